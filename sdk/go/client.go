@@ -0,0 +1,1742 @@
+// Package bridge is a thin Go client for the WhatsApp bridge REST API (/api/v1).
+// Each method maps to one operation in whatsapp-bridge/openapi.yaml.
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls a bridge instance
+type Client struct {
+	// BaseURL is the bridge address with any BASE_PATH, e.g. http://localhost:8080
+	BaseURL string
+	// APIKey, if set, is sent in the X-API-Key header
+	APIKey string
+	// Timezone, if set, is sent as ?tz= so timestamps come back in that zone
+	Timezone string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the bridge at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// APIError is returned for non-2xx responses
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("bridge API error %d: %s", e.StatusCode, e.Message)
+}
+
+// do performs a request against /api/v1 and returns the response if it succeeded
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.Timezone != "" {
+		query.Set("tz", c.Timezone)
+	}
+
+	endpoint := c.BaseURL + "/api/v1" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send and download report failures with a JSON body that is still worth decoding
+	if resp.StatusCode >= 300 && !(resp.StatusCode == http.StatusInternalServerError && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")) {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return resp, nil
+}
+
+// doJSON sends an optional JSON body and decodes the JSON response into out
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(ctx, method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SendMessage sends a text or media message
+func (c *Client) SendMessage(ctx context.Context, req SendMessageRequest) (*SendMessageResponse, error) {
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/send", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendMessageAndWait sends a message and blocks until it reaches waitFor (server_ack or delivered)
+// or timeout passes, in which case TimedOut is set. The HTTP client's timeout must be longer.
+func (c *Client) SendMessageAndWait(ctx context.Context, req SendMessageRequest, waitFor string, timeout time.Duration) (*SendMessageResponse, error) {
+	query := url.Values{"wait_for": {waitFor}}
+	if timeout > 0 {
+		query.Set("timeout", strconv.Itoa(int(timeout.Seconds())))
+	}
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/send", query, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RequestPayment sends a WhatsApp payment request; the bridge must run with PAYMENTS_ENABLED
+func (c *Client) RequestPayment(ctx context.Context, req PaymentRequest) (*SendMessageResponse, error) {
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/payments/request", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ScheduleMessage schedules a message for a fixed time or a time on the recipient's clock
+func (c *Client) ScheduleMessage(ctx context.Context, req ScheduleRequest) (*ScheduledMessage, error) {
+	var out ScheduledMessage
+	if err := c.doJSON(ctx, http.MethodPost, "/scheduled", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListScheduledMessages lists scheduled messages by send time, optionally of one status or campaign
+func (c *Client) ListScheduledMessages(ctx context.Context, status, campaignID string, limit int) ([]ScheduledMessage, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if campaignID != "" {
+		query.Set("campaign_id", campaignID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []ScheduledMessage
+	if err := c.doJSON(ctx, http.MethodGet, "/scheduled", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetScheduledMessage returns a scheduled message and the outcome of its send
+func (c *Client) GetScheduledMessage(ctx context.Context, id string) (*ScheduledMessage, error) {
+	var out ScheduledMessage
+	if err := c.doJSON(ctx, http.MethodGet, "/scheduled/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelScheduledMessage cancels a pending scheduled message
+func (c *Client) CancelScheduledMessage(ctx context.Context, id string) (*ScheduledMessage, error) {
+	var out ScheduledMessage
+	if err := c.doJSON(ctx, http.MethodDelete, "/scheduled/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateCampaign schedules a message for many recipients
+func (c *Client) CreateCampaign(ctx context.Context, req CampaignRequest) (*Campaign, error) {
+	var out Campaign
+	if err := c.doJSON(ctx, http.MethodPost, "/campaigns", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListCampaigns lists campaigns, newest first
+func (c *Client) ListCampaigns(ctx context.Context) ([]Campaign, error) {
+	return c.ListCampaignsByApproval(ctx, "")
+}
+
+// ListCampaignsByApproval lists campaigns in one approval state, such as "pending", newest first
+func (c *Client) ListCampaignsByApproval(ctx context.Context, approval string) ([]Campaign, error) {
+	query := url.Values{}
+	if approval != "" {
+		query.Set("approval", approval)
+	}
+	var out []Campaign
+	if err := c.doJSON(ctx, http.MethodGet, "/campaigns", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetCampaign returns a campaign with the number of its messages in each status
+func (c *Client) GetCampaign(ctx context.Context, id string) (*Campaign, error) {
+	var out Campaign
+	if err := c.doJSON(ctx, http.MethodGet, "/campaigns/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CancelCampaign cancels the pending messages of a campaign
+func (c *Client) CancelCampaign(ctx context.Context, id string) (*Campaign, error) {
+	var out Campaign
+	if err := c.doJSON(ctx, http.MethodDelete, "/campaigns/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PreviewCampaign renders the message of a campaign's first recipients; samples of 0 means the default of 5
+func (c *Client) PreviewCampaign(ctx context.Context, id string, samples int) (*CampaignPreview, error) {
+	query := url.Values{}
+	if samples > 0 {
+		query.Set("samples", strconv.Itoa(samples))
+	}
+	var out CampaignPreview
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/campaigns/"+url.PathEscape(id)+"/preview", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ApproveCampaign releases the messages of a campaign waiting for approval; reviewer defaults to the API key
+func (c *Client) ApproveCampaign(ctx context.Context, id, reviewer, note string) (*Campaign, error) {
+	return c.reviewCampaign(ctx, id, "approve", reviewer, note)
+}
+
+// RejectCampaign cancels the messages of a campaign waiting for approval
+func (c *Client) RejectCampaign(ctx context.Context, id, reviewer, note string) (*Campaign, error) {
+	return c.reviewCampaign(ctx, id, "reject", reviewer, note)
+}
+
+func (c *Client) reviewCampaign(ctx context.Context, id, action, reviewer, note string) (*Campaign, error) {
+	body := map[string]string{"reviewer": reviewer, "note": note}
+	var out Campaign
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/campaigns/"+url.PathEscape(id)+"/"+action, nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateRecurring starts a series sending a message every time its cron expression matches
+func (c *Client) CreateRecurring(ctx context.Context, req RecurringRequest) (*RecurringSeries, error) {
+	var out RecurringSeries
+	if err := c.doJSON(ctx, http.MethodPost, "/recurring", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListRecurring lists recurring series, newest first, optionally of one status
+func (c *Client) ListRecurring(ctx context.Context, status string) ([]RecurringSeries, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	var out []RecurringSeries
+	if err := c.doJSON(ctx, http.MethodGet, "/recurring", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetRecurring returns a recurring series with its upcoming occurrences
+func (c *Client) GetRecurring(ctx context.Context, id string) (*RecurringSeries, error) {
+	return c.recurring(ctx, http.MethodGet, id, "", nil)
+}
+
+// UpdateRecurring edits a series; changes apply from its next occurrence
+func (c *Client) UpdateRecurring(ctx context.Context, id string, update RecurringUpdate) (*RecurringSeries, error) {
+	return c.recurring(ctx, http.MethodPatch, id, "", update)
+}
+
+// PauseRecurring stops a series until it's resumed
+func (c *Client) PauseRecurring(ctx context.Context, id string) (*RecurringSeries, error) {
+	return c.recurring(ctx, http.MethodPost, id, "/pause", nil)
+}
+
+// ResumeRecurring restarts a paused series from its next occurrence
+func (c *Client) ResumeRecurring(ctx context.Context, id string) (*RecurringSeries, error) {
+	return c.recurring(ctx, http.MethodPost, id, "/resume", nil)
+}
+
+// CancelRecurring cancels a series
+func (c *Client) CancelRecurring(ctx context.Context, id string) (*RecurringSeries, error) {
+	return c.recurring(ctx, http.MethodDelete, id, "", nil)
+}
+
+func (c *Client) recurring(ctx context.Context, method, id, action string, body interface{}) (*RecurringSeries, error) {
+	var out RecurringSeries
+	if err := c.doJSON(ctx, method, "/recurring/"+url.PathEscape(id)+action, nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListRecurringOccurrences lists the scheduled messages a series has queued, by send time
+func (c *Client) ListRecurringOccurrences(ctx context.Context, id string, limit int) ([]ScheduledMessage, error) {
+	query := url.Values{"series_id": {id}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []ScheduledMessage
+	if err := c.doJSON(ctx, http.MethodGet, "/scheduled", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateTemplate stores a message template
+func (c *Client) CreateTemplate(ctx context.Context, template MessageTemplate) (*MessageTemplate, error) {
+	var out MessageTemplate
+	if err := c.doJSON(ctx, http.MethodPost, "/templates", nil, template, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListTemplates lists message templates by name
+func (c *Client) ListTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	var out []MessageTemplate
+	if err := c.doJSON(ctx, http.MethodGet, "/templates", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetTemplate returns a message template
+func (c *Client) GetTemplate(ctx context.Context, id string) (*MessageTemplate, error) {
+	var out MessageTemplate
+	if err := c.doJSON(ctx, http.MethodGet, "/templates/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateTemplate replaces the name, body, samples and language variants of a template
+func (c *Client) UpdateTemplate(ctx context.Context, id string, template MessageTemplate) (*MessageTemplate, error) {
+	var out MessageTemplate
+	if err := c.doJSON(ctx, http.MethodPut, "/templates/"+url.PathEscape(id), nil, template, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteTemplate deletes a message template
+func (c *Client) DeleteTemplate(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/templates/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// PreviewTemplate renders a template with variables over its samples, and lints the result
+func (c *Client) PreviewTemplate(ctx context.Context, id string, req TemplatePreviewRequest) (*TemplatePreview, error) {
+	var out TemplatePreview
+	if err := c.doJSON(ctx, http.MethodPost, "/templates/"+url.PathEscape(id)+"/preview", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// React reacts to a stored message; an empty emoji removes the reaction
+func (c *Client) React(ctx context.Context, req ReactRequest) (*SendMessageResponse, error) {
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/react", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendVoice sends an Opus recording as a voice note
+func (c *Client) SendVoice(ctx context.Context, req SendVoiceRequest, audio io.Reader) (*SendMessageResponse, error) {
+	query := url.Values{"recipient": {req.Recipient}}
+	if req.ClientRef != "" {
+		query.Set("client_ref", req.ClientRef)
+	}
+	if req.Agent != "" {
+		query.Set("agent", req.Agent)
+	}
+	if req.QuotedMessageID != "" {
+		query.Set("quoted_message_id", req.QuotedMessageID)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", req.ContentType)
+
+	resp, err := c.do(ctx, http.MethodPost, "/send/voice", query, audio, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out SendMessageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendMedia uploads a file and sends it as an image, video, audio or document message, depending on its
+// content. The file is streamed, so it can be as large as WhatsApp allows.
+func (c *Client) SendMedia(ctx context.Context, req SendMediaRequest, media io.Reader) (*SendMessageResponse, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		fields := map[string]string{"recipient": req.Recipient, "message": req.Message, "client_ref": req.ClientRef, "agent": req.Agent,
+			"quoted_message_id": req.QuotedMessageID}
+		if req.Signature != nil {
+			fields["signature"] = strconv.FormatBool(*req.Signature)
+		}
+		for name, value := range fields {
+			if value != "" {
+				if err := form.WriteField(name, value); err != nil {
+					writer.CloseWithError(err)
+					return
+				}
+			}
+		}
+		part, err := form.CreateFormFile("file", req.Filename)
+		if err == nil {
+			_, err = io.Copy(part, media)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	header := http.Header{}
+	header.Set("Content-Type", form.FormDataContentType())
+	resp, err := c.do(ctx, http.MethodPost, "/send/media", nil, body, header)
+	// Unblock the writer if the request ended before reading the whole body
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out SendMessageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DownloadMedia downloads a message's media to the bridge's local store
+func (c *Client) DownloadMedia(ctx context.Context, req DownloadMediaRequest) (*DownloadMediaResponse, error) {
+	var out DownloadMediaResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/download", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetMedia streams a message's media. The caller must close the returned body.
+func (c *Client) GetMedia(ctx context.Context, messageID, chatJID string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/media/"+url.PathEscape(messageID), url.Values{"chat_jid": {chatJID}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetMediaURL returns a short-lived signed URL of a message's media, which needs no credentials.
+// The bridge must keep media in Supabase Storage.
+func (c *Client) GetMediaURL(ctx context.Context, messageID, chatJID string) (*MediaURL, error) {
+	var out MediaURL
+	query := url.Values{"chat_jid": {chatJID}, "redirect": {"false"}}
+	if err := c.doJSON(ctx, http.MethodGet, "/media/"+url.PathEscape(messageID), query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListChats lists chats, most recently active first
+func (c *Client) ListChats(ctx context.Context) ([]Chat, error) {
+	var out []Chat
+	if err := c.doJSON(ctx, http.MethodGet, "/chats", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListChatsWithLabel lists the chats that have a label, most recently active first
+func (c *Client) ListChatsWithLabel(ctx context.Context, label string) ([]Chat, error) {
+	var out []Chat
+	if err := c.doJSON(ctx, http.MethodGet, "/chats", url.Values{"label": {label}}, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListMessages lists the most recent messages of a chat, newest first. A limit of 0 uses the server default.
+func (c *Client) ListMessages(ctx context.Context, chatJID string, limit int) ([]Message, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []Message
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/messages", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SearchMessages finds messages whose text or file name matches query, newest first. An empty
+// chatJID searches every chat; a limit of 0 uses the server default.
+func (c *Client) SearchMessages(ctx context.Context, query, chatJID string, limit int) ([]Message, error) {
+	return c.Search(ctx, query, SearchOptions{ChatJID: chatJID, Limit: limit})
+}
+
+// Search finds messages whose text or file name matches query, newest first, narrowed by the
+// options. Page through results by raising Offset until a page is shorter than Limit.
+func (c *Client) Search(ctx context.Context, query string, opts SearchOptions) ([]Message, error) {
+	params := url.Values{"q": {query}}
+	if opts.ChatJID != "" {
+		params.Set("chat_jid", opts.ChatJID)
+	}
+	if opts.Sender != "" {
+		params.Set("sender", opts.Sender)
+	}
+	if !opts.Since.IsZero() {
+		params.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		params.Set("until", opts.Until.Format(time.RFC3339))
+	}
+	if opts.Type != "" {
+		params.Set("type", opts.Type)
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		params.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	var out []Message
+	if err := c.doJSON(ctx, http.MethodGet, "/search", params, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SemanticSearch finds the messages closest in meaning to query, best match first. It needs
+// embeddings enabled on the bridge; a limit of 0 uses the server default.
+func (c *Client) SemanticSearch(ctx context.Context, query string, opts SemanticSearchOptions) ([]SemanticSearchResult, error) {
+	params := url.Values{"q": {query}}
+	if opts.ChatJID != "" {
+		params.Set("chat_jid", opts.ChatJID)
+	}
+	if !opts.Since.IsZero() {
+		params.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		params.Set("until", opts.Until.Format(time.RFC3339))
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var out []SemanticSearchResult
+	if err := c.doJSON(ctx, http.MethodGet, "/search/semantic", params, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDraft returns the draft for a chat, or nil if there is none
+func (c *Client) GetDraft(ctx context.Context, chatJID string) (*Draft, error) {
+	var out Draft
+	err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/draft", nil, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SaveDraft saves the draft for a chat; empty content clears it
+func (c *Client) SaveDraft(ctx context.Context, chatJID string, req SaveDraftRequest) error {
+	return c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/draft", nil, req, nil)
+}
+
+// DeleteDraft clears the draft for a chat
+func (c *Client) DeleteDraft(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/draft", nil, nil, nil)
+}
+
+// FindMessagesByClientRef returns the messages sent with a client reference, newest first
+func (c *Client) FindMessagesByClientRef(ctx context.Context, clientRef string) ([]Message, error) {
+	var out []Message
+	if err := c.doJSON(ctx, http.MethodGet, "/messages", url.Values{"client_ref": {clientRef}}, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetThread returns the quoted-reply thread around a message. chatJID may be empty
+// unless the message ID occurs in several chats.
+func (c *Client) GetThread(ctx context.Context, messageID, chatJID string) (*MessageThread, error) {
+	query := url.Values{}
+	if chatJID != "" {
+		query.Set("chat_jid", chatJID)
+	}
+	var out MessageThread
+	if err := c.doJSON(ctx, http.MethodGet, "/messages/"+url.PathEscape(messageID)+"/thread", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetMessageStatus returns whether a message is queued, sent or failed, by the OutboxID of a
+// queued send or a WhatsApp message ID. chatJID may be empty unless the message ID occurs in several chats.
+func (c *Client) GetMessageStatus(ctx context.Context, id, chatJID string) (*MessageStatus, error) {
+	query := url.Values{}
+	if chatJID != "" {
+		query.Set("chat_jid", chatJID)
+	}
+	var out MessageStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/messages/"+url.PathEscape(id)+"/status", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetMessageReceipts returns the status of a sent message and every receipt for it. chatJID may be
+// empty unless the message ID occurs in several chats.
+func (c *Client) GetMessageReceipts(ctx context.Context, messageID, chatJID string) (*MessageReceipts, error) {
+	query := url.Values{}
+	if chatJID != "" {
+		query.Set("chat_jid", chatJID)
+	}
+	var out MessageReceipts
+	if err := c.doJSON(ctx, http.MethodGet, "/messages/"+url.PathEscape(messageID)+"/receipts", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAssignment returns the queue a chat is assigned to, or nil if it is not assigned
+func (c *Client) GetAssignment(ctx context.Context, chatJID string) (*ChatAssignment, error) {
+	var out ChatAssignment
+	err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/assignment", nil, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AssignChat assigns a chat to a queue, replacing any existing assignment
+func (c *Client) AssignChat(ctx context.Context, chatJID, queue string) (*ChatAssignment, error) {
+	var out ChatAssignment
+	in := map[string]string{"queue": queue}
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/assignment", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UnassignChat removes a chat from its queue
+func (c *Client) UnassignChat(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/assignment", nil, nil, nil)
+}
+
+// ResolveChat marks a chat's assignment resolved, stopping its SLA resolution clock
+func (c *Client) ResolveChat(ctx context.Context, chatJID string) (*ChatAssignment, error) {
+	var out ChatAssignment
+	if err := c.doJSON(ctx, http.MethodPost, "/chats/"+url.PathEscape(chatJID)+"/resolve", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAssignments lists the chats assigned to a queue, or all assignments if queue is empty
+func (c *Client) ListAssignments(ctx context.Context, queue string) ([]ChatAssignment, error) {
+	return c.listAssignments(ctx, queue, "")
+}
+
+// ListOpenAssignments lists the unresolved chats of a queue, or of all queues if queue is empty
+func (c *Client) ListOpenAssignments(ctx context.Context, queue string) ([]ChatAssignment, error) {
+	return c.listAssignments(ctx, queue, "open")
+}
+
+func (c *Client) listAssignments(ctx context.Context, queue, state string) ([]ChatAssignment, error) {
+	query := url.Values{}
+	if queue != "" {
+		query.Set("queue", queue)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	var out []ChatAssignment
+	if err := c.doJSON(ctx, http.MethodGet, "/assignments", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListHandoffs lists the chats handed to an agent, where the bot is paused, longest waiting first
+func (c *Client) ListHandoffs(ctx context.Context) ([]Handoff, error) {
+	var out []Handoff
+	if err := c.doJSON(ctx, http.MethodGet, "/handoffs", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetHandoff returns the handoff of a chat, or nil if the bot isn't paused there
+func (c *Client) GetHandoff(ctx context.Context, chatJID string) (*Handoff, error) {
+	var out Handoff
+	err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/handoff", nil, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ResumeHandoff gives a handed-over chat back to the bot
+func (c *Client) ResumeHandoff(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/handoff", nil, nil, nil)
+}
+
+// GetBotStatus reports whether the bot is paused in a chat
+func (c *Client) GetBotStatus(ctx context.Context, chatJID string) (*BotStatus, error) {
+	var out BotStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/bot", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PauseBot stops flows, routing rules and classification in a chat so an agent can take over.
+// A non-empty resumeAfter, such as "30m", "8h" or "7d", lifts the pause by itself.
+func (c *Client) PauseBot(ctx context.Context, chatJID, resumeAfter string) (*BotStatus, error) {
+	return c.setBot(ctx, chatJID, map[string]interface{}{"paused": true, "resume_after": resumeAfter})
+}
+
+// ResumeBot lets the bot act on a paused chat again
+func (c *Client) ResumeBot(ctx context.Context, chatJID string) (*BotStatus, error) {
+	return c.setBot(ctx, chatJID, map[string]interface{}{"paused": false})
+}
+
+func (c *Client) setBot(ctx context.Context, chatJID string, in map[string]interface{}) (*BotStatus, error) {
+	var out BotStatus
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/bot", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListFlows lists the conversation flows loaded by the bridge
+func (c *Client) ListFlows(ctx context.Context) ([]Flow, error) {
+	var out []Flow
+	if err := c.doJSON(ctx, http.MethodGet, "/flows", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetFlowSession returns the flow a chat is in, or nil if it is not in one
+func (c *Client) GetFlowSession(ctx context.Context, chatJID string) (*FlowSession, error) {
+	var out *FlowSession
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/flow", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StartFlow puts a chat into a flow and sends its first step.
+// The session is nil if the flow finished without waiting for an answer.
+func (c *Client) StartFlow(ctx context.Context, chatJID string, req StartFlowRequest) (*FlowSession, error) {
+	var out *FlowSession
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/flow", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdvanceFlow answers the current step of a chat's flow on the contact's behalf.
+// The session is nil once the flow has finished.
+func (c *Client) AdvanceFlow(ctx context.Context, chatJID, input string) (*FlowSession, error) {
+	var out *FlowSession
+	in := map[string]string{"input": input}
+	if err := c.doJSON(ctx, http.MethodPost, "/chats/"+url.PathEscape(chatJID)+"/flow", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CancelFlow takes a chat out of its flow without sending anything
+func (c *Client) CancelFlow(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/flow", nil, nil, nil)
+}
+
+// PairPhone requests a code that links the bridge to the WhatsApp account of phone, a number with
+// its country code. It only works while the bridge shows QR codes.
+func (c *Client) PairPhone(ctx context.Context, phone string) (*PairingCode, error) {
+	var out PairingCode
+	if err := c.doJSON(ctx, http.MethodPost, "/pair/phone", nil, map[string]string{"phone": phone}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPairingHistory lists the most recent attempts to link the account, newest first
+func (c *Client) GetPairingHistory(ctx context.Context, limit int) ([]PairingAttempt, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []PairingAttempt
+	if err := c.doJSON(ctx, http.MethodGet, "/pairing/history", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSessionLock reports whether sending is locked after a possible session takeover
+func (c *Client) GetSessionLock(ctx context.Context) (*SessionStatus, error) {
+	var out SessionStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/session/lock", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AcknowledgeSessionLock lifts the lock so the bridge can send, reconnect and pair again
+func (c *Client) AcknowledgeSessionLock(ctx context.Context, acknowledgedBy string) (*SessionLock, error) {
+	var out SessionLock
+	in := map[string]string{"acknowledged_by": acknowledgedBy}
+	if err := c.doJSON(ctx, http.MethodPost, "/session/lock/acknowledge", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAuditExports returns the days exported to the audit bucket, newest first; limit 0 uses the default
+func (c *Client) ListAuditExports(ctx context.Context, limit int) ([]AuditExport, error) {
+	var out []AuditExport
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/audit-exports", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListDashboardLogins returns sign-ins to the dashboard, newest first, of one user if email is
+// set; limit 0 uses the default
+func (c *Client) ListDashboardLogins(ctx context.Context, email string, limit int) ([]DashboardLogin, error) {
+	var out []DashboardLogin
+	query := url.Values{}
+	if email != "" {
+		query.Set("email", email)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/logins", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListDashboardUsers returns the local dashboard users, by email
+func (c *Client) ListDashboardUsers(ctx context.Context) ([]DashboardUser, error) {
+	var out []DashboardUser
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/users", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateDashboardUser adds a local dashboard user; without a password they sign in with a passkey
+func (c *Client) CreateDashboardUser(ctx context.Context, req DashboardUserRequest) (*DashboardUser, error) {
+	var out DashboardUser
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/users", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateDashboardUser sets a user's password or admin flag; a new password signs them out everywhere
+func (c *Client) UpdateDashboardUser(ctx context.Context, id string, req DashboardUserRequest) (*DashboardUser, error) {
+	var out DashboardUser
+	if err := c.doJSON(ctx, http.MethodPatch, "/admin/users/"+url.PathEscape(id), nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteDashboardUser removes a user with their passkeys and sessions
+func (c *Client) DeleteDashboardUser(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/users/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ListTenants returns the tenants with their status and usage this month
+func (c *Client) ListTenants(ctx context.Context) ([]TenantStatus, error) {
+	var out []TenantStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/tenants", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateTenant creates a tenant from its ID, name, key IDs and users
+func (c *Client) CreateTenant(ctx context.Context, tenant Tenant) (*Tenant, error) {
+	var out Tenant
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/tenants", nil, tenant, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateTenant replaces the name, key IDs and users of a tenant
+func (c *Client) UpdateTenant(ctx context.Context, tenant Tenant) (*TenantStatus, error) {
+	var out TenantStatus
+	if err := c.doJSON(ctx, http.MethodPut, "/admin/tenants/"+url.PathEscape(tenant.ID), nil, tenant, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteTenant deletes a tenant; its keys keep working, unassigned
+func (c *Client) DeleteTenant(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/tenants/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// SuspendTenant refuses API requests made with the tenant's keys
+func (c *Client) SuspendTenant(ctx context.Context, id, reason string) (*TenantStatus, error) {
+	var out TenantStatus
+	in := map[string]string{"reason": reason}
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/tenants/"+url.PathEscape(id)+"/suspend", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ResumeTenant lifts a tenant's suspension
+func (c *Client) ResumeTenant(ctx context.Context, id string) (*TenantStatus, error) {
+	var out TenantStatus
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/tenants/"+url.PathEscape(id)+"/resume", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAccounts lists the additional WhatsApp accounts linked to the bridge
+func (c *Client) ListAccounts(ctx context.Context) ([]AccountStatus, error) {
+	var out []AccountStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/accounts", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateAccount adds an account; scan the QR code at its QRURL to link it
+func (c *Client) CreateAccount(ctx context.Context, id, name string) (*AccountStatus, error) {
+	var out AccountStatus
+	in := map[string]string{"id": id, "name": name}
+	if err := c.doJSON(ctx, http.MethodPost, "/accounts", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAccount returns an account and its connection state
+func (c *Client) GetAccount(ctx context.Context, id string) (*AccountStatus, error) {
+	var out AccountStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/accounts/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteAccount unlinks an account's device and removes the account
+func (c *Client) DeleteAccount(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/accounts/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// PairAccountPhone requests a code that links an account to the WhatsApp account of phone, while
+// the account shows QR codes
+func (c *Client) PairAccountPhone(ctx context.Context, account, phone string) (*PairingCode, error) {
+	var out PairingCode
+	if err := c.doJSON(ctx, http.MethodPost, "/accounts/"+url.PathEscape(account)+"/pair-phone", nil, map[string]string{"phone": phone}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendAccountMessage sends a text or media message from an account
+func (c *Client) SendAccountMessage(ctx context.Context, account string, req SendMessageRequest) (*SendMessageResponse, error) {
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/accounts/"+url.PathEscape(account)+"/send", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAccountChats lists an account's chats, most recently active first
+func (c *Client) ListAccountChats(ctx context.Context, account string) ([]Chat, error) {
+	var out []Chat
+	if err := c.doJSON(ctx, http.MethodGet, "/accounts/"+url.PathEscape(account)+"/chats", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAccountMessages lists the most recent messages of one of an account's chats, newest first.
+// A limit of 0 uses the server default.
+func (c *Client) ListAccountMessages(ctx context.Context, account, chatJID string, limit int) ([]Message, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []Message
+	path := "/accounts/" + url.PathEscape(account) + "/chats/" + url.PathEscape(chatJID) + "/messages"
+	if err := c.doJSON(ctx, http.MethodGet, path, query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScanOrphanMedia lists downloaded media no stored message refers to, and removes it if remove is set
+func (c *Client) ScanOrphanMedia(ctx context.Context, remove bool) (*MediaGCReport, error) {
+	method := http.MethodGet
+	if remove {
+		method = http.MethodPost
+	}
+	var out MediaGCReport
+	if err := c.doJSON(ctx, method, "/admin/media/gc", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetMaintenance reports whether sends and event processing are paused
+func (c *Client) GetMaintenance(ctx context.Context) (*MaintenanceStatus, error) {
+	var out MaintenanceStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/maintenance", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetMaintenance refuses sends and closes the WhatsApp connection, or resumes and reconnects
+func (c *Client) SetMaintenance(ctx context.Context, enabled bool, reason string) (*MaintenanceStatus, error) {
+	var out MaintenanceStatus
+	in := map[string]interface{}{"enabled": enabled, "reason": reason}
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/maintenance", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetLogLevel returns the bridge's current log level
+func (c *Client) GetLogLevel(ctx context.Context) (*LogLevel, error) {
+	var out LogLevel
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/loglevel", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetLogLevel changes the log level (debug, info, warn or error) for duration, after which it
+// falls back to LOG_LEVEL. A zero duration uses the bridge's LOG_LEVEL_TIMEOUT_MINUTES.
+func (c *Client) SetLogLevel(ctx context.Context, level string, duration time.Duration) (*LogLevel, error) {
+	var out LogLevel
+	in := map[string]interface{}{"level": level}
+	if duration > 0 {
+		in["duration_seconds"] = int(duration.Seconds())
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/loglevel", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DownloadDiagnostics downloads the redacted diagnostic bundle as a zip, with the last logLines
+// log lines (0 for all that are kept). The caller must close the returned body.
+func (c *Client) DownloadDiagnostics(ctx context.Context, logLines int) (io.ReadCloser, error) {
+	query := url.Values{}
+	if logLines > 0 {
+		query.Set("log_lines", strconv.Itoa(logLines))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/admin/diagnostics", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetUsage returns the usage of the client's API key in a month (YYYY-MM, empty for the current one)
+func (c *Client) GetUsage(ctx context.Context, period string) (*UsageReport, error) {
+	var out UsageReport
+	query := url.Values{}
+	if period != "" {
+		query.Set("period", period)
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/usage", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAPIKeys lists the issued API keys, newest first; it needs an admin key
+func (c *Client) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var out []APIKey
+	if err := c.doJSON(ctx, http.MethodGet, "/keys", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateAPIKey issues an API key with scopes (read, send, write or admin). The returned Key is
+// the only time the key is shown.
+func (c *Client) CreateAPIKey(ctx context.Context, name string, scopes []string) (*APIKey, error) {
+	var out APIKey
+	body := map[string]interface{}{"name": name, "scopes": scopes}
+	if err := c.doJSON(ctx, http.MethodPost, "/keys", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeAPIKey revokes the API key with this key_ ID
+func (c *Client) RevokeAPIKey(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/keys/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// GetMetadata returns the notes and metadata of a chat or contact
+func (c *Client) GetMetadata(ctx context.Context, chatJID string) (*ChatMetadata, error) {
+	var out ChatMetadata
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/metadata", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReplaceMetadata replaces the notes and all metadata of a chat or contact
+func (c *Client) ReplaceMetadata(ctx context.Context, chatJID string, metadata ChatMetadata) (*ChatMetadata, error) {
+	var out ChatMetadata
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/metadata", nil, metadata, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateMetadata changes the notes or some metadata keys of a chat or contact
+func (c *Client) UpdateMetadata(ctx context.Context, chatJID string, req UpdateMetadataRequest) (*ChatMetadata, error) {
+	var out ChatMetadata
+	if err := c.doJSON(ctx, http.MethodPatch, "/chats/"+url.PathEscape(chatJID)+"/metadata", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteMetadata removes all notes and metadata of a chat or contact
+func (c *Client) DeleteMetadata(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/metadata", nil, nil, nil)
+}
+
+// FindByMetadata returns the JIDs where a metadata key has the given value
+func (c *Client) FindByMetadata(ctx context.Context, key, value string) ([]string, error) {
+	var out struct {
+		JIDs []string `json:"jids"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/metadata", url.Values{"key": {key}, "value": {value}}, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.JIDs, nil
+}
+
+// GetContactAttributes returns the attributes of a contact, given as a phone number or JID
+func (c *Client) GetContactAttributes(ctx context.Context, contact string) (*ContactAttributes, error) {
+	return c.contactAttributes(ctx, http.MethodGet, contact, nil)
+}
+
+// ReplaceContactAttributes replaces all attributes of a contact
+func (c *Client) ReplaceContactAttributes(ctx context.Context, contact string, attributes ContactAttributes) (*ContactAttributes, error) {
+	return c.contactAttributes(ctx, http.MethodPut, contact, attributes)
+}
+
+// UpdateContactAttributes changes some attributes of a contact
+func (c *Client) UpdateContactAttributes(ctx context.Context, contact string, update ContactAttributesUpdate) (*ContactAttributes, error) {
+	return c.contactAttributes(ctx, http.MethodPatch, contact, update)
+}
+
+// DeleteContactAttributes removes all attributes of a contact
+func (c *Client) DeleteContactAttributes(ctx context.Context, contact string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/contacts/"+url.PathEscape(contact)+"/attributes", nil, nil, nil)
+}
+
+func (c *Client) contactAttributes(ctx context.Context, method, contact string, body interface{}) (*ContactAttributes, error) {
+	var out ContactAttributes
+	if err := c.doJSON(ctx, method, "/contacts/"+url.PathEscape(contact)+"/attributes", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListContactAttributes lists contacts with attributes by JID, optionally of one language; a limit
+// of 0 means the default of 100
+func (c *Client) ListContactAttributes(ctx context.Context, language string, limit, offset int) ([]ContactAttributes, error) {
+	query := url.Values{}
+	if language != "" {
+		query.Set("language", language)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	var out []ContactAttributes
+	if err := c.doJSON(ctx, http.MethodGet, "/contacts/attributes", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ImportContactAttributes merges the attributes of up to 10,000 contacts into theirs, or with
+// replace replaces them
+func (c *Client) ImportContactAttributes(ctx context.Context, rows []ContactImportRow, replace bool) (*ContactImportResult, error) {
+	query := url.Values{}
+	if replace {
+		query.Set("replace", "true")
+	}
+	var out ContactImportResult
+	if err := c.doJSON(ctx, http.MethodPost, "/contacts/attributes/import", query, rows, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ImportContactAttributesCSV imports a CSV with a header row and a phone, jid or recipient column;
+// name and language columns set those attributes and any other column is a field
+func (c *Client) ImportContactAttributesCSV(ctx context.Context, csv io.Reader, replace bool) (*ContactImportResult, error) {
+	query := url.Values{}
+	if replace {
+		query.Set("replace", "true")
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/csv")
+	resp, err := c.do(ctx, http.MethodPost, "/contacts/attributes/import", query, csv, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ContactImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetLabels returns the labels of a chat or contact
+func (c *Client) GetLabels(ctx context.Context, chatJID string) ([]string, error) {
+	var out struct {
+		Labels []string `json:"labels"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/labels", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Labels, nil
+}
+
+// SetLabels replaces the labels of a chat or contact and returns them as saved
+func (c *Client) SetLabels(ctx context.Context, chatJID string, labels []string) ([]string, error) {
+	var out struct {
+		Labels []string `json:"labels"`
+	}
+	body := map[string][]string{"labels": labels}
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/labels", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return out.Labels, nil
+}
+
+// GetAvatar returns the profile picture of a contact or group, or nil if there is none
+func (c *Client) GetAvatar(ctx context.Context, chatJID string) (*Avatar, error) {
+	var out Avatar
+	err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/avatar", nil, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetPresence subscribes to a contact's presence and returns their last known status
+func (c *Client) GetPresence(ctx context.Context, contactJID string) (*Presence, error) {
+	var out Presence
+	if err := c.doJSON(ctx, http.MethodGet, "/contacts/"+url.PathEscape(contactJID)+"/presence", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetStoredPresence returns a contact's last known status and last-seen time without subscribing
+func (c *Client) GetStoredPresence(ctx context.Context, contactJID string) (*Presence, error) {
+	var out Presence
+	if err := c.doJSON(ctx, http.MethodGet, "/presence/"+url.PathEscape(contactJID), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SubscribePresence subscribes to a contact's presence, renewed on every connect until removed
+func (c *Client) SubscribePresence(ctx context.Context, contactJID string) (*Presence, error) {
+	var out Presence
+	if err := c.doJSON(ctx, http.MethodPost, "/presence/"+url.PathEscape(contactJID)+"/subscribe", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UnsubscribePresence stops renewing a contact's presence subscription
+func (c *Client) UnsubscribePresence(ctx context.Context, contactJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/presence/"+url.PathEscape(contactJID)+"/subscribe", nil, nil, nil)
+}
+
+// GetOwnPresence returns whether the account is online or offline
+func (c *Client) GetOwnPresence(ctx context.Context) (*OwnPresence, error) {
+	var out OwnPresence
+	if err := c.doJSON(ctx, http.MethodGet, "/presence", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetOwnPresence marks the account online or offline
+func (c *Client) SetOwnPresence(ctx context.Context, status string) (*OwnPresence, error) {
+	var out OwnPresence
+	if err := c.doJSON(ctx, http.MethodPost, "/presence", nil, OwnPresence{Status: status}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SendChatState shows the typing (composing) or recording indicator in a chat, or clears it (paused)
+func (c *Client) SendChatState(ctx context.Context, chatJID, state string) error {
+	body := map[string]string{"state": state}
+	return c.doJSON(ctx, http.MethodPost, "/chats/"+url.PathEscape(chatJID)+"/typing", nil, body, nil)
+}
+
+// GetContactOverview returns what the bridge knows about a contact, with days of daily message volume (0 for the default)
+func (c *Client) GetContactOverview(ctx context.Context, contactJID string, days int) (*ContactOverview, error) {
+	query := url.Values{}
+	if days > 0 {
+		query.Set("days", strconv.Itoa(days))
+	}
+	var out ContactOverview
+	if err := c.doJSON(ctx, http.MethodGet, "/contacts/"+url.PathEscape(contactJID)+"/overview", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ExportChat renders a chat transcript as PDF. The caller must close the returned body.
+func (c *Client) ExportChat(ctx context.Context, chatJID string, opts ExportOptions) (io.ReadCloser, error) {
+	query := url.Values{"format": {"pdf"}}
+	if !opts.From.IsZero() {
+		query.Set("from", opts.From.Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		query.Set("to", opts.To.Format(time.RFC3339))
+	}
+	if opts.NoThumbnails {
+		query.Set("thumbnails", "false")
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/export", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// CreateUpload starts a resumable upload
+func (c *Client) CreateUpload(ctx context.Context, req CreateUploadRequest) (*Upload, error) {
+	var out Upload
+	if err := c.doJSON(ctx, http.MethodPost, "/uploads", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetUpload returns the progress of an upload
+func (c *Client) GetUpload(ctx context.Context, uploadID string) (*Upload, error) {
+	var out Upload
+	if err := c.doJSON(ctx, http.MethodGet, "/uploads/"+url.PathEscape(uploadID), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AppendUploadChunk sends the next chunk at offset and returns the new offset
+func (c *Client) AppendUploadChunk(ctx context.Context, uploadID string, offset int64, chunk io.Reader) (int64, error) {
+	header := http.Header{}
+	header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	header.Set("Content-Type", "application/offset+octet-stream")
+
+	resp, err := c.do(ctx, http.MethodPatch, "/uploads/"+url.PathEscape(uploadID), nil, chunk, header)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// DeleteUpload cancels an upload
+func (c *Client) DeleteUpload(ctx context.Context, uploadID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/uploads/"+url.PathEscape(uploadID), nil, nil, nil)
+}
+
+// SendUpload sends a completed upload as a media message
+func (c *Client) SendUpload(ctx context.Context, uploadID string, req SendUploadRequest) (*SendMessageResponse, error) {
+	var out SendMessageResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/uploads/"+url.PathEscape(uploadID)+"/send", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// EraseContact deletes all data the bridge holds for a person
+func (c *Client) EraseContact(ctx context.Context, req EraseRequest) (*ErasureReport, error) {
+	var out ErasureReport
+	if err := c.doJSON(ctx, http.MethodPost, "/gdpr/erase", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetLegalHold returns the legal hold of a chat, or nil if it is not on hold
+func (c *Client) GetLegalHold(ctx context.Context, chatJID string) (*LegalHold, error) {
+	var out LegalHold
+	err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/hold", nil, nil, &out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PlaceLegalHold puts a chat on legal hold, or updates the reason of an existing hold
+func (c *Client) PlaceLegalHold(ctx context.Context, chatJID, reason, placedBy string) (*LegalHold, error) {
+	var out LegalHold
+	in := map[string]string{"reason": reason, "placed_by": placedBy}
+	if err := c.doJSON(ctx, http.MethodPut, "/chats/"+url.PathEscape(chatJID)+"/hold", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReleaseLegalHold takes a chat off legal hold
+func (c *Client) ReleaseLegalHold(ctx context.Context, chatJID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/chats/"+url.PathEscape(chatJID)+"/hold", nil, nil, nil)
+}
+
+// ListLegalHolds lists all chats on legal hold
+func (c *Client) ListLegalHolds(ctx context.Context) ([]LegalHold, error) {
+	var out []LegalHold
+	if err := c.doJSON(ctx, http.MethodGet, "/legal-holds", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetLegalHoldAudit returns the newest legal hold audit entries, of one chat or of all chats if chatJID is empty
+func (c *Client) GetLegalHoldAudit(ctx context.Context, chatJID string, limit int) ([]HoldAuditEntry, error) {
+	query := url.Values{}
+	if chatJID != "" {
+		query.Set("chat_jid", chatJID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var out []HoldAuditEntry
+	if err := c.doJSON(ctx, http.MethodGet, "/legal-holds/audit", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerifyChain recomputes and checks a chat's message hash chain
+func (c *Client) VerifyChain(ctx context.Context, chatJID string) (*ChainVerification, error) {
+	var out ChainVerification
+	if err := c.doJSON(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatJID)+"/chain", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CheckChainHashes compares hashes from an exported transcript with the stored chain
+func (c *Client) CheckChainHashes(ctx context.Context, chatJID string, hashes []ChainCheck) ([]ChainCheckResult, error) {
+	var out []ChainCheckResult
+	in := map[string][]ChainCheck{"hashes": hashes}
+	if err := c.doJSON(ctx, http.MethodPost, "/chats/"+url.PathEscape(chatJID)+"/chain", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListGroups returns the groups the account is in, by name
+func (c *Client) ListGroups(ctx context.Context) ([]Group, error) {
+	var out []Group
+	if err := c.doJSON(ctx, http.MethodGet, "/groups", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetGroupEvents returns a group's membership, subject and settings changes, newest first
+func (c *Client) GetGroupEvents(ctx context.Context, groupJID string, opts GroupEventOptions) ([]GroupEvent, error) {
+	query := url.Values{}
+	if !opts.From.IsZero() {
+		query.Set("from", opts.From.Format(time.RFC3339))
+	}
+	if !opts.To.IsZero() {
+		query.Set("to", opts.To.Format(time.RFC3339))
+	}
+	if len(opts.Types) > 0 {
+		query.Set("type", strings.Join(opts.Types, ","))
+	}
+	if opts.Participant != "" {
+		query.Set("participant", opts.Participant)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var out []GroupEvent
+	if err := c.doJSON(ctx, http.MethodGet, "/groups/"+url.PathEscape(groupJID)+"/events", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListActivity returns logged events of all chats, newest first; page back with Before
+func (c *Client) ListActivity(ctx context.Context, opts ActivityOptions) ([]BridgeEvent, error) {
+	query := url.Values{}
+	if len(opts.Types) > 0 {
+		query.Set("types", strings.Join(opts.Types, ","))
+	}
+	if opts.ChatJID != "" {
+		query.Set("chat_jid", opts.ChatJID)
+	}
+	if !opts.Before.IsZero() {
+		query.Set("before", opts.Before.Format(time.RFC3339Nano))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var out []BridgeEvent
+	if err := c.doJSON(ctx, http.MethodGet, "/activity", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// analyticsQuery turns analytics filters into query parameters
+func analyticsQuery(opts AnalyticsOptions, format string) url.Values {
+	query := url.Values{"format": {format}}
+	for name, value := range map[string]string{"from": opts.From, "to": opts.To, "chat_jid": opts.ChatJID, "agent": opts.Agent, "label": opts.Label, "tz": opts.Timezone} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query
+}
+
+// GetMessageAnalytics returns the messages received and sent per day
+func (c *Client) GetMessageAnalytics(ctx context.Context, opts AnalyticsOptions) ([]DailyMessages, error) {
+	var out []DailyMessages
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/messages", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeliveryAnalytics returns the API sends accepted and refused per day, as far back as the event log is kept
+func (c *Client) GetDeliveryAnalytics(ctx context.Context, opts AnalyticsOptions) ([]DailyDelivery, error) {
+	var out []DailyDelivery
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/delivery", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetResponseTimes returns how long each agent took to answer contacts
+func (c *Client) GetResponseTimes(ctx context.Context, opts AnalyticsOptions) ([]AgentResponseTimes, error) {
+	var out []AgentResponseTimes
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/response-times", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetLabelAnalytics returns, per label, the labeled chats with messages in the period and their messages
+func (c *Client) GetLabelAnalytics(ctx context.Context, opts AnalyticsOptions) ([]LabelActivity, error) {
+	var out []LabelActivity
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/labels", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSentimentAnalytics returns the average sentiment of incoming messages per day
+func (c *Client) GetSentimentAnalytics(ctx context.Context, opts AnalyticsOptions) ([]DailySentiment, error) {
+	var out []DailySentiment
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/sentiment", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetChatSentiment returns the sentiment of each chat, the chats whose latest messages are most negative first
+func (c *Client) GetChatSentiment(ctx context.Context, opts AnalyticsOptions) ([]ChatSentiment, error) {
+	var out []ChatSentiment
+	if err := c.doJSON(ctx, http.MethodGet, "/analytics/sentiment-chats", analyticsQuery(opts, "json"), nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportAnalytics downloads a report ("messages", "delivery", "response-times", "sentiment", "sentiment-chats"
+// or "labels") as CSV.
+// The caller must close the returned body.
+func (c *Client) ExportAnalytics(ctx context.Context, report string, opts AnalyticsOptions) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/analytics/"+url.PathEscape(report), analyticsQuery(opts, "csv"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ExportMessages streams every stored message, oldest first, calling fn for each one.
+// An error from fn stops the export and is returned.
+func (c *Client) ExportMessages(ctx context.Context, opts MessageExportOptions, fn func(ExportedMessage) error) error {
+	query := url.Values{"format": {"jsonl"}}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", opts.Until.Format(time.RFC3339))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/export/all", query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg ExportedMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// ListMutes returns the chats muted with the !mute chat command, most recently muted first
+func (c *Client) ListMutes(ctx context.Context) ([]ChatMute, error) {
+	var out []ChatMute
+	if err := c.doJSON(ctx, http.MethodGet, "/mutes", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Health returns the WhatsApp connection status
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var out Health
+	if err := c.doJSON(ctx, http.MethodGet, "/health", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Version returns the version of the bridge and, with UPDATE_CHECK enabled, the latest release
+func (c *Client) Version(ctx context.Context) (*Version, error) {
+	var out Version
+	if err := c.doJSON(ctx, http.MethodGet, "/version", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DatabaseStatus returns the database connection status
+func (c *Client) DatabaseStatus(ctx context.Context) (*DatabaseStatus, error) {
+	var out DatabaseStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/db/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
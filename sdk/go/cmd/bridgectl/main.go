@@ -0,0 +1,413 @@
+// Command bridgectl is a command-line client for the WhatsApp bridge API.
+//
+//	bridgectl [global flags] <command> [flags] [args]
+//
+// Global flags can also be set with BRIDGE_URL, BRIDGE_API_KEY and BRIDGE_TZ.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bridge "github.com/alexechoi/whatsapp-bridge/sdk/go"
+)
+
+const usage = `Usage: bridgectl [global flags] <command> [flags] [args]
+
+Commands:
+  send <recipient> <message>   Send a message (--media to attach a file on the bridge host)
+  schedule <recipient> <msg>   Send a message later (--at time, or --local HH:MM on the recipient's clock)
+  chats                        List chats, most recent first
+  groups                       List the groups the account is in
+  messages <chat_jid>          Show recent messages of a chat
+  search <text>                Find messages containing text
+  export <chat_jid>            Export a chat transcript as PDF
+  status                       Show WhatsApp and database status
+
+Global flags:
+`
+
+// cli holds the global options shared by all commands
+type cli struct {
+	client *bridge.Client
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	global := flag.NewFlagSet("bridgectl", flag.ExitOnError)
+	baseURL := global.String("url", envOr("BRIDGE_URL", "http://localhost:8080"), "bridge address")
+	apiKey := global.String("api-key", os.Getenv("BRIDGE_API_KEY"), "API key sent as X-API-Key")
+	timezone := global.String("tz", os.Getenv("BRIDGE_TZ"), "IANA timezone for displayed timestamps")
+	output := global.String("o", "table", "output format: table or json")
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatalf("invalid output format %q (expected table or json)", *output)
+	}
+
+	client := bridge.NewClient(*baseURL)
+	client.APIKey = *apiKey
+	client.Timezone = *timezone
+	c := &cli{client: client, output: *output, stdout: os.Stdout}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	command, args := global.Arg(0), global.Args()[1:]
+	var err error
+	switch command {
+	case "send":
+		err = c.send(ctx, args)
+	case "schedule":
+		err = c.schedule(ctx, args)
+	case "chats":
+		err = c.chats(ctx, args)
+	case "groups":
+		err = c.groups(ctx, args)
+	case "messages":
+		err = c.messages(ctx, args)
+	case "search":
+		err = c.search(ctx, args)
+	case "export":
+		err = c.export(ctx, args)
+	case "status":
+		err = c.status(ctx, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		global.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func (c *cli) send(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	media := flags.String("media", "", "path of a file on the bridge host to send")
+	ref := flags.String("ref", "", "your own ID for the message (client_ref)")
+	agent := flags.String("agent", "", "name of the person sending, recorded with the message")
+	flags.Parse(args)
+	if flags.NArg() < 1 || (flags.NArg() < 2 && *media == "") {
+		return fmt.Errorf("usage: bridgectl send [--media path] [--ref id] [--agent name] <recipient> <message>")
+	}
+
+	resp, err := c.client.SendMessage(ctx, bridge.SendMessageRequest{
+		Recipient: flags.Arg(0),
+		Message:   strings.Join(flags.Args()[1:], " "),
+		MediaPath: *media,
+		ClientRef: *ref,
+		Agent:     *agent,
+	})
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(resp)
+	}
+	if !resp.Success {
+		return fmt.Errorf("send failed: %s", resp.Message)
+	}
+	fmt.Fprintln(c.stdout, resp.Message, resp.MessageID)
+	return nil
+}
+
+func (c *cli) schedule(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	at := flags.String("at", "", "RFC 3339 time to send at")
+	local := flags.String("local", "", "HH:MM on the recipient's clock to send at")
+	date := flags.String("date", "", "YYYY-MM-DD day of --local (default: its next occurrence)")
+	media := flags.String("media", "", "path of a file on the bridge host to send")
+	flags.Parse(args)
+	if flags.NArg() < 1 || (flags.NArg() < 2 && *media == "") || (*at == "") == (*local == "") {
+		return fmt.Errorf("usage: bridgectl schedule (--at time | --local HH:MM [--date YYYY-MM-DD]) [--media path] <recipient> <message>")
+	}
+
+	req := bridge.ScheduleRequest{
+		Recipient: flags.Arg(0),
+		Message:   strings.Join(flags.Args()[1:], " "),
+		MediaPath: *media,
+		LocalTime: *local,
+		Date:      *date,
+	}
+	if *at != "" {
+		sendAt, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("invalid --at %q: %v", *at, err)
+		}
+		req.SendAt = &sendAt
+	}
+
+	scheduled, err := c.client.ScheduleMessage(ctx, req)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(scheduled)
+	}
+	fmt.Fprintf(c.stdout, "Scheduled %s for %s (%s)\n", scheduled.ID, formatTime(scheduled.SendAt), scheduled.Timezone)
+	return nil
+}
+
+func (c *cli) chats(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("chats", flag.ExitOnError)
+	limit := flags.Int("limit", 0, "maximum number of chats to show (0 for all)")
+	flags.Parse(args)
+
+	chats, err := c.client.ListChats(ctx)
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(chats) > *limit {
+		chats = chats[:*limit]
+	}
+	if c.output == "json" {
+		return c.printJSON(chats)
+	}
+
+	rows := make([][]string, len(chats))
+	for i, chat := range chats {
+		rows[i] = []string{chat.JID, chat.Name, formatTime(chat.LastMessageTime)}
+	}
+	c.printTable([]string{"JID", "NAME", "LAST MESSAGE"}, rows)
+	return nil
+}
+
+func (c *cli) groups(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("groups", flag.ExitOnError)
+	flags.Parse(args)
+
+	groups, err := c.client.ListGroups(ctx)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(groups)
+	}
+
+	rows := make([][]string, len(groups))
+	for i, group := range groups {
+		// Groups where only admins can send are no use to a member
+		canSend := "yes"
+		if group.AdminsOnly && !group.IsAdmin {
+			canSend = "no"
+		}
+		rows[i] = []string{group.JID, group.Name, strconv.Itoa(group.ParticipantCount), canSend}
+	}
+	c.printTable([]string{"JID", "NAME", "PARTICIPANTS", "CAN SEND"}, rows)
+	return nil
+}
+
+func (c *cli) messages(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("messages", flag.ExitOnError)
+	limit := flags.Int("limit", 20, "number of messages to show")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: bridgectl messages [--limit n] <chat_jid>")
+	}
+
+	messages, err := c.client.ListMessages(ctx, flags.Arg(0), *limit)
+	if err != nil {
+		return err
+	}
+	return c.printMessages(messages)
+}
+
+// search scans recent messages client-side for the given text, case-insensitively
+func (c *cli) search(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	chatJID := flags.String("chat", "", "only search this chat")
+	depth := flags.Int("depth", 500, "number of recent messages to scan per chat")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: bridgectl search [--chat jid] [--depth n] <text>")
+	}
+	needle := strings.ToLower(strings.Join(flags.Args(), " "))
+
+	chatJIDs := []string{*chatJID}
+	if *chatJID == "" {
+		chats, err := c.client.ListChats(ctx)
+		if err != nil {
+			return err
+		}
+		chatJIDs = chatJIDs[:0]
+		for _, chat := range chats {
+			chatJIDs = append(chatJIDs, chat.JID)
+		}
+	}
+
+	matches := []bridge.Message{}
+	for _, jid := range chatJIDs {
+		messages, err := c.client.ListMessages(ctx, jid, *depth)
+		if err != nil {
+			return fmt.Errorf("%s: %v", jid, err)
+		}
+		for _, msg := range messages {
+			if strings.Contains(strings.ToLower(msg.Content), needle) || strings.Contains(strings.ToLower(msg.Filename), needle) {
+				matches = append(matches, msg)
+			}
+		}
+	}
+	return c.printMessages(matches)
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "output file (default: chat-<number>.pdf)")
+	from := flags.String("from", "", "start of the period, RFC3339")
+	to := flags.String("to", "", "end of the period, RFC3339")
+	noThumbnails := flags.Bool("no-thumbnails", false, "don't embed image thumbnails")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: bridgectl export [--out file] [--from t] [--to t] [--no-thumbnails] <chat_jid>")
+	}
+	chatJID := flags.Arg(0)
+
+	opts := bridge.ExportOptions{NoThumbnails: *noThumbnails}
+	var err error
+	if *from != "" {
+		if opts.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid --from: %v", err)
+		}
+	}
+	if *to != "" {
+		if opts.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("invalid --to: %v", err)
+		}
+	}
+
+	if *out == "" {
+		*out = "chat-" + strings.Split(chatJID, "@")[0] + ".pdf"
+	}
+
+	body, err := c.client.ExportChat(ctx, chatJID, opts)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(map[string]interface{}{"path": *out, "bytes": size})
+	}
+	fmt.Fprintf(c.stdout, "Wrote %s (%d bytes)\n", *out, size)
+	return nil
+}
+
+func (c *cli) status(ctx context.Context, args []string) error {
+	health, err := c.client.Health(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The database endpoint answers 503 when unhealthy; report that rather than failing
+	db, dbErr := c.client.DatabaseStatus(ctx)
+	if dbErr != nil {
+		db = &bridge.DatabaseStatus{Status: dbErr.Error()}
+	}
+
+	if c.output == "json" {
+		return c.printJSON(map[string]interface{}{"whatsapp": health, "database": db})
+	}
+	c.printTable([]string{"COMPONENT", "OK", "STATUS"}, [][]string{
+		{"whatsapp", fmt.Sprint(health.Connected), health.Message},
+		{"database", fmt.Sprint(db.Healthy), db.Status},
+	})
+	return nil
+}
+
+// printMessages prints messages as a table or JSON
+func (c *cli) printMessages(messages []bridge.Message) error {
+	if c.output == "json" {
+		return c.printJSON(messages)
+	}
+
+	rows := make([][]string, len(messages))
+	for i, msg := range messages {
+		sender := msg.Sender
+		if msg.IsFromMe {
+			sender = "me"
+		}
+		content := msg.Content
+		if msg.MediaType != "" {
+			content = strings.TrimSpace(fmt.Sprintf("[%s: %s] %s", msg.MediaType, msg.Filename, content))
+		}
+		rows[i] = []string{formatTime(msg.Timestamp), msg.ChatJID, sender, truncate(content, 80)}
+	}
+	c.printTable([]string{"TIME", "CHAT", "SENDER", "CONTENT"}, rows)
+	return nil
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *cli) printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// formatTime keeps the zone the server rendered the timestamp in
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// truncate shortens text to a single line of at most n characters
+func truncate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "bridgectl: "+format+"\n", args...)
+	os.Exit(1)
+}
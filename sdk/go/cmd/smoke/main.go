@@ -0,0 +1,123 @@
+// Command smoke exercises the Go SDK against a running bridge.
+//
+//	BRIDGE_URL=http://localhost:8080 BRIDGE_API_KEY=... go run ./cmd/smoke
+//
+// Set SMOKE_RECIPIENT to also send a real message.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	bridge "github.com/alexechoi/whatsapp-bridge/sdk/go"
+)
+
+// smokeChatJID is a chat that never exists, used for write operations that must not touch real data
+const smokeChatJID = "0000000000@s.whatsapp.net"
+
+func main() {
+	baseURL := os.Getenv("BRIDGE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	client := bridge.NewClient(baseURL)
+	client.APIKey = os.Getenv("BRIDGE_API_KEY")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	failures := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failures++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	_, err := client.Health(ctx)
+	check("health", err)
+
+	_, err = client.DatabaseStatus(ctx)
+	check("db status", err)
+
+	chats, err := client.ListChats(ctx)
+	check("list chats", err)
+	if len(chats) > 0 {
+		_, err = client.ListMessages(ctx, chats[0].JID, 5)
+		check("list messages", err)
+	}
+
+	check("draft round trip", func() error {
+		if err := client.SaveDraft(ctx, smokeChatJID, bridge.SaveDraftRequest{Content: "smoke test"}); err != nil {
+			return err
+		}
+		draft, err := client.GetDraft(ctx, smokeChatJID)
+		if err != nil {
+			return err
+		}
+		if draft == nil || draft.Content != "smoke test" {
+			return fmt.Errorf("unexpected draft: %+v", draft)
+		}
+		return client.DeleteDraft(ctx, smokeChatJID)
+	}())
+
+	check("chunked upload", func() error {
+		data := []byte("smoke test upload")
+		upload, err := client.CreateUpload(ctx, bridge.CreateUploadRequest{Filename: "smoke.txt", Size: int64(len(data))})
+		if err != nil {
+			return err
+		}
+		defer client.DeleteUpload(ctx, upload.ID)
+
+		offset, err := client.AppendUploadChunk(ctx, upload.ID, 0, bytes.NewReader(data[:5]))
+		if err != nil {
+			return err
+		}
+		if _, err := client.AppendUploadChunk(ctx, upload.ID, offset, bytes.NewReader(data[5:])); err != nil {
+			return err
+		}
+		status, err := client.GetUpload(ctx, upload.ID)
+		if err != nil {
+			return err
+		}
+		if status.Offset != status.Size {
+			return fmt.Errorf("upload at %d of %d bytes", status.Offset, status.Size)
+		}
+		return nil
+	}())
+
+	check("export", func() error {
+		body, err := client.ExportChat(ctx, smokeChatJID, bridge.ExportOptions{NoThumbnails: true})
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(body, header); err != nil {
+			return err
+		}
+		if string(header) != "%PDF-" {
+			return fmt.Errorf("response is not a PDF")
+		}
+		return nil
+	}())
+
+	if recipient := os.Getenv("SMOKE_RECIPIENT"); recipient != "" {
+		resp, err := client.SendMessage(ctx, bridge.SendMessageRequest{Recipient: recipient, Message: "Bridge SDK smoke test"})
+		if err == nil && !resp.Success {
+			err = fmt.Errorf("%s", resp.Message)
+		}
+		check("send message", err)
+	}
+
+	if failures > 0 {
+		fmt.Printf("%d check(s) failed\n", failures)
+		os.Exit(1)
+	}
+}
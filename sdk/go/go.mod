@@ -0,0 +1,3 @@
+module github.com/alexechoi/whatsapp-bridge/sdk/go
+
+go 1.21
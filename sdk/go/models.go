@@ -0,0 +1,1194 @@
+package bridge
+
+import "time"
+
+// Schemas from whatsapp-bridge/openapi.yaml
+
+// SendMessageRequest is the body of SendMessage
+type SendMessageRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message,omitempty"`
+	MediaPath string `json:"media_path,omitempty"`
+	// ClientRef is your own ID for the message, echoed in status events
+	ClientRef string `json:"client_ref,omitempty"`
+	// Agent is the dashboard user sending the message
+	Agent string `json:"agent,omitempty"`
+	// Signature overrides the bridge's AGENT_SIGNATURE setting when set
+	Signature *bool `json:"signature,omitempty"`
+	// TemplateID sends a stored template filled in with Variables instead of Message
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	// Queue hands the message to the outbox, which sends it once connected and retries failures;
+	// nil uses the bridge's OUTBOX_ENABLED setting
+	Queue *bool `json:"queue,omitempty"`
+	// QuotedMessageID sends the message as a reply to a stored message of the same chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// SendMessageResponse is returned by SendMessage and SendUpload
+type SendMessageResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	// ErrorCode classifies a failed send: recipient_not_on_whatsapp, rate_limited, media_too_large,
+	// not_connected, server_error, invalid_request, media_blocked, sending_disabled,
+	// not_group_member or group_admins_only
+	ErrorCode string `json:"error_code,omitempty"`
+	// Retryable is set on failures and tells whether sending again later can succeed
+	Retryable *bool `json:"retryable,omitempty"`
+	// State is server_ack or delivered when the send waited, see SendMessageAndWait, or queued
+	State    string `json:"state,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	// OutboxID identifies a queued message, see GetMessageStatus
+	OutboxID string `json:"outbox_id,omitempty"`
+}
+
+// MessageReceipts is the status of a sent message with its receipts, returned by GetMessageReceipts
+type MessageReceipts struct {
+	MessageID string     `json:"message_id"`
+	ChatJID   string     `json:"chat_jid"`
+	Status    string     `json:"status"`
+	StatusAt  *time.Time `json:"status_at,omitempty"`
+	// Receipts are oldest first; in groups every member sends their own
+	Receipts []MessageReceipt `json:"receipts"`
+}
+
+// MessageReceipt is a delivered, read or played receipt from one recipient
+type MessageReceipt struct {
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageStatus is whether a queued or sent message went out, returned by GetMessageStatus
+type MessageStatus struct {
+	// ID is the outbox ID of a queued message, else the WhatsApp message ID
+	ID        string `json:"id"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	// Status is queued, sending, sent or failed
+	Status string `json:"status"`
+	// Delivery is the receipt status once sent: sent, delivered, read or played
+	Delivery      string     `json:"delivery,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	ErrorCode     string     `json:"error_code,omitempty"`
+	QueuedAt      *time.Time `json:"queued_at,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// PaymentRequest is the body of RequestPayment
+type PaymentRequest struct {
+	Recipient string  `json:"recipient"`
+	Amount    float64 `json:"amount"`
+	// Currency is an ISO 4217 code such as INR or BRL
+	Currency string `json:"currency"`
+	Note     string `json:"note,omitempty"`
+	// ExpiresInHours defaults to a week
+	ExpiresInHours int    `json:"expires_in_hours,omitempty"`
+	ClientRef      string `json:"client_ref,omitempty"`
+	Agent          string `json:"agent,omitempty"`
+}
+
+// ScheduleRequest is the body of ScheduleMessage. Set either SendAt or LocalTime.
+type ScheduleRequest struct {
+	Recipient string     `json:"recipient"`
+	Message   string     `json:"message,omitempty"`
+	MediaPath string     `json:"media_path,omitempty"`
+	ClientRef string     `json:"client_ref,omitempty"`
+	Agent     string     `json:"agent,omitempty"`
+	SendAt    *time.Time `json:"send_at,omitempty"`
+	// LocalTime is HH:MM in the recipient's timezone
+	LocalTime string `json:"local_time,omitempty"`
+	// Date is the YYYY-MM-DD day of LocalTime; empty means its next occurrence
+	Date string `json:"date,omitempty"`
+}
+
+// CampaignRequest is the body of CreateCampaign. Set either SendAt or LocalTime.
+type CampaignRequest struct {
+	Name       string     `json:"name"`
+	Recipients []string   `json:"recipients"`
+	Message    string     `json:"message,omitempty"`
+	MediaPath  string     `json:"media_path,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	SendAt     *time.Time `json:"send_at,omitempty"`
+	LocalTime  string     `json:"local_time,omitempty"`
+	Date       string     `json:"date,omitempty"`
+	// SpreadMinutes spaces out recipients due at the same moment over that many minutes
+	SpreadMinutes int `json:"spread_minutes,omitempty"`
+	// RequireApproval holds the messages until an admin approves the campaign
+	RequireApproval bool `json:"require_approval,omitempty"`
+	// TemplateID sends a stored template instead of Message, filled in for each recipient from
+	// their contact attributes and Variables
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+}
+
+// ScheduledMessage is a message the bridge sends at SendAt
+type ScheduledMessage struct {
+	ID         string `json:"id"`
+	CampaignID string `json:"campaign_id,omitempty"`
+	// SeriesID is the recurring series the message is an occurrence of
+	SeriesID  string    `json:"series_id,omitempty"`
+	Recipient string    `json:"recipient"`
+	ChatJID   string    `json:"chat_jid"`
+	Message   string    `json:"message,omitempty"`
+	MediaPath string    `json:"media_path,omitempty"`
+	ClientRef string    `json:"client_ref,omitempty"`
+	Agent     string    `json:"agent,omitempty"`
+	SendAt    time.Time `json:"send_at"`
+	// Timezone is the one a LocalTime was resolved in, and TimezoneSource where it came from:
+	// metadata, country_code or default
+	Timezone       string     `json:"timezone"`
+	TimezoneSource string     `json:"timezone_source,omitempty"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	MessageID      string     `json:"message_id,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	ErrorCode      string     `json:"error_code,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+}
+
+// Campaign is a message scheduled for many recipients
+type Campaign struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Counts is the number of its messages in each status
+	Counts      map[string]int `json:"counts"`
+	FirstSendAt *time.Time     `json:"first_send_at,omitempty"`
+	LastSendAt  *time.Time     `json:"last_send_at,omitempty"`
+	// Approval is pending, approved, rejected or withdrawn for a campaign that needed an admin's approval
+	Approval    string     `json:"approval,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+	// TemplateID is the template the messages were filled in from, if any
+	TemplateID string `json:"template_id,omitempty"`
+}
+
+// CampaignPreview is what a campaign will send to its first recipients
+type CampaignPreview struct {
+	Campaign  Campaign                `json:"campaign"`
+	Message   string                  `json:"message,omitempty"`
+	MediaPath string                  `json:"media_path,omitempty"`
+	Samples   []CampaignPreviewSample `json:"samples"`
+}
+
+// CampaignPreviewSample is the message one recipient of a campaign will get. Diff turns the
+// submitted message into Rendered.
+type CampaignPreviewSample struct {
+	Recipient string       `json:"recipient"`
+	ChatJID   string       `json:"chat_jid"`
+	SendAt    time.Time    `json:"send_at"`
+	Timezone  string       `json:"timezone"`
+	Rendered  string       `json:"rendered"`
+	Diff      []TextChange `json:"diff"`
+}
+
+// TextChange is a run of text a diff keeps (equal), adds (insert) or removes (delete)
+type TextChange struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// RecurringRequest is the body of CreateRecurring. Cron is a five-field cron expression, such as
+// "0 9 * * mon", run in Timezone or, when it's empty, the recipient's timezone.
+type RecurringRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message,omitempty"`
+	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Cron      string `json:"cron"`
+	Timezone  string `json:"timezone,omitempty"`
+	// SkipDates (YYYY-MM-DD) and SkipWeekdays (sun to sat) are days without occurrences
+	SkipDates    []string `json:"skip_dates,omitempty"`
+	SkipWeekdays []string `json:"skip_weekdays,omitempty"`
+	// OnSkip is skip (the default) to drop an occurrence on a skipped day, or next_day to move it
+	OnSkip  string `json:"on_skip,omitempty"`
+	EndDate string `json:"end_date,omitempty"`
+}
+
+// RecurringUpdate is the body of UpdateRecurring; nil fields keep their value
+type RecurringUpdate struct {
+	Message      *string   `json:"message,omitempty"`
+	MediaPath    *string   `json:"media_path,omitempty"`
+	ClientRef    *string   `json:"client_ref,omitempty"`
+	Agent        *string   `json:"agent,omitempty"`
+	Cron         *string   `json:"cron,omitempty"`
+	Timezone     *string   `json:"timezone,omitempty"`
+	SkipDates    *[]string `json:"skip_dates,omitempty"`
+	SkipWeekdays *[]string `json:"skip_weekdays,omitempty"`
+	OnSkip       *string   `json:"on_skip,omitempty"`
+	EndDate      *string   `json:"end_date,omitempty"`
+}
+
+// RecurringSeries sends a message every time its cron expression matches
+type RecurringSeries struct {
+	ID        string `json:"id"`
+	Recipient string `json:"recipient"`
+	ChatJID   string `json:"chat_jid"`
+	Message   string `json:"message,omitempty"`
+	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Cron      string `json:"cron"`
+	// TimezoneSource is request, metadata, country_code or default
+	Timezone       string   `json:"timezone"`
+	TimezoneSource string   `json:"timezone_source"`
+	SkipDates      []string `json:"skip_dates,omitempty"`
+	SkipWeekdays   []string `json:"skip_weekdays,omitempty"`
+	OnSkip         string   `json:"on_skip"`
+	EndDate        string   `json:"end_date,omitempty"`
+	// Status is active, paused, ended or canceled
+	Status      string     `json:"status"`
+	NextSendAt  *time.Time `json:"next_send_at,omitempty"`
+	Occurrences int        `json:"occurrences"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// Upcoming lists the next occurrences of an active series
+	Upcoming []time.Time `json:"upcoming,omitempty"`
+}
+
+// MessageTemplate is reusable message text with {{name}} placeholders
+type MessageTemplate struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Body string `json:"body"`
+	// Language is the language tag of Body, e.g. en
+	Language string `json:"language,omitempty"`
+	// Variants are the body in other languages by language tag; each recipient gets the variant
+	// of their language attribute, falling back to Body
+	Variants map[string]string `json:"variants,omitempty"`
+	// Samples are example values of the placeholders, used by previews
+	Samples   map[string]string `json:"samples,omitempty"`
+	CreatedAt time.Time         `json:"created_at,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at,omitempty"`
+}
+
+// TemplatePreviewRequest is the body of PreviewTemplate
+type TemplatePreviewRequest struct {
+	// Recipient fills placeholders from a contact's attributes, over the template's samples
+	Recipient string `json:"recipient,omitempty"`
+	// Variables fill placeholders, over the samples and the recipient's attributes
+	Variables map[string]string `json:"variables,omitempty"`
+	Agent     string            `json:"agent,omitempty"`
+	Signature *bool             `json:"signature,omitempty"`
+}
+
+// TemplatePreview is a template rendered with a set of variables, and what's wrong with the result
+type TemplatePreview struct {
+	TemplateID string `json:"template_id"`
+	// Language is the language of the variant rendered
+	Language string `json:"language,omitempty"`
+	// Text is exactly what would be sent
+	Text         string          `json:"text"`
+	Length       int             `json:"length"`
+	MaxLength    int             `json:"max_length"`
+	Placeholders []string        `json:"placeholders"`
+	Undefined    []string        `json:"undefined"`
+	Unused       []string        `json:"unused"`
+	Issues       []TemplateIssue `json:"issues"`
+	// OK is false when any issue is an error; such a template isn't sent
+	OK bool `json:"ok"`
+}
+
+// TemplateIssue is something a template check found. Severity is error or warning, and Code one of
+// undefined_placeholder, malformed_placeholder, empty, too_long, url_not_allowed or insecure_url.
+type TemplateIssue struct {
+	Severity    string `json:"severity"`
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Placeholder string `json:"placeholder,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// ContactAttributes personalize messages to a contact; templates use each as a variable
+type ContactAttributes struct {
+	JID      string `json:"jid,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Fields are custom attributes, e.g. {"plan": "pro"}
+	Fields    map[string]string `json:"fields"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// ContactAttributesUpdate is the body of UpdateContactAttributes; nil fields keep their value and
+// a nil entry of Fields removes that field
+type ContactAttributesUpdate struct {
+	Name     *string            `json:"name,omitempty"`
+	Language *string            `json:"language,omitempty"`
+	Fields   map[string]*string `json:"fields,omitempty"`
+}
+
+// ContactImportRow is a contact of ImportContactAttributes; Recipient is a phone number or JID
+type ContactImportRow struct {
+	Recipient string            `json:"recipient"`
+	Name      string            `json:"name,omitempty"`
+	Language  string            `json:"language,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// ContactImportResult counts the contacts an import saved and lists the rows it skipped
+type ContactImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  []struct {
+		Row       int    `json:"row"`
+		Recipient string `json:"recipient,omitempty"`
+		Error     string `json:"error"`
+	} `json:"skipped"`
+}
+
+// ReactRequest is the body of React
+type ReactRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	// Emoji replaces the account's reaction; empty removes it
+	Emoji string `json:"emoji"`
+}
+
+// SendVoiceRequest describes the recording SendVoice sends
+type SendVoiceRequest struct {
+	Recipient string
+	// ContentType is audio/ogg or audio/webm; both must hold Opus audio
+	ContentType string
+	ClientRef   string
+	Agent       string
+	// QuotedMessageID sends the voice note as a reply to a stored message of the chat
+	QuotedMessageID string
+}
+
+// SendMediaRequest describes the file SendMedia sends
+type SendMediaRequest struct {
+	Recipient string
+	// Message is the caption
+	Message string
+	// Filename is the name the file is sent under; its type is detected from the content
+	Filename  string
+	ClientRef string
+	Agent     string
+	Signature *bool
+	// QuotedMessageID sends the file as a reply to a stored message of the chat
+	QuotedMessageID string
+}
+
+// DownloadMediaRequest is the body of DownloadMedia
+type DownloadMediaRequest struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+}
+
+// DownloadMediaResponse is returned by DownloadMedia
+type DownloadMediaResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	Filename string `json:"filename,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// MediaURL is a signed URL of media in the bridge's Supabase Storage bucket
+type MediaURL struct {
+	URL       string    `json:"url"`
+	Filename  string    `json:"filename"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Chat is a conversation known to the bridge
+type Chat struct {
+	JID             string    `json:"jid"`
+	Name            string    `json:"name"`
+	LastMessageTime time.Time `json:"last_message_time"`
+}
+
+// Message is a stored message
+type Message struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	// SystemEvent is set on notices such as group participant changes
+	SystemEvent string `json:"system_event,omitempty"`
+	// ClientRef is the reference passed when sending the message
+	ClientRef string `json:"client_ref,omitempty"`
+	// Agent is the dashboard user who sent the message
+	Agent string `json:"agent,omitempty"`
+	// Reactions counts the people who reacted with each emoji
+	Reactions map[string]int `json:"reactions,omitempty"`
+	// MyReaction is the emoji the bridge account reacted with
+	MyReaction string `json:"my_reaction,omitempty"`
+	// ReplyTo is the ID of the message this one quotes
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Status is received for inbound messages, and sent, delivered, read or played for outbound ones
+	Status string `json:"status,omitempty"`
+}
+
+// SemanticSearchResult is a message found by SemanticSearch. Score is the cosine similarity
+// of the message to the query, higher meaning closer.
+type SemanticSearchResult struct {
+	Message
+	Score float64 `json:"score"`
+}
+
+// SemanticSearchOptions narrow SemanticSearch to a chat and to messages sent from Since and before Until
+// SearchOptions narrows Search; zero fields don't filter
+type SearchOptions struct {
+	ChatJID string
+	// Sender is a phone number or JID
+	Sender string
+	Since  time.Time
+	Until  time.Time
+	// Type is text for messages without media, or image, video, audio or document
+	Type   string
+	Limit  int
+	Offset int
+}
+
+type SemanticSearchOptions struct {
+	ChatJID string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+// MessageThread is a chain of quoted replies, oldest first
+type MessageThread struct {
+	ChatJID   string    `json:"chat_jid"`
+	MessageID string    `json:"message_id"`
+	RootID    string    `json:"root_id"`
+	Messages  []Message `json:"messages"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// Draft is a saved, unsent reply for a chat
+type Draft struct {
+	ChatJID   string    `json:"chat_jid"`
+	Content   string    `json:"content"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChatMetadata is the notes and key/value metadata of a chat or contact
+type ChatMetadata struct {
+	JID       string            `json:"jid,omitempty"`
+	Notes     string            `json:"notes"`
+	Metadata  map[string]string `json:"metadata"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// UpdateMetadataRequest is the body of UpdateMetadata; a nil value removes the key
+type UpdateMetadataRequest struct {
+	Notes    *string            `json:"notes,omitempty"`
+	Metadata map[string]*string `json:"metadata,omitempty"`
+}
+
+// SaveDraftRequest is the body of SaveDraft
+type SaveDraftRequest struct {
+	Content   string `json:"content"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// CreateUploadRequest is the body of CreateUpload
+type CreateUploadRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// Upload is a resumable upload in progress
+type Upload struct {
+	ID        string    `json:"upload_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SendUploadRequest is the body of SendUpload
+type SendUploadRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Signature *bool  `json:"signature,omitempty"`
+	// QuotedMessageID sends the file as a reply to a stored message of the chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// EraseRequest is the body of EraseContact
+type EraseRequest struct {
+	Phone   string `json:"phone,omitempty"`
+	JID     string `json:"jid,omitempty"`
+	Confirm bool   `json:"confirm"`
+}
+
+// ErasureReport is returned by EraseContact. Held lists chats on legal hold whose messages were kept.
+type ErasureReport struct {
+	JID         string           `json:"jid"`
+	Deleted     map[string]int64 `json:"deleted"`
+	Held        []string         `json:"held,omitempty"`
+	Errors      []string         `json:"errors,omitempty"`
+	CompletedAt time.Time        `json:"completed_at"`
+}
+
+// LegalHold keeps a chat's messages and media from being deleted
+type LegalHold struct {
+	ChatJID  string    `json:"chat_jid"`
+	Reason   string    `json:"reason"`
+	PlacedBy string    `json:"placed_by,omitempty"`
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+// ChainGap is a range of hash chain links whose messages are no longer stored
+type ChainGap struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// ChainMismatch is a chained message that no longer matches its hash or its predecessor
+type ChainMismatch struct {
+	Seq       int64  `json:"seq"`
+	MessageID string `json:"message_id"`
+	Problem   string `json:"problem"`
+}
+
+// ChainVerification is returned by VerifyChain. Valid means no stored message was altered;
+// Complete means no chained message is missing.
+type ChainVerification struct {
+	ChatJID    string          `json:"chat_jid"`
+	Valid      bool            `json:"valid"`
+	Complete   bool            `json:"complete"`
+	Messages   int             `json:"messages"`
+	Unchained  int             `json:"unchained"`
+	HeadSeq    int64           `json:"head_seq"`
+	HeadHash   string          `json:"head_hash,omitempty"`
+	Gaps       []ChainGap      `json:"gaps"`
+	Mismatches []ChainMismatch `json:"mismatches"`
+	CheckedAt  time.Time       `json:"checked_at"`
+}
+
+// ChainCheck is a hash from an exported transcript
+type ChainCheck struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// ChainCheckResult tells whether a hash matches the stored message: match, mismatch or missing
+type ChainCheckResult struct {
+	Seq       int64  `json:"seq"`
+	Hash      string `json:"hash"`
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status"`
+}
+
+// HoldAuditEntry records an access to, or a change of, a chat on legal hold.
+// Action is placed, released, access, revoke_kept or erasure_kept.
+type HoldAuditEntry struct {
+	ID      string    `json:"id"`
+	ChatJID string    `json:"chat_jid"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor"`
+	IP      string    `json:"ip,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Avatar is the profile picture of a contact or group
+type Avatar struct {
+	JID string `json:"jid"`
+	URL string `json:"url"`
+	ID  string `json:"id"`
+}
+
+// ChatAssignment is the queue a chat was routed to. SLA is only set when its queue has SLA targets.
+type ChatAssignment struct {
+	ChatJID         string     `json:"chat_jid"`
+	Name            string     `json:"name,omitempty"`
+	Queue           string     `json:"queue"`
+	Rule            string     `json:"rule"`
+	AssignedAt      time.Time  `json:"assigned_at"`
+	FirstResponseAt *time.Time `json:"first_response_at,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	SLA             *SLAStatus `json:"sla,omitempty"`
+}
+
+// Handoff is a chat handed to an agent because the contact asked for one (trigger "keyword"), the
+// bot kept failing to understand them (trigger "fallback") or an agent paused the bot (trigger
+// "manual"); the bot is paused there until resumed
+type Handoff struct {
+	ChatJID  string     `json:"chat_jid"`
+	Name     string     `json:"name,omitempty"`
+	Trigger  string     `json:"trigger"`
+	Keyword  string     `json:"keyword,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+}
+
+// BotStatus reports whether the bot is paused in a chat; Trigger is "manual" for pauses made with PauseBot
+type BotStatus struct {
+	ChatJID  string     `json:"chat_jid"`
+	Paused   bool       `json:"paused"`
+	Trigger  string     `json:"trigger,omitempty"`
+	Keyword  string     `json:"keyword,omitempty"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+}
+
+// SLAStatus is where an assignment stands against the SLA targets of its queue
+type SLAStatus struct {
+	FirstResponseDue      *time.Time `json:"first_response_due,omitempty"`
+	FirstResponseBreached bool       `json:"first_response_breached"`
+	ResolutionDue         *time.Time `json:"resolution_due,omitempty"`
+	ResolutionBreached    bool       `json:"resolution_breached"`
+}
+
+// FlowOption is a choice of a menu step
+type FlowOption struct {
+	Label    string   `json:"label"`
+	Keywords []string `json:"keywords,omitempty"`
+	Next     string   `json:"next"`
+}
+
+// FlowStep is one state of a conversation flow
+type FlowStep struct {
+	Type     string       `json:"type"`
+	Message  string       `json:"message"`
+	Next     string       `json:"next,omitempty"`
+	Options  []FlowOption `json:"options,omitempty"`
+	Variable string       `json:"variable,omitempty"`
+	Validate string       `json:"validate,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	OnYes    string       `json:"on_yes,omitempty"`
+	OnNo     string       `json:"on_no,omitempty"`
+	Assign   string       `json:"assign,omitempty"`
+}
+
+// Flow is a conversation flow loaded by the bridge
+type Flow struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	Start       string `json:"start"`
+	Trigger     *struct {
+		Keywords []string `json:"keywords,omitempty"`
+		Regex    string   `json:"regex,omitempty"`
+	} `json:"trigger,omitempty"`
+	Cancel         []string             `json:"cancel,omitempty"`
+	TimeoutMinutes int                  `json:"timeout_minutes,omitempty"`
+	Steps          map[string]*FlowStep `json:"steps"`
+}
+
+// FlowSession is the step of a flow a chat is waiting at
+type FlowSession struct {
+	ChatJID   string            `json:"chat_jid"`
+	FlowID    string            `json:"flow_id"`
+	Step      string            `json:"step"`
+	Variables map[string]string `json:"variables"`
+	StartedAt time.Time         `json:"started_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// StartFlowRequest puts a chat into a flow. Step defaults to the flow's start step.
+type StartFlowRequest struct {
+	FlowID    string            `json:"flow_id"`
+	Step      string            `json:"step,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// PairingViewer is a dashboard session that was shown the QR code
+type PairingViewer struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// PairingCode is the code to enter on the phone under Linked Devices > Link a Device > Link with
+// phone number instead. Phone is the digits of the number it links.
+type PairingCode struct {
+	Code  string `json:"code"`
+	Phone string `json:"phone"`
+}
+
+// PairingAttempt is one attempt to link the WhatsApp account.
+// Outcome is pending, success, failed, timeout or abandoned.
+type PairingAttempt struct {
+	ID         string          `json:"id"`
+	Method     string          `json:"method"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	DeviceJID  string          `json:"device_jid,omitempty"`
+	Platform   string          `json:"platform,omitempty"`
+	Viewers    []PairingViewer `json:"viewers"`
+}
+
+// SessionLock blocks sending after a possible session takeover.
+// Reason is stream_replaced or logged_out.
+type SessionLock struct {
+	ID             string     `json:"id"`
+	Reason         string     `json:"reason"`
+	Detail         string     `json:"detail,omitempty"`
+	LockedAt       time.Time  `json:"locked_at"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+// SessionStatus reports whether sending is locked
+type SessionStatus struct {
+	Locked bool         `json:"locked"`
+	Lock   *SessionLock `json:"lock,omitempty"`
+}
+
+// OrphanFile is a media file no stored message refers to
+type OrphanFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MediaGCReport is the result of an orphaned media scan
+type MediaGCReport struct {
+	Scanned int          `json:"scanned"`
+	Orphans []OrphanFile `json:"orphans"`
+	Bytes   int64        `json:"bytes"`
+	Removed int          `json:"removed"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+// StorageStatus reports database, media and free disk usage.
+// Status is ok, warning or critical.
+type StorageStatus struct {
+	Status          string    `json:"status"`
+	Problems        []string  `json:"problems,omitempty"`
+	DatabaseBytes   int64     `json:"database_bytes"`
+	MediaBytes      int64     `json:"media_bytes"`
+	MediaFiles      int       `json:"media_files"`
+	DiskFreeBytes   uint64    `json:"disk_free_bytes,omitempty"`
+	DiskTotalBytes  uint64    `json:"disk_total_bytes,omitempty"`
+	DiskFreePercent float64   `json:"disk_free_percent,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+// MaintenanceStatus reports whether sends and event processing are paused.
+// State is off or active.
+type MaintenanceStatus struct {
+	State  string     `json:"state"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// LogLevel is the bridge's log level. ExpiresAt is set while a raised level lasts.
+type LogLevel struct {
+	Level        string     `json:"level"`
+	DefaultLevel string     `json:"default_level"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// Tenant is a customer sharing the bridge, identified by the key IDs of its API keys
+type Tenant struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	KeyIDs          []string  `json:"key_ids"`
+	Users           []string  `json:"users"`
+	Suspended       bool      `json:"suspended,omitempty"`
+	SuspendedReason string    `json:"suspended_reason,omitempty"`
+	CreatedAt       time.Time `json:"created_at,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at,omitempty"`
+}
+
+// AuditExport is a day of outbound messages uploaded to the audit bucket
+type AuditExport struct {
+	Day            string    `json:"day"`
+	Object         string    `json:"object"`
+	Records        int       `json:"records"`
+	SHA256         string    `json:"sha256"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	ExportedAt     time.Time `json:"exported_at"`
+}
+
+// DashboardLogin is a sign-in to the dashboard, located when the bridge has a GeoIP database
+type DashboardLogin struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Outcome   string `json:"outcome"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Region    string `json:"region,omitempty"`
+	City      string `json:"city,omitempty"`
+	// NewCountry is set on the user's first successful login from the country
+	NewCountry bool      `json:"new_country"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// DashboardUser is a local account that signs in to the dashboard without Supabase
+type DashboardUser struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Admin bool   `json:"admin"`
+	// HasPassword is false for users who sign in with a passkey only
+	HasPassword bool      `json:"has_password"`
+	Passkeys    int       `json:"passkeys"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DashboardUserRequest creates or updates a dashboard user; empty fields are left as they are
+type DashboardUserRequest struct {
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+	Admin    *bool  `json:"admin,omitempty"`
+}
+
+// TenantStatus is a tenant with the connection status and its usage this month
+type TenantStatus struct {
+	Tenant
+	Connected bool             `json:"connected"`
+	Period    string           `json:"period"`
+	Usage     map[string]int64 `json:"usage"`
+}
+
+// AccountStatus is an additional WhatsApp account and its connection state
+type AccountStatus struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	JID         string    `json:"jid,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Connected   bool      `json:"connected"`
+	LoggedIn    bool      `json:"logged_in"`
+	QRAvailable bool      `json:"qr_available"`
+	QRURL       string    `json:"qr_url"`
+}
+
+// APIKey is an issued API key. Key is only set in the response that creates it.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UsageReport is the usage of one API key in one month. Usage and Quota are keyed by
+// messages_sent, media_upload_bytes and media_download_bytes.
+type UsageReport struct {
+	Subject string           `json:"subject"`
+	Period  string           `json:"period"`
+	Usage   map[string]int64 `json:"usage"`
+	Quota   map[string]int64 `json:"quota,omitempty"`
+	// Cost is the simulated cost of the messages sent, when the bridge has MESSAGE_COSTS_FILE set
+	Cost *UsageCost `json:"cost,omitempty"`
+	// WarmUp is set while the account's daily sends are limited by a warm-up profile
+	WarmUp *WarmUpStatus `json:"warm_up,omitempty"`
+}
+
+// UsageCost is what the messages sent in a month would cost a paid provider
+type UsageCost struct {
+	Currency  string     `json:"currency"`
+	Total     float64    `json:"total"`
+	Breakdown []CostLine `json:"breakdown"`
+}
+
+// CostLine is the cost of the messages sent to one chat type and country calling code
+type CostLine struct {
+	ChatType string  `json:"chat_type"`
+	Country  string  `json:"country,omitempty"`
+	Messages int64   `json:"messages"`
+	Cost     float64 `json:"cost"`
+}
+
+// WarmUpStatus is the daily send limit of a newly linked number
+type WarmUpStatus struct {
+	Account    string    `json:"account"`
+	StartedAt  time.Time `json:"started_at"`
+	Day        int       `json:"day"`
+	DailyLimit int       `json:"daily_limit"`
+	SentToday  int       `json:"sent_today"`
+	Remaining  int       `json:"remaining"`
+	EndsAt     time.Time `json:"ends_at"`
+}
+
+// Presence is the online status of a contact. Status is online, offline or unknown.
+type Presence struct {
+	JID       string     `json:"jid"`
+	Status    string     `json:"status"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Subscribed is set while the bridge receives the contact's presence updates
+	Subscribed bool `json:"subscribed"`
+}
+
+// OwnPresence is the account's own status, online or offline
+type OwnPresence struct {
+	Status string `json:"status"`
+}
+
+// ContactProfile is what WhatsApp tells about a contact
+type ContactProfile struct {
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+	VerifiedName string `json:"verified_name,omitempty"`
+	About        string `json:"about,omitempty"`
+}
+
+// SharedGroup is a group both the account and the contact are in
+type SharedGroup struct {
+	JID     string `json:"jid"`
+	Name    string `json:"name"`
+	IsAdmin bool   `json:"is_admin"`
+}
+
+// ContactVolume is the number of messages exchanged with a contact on one day
+type ContactVolume struct {
+	Date     string `json:"date"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+}
+
+// ContactOverview is the profile, history, labels, notes and recent media of a contact
+type ContactOverview struct {
+	JID       string         `json:"jid"`
+	Name      string         `json:"name"`
+	Profile   ContactProfile `json:"profile"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	// SharedGroups is nil while the bridge is not connected to WhatsApp
+	SharedGroups     []SharedGroup     `json:"shared_groups"`
+	MessagesSent     int               `json:"messages_sent"`
+	MessagesReceived int               `json:"messages_received"`
+	GroupMessages    int               `json:"group_messages"`
+	FirstMessageAt   *time.Time        `json:"first_message_at,omitempty"`
+	LastMessageAt    *time.Time        `json:"last_message_at,omitempty"`
+	Volume           []ContactVolume   `json:"volume"`
+	Labels           []string          `json:"labels"`
+	Notes            string            `json:"notes"`
+	Metadata         map[string]string `json:"metadata"`
+	Assignment       *ChatAssignment   `json:"assignment,omitempty"`
+	RecentMedia      []Message         `json:"recent_media"`
+}
+
+// Health is the WhatsApp connection status
+type Health struct {
+	Connected bool   `json:"connected"`
+	Message   string `json:"message"`
+	// Role is "standalone", or "leader" / "follower" when the bridge runs in HA mode
+	Role string `json:"role"`
+	// ReadOnly is set when the bridge refuses sends and changes
+	ReadOnly bool `json:"read_only"`
+	// ReceiveOnly is set when the bridge archives incoming messages but refuses all sends
+	ReceiveOnly bool              `json:"receive_only"`
+	Maintenance MaintenanceStatus `json:"maintenance"`
+	// Storage is nil until the first measurement
+	Storage *StorageStatus `json:"storage,omitempty"`
+}
+
+// Version is the build of a bridge
+type Version struct {
+	// Version is the release the bridge was built from, or "dev" for unstamped builds
+	Version          string    `json:"version"`
+	Commit           string    `json:"commit,omitempty"`
+	CommitTime       string    `json:"commit_time,omitempty"`
+	Modified         bool      `json:"modified"`
+	GoVersion        string    `json:"go_version"`
+	WhatsmeowVersion string    `json:"whatsmeow_version,omitempty"`
+	OS               string    `json:"os"`
+	Arch             string    `json:"arch"`
+	StartedAt        time.Time `json:"started_at"`
+	// SchemaVersion is the last database migration the bridge applied
+	SchemaVersion int `json:"schema_version"`
+	// Update is nil unless the bridge has UPDATE_CHECK enabled
+	Update *UpdateStatus `json:"update,omitempty"`
+}
+
+// UpdateStatus is the bridge's last lookup of the latest release
+type UpdateStatus struct {
+	LatestVersion   string    `json:"latest_version,omitempty"`
+	UpdateAvailable bool      `json:"update_available"`
+	ReleaseURL      string    `json:"release_url,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+	// Error says why the lookup failed
+	Error string `json:"error,omitempty"`
+}
+
+// DatabaseStatus is the database connection status
+type DatabaseStatus struct {
+	Healthy      bool              `json:"healthy"`
+	Status       string            `json:"status"`
+	DatabaseInfo map[string]string `json:"database_info"`
+	Timestamp    time.Time         `json:"timestamp"`
+}
+
+// Group is a group the account is in
+type Group struct {
+	JID              string `json:"jid"`
+	Name             string `json:"name"`
+	ParticipantCount int    `json:"participant_count"`
+	// IsAdmin is set when the account is an admin of the group
+	IsAdmin bool `json:"is_admin"`
+	// AdminsOnly is set when only admins can send messages to the group
+	AdminsOnly bool `json:"admins_only"`
+}
+
+// GroupEvent is a membership change, subject change or admin action in a group.
+// Events stored before the bridge recorded details only have Text and Actor.
+type GroupEvent struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Timestamp    time.Time `json:"timestamp"`
+	Actor        string    `json:"actor,omitempty"`
+	Text         string    `json:"text"`
+	Author       string    `json:"author,omitempty"`
+	Participants []string  `json:"participants,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Subject      string    `json:"subject,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	Setting      string    `json:"setting,omitempty"`
+	Value        string    `json:"value,omitempty"`
+	Removed      bool      `json:"removed,omitempty"`
+	Action       string    `json:"action,omitempty"`
+	MessageID    string    `json:"message_id,omitempty"`
+}
+
+// ChatMute is a chat muted with the !mute chat command. Until is nil for mutes without an end.
+type ChatMute struct {
+	ChatJID string     `json:"chat_jid"`
+	MutedBy string     `json:"muted_by"`
+	MutedAt time.Time  `json:"muted_at"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// GroupEventOptions are the optional filters of GetGroupEvents
+type GroupEventOptions struct {
+	From        time.Time
+	To          time.Time
+	Types       []string
+	Participant string
+	Limit       int
+}
+
+// BridgeEvent is an event from the activity feed, in the shape sent to webhooks
+type BridgeEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	ChatJID   string                 `json:"chat_jid,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// ActivityOptions are the optional filters of ListActivity. Types may name categories such as "group.*".
+type ActivityOptions struct {
+	Types   []string
+	ChatJID string
+	Before  time.Time
+	Limit   int
+}
+
+// AnalyticsOptions are the optional filters of the analytics reports. From and To are dates such as "2025-01-31";
+// Label limits the reports to chats with that label.
+type AnalyticsOptions struct {
+	From     string
+	To       string
+	ChatJID  string
+	Agent    string
+	Label    string
+	Timezone string
+}
+
+// DailyMessages is the number of messages received and sent on one day
+type DailyMessages struct {
+	Date     string `json:"date"`
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+}
+
+// DailyDelivery is the number of API sends WhatsApp accepted and refused on one day.
+// SuccessRate is nil on days without sends.
+type DailyDelivery struct {
+	Date        string   `json:"date"`
+	Sent        int      `json:"sent"`
+	Failed      int      `json:"failed"`
+	SuccessRate *float64 `json:"success_rate"`
+}
+
+// AgentResponseTimes summarizes how long an agent took to answer contacts. Agent is empty for replies without one.
+type AgentResponseTimes struct {
+	Agent          string  `json:"agent"`
+	Replies        int     `json:"replies"`
+	AverageSeconds float64 `json:"average_seconds"`
+	MedianSeconds  float64 `json:"median_seconds"`
+	MaxSeconds     float64 `json:"max_seconds"`
+}
+
+// LabelActivity is the number of labeled chats with messages in a period, and their messages.
+// A chat with several labels counts under each.
+type LabelActivity struct {
+	Label    string `json:"label"`
+	Chats    int    `json:"chats"`
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+}
+
+// DailySentiment summarizes the scored incoming messages of one day. Scores run from -1 to 1;
+// Average is nil on days without scored messages.
+type DailySentiment struct {
+	Date     string   `json:"date"`
+	Scored   int      `json:"scored"`
+	Average  *float64 `json:"average"`
+	Positive int      `json:"positive"`
+	Neutral  int      `json:"neutral"`
+	Negative int      `json:"negative"`
+}
+
+// ChatSentiment summarizes the scored incoming messages of one chat. RecentAverage covers its
+// last five scored messages, and Trend is RecentAverage minus Average.
+type ChatSentiment struct {
+	ChatJID       string    `json:"chat_jid"`
+	Name          string    `json:"name,omitempty"`
+	Scored        int       `json:"scored"`
+	Average       float64   `json:"average"`
+	RecentAverage float64   `json:"recent_average"`
+	Trend         float64   `json:"trend"`
+	Negative      int       `json:"negative"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// ExportedMessage is one message of a full export
+type ExportedMessage struct {
+	ID          string         `json:"id"`
+	ChatJID     string         `json:"chat_jid"`
+	Sender      string         `json:"sender"`
+	Content     string         `json:"content"`
+	Timestamp   time.Time      `json:"timestamp"`
+	IsFromMe    bool           `json:"is_from_me"`
+	MediaType   string         `json:"media_type,omitempty"`
+	Filename    string         `json:"filename,omitempty"`
+	Agent       string         `json:"agent,omitempty"`
+	ReplyTo     string         `json:"reply_to,omitempty"`
+	SystemEvent string         `json:"system_event,omitempty"`
+	Status      string         `json:"status,omitempty"`
+	Reactions   map[string]int `json:"reactions,omitempty"`
+	MyReaction  string         `json:"my_reaction,omitempty"`
+}
+
+// MessageExportOptions limit ExportMessages to messages sent from Since and before Until
+type MessageExportOptions struct {
+	Since time.Time
+	Until time.Time
+}
+
+// ExportOptions are the optional parameters of ExportChat
+type ExportOptions struct {
+	From         time.Time
+	To           time.Time
+	NoThumbnails bool
+}
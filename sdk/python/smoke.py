@@ -0,0 +1,80 @@
+"""Exercises the Python SDK against a running bridge.
+
+    BRIDGE_URL=http://localhost:8080 BRIDGE_API_KEY=... python smoke.py
+
+Set SMOKE_RECIPIENT to also send a real message.
+"""
+
+import os
+import sys
+
+from whatsapp_bridge import BridgeClient
+
+# A chat that never exists, used for write operations that must not touch real data
+SMOKE_CHAT_JID = "0000000000@s.whatsapp.net"
+
+
+def main():
+    client = BridgeClient(os.environ.get("BRIDGE_URL", "http://localhost:8080"), api_key=os.environ.get("BRIDGE_API_KEY"))
+    failures = 0
+
+    def check(name, fn):
+        nonlocal failures
+        try:
+            fn()
+            print(f"ok   {name}")
+        except Exception as err:  # report every failure, keep going
+            failures += 1
+            print(f"FAIL {name}: {err}")
+
+    def list_chats_and_messages():
+        chats = client.list_chats()
+        if chats:
+            client.list_messages(chats[0]["jid"], limit=5)
+
+    def draft_round_trip():
+        client.save_draft(SMOKE_CHAT_JID, "smoke test")
+        draft = client.get_draft(SMOKE_CHAT_JID)
+        if not draft or draft["content"] != "smoke test":
+            raise AssertionError(f"unexpected draft: {draft}")
+        client.delete_draft(SMOKE_CHAT_JID)
+
+    def chunked_upload():
+        data = b"smoke test upload"
+        upload = client.create_upload("smoke.txt", len(data))
+        try:
+            offset = client.append_upload_chunk(upload["upload_id"], 0, data[:5])
+            client.append_upload_chunk(upload["upload_id"], offset, data[5:])
+            status = client.get_upload(upload["upload_id"])
+            if status["offset"] != status["size"]:
+                raise AssertionError(f"upload at {status['offset']} of {status['size']} bytes")
+        finally:
+            client.delete_upload(upload["upload_id"])
+
+    def export():
+        if not client.export_chat(SMOKE_CHAT_JID, thumbnails=False).startswith(b"%PDF-"):
+            raise AssertionError("response is not a PDF")
+
+    check("health", client.health)
+    check("db status", client.database_status)
+    check("list chats / messages", list_chats_and_messages)
+    check("draft round trip", draft_round_trip)
+    check("chunked upload", chunked_upload)
+    check("export", export)
+
+    recipient = os.environ.get("SMOKE_RECIPIENT")
+    if recipient:
+        def send():
+            resp = client.send_message(recipient, "Bridge SDK smoke test")
+            if not resp["success"]:
+                raise AssertionError(resp["message"])
+
+        check("send message", send)
+
+    if failures:
+        print(f"{failures} check(s) failed")
+        sys.exit(1)
+
+
+if __name__ == "__main__":
+    main()
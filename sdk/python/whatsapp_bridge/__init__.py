@@ -0,0 +1,921 @@
+"""Thin Python client for the WhatsApp bridge REST API (/api/v1).
+
+Each method maps to one operation in whatsapp-bridge/openapi.yaml. Responses
+are returned as the decoded JSON (dicts and lists with snake_case keys).
+"""
+
+import base64
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+
+__all__ = ["BridgeClient", "BridgeAPIError"]
+
+
+class BridgeAPIError(Exception):
+    """Raised for non-2xx responses."""
+
+    def __init__(self, status, message):
+        super().__init__(f"bridge API error {status}: {message}")
+        self.status = status
+        self.message = message
+
+
+class BridgeClient:
+    def __init__(self, base_url, timezone=None, timeout=30, api_key=None):
+        self.base_url = base_url.rstrip("/")
+        self.timezone = timezone
+        self.timeout = timeout
+        self.api_key = api_key
+
+    def _request(self, method, path, query=None, body=None, headers=None):
+        resp = self._open(method, path, query, body, headers)
+        if isinstance(resp, tuple):
+            return resp
+        with resp:
+            return resp.status, resp.headers, resp.read()
+
+    def _open(self, method, path, query=None, body=None, headers=None):
+        """Sends a request and returns the open response, or a (status, headers, payload) tuple for
+        failures worth returning."""
+        query = dict(query or {})
+        if self.timezone:
+            query["tz"] = self.timezone
+        url = f"{self.base_url}/api/v1{path}"
+        if query:
+            url += "?" + urllib.parse.urlencode(query)
+
+        headers = dict(headers or {})
+        if self.api_key:
+            headers["X-API-Key"] = self.api_key
+        req = urllib.request.Request(url, data=body, method=method, headers=headers)
+        try:
+            return urllib.request.urlopen(req, timeout=self.timeout)
+        except urllib.error.HTTPError as err:
+            # Send and download report failures with a JSON body that is still worth returning
+            if err.code == 500 and err.headers.get("Content-Type", "").startswith("application/json"):
+                return err.code, err.headers, err.read()
+            raise BridgeAPIError(err.code, err.read().decode(errors="replace").strip()) from None
+
+    def _json(self, method, path, body=None, query=None):
+        data, headers = None, {}
+        if body is not None:
+            data = json.dumps(body).encode()
+            headers["Content-Type"] = "application/json"
+        status, _, payload = self._request(method, path, query, data, headers)
+        if status == 204 or not payload:
+            return None
+        return json.loads(payload)
+
+    @staticmethod
+    def _chat_path(chat_jid, resource):
+        return f"/chats/{urllib.parse.quote(chat_jid, safe='@')}/{resource}"
+
+    def send_message(self, recipient, message="", media_path=None, client_ref=None, agent=None, signature=None,
+                     wait_for=None, wait_timeout=None, template_id=None, variables=None, queue=None,
+                     quoted_message_id=None):
+        """Sends a message. With wait_for ("server_ack" or "delivered") the call blocks until the message
+        reaches that state or wait_timeout seconds pass; the client timeout must be longer. template_id
+        sends a stored template filled in with variables instead of message. queue=True hands the message
+        to the outbox and returns its outbox_id at once; None uses the bridge's OUTBOX_ENABLED.
+        quoted_message_id sends the message as a reply to a stored message of the same chat."""
+        body = {"recipient": recipient, "message": message}
+        if media_path:
+            body["media_path"] = media_path
+        if client_ref:
+            body["client_ref"] = client_ref
+        if agent:
+            body["agent"] = agent
+        if signature is not None:
+            body["signature"] = signature
+        if template_id:
+            body["template_id"] = template_id
+            body["variables"] = variables or {}
+        if queue is not None:
+            body["queue"] = queue
+        if quoted_message_id:
+            body["quoted_message_id"] = quoted_message_id
+        query = {}
+        if wait_for:
+            query["wait_for"] = wait_for
+        if wait_timeout:
+            query["timeout"] = wait_timeout
+        return self._json("POST", "/send", body, query=query or None)
+
+    def request_payment(self, recipient, amount, currency, note=None, expires_in_hours=None, client_ref=None, agent=None):
+        """Sends a WhatsApp payment request; the bridge must run with PAYMENTS_ENABLED."""
+        body = {"recipient": recipient, "amount": amount, "currency": currency}
+        if note:
+            body["note"] = note
+        if expires_in_hours:
+            body["expires_in_hours"] = expires_in_hours
+        if client_ref:
+            body["client_ref"] = client_ref
+        if agent:
+            body["agent"] = agent
+        return self._json("POST", "/payments/request", body)
+
+    def schedule_message(self, recipient, message=None, media_path=None, send_at=None, local_time=None, date=None,
+                         client_ref=None, agent=None):
+        """Schedules a message at send_at (a datetime) or at local_time (HH:MM) on the recipient's clock,
+        on date (YYYY-MM-DD) or at its next occurrence."""
+        body = {"recipient": recipient}
+        for key, value in (("message", message), ("media_path", media_path), ("local_time", local_time),
+                           ("date", date), ("client_ref", client_ref), ("agent", agent)):
+            if value:
+                body[key] = value
+        if send_at:
+            body["send_at"] = send_at.isoformat()
+        return self._json("POST", "/scheduled", body)
+
+    def list_scheduled_messages(self, status=None, campaign_id=None, limit=None, series_id=None):
+        """Returns scheduled messages by send time, optionally of one status, campaign or recurring series."""
+        query = {}
+        if status:
+            query["status"] = status
+        if campaign_id:
+            query["campaign_id"] = campaign_id
+        if series_id:
+            query["series_id"] = series_id
+        if limit:
+            query["limit"] = limit
+        return self._json("GET", "/scheduled", query=query or None)
+
+    def get_scheduled_message(self, scheduled_id):
+        return self._json("GET", f"/scheduled/{urllib.parse.quote(scheduled_id, safe='')}")
+
+    def cancel_scheduled_message(self, scheduled_id):
+        """Cancels a pending scheduled message."""
+        return self._json("DELETE", f"/scheduled/{urllib.parse.quote(scheduled_id, safe='')}")
+
+    def create_campaign(self, name, recipients, message=None, media_path=None, send_at=None, local_time=None, date=None,
+                        spread_minutes=None, agent=None, require_approval=False, template_id=None, variables=None):
+        """Schedules a message for many recipients; spread_minutes spaces out those due at the same moment,
+        and require_approval holds them until an admin approves the campaign. template_id sends a stored
+        template instead of message, filled in from each recipient's contact attributes and variables."""
+        body = {"name": name, "recipients": list(recipients)}
+        for key, value in (("message", message), ("media_path", media_path), ("local_time", local_time),
+                           ("date", date), ("spread_minutes", spread_minutes), ("agent", agent),
+                           ("require_approval", require_approval), ("template_id", template_id),
+                           ("variables", variables)):
+            if value:
+                body[key] = value
+        if send_at:
+            body["send_at"] = send_at.isoformat()
+        return self._json("POST", "/campaigns", body)
+
+    def list_campaigns(self, approval=None):
+        """Returns campaigns, newest first, optionally in one approval state such as "pending"."""
+        return self._json("GET", "/campaigns", query={"approval": approval} if approval else None)
+
+    def get_campaign(self, campaign_id):
+        """Returns a campaign with the number of its messages in each status."""
+        return self._json("GET", f"/campaigns/{urllib.parse.quote(campaign_id, safe='')}")
+
+    def cancel_campaign(self, campaign_id):
+        """Cancels the pending messages of a campaign."""
+        return self._json("DELETE", f"/campaigns/{urllib.parse.quote(campaign_id, safe='')}")
+
+    def preview_campaign(self, campaign_id, samples=None):
+        """Renders the message of a campaign's first recipients, with a diff from the submitted text."""
+        query = {"samples": samples} if samples else None
+        return self._json("GET", f"/admin/campaigns/{urllib.parse.quote(campaign_id, safe='')}/preview", query=query)
+
+    def approve_campaign(self, campaign_id, reviewer=None, note=None):
+        """Releases the messages of a campaign waiting for approval."""
+        return self._review_campaign(campaign_id, "approve", reviewer, note)
+
+    def reject_campaign(self, campaign_id, reviewer=None, note=None):
+        """Cancels the messages of a campaign waiting for approval."""
+        return self._review_campaign(campaign_id, "reject", reviewer, note)
+
+    def _review_campaign(self, campaign_id, action, reviewer, note):
+        body = {key: value for key, value in (("reviewer", reviewer), ("note", note)) if value}
+        return self._json("POST", f"/admin/campaigns/{urllib.parse.quote(campaign_id, safe='')}/{action}", body)
+
+    def create_recurring(self, recipient, cron, message=None, media_path=None, timezone=None, skip_dates=None,
+                         skip_weekdays=None, on_skip=None, end_date=None, client_ref=None, agent=None):
+        """Starts a series sending a message every time cron (e.g. "0 9 * * mon") matches, in timezone or
+        the recipient's. on_skip is "skip" or "next_day" for occurrences on skip_dates or skip_weekdays."""
+        body = {"recipient": recipient, "cron": cron}
+        for key, value in (("message", message), ("media_path", media_path), ("timezone", timezone),
+                           ("skip_dates", skip_dates), ("skip_weekdays", skip_weekdays), ("on_skip", on_skip),
+                           ("end_date", end_date), ("client_ref", client_ref), ("agent", agent)):
+            if value:
+                body[key] = value
+        return self._json("POST", "/recurring", body)
+
+    def list_recurring(self, status=None):
+        return self._json("GET", "/recurring", query={"status": status} if status else None)
+
+    def get_recurring(self, series_id):
+        return self._json("GET", f"/recurring/{urllib.parse.quote(series_id, safe='')}")
+
+    def update_recurring(self, series_id, **changes):
+        """Edits a series with the fields of create_recurring, except recipient; changes apply from its
+        next occurrence."""
+        return self._json("PATCH", f"/recurring/{urllib.parse.quote(series_id, safe='')}", changes)
+
+    def pause_recurring(self, series_id):
+        return self._json("POST", f"/recurring/{urllib.parse.quote(series_id, safe='')}/pause")
+
+    def resume_recurring(self, series_id):
+        return self._json("POST", f"/recurring/{urllib.parse.quote(series_id, safe='')}/resume")
+
+    def cancel_recurring(self, series_id):
+        return self._json("DELETE", f"/recurring/{urllib.parse.quote(series_id, safe='')}")
+
+    def create_template(self, name, body, samples=None, language=None, variants=None):
+        """Stores a message template; body has {{name}} placeholders and samples example values for them.
+        variants maps language tags to the body in that language, picked by each recipient's language
+        attribute, with body, in language, as the fallback."""
+        return self._json("POST", "/templates", self._template(name, body, samples, language, variants))
+
+    def list_templates(self):
+        return self._json("GET", "/templates")
+
+    def get_template(self, template_id):
+        return self._json("GET", f"/templates/{urllib.parse.quote(template_id, safe='')}")
+
+    def update_template(self, template_id, name, body, samples=None, language=None, variants=None):
+        """Replaces the name, body, samples and language variants of a template."""
+        return self._json("PUT", f"/templates/{urllib.parse.quote(template_id, safe='')}",
+                          self._template(name, body, samples, language, variants))
+
+    @staticmethod
+    def _template(name, body, samples, language, variants):
+        template = {"name": name, "body": body}
+        if samples:
+            template["samples"] = samples
+        if language:
+            template["language"] = language
+        if variants:
+            template["variants"] = variants
+        return template
+
+    def delete_template(self, template_id):
+        self._json("DELETE", f"/templates/{urllib.parse.quote(template_id, safe='')}")
+
+    def preview_template(self, template_id, variables=None, agent=None, signature=None, recipient=None):
+        """Renders a template with the attributes of recipient and variables over its samples, and returns
+        the exact text that would be sent with the issues found in it."""
+        body = {}
+        if recipient:
+            body["recipient"] = recipient
+        if variables:
+            body["variables"] = variables
+        if agent:
+            body["agent"] = agent
+        if signature is not None:
+            body["signature"] = signature
+        return self._json("POST", f"/templates/{urllib.parse.quote(template_id, safe='')}/preview", body)
+
+    def react(self, chat_jid, message_id, emoji):
+        """Reacts to a stored message; an empty emoji removes the reaction."""
+        return self._json("POST", "/react", {"chat_jid": chat_jid, "message_id": message_id, "emoji": emoji})
+
+    def send_voice(self, recipient, audio, content_type="audio/ogg", client_ref=None, agent=None,
+                   quoted_message_id=None):
+        """Sends Opus audio bytes (Ogg, or WebM as browsers record it) as a voice note."""
+        query = {"recipient": recipient}
+        if client_ref:
+            query["client_ref"] = client_ref
+        if agent:
+            query["agent"] = agent
+        if quoted_message_id:
+            query["quoted_message_id"] = quoted_message_id
+        _, _, payload = self._request("POST", "/send/voice", query, audio, {"Content-Type": content_type})
+        return json.loads(payload)
+
+    def send_media(self, recipient, data, filename=None, message="", mime_type=None, client_ref=None, agent=None,
+                   signature=None, quoted_message_id=None):
+        """Sends file bytes as an image, video, audio or document message, depending on their content.
+        The file is sent base64-encoded, which the bridge accepts up to 100 MB."""
+        body = {"recipient": recipient, "message": message, "media": base64.b64encode(data).decode()}
+        if filename:
+            body["filename"] = filename
+        if mime_type:
+            body["mime_type"] = mime_type
+        if client_ref:
+            body["client_ref"] = client_ref
+        if agent:
+            body["agent"] = agent
+        if signature is not None:
+            body["signature"] = signature
+        if quoted_message_id:
+            body["quoted_message_id"] = quoted_message_id
+        return self._json("POST", "/send/media", body)
+
+    def find_messages_by_client_ref(self, client_ref):
+        """Returns the messages sent with a client reference, newest first."""
+        return self._json("GET", "/messages", query={"client_ref": client_ref})
+
+    def get_thread(self, message_id, chat_jid=None):
+        """Returns the quoted-reply thread around a message; chat_jid is needed if the ID occurs in several chats."""
+        query = {"chat_jid": chat_jid} if chat_jid else None
+        return self._json("GET", f"/messages/{urllib.parse.quote(message_id, safe='')}/thread", query=query)
+
+    def get_message_receipts(self, message_id, chat_jid=None):
+        """Returns the status of a sent message and every delivered, read and played receipt for it."""
+        query = {"chat_jid": chat_jid} if chat_jid else None
+        return self._json("GET", f"/messages/{urllib.parse.quote(message_id, safe='')}/receipts", query=query)
+
+    def get_message_status(self, message_id, chat_jid=None):
+        """Returns whether a message is queued, sent or failed, by its outbox_id or WhatsApp message ID."""
+        query = {"chat_jid": chat_jid} if chat_jid else None
+        return self._json("GET", f"/messages/{urllib.parse.quote(message_id, safe='')}/status", query=query)
+
+    def download_media(self, message_id, chat_jid):
+        return self._json("POST", "/download", {"message_id": message_id, "chat_jid": chat_jid})
+
+    def get_media(self, message_id, chat_jid):
+        """Returns the media content as bytes."""
+        _, _, payload = self._request("GET", f"/media/{urllib.parse.quote(message_id)}", {"chat_jid": chat_jid})
+        return payload
+
+    def get_media_url(self, message_id, chat_jid):
+        """Returns a short-lived signed URL of the media; the bridge must keep media in Supabase Storage."""
+        return self._json("GET", f"/media/{urllib.parse.quote(message_id)}", query={"chat_jid": chat_jid, "redirect": "false"})
+
+    def list_chats(self, label=None):
+        return self._json("GET", "/chats", query={"label": label} if label else None)
+
+    def list_messages(self, chat_jid, limit=None):
+        query = {"limit": limit} if limit else None
+        return self._json("GET", self._chat_path(chat_jid, "messages"), query=query)
+
+    def search_messages(self, query, chat_jid=None, limit=None, sender=None, since=None, until=None, type=None,
+                        offset=None):
+        """Finds messages whose text or file name matches query, newest first. since and until are datetimes;
+        type is "text" or a media type. Page through results by raising offset until a page is shorter than limit."""
+        params = {"q": query}
+        for key, value in (("chat_jid", chat_jid), ("sender", sender), ("type", type), ("limit", limit),
+                           ("offset", offset)):
+            if value:
+                params[key] = value
+        if since:
+            params["since"] = since.isoformat()
+        if until:
+            params["until"] = until.isoformat()
+        return self._json("GET", "/search", query=params)
+
+    def semantic_search(self, query, chat_jid=None, since=None, until=None, limit=None):
+        """Finds the messages closest in meaning to query, best match first, each with a score.
+        Needs embeddings enabled on the bridge. since and until are datetimes."""
+        params = {"q": query}
+        if chat_jid:
+            params["chat_jid"] = chat_jid
+        if since:
+            params["since"] = since.isoformat()
+        if until:
+            params["until"] = until.isoformat()
+        if limit:
+            params["limit"] = limit
+        return self._json("GET", "/search/semantic", query=params)
+
+    def get_draft(self, chat_jid):
+        """Returns the draft for a chat, or None if there is none."""
+        try:
+            return self._json("GET", self._chat_path(chat_jid, "draft"))
+        except BridgeAPIError as err:
+            if err.status == 404:
+                return None
+            raise
+
+    def save_draft(self, chat_jid, content, updated_by=None):
+        body = {"content": content}
+        if updated_by:
+            body["updated_by"] = updated_by
+        return self._json("PUT", self._chat_path(chat_jid, "draft"), body)
+
+    def delete_draft(self, chat_jid):
+        self._json("DELETE", self._chat_path(chat_jid, "draft"))
+
+    def get_assignment(self, chat_jid):
+        """Returns the queue a chat is assigned to, or None if it is not assigned."""
+        try:
+            return self._json("GET", self._chat_path(chat_jid, "assignment"))
+        except BridgeAPIError as err:
+            if err.status == 404:
+                return None
+            raise
+
+    def assign_chat(self, chat_jid, queue):
+        return self._json("PUT", self._chat_path(chat_jid, "assignment"), {"queue": queue})
+
+    def unassign_chat(self, chat_jid):
+        self._json("DELETE", self._chat_path(chat_jid, "assignment"))
+
+    def resolve_chat(self, chat_jid):
+        """Marks a chat's assignment resolved, stopping its SLA resolution clock."""
+        return self._json("POST", self._chat_path(chat_jid, "resolve"))
+
+    def list_assignments(self, queue=None, state=None):
+        """Lists assignments, optionally of one queue and only the "open" or "resolved" ones."""
+        query = {}
+        if queue:
+            query["queue"] = queue
+        if state:
+            query["state"] = state
+        return self._json("GET", "/assignments", query=query or None)
+
+    def list_handoffs(self):
+        """Lists the chats handed to an agent, longest waiting first."""
+        return self._json("GET", "/handoffs")
+
+    def get_handoff(self, chat_jid):
+        """Returns the handoff of a chat, or None if the bot isn't paused there."""
+        try:
+            return self._json("GET", self._chat_path(chat_jid, "handoff"))
+        except BridgeAPIError as err:
+            if err.status == 404:
+                return None
+            raise
+
+    def resume_handoff(self, chat_jid):
+        """Gives a handed-over chat back to the bot."""
+        self._json("DELETE", self._chat_path(chat_jid, "handoff"))
+
+    def get_bot_status(self, chat_jid):
+        return self._json("GET", self._chat_path(chat_jid, "bot"))
+
+    def pause_bot(self, chat_jid, resume_after=None):
+        """Stops flows, routing rules and classification in a chat; resume_after (e.g. "8h") lifts the pause by itself."""
+        body = {"paused": True}
+        if resume_after:
+            body["resume_after"] = resume_after
+        return self._json("PUT", self._chat_path(chat_jid, "bot"), body)
+
+    def resume_bot(self, chat_jid):
+        return self._json("PUT", self._chat_path(chat_jid, "bot"), {"paused": False})
+
+    def list_flows(self):
+        return self._json("GET", "/flows")
+
+    def get_flow_session(self, chat_jid):
+        """Returns the flow a chat is in, or None if it is not in one."""
+        return self._json("GET", self._chat_path(chat_jid, "flow"))
+
+    def start_flow(self, chat_jid, flow_id, step=None, variables=None):
+        """Puts a chat into a flow. Returns None if the flow finished without waiting for an answer."""
+        body = {"flow_id": flow_id}
+        if step:
+            body["step"] = step
+        if variables:
+            body["variables"] = variables
+        return self._json("PUT", self._chat_path(chat_jid, "flow"), body)
+
+    def advance_flow(self, chat_jid, input):
+        """Answers the current step on the contact's behalf. Returns None once the flow has finished."""
+        return self._json("POST", self._chat_path(chat_jid, "flow"), {"input": input})
+
+    def cancel_flow(self, chat_jid):
+        self._json("DELETE", self._chat_path(chat_jid, "flow"))
+
+    def pair_phone(self, phone):
+        """Requests a code linking the bridge to the account of a phone number, while the bridge shows QR codes."""
+        return self._json("POST", "/pair/phone", {"phone": phone})
+
+    def get_pairing_history(self, limit=None):
+        """Lists the most recent attempts to link the account, newest first."""
+        return self._json("GET", "/pairing/history", query={"limit": limit} if limit else None)
+
+    def get_session_lock(self):
+        """Reports whether sending is locked after a possible session takeover."""
+        return self._json("GET", "/session/lock")
+
+    def acknowledge_session_lock(self, acknowledged_by):
+        """Lifts the lock so the bridge can send, reconnect and pair again."""
+        return self._json("POST", "/session/lock/acknowledge", {"acknowledged_by": acknowledged_by})
+
+    def get_usage(self, period=None):
+        """Usage of this client's API key in a month (YYYY-MM), the current one by default."""
+        query = {"period": period} if period else None
+        return self._json("GET", "/usage", query=query)
+
+    def list_api_keys(self):
+        """Issued API keys, newest first; needs an admin key."""
+        return self._json("GET", "/keys")
+
+    def create_api_key(self, name, scopes):
+        """Issues a key with scopes (read, send, write, admin); the result's "key" is only shown now."""
+        return self._json("POST", "/keys", {"name": name, "scopes": list(scopes)})
+
+    def revoke_api_key(self, key_id):
+        self._json("DELETE", f"/keys/{urllib.parse.quote(key_id)}")
+
+    def list_audit_exports(self, limit=None):
+        """Days of outbound messages uploaded to the audit bucket, newest first."""
+        query = {"limit": limit} if limit else None
+        return self._json("GET", "/admin/audit-exports", query=query)
+
+    def list_dashboard_logins(self, email=None, limit=None):
+        """Sign-ins to the dashboard with their location, newest first."""
+        query = {}
+        if email:
+            query["email"] = email
+        if limit:
+            query["limit"] = limit
+        return self._json("GET", "/admin/logins", query=query or None)
+
+    def list_dashboard_users(self):
+        """Local dashboard users, by email."""
+        return self._json("GET", "/admin/users")
+
+    def create_dashboard_user(self, email, password=None, admin=False):
+        """Without a password the user signs in with a passkey."""
+        body = {"email": email, "admin": admin}
+        if password:
+            body["password"] = password
+        return self._json("POST", "/admin/users", body)
+
+    def update_dashboard_user(self, user_id, password=None, admin=None):
+        """A new password signs the user out everywhere."""
+        body = {}
+        if password:
+            body["password"] = password
+        if admin is not None:
+            body["admin"] = admin
+        return self._json("PATCH", f"/admin/users/{urllib.parse.quote(user_id)}", body)
+
+    def delete_dashboard_user(self, user_id):
+        self._json("DELETE", f"/admin/users/{urllib.parse.quote(user_id)}")
+
+    def list_tenants(self):
+        return self._json("GET", "/admin/tenants")
+
+    def create_tenant(self, tenant_id, name, key_ids=None, users=None):
+        body = {"id": tenant_id, "name": name, "key_ids": key_ids or [], "users": users or []}
+        return self._json("POST", "/admin/tenants", body)
+
+    def update_tenant(self, tenant_id, name, key_ids=None, users=None):
+        """Replaces the name, key IDs and users of a tenant."""
+        body = {"id": tenant_id, "name": name, "key_ids": key_ids or [], "users": users or []}
+        return self._json("PUT", f"/admin/tenants/{urllib.parse.quote(tenant_id)}", body)
+
+    def delete_tenant(self, tenant_id):
+        """Deletes a tenant; its keys keep working, unassigned."""
+        self._json("DELETE", f"/admin/tenants/{urllib.parse.quote(tenant_id)}")
+
+    def suspend_tenant(self, tenant_id, reason=""):
+        """Refuses API requests made with the tenant's keys."""
+        return self._json("POST", f"/admin/tenants/{urllib.parse.quote(tenant_id)}/suspend", {"reason": reason})
+
+    def resume_tenant(self, tenant_id):
+        return self._json("POST", f"/admin/tenants/{urllib.parse.quote(tenant_id)}/resume")
+
+    def list_accounts(self):
+        return self._json("GET", "/accounts")
+
+    def create_account(self, account_id, name=""):
+        """Adds an account; scan the QR code at its qr_url to link it."""
+        return self._json("POST", "/accounts", {"id": account_id, "name": name})
+
+    def get_account(self, account_id):
+        return self._json("GET", f"/accounts/{urllib.parse.quote(account_id)}")
+
+    def delete_account(self, account_id):
+        """Unlinks an account's device and removes the account."""
+        self._json("DELETE", f"/accounts/{urllib.parse.quote(account_id)}")
+
+    def pair_account_phone(self, account_id, phone):
+        """Requests a code linking an account to a phone number, while the account shows QR codes."""
+        return self._json("POST", f"/accounts/{urllib.parse.quote(account_id)}/pair-phone", {"phone": phone})
+
+    def send_account_message(self, account_id, recipient, message="", media_path=None, client_ref=None, agent=None):
+        body = {"recipient": recipient, "message": message}
+        if media_path:
+            body["media_path"] = media_path
+        if client_ref:
+            body["client_ref"] = client_ref
+        if agent:
+            body["agent"] = agent
+        return self._json("POST", f"/accounts/{urllib.parse.quote(account_id)}/send", body)
+
+    def list_account_chats(self, account_id):
+        return self._json("GET", f"/accounts/{urllib.parse.quote(account_id)}/chats")
+
+    def list_account_messages(self, account_id, chat_jid, limit=None):
+        query = {"limit": limit} if limit else None
+        path = f"/accounts/{urllib.parse.quote(account_id)}" + self._chat_path(chat_jid, "messages")
+        return self._json("GET", path, query=query)
+
+    def scan_orphan_media(self, remove=False):
+        """Lists downloaded media no stored message refers to, and removes it if remove is set."""
+        return self._json("POST" if remove else "GET", "/admin/media/gc")
+
+    def get_maintenance(self):
+        """Reports whether sends and event processing are paused."""
+        return self._json("GET", "/admin/maintenance")
+
+    def set_maintenance(self, enabled, reason=""):
+        """Refuses sends and closes the WhatsApp connection, or resumes and reconnects."""
+        return self._json("POST", "/admin/maintenance", {"enabled": enabled, "reason": reason})
+
+    def get_log_level(self):
+        return self._json("GET", "/admin/loglevel")
+
+    def set_log_level(self, level, duration_seconds=None):
+        """Changes the log level (debug, info, warn or error) for duration_seconds, after which it falls
+        back to LOG_LEVEL; 0 keeps it until changed again, None uses LOG_LEVEL_TIMEOUT_MINUTES."""
+        body = {"level": level}
+        if duration_seconds is not None:
+            body["duration_seconds"] = duration_seconds
+        return self._json("POST", "/admin/loglevel", body)
+
+    def download_diagnostics(self, log_lines=None):
+        """Returns the redacted diagnostic bundle as zip bytes, optionally with only the last log_lines log lines."""
+        query = {"log_lines": str(log_lines)} if log_lines else None
+        _, _, payload = self._request("GET", "/admin/diagnostics", query)
+        return payload
+
+    def get_metadata(self, chat_jid):
+        return self._json("GET", self._chat_path(chat_jid, "metadata"))
+
+    def replace_metadata(self, chat_jid, notes="", metadata=None):
+        """Replaces the notes and all metadata of a chat or contact."""
+        body = {"notes": notes, "metadata": metadata or {}}
+        return self._json("PUT", self._chat_path(chat_jid, "metadata"), body)
+
+    def update_metadata(self, chat_jid, notes=None, metadata=None):
+        """Changes the notes or some metadata keys; a None value removes the key."""
+        body = {}
+        if notes is not None:
+            body["notes"] = notes
+        if metadata is not None:
+            body["metadata"] = metadata
+        return self._json("PATCH", self._chat_path(chat_jid, "metadata"), body)
+
+    def get_labels(self, chat_jid):
+        return self._json("GET", self._chat_path(chat_jid, "labels"))["labels"]
+
+    def set_labels(self, chat_jid, labels):
+        """Replaces the labels of a chat or contact and returns them as saved."""
+        return self._json("PUT", self._chat_path(chat_jid, "labels"), {"labels": labels})["labels"]
+
+    def delete_metadata(self, chat_jid):
+        self._json("DELETE", self._chat_path(chat_jid, "metadata"))
+
+    def get_contact_attributes(self, contact):
+        """Returns the name, language and custom fields of a contact, given as a phone number or JID."""
+        return self._json("GET", self._contact_attributes_path(contact))
+
+    def replace_contact_attributes(self, contact, name=None, language=None, fields=None):
+        body = {"fields": fields or {}}
+        if name:
+            body["name"] = name
+        if language:
+            body["language"] = language
+        return self._json("PUT", self._contact_attributes_path(contact), body)
+
+    def update_contact_attributes(self, contact, name=None, language=None, fields=None):
+        """Changes some attributes of a contact; a field set to None is removed."""
+        body = {}
+        if name is not None:
+            body["name"] = name
+        if language is not None:
+            body["language"] = language
+        if fields:
+            body["fields"] = fields
+        return self._json("PATCH", self._contact_attributes_path(contact), body)
+
+    def delete_contact_attributes(self, contact):
+        self._json("DELETE", self._contact_attributes_path(contact))
+
+    @staticmethod
+    def _contact_attributes_path(contact):
+        return f"/contacts/{urllib.parse.quote(contact, safe='@')}/attributes"
+
+    def list_contact_attributes(self, language=None, limit=None, offset=None):
+        query = {key: value for key, value in (("language", language), ("limit", limit), ("offset", offset)) if value}
+        return self._json("GET", "/contacts/attributes", query=query or None)
+
+    def import_contact_attributes(self, rows, replace=False):
+        """Imports the attributes of up to 10,000 contacts, merged into theirs unless replace is set. rows is
+        a list of {"recipient", "name", "language", "fields"} dicts, or CSV text with a header row and a
+        phone, jid or recipient column."""
+        query = {"replace": "true"} if replace else None
+        if not isinstance(rows, str):
+            return self._json("POST", "/contacts/attributes/import", list(rows), query=query)
+        _, _, payload = self._request("POST", "/contacts/attributes/import", query, rows.encode(),
+                                      {"Content-Type": "text/csv"})
+        return json.loads(payload)
+
+    def find_by_metadata(self, key, value):
+        """Returns the JIDs where a metadata key has the given value."""
+        return self._json("GET", "/metadata", query={"key": key, "value": value})["jids"]
+
+    def get_avatar(self, chat_jid):
+        """Returns the profile picture of a contact or group, or None if there is none."""
+        try:
+            return self._json("GET", self._chat_path(chat_jid, "avatar"))
+        except BridgeAPIError as err:
+            if err.status == 404:
+                return None
+            raise
+
+    def get_presence(self, contact_jid):
+        """Subscribes to a contact's presence and returns their last known status."""
+        path = f"/contacts/{urllib.parse.quote(contact_jid, safe='@')}/presence"
+        return self._json("GET", path)
+
+    def get_stored_presence(self, contact_jid):
+        """Returns a contact's last known status and last-seen time without subscribing."""
+        return self._json("GET", f"/presence/{urllib.parse.quote(contact_jid, safe='@')}")
+
+    def subscribe_presence(self, contact_jid):
+        """Subscribes to a contact's presence, renewed on every connect until removed."""
+        return self._json("POST", f"/presence/{urllib.parse.quote(contact_jid, safe='@')}/subscribe")
+
+    def unsubscribe_presence(self, contact_jid):
+        """Stops renewing a contact's presence subscription."""
+        self._json("DELETE", f"/presence/{urllib.parse.quote(contact_jid, safe='@')}/subscribe")
+
+    def get_own_presence(self):
+        """Returns whether the account is online or offline."""
+        return self._json("GET", "/presence")
+
+    def set_own_presence(self, status):
+        """Marks the account online or offline."""
+        return self._json("POST", "/presence", {"status": status})
+
+    def send_chat_state(self, chat_jid, state):
+        """Shows the typing (composing) or recording indicator in a chat, or clears it (paused)."""
+        self._json("POST", self._chat_path(chat_jid, "typing"), {"state": state})
+
+    def get_contact_overview(self, contact_jid, days=None):
+        """Returns the profile, shared groups, message history, labels, notes and recent media of a contact."""
+        path = f"/contacts/{urllib.parse.quote(contact_jid, safe='@')}/overview"
+        return self._json("GET", path, query={"days": days} if days else None)
+
+    def export_chat(self, chat_jid, start=None, end=None, thumbnails=True):
+        """Returns a PDF transcript as bytes. start/end are datetimes."""
+        query = {"format": "pdf"}
+        if start:
+            query["from"] = start.isoformat()
+        if end:
+            query["to"] = end.isoformat()
+        if not thumbnails:
+            query["thumbnails"] = "false"
+        _, _, payload = self._request("GET", self._chat_path(chat_jid, "export"), query)
+        return payload
+
+    def create_upload(self, filename, size):
+        return self._json("POST", "/uploads", {"filename": filename, "size": size})
+
+    def get_upload(self, upload_id):
+        return self._json("GET", f"/uploads/{upload_id}")
+
+    def append_upload_chunk(self, upload_id, offset, chunk):
+        """Sends the next chunk at offset and returns the new offset."""
+        headers = {"Upload-Offset": str(offset), "Content-Type": "application/offset+octet-stream"}
+        _, resp_headers, _ = self._request("PATCH", f"/uploads/{upload_id}", body=chunk, headers=headers)
+        return int(resp_headers["Upload-Offset"])
+
+    def delete_upload(self, upload_id):
+        self._json("DELETE", f"/uploads/{upload_id}")
+
+    def send_upload(self, upload_id, recipient, message="", client_ref=None, agent=None, signature=None,
+                    quoted_message_id=None):
+        body = {"recipient": recipient, "message": message}
+        if client_ref:
+            body["client_ref"] = client_ref
+        if agent:
+            body["agent"] = agent
+        if signature is not None:
+            body["signature"] = signature
+        if quoted_message_id:
+            body["quoted_message_id"] = quoted_message_id
+        return self._json("POST", f"/uploads/{upload_id}/send", body)
+
+    def erase_contact(self, phone=None, jid=None, confirm=False):
+        return self._json("POST", "/gdpr/erase", {"phone": phone or "", "jid": jid or "", "confirm": confirm})
+
+    def verify_chain(self, chat_jid):
+        return self._json("GET", self._chat_path(chat_jid, "chain"))
+
+    def check_chain_hashes(self, chat_jid, hashes):
+        """Compares hashes from an exported transcript, a list of {"seq": ..., "hash": ...}, with the stored chain."""
+        return self._json("POST", self._chat_path(chat_jid, "chain"), {"hashes": hashes})
+
+    def get_legal_hold(self, chat_jid):
+        """Returns the legal hold of a chat, or None if it is not on hold."""
+        try:
+            return self._json("GET", self._chat_path(chat_jid, "hold"))
+        except BridgeAPIError as err:
+            if err.status == 404:
+                return None
+            raise
+
+    def place_legal_hold(self, chat_jid, reason, placed_by=None):
+        body = {"reason": reason}
+        if placed_by:
+            body["placed_by"] = placed_by
+        return self._json("PUT", self._chat_path(chat_jid, "hold"), body)
+
+    def release_legal_hold(self, chat_jid):
+        self._json("DELETE", self._chat_path(chat_jid, "hold"))
+
+    def list_legal_holds(self):
+        return self._json("GET", "/legal-holds")
+
+    def get_legal_hold_audit(self, chat_jid=None, limit=None):
+        """Returns the newest legal hold audit entries, of one chat or of all chats."""
+        query = {}
+        if chat_jid:
+            query["chat_jid"] = chat_jid
+        if limit:
+            query["limit"] = limit
+        return self._json("GET", "/legal-holds/audit", query=query or None)
+
+    def list_groups(self):
+        """Returns the groups the account is in, by name."""
+        return self._json("GET", "/groups")
+
+    def get_group_events(self, group_jid, start=None, end=None, types=None, participant=None, limit=None):
+        """Returns a group's membership, subject and settings changes, newest first.
+        start/end are datetimes, types a list such as ["participants_removed"]."""
+        query = {}
+        if start:
+            query["from"] = start.isoformat()
+        if end:
+            query["to"] = end.isoformat()
+        if types:
+            query["type"] = ",".join(types)
+        if participant:
+            query["participant"] = participant
+        if limit:
+            query["limit"] = limit
+        group = urllib.parse.quote(group_jid, safe="@")
+        return self._json("GET", f"/groups/{group}/events", query=query or None)
+
+    def list_activity(self, types=None, chat_jid=None, before=None, limit=None):
+        """Returns logged events of all chats, newest first.
+        types is a list such as ["message.*", "connection.*"], before a datetime to page back from."""
+        query = {}
+        if types:
+            query["types"] = ",".join(types)
+        if chat_jid:
+            query["chat_jid"] = chat_jid
+        if before:
+            query["before"] = before.isoformat()
+        if limit:
+            query["limit"] = limit
+        return self._json("GET", "/activity", query=query or None)
+
+    @staticmethod
+    def _analytics_query(start, end, chat_jid, agent, tz, fmt, label=None):
+        query = {"format": fmt}
+        if start:
+            query["from"] = start.isoformat()
+        if end:
+            query["to"] = end.isoformat()
+        if chat_jid:
+            query["chat_jid"] = chat_jid
+        if agent:
+            query["agent"] = agent
+        if tz:
+            query["tz"] = tz
+        if label:
+            query["label"] = label
+        return query
+
+    def get_analytics(self, report, start=None, end=None, chat_jid=None, agent=None, tz=None, label=None):
+        """Returns a report: "messages", "delivery", "response-times", "sentiment",
+        "sentiment-chats" or "labels". start/end are dates."""
+        query = self._analytics_query(start, end, chat_jid, agent, tz, "json", label)
+        return self._json("GET", f"/analytics/{report}", query=query)
+
+    def export_analytics(self, report, start=None, end=None, chat_jid=None, agent=None, tz=None, label=None):
+        """Returns a report as CSV bytes."""
+        query = self._analytics_query(start, end, chat_jid, agent, tz, "csv", label)
+        _, _, payload = self._request("GET", f"/analytics/{report}", query)
+        return payload
+
+    def export_messages(self, since=None, until=None):
+        """Yields every stored message, oldest first, as the export streams in.
+        since and until are datetimes limiting the export."""
+        query = {"format": "jsonl"}
+        if since:
+            query["since"] = since.isoformat()
+        if until:
+            query["until"] = until.isoformat()
+        with self._open("GET", "/export/all", query) as resp:
+            for line in resp:
+                if line.strip():
+                    yield json.loads(line)
+
+    def list_mutes(self):
+        """Returns the chats muted with the !mute chat command, most recently muted first."""
+        return self._json("GET", "/mutes")
+
+    def health(self):
+        return self._json("GET", "/health")
+
+    def version(self):
+        """Returns the version of the bridge and, with UPDATE_CHECK enabled, the latest release."""
+        return self._json("GET", "/version")
+
+    def database_status(self):
+        return self._json("GET", "/db/status")
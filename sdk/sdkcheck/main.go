@@ -0,0 +1,246 @@
+// Command sdkcheck fails when the client SDKs fall behind whatsapp-bridge/openapi.yaml.
+//
+//	go run . [-config ../sdkcheck.yaml]
+//
+// Every operationId must have a method in each client, named by the client's convention
+// (Go SendMessage, TypeScript sendMessage, Python send_message) unless the config maps it to
+// another name or marks it "-" for a client that doesn't wrap it. Every schema must have a Go
+// struct and a TypeScript interface of the same name carrying all of its properties; Python
+// returns plain dicts, so only its methods are checked. The config may not name operations or
+// schemas the spec no longer has, so it can't go stale either.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// skip marks an operation or schema a client deliberately leaves out
+const skip = "-"
+
+// Config is the mapping between the spec and the clients, see sdkcheck.yaml
+type Config struct {
+	Spec    string              `yaml:"spec"`
+	Clients map[string][]string `yaml:"clients"`
+	// Operations maps an operationId to the method name per client, where it isn't the default
+	Operations map[string]map[string]string `yaml:"operations"`
+	// Schemas maps a schema to the type name per client, where it isn't the schema name
+	Schemas map[string]map[string]string `yaml:"schemas"`
+}
+
+// spec is the part of the OpenAPI document that is checked
+type spec struct {
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]yaml.Node `yaml:"properties"`
+		} `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// client describes how a client's methods and types are found in its source
+type client struct {
+	method func(operationID string) string
+	// methods matches a method declaration; the first group is its name
+	methods *regexp.Regexp
+	// typeBlock returns the body of a type declaration, or false if it isn't declared
+	typeBlock func(source, name string) (string, bool)
+	// hasProperty reports whether a type body declares a JSON property
+	hasProperty func(body, property string) bool
+}
+
+var clients = map[string]client{
+	"go": {
+		method:  func(id string) string { return strings.ToUpper(id[:1]) + id[1:] },
+		methods: regexp.MustCompile(`(?m)^func \(c \*Client\) (\w+)\(`),
+		typeBlock: func(source, name string) (string, bool) {
+			return block(source, `(?ms)^type `+name+` struct \{\n(.*?)^\}`)
+		},
+		hasProperty: func(body, property string) bool {
+			return strings.Contains(body, `json:"`+property+`"`) || strings.Contains(body, `json:"`+property+`,`)
+		},
+	},
+	"typescript": {
+		method:  func(id string) string { return id },
+		methods: regexp.MustCompile(`(?m)^  (?:async\s+)?\*?(\w+)\(`),
+		typeBlock: func(source, name string) (string, bool) {
+			return block(source, `(?ms)^export interface `+name+`(?: extends [\w<>, ]+)? \{\n(.*?)^\}`)
+		},
+		hasProperty: func(body, property string) bool {
+			return regexp.MustCompile(`(?m)^\s+"?` + regexp.QuoteMeta(property) + `"?\??:`).MatchString(body)
+		},
+	},
+	"python": {
+		method:  snakeCase,
+		methods: regexp.MustCompile(`(?m)^    def (\w+)\(`),
+	},
+}
+
+// block returns the first group of a pattern matched against source
+func block(source, pattern string) (string, bool) {
+	match := regexp.MustCompile(pattern).FindStringSubmatch(source)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// snakeCase turns an operationId into a Python method name; acronyms stay one word,
+// so listAPIKeys becomes list_api_keys
+func snakeCase(id string) string {
+	runes := []rune(id)
+	var out strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				out.WriteByte('_')
+			}
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+	return out.String()
+}
+
+func main() {
+	configPath := flag.String("config", "../sdkcheck.yaml", "mapping between the spec and the clients")
+	flag.Parse()
+
+	problems, err := check(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sdkcheck:", err)
+		os.Exit(2)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("\n%d problems: update the SDKs (or sdkcheck.yaml) to match openapi.yaml\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Println("SDKs match openapi.yaml")
+}
+
+// check compares the spec with the clients and lists every mismatch
+func check(configPath string) ([]string, error) {
+	var config Config
+	if err := readYAML(configPath, &config); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(configPath)
+
+	var doc spec
+	if err := readYAML(filepath.Join(dir, config.Spec), &doc); err != nil {
+		return nil, err
+	}
+
+	sources := map[string]string{}
+	for name, files := range config.Clients {
+		if _, ok := clients[name]; !ok {
+			return nil, fmt.Errorf("unknown client %s", name)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				return nil, err
+			}
+			sources[name] += string(data) + "\n"
+		}
+	}
+
+	var problems []string
+	operations := map[string]bool{}
+	for path, methods := range doc.Paths {
+		for method, node := range methods {
+			var op struct {
+				OperationID string `yaml:"operationId"`
+			}
+			if node.Kind != yaml.MappingNode || node.Decode(&op) != nil || op.OperationID == "" {
+				continue
+			}
+			operations[op.OperationID] = true
+			for name, source := range sources {
+				want := config.Operations[op.OperationID][name]
+				if want == skip {
+					continue
+				}
+				if want == "" {
+					want = clients[name].method(op.OperationID)
+				}
+				if !hasMethod(source, clients[name].methods, want) {
+					problems = append(problems, fmt.Sprintf("%s: no method %s for %s (%s %s)", name, want, op.OperationID, strings.ToUpper(method), path))
+				}
+			}
+		}
+	}
+
+	for schema, definition := range doc.Components.Schemas {
+		for name, source := range sources {
+			c := clients[name]
+			if c.typeBlock == nil {
+				continue
+			}
+			want := config.Schemas[schema][name]
+			if want == skip {
+				continue
+			}
+			if want == "" {
+				want = schema
+			}
+			body, ok := c.typeBlock(source, want)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: no type %s for schema %s", name, want, schema))
+				continue
+			}
+			for property := range definition.Properties {
+				if !c.hasProperty(body, property) {
+					problems = append(problems, fmt.Sprintf("%s: %s has no %s property of schema %s", name, want, property, schema))
+				}
+			}
+		}
+	}
+
+	for id := range config.Operations {
+		if !operations[id] {
+			problems = append(problems, fmt.Sprintf("sdkcheck.yaml: operation %s is not in the spec", id))
+		}
+	}
+	for schema := range config.Schemas {
+		if _, ok := doc.Components.Schemas[schema]; !ok {
+			problems = append(problems, fmt.Sprintf("sdkcheck.yaml: schema %s is not in the spec", schema))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// hasMethod reports whether a client source declares a method
+func hasMethod(source string, pattern *regexp.Regexp, name string) bool {
+	for _, match := range pattern.FindAllStringSubmatch(source, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func readYAML(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+#!/bin/sh
+# Runs the SDK smoke tests against a running bridge.
+# Usage: BRIDGE_URL=http://localhost:8080 BRIDGE_API_KEY=... ./smoke-test.sh [go|typescript|python]...
+set -e
+cd "$(dirname "$0")"
+
+export BRIDGE_URL="${BRIDGE_URL:-http://localhost:8080}"
+sdks="${*:-go typescript python}"
+
+for sdk in $sdks; do
+	echo "== $sdk SDK against $BRIDGE_URL"
+	case "$sdk" in
+	go) (cd go && go run ./cmd/smoke) ;;
+	typescript) (cd typescript && npm install --silent && npm run --silent build && npm run --silent smoke) ;;
+	python) (cd python && python3 smoke.py) ;;
+	*) echo "unknown SDK: $sdk" >&2; exit 1 ;;
+	esac
+done
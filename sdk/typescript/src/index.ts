@@ -0,0 +1,1985 @@
+// Thin TypeScript client for the WhatsApp bridge REST API (/api/v1).
+// Each method maps to one operation in whatsapp-bridge/openapi.yaml.
+
+export interface SendMessageRequest {
+  recipient: string;
+  message?: string;
+  media_path?: string;
+  /** Your own ID for the message, echoed in status events */
+  client_ref?: string;
+  /** Dashboard user sending the message */
+  agent?: string;
+  /** Overrides the bridge's AGENT_SIGNATURE setting */
+  signature?: boolean;
+  /** Sends a stored template filled in with variables instead of message */
+  template_id?: string;
+  variables?: Record<string, string>;
+  /** Hands the message to the outbox, which sends it once connected and retries failures; defaults to OUTBOX_ENABLED */
+  queue?: boolean;
+  /** Sends the message as a reply to a stored message of the same chat */
+  quoted_message_id?: string;
+}
+
+export interface SendMessageResponse {
+  success: boolean;
+  message: string;
+  message_id?: string;
+  client_ref?: string;
+  agent?: string;
+  error_code?: SendErrorCode;
+  retryable?: boolean;
+  state?: "server_ack" | "delivered" | "queued";
+  timed_out?: boolean;
+  /** Identifies a queued message, see getMessageStatus */
+  outbox_id?: string;
+}
+
+export interface MessageReceipts {
+  message_id: string;
+  chat_jid: string;
+  status: "received" | "sent" | "delivered" | "read" | "played";
+  status_at?: string;
+  /** Oldest first; in groups every member sends their own */
+  receipts: { recipient: string; status: "delivered" | "read" | "played"; timestamp: string }[];
+}
+
+export interface MessageStatus {
+  /** Outbox ID of a queued message, else the WhatsApp message ID */
+  id: string;
+  message_id?: string;
+  chat_jid?: string;
+  client_ref?: string;
+  status: "queued" | "sending" | "sent" | "failed";
+  /** Receipt status once sent */
+  delivery?: "sent" | "delivered" | "read" | "played";
+  attempts: number;
+  next_attempt_at?: string;
+  error?: string;
+  error_code?: SendErrorCode;
+  queued_at?: string;
+  sent_at?: string;
+}
+
+export type SendErrorCode =
+  | "recipient_not_on_whatsapp"
+  | "rate_limited"
+  | "media_too_large"
+  | "not_connected"
+  | "server_error"
+  | "invalid_request"
+  | "media_blocked"
+  | "sending_disabled"
+  | "not_group_member"
+  | "group_admins_only";
+
+export interface ReactRequest {
+  chat_jid: string;
+  message_id: string;
+  /** Replaces the account's reaction; empty removes it */
+  emoji: string;
+}
+
+export interface SendVoiceRequest {
+  recipient: string;
+  client_ref?: string;
+  agent?: string;
+  /** Sends the voice note as a reply to a stored message of the chat */
+  quoted_message_id?: string;
+}
+
+export interface SendMediaRequest {
+  recipient: string;
+  /** Caption */
+  message?: string;
+  client_ref?: string;
+  agent?: string;
+  signature?: boolean;
+  /** Sends the file as a reply to a stored message of the chat */
+  quoted_message_id?: string;
+}
+
+export interface PaymentRequest {
+  recipient: string;
+  amount: number;
+  /** ISO 4217 code such as INR or BRL */
+  currency: string;
+  note?: string;
+  /** Defaults to a week */
+  expires_in_hours?: number;
+  client_ref?: string;
+  agent?: string;
+}
+
+/** Set either send_at or local_time */
+export interface ScheduleRequest {
+  recipient: string;
+  message?: string;
+  media_path?: string;
+  client_ref?: string;
+  agent?: string;
+  /** RFC 3339 time */
+  send_at?: string;
+  /** HH:MM in the recipient's timezone */
+  local_time?: string;
+  /** YYYY-MM-DD day of local_time; without it the next occurrence is used */
+  date?: string;
+}
+
+/** Set either send_at or local_time */
+export interface CampaignRequest {
+  name: string;
+  recipients: string[];
+  message?: string;
+  media_path?: string;
+  agent?: string;
+  send_at?: string;
+  local_time?: string;
+  date?: string;
+  /** Spaces out recipients due at the same moment over this many minutes */
+  spread_minutes?: number;
+  /** Holds the messages until an admin approves the campaign */
+  require_approval?: boolean;
+  /** Sends a stored template instead of message, filled in from each recipient's contact attributes and variables */
+  template_id?: string;
+  variables?: Record<string, string>;
+}
+
+export interface ScheduledMessage {
+  id: string;
+  campaign_id?: string;
+  /** Recurring series the message is an occurrence of */
+  series_id?: string;
+  recipient: string;
+  chat_jid: string;
+  message?: string;
+  media_path?: string;
+  client_ref?: string;
+  agent?: string;
+  send_at: string;
+  /** Timezone a local_time was resolved in */
+  timezone: string;
+  timezone_source?: "metadata" | "country_code" | "default";
+  status: "pending" | "awaiting_approval" | "sending" | "sent" | "failed" | "canceled";
+  attempts: number;
+  message_id?: string;
+  error?: string;
+  error_code?: string;
+  created_at: string;
+  sent_at?: string;
+}
+
+export interface Campaign {
+  id: string;
+  name: string;
+  created_at: string;
+  /** Number of its messages in each status */
+  counts: Record<string, number>;
+  first_send_at?: string;
+  last_send_at?: string;
+  /** Set for campaigns that needed an admin's approval */
+  approval?: "pending" | "approved" | "rejected" | "withdrawn";
+  requested_by?: string;
+  reviewed_by?: string;
+  reviewed_at?: string;
+  review_note?: string;
+  /** Template the messages were filled in from */
+  template_id?: string;
+}
+
+export interface TextChange {
+  op: "equal" | "insert" | "delete";
+  text: string;
+}
+
+export interface CampaignPreview {
+  campaign: Campaign;
+  message?: string;
+  media_path?: string;
+  samples: {
+    recipient: string;
+    chat_jid: string;
+    send_at: string;
+    timezone: string;
+    /** Text the recipient will get */
+    rendered: string;
+    /** Word diff from the submitted message to rendered */
+    diff: TextChange[];
+  }[];
+}
+
+export interface RecurringRequest {
+  recipient: string;
+  message?: string;
+  media_path?: string;
+  client_ref?: string;
+  agent?: string;
+  /** Five-field cron expression, such as "0 9 * * mon", or a macro such as "@monthly" */
+  cron: string;
+  /** IANA timezone the cron runs in; the recipient's timezone when omitted */
+  timezone?: string;
+  /** Days (YYYY-MM-DD) without occurrences */
+  skip_dates?: string[];
+  /** Weekdays (sun to sat) without occurrences */
+  skip_weekdays?: string[];
+  /** Drop an occurrence on a skipped day, or move it to the next day that isn't skipped */
+  on_skip?: "skip" | "next_day";
+  /** Last day (YYYY-MM-DD) with occurrences */
+  end_date?: string;
+}
+
+export type RecurringUpdate = Partial<Omit<RecurringRequest, "recipient">>;
+
+export interface RecurringSeries {
+  id: string;
+  recipient: string;
+  chat_jid: string;
+  message?: string;
+  media_path?: string;
+  client_ref?: string;
+  agent?: string;
+  cron: string;
+  timezone: string;
+  timezone_source: "request" | "metadata" | "country_code" | "default";
+  skip_dates?: string[];
+  skip_weekdays?: string[];
+  on_skip: "skip" | "next_day";
+  end_date?: string;
+  status: "active" | "paused" | "ended" | "canceled";
+  next_send_at?: string;
+  occurrences: number;
+  created_at: string;
+  updated_at: string;
+  /** Next occurrences of an active series */
+  upcoming?: string[];
+}
+
+export interface MessageTemplateRequest {
+  name: string;
+  /** Message text with {{name}} placeholders; sent when no variant matches */
+  body: string;
+  /** Language tag of body, e.g. en */
+  language?: string;
+  /** The body in other languages by language tag, chosen by the recipient's language attribute */
+  variants?: Record<string, string>;
+  /** Example values of the placeholders, used by previews */
+  samples?: Record<string, string>;
+}
+
+export interface MessageTemplate extends MessageTemplateRequest {
+  id: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface TemplatePreviewRequest {
+  /** Contact whose attributes fill placeholders, over the template's samples */
+  recipient?: string;
+  /** Values of the placeholders, over the samples and the recipient's attributes */
+  variables?: Record<string, string>;
+  agent?: string;
+  signature?: boolean;
+}
+
+export interface TemplateIssue {
+  severity: "error" | "warning";
+  code: "undefined_placeholder" | "malformed_placeholder" | "empty" | "too_long" | "url_not_allowed" | "insecure_url";
+  message: string;
+  placeholder?: string;
+  url?: string;
+}
+
+export interface TemplatePreview {
+  template_id: string;
+  /** Language of the variant rendered */
+  language?: string;
+  /** Exactly what would be sent */
+  text: string;
+  length: number;
+  max_length: number;
+  placeholders: string[];
+  /** Placeholders without a value */
+  undefined: string[];
+  /** Variables no placeholder uses */
+  unused: string[];
+  issues: TemplateIssue[];
+  /** False when any issue is an error; such a template isn't sent */
+  ok: boolean;
+}
+
+export interface DownloadMediaRequest {
+  message_id: string;
+  chat_jid: string;
+}
+
+export interface MediaURL {
+  url: string;
+  filename: string;
+  expires_at: string;
+}
+
+export interface DownloadMediaResponse {
+  success: boolean;
+  message: string;
+  filename?: string;
+  path?: string;
+}
+
+export interface Chat {
+  jid: string;
+  name: string;
+  last_message_time: string;
+}
+
+export interface Message {
+  id: string;
+  chat_jid: string;
+  sender: string;
+  content: string;
+  timestamp: string;
+  is_from_me: boolean;
+  media_type?: "image" | "video" | "audio" | "document";
+  filename?: string;
+  /** Set on notices such as group participant changes, to the event type */
+  system_event?: string;
+  client_ref?: string;
+  agent?: string;
+  /** Number of people who reacted with each emoji */
+  reactions?: Record<string, number>;
+  /** Emoji the bridge account reacted with */
+  my_reaction?: string;
+  /** ID of the message this one quotes */
+  reply_to?: string;
+  /** received for inbound messages; sent, delivered, read or played for outbound ones */
+  status?: "received" | "sent" | "delivered" | "read" | "played";
+}
+
+export interface SearchOptions {
+  chatJID?: string;
+  /** Phone number or JID of the sender */
+  sender?: string;
+  since?: Date;
+  until?: Date;
+  /** text for messages without media, or a media type */
+  type?: "text" | "image" | "video" | "audio" | "document";
+  limit?: number;
+  offset?: number;
+}
+
+export interface SemanticSearchResult extends Message {
+  /** Cosine similarity to the query, higher meaning closer */
+  score: number;
+}
+
+export interface SemanticSearchOptions {
+  chatJID?: string;
+  since?: Date;
+  until?: Date;
+  limit?: number;
+}
+
+export interface MessageThread {
+  chat_jid: string;
+  message_id: string;
+  /** Earliest stored message of the quoted-reply chain */
+  root_id: string;
+  /** The root and every reply below it, oldest first */
+  messages: Message[];
+  truncated?: boolean;
+}
+
+export interface Avatar {
+  jid: string;
+  url: string;
+  id: string;
+}
+
+export interface ContactAttributes {
+  jid?: string;
+  name?: string;
+  /** Language tag such as en or pt-BR */
+  language?: string;
+  /** Custom attributes, e.g. { plan: "pro" } */
+  fields: Record<string, string>;
+  updated_at?: string;
+}
+
+export interface ContactAttributesUpdate {
+  name?: string;
+  language?: string;
+  /** A null value removes the field */
+  fields?: Record<string, string | null>;
+}
+
+export interface ContactImportRow {
+  /** Phone number or JID */
+  recipient: string;
+  name?: string;
+  language?: string;
+  fields?: Record<string, string>;
+}
+
+export interface ContactImportResult {
+  imported: number;
+  /** Rows counted from 1, after a CSV's header */
+  skipped: { row: number; recipient?: string; error: string }[];
+}
+
+export interface ChatMetadata {
+  jid?: string;
+  notes: string;
+  metadata: Record<string, string>;
+  updated_at?: string;
+}
+
+export interface UpdateMetadataRequest {
+  notes?: string;
+  /** null removes the key */
+  metadata?: Record<string, string | null>;
+}
+
+export interface ChatAssignment {
+  chat_jid: string;
+  name?: string;
+  queue: string;
+  rule: string;
+  assigned_at: string;
+  first_response_at?: string;
+  resolved_at?: string;
+  /** Only set when the queue has SLA targets */
+  sla?: SLAStatus;
+}
+
+/** A chat handed to an agent; the bot is paused there until resumed */
+export interface Handoff {
+  chat_jid: string;
+  /** Only in lists */
+  name?: string;
+  trigger: "keyword" | "fallback" | "manual";
+  keyword?: string;
+  paused_at: string;
+  /** When a manual pause lifts by itself */
+  resume_at?: string;
+}
+
+export interface BotStatus {
+  chat_jid: string;
+  paused: boolean;
+  trigger?: "keyword" | "fallback" | "manual";
+  keyword?: string;
+  paused_at?: string;
+  resume_at?: string;
+}
+
+export interface SLAStatus {
+  first_response_due?: string;
+  first_response_breached: boolean;
+  resolution_due?: string;
+  resolution_breached: boolean;
+}
+
+export interface FlowStep {
+  type: "message" | "menu" | "input" | "confirm" | "end";
+  message: string;
+  next?: string;
+  options?: { label: string; keywords?: string[]; next: string }[];
+  variable?: string;
+  validate?: string;
+  error?: string;
+  on_yes?: string;
+  on_no?: string;
+  assign?: string;
+}
+
+export interface Flow {
+  id: string;
+  description?: string;
+  start: string;
+  trigger?: { keywords?: string[]; regex?: string };
+  cancel?: string[];
+  timeout_minutes?: number;
+  steps: Record<string, FlowStep>;
+}
+
+export interface FlowSession {
+  chat_jid: string;
+  flow_id: string;
+  step: string;
+  variables: Record<string, string>;
+  started_at: string;
+  updated_at: string;
+}
+
+export interface StartFlowRequest {
+  flow_id: string;
+  /** Defaults to the flow's start step */
+  step?: string;
+  variables?: Record<string, string>;
+}
+
+/** Code to enter on the phone under Linked Devices > Link a Device > Link with phone number instead */
+export interface PairingCode {
+  code: string;
+  /** Digits of the phone number the code links */
+  phone: string;
+}
+
+export interface PairingAttempt {
+  id: string;
+  method: "qr" | "phone_code";
+  started_at: string;
+  finished_at?: string;
+  outcome: "pending" | "success" | "failed" | "timeout" | "abandoned";
+  error?: string;
+  device_jid?: string;
+  platform?: string;
+  /** Dashboard sessions that were served the QR code */
+  viewers: { ip: string; user_agent?: string; seen_at: string }[];
+}
+
+export interface SessionLock {
+  id: string;
+  reason: "stream_replaced" | "logged_out";
+  detail?: string;
+  locked_at: string;
+  acknowledged_by?: string;
+  acknowledged_at?: string;
+}
+
+export interface SessionStatus {
+  locked: boolean;
+  lock?: SessionLock;
+}
+
+export interface Tenant {
+  id: string;
+  name: string;
+  key_ids: string[];
+  users: string[];
+  suspended?: boolean;
+  suspended_reason?: string;
+  created_at?: string;
+  updated_at?: string;
+}
+
+export interface TenantStatus extends Tenant {
+  connected: boolean;
+  period: string;
+  usage: Record<UsageMetric, number>;
+}
+
+export type UsageMetric = "messages_sent" | "media_upload_bytes" | "media_download_bytes";
+
+/** An additional WhatsApp account linked to the bridge, with its connection state */
+export interface AccountStatus {
+  id: string;
+  name?: string;
+  jid?: string;
+  created_at: string;
+  connected: boolean;
+  logged_in: boolean;
+  qr_available: boolean;
+  /** Dashboard page showing the account's QR code */
+  qr_url: string;
+}
+
+export type APIKeyScope = "read" | "send" | "write" | "admin";
+
+export interface APIKey {
+  id: string;
+  name: string;
+  scopes: APIKeyScope[];
+  /** The key itself, only in the response that creates it */
+  key?: string;
+  created_at: string;
+  last_used_at?: string;
+  revoked_at?: string;
+}
+
+export interface AuditExport {
+  day: string;
+  /** Key of the JSON Lines file in the bucket; manifest.json is next to it */
+  object: string;
+  records: number;
+  sha256: string;
+  manifest_sha256: string;
+  exported_at: string;
+}
+
+export interface DashboardLogin {
+  id: string;
+  email: string;
+  outcome: "success" | "failed";
+  ip: string;
+  user_agent?: string;
+  country?: string;
+  region?: string;
+  city?: string;
+  /** First successful login of the user from this country */
+  new_country: boolean;
+  timestamp: string;
+}
+
+export interface DashboardUser {
+  id: string;
+  email: string;
+  admin: boolean;
+  /** False for users who sign in with a passkey only */
+  has_password: boolean;
+  passkeys: number;
+  created_at: string;
+}
+
+export interface UsageReport {
+  subject: string;
+  period: string;
+  usage: Record<UsageMetric, number>;
+  quota?: Partial<Record<UsageMetric, number>>;
+  /** Simulated cost of the messages sent, when the bridge has MESSAGE_COSTS_FILE set */
+  cost?: UsageCost;
+  warm_up?: WarmUpStatus;
+}
+
+export interface UsageCost {
+  currency: string;
+  total: number;
+  /** By chat type and country calling code, most expensive first */
+  breakdown: {
+    chat_type: "direct" | "group" | "broadcast" | "newsletter";
+    country?: string;
+    messages: number;
+    cost: number;
+  }[];
+}
+
+export interface WarmUpStatus {
+  account: string;
+  started_at: string;
+  day: number;
+  daily_limit: number;
+  sent_today: number;
+  remaining: number;
+  ends_at: string;
+}
+
+export interface MediaGCReport {
+  scanned: number;
+  /** Paths relative to the data directory */
+  orphans: { path: string; bytes: number }[];
+  bytes: number;
+  removed: number;
+  errors?: string[];
+}
+
+export interface StorageStatus {
+  status: "ok" | "warning" | "critical";
+  problems?: string[];
+  database_bytes: number;
+  media_bytes: number;
+  media_files: number;
+  disk_free_bytes?: number;
+  disk_total_bytes?: number;
+  disk_free_percent?: number;
+  checked_at: string;
+}
+
+export interface MaintenanceStatus {
+  state: "off" | "active";
+  reason?: string;
+  since?: string;
+}
+
+export interface LogLevel {
+  level: "DEBUG" | "INFO" | "WARN" | "ERROR";
+  /** LOG_LEVEL, which a temporary level falls back to */
+  default_level: "DEBUG" | "INFO" | "WARN" | "ERROR";
+  expires_at?: string;
+}
+
+export interface Presence {
+  jid: string;
+  status: "online" | "offline" | "unknown";
+  last_seen?: string;
+  updated_at?: string;
+  /** Whether the bridge currently receives the contact's presence updates */
+  subscribed: boolean;
+}
+
+export interface OwnPresence {
+  status: "online" | "offline";
+}
+
+export interface ContactOverview {
+  jid: string;
+  name: string;
+  profile: {
+    full_name?: string;
+    push_name?: string;
+    business_name?: string;
+    verified_name?: string;
+    about?: string;
+  };
+  avatar_url?: string;
+  /** Null while the bridge is not connected to WhatsApp */
+  shared_groups: { jid: string; name: string; is_admin: boolean }[] | null;
+  messages_sent: number;
+  messages_received: number;
+  group_messages: number;
+  first_message_at?: string;
+  last_message_at?: string;
+  /** One entry per day, oldest first */
+  volume: { date: string; sent: number; received: number }[];
+  labels: string[];
+  notes: string;
+  metadata: Record<string, string>;
+  assignment?: ChatAssignment;
+  recent_media: Message[];
+}
+
+export interface Draft {
+  chat_jid: string;
+  content: string;
+  updated_by?: string;
+  updated_at: string;
+}
+
+export interface SaveDraftRequest {
+  content: string;
+  updated_by?: string;
+}
+
+export interface CreateUploadRequest {
+  filename: string;
+  size: number;
+}
+
+export interface Upload {
+  upload_id: string;
+  filename: string;
+  size: number;
+  offset: number;
+  created_at: string;
+}
+
+export interface SendUploadRequest {
+  recipient: string;
+  message?: string;
+  client_ref?: string;
+  agent?: string;
+  signature?: boolean;
+  /** Sends the file as a reply to a stored message of the chat */
+  quoted_message_id?: string;
+}
+
+export interface LegalHold {
+  chat_jid: string;
+  reason: string;
+  placed_by?: string;
+  placed_at: string;
+}
+
+export interface ChainVerification {
+  chat_jid: string;
+  /** No stored message was altered */
+  valid: boolean;
+  /** No chained message is missing */
+  complete: boolean;
+  messages: number;
+  unchained: number;
+  head_seq: number;
+  head_hash?: string;
+  gaps: { from: number; to: number }[];
+  mismatches: { seq: number; message_id: string; problem: string }[];
+  checked_at: string;
+}
+
+export interface ChainCheckResult {
+  seq: number;
+  hash: string;
+  message_id?: string;
+  status: "match" | "mismatch" | "missing";
+}
+
+export interface HoldAuditEntry {
+  id: string;
+  chat_jid: string;
+  action: "placed" | "released" | "access" | "revoke_kept" | "erasure_kept";
+  actor: string;
+  ip?: string;
+  detail?: string;
+  at: string;
+}
+
+export interface EraseRequest {
+  phone?: string;
+  jid?: string;
+  confirm: boolean;
+}
+
+export interface ErasureReport {
+  jid: string;
+  deleted: Record<string, number>;
+  /** Chats on legal hold whose messages were kept */
+  held?: string[];
+  errors?: string[];
+  completed_at: string;
+}
+
+export interface Health {
+  connected: boolean;
+  message: string;
+  role: "standalone" | "leader" | "follower";
+  read_only: boolean;
+  receive_only: boolean;
+  maintenance: MaintenanceStatus;
+  storage?: StorageStatus;
+}
+
+export interface Version {
+  /** Release the bridge was built from, or "dev" for unstamped builds */
+  version: string;
+  commit?: string;
+  commit_time?: string;
+  modified: boolean;
+  go_version: string;
+  whatsmeow_version?: string;
+  os: string;
+  arch: string;
+  started_at: string;
+  /** Last database migration the bridge applied */
+  schema_version: number;
+  /** Only present when the bridge has UPDATE_CHECK enabled */
+  update?: UpdateStatus;
+}
+
+export interface UpdateStatus {
+  latest_version?: string;
+  update_available: boolean;
+  release_url?: string;
+  checked_at: string;
+  /** Why the lookup failed */
+  error?: string;
+}
+
+export interface DatabaseStatus {
+  healthy: boolean;
+  status: string;
+  database_info: Record<string, string>;
+  timestamp: string;
+}
+
+/** A group the account is in */
+export interface Group {
+  jid: string;
+  name: string;
+  participant_count: number;
+  /** Whether the account is an admin of the group */
+  is_admin: boolean;
+  /** Only admins can send messages to the group */
+  admins_only: boolean;
+}
+
+/** A group change; events stored before the bridge recorded details only have text and actor */
+export interface GroupEvent {
+  id: string;
+  type: string;
+  timestamp: string;
+  actor?: string;
+  text: string;
+  author?: string;
+  participants?: string[];
+  reason?: string;
+  subject?: string;
+  description?: string;
+  deleted?: boolean;
+  setting?: "announce" | "locked" | "ephemeral";
+  value?: string;
+  removed?: boolean;
+  /** Moderation action of group.moderation events */
+  action?: "link_removed" | "link_deleted" | "link_warned" | "flood_warned" | "command";
+  message_id?: string;
+}
+
+/** A chat muted with the !mute chat command; until is absent for mutes without an end */
+export interface ChatMute {
+  chat_jid: string;
+  muted_by: string;
+  muted_at: string;
+  until?: string;
+}
+
+/** An event from the activity feed, in the shape sent to webhooks */
+export interface BridgeEvent {
+  id: string;
+  type: string;
+  timestamp: string;
+  chat_jid?: string;
+  data: Record<string, unknown>;
+}
+
+export interface ActivityOptions {
+  /** Event types or categories such as "group.*" */
+  types?: string[];
+  chatJID?: string;
+  before?: Date;
+  limit?: number;
+}
+
+/** Filters of the analytics reports; from and to are dates such as "2025-01-31" */
+export interface AnalyticsOptions {
+  from?: string;
+  to?: string;
+  chatJID?: string;
+  agent?: string;
+  /** Only chats with this label */
+  label?: string;
+  tz?: string;
+}
+
+export type AnalyticsReport = "messages" | "delivery" | "response-times" | "sentiment" | "sentiment-chats" | "labels";
+
+export interface DailyMessages {
+  date: string;
+  received: number;
+  sent: number;
+}
+
+/** API sends accepted and refused on one day; success_rate is null on days without sends */
+export interface DailyDelivery {
+  date: string;
+  sent: number;
+  failed: number;
+  success_rate: number | null;
+}
+
+/** How long an agent took to answer contacts; agent is empty for replies without one */
+export interface AgentResponseTimes {
+  agent: string;
+  replies: number;
+  average_seconds: number;
+  median_seconds: number;
+  max_seconds: number;
+}
+
+/** Labeled chats with messages in a period; a chat with several labels counts under each */
+export interface LabelActivity {
+  label: string;
+  chats: number;
+  received: number;
+  sent: number;
+}
+
+/** Scored incoming messages of one day; scores run from -1 to 1 and average is null on days without any */
+export interface DailySentiment {
+  date: string;
+  scored: number;
+  average: number | null;
+  positive: number;
+  neutral: number;
+  negative: number;
+}
+
+/** Sentiment of one chat; recent_average covers its last five scored messages, trend is recent_average minus average */
+export interface ChatSentiment {
+  chat_jid: string;
+  name?: string;
+  scored: number;
+  average: number;
+  recent_average: number;
+  trend: number;
+  negative: number;
+  last_message_at: string;
+}
+
+export interface ExportedMessage {
+  id: string;
+  chat_jid: string;
+  sender: string;
+  content: string;
+  timestamp: string;
+  is_from_me: boolean;
+  media_type?: string;
+  filename?: string;
+  agent?: string;
+  reply_to?: string;
+  system_event?: string;
+  status?: string;
+  reactions?: Record<string, number>;
+  my_reaction?: string;
+}
+
+export interface MessageExportOptions {
+  since?: Date;
+  until?: Date;
+}
+
+export interface GroupEventOptions {
+  from?: Date;
+  to?: Date;
+  types?: string[];
+  participant?: string;
+  limit?: number;
+}
+
+export interface ExportOptions {
+  from?: Date;
+  to?: Date;
+  thumbnails?: boolean;
+}
+
+export interface BridgeClientOptions {
+  /** API key sent in the X-API-Key header */
+  apiKey?: string;
+  /** Timezone sent as ?tz= so timestamps come back in that zone */
+  timezone?: string;
+  /** Custom fetch implementation, defaults to the global fetch */
+  fetch?: typeof fetch;
+}
+
+/** Error thrown for non-2xx responses */
+export class BridgeAPIError extends Error {
+  constructor(public readonly status: number, message: string) {
+    super(`bridge API error ${status}: ${message}`);
+    this.name = "BridgeAPIError";
+  }
+}
+
+export class BridgeClient {
+  private readonly baseURL: string;
+  private readonly options: BridgeClientOptions;
+
+  constructor(baseURL: string, options: BridgeClientOptions = {}) {
+    this.baseURL = baseURL.replace(/\/+$/, "");
+    this.options = options;
+  }
+
+  private async request(
+    method: string,
+    path: string,
+    init: { query?: Record<string, string>; body?: BodyInit; headers?: Record<string, string> } = {},
+  ): Promise<Response> {
+    const query = new URLSearchParams(init.query);
+    if (this.options.timezone) {
+      query.set("tz", this.options.timezone);
+    }
+    const qs = query.toString();
+    const url = `${this.baseURL}/api/v1${path}${qs ? `?${qs}` : ""}`;
+
+    const headers = { ...init.headers };
+    if (this.options.apiKey) {
+      headers["X-API-Key"] = this.options.apiKey;
+    }
+
+    const doFetch = this.options.fetch ?? fetch;
+    const response = await doFetch(url, { method, body: init.body, headers });
+
+    // Send and download report failures with a JSON body that is still worth returning
+    const jsonFailure =
+      response.status === 500 && (response.headers.get("Content-Type") ?? "").startsWith("application/json");
+    if (response.status >= 300 && !jsonFailure) {
+      throw new BridgeAPIError(response.status, (await response.text()).trim());
+    }
+    return response;
+  }
+
+  private async json<T>(method: string, path: string, body?: unknown, query?: Record<string, string>): Promise<T> {
+    const response = await this.request(method, path, {
+      query,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      headers: body === undefined ? undefined : { "Content-Type": "application/json" },
+    });
+    if (response.status === 204) {
+      return undefined as T;
+    }
+    return (await response.json()) as T;
+  }
+
+  private chatPath(chatJID: string, resource: string): string {
+    return `/chats/${encodeURIComponent(chatJID)}/${resource}`;
+  }
+
+  /**
+   * Sends a message. With waitFor, blocks until the message is acknowledged or delivered, or
+   * until timeout seconds pass, in which case timed_out is set.
+   */
+  sendMessage(
+    req: SendMessageRequest,
+    options?: { waitFor?: "server_ack" | "delivered"; timeout?: number },
+  ): Promise<SendMessageResponse> {
+    const query: Record<string, string> = {};
+    if (options?.waitFor) {
+      query.wait_for = options.waitFor;
+    }
+    if (options?.timeout) {
+      query.timeout = String(options.timeout);
+    }
+    return this.json("POST", "/send", req, query);
+  }
+
+  /** Sends a WhatsApp payment request; the bridge must run with PAYMENTS_ENABLED */
+  requestPayment(req: PaymentRequest): Promise<SendMessageResponse> {
+    return this.json("POST", "/payments/request", req);
+  }
+
+  /** Schedules a message for a fixed time or a time on the recipient's clock */
+  scheduleMessage(req: ScheduleRequest): Promise<ScheduledMessage> {
+    return this.json("POST", "/scheduled", req);
+  }
+
+  /** Lists scheduled messages by send time, optionally of one status or campaign */
+  listScheduledMessages(
+    options: { status?: string; campaign_id?: string; series_id?: string; limit?: number } = {},
+  ): Promise<ScheduledMessage[]> {
+    const query: Record<string, string> = {};
+    if (options.status) query.status = options.status;
+    if (options.campaign_id) query.campaign_id = options.campaign_id;
+    if (options.series_id) query.series_id = options.series_id;
+    if (options.limit) query.limit = String(options.limit);
+    return this.json("GET", "/scheduled", undefined, query);
+  }
+
+  getScheduledMessage(id: string): Promise<ScheduledMessage> {
+    return this.json("GET", `/scheduled/${encodeURIComponent(id)}`);
+  }
+
+  /** Cancels a pending scheduled message */
+  cancelScheduledMessage(id: string): Promise<ScheduledMessage> {
+    return this.json("DELETE", `/scheduled/${encodeURIComponent(id)}`);
+  }
+
+  /** Schedules a message for many recipients */
+  createCampaign(req: CampaignRequest): Promise<Campaign> {
+    return this.json("POST", "/campaigns", req);
+  }
+
+  listCampaigns(options: { approval?: Campaign["approval"] } = {}): Promise<Campaign[]> {
+    const query: Record<string, string> = {};
+    if (options.approval) query.approval = options.approval;
+    return this.json("GET", "/campaigns", undefined, query);
+  }
+
+  getCampaign(id: string): Promise<Campaign> {
+    return this.json("GET", `/campaigns/${encodeURIComponent(id)}`);
+  }
+
+  /** Cancels the pending messages of a campaign */
+  cancelCampaign(id: string): Promise<Campaign> {
+    return this.json("DELETE", `/campaigns/${encodeURIComponent(id)}`);
+  }
+
+  /** Renders the message of a campaign's first recipients, with a diff from the submitted text */
+  previewCampaign(id: string, samples?: number): Promise<CampaignPreview> {
+    const query: Record<string, string> = {};
+    if (samples) query.samples = String(samples);
+    return this.json("GET", `/admin/campaigns/${encodeURIComponent(id)}/preview`, undefined, query);
+  }
+
+  /** Releases the messages of a campaign waiting for approval */
+  approveCampaign(id: string, review: { reviewer?: string; note?: string } = {}): Promise<Campaign> {
+    return this.json("POST", `/admin/campaigns/${encodeURIComponent(id)}/approve`, review);
+  }
+
+  /** Cancels the messages of a campaign waiting for approval */
+  rejectCampaign(id: string, review: { reviewer?: string; note?: string } = {}): Promise<Campaign> {
+    return this.json("POST", `/admin/campaigns/${encodeURIComponent(id)}/reject`, review);
+  }
+
+  /** Starts a series sending a message every time its cron expression matches */
+  createRecurring(req: RecurringRequest): Promise<RecurringSeries> {
+    return this.json("POST", "/recurring", req);
+  }
+
+  listRecurring(status?: string): Promise<RecurringSeries[]> {
+    return this.json("GET", "/recurring", undefined, status ? { status } : undefined);
+  }
+
+  getRecurring(id: string): Promise<RecurringSeries> {
+    return this.json("GET", `/recurring/${encodeURIComponent(id)}`);
+  }
+
+  /** Edits a series; changes apply from its next occurrence */
+  updateRecurring(id: string, update: RecurringUpdate): Promise<RecurringSeries> {
+    return this.json("PATCH", `/recurring/${encodeURIComponent(id)}`, update);
+  }
+
+  pauseRecurring(id: string): Promise<RecurringSeries> {
+    return this.json("POST", `/recurring/${encodeURIComponent(id)}/pause`);
+  }
+
+  resumeRecurring(id: string): Promise<RecurringSeries> {
+    return this.json("POST", `/recurring/${encodeURIComponent(id)}/resume`);
+  }
+
+  cancelRecurring(id: string): Promise<RecurringSeries> {
+    return this.json("DELETE", `/recurring/${encodeURIComponent(id)}`);
+  }
+
+  /** Stores a message template */
+  createTemplate(req: MessageTemplateRequest): Promise<MessageTemplate> {
+    return this.json("POST", "/templates", req);
+  }
+
+  /** Lists message templates by name */
+  listTemplates(): Promise<MessageTemplate[]> {
+    return this.json("GET", "/templates");
+  }
+
+  getTemplate(id: string): Promise<MessageTemplate> {
+    return this.json("GET", `/templates/${encodeURIComponent(id)}`);
+  }
+
+  /** Replaces the name, body, samples and language variants of a template */
+  updateTemplate(id: string, req: MessageTemplateRequest): Promise<MessageTemplate> {
+    return this.json("PUT", `/templates/${encodeURIComponent(id)}`, req);
+  }
+
+  async deleteTemplate(id: string): Promise<void> {
+    await this.json("DELETE", `/templates/${encodeURIComponent(id)}`);
+  }
+
+  /** Renders a template with variables over its samples, and lints the result */
+  previewTemplate(id: string, req: TemplatePreviewRequest = {}): Promise<TemplatePreview> {
+    return this.json("POST", `/templates/${encodeURIComponent(id)}/preview`, req);
+  }
+
+  /** Reacts to a stored message; an empty emoji removes the reaction */
+  react(req: ReactRequest): Promise<SendMessageResponse> {
+    return this.json("POST", "/react", req);
+  }
+
+  /** Sends an Opus recording (audio/ogg or audio/webm, as MediaRecorder makes) as a voice note */
+  async sendVoice(req: SendVoiceRequest, audio: Blob, contentType = audio.type): Promise<SendMessageResponse> {
+    const query: Record<string, string> = { recipient: req.recipient };
+    if (req.client_ref) {
+      query.client_ref = req.client_ref;
+    }
+    if (req.agent) {
+      query.agent = req.agent;
+    }
+    if (req.quoted_message_id) {
+      query.quoted_message_id = req.quoted_message_id;
+    }
+    const response = await this.request("POST", "/send/voice", {
+      query,
+      body: audio,
+      headers: { "Content-Type": contentType },
+    });
+    return (await response.json()) as SendMessageResponse;
+  }
+
+  /** Sends a file as an image, video, audio or document message, depending on its content */
+  async sendMedia(req: SendMediaRequest, file: Blob, filename = (file as { name?: string }).name ?? ""): Promise<SendMessageResponse> {
+    const form = new FormData();
+    form.set("recipient", req.recipient);
+    if (req.message) form.set("message", req.message);
+    if (req.client_ref) form.set("client_ref", req.client_ref);
+    if (req.agent) form.set("agent", req.agent);
+    if (req.signature !== undefined) form.set("signature", String(req.signature));
+    if (req.quoted_message_id) form.set("quoted_message_id", req.quoted_message_id);
+    form.set("file", file, filename);
+    const response = await this.request("POST", "/send/media", { body: form });
+    return (await response.json()) as SendMessageResponse;
+  }
+
+  downloadMedia(req: DownloadMediaRequest): Promise<DownloadMediaResponse> {
+    return this.json("POST", "/download", req);
+  }
+
+  /** Streams a message's media */
+  async getMedia(messageID: string, chatJID: string): Promise<Blob> {
+    const response = await this.request("GET", `/media/${encodeURIComponent(messageID)}`, {
+      query: { chat_jid: chatJID },
+    });
+    return response.blob();
+  }
+
+  /** Returns a short-lived signed URL of a message's media; the bridge must keep media in Supabase Storage */
+  getMediaURL(messageID: string, chatJID: string): Promise<MediaURL> {
+    return this.json("GET", `/media/${encodeURIComponent(messageID)}`, undefined, {
+      chat_jid: chatJID,
+      redirect: "false",
+    });
+  }
+
+  /** Lists chats, most recently active first; with a label only the chats that have it */
+  listChats(label?: string): Promise<Chat[]> {
+    return this.json("GET", "/chats", undefined, label ? { label } : undefined);
+  }
+
+  listMessages(chatJID: string, limit?: number): Promise<Message[]> {
+    return this.json("GET", this.chatPath(chatJID, "messages"), undefined, limit ? { limit: String(limit) } : undefined);
+  }
+
+  /**
+   * Finds messages whose text or file name matches query, newest first, in every chat unless chatJID is given.
+   * Page through results by raising offset until a page is shorter than limit.
+   */
+  searchMessages(query: string, options: SearchOptions = {}): Promise<Message[]> {
+    const params: Record<string, string> = { q: query };
+    if (options.chatJID) params.chat_jid = options.chatJID;
+    if (options.sender) params.sender = options.sender;
+    if (options.since) params.since = options.since.toISOString();
+    if (options.until) params.until = options.until.toISOString();
+    if (options.type) params.type = options.type;
+    if (options.limit) params.limit = String(options.limit);
+    if (options.offset) params.offset = String(options.offset);
+    return this.json("GET", "/search", undefined, params);
+  }
+
+  /** Finds the messages closest in meaning to query, best match first; needs embeddings enabled on the bridge */
+  semanticSearch(query: string, options: SemanticSearchOptions = {}): Promise<SemanticSearchResult[]> {
+    const params: Record<string, string> = { q: query };
+    if (options.chatJID) params.chat_jid = options.chatJID;
+    if (options.since) params.since = options.since.toISOString();
+    if (options.until) params.until = options.until.toISOString();
+    if (options.limit) params.limit = String(options.limit);
+    return this.json("GET", "/search/semantic", undefined, params);
+  }
+
+  /** Returns the draft for a chat, or null if there is none */
+  async getDraft(chatJID: string): Promise<Draft | null> {
+    try {
+      return await this.json<Draft>("GET", this.chatPath(chatJID, "draft"));
+    } catch (err) {
+      if (err instanceof BridgeAPIError && err.status === 404) {
+        return null;
+      }
+      throw err;
+    }
+  }
+
+  /** Returns the profile picture of a contact or group, or null if there is none */
+  async getAvatar(chatJID: string): Promise<Avatar | null> {
+    try {
+      return await this.json<Avatar>("GET", this.chatPath(chatJID, "avatar"));
+    } catch (err) {
+      if (err instanceof BridgeAPIError && err.status === 404) {
+        return null;
+      }
+      throw err;
+    }
+  }
+
+  /** Subscribes to a contact's presence and returns their last known status */
+  getPresence(contactJID: string): Promise<Presence> {
+    return this.json("GET", `/contacts/${encodeURIComponent(contactJID)}/presence`);
+  }
+
+  /** Returns a contact's last known status and last-seen time without subscribing */
+  getStoredPresence(contactJID: string): Promise<Presence> {
+    return this.json("GET", `/presence/${encodeURIComponent(contactJID)}`);
+  }
+
+  /** Subscribes to a contact's presence, renewed on every connect until removed */
+  subscribePresence(contactJID: string): Promise<Presence> {
+    return this.json("POST", `/presence/${encodeURIComponent(contactJID)}/subscribe`);
+  }
+
+  /** Stops renewing a contact's presence subscription */
+  async unsubscribePresence(contactJID: string): Promise<void> {
+    await this.json("DELETE", `/presence/${encodeURIComponent(contactJID)}/subscribe`);
+  }
+
+  /** Returns whether the account is online or offline */
+  getOwnPresence(): Promise<OwnPresence> {
+    return this.json("GET", "/presence");
+  }
+
+  /** Marks the account online or offline */
+  setOwnPresence(status: OwnPresence["status"]): Promise<OwnPresence> {
+    return this.json("POST", "/presence", { status });
+  }
+
+  /** Shows the typing (composing) or recording indicator in a chat, or clears it (paused) */
+  async sendChatState(chatJID: string, state: "composing" | "recording" | "paused"): Promise<void> {
+    await this.json("POST", this.chatPath(chatJID, "typing"), { state });
+  }
+
+  /** Returns what the bridge knows about a contact, with days of daily message volume */
+  getContactOverview(contactJID: string, days?: number): Promise<ContactOverview> {
+    return this.json(
+      "GET",
+      `/contacts/${encodeURIComponent(contactJID)}/overview`,
+      undefined,
+      days ? { days: String(days) } : undefined,
+    );
+  }
+
+  /** Saves the draft for a chat; empty content clears it */
+  async saveDraft(chatJID: string, req: SaveDraftRequest): Promise<void> {
+    await this.json("PUT", this.chatPath(chatJID, "draft"), req);
+  }
+
+  async deleteDraft(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "draft"));
+  }
+
+  /** Returns the queue a chat is assigned to, or null if it is not assigned */
+  async getAssignment(chatJID: string): Promise<ChatAssignment | null> {
+    try {
+      return await this.json<ChatAssignment>("GET", this.chatPath(chatJID, "assignment"));
+    } catch (err) {
+      if (err instanceof BridgeAPIError && err.status === 404) {
+        return null;
+      }
+      throw err;
+    }
+  }
+
+  /** Assigns a chat to a queue, replacing any existing assignment */
+  assignChat(chatJID: string, queue: string): Promise<ChatAssignment> {
+    return this.json("PUT", this.chatPath(chatJID, "assignment"), { queue });
+  }
+
+  async unassignChat(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "assignment"));
+  }
+
+  /** Marks a chat's assignment resolved, stopping its SLA resolution clock */
+  resolveChat(chatJID: string): Promise<ChatAssignment> {
+    return this.json("POST", this.chatPath(chatJID, "resolve"));
+  }
+
+  /** Lists the chats assigned to a queue, or all assignments, optionally only open or resolved ones */
+  listAssignments(queue?: string, state?: "open" | "resolved"): Promise<ChatAssignment[]> {
+    const query: Record<string, string> = {};
+    if (queue) query.queue = queue;
+    if (state) query.state = state;
+    return this.json("GET", "/assignments", undefined, query);
+  }
+
+  /** Lists the chats handed to an agent, longest waiting first */
+  listHandoffs(): Promise<Handoff[]> {
+    return this.json("GET", "/handoffs");
+  }
+
+  /** Returns the handoff of a chat, or null if the bot isn't paused there */
+  async getHandoff(chatJID: string): Promise<Handoff | null> {
+    try {
+      return await this.json<Handoff>("GET", this.chatPath(chatJID, "handoff"));
+    } catch (err) {
+      if (err instanceof BridgeAPIError && err.status === 404) {
+        return null;
+      }
+      throw err;
+    }
+  }
+
+  /** Gives a handed-over chat back to the bot */
+  async resumeHandoff(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "handoff"));
+  }
+
+  getBotStatus(chatJID: string): Promise<BotStatus> {
+    return this.json("GET", this.chatPath(chatJID, "bot"));
+  }
+
+  /** Stops flows, routing rules and classification in a chat; resumeAfter (e.g. "8h") lifts the pause by itself */
+  pauseBot(chatJID: string, resumeAfter?: string): Promise<BotStatus> {
+    return this.json("PUT", this.chatPath(chatJID, "bot"), { paused: true, resume_after: resumeAfter });
+  }
+
+  resumeBot(chatJID: string): Promise<BotStatus> {
+    return this.json("PUT", this.chatPath(chatJID, "bot"), { paused: false });
+  }
+
+  listFlows(): Promise<Flow[]> {
+    return this.json("GET", "/flows");
+  }
+
+  /** Returns the flow a chat is in, or null if it is not in one */
+  async getFlowSession(chatJID: string): Promise<FlowSession | null> {
+    return (await this.json<FlowSession | undefined>("GET", this.chatPath(chatJID, "flow"))) ?? null;
+  }
+
+  /** Puts a chat into a flow; null if the flow finished without waiting for an answer */
+  async startFlow(chatJID: string, req: StartFlowRequest): Promise<FlowSession | null> {
+    return (await this.json<FlowSession | undefined>("PUT", this.chatPath(chatJID, "flow"), req)) ?? null;
+  }
+
+  /** Answers the current step on the contact's behalf; null once the flow has finished */
+  async advanceFlow(chatJID: string, input: string): Promise<FlowSession | null> {
+    return (await this.json<FlowSession | undefined>("POST", this.chatPath(chatJID, "flow"), { input })) ?? null;
+  }
+
+  async cancelFlow(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "flow"));
+  }
+
+  /** Requests a code linking the bridge to the account of a phone number, while the bridge shows QR codes */
+  pairPhone(phone: string): Promise<PairingCode> {
+    return this.json("POST", "/pair/phone", { phone });
+  }
+
+  /** Lists the most recent attempts to link the account, newest first */
+  getPairingHistory(limit?: number): Promise<PairingAttempt[]> {
+    return this.json("GET", "/pairing/history", undefined, limit ? { limit: String(limit) } : undefined);
+  }
+
+  /** Reports whether sending is locked after a possible session takeover */
+  getSessionLock(): Promise<SessionStatus> {
+    return this.json("GET", "/session/lock");
+  }
+
+  /** Lifts the lock so the bridge can send, reconnect and pair again */
+  acknowledgeSessionLock(acknowledgedBy: string): Promise<SessionLock> {
+    return this.json("POST", "/session/lock/acknowledge", { acknowledged_by: acknowledgedBy });
+  }
+
+  /** Usage of this client's API key in a month (YYYY-MM), the current one by default */
+  getUsage(period?: string): Promise<UsageReport> {
+    return this.json("GET", "/usage", undefined, period ? { period } : undefined);
+  }
+
+  /** Needs an admin key, as do createAPIKey and revokeAPIKey */
+  listAPIKeys(): Promise<APIKey[]> {
+    return this.json("GET", "/keys");
+  }
+
+  /** The returned key is the only time it is shown */
+  createAPIKey(name: string, scopes: APIKeyScope[]): Promise<APIKey> {
+    return this.json("POST", "/keys", { name, scopes });
+  }
+
+  revokeAPIKey(id: string): Promise<void> {
+    return this.json("DELETE", `/keys/${encodeURIComponent(id)}`);
+  }
+
+  /** Days of outbound messages uploaded to the audit bucket, newest first */
+  listAuditExports(limit?: number): Promise<AuditExport[]> {
+    return this.json("GET", "/admin/audit-exports", undefined, limit ? { limit: String(limit) } : undefined);
+  }
+
+  listDashboardLogins(options: { email?: string; limit?: number } = {}): Promise<DashboardLogin[]> {
+    const query: Record<string, string> = {};
+    if (options.email) query.email = options.email;
+    if (options.limit) query.limit = String(options.limit);
+    return this.json("GET", "/admin/logins", undefined, query);
+  }
+
+  listDashboardUsers(): Promise<DashboardUser[]> {
+    return this.json("GET", "/admin/users");
+  }
+
+  /** Without a password the user signs in with a passkey */
+  createDashboardUser(user: { email: string; password?: string; admin?: boolean }): Promise<DashboardUser> {
+    return this.json("POST", "/admin/users", user);
+  }
+
+  /** A new password signs the user out everywhere */
+  updateDashboardUser(id: string, changes: { password?: string; admin?: boolean }): Promise<DashboardUser> {
+    return this.json("PATCH", `/admin/users/${encodeURIComponent(id)}`, changes);
+  }
+
+  deleteDashboardUser(id: string): Promise<void> {
+    return this.json("DELETE", `/admin/users/${encodeURIComponent(id)}`);
+  }
+
+  listTenants(): Promise<TenantStatus[]> {
+    return this.json("GET", "/admin/tenants");
+  }
+
+  createTenant(tenant: Pick<Tenant, "id" | "name" | "key_ids" | "users">): Promise<Tenant> {
+    return this.json("POST", "/admin/tenants", tenant);
+  }
+
+  /** Replaces the name, key IDs and users of a tenant */
+  updateTenant(tenant: Pick<Tenant, "id" | "name" | "key_ids" | "users">): Promise<TenantStatus> {
+    return this.json("PUT", `/admin/tenants/${encodeURIComponent(tenant.id)}`, tenant);
+  }
+
+  /** Deletes a tenant; its keys keep working, unassigned */
+  deleteTenant(id: string): Promise<void> {
+    return this.json("DELETE", `/admin/tenants/${encodeURIComponent(id)}`);
+  }
+
+  /** Refuses API requests made with the tenant's keys */
+  suspendTenant(id: string, reason?: string): Promise<TenantStatus> {
+    return this.json("POST", `/admin/tenants/${encodeURIComponent(id)}/suspend`, { reason });
+  }
+
+  resumeTenant(id: string): Promise<TenantStatus> {
+    return this.json("POST", `/admin/tenants/${encodeURIComponent(id)}/resume`);
+  }
+
+  listAccounts(): Promise<AccountStatus[]> {
+    return this.json("GET", "/accounts");
+  }
+
+  /** Adds an account; scan the QR code at its qr_url to link it */
+  createAccount(id: string, name?: string): Promise<AccountStatus> {
+    return this.json("POST", "/accounts", { id, name });
+  }
+
+  getAccount(id: string): Promise<AccountStatus> {
+    return this.json("GET", `/accounts/${encodeURIComponent(id)}`);
+  }
+
+  /** Unlinks an account's device and removes the account */
+  deleteAccount(id: string): Promise<void> {
+    return this.json("DELETE", `/accounts/${encodeURIComponent(id)}`);
+  }
+
+  /** Requests a code linking an account to a phone number, while the account shows QR codes */
+  pairAccountPhone(account: string, phone: string): Promise<PairingCode> {
+    return this.json("POST", `/accounts/${encodeURIComponent(account)}/pair-phone`, { phone });
+  }
+
+  sendAccountMessage(account: string, req: SendMessageRequest): Promise<SendMessageResponse> {
+    return this.json("POST", `/accounts/${encodeURIComponent(account)}/send`, req);
+  }
+
+  listAccountChats(account: string): Promise<Chat[]> {
+    return this.json("GET", `/accounts/${encodeURIComponent(account)}/chats`);
+  }
+
+  listAccountMessages(account: string, chatJID: string, limit?: number): Promise<Message[]> {
+    const path = `/accounts/${encodeURIComponent(account)}` + this.chatPath(chatJID, "messages");
+    return this.json("GET", path, undefined, limit ? { limit: String(limit) } : undefined);
+  }
+
+  /** Lists downloaded media no stored message refers to, and removes it if remove is set */
+  scanOrphanMedia(remove = false): Promise<MediaGCReport> {
+    return this.json(remove ? "POST" : "GET", "/admin/media/gc");
+  }
+
+  getMaintenance(): Promise<MaintenanceStatus> {
+    return this.json("GET", "/admin/maintenance");
+  }
+
+  /** Refuses sends and closes the WhatsApp connection, or resumes and reconnects */
+  setMaintenance(enabled: boolean, reason?: string): Promise<MaintenanceStatus> {
+    return this.json("POST", "/admin/maintenance", { enabled, reason });
+  }
+
+  getLogLevel(): Promise<LogLevel> {
+    return this.json("GET", "/admin/loglevel");
+  }
+
+  /**
+   * Changes the log level for durationSeconds (default LOG_LEVEL_TIMEOUT_MINUTES), after which it
+   * falls back to LOG_LEVEL; 0 keeps it until changed again
+   */
+  setLogLevel(level: "debug" | "info" | "warn" | "error", durationSeconds?: number): Promise<LogLevel> {
+    return this.json("POST", "/admin/loglevel", { level, duration_seconds: durationSeconds });
+  }
+
+  /** Downloads the redacted diagnostic bundle as a zip, optionally with only the last logLines log lines */
+  async downloadDiagnostics(logLines?: number): Promise<Blob> {
+    const query: Record<string, string> = {};
+    if (logLines) query.log_lines = String(logLines);
+    const response = await this.request("GET", "/admin/diagnostics", { query });
+    return response.blob();
+  }
+
+  getMetadata(chatJID: string): Promise<ChatMetadata> {
+    return this.json("GET", this.chatPath(chatJID, "metadata"));
+  }
+
+  /** Replaces the notes and all metadata of a chat or contact */
+  replaceMetadata(chatJID: string, metadata: ChatMetadata): Promise<ChatMetadata> {
+    return this.json("PUT", this.chatPath(chatJID, "metadata"), metadata);
+  }
+
+  /** Changes the notes or some metadata keys of a chat or contact */
+  updateMetadata(chatJID: string, req: UpdateMetadataRequest): Promise<ChatMetadata> {
+    return this.json("PATCH", this.chatPath(chatJID, "metadata"), req);
+  }
+
+  async deleteMetadata(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "metadata"));
+  }
+
+  /** Returns the attributes of a contact, given as a phone number or JID */
+  getContactAttributes(contact: string): Promise<ContactAttributes> {
+    return this.json("GET", `/contacts/${encodeURIComponent(contact)}/attributes`);
+  }
+
+  replaceContactAttributes(contact: string, attributes: ContactAttributes): Promise<ContactAttributes> {
+    return this.json("PUT", `/contacts/${encodeURIComponent(contact)}/attributes`, attributes);
+  }
+
+  /** Changes some attributes of a contact */
+  updateContactAttributes(contact: string, update: ContactAttributesUpdate): Promise<ContactAttributes> {
+    return this.json("PATCH", `/contacts/${encodeURIComponent(contact)}/attributes`, update);
+  }
+
+  async deleteContactAttributes(contact: string): Promise<void> {
+    await this.json("DELETE", `/contacts/${encodeURIComponent(contact)}/attributes`);
+  }
+
+  /** Lists contacts with attributes by JID, optionally of one language */
+  listContactAttributes(options: { language?: string; limit?: number; offset?: number } = {}): Promise<ContactAttributes[]> {
+    const query: Record<string, string> = {};
+    if (options.language) query.language = options.language;
+    if (options.limit) query.limit = String(options.limit);
+    if (options.offset) query.offset = String(options.offset);
+    return this.json("GET", "/contacts/attributes", undefined, query);
+  }
+
+  /**
+   * Imports the attributes of up to 10,000 contacts, merged into theirs unless replace is set. A CSV
+   * string needs a header row with a phone, jid or recipient column.
+   */
+  async importContactAttributes(rows: ContactImportRow[] | string, replace = false): Promise<ContactImportResult> {
+    const query: Record<string, string> = replace ? { replace: "true" } : {};
+    if (typeof rows !== "string") {
+      return this.json("POST", "/contacts/attributes/import", rows, query);
+    }
+    const response = await this.request("POST", "/contacts/attributes/import", {
+      query,
+      body: rows,
+      headers: { "Content-Type": "text/csv" },
+    });
+    return (await response.json()) as ContactImportResult;
+  }
+
+  async getLabels(chatJID: string): Promise<string[]> {
+    return (await this.json<{ labels: string[] }>("GET", this.chatPath(chatJID, "labels"))).labels;
+  }
+
+  /** Replaces the labels of a chat or contact and returns them as saved */
+  async setLabels(chatJID: string, labels: string[]): Promise<string[]> {
+    return (await this.json<{ labels: string[] }>("PUT", this.chatPath(chatJID, "labels"), { labels })).labels;
+  }
+
+  /** Returns the messages sent with a client reference, newest first */
+  findMessagesByClientRef(clientRef: string): Promise<Message[]> {
+    return this.json("GET", "/messages", undefined, { client_ref: clientRef });
+  }
+
+  /** Returns the quoted-reply thread around a message; chatJID is needed if the ID occurs in several chats */
+  getThread(messageID: string, chatJID?: string): Promise<MessageThread> {
+    return this.json(
+      "GET",
+      `/messages/${encodeURIComponent(messageID)}/thread`,
+      undefined,
+      chatJID ? { chat_jid: chatJID } : undefined,
+    );
+  }
+
+  /** Returns the status of a sent message and every receipt for it */
+  getMessageReceipts(messageID: string, chatJID?: string): Promise<MessageReceipts> {
+    return this.json(
+      "GET",
+      `/messages/${encodeURIComponent(messageID)}/receipts`,
+      undefined,
+      chatJID ? { chat_jid: chatJID } : undefined,
+    );
+  }
+
+  /** Returns whether a message is queued, sent or failed, by its outbox_id or WhatsApp message ID */
+  getMessageStatus(id: string, chatJID?: string): Promise<MessageStatus> {
+    return this.json(
+      "GET",
+      `/messages/${encodeURIComponent(id)}/status`,
+      undefined,
+      chatJID ? { chat_jid: chatJID } : undefined,
+    );
+  }
+
+  /** Returns the JIDs where a metadata key has the given value */
+  async findByMetadata(key: string, value: string): Promise<string[]> {
+    const result = await this.json<{ jids: string[] }>("GET", "/metadata", undefined, { key, value });
+    return result.jids;
+  }
+
+  /** Renders a chat transcript as PDF */
+  async exportChat(chatJID: string, options: ExportOptions = {}): Promise<Blob> {
+    const query: Record<string, string> = { format: "pdf" };
+    if (options.from) query.from = options.from.toISOString();
+    if (options.to) query.to = options.to.toISOString();
+    if (options.thumbnails === false) query.thumbnails = "false";
+
+    const response = await this.request("GET", this.chatPath(chatJID, "export"), { query });
+    return response.blob();
+  }
+
+  createUpload(req: CreateUploadRequest): Promise<Upload> {
+    return this.json("POST", "/uploads", req);
+  }
+
+  getUpload(uploadID: string): Promise<Upload> {
+    return this.json("GET", `/uploads/${encodeURIComponent(uploadID)}`);
+  }
+
+  /** Sends the next chunk at offset and returns the new offset */
+  async appendUploadChunk(uploadID: string, offset: number, chunk: Blob | Uint8Array): Promise<number> {
+    const response = await this.request("PATCH", `/uploads/${encodeURIComponent(uploadID)}`, {
+      body: chunk,
+      headers: { "Upload-Offset": String(offset), "Content-Type": "application/offset+octet-stream" },
+    });
+    return Number(response.headers.get("Upload-Offset"));
+  }
+
+  async deleteUpload(uploadID: string): Promise<void> {
+    await this.json("DELETE", `/uploads/${encodeURIComponent(uploadID)}`);
+  }
+
+  sendUpload(uploadID: string, req: SendUploadRequest): Promise<SendMessageResponse> {
+    return this.json("POST", `/uploads/${encodeURIComponent(uploadID)}/send`, req);
+  }
+
+  eraseContact(req: EraseRequest): Promise<ErasureReport> {
+    return this.json("POST", "/gdpr/erase", req);
+  }
+
+  verifyChain(chatJID: string): Promise<ChainVerification> {
+    return this.json("GET", this.chatPath(chatJID, "chain"));
+  }
+
+  /** Compares hashes from an exported transcript with the stored chain */
+  checkChainHashes(chatJID: string, hashes: { seq: number; hash: string }[]): Promise<ChainCheckResult[]> {
+    return this.json("POST", this.chatPath(chatJID, "chain"), { hashes });
+  }
+
+  /** Returns the legal hold of a chat, or null if it is not on hold */
+  async getLegalHold(chatJID: string): Promise<LegalHold | null> {
+    try {
+      return await this.json<LegalHold>("GET", this.chatPath(chatJID, "hold"));
+    } catch (err) {
+      if (err instanceof BridgeAPIError && err.status === 404) {
+        return null;
+      }
+      throw err;
+    }
+  }
+
+  /** Puts a chat on legal hold, or updates the reason of an existing hold */
+  placeLegalHold(chatJID: string, reason: string, placedBy?: string): Promise<LegalHold> {
+    return this.json("PUT", this.chatPath(chatJID, "hold"), { reason, placed_by: placedBy });
+  }
+
+  async releaseLegalHold(chatJID: string): Promise<void> {
+    await this.json("DELETE", this.chatPath(chatJID, "hold"));
+  }
+
+  listLegalHolds(): Promise<LegalHold[]> {
+    return this.json("GET", "/legal-holds");
+  }
+
+  /** Returns the newest legal hold audit entries, of one chat or of all chats */
+  getLegalHoldAudit(chatJID?: string, limit?: number): Promise<HoldAuditEntry[]> {
+    const query: Record<string, string> = {};
+    if (chatJID) {
+      query.chat_jid = chatJID;
+    }
+    if (limit) {
+      query.limit = String(limit);
+    }
+    return this.json("GET", "/legal-holds/audit", undefined, query);
+  }
+
+  /** Returns the groups the account is in, by name */
+  listGroups(): Promise<Group[]> {
+    return this.json("GET", "/groups");
+  }
+
+  /** Returns a group's membership, subject and settings changes, newest first */
+  getGroupEvents(groupJID: string, options: GroupEventOptions = {}): Promise<GroupEvent[]> {
+    const query: Record<string, string> = {};
+    if (options.from) query.from = options.from.toISOString();
+    if (options.to) query.to = options.to.toISOString();
+    if (options.types?.length) query.type = options.types.join(",");
+    if (options.participant) query.participant = options.participant;
+    if (options.limit) query.limit = String(options.limit);
+    return this.json("GET", `/groups/${encodeURIComponent(groupJID)}/events`, undefined, query);
+  }
+
+  /** Returns logged events of all chats, newest first; page back with before */
+  listActivity(options: ActivityOptions = {}): Promise<BridgeEvent[]> {
+    const query: Record<string, string> = {};
+    if (options.types?.length) query.types = options.types.join(",");
+    if (options.chatJID) query.chat_jid = options.chatJID;
+    if (options.before) query.before = options.before.toISOString();
+    if (options.limit) query.limit = String(options.limit);
+    return this.json("GET", "/activity", undefined, query);
+  }
+
+  private analyticsQuery(options: AnalyticsOptions, format: string): Record<string, string> {
+    const query: Record<string, string> = { format };
+    if (options.from) query.from = options.from;
+    if (options.to) query.to = options.to;
+    if (options.chatJID) query.chat_jid = options.chatJID;
+    if (options.agent) query.agent = options.agent;
+    if (options.label) query.label = options.label;
+    if (options.tz) query.tz = options.tz;
+    return query;
+  }
+
+  /** Returns the messages received and sent per day */
+  getMessageAnalytics(options: AnalyticsOptions = {}): Promise<DailyMessages[]> {
+    return this.json("GET", "/analytics/messages", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Returns the API sends accepted and refused per day, as far back as the event log is kept */
+  getDeliveryAnalytics(options: AnalyticsOptions = {}): Promise<DailyDelivery[]> {
+    return this.json("GET", "/analytics/delivery", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Returns how long each agent took to answer contacts */
+  getResponseTimes(options: AnalyticsOptions = {}): Promise<AgentResponseTimes[]> {
+    return this.json("GET", "/analytics/response-times", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Returns, per label, the labeled chats with messages in the period and their messages */
+  getLabelAnalytics(options: AnalyticsOptions = {}): Promise<LabelActivity[]> {
+    return this.json("GET", "/analytics/labels", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Returns the average sentiment of incoming messages per day */
+  getSentimentAnalytics(options: AnalyticsOptions = {}): Promise<DailySentiment[]> {
+    return this.json("GET", "/analytics/sentiment", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Returns the sentiment of each chat, the chats whose latest messages are most negative first */
+  getChatSentiment(options: AnalyticsOptions = {}): Promise<ChatSentiment[]> {
+    return this.json("GET", "/analytics/sentiment-chats", undefined, this.analyticsQuery(options, "json"));
+  }
+
+  /** Downloads a report as CSV */
+  async exportAnalytics(report: AnalyticsReport, options: AnalyticsOptions = {}): Promise<Blob> {
+    const response = await this.request("GET", `/analytics/${report}`, { query: this.analyticsQuery(options, "csv") });
+    return response.blob();
+  }
+
+  /** Streams every stored message, oldest first, without holding the whole export in memory */
+  async *exportMessages(options: MessageExportOptions = {}): AsyncGenerator<ExportedMessage> {
+    const query: Record<string, string> = { format: "jsonl" };
+    if (options.since) query.since = options.since.toISOString();
+    if (options.until) query.until = options.until.toISOString();
+    const response = await this.request("GET", "/export/all", { query });
+    if (!response.body) return;
+
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffered = "";
+    for (;;) {
+      const { done, value } = await reader.read();
+      buffered += decoder.decode(value, { stream: !done });
+      const lines = buffered.split("\n");
+      buffered = done ? "" : (lines.pop() ?? "");
+      for (const line of lines) {
+        if (line.trim()) yield JSON.parse(line) as ExportedMessage;
+      }
+      if (done) return;
+    }
+  }
+
+  /** Returns the chats muted with the !mute chat command, most recently muted first */
+  listMutes(): Promise<ChatMute[]> {
+    return this.json("GET", "/mutes");
+  }
+
+  health(): Promise<Health> {
+    return this.json("GET", "/health");
+  }
+
+  /** Returns the version of the bridge and, with UPDATE_CHECK enabled, the latest release */
+  version(): Promise<Version> {
+    return this.json("GET", "/version");
+  }
+
+  databaseStatus(): Promise<DatabaseStatus> {
+    return this.json("GET", "/db/status");
+  }
+}
@@ -0,0 +1,84 @@
+// Exercises the TypeScript SDK against a running bridge.
+//
+//   npm run build && BRIDGE_URL=http://localhost:8080 BRIDGE_API_KEY=... npm run smoke
+//
+// Set SMOKE_RECIPIENT to also send a real message.
+import { BridgeClient } from "./index";
+
+// A chat that never exists, used for write operations that must not touch real data
+const smokeChatJID = "0000000000@s.whatsapp.net";
+
+async function main(): Promise<void> {
+  const client = new BridgeClient(process.env.BRIDGE_URL ?? "http://localhost:8080", {
+    apiKey: process.env.BRIDGE_API_KEY,
+  });
+  let failures = 0;
+
+  const check = async (name: string, fn: () => Promise<unknown>): Promise<void> => {
+    try {
+      await fn();
+      console.log(`ok   ${name}`);
+    } catch (err) {
+      failures++;
+      console.log(`FAIL ${name}: ${err instanceof Error ? err.message : String(err)}`);
+    }
+  };
+
+  await check("health", () => client.health());
+  await check("db status", () => client.databaseStatus());
+  await check("list chats / messages", async () => {
+    const chats = await client.listChats();
+    if (chats.length > 0) {
+      await client.listMessages(chats[0].jid, 5);
+    }
+  });
+
+  await check("draft round trip", async () => {
+    await client.saveDraft(smokeChatJID, { content: "smoke test" });
+    const draft = await client.getDraft(smokeChatJID);
+    if (draft?.content !== "smoke test") {
+      throw new Error(`unexpected draft: ${JSON.stringify(draft)}`);
+    }
+    await client.deleteDraft(smokeChatJID);
+  });
+
+  await check("chunked upload", async () => {
+    const data = new TextEncoder().encode("smoke test upload");
+    const upload = await client.createUpload({ filename: "smoke.txt", size: data.length });
+    try {
+      const offset = await client.appendUploadChunk(upload.upload_id, 0, data.slice(0, 5));
+      await client.appendUploadChunk(upload.upload_id, offset, data.slice(5));
+      const status = await client.getUpload(upload.upload_id);
+      if (status.offset !== status.size) {
+        throw new Error(`upload at ${status.offset} of ${status.size} bytes`);
+      }
+    } finally {
+      await client.deleteUpload(upload.upload_id);
+    }
+  });
+
+  await check("export", async () => {
+    const pdf = await client.exportChat(smokeChatJID, { thumbnails: false });
+    const header = new TextDecoder().decode((await pdf.arrayBuffer()).slice(0, 5));
+    if (header !== "%PDF-") {
+      throw new Error("response is not a PDF");
+    }
+  });
+
+  const recipient = process.env.SMOKE_RECIPIENT;
+  if (recipient) {
+    await check("send message", async () => {
+      const resp = await client.sendMessage({ recipient, message: "Bridge SDK smoke test" });
+      if (!resp.success) {
+        throw new Error(resp.message);
+      }
+    });
+  }
+
+  if (failures > 0) {
+    console.log(`${failures} check(s) failed`);
+    process.exit(1);
+  }
+}
+
+main();
@@ -0,0 +1,898 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// accountIDPattern keeps account IDs usable in URLs and file names
+var accountIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// reservedAccountIDs would clash with the default account or with the routes under /qr/
+var reservedAccountIDs = map[string]bool{"default": true, "image": true, "status": true, "embed": true}
+
+// Account is an additional WhatsApp number linked to the bridge. Its device, keys and sessions
+// and its messages are kept apart from the other accounts': in the schema account_{id} on
+// PostgreSQL, or in DATA_DIR/accounts/{id}/whatsmeow.db and messages.db on SQLite.
+type Account struct {
+	ID   string
+	Name string
+	// JID is the device the account is linked to, empty until its QR code is scanned
+	JID       string
+	CreatedAt time.Time
+
+	client  *whatsmeow.Client
+	store   *MessageStore
+	devices *sqlstore.Container
+	logger  waLog.Logger
+	qrCode  string
+	mutex   sync.RWMutex
+}
+
+// AccountStatus is an account with the state of its connection, as the API reports it
+type AccountStatus struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	JID         string    `json:"jid,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Connected   bool      `json:"connected"`
+	LoggedIn    bool      `json:"logged_in"`
+	QRAvailable bool      `json:"qr_available"`
+	QRURL       string    `json:"qr_url"`
+}
+
+// SessionManager hosts the WhatsApp clients of the additional accounts, keyed by account ID
+type SessionManager struct {
+	container    *sqlstore.Container
+	messageStore *MessageStore
+	dbURL        string
+	logger       waLog.Logger
+	limit        int
+	accounts     map[string]*Account
+	started      bool
+	mutex        sync.RWMutex
+}
+
+// sessionManager is set at startup; it holds no accounts until one is added
+var sessionManager *SessionManager
+
+// NewSessionManager opens the accounts added through /api/v1/accounts, keeping their messages in
+// the PostgreSQL database at dbURL, or in SQLite if it is empty. MAX_ACCOUNTS limits how many
+// can be added.
+func NewSessionManager(container *sqlstore.Container, messageStore *MessageStore, dbURL string, logger waLog.Logger) (*SessionManager, error) {
+	m := &SessionManager{
+		container:    container,
+		messageStore: messageStore,
+		dbURL:        dbURL,
+		logger:       logger,
+		limit:        getEnvInt("MAX_ACCOUNTS", 10),
+		accounts:     make(map[string]*Account),
+	}
+	if m.limit < 0 {
+		return nil, fmt.Errorf("MAX_ACCOUNTS must not be negative")
+	}
+
+	accounts, err := messageStore.ListAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load accounts: %v", err)
+	}
+	for _, account := range accounts {
+		if err := m.open(account); err != nil {
+			return nil, fmt.Errorf("failed to open account %s: %v", account.ID, err)
+		}
+		m.accounts[account.ID] = account
+	}
+	if len(accounts) > 0 {
+		logger.Infof("Loaded %d additional accounts", len(accounts))
+	}
+	return m, nil
+}
+
+// defaultDevice returns the device of the default account: the first stored device that no
+// additional account has claimed, or a new one
+func defaultDevice(container *sqlstore.Container, messageStore *MessageStore) (*store.Device, error) {
+	devices, err := container.GetAllDevices(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := messageStore.accountDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account devices: %v", err)
+	}
+	for _, device := range devices {
+		if device.ID != nil && !claimed[device.ID.String()] {
+			return device, nil
+		}
+	}
+	return container.NewDevice(), nil
+}
+
+// open gives an account its message store and a client for its device, or a new device if it
+// isn't linked
+func (m *SessionManager) open(account *Account) error {
+	account.mutex.Lock()
+	defer account.mutex.Unlock()
+
+	if account.logger == nil {
+		account.logger = m.logger.Sub(account.ID)
+	}
+	if account.store == nil {
+		messageStore, err := m.openAccountStore(account.ID)
+		if err != nil {
+			return err
+		}
+		messageStore.account = account.ID
+		account.store = messageStore
+	}
+	if account.devices == nil {
+		devices, db, err := m.openAccountDevices(account.ID)
+		if err != nil {
+			return err
+		}
+		if err := m.moveSharedDevice(account, db); err != nil {
+			account.logger.Warnf("Failed to move device to the account's own store, using it where it is: %v", err)
+		}
+		account.devices = devices
+	}
+
+	device := account.devices.NewDevice()
+	if account.JID != "" {
+		jid, err := types.ParseJID(account.JID)
+		if err != nil {
+			return fmt.Errorf("invalid device JID %s: %v", account.JID, err)
+		}
+		existing, err := account.devices.GetDevice(context.Background(), jid)
+		if err == nil && existing == nil {
+			// Only there if moving it failed
+			existing, err = m.container.GetDevice(context.Background(), jid)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get device: %v", err)
+		}
+		if existing != nil {
+			device = existing
+		}
+	}
+
+	client := whatsmeow.NewClient(device, account.logger)
+	if client == nil {
+		return fmt.Errorf("failed to create WhatsApp client")
+	}
+	if err := configureProxy(client, account.logger); err != nil {
+		return err
+	}
+	client.AddEventHandler(func(evt interface{}) {
+		m.handleEvent(account, evt)
+	})
+	account.client = client
+	account.qrCode = ""
+	return nil
+}
+
+// openAccountStore opens an account's message store in the database the bridge is configured
+// with, so that with HA_MODE every replica sees the same history
+func (m *SessionManager) openAccountStore(id string) (*MessageStore, error) {
+	if m.dbURL == "" {
+		if err := os.MkdirAll(dataPath("accounts", id), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create account directory: %v", err)
+		}
+		return openSQLiteMessageStore(filepath.Join("accounts", id, "messages.db"))
+	}
+
+	dsn, err := m.accountSchemaDSN(id)
+	if err != nil {
+		return nil, err
+	}
+	return openPostgresMessageStore(dsn)
+}
+
+// accountSchemaDSN creates an account's PostgreSQL schema and returns a connection string using it
+func (m *SessionManager) accountSchemaDSN(id string) (string, error) {
+	// Quoted, since account IDs may contain dashes
+	schema := `"account_` + id + `"`
+	if _, err := m.messageStore.db.Exec("CREATE SCHEMA IF NOT EXISTS " + schema); err != nil {
+		return "", fmt.Errorf("failed to create account schema: %v", err)
+	}
+	return withSearchPath(m.dbURL, schema)
+}
+
+// openAccountDevices opens the whatsmeow store of an account, next to its messages, so accounts
+// never share keys or Signal sessions. The database stays open with the returned container.
+func (m *SessionManager) openAccountDevices(id string) (*sqlstore.Container, *sql.DB, error) {
+	driver, dsn := "sqlite3", sqliteDSN(filepath.Join("accounts", id, "whatsmeow.db"))
+	if m.dbURL != "" {
+		var err error
+		if dsn, err = m.accountSchemaDSN(id); err != nil {
+			return nil, nil, err
+		}
+		driver = "postgres"
+	}
+	db, err := sql.Open(sqlDriverName(driver), dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open device store: %v", err)
+	}
+	container := sqlstore.NewWithDB(db, driver, m.logger.Sub(id).Sub("Database"))
+	if err := container.Upgrade(context.Background()); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to update device store: %v", err)
+	}
+	return container, db, nil
+}
+
+// moveSharedDevice moves an account's device out of the default account's whatsmeow store, where
+// accounts linked before they had their own stores keep it
+func (m *SessionManager) moveSharedDevice(account *Account, to *sql.DB) error {
+	if account.JID == "" {
+		return nil
+	}
+	jid, err := types.ParseJID(account.JID)
+	if err != nil {
+		return fmt.Errorf("invalid device JID %s: %v", account.JID, err)
+	}
+	existing, err := m.container.GetDevice(context.Background(), jid)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	from, err := openDeviceDB(m.dbURL)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	isPostgres := m.dbURL != ""
+	snapshot, err := exportSession(from, isPostgres, account.JID)
+	if err != nil {
+		return err
+	}
+	// Through JSON, like an export file, so the values are in the form importSession reads
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if snapshot, err = decodeSessionSnapshot(data); err != nil {
+		return err
+	}
+	if err := importSession(to, isPostgres, snapshot, false); err != nil {
+		return err
+	}
+	if err := deleteSessionDevice(from, isPostgres, account.JID); err != nil {
+		return err
+	}
+	account.logger.Infof("Moved device to the account's own store")
+	return nil
+}
+
+// withSearchPath points a PostgreSQL connection string at a schema. lib/pq passes settings it
+// doesn't know to the server, in URLs and key=value strings alike.
+func withSearchPath(dbURL, schema string) (string, error) {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		u, err := url.Parse(dbURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid DATABASE_URL: %v", err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	return dbURL + " search_path='" + schema + "'", nil
+}
+
+// Start connects every account; accounts added later connect as they are added
+func (m *SessionManager) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.started = true
+	for _, account := range m.accounts {
+		go m.connect(account)
+	}
+}
+
+// connect connects an account. An account that isn't linked shows QR codes on /qr/{id} until it
+// is scanned or the codes run out; POST /api/v1/accounts/{id}/connect starts over.
+func (m *SessionManager) connect(account *Account) {
+	client := account.Client()
+	if client.IsConnected() {
+		return
+	}
+	if client.Store.ID != nil {
+		if err := client.Connect(); err != nil {
+			account.logger.Errorf("Failed to connect: %v", err)
+		}
+		return
+	}
+
+	qrChan, err := client.GetQRChannel(context.Background())
+	if err != nil {
+		account.logger.Errorf("Failed to get QR channel: %v", err)
+		return
+	}
+	if err := client.Connect(); err != nil {
+		account.logger.Errorf("Failed to connect: %v", err)
+		return
+	}
+	fmt.Printf("\n🌐 QR Code for account %s available at: %s\n", account.ID, withBasePath("/qr/"+account.ID))
+	for evt := range qrChan {
+		if evt.Event == "code" {
+			account.setQRCode(evt.Code)
+		} else {
+			account.setQRCode("")
+			if evt.Event != "success" {
+				account.logger.Warnf("Pairing ended: %s", evt.Event)
+			}
+		}
+	}
+}
+
+// handleEvent stores an account's messages and reports its connection changes. Routing,
+// flows, commands and the other automations only run on the default account.
+func (m *SessionManager) handleEvent(account *Account, evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Message:
+		m.handleMessage(account, v)
+
+	case *events.Receipt:
+		// Wake sends waiting for delivery, tell subscribers and update the stored status
+		handleDeliveryReceipt(v, account.ID)
+		recordReceiptStatus(account.store, v, account.logger)
+
+	case *events.Connected:
+		account.logger.Infof("Connected to WhatsApp")
+		publishEvent(EventAccountConnected, "", time.Time{}, map[string]interface{}{"account": account.ID})
+
+	case *events.Disconnected:
+		publishEvent(EventAccountDisconnected, "", time.Time{}, map[string]interface{}{"account": account.ID})
+
+	case *events.PairSuccess:
+		if err := m.messageStore.SetAccountDevice(account.ID, v.ID.String()); err != nil {
+			account.logger.Errorf("Failed to save the device of account %s: %v", account.ID, err)
+		}
+		account.mutex.Lock()
+		account.JID = v.ID.String()
+		account.mutex.Unlock()
+		publishEvent(EventAccountPaired, "", time.Time{}, map[string]interface{}{
+			"account":  account.ID,
+			"jid":      v.ID.ToNonAD().String(),
+			"platform": v.Platform,
+		})
+
+	case *events.LoggedOut:
+		account.logger.Warnf("Device logged out, scan the QR code on /qr/%s to link it again", account.ID)
+		if err := m.messageStore.SetAccountDevice(account.ID, ""); err != nil {
+			account.logger.Errorf("Failed to clear the device of account %s: %v", account.ID, err)
+		}
+		account.mutex.Lock()
+		account.JID = ""
+		account.mutex.Unlock()
+		publishEvent(EventAccountLoggedOut, "", time.Time{}, map[string]interface{}{"account": account.ID, "reason": v.Reason.String()})
+
+		// whatsmeow removed the device, so linking again starts from a new one
+		if err := m.open(account); err != nil {
+			account.logger.Errorf("Failed to reset account %s: %v", account.ID, err)
+		}
+	}
+}
+
+// handleMessage stores a message of an account in the account's own message store
+func (m *SessionManager) handleMessage(account *Account, msg *events.Message) {
+	content := extractTextContent(msg.Message)
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
+	if content == "" && mediaType == "" {
+		return
+	}
+
+	chatJID := msg.Info.Chat.String()
+	sender := msg.Info.Sender.User
+	name := GetChatName(account.Client(), account.store, msg.Info.Chat, chatJID, nil, sender, account.logger)
+	if err := account.store.StoreChat(chatJID, name, msg.Info.Timestamp); err != nil {
+		account.logger.Warnf("Failed to store chat: %v", err)
+	}
+	if err := account.store.StoreMessage(msg.Info.ID, chatJID, sender, content, msg.Info.Timestamp, msg.Info.IsFromMe,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength); err != nil {
+		account.logger.Warnf("Failed to store message: %v", err)
+		return
+	}
+
+	publishEvent(EventMessageReceived, chatJID, msg.Info.Timestamp, map[string]interface{}{
+		"account":    account.ID,
+		"id":         msg.Info.ID,
+		"chat_jid":   chatJID,
+		"sender":     sender,
+		"content":    content,
+		"is_from_me": msg.Info.IsFromMe,
+		"media_type": mediaType,
+		"filename":   filename,
+	})
+}
+
+// Disconnect closes the connection of every account and returns the linked ones, for Reconnect
+func (m *SessionManager) Disconnect() []*Account {
+	if m == nil {
+		return nil
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var linked []*Account
+	for _, account := range m.accounts {
+		client := account.Client()
+		if !client.IsConnected() {
+			continue
+		}
+		// An expected disconnect, so whatsmeow doesn't reconnect on its own
+		client.Disconnect()
+		if client.Store.ID != nil {
+			linked = append(linked, account)
+		}
+	}
+	return linked
+}
+
+// Reconnect connects accounts closed by Disconnect again, unless they were removed since
+func (m *SessionManager) Reconnect(accounts []*Account) {
+	if m == nil {
+		return
+	}
+	for _, account := range accounts {
+		if m.Get(account.ID) == account {
+			go m.connect(account)
+		}
+	}
+}
+
+// Get returns an account by ID, or nil
+func (m *SessionManager) Get(id string) *Account {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.accounts[id]
+}
+
+// List returns the accounts ordered by ID
+func (m *SessionManager) List() []AccountStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	statuses := []AccountStatus{}
+	for _, account := range m.accounts {
+		statuses = append(statuses, account.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// Add creates an account and, once the manager is started, connects it for pairing
+func (m *SessionManager) Add(id, name string) (*Account, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !accountIDPattern.MatchString(id) || reservedAccountIDs[id] {
+		return nil, fmt.Errorf("id must be 1-32 lowercase letters, digits, dashes or underscores, and not default, image, status or embed")
+	}
+	if len(name) > 200 {
+		return nil, fmt.Errorf("name must be at most 200 characters")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.accounts[id] != nil {
+		return nil, errAccountExists
+	}
+	if len(m.accounts) >= m.limit {
+		return nil, fmt.Errorf("the bridge already has %d additional accounts (MAX_ACCOUNTS)", m.limit)
+	}
+
+	account := &Account{ID: id, Name: name, CreatedAt: time.Now().UTC()}
+	if err := m.messageStore.SaveAccount(account); err != nil {
+		return nil, err
+	}
+	if err := m.open(account); err != nil {
+		m.messageStore.DeleteAccount(id)
+		return nil, err
+	}
+	m.accounts[id] = account
+	if m.started {
+		go m.connect(account)
+	}
+	return account, nil
+}
+
+// Remove unlinks an account's device and forgets the account. Its message history is kept.
+func (m *SessionManager) Remove(id string) error {
+	// Taken out of the map first, so other accounts aren't blocked while WhatsApp is told about
+	// the logout, and put back if removing it fails
+	m.mutex.Lock()
+	account := m.accounts[id]
+	delete(m.accounts, id)
+	m.mutex.Unlock()
+	if account == nil {
+		return nil
+	}
+	restore := func() {
+		m.mutex.Lock()
+		m.accounts[id] = account
+		m.mutex.Unlock()
+	}
+
+	client := account.Client()
+	if client.Store.ID != nil {
+		if err := client.Logout(context.Background()); err != nil {
+			account.logger.Warnf("Failed to log out, removing the device locally: %v", err)
+			client.Disconnect()
+			if err := client.Store.Delete(context.Background()); err != nil {
+				restore()
+				return fmt.Errorf("failed to delete device: %v", err)
+			}
+		}
+	} else {
+		client.Disconnect()
+	}
+
+	if err := m.messageStore.DeleteAccount(id); err != nil {
+		restore()
+		return err
+	}
+	account.store.Close()
+	account.devices.Close()
+	return nil
+}
+
+// errAccountExists is returned when adding an account whose ID is taken
+var errAccountExists = fmt.Errorf("an account with this id already exists")
+
+// Client returns the account's current client, which is replaced when the device is logged out
+func (a *Account) Client() *whatsmeow.Client {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.client
+}
+
+func (a *Account) setQRCode(code string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.qrCode = code
+}
+
+// Status reports the account with its connection state
+func (a *Account) Status() AccountStatus {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	jid := a.JID
+	if device, err := types.ParseJID(a.JID); err == nil && a.JID != "" {
+		jid = device.ToNonAD().String()
+	}
+	return AccountStatus{
+		ID:          a.ID,
+		Name:        a.Name,
+		JID:         jid,
+		CreatedAt:   a.CreatedAt,
+		Connected:   a.client.IsConnected(),
+		LoggedIn:    a.client.Store.ID != nil,
+		QRAvailable: a.qrCode != "",
+		QRURL:       withBasePath("/qr/" + a.ID),
+	}
+}
+
+// withAccount adds the ID of an additional account to event data; the default account has none
+func withAccount(account string, data map[string]interface{}) map[string]interface{} {
+	if account != "" {
+		data["account"] = account
+	}
+	return data
+}
+
+// ListAccounts returns the additional accounts, oldest first
+func (store *MessageStore) ListAccounts() ([]*Account, error) {
+	rows, err := store.db.Query("SELECT id, COALESCE(name, ''), COALESCE(device_jid, ''), created_at FROM accounts ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account := &Account{}
+		if err := rows.Scan(&account.ID, &account.Name, &account.JID, &account.CreatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// accountDevices returns the device JIDs claimed by additional accounts
+func (store *MessageStore) accountDevices() (map[string]bool, error) {
+	rows, err := store.db.Query("SELECT device_jid FROM accounts WHERE device_jid IS NOT NULL AND device_jid <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := map[string]bool{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		devices[jid] = true
+	}
+	return devices, rows.Err()
+}
+
+// SaveAccount inserts an account
+func (store *MessageStore) SaveAccount(account *Account) error {
+	query := "INSERT INTO accounts (id, name, created_at) VALUES (?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO accounts (id, name, created_at) VALUES ($1, $2, $3)"
+	}
+	_, err := store.db.Exec(query, account.ID, account.Name, account.CreatedAt)
+	return err
+}
+
+// SetAccountDevice records the device an account is linked to, or clears it
+func (store *MessageStore) SetAccountDevice(id, deviceJID string) error {
+	query := "UPDATE accounts SET device_jid = NULLIF(?, '') WHERE id = ?"
+	if store.isPostgres {
+		query = "UPDATE accounts SET device_jid = NULLIF($1, '') WHERE id = $2"
+	}
+	_, err := store.db.Exec(query, deviceJID, id)
+	return err
+}
+
+// DeleteAccount removes an account
+func (store *MessageStore) DeleteAccount(id string) error {
+	query := "DELETE FROM accounts WHERE id = ?"
+	if store.isPostgres {
+		query = "DELETE FROM accounts WHERE id = $1"
+	}
+	_, err := store.db.Exec(query, id)
+	return err
+}
+
+// ServeAccountQR serves the pairing page of an account at /qr/{id}, with its /image and /status
+func ServeAccountQR(w http.ResponseWriter, r *http.Request) {
+	id, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/qr/"), "/")
+	account := sessionManager.Get(id)
+	if account == nil {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+
+	switch resource {
+	case "":
+		pageTemplates.Render(w, "account_qr", struct {
+			ID   string
+			Name string
+		}{account.ID, account.Name})
+
+	case "image":
+		status := account.Status()
+		if status.LoggedIn {
+			http.Error(w, "Already connected", http.StatusGone)
+			return
+		}
+		account.mutex.RLock()
+		code := account.qrCode
+		account.mutex.RUnlock()
+		if code == "" {
+			http.Error(w, "No QR code available", http.StatusNotFound)
+			return
+		}
+		image, err := qrPNG(code)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Write(image)
+
+	case "status":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		json.NewEncoder(w).Encode(account.Status())
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// registerAccountRoutes registers /api/v1/accounts and the routes of each account under it
+func registerAccountRoutes() {
+	handleAPI("/accounts", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessionManager.List())
+
+		case http.MethodPost:
+			var req struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			account, err := sessionManager.Add(req.ID, req.Name)
+			if err == errAccountExists {
+				http.Error(w, "An account with this id already exists", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(account.Status())
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	send := refuseDuringMaintenance(sendUnlocked(meteredSend(serveAccountSend)))
+
+	// /accounts/{id}, plus /connect, /pair-phone, /send, /chats and /chats/{jid}/messages
+	handleAPI("/accounts/", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		id, resource, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/accounts/"), "/")
+		account := sessionManager.Get(id)
+		if account == nil {
+			http.Error(w, "Account not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case resource == "" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(account.Status())
+
+		case resource == "" && r.Method == http.MethodDelete:
+			if err := sessionManager.Remove(id); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to remove account: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case resource == "connect" && r.Method == http.MethodPost:
+			go sessionManager.connect(account)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(account.Status())
+
+		case resource == "pair-phone" && r.Method == http.MethodPost:
+			account.mutex.RLock()
+			qrShown := account.qrCode != ""
+			account.mutex.RUnlock()
+			servePairingCode(w, r, account.Client(), qrShown)
+
+		case resource == "send" && r.Method == http.MethodPost:
+			send(w, r)
+
+		case resource == "chats" && r.Method == http.MethodGet:
+			loc, err := requestLocation(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// Not through ListChats, whose cache holds the default account's chats
+			chats, err := account.store.queryChats()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get chats: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for i := range chats {
+				chats[i].LastMessageTime = chats[i].LastMessageTime.In(loc)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(chats)
+
+		case strings.HasPrefix(resource, "chats/") && strings.HasSuffix(resource, "/messages") && r.Method == http.MethodGet:
+			chatJID := strings.TrimSuffix(strings.TrimPrefix(resource, "chats/"), "/messages")
+			limit := 100
+			if value := r.URL.Query().Get("limit"); value != "" {
+				if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+					limit = parsed
+				}
+			}
+			loc, err := requestLocation(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			messages, err := account.store.ListMessages(chatJID, limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for i := range messages {
+				messages[i].Timestamp = messages[i].Timestamp.In(loc)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(messages)
+
+		case resource == "" || resource == "connect" || resource == "pair-phone" || resource == "send" || resource == "chats":
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// serveAccountSend sends a message from an account, taking the same request as /api/v1/send
+func serveAccountSend(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/accounts/"), "/")
+	account := sessionManager.Get(id)
+	if account == nil {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	waitFor, waitTimeout, err := parseDeliveryWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Recipient == "" {
+		http.Error(w, "Recipient is required", http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" && req.MediaPath == "" {
+		http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ClientRef) > maxClientRefLen {
+		http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+		return
+	}
+	if len(req.Agent) > maxAgentLen {
+		http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+		return
+	}
+
+	opts := SendOptions{ClientRef: req.ClientRef, Agent: req.Agent, Signature: req.Signature, Account: account.ID, APIKey: usageSubject(r), QuotedMessageID: req.QuotedMessageID}
+	success, message, messageID, code := sendWhatsAppMessage(account.Client(), req.Recipient, req.Message, req.MediaPath, opts, account.store)
+	if success {
+		usageMeter.RecordSend(r, req.Recipient, req.MediaPath)
+	}
+	response := SendMessageResponse{
+		Success:   success,
+		Message:   message,
+		MessageID: messageID,
+		ClientRef: req.ClientRef,
+		Agent:     req.Agent,
+	}.withError(code)
+
+	status := http.StatusOK
+	if !success {
+		status = http.StatusInternalServerError
+	} else if waitFor != "" {
+		status = awaitSendState(&response, waitFor, waitTimeout)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
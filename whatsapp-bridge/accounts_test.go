@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waAdv"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestAccountDeviceMovesToItsOwnStore(t *testing.T) {
+	ctx := context.Background()
+	messageStore := newTestMessageStore(t)
+	db, err := openDeviceDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := sqlstore.NewWithDB(db, "sqlite3", waLog.Noop)
+	t.Cleanup(func() { container.Close() })
+
+	// Both devices in the default store, as accounts were linked before they had their own
+	linkDevice := func(user string) types.JID {
+		device := container.NewDevice()
+		jid := types.NewADJID(user, 0, 1)
+		device.ID = &jid
+		device.Account = &waAdv.ADVSignedDeviceIdentity{
+			Details:             []byte{1},
+			AccountSignature:    make([]byte, 64),
+			AccountSignatureKey: make([]byte, 32),
+			DeviceSignature:     make([]byte, 64),
+		}
+		if err := container.PutDevice(ctx, device); err != nil {
+			t.Fatal(err)
+		}
+		if err := device.Sessions.PutSession(ctx, "15550009999.0:1", []byte(user)); err != nil {
+			t.Fatal(err)
+		}
+		return jid
+	}
+	defaultJID := linkDevice("15550001111")
+	accountJID := linkDevice("15550002222")
+
+	manager := &SessionManager{container: container, messageStore: messageStore, logger: waLog.Noop, limit: 10, accounts: map[string]*Account{}}
+	account := &Account{ID: "sales", JID: accountJID.String()}
+	if err := messageStore.SaveAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.open(account); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		account.store.Close()
+		account.devices.Close()
+	})
+
+	device := account.Client().Store
+	if device.ID == nil || *device.ID != accountJID || device.Container != account.devices {
+		t.Fatalf("account uses %v from %T, want %v from its own store", device.ID, device.Container, accountJID)
+	}
+	if session, err := device.Sessions.GetSession(ctx, "15550009999.0:1"); err != nil || string(session) != "15550002222" {
+		t.Errorf("moved session is %q (%v)", session, err)
+	}
+	if left, err := container.GetDevice(ctx, accountJID); err != nil || left != nil {
+		t.Errorf("device is still in the default store (%v)", err)
+	}
+
+	// The default store now exports just the default device
+	snapshot, err := exportSession(db, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.DeviceJID != defaultJID.String() {
+		t.Errorf("exported %s, want %s", snapshot.DeviceJID, defaultJID)
+	}
+	for _, table := range snapshot.Tables {
+		if table.Name == "whatsmeow_sessions" && len(table.Rows) != 1 {
+			t.Errorf("exported %d sessions, want the default device's one", len(table.Rows))
+		}
+	}
+
+	// Opening it again finds the device where it was moved
+	account.devices.Close()
+	account.devices = nil
+	if err := manager.open(account); err != nil {
+		t.Fatal(err)
+	}
+	if device := account.Client().Store; device.ID == nil || *device.ID != accountJID {
+		t.Errorf("reopened account uses %v, want %v", device.ID, accountJID)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"net/http"
+)
+
+// ServeActivityPage serves the activity feed, a thin client of /api/v1/activity and /api/v1/events
+func ServeActivityPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "activity", struct{ EventTypes []string }{eventTypes})
+}
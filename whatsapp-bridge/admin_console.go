@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+)
+
+// ServeAdminConsole serves the tenant admin page, a thin client of /api/v1/admin/tenants
+func ServeAdminConsole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "tenants", nil)
+}
+
+// ServeApprovalsPage serves the campaign review page, a thin client of /api/v1/campaigns and /api/v1/admin/campaigns
+func ServeApprovalsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "approvals", nil)
+}
+
+// ServeLoginHistoryPage serves the dashboard login history, a thin client of /api/v1/admin/logins
+func ServeLoginHistoryPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "logins", nil)
+}
+
+// ServeUsersPage serves local dashboard user management, a thin client of /api/v1/admin/users
+func ServeUsersPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "users", nil)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// maxAgentLen keeps agent names to the size of a user name or email address
+const maxAgentLen = 100
+
+// defaultAgentSignatureFormat prefixes messages with the agent's name in bold
+const defaultAgentSignatureFormat = "*{agent}:*\n"
+
+// withAgentSignature prefixes a message with the sending agent's signature when signatures are
+// enabled by AGENT_SIGNATURE or the request. Messages without text (e.g. bare media) are left alone.
+func withAgentSignature(message string, opts SendOptions) string {
+	if opts.Agent == "" || message == "" {
+		return message
+	}
+
+	enabled := getEnvBool("AGENT_SIGNATURE", false)
+	if opts.Signature != nil {
+		enabled = *opts.Signature
+	}
+	if !enabled {
+		return message
+	}
+
+	format := os.Getenv("AGENT_SIGNATURE_FORMAT")
+	if format == "" {
+		format = defaultAgentSignatureFormat
+	}
+	// Allow a literal \n in .env files
+	format = strings.ReplaceAll(format, `\n`, "\n")
+
+	return strings.ReplaceAll(format, "{agent}", opts.Agent) + message
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAnalyticsDays bounds the period of a report
+const maxAnalyticsDays = 366
+
+// AnalyticsFilter selects the messages and events a report covers. From and To are whole days in Location.
+type AnalyticsFilter struct {
+	From     time.Time
+	To       time.Time
+	ChatJID  string
+	Agent    string
+	Label    string
+	Location *time.Location
+}
+
+// DailyMessages is the number of messages received and sent on one day
+type DailyMessages struct {
+	Date     string `json:"date"`
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+}
+
+// DailyDelivery is the number of API sends WhatsApp accepted and refused on one day
+type DailyDelivery struct {
+	Date        string   `json:"date"`
+	Sent        int      `json:"sent"`
+	Failed      int      `json:"failed"`
+	SuccessRate *float64 `json:"success_rate"`
+}
+
+// LabelActivity is the number of labeled chats that were active in a period, and their messages
+type LabelActivity struct {
+	Label    string `json:"label"`
+	Chats    int    `json:"chats"`
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+}
+
+// AgentResponseTimes summarizes how long an agent took to answer contacts
+type AgentResponseTimes struct {
+	Agent          string  `json:"agent"`
+	Replies        int     `json:"replies"`
+	AverageSeconds float64 `json:"average_seconds"`
+	MedianSeconds  float64 `json:"median_seconds"`
+	MaxSeconds     float64 `json:"max_seconds"`
+}
+
+// parseAnalyticsFilter reads ?from=YYYY-MM-DD&to=YYYY-MM-DD&chat_jid=...&agent=...&label=...&tz=..., by default the last 30 days
+func parseAnalyticsFilter(r *http.Request) (AnalyticsFilter, error) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		return AnalyticsFilter{}, err
+	}
+	now := time.Now().In(loc)
+	filter := AnalyticsFilter{
+		To:       time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc),
+		ChatJID:  r.URL.Query().Get("chat_jid"),
+		Agent:    r.URL.Query().Get("agent"),
+		Label:    strings.ToLower(strings.TrimSpace(r.URL.Query().Get("label"))),
+		Location: loc,
+	}
+	filter.From = filter.To.AddDate(0, 0, -29)
+
+	if value := r.URL.Query().Get("to"); value != "" {
+		if filter.To, err = time.ParseInLocation("2006-01-02", value, loc); err != nil {
+			return filter, fmt.Errorf("to must be a date like 2025-01-31")
+		}
+		if r.URL.Query().Get("from") == "" {
+			filter.From = filter.To.AddDate(0, 0, -29)
+		}
+	}
+	if value := r.URL.Query().Get("from"); value != "" {
+		if filter.From, err = time.ParseInLocation("2006-01-02", value, loc); err != nil {
+			return filter, fmt.Errorf("from must be a date like 2025-01-01")
+		}
+	}
+	if filter.To.Before(filter.From) {
+		return filter, fmt.Errorf("from must not be after to")
+	}
+	if filter.From.AddDate(0, 0, maxAnalyticsDays).Before(filter.To) {
+		return filter, fmt.Errorf("reports cover at most %d days", maxAnalyticsDays)
+	}
+	return filter, nil
+}
+
+// end is the first instant after the period
+func (f AnalyticsFilter) end() time.Time {
+	return f.To.AddDate(0, 0, 1)
+}
+
+// days lists the dates of the period, so quiet days still get a row
+func (f AnalyticsFilter) days() []string {
+	var days []string
+	for day := f.From; day.Before(f.end()); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
+	}
+	return days
+}
+
+// analyticsConditions returns the WHERE clause shared by reports on the messages table
+func (store *MessageStore) analyticsConditions(f AnalyticsFilter) (string, []interface{}) {
+	args := []interface{}{f.From.UTC(), f.end().UTC()}
+	arg := func() string {
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+	var where string
+	if store.isPostgres {
+		where = "timestamp >= $1 AND timestamp < $2 AND system_event IS NULL"
+	} else {
+		where = "timestamp >= ? AND timestamp < ? AND system_event IS NULL"
+	}
+	if f.ChatJID != "" {
+		args = append(args, f.ChatJID)
+		where += " AND chat_jid = " + arg()
+	}
+	if f.Label != "" {
+		args = append(args, f.Label)
+		where += " AND chat_jid IN (SELECT chat_jid FROM chat_labels WHERE label = " + arg() + ")"
+	}
+	return where, args
+}
+
+// DailyMessageCounts counts messages per day. An agent filter only narrows the sent messages.
+func (store *MessageStore) DailyMessageCounts(f AnalyticsFilter) ([]DailyMessages, error) {
+	where, args := store.analyticsConditions(f)
+	rows, err := store.db.Query("SELECT timestamp, is_from_me, COALESCE(agent, '') FROM messages WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []DailyMessages{}
+	days := map[string]*DailyMessages{}
+	for _, date := range f.days() {
+		result = append(result, DailyMessages{Date: date})
+	}
+	for i := range result {
+		days[result[i].Date] = &result[i]
+	}
+	for rows.Next() {
+		var at time.Time
+		var fromMe bool
+		var agent string
+		if err := rows.Scan(&at, &fromMe, &agent); err != nil {
+			return nil, err
+		}
+		day, ok := days[at.In(f.Location).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		if !fromMe {
+			day.Received++
+		} else if f.Agent == "" || agent == f.Agent {
+			day.Sent++
+		}
+	}
+	return result, rows.Err()
+}
+
+// DailyDeliveryCounts counts API sends per day from the message.sent and message.failed events of the
+// event log, so it only reaches back EVENT_LOG_RETENTION_DAYS
+func (store *MessageStore) DailyDeliveryCounts(f AnalyticsFilter) ([]DailyDelivery, error) {
+	query := "SELECT type, timestamp, data FROM bridge_events WHERE type IN (?, ?) AND timestamp >= ? AND timestamp < ?"
+	if store.isPostgres {
+		query = "SELECT type, timestamp, data FROM bridge_events WHERE type IN ($1, $2) AND timestamp >= $3 AND timestamp < $4"
+	}
+	args := []interface{}{EventMessageSent, EventMessageFailed, f.From.UTC(), f.end().UTC()}
+	if f.ChatJID != "" {
+		args = append(args, f.ChatJID)
+		if store.isPostgres {
+			query += fmt.Sprintf(" AND chat_jid = $%d", len(args))
+		} else {
+			query += " AND chat_jid = ?"
+		}
+	}
+	if f.Label != "" {
+		args = append(args, f.Label)
+		if store.isPostgres {
+			query += fmt.Sprintf(" AND chat_jid IN (SELECT chat_jid FROM chat_labels WHERE label = $%d)", len(args))
+		} else {
+			query += " AND chat_jid IN (SELECT chat_jid FROM chat_labels WHERE label = ?)"
+		}
+	}
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []DailyDelivery{}
+	days := map[string]*DailyDelivery{}
+	for _, date := range f.days() {
+		result = append(result, DailyDelivery{Date: date})
+	}
+	for i := range result {
+		days[result[i].Date] = &result[i]
+	}
+	for rows.Next() {
+		var eventType, data string
+		var at time.Time
+		if err := rows.Scan(&eventType, &at, &data); err != nil {
+			return nil, err
+		}
+		if f.Agent != "" {
+			var fields struct {
+				Agent string `json:"agent"`
+			}
+			if json.Unmarshal([]byte(data), &fields) != nil || fields.Agent != f.Agent {
+				continue
+			}
+		}
+		day, ok := days[at.In(f.Location).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		if eventType == EventMessageSent {
+			day.Sent++
+		} else {
+			day.Failed++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if total := result[i].Sent + result[i].Failed; total > 0 {
+			rate := float64(result[i].Sent) / float64(total)
+			result[i].SuccessRate = &rate
+		}
+	}
+	return result, nil
+}
+
+// AgentResponseTimes measures, per agent, the time from the first unanswered incoming message of a
+// chat to the next reply. Replies sent without an agent, e.g. from the phone, are listed under "".
+func (store *MessageStore) AgentResponseTimes(f AnalyticsFilter) ([]AgentResponseTimes, error) {
+	where, args := store.analyticsConditions(f)
+	rows, err := store.db.Query("SELECT chat_jid, timestamp, is_from_me, COALESCE(agent, '') FROM messages WHERE "+where+" ORDER BY chat_jid, timestamp", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	durations := map[string][]float64{}
+	var chat string
+	var waitingSince time.Time
+	for rows.Next() {
+		var chatJID, agent string
+		var at time.Time
+		var fromMe bool
+		if err := rows.Scan(&chatJID, &at, &fromMe, &agent); err != nil {
+			return nil, err
+		}
+		if chatJID != chat {
+			chat, waitingSince = chatJID, time.Time{}
+		}
+		if !fromMe {
+			if waitingSince.IsZero() {
+				waitingSince = at
+			}
+			continue
+		}
+		if !waitingSince.IsZero() && (f.Agent == "" || agent == f.Agent) {
+			durations[agent] = append(durations[agent], at.Sub(waitingSince).Seconds())
+		}
+		waitingSince = time.Time{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []AgentResponseTimes{}
+	for agent, seconds := range durations {
+		sort.Float64s(seconds)
+		var total float64
+		for _, s := range seconds {
+			total += s
+		}
+		median := seconds[len(seconds)/2]
+		if len(seconds)%2 == 0 {
+			median = (seconds[len(seconds)/2-1] + median) / 2
+		}
+		result = append(result, AgentResponseTimes{
+			Agent:          agent,
+			Replies:        len(seconds),
+			AverageSeconds: total / float64(len(seconds)),
+			MedianSeconds:  median,
+			MaxSeconds:     seconds[len(seconds)-1],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Agent < result[j].Agent })
+	return result, nil
+}
+
+// LabelActivityCounts counts, per label, the labeled chats with messages in the period and their
+// messages. A chat with several labels counts under each. An agent filter only narrows the sent messages.
+func (store *MessageStore) LabelActivityCounts(f AnalyticsFilter) ([]LabelActivity, error) {
+	where, args := store.analyticsConditions(f)
+	rows, err := store.db.Query("SELECT l.label, m.chat_jid, m.is_from_me, m.agent FROM "+
+		"(SELECT chat_jid, is_from_me, COALESCE(agent, '') AS agent FROM messages WHERE "+where+") m "+
+		"JOIN chat_labels l ON l.chat_jid = m.chat_jid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := map[string]*LabelActivity{}
+	chats := map[string]map[string]bool{}
+	for rows.Next() {
+		var label, chatJID, agent string
+		var fromMe bool
+		if err := rows.Scan(&label, &chatJID, &fromMe, &agent); err != nil {
+			return nil, err
+		}
+		activity := labels[label]
+		if activity == nil {
+			activity = &LabelActivity{Label: label}
+			labels[label] = activity
+			chats[label] = map[string]bool{}
+		}
+		if !fromMe {
+			activity.Received++
+		} else if f.Agent == "" || agent == f.Agent {
+			activity.Sent++
+		} else {
+			continue
+		}
+		if !chats[label][chatJID] {
+			chats[label][chatJID] = true
+			activity.Chats++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []LabelActivity{}
+	for _, activity := range labels {
+		result = append(result, *activity)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Label < result[j].Label })
+	return result, nil
+}
+
+// writeAnalytics sends a report as JSON, or as a CSV download with ?format=csv
+func writeAnalytics(w http.ResponseWriter, r *http.Request, report string, f AnalyticsFilter, data interface{}, header []string, rows [][]string) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	case "csv":
+		filename := fmt.Sprintf("%s_%s_%s.csv", report, f.From.Format("2006-01-02"), f.To.Format("2006-01-02"))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		out := csv.NewWriter(w)
+		out.Write(header)
+		out.WriteAll(rows)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// formatSeconds renders a duration in seconds for CSV, rounded to the second
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 0, 64)
+}
+
+// formatSentiment renders an average score for CSV
+func formatSentiment(score float64) string {
+	return strconv.FormatFloat(score, 'f', 3, 64)
+}
+
+// registerAnalyticsRoutes registers /api/v1/analytics/{messages,delivery,response-times,sentiment,sentiment-chats,labels}
+func registerAnalyticsRoutes(messageStore *MessageStore) {
+	handleAPI("/analytics/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report := r.URL.Path[strings.LastIndex(r.URL.Path, "/analytics/")+len("/analytics/"):]
+
+		filter, err := parseAnalyticsFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.ChatJID != "" {
+			legalHolds.RecordRequest(r, filter.ChatJID)
+		}
+
+		switch report {
+		case "messages":
+			days, err := messageStore.DailyMessageCounts(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to count messages: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, day := range days {
+				rows = append(rows, []string{day.Date, strconv.Itoa(day.Received), strconv.Itoa(day.Sent)})
+			}
+			writeAnalytics(w, r, report, filter, days, []string{"date", "received", "sent"}, rows)
+
+		case "delivery":
+			days, err := messageStore.DailyDeliveryCounts(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to count sends: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, day := range days {
+				rate := ""
+				if day.SuccessRate != nil {
+					rate = strconv.FormatFloat(*day.SuccessRate, 'f', 4, 64)
+				}
+				rows = append(rows, []string{day.Date, strconv.Itoa(day.Sent), strconv.Itoa(day.Failed), rate})
+			}
+			writeAnalytics(w, r, report, filter, days, []string{"date", "sent", "failed", "success_rate"}, rows)
+
+		case "response-times":
+			agents, err := messageStore.AgentResponseTimes(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to measure response times: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, agent := range agents {
+				rows = append(rows, []string{agent.Agent, strconv.Itoa(agent.Replies),
+					formatSeconds(agent.AverageSeconds), formatSeconds(agent.MedianSeconds), formatSeconds(agent.MaxSeconds)})
+			}
+			writeAnalytics(w, r, "response_times", filter, agents, []string{"agent", "replies", "average_seconds", "median_seconds", "max_seconds"}, rows)
+
+		case "sentiment":
+			days, err := messageStore.DailySentimentScores(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to summarize sentiment: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, day := range days {
+				average := ""
+				if day.Average != nil {
+					average = formatSentiment(*day.Average)
+				}
+				rows = append(rows, []string{day.Date, strconv.Itoa(day.Scored), average,
+					strconv.Itoa(day.Positive), strconv.Itoa(day.Neutral), strconv.Itoa(day.Negative)})
+			}
+			writeAnalytics(w, r, report, filter, days, []string{"date", "scored", "average", "positive", "neutral", "negative"}, rows)
+
+		case "sentiment-chats":
+			chats, err := messageStore.ChatSentimentScores(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to summarize sentiment: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, chat := range chats {
+				rows = append(rows, []string{chat.ChatJID, chat.Name, strconv.Itoa(chat.Scored), formatSentiment(chat.Average),
+					formatSentiment(chat.RecentAverage), formatSentiment(chat.Trend), strconv.Itoa(chat.Negative),
+					chat.LastMessageAt.In(filter.Location).Format(time.RFC3339)})
+			}
+			writeAnalytics(w, r, "sentiment_chats", filter, chats,
+				[]string{"chat_jid", "name", "scored", "average", "recent_average", "trend", "negative", "last_message_at"}, rows)
+
+		case "labels":
+			labels, err := messageStore.LabelActivityCounts(filter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to count labeled chats: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var rows [][]string
+			for _, label := range labels {
+				rows = append(rows, []string{label.Label, strconv.Itoa(label.Chats), strconv.Itoa(label.Received), strconv.Itoa(label.Sent)})
+			}
+			writeAnalytics(w, r, report, filter, labels, []string{"label", "chats", "received", "sent"}, rows)
+
+		default:
+			http.Error(w, "Unknown report; use messages, delivery, response-times, sentiment, sentiment-chats or labels", http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// API key scopes. A key with the admin scope may do everything.
+const (
+	ScopeRead  = "read"
+	ScopeSend  = "send"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// apiKeyScopes lists the scopes in the order they are reported
+var apiKeyScopes = []string{ScopeRead, ScopeSend, ScopeWrite, ScopeAdmin}
+
+// apiKeyCookie carries the key of dashboard pages, whose requests and event streams can't set headers
+const apiKeyCookie = "bridge_api_key"
+
+// apiKeyCacheTTL is how long a key lookup is reused, and so how long a revocation takes to reach
+// other instances
+const apiKeyCacheTTL = 30 * time.Second
+
+// apiKeyTouchInterval limits how often a key's last use is written
+const apiKeyTouchInterval = time.Minute
+
+// apiKeysRequired rejects /api requests without a key; keys that are present but invalid are
+// always rejected
+var apiKeysRequired = true
+
+// errAPIKeyNotFound is returned when revoking a key that doesn't exist or is already revoked
+var errAPIKeyNotFound = errors.New("API key not found")
+
+// APIKey is a key issued by the bridge. Only a hash of the key is stored; Key is set once, in the
+// response that creates it. ID is the key_ ID that usage, quotas and tenants know the key by.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest is the body of POST /api/v1/keys
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// allows reports whether a key may make a request needing scope
+func (key *APIKey) allows(scope string) bool {
+	return containsString(key.Scopes, scope) || containsString(key.Scopes, ScopeAdmin)
+}
+
+// normalizeScopes checks scopes and returns them lowercased, in the order of apiKeyScopes
+func normalizeScopes(scopes []string) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !containsString(apiKeyScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q (expected %s)", scope, strings.Join(apiKeyScopes, ", "))
+		}
+		wanted[scope] = true
+	}
+	if len(wanted) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	normalized := []string{}
+	for _, scope := range apiKeyScopes {
+		if wanted[scope] {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
+
+// requestAPIKey returns the key of a request, from the X-API-Key header or the dashboard cookie
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if cookie, err := r.Cookie(apiKeyCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// hashAPIKey returns the stored hash of a key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// adminRoutes need the admin scope whatever the method: they erase data, link or unlink WhatsApp
+// sessions, break the bridge on purpose or show the legal hold trail
+var adminRoutes = map[string]bool{
+	"/gdpr/erase":               true,
+	"/session/lock/acknowledge": true,
+	"/pair/phone":               true,
+	"/accounts":                 true,
+	"/chaos":                    true,
+	"/legal-holds/audit":        true,
+}
+
+// requiredScope returns the scope an API request needs: admin for /admin routes, key management,
+// every key's usage, adminRoutes, managing linked accounts and releasing legal holds; read for
+// lookups, send for sending messages and write for other changes
+func requiredScope(r *http.Request) string {
+	route := apiRoute(r)
+	switch {
+	case strings.HasPrefix(route, "/admin/"), route == "/keys", strings.HasPrefix(route, "/keys/"), adminRoutes[route]:
+		return ScopeAdmin
+	case route == "/usage" && r.URL.Query().Get("all") != "":
+		return ScopeAdmin
+	case strings.HasPrefix(route, "/chats/") && strings.HasSuffix(route, "/hold") && r.Method == http.MethodDelete:
+		// A released hold lets the chat be erased
+		return ScopeAdmin
+	case strings.HasPrefix(route, "/accounts/") && !accountDataRoute(route):
+		return ScopeAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || readOnlyRoutes[route]:
+		return ScopeRead
+	case outboundRoutes[route], strings.HasPrefix(route, "/send/"), strings.HasSuffix(route, "/send"), strings.HasPrefix(route, "/uploads/"),
+		strings.HasSuffix(route, "/typing"):
+		return ScopeSend
+	}
+	return ScopeWrite
+}
+
+// accountDataRoute reports whether an /accounts/{id} route sends or reads messages of a linked
+// account, which need the usual scopes, rather than managing the account
+func accountDataRoute(route string) bool {
+	_, resource, _ := strings.Cut(strings.TrimPrefix(route, "/accounts/"), "/")
+	return resource == "send" || resource == "chats" || strings.HasPrefix(resource, "chats/")
+}
+
+// apiKeyLookup is a cached key lookup; key is nil for hashes that aren't a valid key
+type apiKeyLookup struct {
+	key       *APIKey
+	fetchedAt time.Time
+}
+
+// APIKeyRegistry checks the keys of API requests, caching lookups briefly
+type APIKeyRegistry struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	lookups      map[string]apiKeyLookup
+	touched      map[string]time.Time
+	mutex        sync.Mutex
+}
+
+// apiKeys is set once the message store is open
+var apiKeys *APIKeyRegistry
+
+// NewAPIKeyRegistry creates the registry of keys issued by the bridge
+func NewAPIKeyRegistry(messageStore *MessageStore, logger waLog.Logger) *APIKeyRegistry {
+	return &APIKeyRegistry{
+		messageStore: messageStore,
+		logger:       logger,
+		lookups:      make(map[string]apiKeyLookup),
+		touched:      make(map[string]time.Time),
+	}
+}
+
+// Lookup returns the active key a request presents, or nil for keys the bridge didn't issue or revoked
+func (k *APIKeyRegistry) Lookup(key string) (*APIKey, error) {
+	hash := hashAPIKey(key)
+	now := time.Now()
+
+	k.mutex.Lock()
+	lookup, ok := k.lookups[hash]
+	k.mutex.Unlock()
+	if !ok || now.Sub(lookup.fetchedAt) > apiKeyCacheTTL {
+		found, err := k.messageStore.GetAPIKeyByHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		if found != nil && found.RevokedAt != nil {
+			found = nil
+		}
+		lookup = apiKeyLookup{key: found, fetchedAt: now}
+		k.mutex.Lock()
+		k.lookups[hash] = lookup
+		k.mutex.Unlock()
+	}
+	if lookup.key != nil {
+		k.touch(lookup.key.ID, now)
+	}
+	return lookup.key, nil
+}
+
+// Forget drops cached lookups, so a revocation applies on this instance right away
+func (k *APIKeyRegistry) Forget() {
+	k.mutex.Lock()
+	k.lookups = make(map[string]apiKeyLookup)
+	k.mutex.Unlock()
+}
+
+// touch records the last use of a key, at most once per apiKeyTouchInterval
+func (k *APIKeyRegistry) touch(id string, now time.Time) {
+	k.mutex.Lock()
+	if now.Sub(k.touched[id]) < apiKeyTouchInterval {
+		k.mutex.Unlock()
+		return
+	}
+	k.touched[id] = now
+	k.mutex.Unlock()
+
+	if err := k.messageStore.TouchAPIKey(id, now.UTC()); err != nil {
+		k.logger.Warnf("Failed to record use of API key %s: %v", id, err)
+	}
+}
+
+// apiKeyMiddleware checks the key of /api requests and the scope the request needs. Requests
+// without a key pass only when API_KEYS_REQUIRED is turned off; a key the bridge didn't issue, or
+// revoked, is refused either way. /api/health stays open for load balancers.
+func apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.Method == http.MethodOptions || apiRoute(r) == "/health" || apiKeys == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		presented := requestAPIKey(r)
+		if presented == "" {
+			if apiKeysRequired {
+				http.Error(w, "An API key is required; send it in the X-API-Key header", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := apiKeys.Lookup(presented)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if key == nil {
+			http.Error(w, "Invalid or revoked API key", http.StatusUnauthorized)
+			return
+		}
+		if scope := requiredScope(r); !key.allows(scope) {
+			http.Error(w, fmt.Sprintf("API key %s lacks the %s scope", key.ID, scope), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAPIKey creates a key with its ID; the ID matches the usage subject of the key
+func newAPIKey(name string, scopes []string) (*APIKey, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := "wab_" + hex.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(key))
+	return &APIKey{
+		ID:        "key_" + hex.EncodeToString(sum[:8]),
+		Name:      name,
+		Scopes:    scopes,
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// CreateAPIKey issues a key after checking its name and scopes. The returned key holds the secret.
+func (store *MessageStore) CreateAPIKey(name string, scopes []string) (*APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 200 {
+		return nil, fmt.Errorf("name is required and must be at most 200 characters")
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newAPIKey(name, scopes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	query := "INSERT INTO api_keys (id, name, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO api_keys (id, name, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5)"
+	}
+	if _, err := store.db.Exec(query, key.ID, key.Name, hashAPIKey(key.Key), strings.Join(key.Scopes, ","), key.CreatedAt); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// hasActiveAPIKey reports whether any of keys is not revoked
+func hasActiveAPIKey(keys []APIKey) bool {
+	for _, key := range keys {
+		if key.RevokedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyColumns are the columns scanAPIKey reads, in order
+const apiKeyColumns = "id, name, scopes, created_at, last_used_at, revoked_at"
+
+// scanAPIKey reads a row of apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &scopes, &key.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByHash returns the key with a hash, or nil if the bridge never issued it
+func (store *MessageStore) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = ?"
+	if store.isPostgres {
+		query = "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = $1"
+	}
+	key, err := scanAPIKey(store.db.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys returns every issued key, newest first, including revoked ones
+func (store *MessageStore) ListAPIKeys() ([]APIKey, error) {
+	rows, err := store.db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key for good
+func (store *MessageStore) RevokeAPIKey(id string) error {
+	query := "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	if store.isPostgres {
+		query = "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL"
+	}
+	result, err := store.db.Exec(query, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records when a key was last used
+func (store *MessageStore) TouchAPIKey(id string, at time.Time) error {
+	query := "UPDATE api_keys SET last_used_at = ? WHERE id = ?"
+	if store.isPostgres {
+		query = "UPDATE api_keys SET last_used_at = $1 WHERE id = $2"
+	}
+	_, err := store.db.Exec(query, at, id)
+	return err
+}
+
+// localize converts the times of a key to a response's time zone
+func (key *APIKey) localize(loc *time.Location) {
+	key.CreatedAt = key.CreatedAt.In(loc)
+	for _, at := range []*time.Time{key.LastUsedAt, key.RevokedAt} {
+		if at != nil {
+			*at = at.In(loc)
+		}
+	}
+}
+
+// registerAPIKeyRoutes registers /api/v1/keys and /api/v1/keys/{id}
+func registerAPIKeyRoutes(messageStore *MessageStore) {
+	handleAPI("/keys", dashboardAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := messageStore.ListAPIKeys()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list API keys: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for i := range keys {
+				keys[i].localize(loc)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(keys)
+
+		case http.MethodPost:
+			var req CreateAPIKeyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			key, err := messageStore.CreateAPIKey(req.Name, req.Scopes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			key.localize(loc)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(key)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	handleAPI("/keys/", dashboardAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(apiRoute(r), "/keys/")
+		err := messageStore.RevokeAPIKey(id)
+		if errors.Is(err, errAPIKeyNotFound) {
+			http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to revoke API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		apiKeys.Forget()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// runAPIKeyCommand creates, revokes or lists keys from the command line, e.g. to issue the first
+// admin key, which the API can't hand out without one
+func runAPIKeyCommand(logger waLog.Logger, create, scopes, revoke string, list bool) error {
+	dbAdapter := NewDatabaseAdapter(logger)
+	if _, err := dbAdapter.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+	messageStore, err := NewMessageStore(dbAdapter)
+	if err != nil {
+		return fmt.Errorf("failed to initialize message store: %v", err)
+	}
+	defer messageStore.Close()
+
+	switch {
+	case create != "":
+		key, err := messageStore.CreateAPIKey(create, strings.Split(scopes, ","))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Created %s (%s) with scopes %s. Store the key now; it can't be shown again:\n%s\n",
+			key.ID, key.Name, strings.Join(key.Scopes, ","), key.Key)
+
+	case revoke != "":
+		if err := messageStore.RevokeAPIKey(revoke); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Revoked %s\n", revoke)
+
+	case list:
+		keys, err := messageStore.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(os.Stdout, "%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), state)
+		}
+	}
+	return nil
+}
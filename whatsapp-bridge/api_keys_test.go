@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{http.MethodGet, "/api/v1/chats", ScopeRead},
+		{http.MethodGet, "/api/v1/download", ScopeRead},
+		{http.MethodPost, "/api/v1/download", ScopeRead},
+		{http.MethodPost, "/api/v1/send", ScopeSend},
+		{http.MethodPost, "/api/send", ScopeSend},
+		{http.MethodPost, "/api/v1/send/voice", ScopeSend},
+		{http.MethodPut, "/api/v1/uploads/abc", ScopeSend},
+		{http.MethodPost, "/api/v1/chats/123@s.whatsapp.net/typing", ScopeSend},
+		{http.MethodPost, "/api/v1/chats/123@s.whatsapp.net/labels", ScopeWrite},
+		{http.MethodPut, "/api/v1/chats/123@s.whatsapp.net/hold", ScopeWrite},
+		{http.MethodGet, "/api/v1/chats/123@s.whatsapp.net/hold", ScopeRead},
+		{http.MethodDelete, "/api/v1/chats/123@s.whatsapp.net/hold", ScopeAdmin},
+		{http.MethodGet, "/api/v1/legal-holds", ScopeRead},
+		{http.MethodGet, "/api/v1/legal-holds/audit", ScopeAdmin},
+		{http.MethodPost, "/api/v1/gdpr/erase", ScopeAdmin},
+		{http.MethodGet, "/api/v1/session/lock", ScopeRead},
+		{http.MethodPost, "/api/v1/session/lock/acknowledge", ScopeAdmin},
+		{http.MethodPost, "/api/v1/pair/phone", ScopeAdmin},
+		{http.MethodPost, "/api/v1/chaos", ScopeAdmin},
+		{http.MethodGet, "/api/v1/chaos", ScopeAdmin},
+		{http.MethodGet, "/api/v1/accounts", ScopeAdmin},
+		{http.MethodPost, "/api/v1/accounts", ScopeAdmin},
+		{http.MethodDelete, "/api/v1/accounts/sales", ScopeAdmin},
+		{http.MethodPost, "/api/v1/accounts/sales/connect", ScopeAdmin},
+		{http.MethodPost, "/api/v1/accounts/sales/pair-phone", ScopeAdmin},
+		{http.MethodPost, "/api/v1/accounts/sales/send", ScopeSend},
+		{http.MethodGet, "/api/v1/accounts/sales/chats", ScopeRead},
+		{http.MethodGet, "/api/v1/accounts/sales/chats/123@s.whatsapp.net/messages", ScopeRead},
+		{http.MethodGet, "/api/v1/keys", ScopeAdmin},
+		{http.MethodDelete, "/api/v1/keys/key_2bb80d537b1da3e3", ScopeAdmin},
+		{http.MethodGet, "/api/v1/admin/logins", ScopeAdmin},
+		{http.MethodGet, "/api/v1/usage", ScopeRead},
+		{http.MethodGet, "/api/v1/usage?all=true", ScopeAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if scope := requiredScope(httptest.NewRequest(tt.method, tt.path, nil)); scope != tt.scope {
+				t.Errorf("got %s, want %s", scope, tt.scope)
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	store := newTestMessageStore(t)
+	previousKeys, previousRequired, previousAuth := apiKeys, apiKeysRequired, localAuth
+	apiKeys, localAuth = NewAPIKeyRegistry(store, waLog.Noop), nil
+	t.Cleanup(func() { apiKeys, apiKeysRequired, localAuth = previousKeys, previousRequired, previousAuth })
+
+	keys := map[string]string{"": "", "unissued": "wab_0000"}
+	for _, scope := range apiKeyScopes {
+		key, err := store.CreateAPIKey(scope+" key", []string{scope})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[scope] = key.Key
+	}
+	revoked, err := store.CreateAPIKey("revoked key", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAPIKey(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	keys["revoked"] = revoked.Key
+
+	// Key management is wrapped like registerAPIKeyRoutes wraps it
+	handler := apiKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(apiRoute(r), "/keys") {
+			dashboardAdminOnly(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		optional bool
+		key      string
+		method   string
+		path     string
+		status   int
+	}{
+		{"health stays open", false, "", http.MethodGet, "/api/v1/health", http.StatusOK},
+		{"pages aren't checked", false, "", http.MethodGet, "/qr", http.StatusOK},
+		{"no key", false, "", http.MethodGet, "/api/v1/chats", http.StatusUnauthorized},
+		{"no key when optional", true, "", http.MethodPost, "/api/v1/send", http.StatusOK},
+		{"unissued key", false, "unissued", http.MethodGet, "/api/v1/chats", http.StatusUnauthorized},
+		{"unissued key when optional", true, "unissued", http.MethodGet, "/api/v1/chats", http.StatusUnauthorized},
+		{"revoked key", false, "revoked", http.MethodGet, "/api/v1/chats", http.StatusUnauthorized},
+		{"read key reads", false, ScopeRead, http.MethodGet, "/api/v1/chats", http.StatusOK},
+		{"read key can't send", false, ScopeRead, http.MethodPost, "/api/v1/send", http.StatusForbidden},
+		{"send key sends", false, ScopeSend, http.MethodPost, "/api/send", http.StatusOK},
+		{"send key can't write", false, ScopeSend, http.MethodPost, "/api/v1/chats/123@s.whatsapp.net/labels", http.StatusForbidden},
+		{"write key places a hold", false, ScopeWrite, http.MethodPut, "/api/v1/chats/123@s.whatsapp.net/hold", http.StatusOK},
+		{"write key can't release a hold", false, ScopeWrite, http.MethodDelete, "/api/v1/chats/123@s.whatsapp.net/hold", http.StatusForbidden},
+		{"write key can't erase", false, ScopeWrite, http.MethodPost, "/api/v1/gdpr/erase", http.StatusForbidden},
+		{"write key can't add accounts", false, ScopeWrite, http.MethodPost, "/api/v1/accounts", http.StatusForbidden},
+		{"write key can't issue keys", false, ScopeWrite, http.MethodPost, "/api/v1/keys", http.StatusForbidden},
+		{"admin key erases", false, ScopeAdmin, http.MethodPost, "/api/v1/gdpr/erase", http.StatusOK},
+		{"admin key issues keys", false, ScopeAdmin, http.MethodPost, "/api/v1/keys", http.StatusOK},
+		{"no key can't issue keys when optional", true, "", http.MethodPost, "/api/v1/keys", http.StatusUnauthorized},
+		{"no key can't revoke keys when optional", true, "", http.MethodDelete, "/api/v1/keys/key_2bb80d537b1da3e3", http.StatusUnauthorized},
+		{"admin key issues keys when optional", true, ScopeAdmin, http.MethodPost, "/api/v1/keys", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiKeysRequired = !tt.optional
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if key := keys[tt.key]; key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.status {
+				t.Errorf("got %d (%s), want %d", recorder.Code, strings.TrimSpace(recorder.Body.String()), tt.status)
+			}
+		})
+	}
+}
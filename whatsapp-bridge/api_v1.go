@@ -0,0 +1,214 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiV1Prefix is the root of the current versioned API
+const apiV1Prefix = "/api/v1"
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// APIMessage is the v1 representation of a stored message
+type APIMessage struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	// SystemEvent is set on notices such as group participant changes, to the event type
+	SystemEvent string `json:"system_event,omitempty"`
+	// ClientRef is the reference the sender passed to /send
+	ClientRef string `json:"client_ref,omitempty"`
+	// Agent is the dashboard user who sent the message
+	Agent string `json:"agent,omitempty"`
+	// ReplyTo is the ID of the message this one quotes
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Status is received for inbound messages, and sent, delivered, read or played for outbound ones
+	Status string `json:"status,omitempty"`
+	// Reactions maps each emoji to the number of people who reacted with it
+	Reactions map[string]int `json:"reactions,omitempty"`
+	// MyReaction is the emoji this account reacted with
+	MyReaction string `json:"my_reaction,omitempty"`
+}
+
+// APIChat is the v1 representation of a chat
+type APIChat struct {
+	JID             string    `json:"jid"`
+	Name            string    `json:"name"`
+	LastMessageTime time.Time `json:"last_message_time"`
+}
+
+// handleAPI registers a handler under /api/v1 and keeps the unversioned /api path as a deprecated alias
+func handleAPI(path string, handler http.HandlerFunc) {
+	http.HandleFunc(apiV1Prefix+path, handler)
+	handleLegacyAPI("/api"+path, func(r *http.Request) string {
+		return apiV1Prefix + strings.TrimPrefix(r.URL.Path, "/api")
+	}, handler)
+}
+
+// handleLegacyAPI registers an unversioned route that is kept for one release.
+// Responses carry Deprecation and Link headers pointing clients at the v1 successor.
+func handleLegacyAPI(path string, successor func(r *http.Request) string, handler http.HandlerFunc) {
+	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", withBasePath(successor(r))))
+		handler(w, r)
+	})
+}
+
+// apiRoute returns the request path relative to the API root, so handlers
+// shared between /api and /api/v1 can parse path parameters the same way
+func apiRoute(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, apiV1Prefix+"/") {
+		return strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+	}
+	return strings.TrimPrefix(r.URL.Path, "/api")
+}
+
+// ListChats returns all chats, most recently active first
+func (store *MessageStore) ListChats() ([]APIChat, error) {
+	return cached(cacheKeyChatList, cacheTTL, store.queryChats)
+}
+
+// queryChats reads the chat list from the database
+func (store *MessageStore) queryChats() ([]APIChat, error) {
+	rows, err := store.db.Query("SELECT jid, COALESCE(name, ''), last_message_time FROM chats ORDER BY last_message_time DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []APIChat{}
+	for rows.Next() {
+		var chat APIChat
+		if err := rows.Scan(&chat.JID, &chat.Name, &chat.LastMessageTime); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+
+	return chats, rows.Err()
+}
+
+// ListMessages returns the most recent messages of a chat, newest first
+func (store *MessageStore) ListMessages(chatJID string, limit int) ([]APIMessage, error) {
+	var query string
+	if store.isPostgres {
+		query = "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '') FROM messages WHERE chat_jid = $1 ORDER BY timestamp DESC LIMIT $2"
+	} else {
+		query = "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '') FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?"
+	}
+
+	rows, err := store.db.Query(query, chatJID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []APIMessage{}
+	for rows.Next() {
+		var msg APIMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.SystemEvent, &msg.ClientRef, &msg.Agent, &msg.ReplyTo, &msg.Status); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// registerV1Routes registers the v1 endpoints whose JSON shape differs from the legacy routes
+func registerV1Routes(messageStore *MessageStore) {
+	// Handler for listing chats
+	http.HandleFunc(apiV1Prefix+"/chats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		chats, err := messageStore.ListChats()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get chats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// ?label= narrows the list to the chats with that label
+		if label := r.URL.Query().Get("label"); label != "" {
+			labeled, err := messageStore.ChatsWithLabel(label)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get labels: %v", err), http.StatusInternalServerError)
+				return
+			}
+			filtered := []APIChat{}
+			for _, chat := range chats {
+				if labeled[chat.JID] {
+					filtered = append(filtered, chat)
+				}
+			}
+			chats = filtered
+		}
+		for i := range chats {
+			chats[i].LastMessageTime = chats[i].LastMessageTime.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chats)
+	})
+
+	// Handler for listing the messages of a chat (/api/v1/chats/{jid}/messages)
+	registerChatRoute("messages", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := 100 // Default limit
+		if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+
+		messages, err := messageStore.ListMessages(chatJID, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := messageStore.attachReactions(chatJID, messages); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get reactions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range messages {
+			messages[i].Timestamp = messages[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	})
+
+	// Handler for the OpenAPI description of the v1 API
+	http.HandleFunc(apiV1Prefix+"/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// auditExportCheckInterval is how often the exporter looks for finished days to export or retry
+const auditExportCheckInterval = time.Hour
+
+// AuditRecord is an outbound message in the audit export: who sent it, when, and what became of it.
+// Messages the outbox gave up on have an outbox ID instead of a message ID.
+type AuditRecord struct {
+	MessageID string     `json:"message_id,omitempty"`
+	OutboxID  string     `json:"outbox_id,omitempty"`
+	ChatJID   string     `json:"chat_jid"`
+	Timestamp time.Time  `json:"timestamp"`
+	APIKey    string     `json:"api_key,omitempty"`
+	Agent     string     `json:"agent,omitempty"`
+	ClientRef string     `json:"client_ref,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	MediaType string     `json:"media_type,omitempty"`
+	Filename  string     `json:"filename,omitempty"`
+	Outcome   string     `json:"outcome"`
+	OutcomeAt *time.Time `json:"outcome_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"`
+}
+
+// AuditManifest describes a day's export. Each manifest names the hash of the one before it, so a
+// removed or altered day breaks the chain, and the signature shows the bridge wrote it.
+type AuditManifest struct {
+	Day                    string    `json:"day"`
+	Object                 string    `json:"object"`
+	Records                int       `json:"records"`
+	SHA256                 string    `json:"sha256"`
+	PreviousManifestSHA256 string    `json:"previous_manifest_sha256"`
+	ExportedAt             time.Time `json:"exported_at"`
+	Signature              string    `json:"signature"`
+}
+
+// AuditExport is a day that was delivered, served by /api/v1/admin/audit-exports
+type AuditExport struct {
+	Day            string    `json:"day"`
+	Object         string    `json:"object"`
+	Records        int       `json:"records"`
+	SHA256         string    `json:"sha256"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	ExportedAt     time.Time `json:"exported_at"`
+}
+
+// AuditExporter uploads every finished UTC day of outbound messages to S3 for compliance retention
+type AuditExporter struct {
+	messageStore  *MessageStore
+	logger        waLog.Logger
+	bucket        *S3Bucket
+	prefix        string
+	secret        string
+	retentionDays int
+	firstDay      string
+}
+
+// NewAuditExporterFromEnv returns nil when AUDIT_EXPORT_S3_BUCKET is not set
+func NewAuditExporterFromEnv(messageStore *MessageStore, logger waLog.Logger) (*AuditExporter, error) {
+	bucketName := os.Getenv("AUDIT_EXPORT_S3_BUCKET")
+	if bucketName == "" {
+		return nil, nil
+	}
+
+	secret := os.Getenv("AUDIT_EXPORT_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("AUDIT_EXPORT_SECRET is required to sign audit exports")
+	}
+	region := os.Getenv("AUDIT_EXPORT_S3_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	bucket, err := NewS3BucketFromEnv(bucketName, region, os.Getenv("AUDIT_EXPORT_S3_ENDPOINT"))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := os.Getenv("AUDIT_EXPORT_S3_PREFIX")
+	if prefix == "" {
+		prefix = "whatsapp-audit/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	exporter := &AuditExporter{
+		messageStore:  messageStore,
+		logger:        logger,
+		bucket:        bucket,
+		prefix:        strings.TrimPrefix(prefix, "/"),
+		secret:        secret,
+		retentionDays: getEnvInt("AUDIT_EXPORT_RETENTION_DAYS", 0),
+		firstDay:      os.Getenv("AUDIT_EXPORT_START"),
+	}
+	if exporter.retentionDays < 0 {
+		return nil, fmt.Errorf("AUDIT_EXPORT_RETENTION_DAYS must not be negative")
+	}
+	if exporter.firstDay != "" {
+		if _, err := time.Parse("2006-01-02", exporter.firstDay); err != nil {
+			return nil, fmt.Errorf("AUDIT_EXPORT_START must be a date such as 2025-01-31")
+		}
+	}
+	return exporter, nil
+}
+
+// Start exports the finished days now and checks again every hour
+func (a *AuditExporter) Start() {
+	go func() {
+		for {
+			a.exportPending(time.Now())
+			time.Sleep(auditExportCheckInterval)
+		}
+	}()
+}
+
+// pendingDays returns the finished days not exported yet, oldest first: the days after the last
+// export, or from AUDIT_EXPORT_START, or just yesterday on the first run
+func (a *AuditExporter) pendingDays(now time.Time) ([]time.Time, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	next := today.AddDate(0, 0, -1)
+
+	last, err := a.messageStore.LastAuditExport()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case last != nil:
+		day, _ := time.Parse("2006-01-02", last.Day)
+		next = day.AddDate(0, 0, 1)
+	case a.firstDay != "":
+		next, _ = time.Parse("2006-01-02", a.firstDay)
+	}
+
+	days := []time.Time{}
+	for day := next; day.Before(today); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// exportPending exports the pending days in order. A failed day stops the run, so the chain never
+// skips one; it is retried on the next check.
+func (a *AuditExporter) exportPending(now time.Time) {
+	// Replicas share the database, so only the leader exports
+	if leaderElector != nil && !leaderElector.IsLeader() {
+		return
+	}
+
+	days, err := a.pendingDays(now)
+	if err != nil {
+		a.logger.Warnf("Failed to find days to export for the audit: %v", err)
+		return
+	}
+	for _, day := range days {
+		export, err := a.exportDay(day)
+		if err != nil {
+			a.logger.Warnf("Failed to export the audit of %s: %v", day.Format("2006-01-02"), err)
+			return
+		}
+		a.logger.Infof("Exported %d outbound messages of %s to s3://%s/%s", export.Records, export.Day, a.bucket.bucket, export.Object)
+	}
+}
+
+// exportDay uploads a day's records and its signed manifest, then records the export
+func (a *AuditExporter) exportDay(day time.Time) (*AuditExport, error) {
+	records, err := a.messageStore.AuditRecords(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+
+	previous := ""
+	if last, err := a.messageStore.LastAuditExport(); err != nil {
+		return nil, err
+	} else if last != nil {
+		previous = last.ManifestSHA256
+	}
+
+	base := a.prefix + day.Format("2006/01/02") + "/"
+	dataSum := sha256.Sum256(data.Bytes())
+	manifest := AuditManifest{
+		Day:                    day.Format("2006-01-02"),
+		Object:                 base + "outbound.jsonl",
+		Records:                len(records),
+		SHA256:                 hex.EncodeToString(dataSum[:]),
+		PreviousManifestSHA256: previous,
+		ExportedAt:             time.Now().UTC(),
+	}
+	manifest.Signature = "sha256=" + hex.EncodeToString(hmacSHA256([]byte(a.secret), manifest.signedString()))
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	if a.retentionDays > 0 {
+		headers["X-Amz-Object-Lock-Mode"] = "COMPLIANCE"
+		headers["X-Amz-Object-Lock-Retain-Until-Date"] = manifest.ExportedAt.AddDate(0, 0, a.retentionDays).Format(time.RFC3339)
+	}
+	if err := a.bucket.PutObject(manifest.Object, "application/x-ndjson", data.Bytes(), headers); err != nil {
+		return nil, err
+	}
+	if err := a.bucket.PutObject(base+"manifest.json", "application/json", manifestData, headers); err != nil {
+		return nil, err
+	}
+
+	manifestSum := sha256.Sum256(manifestData)
+	export := &AuditExport{
+		Day:            manifest.Day,
+		Object:         manifest.Object,
+		Records:        manifest.Records,
+		SHA256:         manifest.SHA256,
+		ManifestSHA256: hex.EncodeToString(manifestSum[:]),
+		ExportedAt:     manifest.ExportedAt,
+	}
+	return export, a.messageStore.SaveAuditExport(*export)
+}
+
+// signedString is what the manifest signature covers: the day, the object, the record count, the
+// hash of the records, the hash of the previous manifest and the export time as it appears in the
+// manifest, one per line
+func (m AuditManifest) signedString() string {
+	return fmt.Sprintf("%s\n%s\n%d\n%s\n%s\n%s", m.Day, m.Object, m.Records, m.SHA256, m.PreviousManifestSHA256,
+		m.ExportedAt.Format(time.RFC3339Nano))
+}
+
+// AuditRecords returns the outbound messages sent in [from, to), and the queued sends that failed
+// in it, oldest first
+func (store *MessageStore) AuditRecords(from, to time.Time) ([]AuditRecord, error) {
+	query := `SELECT id, chat_jid, timestamp, COALESCE(api_key, ''), COALESCE(agent, ''), COALESCE(client_ref, ''),
+		COALESCE(content, ''), COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(status, ''), status_at
+		FROM messages WHERE is_from_me AND system_event IS NULL AND timestamp >= ? AND timestamp < ?`
+	if store.isPostgres {
+		query = `SELECT id, chat_jid, timestamp, COALESCE(api_key, ''), COALESCE(agent, ''), COALESCE(client_ref, ''),
+		COALESCE(content, ''), COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(status, ''), status_at
+		FROM messages WHERE is_from_me AND system_event IS NULL AND timestamp >= $1 AND timestamp < $2`
+	}
+	rows, err := store.db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var record AuditRecord
+		var outcomeAt sql.NullTime
+		if err := rows.Scan(&record.MessageID, &record.ChatJID, &record.Timestamp, &record.APIKey, &record.Agent, &record.ClientRef,
+			&record.Content, &record.MediaType, &record.Filename, &record.Outcome, &outcomeAt); err != nil {
+			return nil, err
+		}
+		if record.Outcome == "" {
+			record.Outcome = MessageStatusSent
+		}
+		if outcomeAt.Valid {
+			at := outcomeAt.Time.UTC()
+			record.OutcomeAt = &at
+		}
+		record.Timestamp = record.Timestamp.UTC()
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT id, chat_jid, created_at, COALESCE(api_key, ''), COALESCE(agent, ''), COALESCE(client_ref, ''),
+		COALESCE(message, ''), COALESCE(error, ''), COALESCE(error_code, '')
+		FROM outbox WHERE status = ? AND created_at >= ? AND created_at < ?`
+	if store.isPostgres {
+		query = `SELECT id, chat_jid, created_at, COALESCE(api_key, ''), COALESCE(agent, ''), COALESCE(client_ref, ''),
+		COALESCE(message, ''), COALESCE(error, ''), COALESCE(error_code, '')
+		FROM outbox WHERE status = $1 AND created_at >= $2 AND created_at < $3`
+	}
+	failed, err := store.db.Query(query, OutboxFailed, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer failed.Close()
+
+	for failed.Next() {
+		record := AuditRecord{Outcome: OutboxFailed}
+		if err := failed.Scan(&record.OutboxID, &record.ChatJID, &record.Timestamp, &record.APIKey, &record.Agent, &record.ClientRef,
+			&record.Content, &record.Error, &record.ErrorCode); err != nil {
+			return nil, err
+		}
+		record.Timestamp = record.Timestamp.UTC()
+		records = append(records, record)
+	}
+	if err := failed.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records, nil
+}
+
+// scanAuditExport reads a row of an audit_exports query
+func scanAuditExport(row interface{ Scan(...interface{}) error }) (*AuditExport, error) {
+	var export AuditExport
+	if err := row.Scan(&export.Day, &export.Object, &export.Records, &export.SHA256, &export.ManifestSHA256, &export.ExportedAt); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// SaveAuditExport records a delivered day
+func (store *MessageStore) SaveAuditExport(export AuditExport) error {
+	query := `INSERT INTO audit_exports (day, object, records, sha256, manifest_sha256, exported_at) VALUES (?, ?, ?, ?, ?, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO audit_exports (day, object, records, sha256, manifest_sha256, exported_at) VALUES ($1, $2, $3, $4, $5, $6)`
+	}
+	_, err := store.db.Exec(query, export.Day, export.Object, export.Records, export.SHA256, export.ManifestSHA256, export.ExportedAt)
+	return err
+}
+
+// LastAuditExport returns the latest delivered day, or nil before the first export
+func (store *MessageStore) LastAuditExport() (*AuditExport, error) {
+	export, err := scanAuditExport(store.db.QueryRow(
+		"SELECT day, object, records, sha256, manifest_sha256, exported_at FROM audit_exports ORDER BY day DESC LIMIT 1"))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return export, err
+}
+
+// ListAuditExports returns the delivered days, newest first
+func (store *MessageStore) ListAuditExports(limit int) ([]AuditExport, error) {
+	query := "SELECT day, object, records, sha256, manifest_sha256, exported_at FROM audit_exports ORDER BY day DESC LIMIT ?"
+	if store.isPostgres {
+		query = "SELECT day, object, records, sha256, manifest_sha256, exported_at FROM audit_exports ORDER BY day DESC LIMIT $1"
+	}
+	rows, err := store.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []AuditExport{}
+	for rows.Next() {
+		export, err := scanAuditExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *export)
+	}
+	return exports, rows.Err()
+}
+
+// registerAuditExportRoutes registers /api/v1/admin/audit-exports
+func registerAuditExportRoutes(messageStore *MessageStore) {
+	handleAPI("/admin/audit-exports", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := 30
+		if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+			limit = parsed
+		}
+
+		exports, err := messageStore.ListAuditExports(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list audit exports: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range exports {
+			exports[i].ExportedAt = exports[i].ExportedAt.In(loc)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exports)
+	})
+}
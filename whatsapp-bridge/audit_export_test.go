@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditManifestSignatureCoversEveryField(t *testing.T) {
+	base := AuditManifest{
+		Day:                    "2025-03-01",
+		Object:                 "audit/2025/03/01/outbound.jsonl",
+		Records:                12,
+		SHA256:                 "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		PreviousManifestSHA256: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752",
+		ExportedAt:             time.Date(2025, 3, 2, 0, 5, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name   string
+		change func(m *AuditManifest)
+	}{
+		{"day", func(m *AuditManifest) { m.Day = "2025-03-02" }},
+		{"object", func(m *AuditManifest) { m.Object = "audit/2025/03/01/other.jsonl" }},
+		{"records", func(m *AuditManifest) { m.Records = 11 }},
+		{"sha256", func(m *AuditManifest) { m.SHA256 = "0" + m.SHA256[1:] }},
+		{"previous manifest", func(m *AuditManifest) { m.PreviousManifestSHA256 = "" }},
+		{"exported at", func(m *AuditManifest) { m.ExportedAt = m.ExportedAt.Add(time.Second) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			tt.change(&changed)
+			if changed.signedString() == base.signedString() {
+				t.Errorf("changing the %s leaves the signed string unchanged", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// autoDownloadTypes are the media types the policy can name
+var autoDownloadTypes = []string{"image", "video", "audio", "document"}
+
+// AutoDownloadPolicy decides which incoming media is downloaded as soon as it arrives.
+// Everything else is downloaded lazily on first access through /download or /media/.
+type AutoDownloadPolicy struct {
+	types        map[string]bool
+	maxBytes     uint64
+	chats        []string
+	excludeChats []string
+	senders      []string
+	queue        chan autoDownload
+	workers      int
+	logger       waLog.Logger
+}
+
+// autoDownload is an incoming media message waiting to be downloaded
+type autoDownload struct {
+	messageID string
+	chatJID   string
+}
+
+// autoDownloadPolicy is nil unless MEDIA_AUTO_DOWNLOAD names at least one type
+var autoDownloadPolicy *AutoDownloadPolicy
+
+// NewAutoDownloadPolicyFromEnv reads the MEDIA_AUTO_DOWNLOAD* settings; it returns nil when
+// auto-download is off, which is the default
+func NewAutoDownloadPolicyFromEnv(logger waLog.Logger) (*AutoDownloadPolicy, error) {
+	policy := &AutoDownloadPolicy{
+		types:        map[string]bool{},
+		chats:        splitEnvList("MEDIA_AUTO_DOWNLOAD_CHATS"),
+		excludeChats: splitEnvList("MEDIA_AUTO_DOWNLOAD_EXCLUDE_CHATS"),
+		senders:      splitEnvList("MEDIA_AUTO_DOWNLOAD_SENDERS"),
+		logger:       logger,
+	}
+
+	for _, mediaType := range splitEnvList("MEDIA_AUTO_DOWNLOAD") {
+		switch {
+		case mediaType == "none":
+		case mediaType == "all":
+			for _, t := range autoDownloadTypes {
+				policy.types[t] = true
+			}
+		case containsString(autoDownloadTypes, mediaType):
+			policy.types[mediaType] = true
+		default:
+			return nil, fmt.Errorf("unknown media type %q in MEDIA_AUTO_DOWNLOAD", mediaType)
+		}
+	}
+	if len(policy.types) == 0 {
+		return nil, nil
+	}
+
+	maxMB := getEnvInt("MEDIA_AUTO_DOWNLOAD_MAX_MB", 16)
+	if maxMB < 0 {
+		return nil, fmt.Errorf("MEDIA_AUTO_DOWNLOAD_MAX_MB must not be negative")
+	}
+	policy.maxBytes = uint64(maxMB) << 20
+
+	workers := getEnvInt("MEDIA_AUTO_DOWNLOAD_WORKERS", 2)
+	if workers < 1 {
+		return nil, fmt.Errorf("MEDIA_AUTO_DOWNLOAD_WORKERS must be positive")
+	}
+	policy.queue = make(chan autoDownload, 1000)
+	policy.workers = workers
+	return policy, nil
+}
+
+// splitEnvList reads a comma-separated setting, trimmed and lowercased, without empty entries
+func splitEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// containsString reports whether a list holds a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesJID reports whether a JID or bare phone number is in a list of JIDs or phone numbers
+func matchesJID(list []string, jid string) bool {
+	user := strings.SplitN(jid, "@", 2)[0]
+	for _, entry := range list {
+		if entry == jid {
+			return true
+		}
+		// Phone numbers match the user part of a JID
+		if !strings.Contains(entry, "@") || !strings.Contains(jid, "@") {
+			if strings.TrimPrefix(strings.SplitN(entry, "@", 2)[0], "+") == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allows reports whether media with these properties should be downloaded right away
+func (p *AutoDownloadPolicy) Allows(mediaType string, fileLength uint64, chatJID, sender string) bool {
+	if p == nil || !p.types[mediaType] {
+		return false
+	}
+	if p.maxBytes > 0 && fileLength > p.maxBytes {
+		return false
+	}
+	if matchesJID(p.excludeChats, chatJID) {
+		return false
+	}
+	if len(p.chats) > 0 && !matchesJID(p.chats, chatJID) {
+		return false
+	}
+	if len(p.senders) > 0 && !matchesJID(p.senders, sender) {
+		return false
+	}
+	return true
+}
+
+// Start runs the download workers
+func (p *AutoDownloadPolicy) Start(client *whatsmeow.Client, messageStore *MessageStore) {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for item := range p.queue {
+				_, _, filename, path, err := downloadMedia(client, messageStore, item.messageID, item.chatJID)
+				if err != nil {
+					p.logger.Warnf("Failed to auto-download media of message %s: %v", item.messageID, err)
+					continue
+				}
+				// Upload right away too, so the first request gets a signed URL without waiting
+				if mediaStorage != nil {
+					if _, err := mediaStorage.Store(messageStore, item.messageID, item.chatJID, path, filename); err != nil {
+						p.logger.Warnf("Failed to upload auto-downloaded media of message %s: %v", item.messageID, err)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue schedules the download of an incoming media message. When the queue is full the
+// media is left for lazy download, so a burst of media never blocks message handling.
+func (p *AutoDownloadPolicy) Enqueue(messageID, chatJID string) {
+	select {
+	case p.queue <- autoDownload{messageID: messageID, chatJID: chatJID}:
+	default:
+		p.logger.Warnf("Auto-download queue is full; media of message %s will be downloaded on first access", messageID)
+	}
+}
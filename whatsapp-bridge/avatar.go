@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// APIAvatar is the profile picture of a contact or group; URL is empty when there is none or it is hidden
+type APIAvatar struct {
+	JID string `json:"jid"`
+	URL string `json:"url"`
+	ID  string `json:"id"`
+}
+
+// getAvatar looks up a profile picture, caching the answer including "no picture"
+func getAvatar(client *whatsmeow.Client, jid types.JID) (APIAvatar, error) {
+	return cached(cacheKeyChatAvatar+jid.String(), contactCacheTTL, func() (APIAvatar, error) {
+		avatar := APIAvatar{JID: jid.String()}
+		if !client.IsConnected() {
+			return avatar, whatsmeow.ErrNotConnected
+		}
+
+		info, err := client.GetProfilePictureInfo(jid, &whatsmeow.GetProfilePictureParams{Preview: true})
+		if errors.Is(err, whatsmeow.ErrProfilePictureNotSet) || errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) {
+			return avatar, nil
+		}
+		if err != nil {
+			return avatar, err
+		}
+		if info != nil {
+			avatar.URL, avatar.ID = info.URL, info.ID
+		}
+		return avatar, nil
+	})
+}
+
+// registerAvatarRoutes registers /api/v1/chats/{jid}/avatar
+func registerAvatarRoutes(client *whatsmeow.Client) {
+	registerChatRoute("avatar", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid, err := types.ParseJID(chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JID: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		avatar, err := getAvatar(client, jid)
+		if errors.Is(err, whatsmeow.ErrNotConnected) {
+			http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get profile picture: %v", err), http.StatusBadGateway)
+			return
+		}
+		if avatar.URL == "" {
+			http.Error(w, "No profile picture", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(avatar)
+	})
+}
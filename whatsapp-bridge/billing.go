@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Billing periods a usage summary can cover
+const (
+	BillingMonthly = "monthly"
+	BillingDaily   = "daily"
+)
+
+// EventBillingUsageSummary is the event posted to BILLING_WEBHOOK_URL once a period ends
+const EventBillingUsageSummary = "billing.usage_summary"
+
+// billingCheckInterval is how often the reporter looks for periods to report or retry
+const billingCheckInterval = time.Hour
+
+// BillingSummary is a tenant's usage over one billing period
+type BillingSummary struct {
+	TenantID           string    `json:"tenant_id"`
+	TenantName         string    `json:"tenant_name"`
+	KeyIDs             []string  `json:"key_ids"`
+	Period             string    `json:"period"`
+	PeriodStart        time.Time `json:"period_start"`
+	PeriodEnd          time.Time `json:"period_end"`
+	MessagesSent       int64     `json:"messages_sent"`
+	MediaUploadBytes   int64     `json:"media_upload_bytes"`
+	MediaDownloadBytes int64     `json:"media_download_bytes"`
+	ActiveDays         int       `json:"active_days"`
+}
+
+// BillingReporter posts each tenant's usage to a billing webhook after every period,
+// so operators can meter the bridge without reading its database
+type BillingReporter struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	period       string
+	sink         *WebhookSink
+}
+
+// NewBillingReporterFromEnv returns nil when BILLING_WEBHOOK_URL is not set
+func NewBillingReporterFromEnv(messageStore *MessageStore, logger waLog.Logger) (*BillingReporter, error) {
+	url := os.Getenv("BILLING_WEBHOOK_URL")
+	if url == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid BILLING_WEBHOOK_URL %q", url)
+	}
+
+	period := os.Getenv("BILLING_PERIOD")
+	if period == "" {
+		period = BillingMonthly
+	}
+	if period != BillingMonthly && period != BillingDaily {
+		return nil, fmt.Errorf("BILLING_PERIOD must be %s or %s", BillingMonthly, BillingDaily)
+	}
+
+	sink := newWebhookSink([]string{url}, logger)
+	if secret := os.Getenv("BILLING_WEBHOOK_SECRET"); secret != "" {
+		sink.secret = secret
+	}
+	return &BillingReporter{messageStore: messageStore, logger: logger, period: period, sink: sink}, nil
+}
+
+// Start reports the last completed period now and checks again every hour
+func (b *BillingReporter) Start() {
+	go func() {
+		for {
+			b.reportLastPeriod(time.Now())
+			time.Sleep(billingCheckInterval)
+		}
+	}()
+}
+
+// lastPeriod returns the name and bounds of the most recent completed period
+func (b *BillingReporter) lastPeriod(now time.Time) (string, time.Time, time.Time) {
+	now = now.UTC()
+	if b.period == BillingDaily {
+		end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		start := end.AddDate(0, 0, -1)
+		return start.Format("2006-01-02"), start, end
+	}
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, -1, 0)
+	return start.Format("2006-01"), start, end
+}
+
+// reportLastPeriod posts a summary for every tenant not yet reported for the last period.
+// Failed deliveries are retried on the next check.
+func (b *BillingReporter) reportLastPeriod(now time.Time) {
+	// Replicas share the database, so only the leader reports
+	if leaderElector != nil && !leaderElector.IsLeader() {
+		return
+	}
+
+	period, start, end := b.lastPeriod(now)
+	tenants, err := b.messageStore.ListTenants()
+	if err != nil {
+		b.logger.Warnf("Failed to list tenants for billing: %v", err)
+		return
+	}
+
+	for _, tenant := range tenants {
+		reported, err := b.messageStore.BillingReported(tenant.ID, period)
+		if err != nil {
+			b.logger.Warnf("Failed to check billing report of %s: %v", tenant.ID, err)
+			continue
+		}
+		if reported {
+			continue
+		}
+
+		summary, err := b.summarize(tenant, period, start, end)
+		if err != nil {
+			b.logger.Warnf("Failed to summarize usage of %s: %v", tenant.ID, err)
+			continue
+		}
+		if err := b.post(summary); err != nil {
+			b.logger.Warnf("Failed to post %s usage of %s to the billing webhook: %v", period, tenant.ID, err)
+			continue
+		}
+		if err := b.messageStore.SaveBillingReport(tenant.ID, period, time.Now().UTC()); err != nil {
+			b.logger.Warnf("Failed to record billing report of %s: %v", tenant.ID, err)
+		}
+	}
+}
+
+// summarize adds up the daily usage of a tenant's keys over a period
+func (b *BillingReporter) summarize(tenant Tenant, period string, start, end time.Time) (*BillingSummary, error) {
+	summary := &BillingSummary{
+		TenantID:    tenant.ID,
+		TenantName:  tenant.Name,
+		KeyIDs:      tenant.KeyIDs,
+		Period:      period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	days, err := b.messageStore.GetDailyUsage(tenant.KeyIDs, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	for _, usage := range days {
+		summary.MessagesSent += usage[UsageMessagesSent]
+		summary.MediaUploadBytes += usage[UsageMediaUploadBytes]
+		summary.MediaDownloadBytes += usage[UsageMediaDownloadBytes]
+	}
+	summary.ActiveDays = len(days)
+	return summary, nil
+}
+
+// post delivers a summary as a bridge event, retrying like other webhooks
+func (b *BillingReporter) post(summary *BillingSummary) error {
+	var data map[string]interface{}
+	encoded, _ := json.Marshal(summary)
+	json.Unmarshal(encoded, &data)
+
+	body, err := json.Marshal(BridgeEvent{
+		ID:        newEventID(),
+		Type:      EventBillingUsageSummary,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * time.Second)
+		}
+		if lastErr = b.sink.post(b.sink.urls[0], EventBillingUsageSummary, body); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// AddDailyUsage adds an amount to a subject's counter for a day
+func (store *MessageStore) AddDailyUsage(subject, day, metric string, amount int64) error {
+	query := `INSERT INTO api_usage_daily (subject, day, metric, amount) VALUES (?, ?, ?, ?)
+		ON CONFLICT (subject, day, metric) DO UPDATE SET amount = api_usage_daily.amount + excluded.amount`
+	if store.isPostgres {
+		query = `INSERT INTO api_usage_daily (subject, day, metric, amount) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (subject, day, metric) DO UPDATE SET amount = api_usage_daily.amount + EXCLUDED.amount`
+	}
+	_, err := store.db.Exec(query, subject, day, metric, amount)
+	return err
+}
+
+// GetDailyUsage returns the counters of the subjects summed per day, for days in [from, to)
+func (store *MessageStore) GetDailyUsage(subjects []string, from, to string) (map[string]map[string]int64, error) {
+	days := map[string]map[string]int64{}
+	if len(subjects) == 0 {
+		return days, nil
+	}
+
+	placeholders := make([]string, len(subjects))
+	args := []interface{}{from, to}
+	for i, subject := range subjects {
+		placeholders[i] = "?"
+		if store.isPostgres {
+			placeholders[i] = fmt.Sprintf("$%d", i+3)
+		}
+		args = append(args, subject)
+	}
+	query := fmt.Sprintf(`SELECT day, metric, SUM(amount) FROM api_usage_daily
+		WHERE day >= ? AND day < ? AND subject IN (%s) GROUP BY day, metric`, strings.Join(placeholders, ", "))
+	if store.isPostgres {
+		query = fmt.Sprintf(`SELECT day, metric, SUM(amount) FROM api_usage_daily
+		WHERE day >= $1 AND day < $2 AND subject IN (%s) GROUP BY day, metric`, strings.Join(placeholders, ", "))
+	}
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day, metric string
+		var amount int64
+		if err := rows.Scan(&day, &metric, &amount); err != nil {
+			return nil, err
+		}
+		if days[day] == nil {
+			days[day] = map[string]int64{}
+		}
+		days[day][metric] = amount
+	}
+	return days, rows.Err()
+}
+
+// BillingReported reports whether a tenant's usage for a period was delivered
+func (store *MessageStore) BillingReported(tenantID, period string) (bool, error) {
+	query := "SELECT COUNT(*) FROM billing_reports WHERE tenant_id = ? AND period = ?"
+	if store.isPostgres {
+		query = "SELECT COUNT(*) FROM billing_reports WHERE tenant_id = $1 AND period = $2"
+	}
+	var count int
+	err := store.db.QueryRow(query, tenantID, period).Scan(&count)
+	return count > 0, err
+}
+
+// SaveBillingReport records that a tenant's usage for a period was delivered
+func (store *MessageStore) SaveBillingReport(tenantID, period string, sentAt time.Time) error {
+	query := "INSERT INTO billing_reports (tenant_id, period, sent_at) VALUES (?, ?, ?) ON CONFLICT (tenant_id, period) DO NOTHING"
+	if store.isPostgres {
+		query = "INSERT INTO billing_reports (tenant_id, period, sent_at) VALUES ($1, $2, $3) ON CONFLICT (tenant_id, period) DO NOTHING"
+	}
+	_, err := store.db.Exec(query, tenantID, period, sentAt)
+	return err
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keys; chat listings are dropped whenever a chat is stored, names and avatars are per JID
+const (
+	cacheKeyChatList     = "chats:list"
+	cacheKeyChatMap      = "chats:map"
+	cacheKeyChatName     = "chat-name:"
+	cacheKeyChatAvatar   = "chat-avatar:"
+	cacheKeyContactInfo  = "contact-info:"
+	cacheKeyJoinedGroups = "groups:joined"
+)
+
+// Cache stores serialized values for the read-heavy endpoints polled by dashboards
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(keys ...string)
+}
+
+var (
+	// responseCache is set by initCache; nil disables caching
+	responseCache Cache
+	// cacheTTL bounds how stale a listing can be if an invalidation is missed, e.g. from another replica
+	cacheTTL time.Duration
+	// contactCacheTTL is longer as names are written through on change and avatars rarely change
+	contactCacheTTL time.Duration
+)
+
+// initCache configures the cache from CACHE_BACKEND (memory, redis or none) and REDIS_URL
+func initCache() error {
+	cacheTTL = time.Duration(getEnvInt("CACHE_TTL_SECONDS", 30)) * time.Second
+	contactCacheTTL = time.Duration(getEnvInt("CACHE_CONTACT_TTL_SECONDS", 3600)) * time.Second
+
+	switch backend := strings.ToLower(os.Getenv("CACHE_BACKEND")); backend {
+	case "", "memory":
+		responseCache = NewMemoryCache()
+	case "redis":
+		cache, err := NewRedisCache(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return err
+		}
+		responseCache = cache
+	case "none":
+		responseCache = nil
+	default:
+		return fmt.Errorf("unknown CACHE_BACKEND %q (expected memory, redis or none)", backend)
+	}
+	return nil
+}
+
+// cached returns the cached value for key, or calls load and caches its result
+func cached[T any](key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	if responseCache != nil {
+		if data, ok := responseCache.Get(key); ok {
+			var value T
+			if err := json.Unmarshal(data, &value); err == nil {
+				return value, nil
+			}
+		}
+	}
+
+	value, err := load()
+	if err != nil || responseCache == nil {
+		return value, err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		responseCache.Set(key, data, ttl)
+	}
+	return value, nil
+}
+
+// invalidateCache drops cached values after the data behind them changed
+func invalidateCache(keys ...string) {
+	if responseCache != nil {
+		responseCache.Delete(keys...)
+	}
+}
+
+// chatStored drops the chat listings and writes the chat's current name through to the cache
+func chatStored(jid, name string) {
+	if responseCache == nil {
+		return
+	}
+	responseCache.Delete(cacheKeyChatList, cacheKeyChatMap)
+	if data, err := json.Marshal(name); err == nil {
+		responseCache.Set(cacheKeyChatName+jid, data, contactCacheTTL)
+	}
+}
+
+// memoryEntry is a cached value with its expiry
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCache is a per-process cache
+type MemoryCache struct {
+	entries map[string]memoryEntry
+	mutex   sync.Mutex
+}
+
+// NewMemoryCache creates an empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryEntry)}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Sweep expired entries now and then so per-contact keys don't pile up
+	if len(c.entries) >= 1000 {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+func (c *MemoryCache) Delete(keys ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+// RedisCache shares the cache between replicas, so an invalidation on the leader reaches followers.
+// It speaks just enough of the Redis protocol for GET, SET and DEL; errors are treated as misses.
+type RedisCache struct {
+	addr     string
+	username string
+	password string
+	db       int
+	prefix   string
+	conn     net.Conn
+	reader   *bufio.Reader
+	mutex    sync.Mutex
+}
+
+// NewRedisCache parses a redis://[[user]:password@]host:port[/db] URL
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("REDIS_URL is required when CACHE_BACKEND is redis")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid REDIS_URL: expected redis://[:password@]host:port[/db]")
+	}
+
+	cache := &RedisCache{addr: u.Host, prefix: "whatsapp-bridge:"}
+	if u.Port() == "" {
+		cache.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		cache.username, cache.password = u.User.Username(), password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if cache.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database number %q", db)
+		}
+	}
+	return cache, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", c.prefix+key)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := reply.([]byte)
+	return value, ok
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	c.do("SET", c.prefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+}
+
+func (c *RedisCache) Delete(keys ...string) {
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, c.prefix+key)
+	}
+	c.do(args...)
+}
+
+// do sends a command and reads its reply, reconnecting on the next call after a failure
+func (c *RedisCache) do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials Redis and authenticates
+func (c *RedisCache) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.password != "" {
+		auth := []string{"AUTH", c.password}
+		if c.username != "" {
+			auth = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := c.roundTrip(auth); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command as a RESP array and parses the reply
+func (c *RedisCache) roundTrip(args []string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(command.String())); err != nil {
+		return nil, err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply from Redis")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			// Missing key
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	default:
+		return nil, fmt.Errorf("unexpected reply from Redis: %q", line)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Approval states of a campaign created while approval is required. A withdrawn campaign was
+// canceled by its operator before anyone reviewed it.
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalWithdrawn = "withdrawn"
+)
+
+// campaignPreviewSamples is how many recipients a preview renders unless asked for more, up to maxCampaignPreviewSamples
+const (
+	campaignPreviewSamples    = 5
+	maxCampaignPreviewSamples = 50
+)
+
+// maxDiffCells bounds the table diffWords fills; longer texts are reported as replaced outright
+const maxDiffCells = 1 << 22
+
+// TextChange is a run of text a diff keeps (equal), adds (insert) or removes (delete)
+type TextChange struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// CampaignPreview shows a reviewer what a campaign will send
+type CampaignPreview struct {
+	Campaign  Campaign `json:"campaign"`
+	Message   string   `json:"message,omitempty"`
+	MediaPath string   `json:"media_path,omitempty"`
+	// Samples are the first recipients by send time, each with the exact text they will get
+	Samples []CampaignPreviewSample `json:"samples"`
+}
+
+// CampaignPreviewSample is the message one recipient of a campaign will get
+type CampaignPreviewSample struct {
+	Recipient string    `json:"recipient"`
+	ChatJID   string    `json:"chat_jid"`
+	SendAt    time.Time `json:"send_at"`
+	Timezone  string    `json:"timezone"`
+	Rendered  string    `json:"rendered"`
+	// Diff turns the message as submitted, or the template variant of the recipient, into the rendered text
+	Diff []TextChange `json:"diff"`
+}
+
+// renderScheduledMessage returns the text a scheduled message goes out with, as sendWhatsAppMessage builds it
+func renderScheduledMessage(scheduled *ScheduledMessage) string {
+	return withAgentSignature(scheduled.Message, SendOptions{ClientRef: scheduled.ClientRef, Agent: scheduled.Agent})
+}
+
+// PreviewCampaign renders the messages of the first recipients of a campaign
+func (store *MessageStore) PreviewCampaign(campaign *Campaign, samples int) (*CampaignPreview, error) {
+	messages, err := store.ListScheduledMessages("", campaign.ID, "", samples)
+	if err != nil {
+		return nil, err
+	}
+	// The messages of a template campaign differ per recipient, so they're compared to the template,
+	// in the variant of the recipient's language
+	var template *MessageTemplate
+	if campaign.TemplateID != "" {
+		if template, err = store.GetMessageTemplate(campaign.TemplateID); err != nil {
+			return nil, err
+		}
+	}
+	preview := &CampaignPreview{Campaign: *campaign, Samples: []CampaignPreviewSample{}}
+	for i := range messages {
+		scheduled := &messages[i]
+		preview.Message, preview.MediaPath = scheduled.Message, scheduled.MediaPath
+		base := preview.Message
+		if template != nil {
+			variables, err := store.TemplateVariables(scheduled.Recipient, nil)
+			if err != nil {
+				return nil, err
+			}
+			preview.Message = template.Body
+			base, _ = template.variant(variables["language"])
+		}
+		rendered := renderScheduledMessage(scheduled)
+		preview.Samples = append(preview.Samples, CampaignPreviewSample{
+			Recipient: scheduled.Recipient,
+			ChatJID:   scheduled.ChatJID,
+			SendAt:    scheduled.SendAt,
+			Timezone:  scheduled.Timezone,
+			Rendered:  rendered,
+			Diff:      diffWords(base, rendered),
+		})
+	}
+	return preview, nil
+}
+
+// localize converts the times of a preview to a response's time zone
+func (preview *CampaignPreview) localize(loc *time.Location) {
+	preview.Campaign.localize(loc)
+	for i := range preview.Samples {
+		preview.Samples[i].SendAt = preview.Samples[i].SendAt.In(loc)
+	}
+}
+
+// ReviewCampaign moves a campaign waiting for approval to approval, releasing its messages to the
+// scheduler when approved and canceling them otherwise. It reports false when the campaign wasn't waiting.
+func (store *MessageStore) ReviewCampaign(id, approval, reviewer, note string, at time.Time) (bool, error) {
+	release := ScheduledCanceled
+	if approval == ApprovalApproved {
+		release = ScheduledPending
+	}
+
+	campaignQuery := `UPDATE campaigns SET approval = ?, reviewed_by = NULLIF(?, ''), reviewed_at = ?, review_note = NULLIF(?, '')
+		WHERE id = ? AND approval = ?`
+	messagesQuery := "UPDATE scheduled_messages SET status = ? WHERE campaign_id = ? AND status = ?"
+	if store.isPostgres {
+		campaignQuery = `UPDATE campaigns SET approval = $1, reviewed_by = NULLIF($2, ''), reviewed_at = $3, review_note = NULLIF($4, '')
+		WHERE id = $5 AND approval = $6`
+		messagesQuery = "UPDATE scheduled_messages SET status = $1 WHERE campaign_id = $2 AND status = $3"
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(campaignQuery, approval, reviewer, at, note, id, ApprovalPending)
+	if err != nil {
+		return false, err
+	}
+	if reviewed, _ := result.RowsAffected(); reviewed == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(messagesQuery, release, id, ScheduledAwaitingApproval); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// publishCampaignApproval tells subscribers that a campaign is waiting for approval or was reviewed
+func publishCampaignApproval(eventType string, campaign *Campaign) {
+	recipients := 0
+	for _, count := range campaign.Counts {
+		recipients += count
+	}
+	data := map[string]interface{}{
+		"campaign_id":  campaign.ID,
+		"name":         campaign.Name,
+		"requested_by": campaign.RequestedBy,
+		"recipients":   recipients,
+	}
+	if campaign.ReviewedBy != "" {
+		data["reviewed_by"] = campaign.ReviewedBy
+	}
+	if campaign.ReviewNote != "" {
+		data["note"] = campaign.ReviewNote
+	}
+	publishEvent(eventType, "", time.Time{}, data)
+}
+
+// splitWords cuts text into alternating runs of whitespace and everything else
+func splitWords(text string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range text {
+		isSpace := unicode.IsSpace(r)
+		if i > start && isSpace != space {
+			words = append(words, text[start:i])
+			start = i
+		}
+		space = isSpace
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// diffWords compares two texts word by word, so a reviewer sees what rendering adds to a message
+func diffWords(before, after string) []TextChange {
+	changes := []TextChange{}
+	add := func(op, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(changes); n > 0 && changes[n-1].Op == op {
+			changes[n-1].Text += text
+			return
+		}
+		changes = append(changes, TextChange{Op: op, Text: text})
+	}
+
+	a, b := splitWords(before), splitWords(after)
+	if len(a)*len(b) > maxDiffCells {
+		add("delete", before)
+		add("insert", after)
+		return changes
+	}
+
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add("equal", a[i])
+			i, j = i+1, j+1
+		case common[i+1][j] >= common[i][j+1]:
+			add("delete", a[i])
+			i++
+		default:
+			add("insert", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add("delete", a[i])
+	}
+	for ; j < len(b); j++ {
+		add("insert", b[j])
+	}
+	return changes
+}
+
+// registerCampaignApprovalRoutes registers /api/v1/admin/campaigns/{id}/preview, /approve and /reject
+func registerCampaignApprovalRoutes(messageStore *MessageStore) {
+	handleAPI("/admin/campaigns/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/admin/campaigns/"), "/")
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := messageStore.GetCampaign(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get campaign: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if campaign == nil {
+			http.Error(w, "Campaign not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case action == "preview" && r.Method == http.MethodGet:
+			samples := campaignPreviewSamples
+			if value := r.URL.Query().Get("samples"); value != "" {
+				samples, err = strconv.Atoi(value)
+				if err != nil || samples < 1 || samples > maxCampaignPreviewSamples {
+					http.Error(w, fmt.Sprintf("samples must be between 1 and %d", maxCampaignPreviewSamples), http.StatusBadRequest)
+					return
+				}
+			}
+			preview, err := messageStore.PreviewCampaign(campaign, samples)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to preview campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			preview.localize(loc)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(preview)
+			return
+
+		case (action == "approve" || action == "reject") && r.Method == http.MethodPost:
+			var req struct {
+				Reviewer string `json:"reviewer"`
+				Note     string `json:"note"`
+			}
+			// Both fields are optional, so an empty body is fine
+			json.NewDecoder(r.Body).Decode(&req)
+			reviewer := strings.TrimSpace(req.Reviewer)
+			if reviewer == "" {
+				reviewer = usageSubject(r)
+			}
+			if len(reviewer) > maxAgentLen {
+				http.Error(w, fmt.Sprintf("reviewer must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+				return
+			}
+			if campaign.Approval != ApprovalPending {
+				http.Error(w, "Campaign is not waiting for approval", http.StatusConflict)
+				return
+			}
+			// Someone other than the operator who created a campaign has to approve it
+			if action == "approve" && reviewer == campaign.RequestedBy && reviewer != anonymousUsageSubject {
+				http.Error(w, "Campaigns can't be approved by the operator who created them", http.StatusForbidden)
+				return
+			}
+
+			approval, eventType := ApprovalApproved, EventCampaignApproved
+			if action == "reject" {
+				approval, eventType = ApprovalRejected, EventCampaignRejected
+			}
+			reviewed, err := messageStore.ReviewCampaign(id, approval, reviewer, strings.TrimSpace(req.Note), time.Now().UTC())
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to review campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if !reviewed {
+				http.Error(w, "Campaign is not waiting for approval", http.StatusConflict)
+				return
+			}
+			if campaign, err = messageStore.GetCampaign(id); err != nil || campaign == nil {
+				http.Error(w, fmt.Sprintf("Failed to get campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			publishCampaignApproval(eventType, campaign)
+			campaign.localize(loc)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(campaign)
+			return
+
+		case action != "preview" && action != "approve" && action != "reject":
+			http.NotFound(w, r)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	})
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Tables whose row changes can be captured
+const (
+	cdcTableMessages = "messages"
+	cdcTableChats    = "chats"
+)
+
+// Change operations
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// cdcAttempts is how often a batch is tried before its changes are dropped
+const cdcAttempts = 5
+
+// ChangeEvent is one row change. Before is null for inserts and After for deletes.
+type ChangeEvent struct {
+	ID        string            `json:"id"`
+	Table     string            `json:"table"`
+	Op        string            `json:"op"`
+	Key       map[string]string `json:"key"`
+	Before    rowImage          `json:"before"`
+	After     rowImage          `json:"after"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// rowImage is the captured state of a row
+type rowImage interface {
+	rowKey() map[string]string
+	redact(r *Redactor) rowImage
+}
+
+func (m *ExportedMessage) rowKey() map[string]string {
+	return map[string]string{"id": m.ID, "chat_jid": m.ChatJID}
+}
+
+func (m *ExportedMessage) redact(r *Redactor) rowImage {
+	redacted := *m
+	redacted.ChatJID = r.Phone(m.ChatJID)
+	redacted.Sender = r.Phone(m.Sender)
+	redacted.Content = r.Body(m.Content)
+	return &redacted
+}
+
+// ChatImage is the captured state of a chat
+type ChatImage struct {
+	JID             string    `json:"jid"`
+	Name            string    `json:"name"`
+	LastMessageTime time.Time `json:"last_message_time"`
+}
+
+func (c *ChatImage) rowKey() map[string]string {
+	return map[string]string{"jid": c.JID}
+}
+
+func (c *ChatImage) redact(r *Redactor) rowImage {
+	redacted := *c
+	redacted.JID = r.Phone(c.JID)
+	redacted.Name = r.Name(c.Name)
+	return &redacted
+}
+
+// changeSink delivers batches of changes in order
+type changeSink interface {
+	Send(events []ChangeEvent) error
+}
+
+// changeConsumer receives the changes of some tables through its own queue, so a slow
+// or failing sink doesn't hold up the others
+type changeConsumer struct {
+	name      string
+	sink      changeSink
+	tables    map[string]bool
+	redactor  *Redactor
+	batchSize int
+	queue     chan ChangeEvent
+	logger    waLog.Logger
+}
+
+// ChangeCapture fans row changes of the message store out to its consumers, the CDC sink and the search index
+type ChangeCapture struct {
+	consumers []*changeConsumer
+}
+
+// changeCapture gets its consumers at startup; without any, writes aren't captured
+var changeCapture = &ChangeCapture{}
+
+// NewCDCConsumerFromEnv reads the CDC_* settings and returns nil when CDC_SINK is not set
+func NewCDCConsumerFromEnv(logger waLog.Logger) (*changeConsumer, error) {
+	var sink changeSink
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("CDC_SINK"))); kind {
+	case "":
+		return nil, nil
+	case "webhook":
+		target := os.Getenv("CDC_WEBHOOK_URL")
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return nil, fmt.Errorf("CDC_WEBHOOK_URL must be an http or https URL")
+		}
+		sink = &webhookChangeSink{url: target, secret: os.Getenv("CDC_WEBHOOK_SECRET"), client: cdcHTTPClient()}
+	case "kafka":
+		proxy := strings.TrimRight(os.Getenv("CDC_KAFKA_REST_URL"), "/")
+		if !strings.HasPrefix(proxy, "http://") && !strings.HasPrefix(proxy, "https://") {
+			return nil, fmt.Errorf("CDC_KAFKA_REST_URL must be the http or https URL of a Kafka REST proxy")
+		}
+		topic := os.Getenv("CDC_KAFKA_TOPIC")
+		if topic == "" {
+			topic = "whatsapp-bridge.changes"
+		}
+		sink = &kafkaChangeSink{url: proxy + "/topics/" + url.PathEscape(topic), client: cdcHTTPClient()}
+	default:
+		return nil, fmt.Errorf("invalid CDC_SINK: %s (expected webhook or kafka)", kind)
+	}
+
+	consumer := &changeConsumer{
+		name:      "CDC",
+		sink:      sink,
+		tables:    map[string]bool{},
+		redactor:  cdcRedactor,
+		batchSize: getEnvInt("CDC_BATCH_SIZE", 100),
+		queue:     make(chan ChangeEvent, getEnvInt("CDC_QUEUE_SIZE", 10000)),
+		logger:    logger,
+	}
+	tables := os.Getenv("CDC_TABLES")
+	if tables == "" {
+		tables = cdcTableMessages + "," + cdcTableChats
+	}
+	for _, table := range strings.Split(tables, ",") {
+		switch table = strings.TrimSpace(table); table {
+		case cdcTableMessages, cdcTableChats:
+			consumer.tables[table] = true
+		default:
+			return nil, fmt.Errorf("invalid CDC_TABLES entry %q (expected messages or chats)", table)
+		}
+	}
+	if consumer.batchSize < 1 {
+		return nil, fmt.Errorf("CDC_BATCH_SIZE must be positive")
+	}
+	return consumer, nil
+}
+
+// cdcHTTPClient is shared by the sinks, with the webhook timeout
+func cdcHTTPClient() *http.Client {
+	return &http.Client{Timeout: time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second}
+}
+
+// AddConsumer adds a consumer before Start; nil is ignored
+func (c *ChangeCapture) AddConsumer(consumer *changeConsumer) {
+	if consumer != nil {
+		c.consumers = append(c.consumers, consumer)
+	}
+}
+
+// Captures reports whether changes to a table are captured
+func (c *ChangeCapture) Captures(table string) bool {
+	for _, consumer := range c.consumers {
+		if consumer.tables[table] {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues the change between two images of a row, either of which may be nil, for every
+// consumer of the table. Nothing is queued when the row didn't change.
+func (c *ChangeCapture) Emit(table string, before, after rowImage) {
+	if !c.Captures(table) {
+		return
+	}
+	evt := ChangeEvent{ID: newEventID(), Table: table, Timestamp: time.Now().UTC()}
+	switch {
+	case before == nil && after == nil:
+		return
+	case before == nil:
+		evt.Op = ChangeInsert
+	case after == nil:
+		evt.Op = ChangeDelete
+	case reflect.DeepEqual(before, after):
+		return
+	default:
+		evt.Op = ChangeUpdate
+	}
+
+	for _, consumer := range c.consumers {
+		if !consumer.tables[table] {
+			continue
+		}
+		redacted := evt
+		if before != nil {
+			redacted.Before = before.redact(consumer.redactor)
+			redacted.Key = redacted.Before.rowKey()
+		}
+		if after != nil {
+			redacted.After = after.redact(consumer.redactor)
+			redacted.Key = redacted.After.rowKey()
+		}
+		select {
+		case consumer.queue <- redacted:
+		default:
+			consumer.logger.Warnf("%s queue full, dropping %s of a %s row", consumer.name, evt.Op, table)
+		}
+	}
+}
+
+// Start delivers the queued changes of each consumer in the background
+func (c *ChangeCapture) Start() {
+	for _, consumer := range c.consumers {
+		consumer.start()
+	}
+}
+
+// start delivers queued changes in the background, in order and in batches
+func (c *changeConsumer) start() {
+	go func() {
+		for evt := range c.queue {
+			batch := []ChangeEvent{evt}
+		fill:
+			for len(batch) < c.batchSize {
+				select {
+				case evt := <-c.queue:
+					batch = append(batch, evt)
+				default:
+					break fill
+				}
+			}
+			c.deliver(batch)
+		}
+	}()
+}
+
+// deliver sends a batch, retrying with backoff
+func (c *changeConsumer) deliver(batch []ChangeEvent) {
+	var err error
+	for attempt := 0; attempt < cdcAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * time.Second)
+		}
+		if err = c.sink.Send(batch); err == nil {
+			return
+		}
+	}
+	c.logger.Warnf("Giving up on %d %s changes: %v", len(batch), c.name, err)
+}
+
+// webhookChangeSink posts each batch as a JSON array
+type webhookChangeSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (s *webhookChangeSink) Send(events []ChangeEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-Bridge-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return postChanges(s.client, req)
+}
+
+// kafkaChangeSink produces each change as a record through a Kafka REST proxy (v2 API).
+// Records are keyed by table and row, so the changes of a row stay in order on one partition.
+type kafkaChangeSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *kafkaChangeSink) Send(events []ChangeEvent) error {
+	type record struct {
+		Key   string      `json:"key"`
+		Value ChangeEvent `json:"value"`
+	}
+	records := make([]record, len(events))
+	for i, evt := range events {
+		key := evt.Table
+		for _, name := range []string{"chat_jid", "jid", "id"} {
+			if value, ok := evt.Key[name]; ok {
+				key += ":" + value
+			}
+		}
+		records[i] = record{Key: key, Value: evt}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	return postChanges(s.client, req)
+}
+
+// postChanges sends a sink request and checks for success
+func postChanges(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// messageImage returns the current state of a message, or nil if it isn't stored
+func (store *MessageStore) messageImage(id, chatJID string) rowImage {
+	query := "SELECT " + exportedMessageColumns + " FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT " + exportedMessageColumns + " FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	messages, err := store.queryExportedMessages(query, id, chatJID)
+	if err != nil || len(messages) == 0 {
+		return nil
+	}
+	return &messages[0]
+}
+
+// chatImage returns the current state of a chat, or nil if it isn't stored
+func (store *MessageStore) chatImage(jid string) rowImage {
+	query := "SELECT jid, name, last_message_time FROM chats WHERE jid = ?"
+	if store.isPostgres {
+		query = "SELECT jid, name, last_message_time FROM chats WHERE jid = $1"
+	}
+	var chat ChatImage
+	var name sql.NullString
+	var lastMessageTime sql.NullTime
+	if err := store.db.QueryRow(query, jid).Scan(&chat.JID, &name, &lastMessageTime); err != nil {
+		return nil
+	}
+	chat.Name = name.String
+	chat.LastMessageTime = lastMessageTime.Time
+	return &chat
+}
+
+// captureMessage runs a write to one message and emits the change it made
+func (store *MessageStore) captureMessage(id, chatJID string, write func() error) error {
+	if store.account != "" || !changeCapture.Captures(cdcTableMessages) {
+		return write()
+	}
+	before := store.messageImage(id, chatJID)
+	if err := write(); err != nil {
+		return err
+	}
+	changeCapture.Emit(cdcTableMessages, before, store.messageImage(id, chatJID))
+	return nil
+}
+
+// captureChat runs a write to one chat and emits the change it made
+func (store *MessageStore) captureChat(jid string, write func() error) error {
+	if store.account != "" || !changeCapture.Captures(cdcTableChats) {
+		return write()
+	}
+	before := store.chatImage(jid)
+	if err := write(); err != nil {
+		return err
+	}
+	changeCapture.Emit(cdcTableChats, before, store.chatImage(jid))
+	return nil
+}
+
+// messagesToDelete returns the messages a bulk delete with the condition will remove, so their
+// deletes can be emitted afterwards with emitMessageDeletes. It returns nil when messages aren't captured.
+func (store *MessageStore) messagesToDelete(condition string, args ...interface{}) []ExportedMessage {
+	if !changeCapture.Captures(cdcTableMessages) {
+		return nil
+	}
+	messages, err := store.queryExportedMessages("SELECT "+exportedMessageColumns+" FROM messages WHERE "+condition, args...)
+	if err != nil {
+		fmt.Printf("Failed to read messages before deleting them, their captured deletes are lost: %v\n", err)
+	}
+	return messages
+}
+
+// emitMessageDeletes emits the deletes of messages returned by messagesToDelete. Erasures
+// carry only the key, so the erased content isn't copied to the sink on its way out.
+func emitMessageDeletes(messages []ExportedMessage, erasure bool) {
+	for i := range messages {
+		before := &messages[i]
+		if erasure {
+			before = &ExportedMessage{ID: before.ID, ChatJID: before.ChatJID, Timestamp: before.Timestamp}
+		}
+		changeCapture.Emit(cdcTableMessages, before, nil)
+	}
+}
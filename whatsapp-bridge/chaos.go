@@ -0,0 +1,206 @@
+//go:build chaos
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Faults the chaos harness can inject
+const (
+	faultDisconnect  = "disconnect"
+	faultStreamError = "stream_error"
+	faultDBOutage    = "db_outage"
+)
+
+// ChaosResult records one injected fault and whether the bridge recovered from it
+type ChaosResult struct {
+	Fault        string    `json:"fault"`
+	InjectedAt   time.Time `json:"injected_at"`
+	Recovered    bool      `json:"recovered"`
+	RecoveryTime float64   `json:"recovery_seconds"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ChaosMonkey injects faults into the WhatsApp connection and the database and checks that the
+// bridge recovers on its own. It only exists in binaries built with -tags chaos.
+type ChaosMonkey struct {
+	client          *whatsmeow.Client
+	messageStore    *MessageStore
+	logger          waLog.Logger
+	faults          []string
+	interval        time.Duration
+	outageDuration  time.Duration
+	recoveryTimeout time.Duration
+	results         []ChaosResult
+	mutex           sync.Mutex
+	running         sync.Mutex
+}
+
+// startChaos reads the CHAOS_* settings, registers /api/v1/chaos and starts injecting faults
+func startChaos(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	monkey := &ChaosMonkey{
+		client:          client,
+		messageStore:    messageStore,
+		logger:          logger,
+		faults:          []string{faultDisconnect, faultStreamError, faultDBOutage},
+		interval:        time.Duration(getEnvInt("CHAOS_INTERVAL_SECONDS", 120)) * time.Second,
+		outageDuration:  time.Duration(getEnvInt("CHAOS_DB_OUTAGE_SECONDS", 15)) * time.Second,
+		recoveryTimeout: time.Duration(getEnvInt("CHAOS_RECOVERY_TIMEOUT_SECONDS", 90)) * time.Second,
+	}
+	if faults := os.Getenv("CHAOS_FAULTS"); faults != "" {
+		monkey.faults = strings.Split(faults, ",")
+		for i := range monkey.faults {
+			monkey.faults[i] = strings.TrimSpace(monkey.faults[i])
+		}
+	}
+
+	logger.Warnf("CHAOS MODE ENABLED: injecting %v every %v. Never run this build in production.", monkey.faults, monkey.interval)
+	monkey.registerRoutes()
+
+	// An interval of 0 only injects faults on request
+	if monkey.interval > 0 {
+		go func() {
+			for {
+				// Jitter so faults don't line up with other periodic work
+				time.Sleep(monkey.interval/2 + time.Duration(rand.Int63n(int64(monkey.interval))))
+				monkey.Inject(monkey.faults[rand.Intn(len(monkey.faults))])
+			}
+		}()
+	}
+}
+
+// Inject runs one fault and waits for recovery; faults never overlap
+func (m *ChaosMonkey) Inject(fault string) ChaosResult {
+	m.running.Lock()
+	defer m.running.Unlock()
+
+	result := ChaosResult{Fault: fault, InjectedAt: time.Now().UTC()}
+	m.logger.Warnf("CHAOS: injecting %s", fault)
+
+	var err error
+	switch fault {
+	case faultDisconnect:
+		err = m.simulateDisconnect()
+	case faultStreamError:
+		err = m.simulateStreamError()
+	case faultDBOutage:
+		err = m.simulateDBOutage()
+	default:
+		err = fmt.Errorf("unknown fault %q", fault)
+	}
+
+	if err == nil {
+		err = m.awaitRecovery()
+	}
+	result.RecoveryTime = time.Since(result.InjectedAt).Seconds()
+	result.Recovered = err == nil
+	if err != nil {
+		result.Error = err.Error()
+		m.logger.Errorf("CHAOS: bridge did not recover from %s: %v", fault, err)
+	} else {
+		m.logger.Infof("CHAOS: recovered from %s in %.1fs", fault, result.RecoveryTime)
+	}
+
+	m.mutex.Lock()
+	m.results = append(m.results, result)
+	m.mutex.Unlock()
+	return result
+}
+
+// simulateDisconnect drops the socket the way a network failure would: unexpectedly, so auto-reconnect kicks in
+func (m *ChaosMonkey) simulateDisconnect() error {
+	if !m.client.IsConnected() {
+		return fmt.Errorf("not connected before the fault")
+	}
+	internals := m.client.DangerousInternals()
+	m.client.Disconnect()
+	internals.ResetExpectedDisconnect()
+	internals.DispatchEvent(&events.Disconnected{})
+	go internals.AutoReconnect()
+	return nil
+}
+
+// simulateStreamError feeds a "restart required" stream error, which the server sends before closing the stream
+func (m *ChaosMonkey) simulateStreamError() error {
+	if !m.client.IsConnected() {
+		return fmt.Errorf("not connected before the fault")
+	}
+	m.client.DangerousInternals().HandleStreamError(&waBinary.Node{
+		Tag:   "stream:error",
+		Attrs: waBinary.Attrs{"code": "515"},
+	})
+	return nil
+}
+
+// simulateDBOutage makes every database call fail for the configured duration
+func (m *ChaosMonkey) simulateDBOutage() error {
+	chaosDBDown.Store(true)
+	time.Sleep(m.outageDuration)
+	chaosDBDown.Store(false)
+	return nil
+}
+
+// awaitRecovery waits until WhatsApp is connected and the database answers again
+func (m *ChaosMonkey) awaitRecovery() error {
+	deadline := time.Now().Add(m.recoveryTimeout)
+	for {
+		connected := m.client.IsConnected()
+		dbErr := m.messageStore.db.Ping()
+		if connected && dbErr == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if !connected {
+				return fmt.Errorf("WhatsApp still disconnected after %v", m.recoveryTimeout)
+			}
+			return fmt.Errorf("database still failing after %v: %v", m.recoveryTimeout, dbErr)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// registerRoutes exposes the results and on-demand injection at /api/v1/chaos
+func (m *ChaosMonkey) registerRoutes() {
+	handleAPI("/chaos", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			m.mutex.Lock()
+			report := map[string]interface{}{
+				"faults":  m.faults,
+				"results": append([]ChaosResult{}, m.results...),
+			}
+			m.mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+
+		case http.MethodPost:
+			fault := r.URL.Query().Get("fault")
+			if fault != faultDisconnect && fault != faultStreamError && fault != faultDBOutage {
+				http.Error(w, "fault must be disconnect, stream_error or db_outage", http.StatusBadRequest)
+				return
+			}
+			result := m.Inject(fault)
+			w.Header().Set("Content-Type", "application/json")
+			if !result.Recovered {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			json.NewEncoder(w).Encode(result)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
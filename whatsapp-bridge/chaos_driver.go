@@ -0,0 +1,134 @@
+//go:build chaos
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+)
+
+// errChaosOutage is returned while a simulated database outage is in progress
+var errChaosOutage = errors.New("chaos: simulated database outage")
+
+// chaosDBDown is set while a simulated outage is in progress
+var chaosDBDown atomic.Bool
+
+// Wrap the database drivers so the harness can take the database away
+func init() {
+	for _, name := range []string{"postgres", "sqlite3"} {
+		db, err := sql.Open(name, "")
+		if err != nil {
+			continue
+		}
+		sql.Register("chaos-"+name, &chaosDriver{base: db.Driver()})
+		db.Close()
+	}
+}
+
+// sqlDriverName returns the fault-injecting wrapper of a database driver
+func sqlDriverName(name string) string {
+	return "chaos-" + name
+}
+
+// chaosDriver refuses new connections during an outage
+type chaosDriver struct {
+	base driver.Driver
+}
+
+func (d *chaosDriver) Open(name string) (driver.Conn, error) {
+	if chaosDBDown.Load() {
+		return nil, errChaosOutage
+	}
+	conn, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosConn{base: conn}, nil
+}
+
+// chaosConn fails with ErrBadConn during an outage, so the pool drops it like a broken connection
+type chaosConn struct {
+	base driver.Conn
+}
+
+func (c *chaosConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *chaosConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if chaosDBDown.Load() {
+		return nil, driver.ErrBadConn
+	}
+	if preparer, ok := c.base.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.base.Prepare(query)
+}
+
+func (c *chaosConn) Close() error {
+	return c.base.Close()
+}
+
+func (c *chaosConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *chaosConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if chaosDBDown.Load() {
+		return nil, driver.ErrBadConn
+	}
+	if beginner, ok := c.base.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.base.Begin()
+}
+
+func (c *chaosConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if chaosDBDown.Load() {
+		return nil, driver.ErrBadConn
+	}
+	if execer, ok := c.base.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *chaosConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if chaosDBDown.Load() {
+		return nil, driver.ErrBadConn
+	}
+	if queryer, ok := c.base.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *chaosConn) Ping(ctx context.Context) error {
+	if chaosDBDown.Load() {
+		return driver.ErrBadConn
+	}
+	if pinger, ok := c.base.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *chaosConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.base.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func (c *chaosConn) ResetSession(ctx context.Context) error {
+	if chaosDBDown.Load() {
+		return driver.ErrBadConn
+	}
+	if resetter, ok := c.base.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !chaos
+
+package main
+
+import (
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// sqlDriverName returns the database driver to open; chaos builds wrap it to inject outages
+func sqlDriverName(name string) string {
+	return name
+}
+
+// startChaos does nothing unless the bridge is built with -tags chaos
+func startChaos(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Maximum pixel width of image thumbnails embedded in exported transcripts
+const exportThumbnailWidth = 320
+
+// TranscriptMessage is a message as it appears in an exported transcript
+type TranscriptMessage struct {
+	ID        string
+	Sender    string
+	Content   string
+	Time      time.Time
+	IsFromMe  bool
+	MediaType string
+	Filename  string
+	Agent     string
+	ChainSeq  int64
+	ChainHash string
+}
+
+// GetTranscript returns the messages of a chat in chronological order, optionally limited to a time range
+func (store *MessageStore) GetTranscript(chatJID string, from, to time.Time) ([]TranscriptMessage, error) {
+	if to.IsZero() {
+		to = time.Now().Add(24 * time.Hour)
+	}
+
+	var query string
+	if store.isPostgres {
+		query = "SELECT id, sender, content, timestamp, is_from_me, media_type, filename, agent, chain_seq, chain_hash FROM messages WHERE chat_jid = $1 AND timestamp >= $2 AND timestamp <= $3 ORDER BY timestamp ASC"
+	} else {
+		query = "SELECT id, sender, content, timestamp, is_from_me, media_type, filename, agent, chain_seq, chain_hash FROM messages WHERE chat_jid = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC"
+	}
+
+	rows, err := store.db.Query(query, chatJID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []TranscriptMessage
+	for rows.Next() {
+		var msg TranscriptMessage
+		var sender, content, mediaType, filename, agent, chainHash sql.NullString
+		var chainSeq sql.NullInt64
+		if err := rows.Scan(&msg.ID, &sender, &content, &msg.Time, &msg.IsFromMe, &mediaType, &filename, &agent, &chainSeq, &chainHash); err != nil {
+			return nil, err
+		}
+		msg.Sender = sender.String
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+		msg.Filename = filename.String
+		msg.Agent = agent.String
+		msg.ChainSeq = chainSeq.Int64
+		msg.ChainHash = chainHash.String
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// getChatDisplayName returns the stored name of a chat, or the JID if it has none
+func (store *MessageStore) getChatDisplayName(chatJID string) string {
+	var query string
+	if store.isPostgres {
+		query = "SELECT name FROM chats WHERE jid = $1"
+	} else {
+		query = "SELECT name FROM chats WHERE jid = ?"
+	}
+
+	var name sql.NullString
+	if err := store.db.QueryRow(query, chatJID).Scan(&name); err != nil || name.String == "" {
+		return chatJID
+	}
+	return name.String
+}
+
+// exportThumbnail loads an image attachment and returns it as a small JPEG.
+// Missing images are downloaded first; formats the standard library can't decode are skipped.
+func exportThumbnail(client *whatsmeow.Client, messageStore *MessageStore, msg TranscriptMessage, chatJID string) ([]byte, int, int, error) {
+	success, _, _, path, err := downloadMedia(client, messageStore, msg.ID, chatJID)
+	if err != nil || !success {
+		return nil, 0, 0, fmt.Errorf("image not available: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	// Nearest-neighbour downscale is plenty for a printed thumbnail
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, 0, 0, fmt.Errorf("empty image")
+	}
+	if width > exportThumbnailWidth {
+		height = height * exportThumbnailWidth / width
+		width = exportThumbnailWidth
+		if height == 0 {
+			height = 1
+		}
+	}
+
+	// Always draw into RGBA so the JPEG is three-component, matching the DeviceRGB colour space
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			thumb.Set(x, y, src.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return buf.Bytes(), width, height, nil
+}
+
+// renderTranscriptPDF writes a chat transcript as a PDF document
+func renderTranscriptPDF(client *whatsmeow.Client, messageStore *MessageStore, chatJID string, messages []TranscriptMessage, thumbnails bool, loc *time.Location) *PDFDocument {
+	doc := NewPDFDocument()
+
+	doc.WriteLine("Chat transcript: "+messageStore.getChatDisplayName(chatJID), 16, true, 0)
+	doc.WriteLine("Chat JID: "+chatJID, 9, false, 0.3)
+	doc.WriteLine(fmt.Sprintf("Exported: %s", formatTimestamp(time.Now(), loc)), 9, false, 0.3)
+	doc.WriteLine(fmt.Sprintf("Messages: %d", len(messages)), 9, false, 0.3)
+	if len(messages) > 0 {
+		doc.WriteLine(fmt.Sprintf("Period: %s to %s",
+			formatTimestamp(messages[0].Time, loc),
+			formatTimestamp(messages[len(messages)-1].Time, loc)), 9, false, 0.3)
+	}
+	// The chain head lets readers check the export against the bridge with POST /api/v1/chats/{jid}/chain
+	if head, err := messageStore.GetChainHead(chatJID); err == nil && head != nil {
+		doc.WriteLine(fmt.Sprintf("Hash chain head: #%d %s", head.Seq, head.Hash), 8, false, 0.3)
+	}
+	doc.Space(12)
+
+	for _, msg := range messages {
+		sender := msg.Sender
+		if msg.IsFromMe {
+			sender = "Me"
+			if msg.Agent != "" {
+				sender = fmt.Sprintf("Me (%s)", msg.Agent)
+			}
+		}
+		doc.WriteLine(fmt.Sprintf("%s  -  %s", sender, formatTimestamp(msg.Time, loc)), 9, true, 0.25)
+
+		if msg.MediaType != "" {
+			embedded := false
+			if thumbnails && msg.MediaType == "image" {
+				data, width, height, err := exportThumbnail(client, messageStore, msg, chatJID)
+				if err == nil {
+					doc.AddJPEG(data, width, height, 180)
+					embedded = true
+				} else {
+					fmt.Printf("Export: no thumbnail for message %s: %v\n", msg.ID, err)
+				}
+			}
+			if !embedded {
+				doc.WriteLine(fmt.Sprintf("[%s: %s]", msg.MediaType, msg.Filename), 10, false, 0.4)
+			}
+		}
+
+		if msg.Content != "" {
+			doc.WriteParagraph(msg.Content, 10)
+		}
+		if msg.ChainHash != "" {
+			doc.WriteLine(fmt.Sprintf("#%d %s", msg.ChainSeq, msg.ChainHash), 7, false, 0.5)
+		}
+		doc.Space(8)
+	}
+
+	return doc
+}
+
+// registerExportRoutes registers /api/v1/chats/{jid}/export
+func registerExportRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	registerChatRoute("export", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "pdf"
+		}
+		if format != "pdf" {
+			http.Error(w, fmt.Sprintf("Unsupported export format: %s", format), http.StatusBadRequest)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Parse the optional time range
+		var from, to time.Time
+		if value := r.URL.Query().Get("from"); value != "" {
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid from parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("to"); value != "" {
+			if to, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid to parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+
+		messages, err := messageStore.GetTranscript(chatJID, from, to)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		thumbnails := r.URL.Query().Get("thumbnails") != "false"
+		doc := renderTranscriptPDF(client, messageStore, chatJID, messages, thumbnails, loc)
+
+		filename := "chat-" + strings.Split(chatJID, "@")[0] + ".pdf"
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if _, err := doc.WriteTo(w); err != nil {
+			fmt.Printf("Failed to write transcript export: %v\n", err)
+		}
+	})
+}
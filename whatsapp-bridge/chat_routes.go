@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// chatRouteHandler handles a per-chat API resource such as /api/v1/chats/{jid}/draft
+type chatRouteHandler func(w http.ResponseWriter, r *http.Request, chatJID string)
+
+// chatRoutes maps resource names to their handlers
+var chatRoutes = map[string]chatRouteHandler{}
+
+// contactRoutes maps resource names under /api/v1/contacts/{jid} to their handlers
+var contactRoutes = map[string]chatRouteHandler{}
+
+// groupRoutes maps resource names under /api/v1/groups/{jid} to their handlers
+var groupRoutes = map[string]chatRouteHandler{}
+
+// registerChatRoute adds a handler for /api/v1/chats/{jid}/{resource}
+func registerChatRoute(resource string, handler chatRouteHandler) {
+	chatRoutes[resource] = handler
+}
+
+// registerContactRoute adds a handler for /api/v1/contacts/{jid}/{resource}
+func registerContactRoute(resource string, handler chatRouteHandler) {
+	contactRoutes[resource] = handler
+}
+
+// registerGroupRoute adds a handler for /api/v1/groups/{jid}/{resource}
+func registerGroupRoute(resource string, handler chatRouteHandler) {
+	groupRoutes[resource] = handler
+}
+
+// serveChatRoute dispatches /api/v1/chats/{jid}/{resource} requests to the registered handler
+func serveChatRoute(w http.ResponseWriter, r *http.Request) {
+	serveJIDRoute(w, r, "/chats/", chatRoutes)
+}
+
+// serveContactRoute dispatches /api/v1/contacts/{jid}/{resource} requests to the registered handler
+func serveContactRoute(w http.ResponseWriter, r *http.Request) {
+	serveJIDRoute(w, r, "/contacts/", contactRoutes)
+}
+
+// serveGroupRoute dispatches /api/v1/groups/{jid}/{resource} requests to the registered handler
+func serveGroupRoute(w http.ResponseWriter, r *http.Request) {
+	serveJIDRoute(w, r, "/groups/", groupRoutes)
+}
+
+// serveJIDRoute splits {prefix}{jid}/{resource} and calls the matching handler
+func serveJIDRoute(w http.ResponseWriter, r *http.Request, prefix string, routes map[string]chatRouteHandler) {
+	path := strings.TrimPrefix(apiRoute(r), prefix)
+
+	// JIDs never contain a slash, so the last path segment is the resource
+	separator := strings.LastIndex(path, "/")
+	if separator <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	jid, resource := path[:separator], path[separator+1:]
+
+	handler, ok := routes[resource]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	handler(w, r, jid)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// ChunkedUpload describes a resumable upload in progress
+type ChunkedUpload struct {
+	ID        string    `json:"upload_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateUploadRequest represents the request body for starting a chunked upload
+type CreateUploadRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// SendUploadRequest represents the request body for sending a completed upload
+type SendUploadRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Signature *bool  `json:"signature,omitempty"`
+	// QuotedMessageID sends the file as a reply to a stored message of the chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// UploadManager stores resumable uploads on disk until they are complete and sent.
+// Each upload lives in its own directory with a metadata file and the partial data,
+// so clients can resume after a dropped connection or a bridge restart.
+type UploadManager struct {
+	dir     string
+	maxSize int64
+	expiry  time.Duration
+	mutex   sync.Mutex
+}
+
+// NewUploadManager creates a new upload manager rooted in the data directory
+func NewUploadManager() *UploadManager {
+	return &UploadManager{
+		dir:     dataPath("uploads"),
+		maxSize: int64(getEnvInt("CHUNKED_UPLOAD_MAX_MB", 100)) * 1024 * 1024,
+		expiry:  time.Duration(getEnvInt("CHUNKED_UPLOAD_EXPIRY_HOURS", 24)) * time.Hour,
+	}
+}
+
+// uploadDir returns the directory for an upload
+func (m *UploadManager) uploadDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+// dataPath returns the path of the partial upload data
+func (m *UploadManager) dataPath(id string) string {
+	return filepath.Join(m.uploadDir(id), "data.part")
+}
+
+// Create starts a new upload
+func (m *UploadManager) Create(filename string, size int64) (*ChunkedUpload, error) {
+	filename = filepath.Base(filename)
+	if filename == "." || filename == "/" || filename == ".." {
+		return nil, fmt.Errorf("invalid filename")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	if size > m.maxSize {
+		return nil, fmt.Errorf("size exceeds the maximum of %d bytes", m.maxSize)
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate upload ID: %v", err)
+	}
+
+	upload := &ChunkedUpload{
+		ID:        hex.EncodeToString(idBytes),
+		Filename:  filename,
+		Size:      size,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := os.MkdirAll(m.uploadDir(upload.ID), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	metadata, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(m.uploadDir(upload.ID), "upload.json"), metadata, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write upload metadata: %v", err)
+	}
+	if err := os.WriteFile(m.dataPath(upload.ID), nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+
+	return upload, nil
+}
+
+// Get loads an upload and its current offset
+func (m *UploadManager) Get(id string) (*ChunkedUpload, error) {
+	// Upload IDs are hex strings; reject anything else before touching the filesystem
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, os.ErrNotExist
+	}
+
+	metadata, err := os.ReadFile(filepath.Join(m.uploadDir(id), "upload.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var upload ChunkedUpload
+	if err := json.Unmarshal(metadata, &upload); err != nil {
+		return nil, fmt.Errorf("corrupt upload metadata: %v", err)
+	}
+
+	// The offset is whatever has made it to disk
+	info, err := os.Stat(m.dataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = info.Size()
+
+	return &upload, nil
+}
+
+// Append writes a chunk at the given offset and returns the new offset
+func (m *UploadManager) Append(id string, offset int64, chunk io.Reader) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	upload, err := m.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != upload.Offset {
+		return upload.Offset, fmt.Errorf("offset mismatch: expected %d", upload.Offset)
+	}
+
+	file, err := os.OpenFile(m.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return upload.Offset, fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer file.Close()
+
+	// Never accept more than the declared size
+	remaining := upload.Size - upload.Offset
+	written, err := io.Copy(file, io.LimitReader(chunk, remaining))
+	newOffset := upload.Offset + written
+	if err != nil {
+		return newOffset, fmt.Errorf("failed to write chunk: %v", err)
+	}
+
+	return newOffset, nil
+}
+
+// Complete moves the finished upload data to a file carrying its original name, ready to send
+func (m *UploadManager) Complete(id string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	upload, err := m.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if upload.Offset != upload.Size {
+		return "", fmt.Errorf("upload incomplete: %d of %d bytes received", upload.Offset, upload.Size)
+	}
+
+	// The send path derives the media type from the file extension
+	finalPath := filepath.Join(m.uploadDir(id), upload.Filename)
+	if err := os.Rename(m.dataPath(id), finalPath); err != nil {
+		return "", fmt.Errorf("failed to finalize upload: %v", err)
+	}
+
+	return finalPath, nil
+}
+
+// Delete removes an upload and its data
+func (m *UploadManager) Delete(id string) error {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return os.ErrNotExist
+	}
+	return os.RemoveAll(m.uploadDir(id))
+}
+
+// StartCleanup periodically removes uploads that were abandoned
+func (m *UploadManager) StartCleanup() {
+	go func() {
+		for {
+			entries, err := os.ReadDir(m.dir)
+			if err == nil {
+				for _, entry := range entries {
+					upload, err := m.Get(entry.Name())
+					if err != nil || time.Since(upload.CreatedAt) > m.expiry {
+						m.Delete(entry.Name())
+					}
+				}
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// RegisterRoutes registers the chunked upload API routes to the default HTTP mux
+func (m *UploadManager) RegisterRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	// Handler for starting an upload (leader only in HA mode, as uploads live on the replica that received them)
+	handleAPI("/uploads", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CreateUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		upload, err := m.Create(req.Filename, req.Size)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create upload: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", externalURL(r, apiV1Prefix+"/uploads/"+upload.ID))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(upload)
+	}))
+
+	// Handler for upload status, chunks, cancellation and sending
+	handleAPI("/uploads/", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(apiRoute(r), "/uploads/")
+		id, action, _ := strings.Cut(path, "/")
+
+		if action == "send" {
+			refuseDuringMaintenance(sendUnlocked(meteredSend(func(w http.ResponseWriter, r *http.Request) {
+				m.handleSend(w, r, id, client, messageStore)
+			})))(w, r)
+			return
+		}
+		if action != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			upload, err := m.Get(id)
+			if err != nil {
+				http.Error(w, "Upload not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(upload)
+			}
+
+		case http.MethodPatch:
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil {
+				http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+				return
+			}
+
+			newOffset, err := m.Append(id, offset, r.Body)
+			if os.IsNotExist(err) {
+				http.Error(w, "Upload not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+			if err != nil {
+				status := http.StatusInternalServerError
+				if strings.HasPrefix(err.Error(), "offset mismatch") {
+					status = http.StatusConflict
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			if err := m.Delete(id); err != nil {
+				http.Error(w, "Upload not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// handleSend sends a completed upload as a WhatsApp media message and removes it
+func (m *UploadManager) handleSend(w http.ResponseWriter, r *http.Request, id string, client *whatsmeow.Client, messageStore *MessageStore) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SendUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Recipient == "" {
+		http.Error(w, "Recipient is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ClientRef) > maxClientRefLen {
+		http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+		return
+	}
+	if len(req.Agent) > maxAgentLen {
+		http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+		return
+	}
+
+	mediaPath, err := m.Complete(id)
+	if os.IsNotExist(err) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	success, message, messageID, code := sendWhatsAppMessage(client, req.Recipient, req.Message, mediaPath, SendOptions{ClientRef: req.ClientRef, Agent: req.Agent, Signature: req.Signature, APIKey: usageSubject(r), QuotedMessageID: req.QuotedMessageID}, messageStore)
+
+	if success {
+		usageMeter.RecordSend(r, req.Recipient, mediaPath)
+
+		// The file is on WhatsApp's servers now, so the upload is no longer needed
+		if err := m.Delete(id); err != nil {
+			fmt.Printf("Failed to remove upload %s: %v\n", id, err)
+		}
+	} else {
+		// Put the data back so the client can retry the send without uploading again
+		if err := os.Rename(mediaPath, m.dataPath(id)); err != nil {
+			fmt.Printf("Failed to restore upload %s: %v\n", id, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !success {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(SendMessageResponse{
+		Success:   success,
+		Message:   message,
+		MessageID: messageID,
+		ClientRef: req.ClientRef,
+		Agent:     req.Agent,
+	}.withError(code))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// classificationRulesFileName is the default classification rules file inside DATA_DIR
+const classificationRulesFileName = "classification_rules.json"
+
+// classifierContextMessages is how many of a chat's latest messages the LLM reads
+const classifierContextMessages = 10
+
+// ClassificationRule labels the chat of an incoming message that matches its keywords or regex
+type ClassificationRule struct {
+	Label    string   `json:"label"`
+	Keywords []string `json:"keywords,omitempty"`
+	Regex    string   `json:"regex,omitempty"`
+	Groups   bool     `json:"groups,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// llmClassifier asks an OpenAI-compatible chat completions API which labels fit a conversation
+type llmClassifier struct {
+	url    string
+	apiKey string
+	model  string
+	labels []string
+	client *http.Client
+}
+
+// Classifier labels chats from their incoming messages, with rules, an LLM or both
+type Classifier struct {
+	rules        []*ClassificationRule
+	llm          *llmClassifier
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// chatClassifier is set when classification rules or an LLM classifier are configured
+var chatClassifier *Classifier
+
+// NewClassifierFromEnv loads the rules from CLASSIFICATION_RULES_FILE or DATA_DIR/classification_rules.json
+// and the LLM classifier from CLASSIFIER_PROVIDER. It returns nil when neither is configured.
+func NewClassifierFromEnv(messageStore *MessageStore, logger waLog.Logger) (*Classifier, error) {
+	classifier := &Classifier{messageStore: messageStore, logger: logger}
+
+	path := os.Getenv("CLASSIFICATION_RULES_FILE")
+	if path == "" {
+		path = dataPath(classificationRulesFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = ""
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read classification rules: %v", err)
+		}
+		if err := json.Unmarshal(data, &classifier.rules); err != nil {
+			return nil, fmt.Errorf("invalid classification rules in %s: %v", path, err)
+		}
+		for i, rule := range classifier.rules {
+			labels, err := normalizeLabels([]string{rule.Label})
+			if err != nil {
+				return nil, fmt.Errorf("classification rule %d: %v", i+1, err)
+			}
+			rule.Label = labels[0]
+
+			// Keywords match whole words, case-insensitively, as in routing rules
+			var alternatives []string
+			for _, keyword := range rule.Keywords {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					alternatives = append(alternatives, `\b`+regexp.QuoteMeta(keyword)+`\b`)
+				}
+			}
+			if rule.Regex != "" {
+				alternatives = append(alternatives, "(?:"+rule.Regex+")")
+			}
+			if len(alternatives) == 0 {
+				return nil, fmt.Errorf("classification rule for %q has no keywords or regex", rule.Label)
+			}
+			if rule.pattern, err = regexp.Compile("(?i)" + strings.Join(alternatives, "|")); err != nil {
+				return nil, fmt.Errorf("invalid regex in classification rule for %q: %v", rule.Label, err)
+			}
+		}
+		logger.Infof("Loaded %d classification rules from %s", len(classifier.rules), path)
+	}
+
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("CLASSIFIER_PROVIDER"))); kind {
+	case "":
+	case "openai":
+		endpoint := strings.TrimRight(os.Getenv("CLASSIFIER_URL"), "/")
+		if endpoint == "" {
+			endpoint = "https://api.openai.com/v1"
+		}
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return nil, fmt.Errorf("CLASSIFIER_URL must be an http or https URL")
+		}
+		apiKey := os.Getenv("CLASSIFIER_API_KEY")
+		if apiKey == "" && strings.HasPrefix(endpoint, "https://api.openai.com/") {
+			return nil, fmt.Errorf("CLASSIFIER_PROVIDER=openai requires CLASSIFIER_API_KEY")
+		}
+		labels, err := normalizeLabels(strings.Split(os.Getenv("CLASSIFIER_LABELS"), ","))
+		if err != nil || os.Getenv("CLASSIFIER_LABELS") == "" {
+			return nil, fmt.Errorf("CLASSIFIER_LABELS must list the labels to choose from, e.g. order,complaint,spam,lead")
+		}
+		model := os.Getenv("CLASSIFIER_MODEL")
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		classifier.llm = &llmClassifier{
+			url:    endpoint,
+			apiKey: apiKey,
+			model:  model,
+			labels: labels,
+			client: &http.Client{Timeout: time.Duration(getEnvInt("CLASSIFIER_TIMEOUT_SECONDS", 30)) * time.Second},
+		}
+	default:
+		return nil, fmt.Errorf("invalid CLASSIFIER_PROVIDER: %s (expected openai)", kind)
+	}
+
+	if len(classifier.rules) == 0 && classifier.llm == nil {
+		return nil, nil
+	}
+	return classifier, nil
+}
+
+// Classify adds the labels that fit an incoming message to its chat. Labels are only ever added,
+// so agents can remove a wrong one without it being replaced until a new message matches again.
+func (c *Classifier) Classify(msg IncomingMessage) {
+	var labels, sources []string
+	for _, rule := range c.rules {
+		if (!msg.IsGroup || rule.Groups) && rule.pattern.MatchString(msg.Content) {
+			labels = append(labels, rule.Label)
+		}
+	}
+	if len(labels) > 0 {
+		sources = append(sources, "rules")
+	}
+
+	if c.llm != nil && !msg.IsGroup && strings.TrimSpace(msg.Content) != "" {
+		chosen, err := c.classifyWithLLM(msg.ChatJID)
+		if err != nil {
+			c.logger.Warnf("Failed to classify chat %s: %v", msg.ChatJID, err)
+		} else if len(chosen) > 0 {
+			labels = append(labels, chosen...)
+			sources = append(sources, "llm")
+		}
+	}
+	if len(labels) == 0 {
+		return
+	}
+
+	added, err := c.messageStore.AddChatLabels(msg.ChatJID, labels)
+	if err != nil {
+		c.logger.Warnf("Failed to label chat %s: %v", msg.ChatJID, err)
+		return
+	}
+	if len(added) > 0 {
+		publishEvent(EventChatLabeled, msg.ChatJID, time.Time{}, map[string]interface{}{
+			"chat_jid": msg.ChatJID,
+			"labels":   added,
+			"source":   strings.Join(sources, ","),
+		})
+	}
+}
+
+// classifyWithLLM shows the model the latest messages of a chat and returns the labels it chose
+func (c *Classifier) classifyWithLLM(chatJID string) ([]string, error) {
+	messages, err := c.messageStore.ListMessages(chatJID, classifierContextMessages)
+	if err != nil {
+		return nil, err
+	}
+	var transcript strings.Builder
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.SystemEvent != "" || msg.Content == "" {
+			continue
+		}
+		if msg.IsFromMe {
+			transcript.WriteString("Business: ")
+		} else {
+			transcript.WriteString("Customer: ")
+		}
+		transcript.WriteString(strings.ReplaceAll(msg.Content, "\n", " "))
+		transcript.WriteString("\n")
+	}
+
+	request := map[string]interface{}{
+		"model":       c.llm.model,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{"role": "system", "content": "You label WhatsApp conversations between a business and a customer. " +
+				"Choose every label that applies from this list: " + strings.Join(c.llm.labels, ", ") + ". " +
+				`Answer with a JSON array of the chosen labels only, such as ["` + c.llm.labels[0] + `"], or [] if none applies.`},
+			{"role": "user", "content": transcript.String()},
+		},
+	}
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postProviderRequest(c.llm.client, c.llm.url+"/chat/completions", c.llm.apiKey, request, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("classifier returned no answer")
+	}
+	return c.llm.allowed(result.Choices[0].Message.Content)
+}
+
+// allowed reads the JSON array in a model's answer and keeps the configured labels, so a model
+// can't invent new ones
+func (l *llmClassifier) allowed(answer string) ([]string, error) {
+	start, end := strings.Index(answer, "["), strings.LastIndex(answer, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("classifier answer is not a JSON array: %.100s", answer)
+	}
+	var chosen []string
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &chosen); err != nil {
+		return nil, fmt.Errorf("classifier answer is not a JSON array: %.100s", answer)
+	}
+	var labels []string
+	for _, label := range chosen {
+		label = strings.ToLower(strings.TrimSpace(label))
+		for _, known := range l.labels {
+			if label == known {
+				labels = append(labels, label)
+				break
+			}
+		}
+	}
+	return labels, nil
+}
+
+// AddChatLabels adds labels to a chat, up to maxChatLabels in all, and returns those that were new
+func (store *MessageStore) AddChatLabels(jid string, labels []string) ([]string, error) {
+	labels, err := normalizeLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := store.GetChatLabels(jid)
+	if err != nil {
+		return nil, err
+	}
+	has := map[string]bool{}
+	for _, label := range existing {
+		has[label] = true
+	}
+
+	query := "INSERT OR IGNORE INTO chat_labels (chat_jid, label, added_at) VALUES (?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO chat_labels (chat_jid, label, added_at) VALUES ($1, $2, $3) ON CONFLICT (chat_jid, label) DO NOTHING"
+	}
+	added := []string{}
+	now := time.Now().UTC()
+	for _, label := range labels {
+		if has[label] || len(existing)+len(added) >= maxChatLabels {
+			continue
+		}
+		if _, err := store.db.Exec(query, jid, label, now); err != nil {
+			return added, err
+		}
+		added = append(added, label)
+	}
+	return added, nil
+}
+
+// ChatsWithLabel returns the JIDs of the chats that have a label
+func (store *MessageStore) ChatsWithLabel(label string) (map[string]bool, error) {
+	query := "SELECT chat_jid FROM chat_labels WHERE label = ?"
+	if store.isPostgres {
+		query = "SELECT chat_jid FROM chat_labels WHERE label = $1"
+	}
+	rows, err := store.db.Query(query, strings.ToLower(strings.TrimSpace(label)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := map[string]bool{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		chats[jid] = true
+	}
+	return chats, rows.Err()
+}
+
+// registerClassificationRoutes registers /api/v1/classification/rules
+func registerClassificationRoutes() {
+	handleAPI("/classification/rules", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response := struct {
+			Rules     []*ClassificationRule `json:"rules"`
+			LLMLabels []string              `json:"llm_labels,omitempty"`
+		}{Rules: []*ClassificationRule{}}
+		if chatClassifier != nil {
+			if chatClassifier.rules != nil {
+				response.Rules = chatClassifier.rules
+			}
+			if chatClassifier.llm != nil {
+				response.LLMLabels = chatClassifier.llm.labels
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
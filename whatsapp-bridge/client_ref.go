@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// maxClientRefLen keeps client references to the size of an order or ticket ID
+const maxClientRefLen = 255
+
+// SetSendDetails stores the caller's reference, the authoring agent and the API key of a sent message
+func (store *MessageStore) SetSendDetails(id, chatJID string, opts SendOptions) error {
+	var query string
+	if store.isPostgres {
+		query = "UPDATE messages SET client_ref = NULLIF($1, ''), agent = NULLIF($2, ''), api_key = NULLIF($3, '') WHERE id = $4 AND chat_jid = $5"
+	} else {
+		query = "UPDATE messages SET client_ref = NULLIF(?, ''), agent = NULLIF(?, ''), api_key = NULLIF(?, '') WHERE id = ? AND chat_jid = ?"
+	}
+
+	return store.captureMessage(id, chatJID, func() error {
+		_, err := store.db.Exec(query, opts.ClientRef, opts.Agent, opts.APIKey, id, chatJID)
+		return err
+	})
+}
+
+// FindMessagesByClientRef returns the messages sent with a client reference, newest first
+func (store *MessageStore) FindMessagesByClientRef(clientRef string) ([]APIMessage, error) {
+	var query string
+	if store.isPostgres {
+		query = "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '') FROM messages WHERE client_ref = $1 ORDER BY timestamp DESC"
+	} else {
+		query = "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '') FROM messages WHERE client_ref = ? ORDER BY timestamp DESC"
+	}
+
+	rows, err := store.db.Query(query, clientRef)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []APIMessage{}
+	for rows.Next() {
+		var msg APIMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.SystemEvent, &msg.ClientRef, &msg.Agent, &msg.ReplyTo, &msg.Status); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// registerClientRefRoutes registers /api/v1/messages?client_ref=
+func registerClientRefRoutes(messageStore *MessageStore) {
+	handleAPI("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		clientRef := r.URL.Query().Get("client_ref")
+		if clientRef == "" {
+			http.Error(w, "client_ref is required", http.StatusBadRequest)
+			return
+		}
+
+		messages, err := messageStore.FindMessagesByClientRef(clientRef)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range messages {
+			messages[i].Timestamp = messages[i].Timestamp.In(loc)
+			legalHolds.RecordRequest(r, messages[i].ChatJID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	})
+}
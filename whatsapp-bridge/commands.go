@@ -0,0 +1,547 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// broadcastInterval spaces out the messages of a !broadcast
+const broadcastInterval = time.Second
+
+// ChatCommand is a command that admins send to the bridge in a direct chat, such as !status.
+// Extensions add their own with RegisterChatCommand.
+type ChatCommand interface {
+	// Name is the word after the prefix, e.g. "status"
+	Name() string
+	// Usage is the line shown by !help, without the prefix, e.g. "mute <chat> [duration]"
+	Usage() string
+	// Run executes the command and returns the reply
+	Run(ctx *CommandContext, args []string) (string, error)
+}
+
+// CommandContext is what a command runs with
+type CommandContext struct {
+	Client       *whatsmeow.Client
+	MessageStore *MessageStore
+	Logger       waLog.Logger
+	// Admin is the phone number of the admin who sent the command
+	Admin   string
+	Message IncomingMessage
+	// Prefix is the configured command prefix, for replies that mention other commands
+	Prefix string
+}
+
+// chatCommandFunc adapts a function to ChatCommand
+type chatCommandFunc struct {
+	name  string
+	usage string
+	run   func(ctx *CommandContext, args []string) (string, error)
+}
+
+func (c *chatCommandFunc) Name() string  { return c.name }
+func (c *chatCommandFunc) Usage() string { return c.usage }
+func (c *chatCommandFunc) Run(ctx *CommandContext, args []string) (string, error) {
+	return c.run(ctx, args)
+}
+
+// NewChatCommand creates a command from a function
+func NewChatCommand(name, usage string, run func(ctx *CommandContext, args []string) (string, error)) ChatCommand {
+	return &chatCommandFunc{name: name, usage: usage, run: run}
+}
+
+var (
+	chatCommandsMutex sync.RWMutex
+	// chatCommands maps lowercase command names to their implementation
+	chatCommands = map[string]ChatCommand{}
+)
+
+// RegisterChatCommand adds a command, replacing a built-in one of the same name
+func RegisterChatCommand(command ChatCommand) {
+	chatCommandsMutex.Lock()
+	defer chatCommandsMutex.Unlock()
+	chatCommands[strings.ToLower(command.Name())] = command
+}
+
+// lookupChatCommand returns a registered command, or nil
+func lookupChatCommand(name string) ChatCommand {
+	chatCommandsMutex.RLock()
+	defer chatCommandsMutex.RUnlock()
+	return chatCommands[strings.ToLower(name)]
+}
+
+// listChatCommands returns the registered commands sorted by name
+func listChatCommands() []ChatCommand {
+	chatCommandsMutex.RLock()
+	defer chatCommandsMutex.RUnlock()
+	commands := make([]ChatCommand, 0, len(chatCommands))
+	for _, command := range chatCommands {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name() < commands[j].Name() })
+	return commands
+}
+
+// CommandProcessor runs chat commands from allowlisted admin numbers
+type CommandProcessor struct {
+	admins       map[string]bool
+	prefix       string
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// commandProcessor is set when COMMAND_ADMINS is configured
+var commandProcessor *CommandProcessor
+
+// NewCommandProcessorFromEnv reads COMMAND_ADMINS and COMMAND_PREFIX.
+// It returns nil when no admins are configured.
+func NewCommandProcessorFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*CommandProcessor, error) {
+	admins := make(map[string]bool)
+	for _, admin := range splitEnvList("COMMAND_ADMINS") {
+		phone := strings.TrimPrefix(admin, "+")
+		if phone == "" || strings.Trim(phone, "0123456789") != "" {
+			return nil, fmt.Errorf("invalid phone number %q in COMMAND_ADMINS", admin)
+		}
+		admins[phone] = true
+	}
+	if len(admins) == 0 {
+		return nil, nil
+	}
+
+	prefix := strings.TrimSpace(os.Getenv("COMMAND_PREFIX"))
+	if prefix == "" {
+		prefix = "!"
+	}
+
+	registerBuiltinChatCommands()
+	logger.Infof("Chat commands enabled for %d admin numbers", len(admins))
+	return &CommandProcessor{
+		admins:       admins,
+		prefix:       prefix,
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+	}, nil
+}
+
+// Handle runs a message as a command if it is one from an admin in a direct chat, and reports
+// whether it was. Commands in groups are ignored so their output never reaches other members.
+func (p *CommandProcessor) Handle(msg IncomingMessage) bool {
+	if p == nil || msg.IsGroup || !p.admins[msg.SenderPhone] || !strings.HasPrefix(msg.Content, p.prefix) {
+		return false
+	}
+	fields := strings.Fields(strings.TrimPrefix(msg.Content, p.prefix))
+	if len(fields) == 0 {
+		return false
+	}
+
+	name, args := strings.ToLower(fields[0]), fields[1:]
+	command := lookupChatCommand(name)
+	if command == nil {
+		p.reply(msg.ChatJID, fmt.Sprintf("Unknown command %s%s. Send %shelp for a list.", p.prefix, name, p.prefix))
+		return true
+	}
+
+	ctx := &CommandContext{
+		Client:       p.client,
+		MessageStore: p.messageStore,
+		Logger:       p.logger,
+		Admin:        msg.SenderPhone,
+		Message:      msg,
+		Prefix:       p.prefix,
+	}
+	reply, err := command.Run(ctx, args)
+
+	// Every command is logged, as they can change what the bridge does
+	data := map[string]interface{}{
+		"admin":   msg.SenderPhone,
+		"command": name,
+		"args":    args,
+		"success": err == nil,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+		reply = fmt.Sprintf("%s%s failed: %v", p.prefix, name, err)
+	}
+	p.logger.Infof("Chat command %s from %s (success: %v)", name, logRedactor.Phone(msg.SenderPhone), err == nil)
+	publishEvent(EventCommandExecuted, msg.ChatJID, msg.Timestamp, data)
+
+	if reply != "" {
+		p.reply(msg.ChatJID, reply)
+	}
+	return true
+}
+
+// Muted reports whether a chat was muted with !mute. Mutes are made and honoured while chat
+// commands are enabled.
+func (p *CommandProcessor) Muted(chatJID string) bool {
+	return p != nil && p.messageStore.IsChatMuted(chatJID)
+}
+
+// reply answers an admin
+func (p *CommandProcessor) reply(chatJID, text string) {
+	noSignature := false
+	success, result, _, _ := sendWhatsAppMessage(p.client, chatJID, text, "", SendOptions{Agent: "commands", Signature: &noSignature}, p.messageStore)
+	if !success {
+		p.logger.Warnf("Reply to chat command failed: %s", result)
+	}
+}
+
+// commandChatJID turns a phone number or JID argument into a chat JID
+func commandChatJID(arg string) (string, error) {
+	if strings.Contains(arg, "@") {
+		jid, err := types.ParseJID(arg)
+		if err != nil || jid.User == "" {
+			return "", fmt.Errorf("invalid chat %q", arg)
+		}
+		return jid.String(), nil
+	}
+	phone := strings.TrimPrefix(arg, "+")
+	if phone == "" || strings.Trim(phone, "0123456789") != "" {
+		return "", fmt.Errorf("invalid chat %q, expected a phone number or JID", arg)
+	}
+	return phone + "@" + types.DefaultUserServer, nil
+}
+
+// parseMuteDuration reads durations such as 30m, 8h or 7d
+func parseMuteDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q, expected e.g. 30m, 8h or 7d", value)
+	}
+	return duration, nil
+}
+
+// registerBuiltinChatCommands adds help, status, broadcast, mute, unmute and muted.
+// Commands registered earlier by extensions keep their place.
+func registerBuiltinChatCommands() {
+	builtins := []ChatCommand{
+		NewChatCommand("help", "help", func(ctx *CommandContext, args []string) (string, error) {
+			lines := []string{"Commands:"}
+			for _, command := range listChatCommands() {
+				lines = append(lines, ctx.Prefix+command.Usage())
+			}
+			return strings.Join(lines, "\n"), nil
+		}),
+		NewChatCommand("status", "status", runStatusCommand),
+		NewChatCommand("broadcast", "broadcast <groups|numbers,...> <message>", runBroadcastCommand),
+		NewChatCommand("mute", "mute <chat> [duration]", func(ctx *CommandContext, args []string) (string, error) {
+			if len(args) == 0 || len(args) > 2 {
+				return "Usage: " + ctx.Prefix + "mute <phone number or JID> [duration, e.g. 8h or 7d]", nil
+			}
+			chatJID, err := commandChatJID(args[0])
+			if err != nil {
+				return "", err
+			}
+			mute := &ChatMute{ChatJID: chatJID, MutedBy: ctx.Admin, MutedAt: time.Now().UTC()}
+			if len(args) == 2 {
+				duration, err := parseMuteDuration(args[1])
+				if err != nil {
+					return "", err
+				}
+				until := mute.MutedAt.Add(duration)
+				mute.Until = &until
+			}
+			if err := ctx.MessageStore.MuteChat(mute); err != nil {
+				return "", err
+			}
+			if mute.Until != nil {
+				return fmt.Sprintf("Muted %s until %s.", chatJID, formatTimestamp(*mute.Until, displayLocation)), nil
+			}
+			return fmt.Sprintf("Muted %s.", chatJID), nil
+		}),
+		NewChatCommand("unmute", "unmute <chat>", func(ctx *CommandContext, args []string) (string, error) {
+			if len(args) != 1 {
+				return "Usage: " + ctx.Prefix + "unmute <phone number or JID>", nil
+			}
+			chatJID, err := commandChatJID(args[0])
+			if err != nil {
+				return "", err
+			}
+			unmuted, err := ctx.MessageStore.UnmuteChat(chatJID)
+			if err != nil {
+				return "", err
+			}
+			if !unmuted {
+				return fmt.Sprintf("%s was not muted.", chatJID), nil
+			}
+			return fmt.Sprintf("Unmuted %s.", chatJID), nil
+		}),
+		NewChatCommand("muted", "muted", func(ctx *CommandContext, args []string) (string, error) {
+			mutes, err := ctx.MessageStore.ListChatMutes()
+			if err != nil {
+				return "", err
+			}
+			if len(mutes) == 0 {
+				return "No chats are muted.", nil
+			}
+			lines := []string{"Muted chats:"}
+			for _, mute := range mutes {
+				line := mute.ChatJID
+				if mute.Until != nil {
+					line += " until " + formatTimestamp(*mute.Until, displayLocation)
+				}
+				lines = append(lines, line)
+			}
+			return strings.Join(lines, "\n"), nil
+		}),
+	}
+
+	chatCommandsMutex.Lock()
+	defer chatCommandsMutex.Unlock()
+	for _, command := range builtins {
+		if chatCommands[command.Name()] == nil {
+			chatCommands[command.Name()] = command
+		}
+	}
+}
+
+// runStatusCommand reports the connection, send restrictions and muted chats
+func runStatusCommand(ctx *CommandContext, args []string) (string, error) {
+	connected := "no"
+	if ctx.Client.IsConnected() {
+		connected = "yes"
+	}
+	lines := []string{"Connected: " + connected}
+
+	if status := maintenance.Status(); status.State != MaintenanceOff {
+		lines = append(lines, fmt.Sprintf("Maintenance: %s (%s)", status.State, status.Reason))
+	}
+	if lock := sessionGuard.Current(); lock != nil {
+		lines = append(lines, "Sending locked: "+lock.Reason)
+	}
+	if status, err := warmUp.Status(); err == nil && status != nil {
+		lines = append(lines, fmt.Sprintf("Warm-up: day %d, %d of %d messages left today", status.Day, status.Remaining, status.DailyLimit))
+	}
+
+	mutes, err := ctx.MessageStore.ListChatMutes()
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("Muted chats: %d", len(mutes)))
+	return strings.Join(lines, "\n"), nil
+}
+
+// runBroadcastCommand sends a message to all stored groups or to a list of numbers, skipping muted chats
+func runBroadcastCommand(ctx *CommandContext, args []string) (string, error) {
+	if len(args) < 2 {
+		return "Usage: " + ctx.Prefix + "broadcast <groups|numbers,...> <message>", nil
+	}
+	// The message keeps its original spacing and line breaks: drop the command and the target
+	content := strings.TrimSpace(strings.TrimPrefix(ctx.Message.Content, ctx.Prefix))
+	for range 2 {
+		content = strings.TrimSpace(content[strings.IndexFunc(content, unicode.IsSpace):])
+	}
+
+	var recipients []string
+	if strings.EqualFold(args[0], "groups") {
+		groups, err := ctx.MessageStore.groupChatJIDs()
+		if err != nil {
+			return "", err
+		}
+		recipients = groups
+	} else {
+		for _, target := range strings.Split(args[0], ",") {
+			chatJID, err := commandChatJID(target)
+			if err != nil {
+				return "", err
+			}
+			recipients = append(recipients, chatJID)
+		}
+	}
+
+	sent, skipped := 0, 0
+	var failures []string
+	for i, recipient := range recipients {
+		if ctx.MessageStore.IsChatMuted(recipient) {
+			skipped++
+			continue
+		}
+		if i > 0 {
+			time.Sleep(broadcastInterval)
+		}
+		success, result, _, _ := sendWhatsAppMessage(ctx.Client, recipient, content, "", SendOptions{Agent: "broadcast"}, ctx.MessageStore)
+		if !success {
+			failures = append(failures, fmt.Sprintf("%s: %s", recipient, result))
+			continue
+		}
+		sent++
+	}
+
+	reply := fmt.Sprintf("Broadcast sent to %d of %d chats", sent, len(recipients))
+	if skipped > 0 {
+		reply += fmt.Sprintf(", %d muted chats skipped", skipped)
+	}
+	if len(failures) > 0 {
+		reply += ".\nFailed:\n" + strings.Join(failures, "\n")
+	}
+	return reply, nil
+}
+
+// groupChatJIDs returns the JIDs of all stored group chats
+func (store *MessageStore) groupChatJIDs() ([]string, error) {
+	rows, err := store.db.Query("SELECT jid FROM chats WHERE jid LIKE '%@g.us' ORDER BY jid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// ChatMute stops flows, routing rules and moderation from acting on a chat's messages
+type ChatMute struct {
+	ChatJID string     `json:"chat_jid"`
+	MutedBy string     `json:"muted_by"`
+	MutedAt time.Time  `json:"muted_at"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// MuteChat mutes a chat, replacing an existing mute
+func (store *MessageStore) MuteChat(mute *ChatMute) error {
+	query := `INSERT INTO chat_mutes (chat_jid, muted_by, muted_at, muted_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET muted_by = excluded.muted_by, muted_at = excluded.muted_at, muted_until = excluded.muted_until`
+	if store.isPostgres {
+		query = `INSERT INTO chat_mutes (chat_jid, muted_by, muted_at, muted_until) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_jid) DO UPDATE SET muted_by = EXCLUDED.muted_by, muted_at = EXCLUDED.muted_at, muted_until = EXCLUDED.muted_until`
+	}
+	_, err := store.db.Exec(query, mute.ChatJID, mute.MutedBy, mute.MutedAt, mute.Until)
+	return err
+}
+
+// UnmuteChat removes the mute of a chat and reports whether it was muted
+func (store *MessageStore) UnmuteChat(chatJID string) (bool, error) {
+	query := "DELETE FROM chat_mutes WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM chat_mutes WHERE chat_jid = $1"
+	}
+	result, err := store.db.Exec(query, chatJID)
+	if err != nil {
+		return false, err
+	}
+	removed, _ := result.RowsAffected()
+	return removed > 0, nil
+}
+
+// IsChatMuted reports whether a chat is muted now
+func (store *MessageStore) IsChatMuted(chatJID string) bool {
+	query := "SELECT 1 FROM chat_mutes WHERE chat_jid = ? AND (muted_until IS NULL OR muted_until > ?)"
+	if store.isPostgres {
+		query = "SELECT 1 FROM chat_mutes WHERE chat_jid = $1 AND (muted_until IS NULL OR muted_until > $2)"
+	}
+	var muted int
+	return store.db.QueryRow(query, chatJID, time.Now().UTC()).Scan(&muted) == nil
+}
+
+// ListChatMutes returns the chats muted now, most recently muted first
+func (store *MessageStore) ListChatMutes() ([]ChatMute, error) {
+	query := "SELECT chat_jid, muted_by, muted_at, muted_until FROM chat_mutes WHERE muted_until IS NULL OR muted_until > ? ORDER BY muted_at DESC"
+	if store.isPostgres {
+		query = "SELECT chat_jid, muted_by, muted_at, muted_until FROM chat_mutes WHERE muted_until IS NULL OR muted_until > $1 ORDER BY muted_at DESC"
+	}
+	rows, err := store.db.Query(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mutes := []ChatMute{}
+	for rows.Next() {
+		var mute ChatMute
+		var until sql.NullTime
+		if err := rows.Scan(&mute.ChatJID, &mute.MutedBy, &mute.MutedAt, &until); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			mute.Until = &until.Time
+		}
+		mutes = append(mutes, mute)
+	}
+	return mutes, rows.Err()
+}
+
+// registerCommandRoutes registers /api/v1/commands and /api/v1/mutes
+func registerCommandRoutes(messageStore *MessageStore) {
+	handleAPI("/commands", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		type commandInfo struct {
+			Name  string `json:"name"`
+			Usage string `json:"usage"`
+		}
+		response := struct {
+			Enabled  bool          `json:"enabled"`
+			Prefix   string        `json:"prefix,omitempty"`
+			Commands []commandInfo `json:"commands"`
+		}{Enabled: commandProcessor != nil, Commands: []commandInfo{}}
+		if commandProcessor != nil {
+			response.Prefix = commandProcessor.prefix
+		}
+		for _, command := range listChatCommands() {
+			response.Commands = append(response.Commands, commandInfo{Name: command.Name(), Usage: command.Usage()})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	handleAPI("/mutes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mutes, err := messageStore.ListChatMutes()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list muted chats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range mutes {
+			mutes[i].MutedAt = mutes[i].MutedAt.In(loc)
+			if mutes[i].Until != nil {
+				until := mutes[i].Until.In(loc)
+				mutes[i].Until = &until
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mutes)
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxETagBodyBytes bounds the responses buffered to compute an ETag; larger ones are streamed without one
+const maxETagBodyBytes = 4 << 20
+
+// compressionEnabled is read from HTTP_COMPRESSION at startup
+var compressionEnabled = true
+
+// gzipWriters reuses compressors, which are expensive to allocate
+var gzipWriters = sync.Pool{New: func() interface{} {
+	writer, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return writer
+}}
+
+// compressibleType reports whether a Content-Type is text that compresses well.
+// Event streams are left alone so each event reaches the browser as it is written.
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-ndjson", "application/yaml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding without refusing it with q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			q := strings.ReplaceAll(params, " ", "")
+			return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body once the handler has set a compressible Content-Type
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzip    *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.decided {
+		g.decided = true
+		header := g.Header()
+		if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+			header.Get("Content-Encoding") == "" && compressibleType(header.Get("Content-Type")) {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+			g.gzip = gzipWriters.Get().(*gzip.Writer)
+			g.gzip.Reset(g.ResponseWriter)
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !g.decided {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gzip != nil {
+		return g.gzip.Write(data)
+	}
+	return g.ResponseWriter.Write(data)
+}
+
+// Flush sends what was compressed so far, for handlers that stream
+func (g *gzipResponseWriter) Flush() {
+	if g.gzip != nil {
+		g.gzip.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection to handlers that take it over, such as WebSocket upgrades
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gzip != nil {
+		g.gzip.Close()
+		gzipWriters.Put(g.gzip)
+		g.gzip = nil
+	}
+}
+
+// compressionMiddleware gzips text responses for clients that accept it
+func compressionMiddleware(next http.Handler) http.Handler {
+	if !compressionEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// Ranges address bytes of the uncompressed body, and upgrades aren't responses at all
+		if !acceptsGzip(r) || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &gzipResponseWriter{ResponseWriter: w}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// etagResponseWriter buffers a successful HTML or JSON response to derive its ETag.
+// Anything else, and responses that stream or grow too large, pass straight through.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+// bypass stops buffering and sends what was held back
+func (e *etagResponseWriter) bypass() {
+	if e.passthrough {
+		return
+	}
+	e.passthrough = true
+	if e.status != 0 {
+		e.ResponseWriter.WriteHeader(e.status)
+	}
+	if e.body.Len() > 0 {
+		e.ResponseWriter.Write(e.body.Bytes())
+		e.body.Reset()
+	}
+}
+
+func (e *etagResponseWriter) WriteHeader(status int) {
+	if e.passthrough || e.status != 0 {
+		e.ResponseWriter.WriteHeader(status)
+		return
+	}
+	e.status = status
+	header := e.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if status != http.StatusOK || header.Get("ETag") != "" || strings.Contains(header.Get("Cache-Control"), "no-store") ||
+		(mediaType != "text/html" && mediaType != "application/json") {
+		e.bypass()
+	}
+}
+
+func (e *etagResponseWriter) Write(data []byte) (int, error) {
+	if e.status == 0 {
+		if e.Header().Get("Content-Type") == "" {
+			e.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.passthrough {
+		return e.ResponseWriter.Write(data)
+	}
+	if e.body.Len()+len(data) > maxETagBodyBytes {
+		e.bypass()
+		return e.ResponseWriter.Write(data)
+	}
+	return e.body.Write(data)
+}
+
+// Flush means the handler is streaming, so the body can't be held back for an ETag
+func (e *etagResponseWriter) Flush() {
+	if e.status == 0 {
+		e.WriteHeader(http.StatusOK)
+	}
+	e.bypass()
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (e *etagResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := e.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+	}
+	e.passthrough = true
+	return hijacker.Hijack()
+}
+
+func (e *etagResponseWriter) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
+
+// finish tags a buffered response and answers 304 Not Modified when the client already has it
+func (e *etagResponseWriter) finish(r *http.Request) {
+	if e.passthrough || e.status == 0 {
+		return
+	}
+	sum := sha256.Sum256(e.body.Bytes())
+	// Weak, as the compressed and uncompressed bodies share it
+	etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
+	header := e.Header()
+	header.Set("ETag", etag)
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", "private, no-cache")
+	}
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			e.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	e.ResponseWriter.WriteHeader(e.status)
+	e.ResponseWriter.Write(e.body.Bytes())
+}
+
+// etagMiddleware adds ETags to HTML pages and JSON responses of GET requests, so browsers
+// revalidate the dashboard and listings with If-None-Match and get an empty 304 when nothing changed
+func etagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &etagResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r)
+		writer.finish(r)
+	})
+}
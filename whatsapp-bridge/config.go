@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getEnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(name string, def bool) bool {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return def
+	}
+
+	return parsed
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when unset or invalid
+func getEnvInt(name string, def int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+
+	return parsed
+}
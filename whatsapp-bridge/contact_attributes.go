@@ -0,0 +1,555 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Limits on contact attributes, which are for personalizing messages rather than a CRM
+const (
+	maxContactFields      = 50
+	maxContactNameLen     = 200
+	maxContactImportRows  = 10000
+	maxContactImportBytes = 10 << 20
+)
+
+// contactFieldPattern limits custom field names to what a {{name}} placeholder can refer to
+var contactFieldPattern = regexp.MustCompile(`^\w{1,64}$`)
+
+// contactLanguagePattern matches language tags such as en, pt-BR or zh-Hant
+var contactLanguagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// contactIDColumns are the CSV columns that name the contact of a row
+var contactIDColumns = []string{"phone", "jid", "recipient"}
+
+// ContactAttributes personalize messages to a contact: each is a template variable of the same
+// name when sending to them, with the custom fields alongside name and language
+type ContactAttributes struct {
+	JID      string `json:"jid"`
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Fields are custom attributes, e.g. {"plan": "pro"}
+	Fields    map[string]string `json:"fields"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// ContactAttributesUpdate is the body of PATCH; a null field removes it
+type ContactAttributesUpdate struct {
+	Name     *string            `json:"name"`
+	Language *string            `json:"language"`
+	Fields   map[string]*string `json:"fields"`
+}
+
+// ContactImportRow is an entry of a JSON import; Recipient is a phone number or JID
+type ContactImportRow struct {
+	Recipient string            `json:"recipient"`
+	Name      string            `json:"name,omitempty"`
+	Language  string            `json:"language,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// ContactImportError is a row an import skipped; rows count from 1, after a CSV's header
+type ContactImportError struct {
+	Row       int    `json:"row"`
+	Recipient string `json:"recipient,omitempty"`
+	Error     string `json:"error"`
+}
+
+// ContactImportResult is the response of POST /api/v1/contacts/attributes/import
+type ContactImportResult struct {
+	Imported int                  `json:"imported"`
+	Skipped  []ContactImportError `json:"skipped"`
+}
+
+// contactJID returns the JID of a contact given as a phone number, such as +44 7700 900123, or a
+// JID; groups have no attributes
+func contactJID(recipient string) (string, error) {
+	value := strings.TrimSpace(recipient)
+	if !strings.Contains(value, "@") {
+		value = normalizePairPhone(value)
+	}
+	jid, err := parseRecipient(value)
+	if err != nil || jid.User == "" {
+		return "", fmt.Errorf("invalid contact %q", recipient)
+	}
+	if jid.Server == types.GroupServer || jid.Server == types.BroadcastServer || jid.Server == types.NewsletterServer {
+		return "", fmt.Errorf("%s is not a contact; only contacts have attributes", jid)
+	}
+	return jid.ToNonAD().String(), nil
+}
+
+// normalize trims the attributes and checks them against the limits
+func (attributes *ContactAttributes) normalize() error {
+	attributes.Name = strings.TrimSpace(attributes.Name)
+	attributes.Language = strings.ReplaceAll(strings.TrimSpace(attributes.Language), "_", "-")
+	if len(attributes.Name) > maxContactNameLen {
+		return fmt.Errorf("name must be at most %d characters", maxContactNameLen)
+	}
+	if attributes.Language != "" && !contactLanguagePattern.MatchString(attributes.Language) {
+		return fmt.Errorf("invalid language %q; use a language tag such as en or pt-BR", attributes.Language)
+	}
+	if attributes.Fields == nil {
+		attributes.Fields = map[string]string{}
+	}
+	if len(attributes.Fields) > maxContactFields {
+		return fmt.Errorf("at most %d fields are allowed", maxContactFields)
+	}
+	for key, value := range attributes.Fields {
+		if !contactFieldPattern.MatchString(key) {
+			return fmt.Errorf("invalid field %q; names are up to 64 letters, digits and underscores", key)
+		}
+		if key == "name" || key == "language" {
+			return fmt.Errorf("%s is an attribute of its own, not a field", key)
+		}
+		if len(value) > maxMetadataValueLen {
+			return fmt.Errorf("field %q is longer than %d characters", key, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+// merge sets the non-empty attributes of other over these, as an import does
+func (attributes *ContactAttributes) merge(other ContactAttributes) {
+	if other.Name != "" {
+		attributes.Name = other.Name
+	}
+	if other.Language != "" {
+		attributes.Language = other.Language
+	}
+	for key, value := range other.Fields {
+		if value != "" {
+			attributes.Fields[key] = value
+		}
+	}
+}
+
+// variables returns the attributes as template variables; empty ones are left out, so a
+// placeholder using them counts as undefined
+func (attributes *ContactAttributes) variables() map[string]string {
+	variables := make(map[string]string, len(attributes.Fields)+2)
+	for key, value := range attributes.Fields {
+		variables[key] = value
+	}
+	if attributes.Name != "" {
+		variables["name"] = attributes.Name
+	}
+	if attributes.Language != "" {
+		variables["language"] = attributes.Language
+	}
+	return variables
+}
+
+// TemplateVariables returns the attributes of a recipient as template variables, with the
+// variables of the request over them. Groups and recipients without attributes get just the latter.
+func (store *MessageStore) TemplateVariables(recipient string, variables map[string]string) (map[string]string, error) {
+	merged := map[string]string{}
+	if jid, err := contactJID(recipient); err == nil {
+		attributes, err := store.GetContactAttributes(jid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get contact attributes: %v", err)
+		}
+		if attributes != nil {
+			merged = attributes.variables()
+		}
+	}
+	for key, value := range variables {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// scanContactAttributes reads a row of jid, name, language, fields and updated_at
+func scanContactAttributes(row interface{ Scan(...interface{}) error }) (*ContactAttributes, error) {
+	var attributes ContactAttributes
+	var fields string
+	var updatedAt time.Time
+	if err := row.Scan(&attributes.JID, &attributes.Name, &attributes.Language, &fields, &updatedAt); err != nil {
+		return nil, err
+	}
+	attributes.UpdatedAt = &updatedAt
+	attributes.Fields = map[string]string{}
+	if fields != "" {
+		if err := json.Unmarshal([]byte(fields), &attributes.Fields); err != nil {
+			return nil, fmt.Errorf("invalid fields of contact %s: %v", attributes.JID, err)
+		}
+	}
+	return &attributes, nil
+}
+
+// GetContactAttributes returns the attributes of a contact, or nil if none were saved
+func (store *MessageStore) GetContactAttributes(jid string) (*ContactAttributes, error) {
+	query := `SELECT chat_jid, COALESCE(name, ''), COALESCE(language, ''), COALESCE(fields, ''), updated_at
+		FROM contact_attributes WHERE chat_jid = ?`
+	if store.isPostgres {
+		query = `SELECT chat_jid, COALESCE(name, ''), COALESCE(language, ''), COALESCE(fields, ''), updated_at
+		FROM contact_attributes WHERE chat_jid = $1`
+	}
+	attributes, err := scanContactAttributes(store.db.QueryRow(query, jid))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return attributes, err
+}
+
+// ListContactAttributes returns the contacts with attributes by JID, optionally those of one language
+func (store *MessageStore) ListContactAttributes(language string, limit, offset int) ([]ContactAttributes, error) {
+	query := `SELECT chat_jid, COALESCE(name, ''), COALESCE(language, ''), COALESCE(fields, ''), updated_at
+		FROM contact_attributes`
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+	if language != "" {
+		query += " WHERE LOWER(language) = " + arg(strings.ToLower(language))
+	}
+	query += " ORDER BY chat_jid LIMIT " + arg(limit) + " OFFSET " + arg(offset)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ContactAttributes{}
+	for rows.Next() {
+		attributes, err := scanContactAttributes(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *attributes)
+	}
+	return list, rows.Err()
+}
+
+// SaveContactAttributes inserts or replaces the attributes of contacts in one transaction
+func (store *MessageStore) SaveContactAttributes(list ...ContactAttributes) error {
+	query := `INSERT INTO contact_attributes (chat_jid, name, language, fields, updated_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET name = excluded.name, language = excluded.language,
+		fields = excluded.fields, updated_at = excluded.updated_at`
+	if store.isPostgres {
+		query = `INSERT INTO contact_attributes (chat_jid, name, language, fields, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5)
+		ON CONFLICT (chat_jid) DO UPDATE SET name = NULLIF($2, ''), language = NULLIF($3, ''), fields = $4, updated_at = $5`
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, attributes := range list {
+		var fields interface{}
+		if len(attributes.Fields) > 0 {
+			encoded, _ := json.Marshal(attributes.Fields)
+			fields = string(encoded)
+		}
+		if _, err := tx.Exec(query, attributes.JID, attributes.Name, attributes.Language, fields, now); err != nil {
+			return fmt.Errorf("failed to save attributes of %s: %v", attributes.JID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteContactAttributes removes the attributes of a contact and reports whether there were any
+func (store *MessageStore) DeleteContactAttributes(jid string) (bool, error) {
+	query := "DELETE FROM contact_attributes WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM contact_attributes WHERE chat_jid = $1"
+	}
+	result, err := store.db.Exec(query, jid)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete contact attributes: %v", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// parseContactCSV reads an import with a header row. One of the contactIDColumns names the
+// contact, name and language columns set those attributes and any other column is a field.
+func parseContactCSV(body io.Reader) ([]ContactImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %v", err)
+	}
+
+	idColumn := -1
+	for i, column := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if idColumn < 0 && containsString(contactIDColumns, strings.ToLower(header[i])) {
+			idColumn = i
+		}
+	}
+	if idColumn < 0 {
+		return nil, fmt.Errorf("the CSV needs a phone, jid or recipient column")
+	}
+
+	rows := []ContactImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		row := ContactImportRow{Fields: map[string]string{}}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch column := header[i]; {
+			case i == idColumn:
+				row.Recipient = value
+			case strings.EqualFold(column, "name"):
+				row.Name = value
+			case strings.EqualFold(column, "language"):
+				row.Language = value
+			case value != "":
+				row.Fields[column] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importContactAttributes validates the rows of an import and saves the valid ones, merged into
+// the contacts' attributes unless replace is set. Later rows for a contact win.
+func importContactAttributes(messageStore *MessageStore, rows []ContactImportRow, replace bool) (*ContactImportResult, error) {
+	result := &ContactImportResult{Skipped: []ContactImportError{}}
+	imported := map[string]*ContactAttributes{}
+	var order []string
+	for i, row := range rows {
+		skip := func(err error) {
+			result.Skipped = append(result.Skipped, ContactImportError{Row: i + 1, Recipient: row.Recipient, Error: err.Error()})
+		}
+		jid, err := contactJID(row.Recipient)
+		if err != nil {
+			skip(err)
+			continue
+		}
+		update := ContactAttributes{JID: jid, Name: row.Name, Language: row.Language, Fields: row.Fields}
+		if err := update.normalize(); err != nil {
+			skip(err)
+			continue
+		}
+
+		attributes, ok := imported[jid]
+		if !ok {
+			if !replace {
+				if attributes, err = messageStore.GetContactAttributes(jid); err != nil {
+					return nil, fmt.Errorf("failed to get contact attributes: %v", err)
+				}
+			}
+			if attributes == nil {
+				attributes = &ContactAttributes{JID: jid, Fields: map[string]string{}}
+			}
+			order = append(order, jid)
+		}
+		merged := *attributes
+		merged.Fields = make(map[string]string, len(attributes.Fields))
+		for key, value := range attributes.Fields {
+			merged.Fields[key] = value
+		}
+		merged.merge(update)
+		if err := merged.normalize(); err != nil {
+			skip(err)
+			continue
+		}
+		imported[jid] = &merged
+	}
+
+	list := make([]ContactAttributes, 0, len(order))
+	for _, jid := range order {
+		list = append(list, *imported[jid])
+	}
+	if err := messageStore.SaveContactAttributes(list...); err != nil {
+		return nil, err
+	}
+	result.Imported = len(list)
+	return result, nil
+}
+
+// registerContactAttributeRoutes registers /api/v1/contacts/{jid}/attributes, the list at
+// /api/v1/contacts/attributes and imports at /api/v1/contacts/attributes/import
+func registerContactAttributeRoutes(messageStore *MessageStore) {
+	registerContactRoute("attributes", func(w http.ResponseWriter, r *http.Request, contact string) {
+		jid, err := contactJID(contact)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attributes, err := messageStore.GetContactAttributes(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get contact attributes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if attributes == nil {
+			attributes = &ContactAttributes{JID: jid, Fields: map[string]string{}}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the current attributes below
+
+		case http.MethodPut:
+			var req ContactAttributes
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			req.JID = jid
+			attributes = &req
+
+		case http.MethodPatch:
+			var req ContactAttributesUpdate
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.Name != nil {
+				attributes.Name = *req.Name
+			}
+			if req.Language != nil {
+				attributes.Language = *req.Language
+			}
+			for key, value := range req.Fields {
+				if value == nil {
+					delete(attributes.Fields, key)
+				} else {
+					attributes.Fields[key] = *value
+				}
+			}
+
+		case http.MethodDelete:
+			if _, err := messageStore.DeleteContactAttributes(jid); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			if err := attributes.normalize(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := messageStore.SaveContactAttributes(*attributes); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if attributes, err = messageStore.GetContactAttributes(jid); err != nil || attributes == nil {
+				http.Error(w, fmt.Sprintf("Failed to get contact attributes: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if attributes.UpdatedAt != nil {
+			updatedAt := attributes.UpdatedAt.In(loc)
+			attributes.UpdatedAt = &updatedAt
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attributes)
+	})
+
+	handleAPI("/contacts/attributes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		limit, offset := 100, 0
+		if value := query.Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > 1000 {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("offset"); value != "" {
+			if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+				http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+		}
+
+		list, err := messageStore.ListContactAttributes(query.Get("language"), limit, offset)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list contact attributes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range list {
+			if list[i].UpdatedAt != nil {
+				updatedAt := list[i].UpdatedAt.In(loc)
+				list[i].UpdatedAt = &updatedAt
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	handleAPI("/contacts/attributes/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxContactImportBytes)
+
+		var rows []ContactImportRow
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "text/csv" {
+			var err error
+			if rows, err = parseContactCSV(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&rows); err != nil {
+			http.Error(w, "Invalid request format; send a JSON array or text/csv", http.StatusBadRequest)
+			return
+		}
+		if len(rows) == 0 || len(rows) > maxContactImportRows {
+			http.Error(w, fmt.Sprintf("an import must have between 1 and %d rows", maxContactImportRows), http.StatusBadRequest)
+			return
+		}
+
+		result, err := importContactAttributes(messageStore, rows, r.URL.Query().Get("replace") == "true")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to import contact attributes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
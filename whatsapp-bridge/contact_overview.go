@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// contactRecentMedia is how many recent attachments the overview lists
+const contactRecentMedia = 12
+
+// ContactProfile is what WhatsApp tells about a contact
+type ContactProfile struct {
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+	// VerifiedName is the name of a verified business account
+	VerifiedName string `json:"verified_name,omitempty"`
+	// About is the profile's status text, if the contact shares it
+	About string `json:"about,omitempty"`
+}
+
+// SharedGroup is a group both the account and the contact are in
+type SharedGroup struct {
+	JID     string `json:"jid"`
+	Name    string `json:"name"`
+	IsAdmin bool   `json:"is_admin"`
+}
+
+// ContactVolume is the number of messages exchanged with a contact on one day
+type ContactVolume struct {
+	Date     string `json:"date"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+}
+
+// ContactOverview brings together what the bridge knows about a contact, for a contact page
+type ContactOverview struct {
+	JID       string         `json:"jid"`
+	Name      string         `json:"name"`
+	Profile   ContactProfile `json:"profile"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	// SharedGroups is null while WhatsApp is not connected, as membership comes from WhatsApp
+	SharedGroups     []SharedGroup `json:"shared_groups"`
+	MessagesSent     int           `json:"messages_sent"`
+	MessagesReceived int           `json:"messages_received"`
+	// GroupMessages counts the contact's messages in groups
+	GroupMessages  int               `json:"group_messages"`
+	FirstMessageAt *time.Time        `json:"first_message_at,omitempty"`
+	LastMessageAt  *time.Time        `json:"last_message_at,omitempty"`
+	Volume         []ContactVolume   `json:"volume"`
+	Labels         []string          `json:"labels"`
+	Notes          string            `json:"notes"`
+	Metadata       map[string]string `json:"metadata"`
+	Assignment     *ChatAssignment   `json:"assignment,omitempty"`
+	RecentMedia    []APIMessage      `json:"recent_media"`
+}
+
+// joinedGroup is a group with the phone numbers of its members, as cached for the overview
+// and the group list
+type joinedGroup struct {
+	JID              string          `json:"jid"`
+	Name             string          `json:"name"`
+	Members          map[string]bool `json:"members"`
+	Admins           map[string]bool `json:"admins"`
+	ParticipantCount int             `json:"participant_count"`
+	// Announce is set when only admins can send messages
+	Announce bool `json:"announce"`
+	// SelfAdmin is set when the account is an admin of the group
+	SelfAdmin bool `json:"self_admin"`
+}
+
+// getJoinedGroups lists the account's groups and their members. Groups with hidden
+// phone numbers only list members by LID, so the contact can be missing from them.
+func getJoinedGroups(client *whatsmeow.Client) ([]joinedGroup, error) {
+	return cached(cacheKeyJoinedGroups, cacheTTL, func() ([]joinedGroup, error) {
+		return loadJoinedGroups(client)
+	})
+}
+
+// loadJoinedGroups asks WhatsApp for the account's groups, bypassing the cache
+func loadJoinedGroups(client *whatsmeow.Client) ([]joinedGroup, error) {
+	groups, err := client.GetJoinedGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	joined := make([]joinedGroup, 0, len(groups))
+	for _, group := range groups {
+		entry := joinedGroup{
+			JID:              group.JID.String(),
+			Name:             group.Name,
+			Members:          map[string]bool{},
+			Admins:           map[string]bool{},
+			ParticipantCount: len(group.Participants),
+			Announce:         group.IsAnnounce,
+		}
+		for _, participant := range group.Participants {
+			for _, jid := range []types.JID{participant.JID, participant.PhoneNumber, participant.LID} {
+				if jid.Server == types.DefaultUserServer {
+					entry.Members[jid.User] = true
+					entry.Admins[jid.User] = participant.IsAdmin || participant.IsSuperAdmin
+				}
+				// Groups with hidden phone numbers list the account by its LID
+				if jid.User != "" && isOwnUser(client, jid.User) && (participant.IsAdmin || participant.IsSuperAdmin) {
+					entry.SelfAdmin = true
+				}
+			}
+		}
+		joined = append(joined, entry)
+	}
+	return joined, nil
+}
+
+// getContactProfile reads the contact store and, when connected, the profile's status text
+func getContactProfile(client *whatsmeow.Client, jid types.JID) ContactProfile {
+	var profile ContactProfile
+	if contact, err := client.Store.Contacts.GetContact(context.Background(), jid); err == nil && contact.Found {
+		profile.FullName, profile.PushName, profile.BusinessName = contact.FullName, contact.PushName, contact.BusinessName
+	}
+	if !client.IsConnected() {
+		return profile
+	}
+
+	info, err := cached(cacheKeyContactInfo+jid.String(), contactCacheTTL, func() (ContactProfile, error) {
+		var info ContactProfile
+		users, err := client.GetUserInfo([]types.JID{jid})
+		if err != nil {
+			return info, err
+		}
+		if user, ok := users[jid]; ok {
+			info.About = user.Status
+			if user.VerifiedName != nil && user.VerifiedName.Details != nil {
+				info.VerifiedName = user.VerifiedName.Details.GetVerifiedName()
+			}
+		}
+		return info, nil
+	})
+	if err == nil {
+		profile.About, profile.VerifiedName = info.About, info.VerifiedName
+	}
+	return profile
+}
+
+// fillContactHistory counts the messages exchanged with a contact and their daily volume since since
+func (store *MessageStore) fillContactHistory(overview *ContactOverview, user string, since time.Time, loc *time.Location) error {
+	arg := func(n int) string {
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", n)
+		}
+		return "?"
+	}
+
+	// Totals of the personal chat
+	rows, err := store.db.Query(fmt.Sprintf("SELECT is_from_me, COUNT(*) FROM messages WHERE chat_jid = %s AND system_event IS NULL GROUP BY is_from_me", arg(1)), overview.JID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var fromMe bool
+		var count int
+		if err := rows.Scan(&fromMe, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		if fromMe {
+			overview.MessagesSent = count
+		} else {
+			overview.MessagesReceived = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Aggregates of timestamps come back as text from SQLite, so read the ends with ORDER BY
+	for _, order := range []string{"ASC", "DESC"} {
+		var at time.Time
+		err := store.db.QueryRow(fmt.Sprintf("SELECT timestamp FROM messages WHERE chat_jid = %s AND system_event IS NULL ORDER BY timestamp %s LIMIT 1", arg(1), order), overview.JID).Scan(&at)
+		if err != nil {
+			continue
+		}
+		at = at.In(loc)
+		if order == "ASC" {
+			overview.FirstMessageAt = &at
+		} else {
+			overview.LastMessageAt = &at
+		}
+	}
+
+	// Older rows store the sender's full JID rather than the phone number
+	groupQuery := fmt.Sprintf("SELECT COUNT(*) FROM messages WHERE (sender = %s OR sender = %s) AND chat_jid LIKE '%%@g.us' AND system_event IS NULL", arg(1), arg(2))
+	if err := store.db.QueryRow(groupQuery, user, overview.JID).Scan(&overview.GroupMessages); err != nil {
+		return err
+	}
+
+	// One entry per day, including quiet days, so the series can be drawn as is
+	days := map[string]*ContactVolume{}
+	start := time.Date(since.In(loc).Year(), since.In(loc).Month(), since.In(loc).Day(), 0, 0, 0, 0, loc)
+	for day := start; !day.After(time.Now().In(loc)); day = day.AddDate(0, 0, 1) {
+		overview.Volume = append(overview.Volume, ContactVolume{Date: day.Format("2006-01-02")})
+	}
+	for i := range overview.Volume {
+		days[overview.Volume[i].Date] = &overview.Volume[i]
+	}
+
+	rows, err = store.db.Query(fmt.Sprintf("SELECT timestamp, is_from_me FROM messages WHERE chat_jid = %s AND timestamp >= %s AND system_event IS NULL", arg(1), arg(2)), overview.JID, start.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at time.Time
+		var fromMe bool
+		if err := rows.Scan(&at, &fromMe); err != nil {
+			return err
+		}
+		if day, ok := days[at.In(loc).Format("2006-01-02")]; ok {
+			if fromMe {
+				day.Sent++
+			} else {
+				day.Received++
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// recentMedia returns the latest attachments of a chat, newest first
+func (store *MessageStore) recentMedia(chatJID string, limit int) ([]APIMessage, error) {
+	query := "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, media_type, COALESCE(filename, '') FROM messages WHERE chat_jid = ? AND COALESCE(media_type, '') <> '' ORDER BY timestamp DESC LIMIT ?"
+	if store.isPostgres {
+		query = "SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, media_type, COALESCE(filename, '') FROM messages WHERE chat_jid = $1 AND COALESCE(media_type, '') <> '' ORDER BY timestamp DESC LIMIT $2"
+	}
+
+	rows, err := store.db.Query(query, chatJID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []APIMessage{}
+	for rows.Next() {
+		var msg APIMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename); err != nil {
+			return nil, err
+		}
+		media = append(media, msg)
+	}
+	return media, rows.Err()
+}
+
+// registerContactOverviewRoutes registers /api/v1/contacts/{jid}/overview?days=...
+func registerContactOverviewRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	registerContactRoute("overview", func(w http.ResponseWriter, r *http.Request, contactJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jid, err := types.ParseJID(contactJID)
+		if err != nil || jid.Server != types.DefaultUserServer {
+			http.Error(w, "Invalid contact JID", http.StatusBadRequest)
+			return
+		}
+		days := 90
+		if value := r.URL.Query().Get("days"); value != "" {
+			days, err = strconv.Atoi(value)
+			if err != nil || days < 1 || days > 365 {
+				http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+				return
+			}
+		}
+		legalHolds.RecordRequest(r, jid.String())
+
+		overview := &ContactOverview{
+			JID:     jid.String(),
+			Name:    messageStore.getChatDisplayName(jid.String()),
+			Profile: getContactProfile(client, jid),
+		}
+		if overview.Name == overview.JID && overview.Profile.FullName != "" {
+			overview.Name = overview.Profile.FullName
+		}
+
+		// WhatsApp lookups are best effort; the stored history is the core of the page
+		if client.IsConnected() {
+			if avatar, err := getAvatar(client, jid); err == nil {
+				overview.AvatarURL = avatar.URL
+			}
+			if groups, err := getJoinedGroups(client); err == nil {
+				overview.SharedGroups = []SharedGroup{}
+				for _, group := range groups {
+					if group.Members[jid.User] {
+						overview.SharedGroups = append(overview.SharedGroups, SharedGroup{JID: group.JID, Name: group.Name, IsAdmin: group.Admins[jid.User]})
+					}
+				}
+			}
+		}
+
+		since := time.Now().AddDate(0, 0, 1-days)
+		if err := messageStore.fillContactHistory(overview, jid.User, since, loc); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message history: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if overview.Labels, err = messageStore.GetChatLabels(overview.JID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get labels: %v", err), http.StatusInternalServerError)
+			return
+		}
+		metadata, err := messageStore.GetChatMetadata(overview.JID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get metadata: %v", err), http.StatusInternalServerError)
+			return
+		}
+		overview.Notes, overview.Metadata = metadata.Notes, metadata.Metadata
+		if overview.Assignment, err = messageStore.GetAssignment(overview.JID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get assignment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if overview.Assignment != nil {
+			overview.Assignment.localize(loc)
+		}
+		if overview.RecentMedia, err = messageStore.recentMedia(overview.JID, contactRecentMedia); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get media: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range overview.RecentMedia {
+			overview.RecentMedia[i].Timestamp = overview.RecentMedia[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(overview)
+	})
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"net/http"
+)
+
+// ServeContactPage serves the page of one contact (?jid=...), a thin client of /api/v1/contacts/{jid}/overview
+func ServeContactPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	pageTemplates.Render(w, "contact", nil)
+}
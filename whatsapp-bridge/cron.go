@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands accepted in place of the five fields
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronMonths and cronWeekdays are the names accepted in the month and day-of-week fields
+var (
+	cronMonths = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	cronWeekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronHorizon is how far ahead Next looks before deciding an expression never matches, e.g. 30 February
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// CronSchedule is a parsed five-field cron expression: minute, hour, day of month, month and day of week.
+// Each field holds the set of values it matches as bits.
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// As in cron, when both day fields are restricted a day matching either one counts
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCron reads a cron expression such as "0 9 * * mon-fri" or "@monthly"
+func parseCron(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron must have five fields (minute hour day-of-month month day-of-week)")
+	}
+
+	var schedule CronSchedule
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute: %v", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour: %v", err)
+	}
+	if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month: %v", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("invalid month: %v", err)
+	}
+	// 7 is Sunday too
+	if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("invalid day of week: %v", err)
+	}
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return &schedule, nil
+}
+
+// parseCronField reads a comma-separated list of values, ranges (a-b) and steps (*/n, a-b/n)
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = min, max
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = value(from); err != nil {
+				return 0, err
+			}
+			if high, err = value(to); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("range %q runs backwards", rangePart)
+			}
+		default:
+			n, err := value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			// a/n runs from a to the end, as in most crons
+			low, high = n, n
+			if hasStep {
+				high = max
+			}
+		}
+		for n := low; n <= high; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay reports whether the day fields allow a day
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time after after that the expression matches on the clock of loc, or the zero
+// time if it never does. A clock time that a daylight saving change skips doesn't occur that day.
+func (c *CronSchedule) Next(after time.Time, loc *time.Location) time.Time {
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronHorizon)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case c.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
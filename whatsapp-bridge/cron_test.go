@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronRejects(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"", "five fields"},
+		{"0 9 * *", "five fields"},
+		{"0 0 9 * * *", "five fields"},
+		{"@every 5m", "five fields"},
+		{"60 * * * *", "invalid minute"},
+		{"* 24 * * *", "invalid hour"},
+		{"* * 0 * *", "invalid day of month"},
+		{"* * 32 * *", "invalid day of month"},
+		{"* * * 13 *", "invalid month"},
+		{"* * * foo *", "invalid month"},
+		{"* * * * 8", "invalid day of week"},
+		{"* * * * mon-", "invalid day of week"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"30-10 * * * *", "runs backwards"},
+		{"1,,2 * * * *", "invalid minute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			if _, err := parseCron(tt.expression); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	tests := []struct {
+		name       string
+		expression string
+		loc        *time.Location
+		after      time.Time
+		want       time.Time
+	}{
+		{"weekdays skip the weekend", "0 9 * * mon-fri", time.UTC, utc(2025, 3, 7, 10, 0), utc(2025, 3, 10, 9, 0)},
+		{"names are case-insensitive", "0 9 * MAR Mon", time.UTC, utc(2025, 3, 4, 0, 0), utc(2025, 3, 10, 9, 0)},
+		{"step", "*/15 * * * *", time.UTC, utc(2025, 3, 7, 10, 7).Add(30 * time.Second), utc(2025, 3, 7, 10, 15)},
+		{"step from a value", "5/20 * * * *", time.UTC, utc(2025, 3, 7, 10, 26), utc(2025, 3, 7, 10, 45)},
+		{"list and range", "0 8,12-13 * * *", time.UTC, utc(2025, 3, 7, 8, 0), utc(2025, 3, 7, 12, 0)},
+		{"strictly after", "0 9 * * *", time.UTC, utc(2025, 3, 7, 9, 0), utc(2025, 3, 8, 9, 0)},
+		{"macro", "@Monthly", time.UTC, utc(2025, 12, 15, 0, 0), utc(2026, 1, 1, 0, 0)},
+		{"month without the day", "0 0 31 * *", time.UTC, utc(2025, 4, 1, 0, 0), utc(2025, 5, 31, 0, 0)},
+		{"leap day", "0 0 29 2 *", time.UTC, utc(2025, 3, 1, 0, 0), utc(2028, 2, 29, 0, 0)},
+		{"never", "0 0 30 2 *", time.UTC, utc(2025, 3, 1, 0, 0), time.Time{}},
+		{"7 is Sunday", "0 8 * * 7", time.UTC, utc(2025, 3, 3, 0, 0), utc(2025, 3, 9, 8, 0)},
+		// 3 March 2025 is a Monday, 1 April a Tuesday
+		{"either restricted day field", "0 12 1 * mon", time.UTC, utc(2025, 3, 1, 13, 0), utc(2025, 3, 3, 12, 0)},
+		{"starred day field still restricts", "0 12 1 * */2", time.UTC, utc(2025, 3, 1, 13, 0), utc(2025, 4, 1, 12, 0)},
+		{"local clock", "0 9 * * *", time.FixedZone("UTC+2", 2*60*60), utc(2025, 3, 3, 8, 0), utc(2025, 3, 4, 7, 0)},
+		{"summer time", "0 9 * * *", london, utc(2025, 6, 1, 12, 0), utc(2025, 6, 2, 8, 0)},
+		// Clocks go forward from 01:00 to 02:00 on 30 March 2025
+		{"skipped by summer time", "30 1 * * *", london, utc(2025, 3, 29, 12, 0), utc(2025, 3, 31, 0, 30)},
+		{"after the change", "30 2 * * *", london, utc(2025, 3, 29, 12, 0), utc(2025, 3, 30, 1, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(tt.after, tt.loc); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, got.UTC(), tt.want)
+			}
+		})
+	}
+}
@@ -22,6 +22,12 @@ func init() {
 		// Don't fail if .env file doesn't exist, just log it
 		log.Printf("No .env file found or error loading it: %v", err)
 	}
+
+	// Then the config file in the data directory, which DATA_DIR above may have moved
+	initDataDir()
+	if err := godotenv.Load(dataPath(configFileName)); err == nil {
+		log.Printf("Loaded configuration from %s", dataPath(configFileName))
+	}
 }
 
 // DatabaseAdapter handles connections to either PostgreSQL or SQLite
@@ -71,7 +77,7 @@ func (a *DatabaseAdapter) connectPostgreSQL() (*sqlstore.Container, error) {
 	a.logger.Infof("Connecting to PostgreSQL at %s", sanitizeConnectionURL(dbURL))
 	
 	// Open a direct connection to the database
-	db, err := sql.Open("postgres", dbURL)
+	db, err := sql.Open(sqlDriverName("postgres"), dbURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -161,15 +167,15 @@ func (a *DatabaseAdapter) checkAndUpdateSchema(db *sql.DB) error {
 // connectSQLite creates a SQLite connection as fallback
 func (a *DatabaseAdapter) connectSQLite() (*sqlstore.Container, error) {
 	// Create directory for SQLite database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
-		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	if err := ensureDataDir(); err != nil {
+		return nil, err
 	}
 	
 	// Connect to SQLite database
 	a.logger.Infof("Connecting to SQLite database")
 	
 	// Create a new container with the SQLite connection
-	container, err := sqlstore.New(context.Background(), "sqlite3", "file:store/whatsmeow.db?_foreign_keys=on", a.logger)
+	container, err := sqlstore.New(context.Background(), "sqlite3", sqliteDSN("whatsmeow.db"), a.logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SQLite database container: %v", err)
 	}
@@ -237,7 +243,7 @@ func (a *DatabaseAdapter) GetConnectionInfo() map[string]string {
 	} else {
 		// SQLite connection
 		info["type"] = "SQLite"
-		info["path"] = "store/whatsmeow.db"
+		info["path"] = dataPath("whatsmeow.db")
 	}
 	
 	return info
@@ -383,7 +389,7 @@ func (a *DatabaseAdapter) GetDB() (*sql.DB, error) {
 		return nil, fmt.Errorf("database URL is not set")
 	}
 	
-	db, err := sql.Open("postgres", a.dbURL)
+	db, err := sql.Open(sqlDriverName("postgres"), a.dbURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
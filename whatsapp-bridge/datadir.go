@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dataDir holds all runtime state: SQLite databases, downloaded media, uploads,
+// quarantine, the config file and logs. Set DATA_DIR to move it, e.g. onto a mounted volume.
+var dataDir = "store"
+
+// configFileName is the config file inside the data directory, written by the setup wizard
+const configFileName = "config.env"
+
+// initDataDir applies DATA_DIR; it runs after .env is loaded so the variable can be set there too
+func initDataDir() {
+	if dir := strings.TrimSpace(os.Getenv("DATA_DIR")); dir != "" {
+		dataDir = dir
+	}
+}
+
+// dataPath returns a path inside the data directory
+func dataPath(elem ...string) string {
+	return filepath.Join(append([]string{dataDir}, elem...)...)
+}
+
+// ensureDataDir creates the data directory if it doesn't exist
+func ensureDataDir() error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory %s: %v", dataDir, err)
+	}
+	return nil
+}
+
+// sqliteDSN returns the connection string for a SQLite database in the data directory
+func sqliteDSN(name string) string {
+	return "file:" + dataPath(name) + "?_foreign_keys=on"
+}
+
+// startFileLogging copies everything written to stdout and stderr into the recent lines kept for
+// diagnostic bundles, and into logs/bridge.log in the data directory when LOG_TO_FILE is enabled,
+// so logs survive with the volume
+func startFileLogging() error {
+	recentLogs = newLogTail(getEnvInt("DIAGNOSTICS_LOG_LINES", 1000))
+	copies := []io.Writer{recentLogs}
+
+	if getEnvBool("LOG_TO_FILE", false) {
+		if err := os.MkdirAll(dataPath("logs"), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+		file, err := os.OpenFile(dataPath("logs", "bridge.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		copies = append(copies, file)
+	}
+
+	// Swap the process output for pipes and copy each one to the original stream and the file
+	tee := func(target **os.File) error {
+		original := *target
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		*target = writer
+		go io.Copy(io.MultiWriter(append([]io.Writer{original}, copies...)...), reader)
+		return nil
+	}
+	if err := tee(&os.Stdout); err != nil {
+		return fmt.Errorf("failed to capture stdout: %v", err)
+	}
+	if err := tee(&os.Stderr); err != nil {
+		return fmt.Errorf("failed to capture stderr: %v", err)
+	}
+	log.SetOutput(os.Stderr)
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// States a send can wait for with ?wait_for=
+const (
+	SendStateServerAck = "server_ack"
+	SendStateDelivered = "delivered"
+)
+
+// Limits of ?timeout= on sends that wait for delivery, in seconds
+const (
+	defaultDeliveryWait = 30
+	maxDeliveryWait     = 120
+)
+
+// deliveryMemory is how long delivery receipts are remembered for sends that start waiting late
+const deliveryMemory = 5 * time.Minute
+
+// DeliveryTracker lets sends wait for the first delivery receipt of their message
+type DeliveryTracker struct {
+	delivered map[string]time.Time
+	waiting   map[string][]chan struct{}
+	mutex     sync.Mutex
+}
+
+// deliveryTracker is the process-wide delivery state
+var deliveryTracker = &DeliveryTracker{
+	delivered: make(map[string]time.Time),
+	waiting:   make(map[string][]chan struct{}),
+}
+
+// Wait blocks until a message is delivered or the timeout passes, and reports whether it was delivered.
+// Receipts can beat the send response, so recent ones count too.
+func (t *DeliveryTracker) Wait(messageID string, timeout time.Duration) bool {
+	t.mutex.Lock()
+	if _, ok := t.delivered[messageID]; ok {
+		t.mutex.Unlock()
+		return true
+	}
+	delivered := make(chan struct{})
+	t.waiting[messageID] = append(t.waiting[messageID], delivered)
+	t.mutex.Unlock()
+
+	select {
+	case <-delivered:
+		return true
+	case <-time.After(timeout):
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		// Drop this waiter; the receipt may have arrived just now
+		waiters := t.waiting[messageID]
+		for i, waiter := range waiters {
+			if waiter == delivered {
+				t.waiting[messageID] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(t.waiting[messageID]) == 0 {
+			delete(t.waiting, messageID)
+		}
+		_, ok := t.delivered[messageID]
+		return ok
+	}
+}
+
+// handleDeliveryReceipt wakes sends waiting for the messages a recipient's receipt covers, and
+// publishes the receipt. Read and played receipts imply delivery; receipts from our own devices don't count.
+func handleDeliveryReceipt(evt *events.Receipt, account string) {
+	switch evt.Type {
+	case types.ReceiptTypeDelivered, types.ReceiptTypeRead, types.ReceiptTypePlayed:
+	default:
+		return
+	}
+	if evt.IsFromMe {
+		return
+	}
+
+	eventType := EventMessageDelivered
+	if evt.Type != types.ReceiptTypeDelivered {
+		eventType = EventMessageRead
+	}
+	publishEvent(eventType, evt.Chat.String(), evt.Timestamp, withAccount(account, map[string]interface{}{
+		"message_ids": evt.MessageIDs,
+		"sender":      evt.Sender.String(),
+		"played":      evt.Type == types.ReceiptTypePlayed,
+	}))
+
+	now := time.Now()
+	deliveryTracker.mutex.Lock()
+	defer deliveryTracker.mutex.Unlock()
+	for id, at := range deliveryTracker.delivered {
+		if now.Sub(at) > deliveryMemory {
+			delete(deliveryTracker.delivered, id)
+		}
+	}
+	for _, id := range evt.MessageIDs {
+		deliveryTracker.delivered[id] = now
+		for _, delivered := range deliveryTracker.waiting[id] {
+			close(delivered)
+		}
+		delete(deliveryTracker.waiting, id)
+	}
+}
+
+// parseDeliveryWait reads ?wait_for= and ?timeout= of a send request
+func parseDeliveryWait(r *http.Request) (string, time.Duration, error) {
+	waitFor := r.URL.Query().Get("wait_for")
+	switch waitFor {
+	case "", SendStateServerAck, SendStateDelivered:
+	default:
+		return "", 0, fmt.Errorf("wait_for must be %s or %s", SendStateServerAck, SendStateDelivered)
+	}
+
+	seconds := defaultDeliveryWait
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if seconds, err = strconv.Atoi(value); err != nil || seconds < 1 || seconds > maxDeliveryWait {
+			return "", 0, fmt.Errorf("timeout must be between 1 and %d seconds", maxDeliveryWait)
+		}
+	}
+	return waitFor, time.Duration(seconds) * time.Second, nil
+}
+
+// awaitSendState waits for a sent message to reach the requested state and fills in the response.
+// It returns the status code: 202 if delivery wasn't confirmed before the timeout.
+func awaitSendState(resp *SendMessageResponse, waitFor string, timeout time.Duration) int {
+	// WhatsApp has acknowledged every message that was sent successfully
+	resp.State = SendStateServerAck
+	if waitFor != SendStateDelivered {
+		return http.StatusOK
+	}
+	if deliveryTracker.Wait(resp.MessageID, timeout) {
+		resp.State = SendStateDelivered
+		return http.StatusOK
+	}
+	resp.TimedOut = true
+	return http.StatusAccepted
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// envExample documents the configuration; its variable names decide what goes into a diagnostic bundle
+//
+//go:embed .env.example
+var envExample string
+
+// processStart is when the bridge started, for the uptime in diagnostic bundles
+var processStart = time.Now()
+
+// secretVariable matches configuration names whose values are never put into a diagnostic bundle
+var secretVariable = regexp.MustCompile(`KEY|SECRET|TOKEN|PASSWORD|PASS$|SALT|CREDENTIAL|AUTH`)
+
+// phoneNumber matches phone numbers and the user part of phone JIDs in free text
+var phoneNumber = regexp.MustCompile(`\+?\b\d{7,15}\b`)
+
+// loggedBodies match the log lines that print message text, capturing the text
+var loggedBodies = []*regexp.Regexp{
+	regexp.MustCompile(`^(\[\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\] [←→] [^:]+: (?:\[[^\]]*\] )?)(.+)$`),
+	regexp.MustCompile(`^(Stored outbound message in database: )(.+)$`),
+}
+
+// logTail keeps the last lines written to stdout and stderr for diagnostic bundles
+type logTail struct {
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+	mutex   sync.Mutex
+}
+
+// recentLogs is fed by startFileLogging; sized by DIAGNOSTICS_LOG_LINES
+var recentLogs = newLogTail(1000)
+
+func newLogTail(size int) *logTail {
+	if size < 1 {
+		size = 1
+	}
+	return &logTail{lines: make([]string, size)}
+}
+
+// Write splits output into lines, holding back an unfinished last line
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	data := append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		t.lines[t.next] = strings.TrimRight(string(data[:i]), "\r")
+		t.next = (t.next + 1) % len(t.lines)
+		if t.next == 0 {
+			t.full = true
+		}
+		data = data[i+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Lines returns the kept lines, oldest first
+func (t *logTail) Lines() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.full {
+		return append([]string(nil), t.lines[:t.next]...)
+	}
+	return append(append([]string(nil), t.lines[t.next:]...), t.lines[:t.next]...)
+}
+
+// diagnosticRedactor hashes phone numbers in a bundle, whatever the log redaction settings
+func diagnosticRedactor() *Redactor {
+	return &Redactor{phones: RedactPhonesHash, bodies: true, salt: os.Getenv("PRIVACY_HASH_SALT")}
+}
+
+// diagnosticConfig returns the set variables that are documented in .env.example, or share the
+// first word of one (e.g. SUPABASE_URL), with secrets masked and URLs cut down to their host
+func diagnosticConfig(redactor *Redactor) map[string]string {
+	documented := map[string]bool{}
+	prefixes := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(envExample))
+	for scanner.Scan() {
+		name, _, found := strings.Cut(scanner.Text(), "=")
+		if !found || strings.HasPrefix(name, "#") || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		documented[name] = true
+		prefix, _, _ := strings.Cut(name, "_")
+		prefixes[prefix] = true
+	}
+
+	config := map[string]string{}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		prefix, _, _ := strings.Cut(name, "_")
+		if value == "" || (!documented[name] && !prefixes[prefix]) {
+			continue
+		}
+		config[name] = maskConfigValue(name, value, redactor)
+	}
+	return config
+}
+
+// maskConfigValue hides the parts of a setting that could give access to something
+func maskConfigValue(name, value string, redactor *Redactor) string {
+	if secretVariable.MatchString(strings.ToUpper(name)) {
+		return "[set]"
+	}
+	// Webhook URLs often carry a token in their path or query, and database URLs a password
+	if parsed, err := url.Parse(value); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		masked := parsed.Scheme + "://" + parsed.Host
+		if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" {
+			masked += "/[redacted]"
+		}
+		return masked
+	}
+	return phoneNumber.ReplaceAllStringFunc(value, redactor.Phone)
+}
+
+// redactLogLine hides phone numbers, message text and configured secrets in a log line
+func redactLogLine(line string, redactor *Redactor, secrets []string) string {
+	for _, secret := range secrets {
+		line = strings.ReplaceAll(line, secret, "[secret]")
+	}
+	for _, pattern := range loggedBodies {
+		if match := pattern.FindStringSubmatch(line); match != nil && !strings.HasPrefix(match[2], "[redacted ") {
+			line = match[1] + redactor.Body(match[2])
+			break
+		}
+	}
+	return phoneNumber.ReplaceAllStringFunc(line, redactor.Phone)
+}
+
+// configSecrets returns the values of secret settings that are long enough to be told apart in logs
+func configSecrets() []string {
+	var secrets []string
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if len(value) >= 6 && secretVariable.MatchString(strings.ToUpper(name)) {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+// DiagnosticTableStats is the size of one table in a diagnostic bundle
+type DiagnosticTableStats struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+	Error string `json:"error,omitempty"`
+}
+
+// diagnosticTables counts the rows of the message store's tables
+func (store *MessageStore) diagnosticTables() []DiagnosticTableStats {
+	tables := []string{"messages", "chats"}
+	for _, table := range bridgeTables {
+		// Indexes share the list, named after what they cover
+		if !strings.Contains(table.name, " ") {
+			tables = append(tables, table.name)
+		}
+	}
+
+	stats := make([]DiagnosticTableStats, 0, len(tables))
+	for _, table := range tables {
+		stat := DiagnosticTableStats{Table: table}
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&stat.Rows); err != nil {
+			stat.Error = err.Error()
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// diagnosticVersions describes the build and the process
+func diagnosticVersions() map[string]interface{} {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	build := readBuildInfo()
+	return map[string]interface{}{
+		"version":          build.Version,
+		"revision":         build.Commit,
+		"time":             build.CommitTime,
+		"modified":         build.Modified,
+		"whatsmeow":        build.WhatsmeowVersion,
+		"go":               build.GoVersion,
+		"os":               build.OS,
+		"arch":             build.Arch,
+		"started_at":       processStart.UTC(),
+		"uptime_seconds":   int64(time.Since(processStart).Seconds()),
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc_bytes": memory.HeapAlloc,
+	}
+}
+
+// writeDiagnosticBundle writes the zip archive of /api/v1/admin/diagnostics
+func writeDiagnosticBundle(w *zip.Writer, client *whatsmeow.Client, messageStore *MessageStore, logLines int) error {
+	redactor := diagnosticRedactor()
+	add := func(name string, value interface{}) error {
+		file, err := w.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	if err := add("versions.json", diagnosticVersions()); err != nil {
+		return err
+	}
+	if err := add("config.json", diagnosticConfig(redactor)); err != nil {
+		return err
+	}
+
+	state := map[string]interface{}{
+		"connected":    client.IsConnected(),
+		"logged_in":    client.Store.ID != nil,
+		"role":         replicaRole(),
+		"read_only":    readOnlyMode,
+		"receive_only": receiveOnlyMode,
+		"maintenance":  maintenance.Status(),
+		"storage":      storageMonitor.Status(),
+		"log_level":    logLevel.State(),
+	}
+	if sessionGuard != nil {
+		state["session_lock"] = sessionGuard.Current()
+	}
+	events, err := messageStore.ListEvents(EventFilter{Types: []string{"connection.*", "session.*", "maintenance.*", "storage.*"}, Limit: 200})
+	if err != nil {
+		state["history_error"] = err.Error()
+	} else {
+		state["history"] = events
+	}
+	if err := add("connection.json", state); err != nil {
+		return err
+	}
+
+	driver := "sqlite"
+	if messageStore.isPostgres {
+		driver = "postgres"
+	}
+	databaseBytes, err := messageStore.databaseSize()
+	database := map[string]interface{}{
+		"driver":     driver,
+		"size_bytes": databaseBytes,
+		"tables":     messageStore.diagnosticTables(),
+	}
+	if err != nil {
+		database["size_error"] = err.Error()
+	}
+	if err := add("database.json", database); err != nil {
+		return err
+	}
+
+	file, err := w.Create("logs.txt")
+	if err != nil {
+		return err
+	}
+	lines := recentLogs.Lines()
+	if len(lines) > logLines {
+		lines = lines[len(lines)-logLines:]
+	}
+	secrets := configSecrets()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(file, redactLogLine(line, redactor, secrets)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerDiagnosticsRoutes registers /api/v1/admin/diagnostics
+func registerDiagnosticsRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	handleAPI("/admin/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		logLines := len(recentLogs.lines)
+		if value := r.URL.Query().Get("log_lines"); value != "" {
+			var err error
+			if logLines, err = strconv.Atoi(value); err != nil || logLines < 0 {
+				http.Error(w, "log_lines must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Built in memory so a failure is a clean error rather than a truncated download
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		if err := writeDiagnosticBundle(archive, client, messageStore, logLines); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build diagnostic bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := archive.Close(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build diagnostic bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		filename := fmt.Sprintf("whatsapp-bridge-diagnostics-%s.zip", time.Now().UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf.Bytes())
+	})
+}
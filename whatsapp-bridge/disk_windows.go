@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// diskSpace is not implemented on Windows; storage health reports sizes without free space
+func diskSpace(path string) (free uint64, total uint64, err error) {
+	return 0, 0, fmt.Errorf("free disk space is not available on Windows")
+}
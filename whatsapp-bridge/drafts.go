@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Draft is a half-written reply saved for a chat
+type Draft struct {
+	ChatJID   string    `json:"chat_jid"`
+	Content   string    `json:"content"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveDraftRequest represents the request body for saving a draft
+type SaveDraftRequest struct {
+	Content   string `json:"content"`
+	UpdatedBy string `json:"updated_by"`
+}
+
+// GetDraft returns the draft for a chat, or nil if there is none
+func (store *MessageStore) GetDraft(chatJID string) (*Draft, error) {
+	var query string
+	if store.isPostgres {
+		query = "SELECT chat_jid, content, updated_by, updated_at FROM drafts WHERE chat_jid = $1"
+	} else {
+		query = "SELECT chat_jid, content, updated_by, updated_at FROM drafts WHERE chat_jid = ?"
+	}
+
+	var draft Draft
+	var updatedBy sql.NullString
+	err := store.db.QueryRow(query, chatJID).Scan(&draft.ChatJID, &draft.Content, &updatedBy, &draft.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	draft.UpdatedBy = updatedBy.String
+
+	return &draft, nil
+}
+
+// SaveDraft stores or replaces the draft for a chat
+func (store *MessageStore) SaveDraft(draft Draft) error {
+	var query string
+	if store.isPostgres {
+		query = "INSERT INTO drafts (chat_jid, content, updated_by, updated_at) VALUES ($1, $2, $3, $4) ON CONFLICT (chat_jid) DO UPDATE SET content = $2, updated_by = $3, updated_at = $4"
+	} else {
+		query = "INSERT OR REPLACE INTO drafts (chat_jid, content, updated_by, updated_at) VALUES (?, ?, ?, ?)"
+	}
+
+	_, err := store.db.Exec(query, draft.ChatJID, draft.Content, draft.UpdatedBy, draft.UpdatedAt)
+	return err
+}
+
+// DeleteDraft removes the draft for a chat
+func (store *MessageStore) DeleteDraft(chatJID string) error {
+	var query string
+	if store.isPostgres {
+		query = "DELETE FROM drafts WHERE chat_jid = $1"
+	} else {
+		query = "DELETE FROM drafts WHERE chat_jid = ?"
+	}
+
+	_, err := store.db.Exec(query, chatJID)
+	return err
+}
+
+// registerDraftRoutes registers /api/v1/chats/{jid}/draft
+func registerDraftRoutes(messageStore *MessageStore) {
+	registerChatRoute("draft", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			draft, err := messageStore.GetDraft(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get draft: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if draft == nil {
+				http.Error(w, "No draft for this chat", http.StatusNotFound)
+				return
+			}
+			draft.UpdatedAt = draft.UpdatedAt.In(loc)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(draft)
+
+		case http.MethodPut:
+			var req SaveDraftRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			// Saving an empty draft is the same as clearing it
+			if req.Content == "" {
+				if err := messageStore.DeleteDraft(chatJID); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to delete draft: %v", err), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			draft := Draft{
+				ChatJID:   chatJID,
+				Content:   req.Content,
+				UpdatedBy: req.UpdatedBy,
+				UpdatedAt: time.Now().UTC(),
+			}
+			if err := messageStore.SaveDraft(draft); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save draft: %v", err), http.StatusInternalServerError)
+				return
+			}
+			draft.UpdatedAt = draft.UpdatedAt.In(loc)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(draft)
+
+		case http.MethodDelete:
+			if err := messageStore.DeleteDraft(chatJID); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to delete draft: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
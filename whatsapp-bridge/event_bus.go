@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus and delivered to webhooks
+const (
+	EventMessageReceived           = "message.received"
+	EventMessageSent               = "message.sent"
+	EventMessageFailed             = "message.failed"
+	EventMessageReaction           = "message.reaction"
+	EventMessageRevoked            = "message.revoked"
+	EventMessageDelivered          = "message.delivered"
+	EventMessageRead               = "message.read"
+	EventMessageStatus             = "message.status"
+	EventGroupParticipantsAdded    = "group.participants_added"
+	EventGroupParticipantsRemoved  = "group.participants_removed"
+	EventGroupParticipantsPromoted = "group.participants_promoted"
+	EventGroupParticipantsDemoted  = "group.participants_demoted"
+	EventGroupSubjectChanged       = "group.subject_changed"
+	EventGroupDescriptionChanged   = "group.description_changed"
+	EventGroupIconChanged          = "group.icon_changed"
+	EventGroupSettingsChanged      = "group.settings_changed"
+	EventGroupModeration           = "group.moderation"
+	EventContactPushNameChanged    = "contact.push_name_changed"
+	EventContactPictureChanged     = "contact.picture_changed"
+	EventContactPresenceChanged    = "contact.presence_changed"
+	EventChatAssigned              = "chat.assigned"
+	EventChatLabeled               = "chat.labeled"
+	EventChatResolved              = "chat.resolved"
+	EventChatHandoff               = "chat.handoff"
+	EventChatBotPaused             = "chat.bot_paused"
+	EventChatBotResumed            = "chat.bot_resumed"
+	EventSLABreached               = "sla.breached"
+	EventFlowStarted               = "flow.started"
+	EventFlowCompleted             = "flow.completed"
+	EventFlowEnded                 = "flow.ended"
+	EventPaymentRequested          = "payment.requested"
+	EventPaymentCompleted          = "payment.completed"
+	EventPaymentDeclined           = "payment.declined"
+	EventPaymentCancelled          = "payment.cancelled"
+	EventOrderReceived             = "order.received"
+	EventSessionLocked             = "session.locked"
+	EventSessionUnlocked           = "session.unlocked"
+	EventDashboardLoginNewCountry  = "dashboard.login_new_country"
+	EventMaintenanceStarted        = "maintenance.started"
+	EventMaintenanceEnded          = "maintenance.ended"
+	EventCommandExecuted           = "command.executed"
+	EventConnectionConnected       = "connection.connected"
+	EventConnectionDisconnected    = "connection.disconnected"
+	EventConnectionLoggedOut       = "connection.logged_out"
+	EventAccountPaired             = "account.paired"
+	EventAccountConnected          = "account.connected"
+	EventAccountDisconnected       = "account.disconnected"
+	EventAccountLoggedOut          = "account.logged_out"
+	EventStorageStatusChanged      = "storage.status_changed"
+	EventCampaignApprovalRequested = "campaign.approval_requested"
+	EventCampaignApproved          = "campaign.approved"
+	EventCampaignRejected          = "campaign.rejected"
+)
+
+// eventTypes lists every event type, for clients that listen to each named server-sent event
+var eventTypes = []string{
+	EventMessageReceived, EventMessageSent, EventMessageFailed, EventMessageReaction, EventMessageRevoked,
+	EventMessageDelivered, EventMessageRead, EventMessageStatus,
+	EventGroupParticipantsAdded, EventGroupParticipantsRemoved, EventGroupParticipantsPromoted, EventGroupParticipantsDemoted,
+	EventGroupSubjectChanged, EventGroupDescriptionChanged, EventGroupIconChanged, EventGroupSettingsChanged, EventGroupModeration,
+	EventContactPushNameChanged, EventContactPictureChanged, EventContactPresenceChanged,
+	EventChatAssigned, EventChatLabeled, EventChatResolved, EventChatHandoff, EventChatBotPaused, EventChatBotResumed, EventSLABreached, EventFlowStarted, EventFlowCompleted, EventFlowEnded,
+	EventPaymentRequested, EventPaymentCompleted, EventPaymentDeclined, EventPaymentCancelled, EventOrderReceived,
+	EventSessionLocked, EventSessionUnlocked, EventDashboardLoginNewCountry, EventMaintenanceStarted, EventMaintenanceEnded, EventCommandExecuted,
+	EventConnectionConnected, EventConnectionDisconnected, EventConnectionLoggedOut, EventStorageStatusChanged,
+	EventAccountPaired, EventAccountConnected, EventAccountDisconnected, EventAccountLoggedOut,
+	EventCampaignApprovalRequested, EventCampaignApproved, EventCampaignRejected,
+}
+
+// BridgeEvent is something that happened on the WhatsApp account, in the shape sent to subscribers
+type BridgeEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	ChatJID   string                 `json:"chat_jid,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// EventBus fans bridge events out to subscribers such as webhooks
+type EventBus struct {
+	subscribers map[int]func(BridgeEvent)
+	nextID      int
+	mutex       sync.RWMutex
+}
+
+// eventBus is the process-wide event bus
+var eventBus = NewEventBus()
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]func(BridgeEvent))}
+}
+
+// Subscribe registers a handler for every event and returns a function that removes it.
+// Handlers run on the publisher's goroutine, so they must hand slow work off.
+func (b *EventBus) Subscribe(handler func(BridgeEvent)) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers an event to all subscribers
+func (b *EventBus) Publish(evt BridgeEvent) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, handler := range b.subscribers {
+		handler(evt)
+	}
+}
+
+// publishEvent fills in the ID and timestamp of an event and publishes it
+func publishEvent(eventType, chatJID string, timestamp time.Time, data map[string]interface{}) {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	eventBus.Publish(BridgeEvent{
+		ID:        newEventID(),
+		Type:      eventType,
+		Timestamp: timestamp.UTC(),
+		ChatJID:   chatJID,
+		Data:      data,
+	})
+}
+
+// newEventID returns a random ID receivers can use to drop duplicate deliveries
+func newEventID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Keys of event data holding personal information, redacted per sink
+var (
+	eventPhoneKeys = map[string]bool{"chat_jid": true, "recipient": true, "sender": true, "message_sender": true, "jid": true, "author": true, "participants": true, "request_from": true, "seller": true}
+	eventBodyKeys  = map[string]bool{"content": true, "message_content": true, "description": true, "variables": true, "note": true}
+	eventNameKeys  = map[string]bool{"agent": true, "email": true, "acknowledged_by": true, "name": true, "old_name": true, "new_name": true, "subject": true}
+)
+
+// redactEvent returns a copy of an event with personal data redacted for a sink
+func redactEvent(evt BridgeEvent, r *Redactor) BridgeEvent {
+	redacted := evt
+	redacted.ChatJID = r.Phone(evt.ChatJID)
+	redacted.Data = make(map[string]interface{}, len(evt.Data))
+
+	for key, value := range evt.Data {
+		switch v := value.(type) {
+		case string:
+			switch {
+			case eventPhoneKeys[key]:
+				value = r.Phone(v)
+			case eventBodyKeys[key]:
+				value = r.Body(v)
+			case eventNameKeys[key]:
+				value = r.Name(v)
+			}
+		case []string:
+			if eventPhoneKeys[key] {
+				phones := make([]string, len(v))
+				for i, phone := range v {
+					phones[i] = r.Phone(phone)
+				}
+				value = phones
+			}
+		case map[string]string:
+			if eventBodyKeys[key] {
+				values := make(map[string]string, len(v))
+				for name, text := range v {
+					values[name] = r.Body(text)
+				}
+				value = values
+			}
+		}
+		redacted.Data[key] = value
+	}
+	return redacted
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// EventLog keeps recent bridge events in the bridge_events table, so the activity feed
+// can show what happened before the page was opened
+type EventLog struct {
+	messageStore *MessageStore
+	retention    time.Duration
+	queue        chan BridgeEvent
+	logger       waLog.Logger
+}
+
+// NewEventLogFromEnv reads EVENT_LOG_RETENTION_DAYS (default 7); 0 turns the log off and returns nil
+func NewEventLogFromEnv(messageStore *MessageStore, logger waLog.Logger) (*EventLog, error) {
+	days := getEnvInt("EVENT_LOG_RETENTION_DAYS", 7)
+	if days < 0 {
+		return nil, fmt.Errorf("EVENT_LOG_RETENTION_DAYS must not be negative")
+	}
+	if days == 0 {
+		return nil, nil
+	}
+	return &EventLog{
+		messageStore: messageStore,
+		retention:    time.Duration(days) * 24 * time.Hour,
+		queue:        make(chan BridgeEvent, 1000),
+		logger:       logger,
+	}, nil
+}
+
+// Start subscribes to the event bus, writes events in the background and prunes old ones hourly
+func (l *EventLog) Start() {
+	eventBus.Subscribe(func(evt BridgeEvent) {
+		// Presence changes and receipts are frequent and only interesting live
+		if evt.Type == EventContactPresenceChanged || evt.Type == EventMessageDelivered || evt.Type == EventMessageRead || evt.Type == EventMessageStatus {
+			return
+		}
+		select {
+		case l.queue <- evt:
+		default:
+			l.logger.Warnf("Event log queue full, dropping %s event", evt.Type)
+		}
+	})
+
+	go func() {
+		for evt := range l.queue {
+			// Followers see the leader's events in the shared database
+			if readOnlyMode || (leaderElector != nil && !leaderElector.IsLeader()) {
+				continue
+			}
+			if err := l.messageStore.SaveEvent(evt); err != nil {
+				l.logger.Warnf("Failed to log %s event: %v", evt.Type, err)
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			if !readOnlyMode && (leaderElector == nil || leaderElector.IsLeader()) {
+				if deleted, err := l.messageStore.PruneEvents(time.Now().Add(-l.retention)); err != nil {
+					l.logger.Warnf("Failed to prune the event log: %v", err)
+				} else if deleted > 0 {
+					l.logger.Infof("Pruned %d logged events", deleted)
+				}
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// SaveEvent stores an event with its data as JSON
+func (store *MessageStore) SaveEvent(evt BridgeEvent) error {
+	data, err := json.Marshal(evt.Data)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO bridge_events (id, type, chat_jid, timestamp, data) VALUES (?, ?, ?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO bridge_events (id, type, chat_jid, timestamp, data) VALUES ($1, $2, $3, $4, $5)"
+	}
+	_, err = store.db.Exec(query, evt.ID, evt.Type, evt.ChatJID, evt.Timestamp.UTC(), string(data))
+	return err
+}
+
+// PruneEvents deletes events older than before
+func (store *MessageStore) PruneEvents(before time.Time) (int64, error) {
+	query := "DELETE FROM bridge_events WHERE timestamp < ?"
+	if store.isPostgres {
+		query = "DELETE FROM bridge_events WHERE timestamp < $1"
+	}
+	result, err := store.db.Exec(query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EventFilter selects logged events. Types ending in ".*" match a whole category, e.g. "group.*".
+type EventFilter struct {
+	Types   []string
+	ChatJID string
+	Before  time.Time
+	Limit   int
+}
+
+// ListEvents returns logged events matching the filter, newest first
+func (store *MessageStore) ListEvents(filter EventFilter) ([]BridgeEvent, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	if len(filter.Types) > 0 {
+		var matches []string
+		for _, eventType := range filter.Types {
+			if category, ok := strings.CutSuffix(eventType, ".*"); ok {
+				matches = append(matches, "type LIKE "+arg(category+".%"))
+			} else {
+				matches = append(matches, "type = "+arg(eventType))
+			}
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+	if filter.ChatJID != "" {
+		conditions = append(conditions, "chat_jid = "+arg(filter.ChatJID))
+	}
+	if !filter.Before.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(filter.Before.UTC()))
+	}
+
+	query := "SELECT id, type, COALESCE(chat_jid, ''), timestamp, data FROM bridge_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp DESC LIMIT " + arg(filter.Limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []BridgeEvent{}
+	for rows.Next() {
+		var evt BridgeEvent
+		var data string
+		if err := rows.Scan(&evt.ID, &evt.Type, &evt.ChatJID, &evt.Timestamp, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &evt.Data); err != nil {
+			evt.Data = map[string]interface{}{}
+		}
+		events = append(events, evt)
+	}
+	return events, rows.Err()
+}
+
+// registerEventLogRoutes registers /api/v1/activity?types=...&chat_jid=...&before=...&limit=...
+func registerEventLogRoutes(messageStore *MessageStore) {
+	handleAPI("/activity", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter := EventFilter{ChatJID: r.URL.Query().Get("chat_jid"), Limit: 100}
+		if types := r.URL.Query().Get("types"); types != "" {
+			for _, eventType := range strings.Split(types, ",") {
+				if eventType = strings.TrimSpace(eventType); eventType != "" {
+					filter.Types = append(filter.Types, eventType)
+				}
+			}
+		}
+		if value := r.URL.Query().Get("before"); value != "" {
+			if filter.Before, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				http.Error(w, "before must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			filter.Limit, err = strconv.Atoi(value)
+			if err != nil || filter.Limit < 1 || filter.Limit > 500 {
+				http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+				return
+			}
+		}
+
+		events, err := messageStore.ListEvents(filter)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range events {
+			events[i].Timestamp = events[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventStreamKeepAlive is how often an idle stream sends a comment so proxies keep it open
+const eventStreamKeepAlive = 30 * time.Second
+
+// registerEventStreamRoutes registers /api/v1/events, a Server-Sent Events stream of bridge events.
+// ?types=a,b limits the stream to the listed event types.
+func registerEventStreamRoutes() {
+	handleAPI("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var filter map[string]bool
+		if types := r.URL.Query().Get("types"); types != "" {
+			filter = make(map[string]bool)
+			for _, eventType := range strings.Split(types, ",") {
+				filter[strings.TrimSpace(eventType)] = true
+			}
+		}
+
+		// Slow clients lose events rather than holding up the publisher
+		stream := make(chan BridgeEvent, 100)
+		unsubscribe := eventBus.Subscribe(func(evt BridgeEvent) {
+			if filter != nil && !filter[evt.Type] {
+				return
+			}
+			select {
+			case stream <- evt:
+			default:
+			}
+		})
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		// The stream stays open for as long as the client listens
+		clearDeadlines(w)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventStreamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case evt := <-stream:
+				data, err := json.Marshal(evt)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data)
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Flow step types
+const (
+	StepMessage = "message" // send a message and continue with next
+	StepMenu    = "menu"    // send numbered options and branch on the choice
+	StepInput   = "input"   // ask for a value and store it in a variable
+	StepConfirm = "confirm" // ask a yes/no question and branch on the answer
+	StepEnd     = "end"     // send a closing message and finish the flow
+)
+
+// flowsDirName is the default directory of flow definitions inside DATA_DIR
+const flowsDirName = "flows"
+
+// maxChainedSteps stops message steps that loop back on themselves
+const maxChainedSteps = 20
+
+// Answers accepted by confirm steps
+var (
+	confirmYes = map[string]bool{"yes": true, "y": true, "ok": true, "confirm": true, "1": true}
+	confirmNo  = map[string]bool{"no": true, "n": true, "2": true}
+)
+
+// flowVariablePattern matches {{name}} placeholders in step messages
+var flowVariablePattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// FlowOption is a choice of a menu step
+type FlowOption struct {
+	Label    string   `yaml:"label" json:"label"`
+	Keywords []string `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Next     string   `yaml:"next" json:"next"`
+}
+
+// FlowStep is one state of a conversation flow
+type FlowStep struct {
+	Type     string       `yaml:"type" json:"type"`
+	Message  string       `yaml:"message" json:"message"`
+	Next     string       `yaml:"next,omitempty" json:"next,omitempty"`
+	Options  []FlowOption `yaml:"options,omitempty" json:"options,omitempty"`
+	Variable string       `yaml:"variable,omitempty" json:"variable,omitempty"`
+	Validate string       `yaml:"validate,omitempty" json:"validate,omitempty"`
+	Error    string       `yaml:"error,omitempty" json:"error,omitempty"`
+	OnYes    string       `yaml:"on_yes,omitempty" json:"on_yes,omitempty"`
+	OnNo     string       `yaml:"on_no,omitempty" json:"on_no,omitempty"`
+	Assign   string       `yaml:"assign,omitempty" json:"assign,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// FlowTrigger starts a flow when a contact without an active flow sends a matching message
+type FlowTrigger struct {
+	Keywords []string `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Regex    string   `yaml:"regex,omitempty" json:"regex,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Flow is a multi-step conversation defined in a YAML or JSON file
+type Flow struct {
+	ID             string               `yaml:"id" json:"id"`
+	Description    string               `yaml:"description,omitempty" json:"description,omitempty"`
+	Start          string               `yaml:"start" json:"start"`
+	Trigger        *FlowTrigger         `yaml:"trigger,omitempty" json:"trigger,omitempty"`
+	Cancel         []string             `yaml:"cancel,omitempty" json:"cancel,omitempty"`
+	TimeoutMinutes int                  `yaml:"timeout_minutes,omitempty" json:"timeout_minutes,omitempty"`
+	Steps          map[string]*FlowStep `yaml:"steps" json:"steps"`
+}
+
+// FlowSession is the position of a chat in a flow
+type FlowSession struct {
+	ChatJID   string            `json:"chat_jid"`
+	FlowID    string            `json:"flow_id"`
+	Step      string            `json:"step"`
+	Variables map[string]string `json:"variables"`
+	StartedAt time.Time         `json:"started_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// FlowEngine runs conversation flows, keeping each chat's position in the database
+type FlowEngine struct {
+	flows        map[string]*Flow
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+	chatLocks    sync.Map
+}
+
+// flowEngine is set when flow definitions are configured
+var flowEngine *FlowEngine
+
+// NewFlowEngineFromEnv loads the flows in FLOWS_DIR or DATA_DIR/flows.
+// It returns nil when the directory doesn't exist.
+func NewFlowEngineFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*FlowEngine, error) {
+	dir := os.Getenv("FLOWS_DIR")
+	if dir == "" {
+		dir = dataPath(flowsDirName)
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) && os.Getenv("FLOWS_DIR") == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flows directory: %v", err)
+	}
+
+	engine := &FlowEngine{
+		flows:        make(map[string]*Flow),
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+	}
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read flow %s: %v", entry.Name(), err)
+		}
+
+		// JSON is valid YAML, so one decoder reads both
+		var flow Flow
+		if err := yaml.Unmarshal(data, &flow); err != nil {
+			return nil, fmt.Errorf("invalid flow %s: %v", entry.Name(), err)
+		}
+		if flow.ID == "" {
+			flow.ID = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		if err := flow.compile(); err != nil {
+			return nil, fmt.Errorf("invalid flow %s: %v", entry.Name(), err)
+		}
+		if engine.flows[flow.ID] != nil {
+			return nil, fmt.Errorf("duplicate flow id %q in %s", flow.ID, entry.Name())
+		}
+		engine.flows[flow.ID] = &flow
+	}
+
+	logger.Infof("Loaded %d conversation flows from %s", len(engine.flows), dir)
+	return engine, nil
+}
+
+// compile checks that all steps exist and prepares the patterns of a flow
+func (flow *Flow) compile() error {
+	if flow.Steps[flow.Start] == nil {
+		return fmt.Errorf("start step %q does not exist", flow.Start)
+	}
+
+	target := func(step, field, name string) error {
+		if name == "" {
+			return fmt.Errorf("step %q needs %s", step, field)
+		}
+		if flow.Steps[name] == nil {
+			return fmt.Errorf("step %q: %s step %q does not exist", step, field, name)
+		}
+		return nil
+	}
+
+	var err error
+	for name, step := range flow.Steps {
+		switch step.Type {
+		case StepMessage:
+			err = target(name, "next", step.Next)
+		case StepMenu:
+			if len(step.Options) == 0 {
+				return fmt.Errorf("menu step %q has no options", name)
+			}
+			for _, option := range step.Options {
+				if err = target(name, "next", option.Next); err != nil {
+					break
+				}
+			}
+		case StepInput:
+			if step.Variable == "" {
+				return fmt.Errorf("input step %q needs a variable", name)
+			}
+			if step.Validate != "" {
+				if step.pattern, err = regexp.Compile(step.Validate); err != nil {
+					return fmt.Errorf("step %q: invalid validate pattern: %v", name, err)
+				}
+			}
+			err = target(name, "next", step.Next)
+		case StepConfirm:
+			if err = target(name, "on_yes", step.OnYes); err == nil {
+				err = target(name, "on_no", step.OnNo)
+			}
+		case StepEnd:
+		default:
+			return fmt.Errorf("step %q has unknown type %q", name, step.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if flow.Trigger != nil {
+		var alternatives []string
+		for _, keyword := range flow.Trigger.Keywords {
+			alternatives = append(alternatives, `\b`+regexp.QuoteMeta(keyword)+`\b`)
+		}
+		if flow.Trigger.Regex != "" {
+			alternatives = append(alternatives, "(?:"+flow.Trigger.Regex+")")
+		}
+		if len(alternatives) == 0 {
+			return fmt.Errorf("trigger needs keywords or a regex")
+		}
+		if flow.Trigger.pattern, err = regexp.Compile("(?i)" + strings.Join(alternatives, "|")); err != nil {
+			return fmt.Errorf("invalid trigger: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// lockChat serializes flow handling per chat, so quick replies advance one step at a time
+func (e *FlowEngine) lockChat(chatJID string) func() {
+	lock, _ := e.chatLocks.LoadOrStore(chatJID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// HandleIncoming advances the chat's active flow, or starts a flow whose trigger matches.
+// It reports whether the message was consumed by a flow.
+func (e *FlowEngine) HandleIncoming(msg IncomingMessage) bool {
+	if msg.IsGroup {
+		return false
+	}
+
+	unlock := e.lockChat(msg.ChatJID)
+	defer unlock()
+
+	session, err := e.messageStore.GetFlowSession(msg.ChatJID)
+	if err != nil {
+		e.logger.Warnf("Failed to load flow session: %v", err)
+		return false
+	}
+
+	// Sessions of removed flows or abandoned conversations end quietly
+	if session != nil {
+		flow := e.flows[session.FlowID]
+		if flow == nil || flow.Steps[session.Step] == nil || flow.expired(session) {
+			e.finish(session, "expired")
+			session = nil
+		}
+	}
+
+	if session != nil {
+		if err := e.advance(session, strings.TrimSpace(msg.Content)); err != nil {
+			e.logger.Warnf("Flow %s failed in %s: %v", session.FlowID, logRedactor.Phone(msg.ChatJID), err)
+		}
+		return true
+	}
+
+	for _, id := range e.flowIDs() {
+		flow := e.flows[id]
+		if flow.Trigger != nil && flow.Trigger.pattern.MatchString(msg.Content) {
+			if _, err := e.start(msg.ChatJID, flow, "", nil); err != nil {
+				e.logger.Warnf("Failed to start flow %s: %v", flow.ID, err)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// expired reports whether a session was idle for longer than the flow's timeout
+func (flow *Flow) expired(session *FlowSession) bool {
+	timeout := flow.TimeoutMinutes
+	if timeout == 0 {
+		timeout = getEnvInt("FLOW_TIMEOUT_MINUTES", 60)
+	}
+	return time.Since(session.UpdatedAt) > time.Duration(timeout)*time.Minute
+}
+
+// flowIDs returns the flow IDs in a stable order, so trigger precedence is predictable
+func (e *FlowEngine) flowIDs() []string {
+	ids := make([]string, 0, len(e.flows))
+	for id := range e.flows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Start puts a chat into a flow, replacing any active flow, and sends the first prompt
+func (e *FlowEngine) Start(chatJID, flowID, step string, variables map[string]string) (*FlowSession, error) {
+	flow := e.flows[flowID]
+	if flow == nil {
+		return nil, fmt.Errorf("unknown flow %q", flowID)
+	}
+	if step != "" && flow.Steps[step] == nil {
+		return nil, fmt.Errorf("flow %q has no step %q", flowID, step)
+	}
+
+	unlock := e.lockChat(chatJID)
+	defer unlock()
+	return e.start(chatJID, flow, step, variables)
+}
+
+// Advance feeds input to the chat's active flow as if the contact had sent it
+func (e *FlowEngine) Advance(chatJID, input string) (*FlowSession, error) {
+	unlock := e.lockChat(chatJID)
+	defer unlock()
+
+	session, err := e.messageStore.GetFlowSession(chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || e.flows[session.FlowID] == nil {
+		return nil, nil
+	}
+	if err := e.advance(session, strings.TrimSpace(input)); err != nil {
+		return nil, err
+	}
+	return e.messageStore.GetFlowSession(chatJID)
+}
+
+// Cancel ends the chat's active flow without a closing message
+func (e *FlowEngine) Cancel(chatJID string) error {
+	unlock := e.lockChat(chatJID)
+	defer unlock()
+
+	session, err := e.messageStore.GetFlowSession(chatJID)
+	if err != nil || session == nil {
+		return err
+	}
+	e.finish(session, "cancelled")
+	return nil
+}
+
+// start creates a session at the start (or given) step and enters it
+func (e *FlowEngine) start(chatJID string, flow *Flow, step string, variables map[string]string) (*FlowSession, error) {
+	if step == "" {
+		step = flow.Start
+	}
+	if variables == nil {
+		variables = map[string]string{}
+	}
+
+	now := time.Now().UTC()
+	session := &FlowSession{
+		ChatJID:   chatJID,
+		FlowID:    flow.ID,
+		Variables: variables,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+
+	publishEvent(EventFlowStarted, chatJID, now, map[string]interface{}{
+		"chat_jid": chatJID,
+		"flow_id":  flow.ID,
+	})
+
+	if err := e.enter(session, step); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// advance applies the contact's answer to the current step and moves to the next one
+func (e *FlowEngine) advance(session *FlowSession, input string) error {
+	flow := e.flows[session.FlowID]
+	step := flow.Steps[session.Step]
+
+	for _, word := range flow.Cancel {
+		if strings.EqualFold(input, word) {
+			e.finish(session, "cancelled")
+			return nil
+		}
+	}
+
+	var next string
+	switch step.Type {
+	case StepMenu:
+		next = step.choose(input)
+
+	case StepInput:
+		if input != "" && (step.pattern == nil || step.pattern.MatchString(input)) {
+			session.Variables[step.Variable] = input
+			next = step.Next
+		}
+
+	case StepConfirm:
+		answer := strings.ToLower(input)
+		if confirmYes[answer] {
+			next = step.OnYes
+		} else if confirmNo[answer] {
+			next = step.OnNo
+		}
+
+	default:
+		// Sessions only wait at menu, input and confirm steps; anything else starts over
+		next = flow.Start
+	}
+
+	// Unusable answers repeat the question, until too many of them hand the chat to an agent
+	if next == "" {
+		if chatHandoff.Fallback(session.ChatJID) {
+			e.finish(session, "handoff")
+			return chatHandoff.Trigger(session.ChatJID, HandoffFallback, "")
+		}
+		errorMessage := step.Error
+		if errorMessage == "" {
+			errorMessage = "Sorry, I didn't understand that."
+		}
+		return e.send(session.ChatJID, errorMessage+"\n\n"+step.prompt(session.Variables))
+	}
+
+	chatHandoff.Understood(session.ChatJID)
+	return e.enter(session, next)
+}
+
+// choose returns the next step of the menu option picked by number, label or keyword
+func (step *FlowStep) choose(input string) string {
+	if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(step.Options) {
+		return step.Options[n-1].Next
+	}
+	for _, option := range step.Options {
+		if strings.EqualFold(input, option.Label) {
+			return option.Next
+		}
+		for _, keyword := range option.Keywords {
+			if strings.EqualFold(input, keyword) {
+				return option.Next
+			}
+		}
+	}
+	return ""
+}
+
+// prompt renders the message of a step, with numbered options for menus
+func (step *FlowStep) prompt(variables map[string]string) string {
+	text := flowVariablePattern.ReplaceAllStringFunc(step.Message, func(placeholder string) string {
+		return variables[flowVariablePattern.FindStringSubmatch(placeholder)[1]]
+	})
+	if step.Type == StepMenu {
+		for i, option := range step.Options {
+			text += fmt.Sprintf("\n%d. %s", i+1, option.Label)
+		}
+	}
+	return text
+}
+
+// enter sends the prompt of a step and saves the session there, running message steps straight through
+func (e *FlowEngine) enter(session *FlowSession, name string) error {
+	flow := e.flows[session.FlowID]
+
+	for i := 0; i < maxChainedSteps; i++ {
+		step := flow.Steps[name]
+		session.Step = name
+		session.UpdatedAt = time.Now().UTC()
+
+		if step.Message != "" {
+			if err := e.send(session.ChatJID, step.prompt(session.Variables)); err != nil {
+				return err
+			}
+		}
+
+		switch step.Type {
+		case StepMessage:
+			name = step.Next
+			continue
+		case StepEnd:
+			if step.Assign != "" {
+				if _, err := e.messageStore.AssignChat(session.ChatJID, step.Assign, "flow:"+flow.ID, true); err != nil {
+					e.logger.Warnf("Failed to assign chat to %s: %v", step.Assign, err)
+				} else {
+					publishEvent(EventChatAssigned, session.ChatJID, time.Time{}, map[string]interface{}{
+						"chat_jid": session.ChatJID,
+						"queue":    step.Assign,
+						"rule":     "flow:" + flow.ID,
+					})
+				}
+			}
+			e.finish(session, "completed")
+			return nil
+		}
+
+		return e.messageStore.SaveFlowSession(session)
+	}
+
+	return fmt.Errorf("more than %d message steps in a row at %q", maxChainedSteps, name)
+}
+
+// finish removes a session and publishes its outcome with the collected variables
+func (e *FlowEngine) finish(session *FlowSession, outcome string) {
+	if err := e.messageStore.DeleteFlowSession(session.ChatJID); err != nil {
+		e.logger.Warnf("Failed to end flow session: %v", err)
+	}
+
+	eventType := EventFlowCompleted
+	if outcome != "completed" {
+		eventType = EventFlowEnded
+	}
+	publishEvent(eventType, session.ChatJID, time.Time{}, map[string]interface{}{
+		"chat_jid":  session.ChatJID,
+		"flow_id":   session.FlowID,
+		"step":      session.Step,
+		"outcome":   outcome,
+		"variables": session.Variables,
+	})
+}
+
+// send sends a flow message to a chat
+func (e *FlowEngine) send(chatJID, text string) error {
+	noSignature := false
+	success, result, _, _ := sendWhatsAppMessage(e.client, chatJID, text, "", SendOptions{Agent: "flow", Signature: &noSignature}, e.messageStore)
+	if !success {
+		return fmt.Errorf("%s", result)
+	}
+	return nil
+}
+
+// GetFlowSession returns the active flow of a chat, or nil if there is none
+func (store *MessageStore) GetFlowSession(chatJID string) (*FlowSession, error) {
+	query := "SELECT chat_jid, flow_id, step, variables, started_at, updated_at FROM flow_sessions WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT chat_jid, flow_id, step, variables, started_at, updated_at FROM flow_sessions WHERE chat_jid = $1"
+	}
+
+	var session FlowSession
+	var variables string
+	err := store.db.QueryRow(query, chatJID).Scan(&session.ChatJID, &session.FlowID, &session.Step, &variables, &session.StartedAt, &session.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &session.Variables); err != nil || session.Variables == nil {
+		session.Variables = map[string]string{}
+	}
+	return &session, nil
+}
+
+// SaveFlowSession stores or replaces the active flow of a chat
+func (store *MessageStore) SaveFlowSession(session *FlowSession) error {
+	variables, err := json.Marshal(session.Variables)
+	if err != nil {
+		return err
+	}
+
+	var query string
+	if store.isPostgres {
+		query = "INSERT INTO flow_sessions (chat_jid, flow_id, step, variables, started_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (chat_jid) DO UPDATE SET flow_id = $2, step = $3, variables = $4, started_at = $5, updated_at = $6"
+	} else {
+		query = "INSERT OR REPLACE INTO flow_sessions (chat_jid, flow_id, step, variables, started_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)"
+	}
+
+	_, err = store.db.Exec(query, session.ChatJID, session.FlowID, session.Step, string(variables), session.StartedAt, session.UpdatedAt)
+	return err
+}
+
+// DeleteFlowSession removes the active flow of a chat
+func (store *MessageStore) DeleteFlowSession(chatJID string) error {
+	query := "DELETE FROM flow_sessions WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM flow_sessions WHERE chat_jid = $1"
+	}
+	_, err := store.db.Exec(query, chatJID)
+	return err
+}
+
+// StartFlowRequest represents the request body for putting a chat into a flow
+type StartFlowRequest struct {
+	FlowID    string            `json:"flow_id"`
+	Step      string            `json:"step,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// registerFlowRoutes registers /api/v1/flows and /api/v1/chats/{jid}/flow
+func registerFlowRoutes(messageStore *MessageStore) {
+	handleAPI("/flows", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		flows := []*Flow{}
+		if flowEngine != nil {
+			for _, id := range flowEngine.flowIDs() {
+				flows = append(flows, flowEngine.flows[id])
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(flows)
+	})
+
+	// Starting and advancing flows sends messages, which only the leader can do
+	registerChatRoute("flow", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+			serveChatFlow(w, r, chatJID, messageStore)
+		})(w, r)
+	})
+}
+
+// serveChatFlow handles /api/v1/chats/{jid}/flow
+func serveChatFlow(w http.ResponseWriter, r *http.Request, chatJID string, messageStore *MessageStore) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if flowEngine == nil {
+		http.Error(w, "No flows configured", http.StatusNotFound)
+		return
+	}
+
+	var session *FlowSession
+	switch r.Method {
+	case http.MethodGet:
+		session, err = messageStore.GetFlowSession(chatJID)
+
+	case http.MethodPut:
+		// Put the chat into a flow, e.g. after an agent hands the conversation to the bot
+		var req StartFlowRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FlowID == "" {
+			http.Error(w, "flow_id is required", http.StatusBadRequest)
+			return
+		}
+		if flowEngine.flows[req.FlowID] == nil {
+			http.Error(w, fmt.Sprintf("Unknown flow %q", req.FlowID), http.StatusNotFound)
+			return
+		}
+		if _, err = flowEngine.Start(chatJID, req.FlowID, req.Step, req.Variables); err == nil {
+			session, err = messageStore.GetFlowSession(chatJID)
+		}
+
+	case http.MethodPost:
+		// Advance the flow as if the contact had answered
+		var req struct {
+			Input string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		session, err = flowEngine.Advance(chatJID, req.Input)
+
+	case http.MethodDelete:
+		if err := flowEngine.Cancel(chatJID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to cancel flow: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Flow failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Flows that finished right away leave no session
+	if session == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	session.StartedAt = session.StartedAt.In(loc)
+	session.UpdatedAt = session.UpdatedAt.In(loc)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultTrustedProxies covers a reverse proxy on the same host. Proxies elsewhere, even in a private
+// network, must be listed, since anything that can reach the bridge there could claim any client address.
+const defaultTrustedProxies = "127.0.0.0/8,::1/128"
+
+// TrustedProxies are the peers whose X-Forwarded-* headers describe the original request
+type TrustedProxies struct {
+	networks []*net.IPNet
+	all      bool
+}
+
+// trustedProxies is the process-wide list, set from TRUSTED_PROXIES at startup
+var trustedProxies = &TrustedProxies{}
+
+// NewTrustedProxiesFromEnv reads TRUSTED_PROXIES, a comma-separated list of addresses and CIDR ranges.
+// "*" trusts every peer, for platforms whose load balancer addresses aren't known; "none" trusts none.
+func NewTrustedProxiesFromEnv() (*TrustedProxies, error) {
+	value := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	switch value {
+	case "":
+		value = defaultTrustedProxies
+	case "*":
+		return &TrustedProxies{all: true}, nil
+	case "none":
+		return &TrustedProxies{}, nil
+	}
+
+	proxies := &TrustedProxies{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an address or CIDR range", entry)
+		}
+		proxies.networks = append(proxies.networks, network)
+	}
+	return proxies, nil
+}
+
+// Trusts reports whether a peer address (host or host:port) is a trusted proxy
+func (p *TrustedProxies) Trusts(remoteAddr string) bool {
+	if p.all {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return p.listed(net.ParseIP(host))
+}
+
+// listed reports whether an address is in one of the trusted ranges; "*" lists none, as it only
+// says the peer is a proxy, not which addresses in X-Forwarded-For are
+func (p *TrustedProxies) listed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header. For X-Forwarded-Proto and
+// X-Forwarded-Host this is only as reliable as the trusted proxy, which should overwrite them.
+func firstHeaderValue(r *http.Request, name string) string {
+	return strings.TrimSpace(strings.Split(r.Header.Get(name), ",")[0])
+}
+
+// forwardedClient returns the client address from X-Forwarded-For, or nil if there is none. Each
+// proxy appends the address it received the request from, so the list is walked from the right,
+// skipping trusted proxies: the first other address is the client. Entries further left were sent
+// by the client itself and can't be believed.
+func (p *TrustedProxies) forwardedClient(r *http.Request) net.IP {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Garbage in the list; only the hops to its right are known to be real
+			break
+		}
+		client = ip
+		if !p.listed(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedMiddleware applies X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-For from trusted
+// proxies to the request, so the scheme, host and client address seen by handlers are the original ones.
+// Headers from other peers are ignored, as any client could send them.
+func forwardedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Handlers must not modify the request they are given, so work on a copy
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		r.URL = &u
+
+		if r.TLS != nil {
+			r.URL.Scheme = "https"
+		} else {
+			r.URL.Scheme = "http"
+		}
+		r.URL.Host = r.Host
+
+		if trustedProxies.Trusts(r.RemoteAddr) {
+			if proto := strings.ToLower(firstHeaderValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+				r.URL.Scheme = proto
+			}
+			if host := firstHeaderValue(r, "X-Forwarded-Host"); host != "" {
+				r.Host = host
+				r.URL.Host = host
+			}
+			if client := trustedProxies.forwardedClient(r); client != nil {
+				r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isSecureRequest reports whether the client reached the bridge over HTTPS, directly or through a trusted proxy
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
+
+// externalURL is the absolute URL of a path under the base path, as the client sees the bridge
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if isSecureRequest(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + withBasePath(path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedMiddlewareClientAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client ignores header", "", "203.0.113.9:5000", []string{"8.8.8.8"}, "203.0.113.9"},
+		{"local proxy", "", "127.0.0.1:5000", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"spoofed entry left of the real client", "", "127.0.0.1:5000", []string{"8.8.8.8, 203.0.113.9"}, "203.0.113.9"},
+		{"private peer not trusted by default", "", "10.0.0.5:5000", []string{"8.8.8.8"}, "10.0.0.5"},
+		{"chain of trusted proxies", "127.0.0.1,10.0.0.0/8", "127.0.0.1:5000", []string{"8.8.8.8, 203.0.113.9, 10.0.0.7"}, "203.0.113.9"},
+		{"header split over lines", "127.0.0.1,10.0.0.0/8", "127.0.0.1:5000", []string{"8.8.8.8", "203.0.113.9, 10.0.0.7"}, "203.0.113.9"},
+		{"only trusted hops", "127.0.0.1,10.0.0.0/8", "127.0.0.1:5000", []string{"10.0.0.8, 10.0.0.7"}, "10.0.0.8"},
+		{"garbage stops the walk", "127.0.0.1,10.0.0.0/8", "127.0.0.1:5000", []string{"8.8.8.8, bogus, 10.0.0.7"}, "10.0.0.7"},
+		{"any peer takes the rightmost hop", "*", "35.191.0.1:5000", []string{"8.8.8.8, 203.0.113.9"}, "203.0.113.9"},
+		{"no header", "", "127.0.0.1:5000", nil, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			proxies, err := NewTrustedProxiesFromEnv()
+			if err != nil {
+				t.Fatalf("NewTrustedProxiesFromEnv: %v", err)
+			}
+			previous := trustedProxies
+			trustedProxies = proxies
+			defer func() { trustedProxies = previous }()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			var got string
+			forwardedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("client address = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// postgresSearchDocument is the text PostgreSQL indexes for full-text search. Queries must repeat
+// the expression exactly for the planner to use idx_messages_fts.
+const postgresSearchDocument = "to_tsvector('simple', COALESCE(content, '') || ' ' || COALESCE(filename, ''))"
+
+// sqliteSearchTriggers keep messages_fts in step with messages. INSERT OR REPLACE only fires the
+// delete trigger with recursive triggers on, which the message store's connection enables.
+var sqliteSearchTriggers = []struct{ name, ddl string }{
+	{
+		name: "messages_fts_insert",
+		ddl: `CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, content, filename) VALUES (NEW.rowid, NEW.content, NEW.filename);
+		END`,
+	},
+	{
+		name: "messages_fts_delete",
+		ddl: `CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content, filename) VALUES ('delete', OLD.rowid, OLD.content, OLD.filename);
+		END`,
+	},
+	{
+		name: "messages_fts_update",
+		ddl: `CREATE TRIGGER messages_fts_update AFTER UPDATE OF content, filename ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content, filename) VALUES ('delete', OLD.rowid, OLD.content, OLD.filename);
+			INSERT INTO messages_fts (rowid, content, filename) VALUES (NEW.rowid, NEW.content, NEW.filename);
+		END`,
+	},
+}
+
+// ensureFullTextSearch indexes the text and file names of messages: with a tsvector index on
+// PostgreSQL, and an FTS5 table on SQLite when the build includes FTS5 (the sqlite_fts5 tag).
+// Without FTS5, searches match substrings as before, and the triggers an FTS5 build left behind
+// are dropped since every insert would fail on them.
+func (store *MessageStore) ensureFullTextSearch() error {
+	if store.isPostgres {
+		if _, err := store.db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_fts ON messages USING GIN (" + postgresSearchDocument + ")"); err != nil {
+			return fmt.Errorf("failed to create full-text index: %v", err)
+		}
+		store.fullTextSearch = true
+		return nil
+	}
+
+	var available bool
+	if err := store.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available); err != nil {
+		return fmt.Errorf("failed to check for FTS5: %v", err)
+	}
+	if !available {
+		for _, trigger := range sqliteSearchTriggers {
+			if _, err := store.db.Exec("DROP TRIGGER IF EXISTS " + trigger.name); err != nil {
+				return fmt.Errorf("failed to drop %s trigger: %v", trigger.name, err)
+			}
+		}
+		return nil
+	}
+
+	if _, err := store.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (
+		content, filename, content = 'messages', tokenize = 'unicode61 remove_diacritics 2'
+	)`); err != nil {
+		return fmt.Errorf("failed to create messages_fts table: %v", err)
+	}
+
+	// A missing trigger means the index is new or missed changes, so it is rebuilt with the triggers
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebuild := false
+	for _, trigger := range sqliteSearchTriggers {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = ?)", trigger.name).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check for %s trigger: %v", trigger.name, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err := tx.Exec(trigger.ddl); err != nil {
+			return fmt.Errorf("failed to create %s trigger: %v", trigger.name, err)
+		}
+		rebuild = true
+	}
+	if rebuild {
+		fmt.Println("Building the full-text search index")
+		if _, err := tx.Exec("INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to build the full-text search index: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	store.fullTextSearch = true
+	return nil
+}
+
+// fullTextTerms splits a query into the lowercase words the full-text index holds
+func fullTextTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+}
+
+// fullTextCondition is the SQL condition matching messages that contain every term, or a prefix of
+// one, so "invoi" finds "invoice"
+func (store *MessageStore) fullTextCondition(terms []string, arg func(value interface{}) string) string {
+	prefixes := make([]string, len(terms))
+	if store.isPostgres {
+		for i, term := range terms {
+			prefixes[i] = term + ":*"
+		}
+		return postgresSearchDocument + " @@ to_tsquery('simple', " + arg(strings.Join(prefixes, " & ")) + ")"
+	}
+	for i, term := range terms {
+		prefixes[i] = `"` + term + `"*`
+	}
+	return "rowid IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH " + arg(strings.Join(prefixes, " ")) + ")"
+}
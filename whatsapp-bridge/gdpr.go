@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gdprChatTables lists bridge tables keyed by chat_jid whose rows belong to a single contact's chat
+var gdprChatTables = []string{"drafts", "chat_notes", "chat_metadata", "chat_assignments", "flow_sessions", "bot_pauses", "chat_labels", "contact_attributes", "outbox", "scheduled_messages", "scheduled_series", "chat_mutes", "bridge_events"}
+
+// gdprContactTables lists whatsmeow tables holding contact data and the columns that reference the contact
+var gdprContactTables = []struct {
+	table   string
+	columns []string
+}{
+	{"whatsmeow_contacts", []string{"their_jid"}},
+	{"whatsmeow_chat_settings", []string{"chat_jid"}},
+	{"whatsmeow_message_secrets", []string{"chat_jid", "sender_jid"}},
+}
+
+// EraseRequest represents the request body for a right-to-erasure request
+type EraseRequest struct {
+	Phone   string `json:"phone"`
+	JID     string `json:"jid"`
+	Confirm bool   `json:"confirm"`
+}
+
+// ErasureReport describes everything removed for an erasure request
+type ErasureReport struct {
+	JID         string           `json:"jid"`
+	Deleted     map[string]int64 `json:"deleted"`
+	Held        []string         `json:"held,omitempty"`
+	Errors      []string         `json:"errors,omitempty"`
+	CompletedAt time.Time        `json:"completed_at"`
+}
+
+// addError records a failed step without aborting the rest of the erasure
+func (report *ErasureReport) addError(format string, args ...interface{}) {
+	report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+}
+
+// normalizeErasureTarget turns a phone number or JID into the personal chat JID and the bare user part
+func normalizeErasureTarget(phone, jid string) (string, string, error) {
+	if jid == "" {
+		// Keep only digits so "+44 7700 900123" and "447700900123" match
+		var digits strings.Builder
+		for _, r := range phone {
+			if r >= '0' && r <= '9' {
+				digits.WriteRune(r)
+			}
+		}
+		if digits.Len() == 0 {
+			return "", "", fmt.Errorf("phone or jid is required")
+		}
+		jid = digits.String() + "@s.whatsapp.net"
+	}
+
+	user, server, found := strings.Cut(jid, "@")
+	if !found || user == "" {
+		return "", "", fmt.Errorf("invalid JID: %s", jid)
+	}
+	if server == "g.us" {
+		return "", "", fmt.Errorf("erasure applies to people, not groups")
+	}
+
+	// Strip the device part so every device of the contact is covered
+	user, _, _ = strings.Cut(user, ":")
+	return user + "@" + server, user, nil
+}
+
+// tableExists checks whether a table is present in the database
+func tableExists(db *sql.DB, isPostgres bool, table string) (bool, error) {
+	var count int
+	var err error
+	if isPostgres {
+		err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_name = $1", table).Scan(&count)
+	} else {
+		err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	}
+	return count > 0, err
+}
+
+// EraseContact deletes all messages, media and contact data held for a person.
+// Every step is attempted even if an earlier one fails, and failures are listed in the report.
+// Chats on legal hold are kept and listed in the report; if their personal chat is on hold,
+// the chat and contact data stay too.
+func (store *MessageStore) EraseContact(jid, user string) *ErasureReport {
+	report := &ErasureReport{JID: jid, Deleted: map[string]int64{}}
+
+	placeholder := func(n int) string {
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", n)
+		}
+		return "?"
+	}
+
+	// Chats on hold that hold their messages
+	personalHeld := legalHolds.IsHeld(jid)
+	if personalHeld {
+		report.Held = append(report.Held, jid)
+	}
+	rows, err := store.db.Query(fmt.Sprintf(
+		"SELECT DISTINCT chat_jid FROM messages WHERE (sender = %s OR sender = %s) AND chat_jid <> %s AND chat_jid IN (%s)",
+		placeholder(1), placeholder(2), placeholder(3), heldChatsQuery), user, jid, jid)
+	if err != nil {
+		report.addError("failed to list chats on hold: %v", err)
+	} else {
+		for rows.Next() {
+			var chatJID string
+			if err := rows.Scan(&chatJID); err == nil {
+				report.Held = append(report.Held, chatJID)
+			}
+		}
+		rows.Close()
+	}
+	for _, chatJID := range report.Held {
+		legalHolds.Record(chatJID, HoldErasureKept, "gdpr", "", "")
+	}
+
+	// Media files of messages they sent in group chats, collected before the rows are deleted
+	var mediaPaths []string
+	rows, err = store.db.Query(fmt.Sprintf(
+		"SELECT chat_jid, filename FROM messages WHERE (sender = %s OR sender = %s) AND chat_jid <> %s AND media_type <> '' AND chat_jid NOT IN (%s)",
+		placeholder(1), placeholder(2), placeholder(3), heldChatsQuery), user, jid, jid)
+	if err != nil {
+		report.addError("failed to list media: %v", err)
+	} else {
+		for rows.Next() {
+			var chatJID string
+			var filename sql.NullString
+			if err := rows.Scan(&chatJID, &filename); err == nil && filename.String != "" {
+				chatDir := dataPath(strings.ReplaceAll(chatJID, ":", "_"))
+				mediaPaths = append(mediaPaths, filepath.Join(chatDir, filepath.Base(filename.String)))
+			}
+		}
+		rows.Close()
+	}
+
+	// Media uploaded to Supabase Storage from those messages
+	var objects []string
+	if mediaStorage != nil {
+		rows, err := store.db.Query(fmt.Sprintf(
+			"SELECT storage_path FROM messages WHERE (chat_jid = %s OR sender = %s OR sender = %s) AND COALESCE(storage_path, '') <> '' AND chat_jid NOT IN (%s)",
+			placeholder(1), placeholder(2), placeholder(3), heldChatsQuery), jid, user, jid)
+		if err != nil {
+			report.addError("failed to list stored media: %v", err)
+		} else {
+			for rows.Next() {
+				var object string
+				if err := rows.Scan(&object); err == nil {
+					objects = append(objects, object)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	// Messages in their personal chat plus anything they sent in groups
+	condition := fmt.Sprintf("(chat_jid = %s OR sender = %s OR sender = %s) AND chat_jid NOT IN (%s)",
+		placeholder(1), placeholder(2), placeholder(3), heldChatsQuery)
+	erased := store.messagesToDelete(condition, jid, user, jid)
+	result, err := store.db.Exec("DELETE FROM messages WHERE "+condition, jid, user, jid)
+	if err != nil {
+		report.addError("failed to delete messages: %v", err)
+	} else {
+		report.Deleted["messages"], _ = result.RowsAffected()
+		emitMessageDeletes(erased, true)
+	}
+
+	// Reactions in their chat and those they left in groups
+	result, err = store.db.Exec(fmt.Sprintf(
+		"DELETE FROM message_reactions WHERE (chat_jid = %s OR sender = %s) AND chat_jid NOT IN (%s)",
+		placeholder(1), placeholder(2), heldChatsQuery), jid, user)
+	if err != nil {
+		report.addError("failed to delete reactions: %v", err)
+	} else {
+		report.Deleted["message_reactions"], _ = result.RowsAffected()
+	}
+
+	// Receipts in their chat and those they sent for group messages
+	result, err = store.db.Exec(fmt.Sprintf(
+		"DELETE FROM message_receipts WHERE (chat_jid = %s OR recipient = %s) AND chat_jid NOT IN (%s)",
+		placeholder(1), placeholder(2), heldChatsQuery), jid, jid)
+	if err != nil {
+		report.addError("failed to delete receipts: %v", err)
+	} else {
+		report.Deleted["message_receipts"], _ = result.RowsAffected()
+	}
+
+	if personalHeld {
+		store.eraseMediaFiles(mediaPaths, report)
+		store.eraseStorageObjects(objects, report)
+		report.CompletedAt = time.Now().UTC()
+		return report
+	}
+
+	result, err = store.db.Exec(fmt.Sprintf("DELETE FROM chats WHERE jid = %s", placeholder(1)), jid)
+	if err != nil {
+		report.addError("failed to delete chat: %v", err)
+	} else {
+		report.Deleted["chats"], _ = result.RowsAffected()
+		if report.Deleted["chats"] > 0 {
+			changeCapture.Emit(cdcTableChats, &ChatImage{JID: jid}, nil)
+		}
+	}
+	invalidateCache(cacheKeyChatList, cacheKeyChatMap, cacheKeyChatName+jid, cacheKeyChatAvatar+jid)
+
+	for _, table := range gdprChatTables {
+		result, err := store.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE chat_jid = %s", table, placeholder(1)), jid)
+		if err != nil {
+			report.addError("failed to delete from %s: %v", table, err)
+			continue
+		}
+		report.Deleted[table], _ = result.RowsAffected()
+	}
+
+	// Presence updates they shared, and any stored subscription to them
+	result, err = store.db.Exec(fmt.Sprintf("DELETE FROM contact_presence WHERE jid = %s", placeholder(1)), jid)
+	if err != nil {
+		report.addError("failed to delete presence: %v", err)
+	} else {
+		report.Deleted["contact_presence"], _ = result.RowsAffected()
+	}
+	presenceTracker.Forget(jid)
+
+	// Media directory of their personal chat and any quarantined files from it
+	chatDir := dataPath(strings.ReplaceAll(jid, ":", "_"))
+	if entries, err := os.ReadDir(chatDir); err == nil {
+		for _, entry := range entries {
+			mediaPaths = append(mediaPaths, filepath.Join(chatDir, entry.Name()))
+		}
+	}
+	if mediaScanPolicy != nil {
+		prefix := strings.ReplaceAll(jid, ":", "_") + "_"
+		if entries, err := os.ReadDir(mediaScanPolicy.quarantineDir); err == nil {
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), prefix) {
+					mediaPaths = append(mediaPaths, filepath.Join(mediaScanPolicy.quarantineDir, entry.Name()))
+				}
+			}
+		}
+	}
+
+	store.eraseMediaFiles(mediaPaths, report)
+	os.Remove(chatDir)
+	store.eraseStorageObjects(objects, report)
+
+	store.eraseContactData(jid, report)
+
+	report.CompletedAt = time.Now().UTC()
+	return report
+}
+
+// eraseMediaFiles deletes media files of an erased contact
+func (store *MessageStore) eraseMediaFiles(paths []string, report *ErasureReport) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				report.addError("failed to delete media file %s: %v", filepath.Base(path), err)
+			}
+			continue
+		}
+		report.Deleted["media_files"]++
+	}
+}
+
+// eraseStorageObjects deletes media of an erased contact from Supabase Storage
+func (store *MessageStore) eraseStorageObjects(objects []string, report *ErasureReport) {
+	if len(objects) == 0 {
+		return
+	}
+	if err := mediaStorage.Remove(objects...); err != nil {
+		report.addError("failed to delete media from storage: %v", err)
+	} else {
+		report.Deleted["storage_objects"] = int64(len(objects))
+	}
+}
+
+// eraseContactData removes the contact from the whatsmeow device store.
+// On PostgreSQL it shares the message database; with SQLite it lives in whatsmeow.db in the data directory.
+func (store *MessageStore) eraseContactData(jid string, report *ErasureReport) {
+	db := store.db
+	if !store.isPostgres {
+		var err error
+		db, err = sql.Open("sqlite3", sqliteDSN("whatsmeow.db"))
+		if err != nil {
+			report.addError("failed to open device store: %v", err)
+			return
+		}
+		defer db.Close()
+	}
+
+	for _, contactTable := range gdprContactTables {
+		exists, err := tableExists(db, store.isPostgres, contactTable.table)
+		if err != nil {
+			report.addError("failed to check %s: %v", contactTable.table, err)
+			continue
+		}
+		if !exists {
+			continue
+		}
+
+		conditions := make([]string, len(contactTable.columns))
+		args := make([]interface{}, len(contactTable.columns))
+		for i, column := range contactTable.columns {
+			if store.isPostgres {
+				conditions[i] = fmt.Sprintf("%s = $%d", column, i+1)
+			} else {
+				conditions[i] = column + " = ?"
+			}
+			args[i] = jid
+		}
+
+		result, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", contactTable.table, strings.Join(conditions, " OR ")), args...)
+		if err != nil {
+			report.addError("failed to delete from %s: %v", contactTable.table, err)
+			continue
+		}
+		report.Deleted[contactTable.table], _ = result.RowsAffected()
+	}
+}
+
+// registerGDPRRoutes registers the right-to-erasure API
+func registerGDPRRoutes(messageStore *MessageStore) {
+	handleAPI("/gdpr/erase", func(w http.ResponseWriter, r *http.Request) {
+		// Only allow POST requests
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req EraseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		jid, user, err := normalizeErasureTarget(req.Phone, req.JID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Erasure can't be undone, so make callers say so explicitly
+		if !req.Confirm {
+			http.Error(w, "Set \"confirm\": true to erase all data for this contact", http.StatusBadRequest)
+			return
+		}
+
+		report := messageStore.EraseContact(jid, user)
+
+		// Don't log the identifier that was just erased
+		fmt.Printf("GDPR erasure completed: %v (%d chats on legal hold kept, %d errors)\n", report.Deleted, len(report.Held), len(report.Errors))
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(report.Errors) > 0 {
+			w.WriteHeader(http.StatusMultiStatus)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// StoreSystemMessage records an event such as a participant change in a chat's history.
+// The chat is created if needed and its last activity moved forward. Data holds the event's
+// details for the group timeline and may be nil.
+func (store *MessageStore) StoreSystemMessage(chatJID, sender, eventType, content string, timestamp time.Time, data map[string]interface{}) error {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	timestamp = timestamp.UTC()
+
+	var details sql.NullString
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode event details: %v", err)
+		}
+		details = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	var chatQuery, messageQuery string
+	if store.isPostgres {
+		chatQuery = `INSERT INTO chats (jid, last_message_time) VALUES ($1, $2)
+		ON CONFLICT (jid) DO UPDATE SET last_message_time = GREATEST(chats.last_message_time, EXCLUDED.last_message_time)`
+		messageQuery = `INSERT INTO messages (id, chat_jid, sender, content, timestamp, is_from_me, system_event, system_data)
+		VALUES ($1, $2, $3, $4, $5, false, $6, $7) ON CONFLICT (id, chat_jid) DO NOTHING`
+	} else {
+		chatQuery = `INSERT INTO chats (jid, last_message_time) VALUES (?, ?)
+		ON CONFLICT (jid) DO UPDATE SET last_message_time = MAX(COALESCE(last_message_time, excluded.last_message_time), excluded.last_message_time)`
+		messageQuery = `INSERT OR IGNORE INTO messages (id, chat_jid, sender, content, timestamp, is_from_me, system_event, system_data)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?)`
+	}
+
+	if err := store.captureChat(chatJID, func() error {
+		_, err := store.db.Exec(chatQuery, chatJID, timestamp)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to update chat: %v", err)
+	}
+	invalidateCache(cacheKeyChatList, cacheKeyChatMap)
+
+	// Derived from the event so redelivered notifications don't create duplicates.
+	// One notification can change several settings, so those are told apart by name.
+	key := eventType
+	if setting, ok := data["setting"].(string); ok {
+		key += "-" + setting
+	}
+	id := fmt.Sprintf("system-%s-%d", key, timestamp.UnixNano())
+	if err := store.captureMessage(id, chatJID, func() error {
+		_, err := store.db.Exec(messageQuery, id, chatJID, sender, content, timestamp, eventType, details)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to store system message: %v", err)
+	}
+	if hashChainEnabled {
+		if err := store.chainMessage(id, chatJID, nil); err != nil {
+			return fmt.Errorf("failed to add system message to hash chain: %v", err)
+		}
+	}
+	return nil
+}
+
+// RenameChat updates the stored name of a chat
+func (store *MessageStore) RenameChat(chatJID, name string) error {
+	query := "UPDATE chats SET name = ? WHERE jid = ?"
+	if store.isPostgres {
+		query = "UPDATE chats SET name = $1 WHERE jid = $2"
+	}
+	if err := store.captureChat(chatJID, func() error {
+		_, err := store.db.Exec(query, name, chatJID)
+		return err
+	}); err != nil {
+		return err
+	}
+	chatStored(chatJID, name)
+	return nil
+}
+
+// chatExists reports whether a chat is already stored
+func (store *MessageStore) chatExists(chatJID string) bool {
+	query := "SELECT 1 FROM chats WHERE jid = ?"
+	if store.isPostgres {
+		query = "SELECT 1 FROM chats WHERE jid = $1"
+	}
+	var exists int
+	return store.db.QueryRow(query, chatJID).Scan(&exists) == nil
+}
+
+// recordSystemEvent stores a system message and publishes the matching event
+func recordSystemEvent(messageStore *MessageStore, logger waLog.Logger, chatJID, sender, eventType, content string, timestamp time.Time, data map[string]interface{}) {
+	if err := messageStore.StoreSystemMessage(chatJID, sender, eventType, content, timestamp, data); err != nil {
+		logger.Warnf("Failed to store %s event: %v", eventType, err)
+	}
+	publishEvent(eventType, chatJID, timestamp, data)
+}
+
+// jidStrings converts JIDs to their string form
+func jidStrings(jids []types.JID) []string {
+	out := make([]string, len(jids))
+	for i, jid := range jids {
+		out[i] = jid.String()
+	}
+	return out
+}
+
+// jidUsers joins the phone numbers of JIDs for system message text
+func jidUsers(jids []types.JID) string {
+	users := make([]string, len(jids))
+	for i, jid := range jids {
+		users[i] = jid.User
+	}
+	return strings.Join(users, ", ")
+}
+
+// handleGroupInfo stores participant, subject and description changes of a group
+func handleGroupInfo(messageStore *MessageStore, evt *events.GroupInfo, logger waLog.Logger) {
+	chatJID := evt.JID.String()
+	invalidateCache(cacheKeyJoinedGroups)
+	// Senders are stored as phone numbers like regular messages; events carry the full JID
+	actor, sender, actorUser := "", "", "Someone"
+	if evt.Sender != nil {
+		actor, sender, actorUser = evt.Sender.String(), evt.Sender.User, evt.Sender.User
+	}
+
+	// A change made by the participant themselves is a join or leave rather than an add or removal
+	selfChange := func(jids []types.JID) bool {
+		return evt.Sender != nil && len(jids) == 1 && jids[0].User == evt.Sender.User
+	}
+
+	if len(evt.Join) > 0 {
+		content := fmt.Sprintf("%s added %s", actorUser, jidUsers(evt.Join))
+		if selfChange(evt.Join) || evt.JoinReason == "invite" {
+			content = fmt.Sprintf("%s joined", jidUsers(evt.Join))
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupParticipantsAdded, content, evt.Timestamp, map[string]interface{}{
+			"author":       actor,
+			"participants": jidStrings(evt.Join),
+			"reason":       evt.JoinReason,
+		})
+	}
+
+	if len(evt.Leave) > 0 {
+		content := fmt.Sprintf("%s removed %s", actorUser, jidUsers(evt.Leave))
+		if selfChange(evt.Leave) {
+			content = fmt.Sprintf("%s left", jidUsers(evt.Leave))
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupParticipantsRemoved, content, evt.Timestamp, map[string]interface{}{
+			"author":       actor,
+			"participants": jidStrings(evt.Leave),
+		})
+	}
+
+	if len(evt.Promote) > 0 {
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupParticipantsPromoted,
+			fmt.Sprintf("%s made %s admin", actorUser, jidUsers(evt.Promote)), evt.Timestamp, map[string]interface{}{
+				"author":       actor,
+				"participants": jidStrings(evt.Promote),
+			})
+	}
+
+	if len(evt.Demote) > 0 {
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupParticipantsDemoted,
+			fmt.Sprintf("%s dismissed %s as admin", actorUser, jidUsers(evt.Demote)), evt.Timestamp, map[string]interface{}{
+				"author":       actor,
+				"participants": jidStrings(evt.Demote),
+			})
+	}
+
+	if evt.Name != nil {
+		if err := messageStore.RenameChat(chatJID, evt.Name.Name); err != nil {
+			logger.Warnf("Failed to rename group %s: %v", chatJID, err)
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupSubjectChanged,
+			fmt.Sprintf("%s changed the subject to \"%s\"", actorUser, evt.Name.Name), evt.Timestamp, map[string]interface{}{
+				"author":  actor,
+				"subject": evt.Name.Name,
+			})
+	}
+
+	if evt.Topic != nil {
+		content := fmt.Sprintf("%s changed the group description", actorUser)
+		if evt.Topic.TopicDeleted {
+			content = fmt.Sprintf("%s deleted the group description", actorUser)
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupDescriptionChanged, content, evt.Timestamp, map[string]interface{}{
+			"author":      actor,
+			"description": evt.Topic.Topic,
+			"deleted":     evt.Topic.TopicDeleted,
+		})
+	}
+
+	if evt.Announce != nil {
+		content := fmt.Sprintf("%s allowed all participants to send messages", actorUser)
+		if evt.Announce.IsAnnounce {
+			content = fmt.Sprintf("%s allowed only admins to send messages", actorUser)
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupSettingsChanged, content, evt.Timestamp, map[string]interface{}{
+			"author":  actor,
+			"setting": "announce",
+			"value":   strconv.FormatBool(evt.Announce.IsAnnounce),
+		})
+	}
+
+	if evt.Locked != nil {
+		content := fmt.Sprintf("%s allowed all participants to edit group info", actorUser)
+		if evt.Locked.IsLocked {
+			content = fmt.Sprintf("%s allowed only admins to edit group info", actorUser)
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupSettingsChanged, content, evt.Timestamp, map[string]interface{}{
+			"author":  actor,
+			"setting": "locked",
+			"value":   strconv.FormatBool(evt.Locked.IsLocked),
+		})
+	}
+
+	if evt.Ephemeral != nil {
+		content := fmt.Sprintf("%s turned off disappearing messages", actorUser)
+		value := "0"
+		if evt.Ephemeral.IsEphemeral {
+			value = strconv.FormatUint(uint64(evt.Ephemeral.DisappearingTimer), 10)
+			content = fmt.Sprintf("%s turned on disappearing messages (%s)", actorUser, disappearingTimerText(evt.Ephemeral.DisappearingTimer))
+		}
+		recordSystemEvent(messageStore, logger, chatJID, sender, EventGroupSettingsChanged, content, evt.Timestamp, map[string]interface{}{
+			"author":  actor,
+			"setting": "ephemeral",
+			"value":   value,
+		})
+	}
+}
+
+// disappearingTimerText describes a disappearing message timer such as 86400 as "24 hours" or "7 days"
+func disappearingTimerText(seconds uint32) string {
+	switch {
+	case seconds > 86400 && seconds%86400 == 0:
+		return fmt.Sprintf("%d days", seconds/86400)
+	case seconds >= 3600 && seconds%3600 == 0:
+		return fmt.Sprintf("%d hours", seconds/3600)
+	default:
+		return (time.Duration(seconds) * time.Second).String()
+	}
+}
+
+// handlePicture stores profile picture and group icon changes
+func handlePicture(messageStore *MessageStore, evt *events.Picture, logger waLog.Logger) {
+	chatJID := evt.JID.String()
+	invalidateCache(cacheKeyChatAvatar + chatJID)
+
+	data := map[string]interface{}{
+		"jid":        chatJID,
+		"author":     evt.Author.String(),
+		"removed":    evt.Remove,
+		"picture_id": evt.PictureID,
+	}
+
+	if evt.JID.Server == types.GroupServer {
+		content := fmt.Sprintf("%s changed the group icon", evt.Author.User)
+		if evt.Remove {
+			content = fmt.Sprintf("%s removed the group icon", evt.Author.User)
+		}
+		recordSystemEvent(messageStore, logger, chatJID, evt.Author.User, EventGroupIconChanged, content, evt.Timestamp, data)
+		return
+	}
+
+	// Contacts change pictures all the time; only note it in chats we already have
+	if messageStore.chatExists(chatJID) {
+		content := fmt.Sprintf("%s changed their profile picture", evt.JID.User)
+		if err := messageStore.StoreSystemMessage(chatJID, evt.JID.User, EventContactPictureChanged, content, evt.Timestamp, nil); err != nil {
+			logger.Warnf("Failed to store %s event: %v", EventContactPictureChanged, err)
+		}
+	}
+	publishEvent(EventContactPictureChanged, chatJID, evt.Timestamp, data)
+}
+
+// handlePushName stores contact display name changes
+func handlePushName(messageStore *MessageStore, evt *events.PushName, logger waLog.Logger) {
+	jid := evt.JID.ToNonAD()
+	chatJID := jid.String()
+
+	var timestamp time.Time
+	if evt.Message != nil {
+		timestamp = evt.Message.Timestamp
+	}
+
+	data := map[string]interface{}{
+		"jid":      chatJID,
+		"old_name": evt.OldPushName,
+		"new_name": evt.NewPushName,
+	}
+
+	// Only note it in chats we already have, as group members we never talk to change names too
+	if evt.OldPushName != "" && messageStore.chatExists(chatJID) {
+		content := fmt.Sprintf("%s changed their name to %s", evt.OldPushName, evt.NewPushName)
+		if err := messageStore.StoreSystemMessage(chatJID, jid.User, EventContactPushNameChanged, content, timestamp, nil); err != nil {
+			logger.Warnf("Failed to store %s event: %v", EventContactPushNameChanged, err)
+		}
+	}
+	publishEvent(EventContactPushNameChanged, chatJID, timestamp, data)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// GroupTimelineEvent is a membership change, subject change or admin action in a group
+type GroupTimelineEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	// Actor is the phone number of who made the change, if known
+	Actor string `json:"actor,omitempty"`
+	// Text is the system message shown in the chat
+	Text string `json:"text"`
+
+	// Details recorded with the event; events stored before they were recorded only have the text
+	Author       string   `json:"author,omitempty"`
+	Participants []string `json:"participants,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	Subject      string   `json:"subject,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Deleted      bool     `json:"deleted,omitempty"`
+	Setting      string   `json:"setting,omitempty"`
+	Value        string   `json:"value,omitempty"`
+	Removed      bool     `json:"removed,omitempty"`
+	Action       string   `json:"action,omitempty"`
+	MessageID    string   `json:"message_id,omitempty"`
+}
+
+// GroupTimelineFilter narrows a group timeline
+type GroupTimelineFilter struct {
+	From  time.Time
+	To    time.Time
+	Types []string
+	// Participant matches events made by or affecting a phone number
+	Participant string
+	Limit       int
+}
+
+// GetGroupTimeline returns the stored group events of a group, newest first
+func (store *MessageStore) GetGroupTimeline(groupJID string, filter GroupTimelineFilter) ([]GroupTimelineEvent, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	conditions = append(conditions, "chat_jid = "+arg(groupJID), "system_event LIKE 'group.%'")
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(filter.From.UTC()))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "timestamp <= "+arg(filter.To.UTC()))
+	}
+	if len(filter.Types) > 0 {
+		placeholders := make([]string, len(filter.Types))
+		for i, eventType := range filter.Types {
+			placeholders[i] = arg(eventType)
+		}
+		conditions = append(conditions, "system_event IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.Participant != "" {
+		// Participants are stored as JIDs in the event details
+		conditions = append(conditions, fmt.Sprintf("(sender = %s OR system_data LIKE %s)",
+			arg(filter.Participant), arg("%\""+filter.Participant+"@%")))
+	}
+
+	query := fmt.Sprintf("SELECT id, COALESCE(sender, ''), COALESCE(content, ''), timestamp, system_event, system_data FROM messages WHERE %s ORDER BY timestamp DESC LIMIT %s",
+		strings.Join(conditions, " AND "), arg(filter.Limit))
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timeline := []GroupTimelineEvent{}
+	for rows.Next() {
+		var event GroupTimelineEvent
+		var data sql.NullString
+		if err := rows.Scan(&event.ID, &event.Actor, &event.Text, &event.Timestamp, &event.Type, &data); err != nil {
+			return nil, err
+		}
+		if data.String != "" {
+			// The details use the same field names as the timeline
+			if err := json.Unmarshal([]byte(data.String), &event); err != nil {
+				return nil, fmt.Errorf("invalid details of group event %s: %v", event.ID, err)
+			}
+		}
+		timeline = append(timeline, event)
+	}
+	return timeline, rows.Err()
+}
+
+// registerGroupTimelineRoutes registers /api/v1/groups/{jid}/events
+func registerGroupTimelineRoutes(messageStore *MessageStore) {
+	registerGroupRoute("events", func(w http.ResponseWriter, r *http.Request, groupJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !strings.HasSuffix(groupJID, "@"+types.GroupServer) {
+			http.Error(w, "Not a group JID", http.StatusBadRequest)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter := GroupTimelineFilter{Limit: 100, Participant: strings.TrimPrefix(r.URL.Query().Get("participant"), "+")}
+		if value := r.URL.Query().Get("from"); value != "" {
+			if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid from parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("to"); value != "" {
+			if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid to parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("type"); value != "" {
+			// Accept both group.subject_changed and subject_changed
+			for _, eventType := range strings.Split(value, ",") {
+				filter.Types = append(filter.Types, "group."+strings.TrimPrefix(strings.TrimSpace(eventType), "group."))
+			}
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			filter.Limit, err = strconv.Atoi(value)
+			if err != nil || filter.Limit < 1 || filter.Limit > 1000 {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+
+		timeline, err := messageStore.GetGroupTimeline(groupJID, filter)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get group events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range timeline {
+			timeline[i].Timestamp = timeline[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(timeline)
+	})
+}
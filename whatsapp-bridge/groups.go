@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// groupIDPattern matches bare group IDs: the current numeric IDs, which are longer than any
+// phone number, and the older creator-timestamp IDs such as 447700900000-1618033988
+var groupIDPattern = regexp.MustCompile(`^(\d{16,}|\d{5,15}-\d{9,10})$`)
+
+// APIGroup is a group the account is in, as listed by /api/v1/groups
+type APIGroup struct {
+	JID              string `json:"jid"`
+	Name             string `json:"name"`
+	ParticipantCount int    `json:"participant_count"`
+	IsAdmin          bool   `json:"is_admin"`
+	// AdminsOnly is set when only admins can send messages to the group
+	AdminsOnly bool `json:"admins_only"`
+}
+
+// parseRecipient turns the recipient of a send into a JID: a full JID, a bare group ID,
+// or otherwise a phone number
+func parseRecipient(recipient string) (types.JID, error) {
+	if strings.Contains(recipient, "@") {
+		return types.ParseJID(recipient)
+	}
+	if groupIDPattern.MatchString(recipient) {
+		return types.NewJID(recipient, types.GroupServer), nil
+	}
+	return types.NewJID(recipient, types.DefaultUserServer), nil
+}
+
+// isOwnUser reports whether a phone number or LID user is the account's own
+func isOwnUser(client *whatsmeow.Client, user string) bool {
+	if client.Store.ID != nil && user == client.Store.ID.User {
+		return true
+	}
+	return !client.Store.LID.IsEmpty() && user == client.Store.LID.User
+}
+
+// findJoinedGroup looks a group up in the account's groups, returning nil if it isn't one of them
+func findJoinedGroup(client *whatsmeow.Client, jid string, load func(*whatsmeow.Client) ([]joinedGroup, error)) (*joinedGroup, error) {
+	groups, err := load(client)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if groups[i].JID == jid {
+			return &groups[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// checkGroupRecipient makes sure the account can write to a group, so sends to a group it left
+// or where only admins can write fail with a clear code. Lookup errors let the send go ahead.
+func checkGroupRecipient(client *whatsmeow.Client, recipient types.JID, account string) (string, string) {
+	if recipient.Server != types.GroupServer {
+		return "", ""
+	}
+
+	// The cache holds the default account's groups; additional accounts ask every time
+	if account != "" {
+		group, err := findJoinedGroup(client, recipient.String(), loadJoinedGroups)
+		return groupSendRefusal(recipient, group, err)
+	}
+	group, err := findJoinedGroup(client, recipient.String(), getJoinedGroups)
+	if err == nil && group == nil {
+		// A group joined moments ago is only in a fresh list
+		invalidateCache(cacheKeyJoinedGroups)
+		group, err = findJoinedGroup(client, recipient.String(), getJoinedGroups)
+	}
+	return groupSendRefusal(recipient, group, err)
+}
+
+// groupSendRefusal explains why the account can't write to a group it looked up, if it can't
+func groupSendRefusal(recipient types.JID, group *joinedGroup, err error) (string, string) {
+	switch {
+	case err != nil:
+		return "", ""
+	case group == nil:
+		return fmt.Sprintf("The account is not a member of group %s", recipient.User), SendErrNotGroupMember
+	case group.Announce && !group.SelfAdmin:
+		return fmt.Sprintf("Only admins can send messages to %s", group.Name), SendErrGroupAdminsOnly
+	}
+	return "", ""
+}
+
+// registerGroupRoutes registers /api/v1/groups
+func registerGroupRoutes(client *whatsmeow.Client) {
+	handleAPI("/groups", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !client.IsConnected() {
+			http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+			return
+		}
+
+		joined, err := getJoinedGroups(client)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list groups: %v", err), http.StatusBadGateway)
+			return
+		}
+		groups := make([]APIGroup, 0, len(joined))
+		for _, group := range joined {
+			groups = append(groups, APIGroup{
+				JID:              group.JID,
+				Name:             group.Name,
+				ParticipantCount: group.ParticipantCount,
+				IsAdmin:          group.SelfAdmin,
+				AdminsOnly:       group.Announce,
+			})
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// leaderElector is set when HA_MODE is enabled; nil means this is the only replica
+var leaderElector *LeaderElector
+
+// LeaderElector decides which of several replicas sharing a PostgreSQL store holds the WhatsApp socket.
+// The leader holds a session-level advisory lock on a dedicated connection; if the leader dies its
+// connection drops, PostgreSQL releases the lock and the next follower to poll takes over.
+type LeaderElector struct {
+	db       *sql.DB
+	key      int64
+	interval time.Duration
+	logger   waLog.Logger
+	conn     *sql.Conn
+	leader   atomic.Bool
+}
+
+// NewLeaderElectorFromEnv returns nil unless HA_MODE is enabled, which requires PostgreSQL
+func NewLeaderElectorFromEnv(store *MessageStore, logger waLog.Logger) (*LeaderElector, error) {
+	if !getEnvBool("HA_MODE", false) {
+		return nil, nil
+	}
+	if !store.isPostgres {
+		return nil, fmt.Errorf("HA_MODE requires DATABASE_URL to point at a shared PostgreSQL database")
+	}
+
+	// Replicas of different bridges on the same database use different lock names
+	lockName := os.Getenv("HA_LOCK_NAME")
+	if lockName == "" {
+		lockName = "whatsapp-bridge"
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(lockName))
+
+	return &LeaderElector{
+		db:       store.db,
+		key:      int64(hash.Sum64()),
+		interval: time.Duration(getEnvInt("HA_LOCK_INTERVAL_SECONDS", 5)) * time.Second,
+		logger:   logger,
+	}, nil
+}
+
+// IsLeader reports whether this replica currently holds the lock
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// AwaitLeadership blocks until this replica acquires the lock
+func (e *LeaderElector) AwaitLeadership(ctx context.Context) error {
+	e.logger.Infof("HA mode: waiting for leadership, serving read traffic meanwhile")
+	for {
+		acquired, err := e.tryAcquire(ctx)
+		if err != nil {
+			e.logger.Warnf("HA mode: failed to check leader lock: %v", err)
+		} else if acquired {
+			e.leader.Store(true)
+			e.logger.Infof("HA mode: became leader")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// tryAcquire attempts to take the advisory lock on a connection held for as long as we lead
+func (e *LeaderElector) tryAcquire(ctx context.Context) (bool, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	e.conn = conn
+	return true, nil
+}
+
+// MonitorLeadership checks the lock connection until it fails, then calls onLost.
+// Losing the connection means PostgreSQL may already have handed the lock to another replica.
+func (e *LeaderElector) MonitorLeadership(ctx context.Context, onLost func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, e.interval)
+		err := e.conn.PingContext(pingCtx)
+		cancel()
+		if err != nil {
+			e.leader.Store(false)
+			e.logger.Errorf("HA mode: lost leader lock connection: %v", err)
+			onLost()
+			return
+		}
+	}
+}
+
+// replicaRole describes this replica for health responses
+func replicaRole() string {
+	if leaderElector == nil {
+		return "standalone"
+	}
+	if leaderElector.IsLeader() {
+		return "leader"
+	}
+	return "follower"
+}
+
+// leaderOnly rejects requests that need the WhatsApp socket when this replica is a follower,
+// so a load balancer or client can retry them against the leader
+func leaderOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if leaderElector != nil && !leaderElector.IsLeader() {
+			w.Header().Set("X-Bridge-Role", "follower")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "This replica is a follower; send this request to the leader", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Handoff triggers; manual pauses come from /api/v1/chats/{jid}/bot
+const (
+	HandoffKeyword  = "keyword"
+	HandoffFallback = "fallback"
+	HandoffManual   = "manual"
+)
+
+// botPauseColumns are the columns scanBotPause reads, in order
+const botPauseColumns = "chat_jid, trigger, keyword, paused_at, resume_at"
+
+// botPauseSweepInterval is how often pauses whose timeout passed are lifted
+const botPauseSweepInterval = time.Minute
+
+// BotPause is a chat the bot stopped answering because a human was asked for or took over. Flows,
+// routing rules and classification skip its messages until an agent resumes the bot, or until
+// ResumeAt if the pause has a timeout.
+type BotPause struct {
+	ChatJID  string     `json:"chat_jid"`
+	Name     string     `json:"name,omitempty"`
+	Trigger  string     `json:"trigger"`
+	Keyword  string     `json:"keyword,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+}
+
+// BotStatus is the response of /api/v1/chats/{jid}/bot
+type BotStatus struct {
+	ChatJID  string     `json:"chat_jid"`
+	Paused   bool       `json:"paused"`
+	Trigger  string     `json:"trigger,omitempty"`
+	Keyword  string     `json:"keyword,omitempty"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+}
+
+// SetBotRequest pauses or resumes the bot in a chat; ResumeAfter (e.g. 30m, 8h or 7d) lifts a
+// pause by itself
+type SetBotRequest struct {
+	Paused      *bool  `json:"paused"`
+	ResumeAfter string `json:"resume_after,omitempty"`
+}
+
+// Handoff hands chats to a human when the contact asks for one or the bot keeps failing to understand them
+type Handoff struct {
+	keywords      *regexp.Regexp
+	fallbackLimit int
+	reply         string
+	queue         string
+	client        *whatsmeow.Client
+	messageStore  *MessageStore
+	logger        waLog.Logger
+	fallbacks     map[string]int
+	mutex         sync.Mutex
+}
+
+// chatHandoff detects handoffs and keeps paused chats away from the bot
+var chatHandoff *Handoff
+
+// NewHandoffFromEnv reads HANDOFF_KEYWORDS, HANDOFF_FALLBACK_LIMIT, HANDOFF_REPLY and HANDOFF_QUEUE.
+// Without keywords or a fallback limit nothing triggers a handoff, but paused chats stay paused.
+func NewHandoffFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*Handoff, error) {
+	handoff := &Handoff{
+		fallbackLimit: getEnvInt("HANDOFF_FALLBACK_LIMIT", 0),
+		reply:         strings.TrimSpace(os.Getenv("HANDOFF_REPLY")),
+		queue:         strings.TrimSpace(os.Getenv("HANDOFF_QUEUE")),
+		client:        client,
+		messageStore:  messageStore,
+		logger:        logger,
+		fallbacks:     make(map[string]int),
+	}
+	if handoff.fallbackLimit < 0 {
+		return nil, fmt.Errorf("HANDOFF_FALLBACK_LIMIT must not be negative")
+	}
+
+	// Keywords match whole words, case-insensitively, like those of routing rules
+	var alternatives []string
+	for _, keyword := range splitEnvList("HANDOFF_KEYWORDS") {
+		alternatives = append(alternatives, `\b`+regexp.QuoteMeta(keyword)+`\b`)
+	}
+	if len(alternatives) > 0 {
+		handoff.keywords = regexp.MustCompile("(?i)" + strings.Join(alternatives, "|"))
+	}
+
+	if handoff.keywords != nil || handoff.fallbackLimit > 0 {
+		logger.Infof("Handing chats to agents on %d keywords and after %d fallbacks", len(alternatives), handoff.fallbackLimit)
+	}
+	return handoff, nil
+}
+
+// Paused reports whether the bot is paused in a chat
+func (h *Handoff) Paused(chatJID string) bool {
+	if h == nil {
+		return false
+	}
+	pause, err := h.messageStore.GetBotPause(chatJID)
+	if err != nil {
+		h.logger.Warnf("Failed to check bot pause of %s: %v", logRedactor.Phone(chatJID), err)
+	}
+	return pause != nil
+}
+
+// HandleIncoming hands a direct chat over when the message asks for a human, ending its flow
+func (h *Handoff) HandleIncoming(msg IncomingMessage) bool {
+	if h == nil || h.keywords == nil || msg.IsGroup {
+		return false
+	}
+	keyword := h.keywords.FindString(msg.Content)
+	if keyword == "" {
+		return false
+	}
+
+	if flowEngine != nil {
+		if err := flowEngine.Cancel(msg.ChatJID); err != nil {
+			h.logger.Warnf("Failed to cancel flow for handoff: %v", err)
+		}
+	}
+	if err := h.Trigger(msg.ChatJID, HandoffKeyword, strings.ToLower(keyword)); err != nil {
+		h.logger.Warnf("Handoff of %s failed: %v", logRedactor.Phone(msg.ChatJID), err)
+	}
+	return true
+}
+
+// Fallback counts an answer the bot couldn't handle and reports whether the chat reached the
+// fallback limit, which starts the count over
+func (h *Handoff) Fallback(chatJID string) bool {
+	if h == nil || h.fallbackLimit == 0 {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.fallbacks[chatJID]++
+	if h.fallbacks[chatJID] < h.fallbackLimit {
+		return false
+	}
+	delete(h.fallbacks, chatJID)
+	return true
+}
+
+// Understood starts the fallback count of a chat over, as the bot handled its last message
+func (h *Handoff) Understood(chatJID string) {
+	if h == nil || h.fallbackLimit == 0 {
+		return
+	}
+	h.mutex.Lock()
+	delete(h.fallbacks, chatJID)
+	h.mutex.Unlock()
+}
+
+// Start lifts pauses once their timeout passes, publishing chat.bot_resumed for each
+func (h *Handoff) Start() {
+	go func() {
+		for {
+			time.Sleep(botPauseSweepInterval)
+			lapsed, err := h.messageStore.LapsedBotPauses(time.Now().UTC())
+			if err != nil {
+				h.logger.Warnf("Failed to list lapsed bot pauses: %v", err)
+				continue
+			}
+			for _, chatJID := range lapsed {
+				if _, err := h.resume(chatJID, "timeout"); err != nil {
+					h.logger.Warnf("Failed to resume bot in %s: %v", logRedactor.Phone(chatJID), err)
+				}
+			}
+		}
+	}()
+}
+
+// Trigger pauses the bot in a chat, tells the contact and assigns the chat to the handoff queue,
+// and publishes chat.handoff. A chat that is already paused is left as it is.
+func (h *Handoff) Trigger(chatJID, trigger, keyword string) error {
+	paused, err := h.messageStore.PauseBot(&BotPause{ChatJID: chatJID, Trigger: trigger, Keyword: keyword, PausedAt: time.Now().UTC()}, false)
+	if err != nil || !paused {
+		return err
+	}
+	h.Understood(chatJID)
+
+	if h.queue != "" {
+		assigned, err := h.messageStore.AssignChat(chatJID, h.queue, "handoff", false)
+		if err != nil {
+			h.logger.Warnf("Failed to assign chat to %s: %v", h.queue, err)
+		} else if assigned {
+			publishEvent(EventChatAssigned, chatJID, time.Time{}, map[string]interface{}{
+				"chat_jid": chatJID,
+				"queue":    h.queue,
+				"rule":     "handoff",
+			})
+		}
+	}
+
+	if h.reply != "" && !receiveOnlyMode {
+		noSignature := false
+		success, result, _, _ := sendWhatsAppMessage(h.client, chatJID, h.reply, "", SendOptions{Agent: "handoff", Signature: &noSignature}, h.messageStore)
+		if !success {
+			h.logger.Warnf("Handoff reply failed: %s", result)
+		}
+	}
+
+	publishEvent(EventChatHandoff, chatJID, time.Time{}, map[string]interface{}{
+		"chat_jid": chatJID,
+		"trigger":  trigger,
+		"keyword":  keyword,
+		"queue":    h.queue,
+	})
+	return nil
+}
+
+// Pause stops the bot in a chat for an agent, until resumeAt if set, replacing an earlier pause.
+// The chat's flow ends and chat.bot_paused is published.
+func (h *Handoff) Pause(chatJID string, resumeAt *time.Time) (*BotPause, error) {
+	if flowEngine != nil {
+		if err := flowEngine.Cancel(chatJID); err != nil {
+			return nil, fmt.Errorf("failed to cancel flow: %v", err)
+		}
+	}
+
+	pause := &BotPause{ChatJID: chatJID, Trigger: HandoffManual, PausedAt: time.Now().UTC(), ResumeAt: resumeAt}
+	if _, err := h.messageStore.PauseBot(pause, true); err != nil {
+		return nil, err
+	}
+	h.Understood(chatJID)
+
+	publishEvent(EventChatBotPaused, chatJID, time.Time{}, map[string]interface{}{
+		"chat_jid":  chatJID,
+		"resume_at": resumeAt,
+	})
+	return pause, nil
+}
+
+// Resume lets the bot answer a paused chat again and publishes chat.bot_resumed; it reports
+// whether the chat was paused
+func (h *Handoff) Resume(chatJID string) (bool, error) {
+	return h.resume(chatJID, "api")
+}
+
+// resume lifts the pause of a chat, for an agent ("api") or because its timeout passed ("timeout")
+func (h *Handoff) resume(chatJID, reason string) (bool, error) {
+	resumed, err := h.messageStore.ResumeBot(chatJID)
+	if err != nil || !resumed {
+		return false, err
+	}
+	h.Understood(chatJID)
+
+	publishEvent(EventChatBotResumed, chatJID, time.Time{}, map[string]interface{}{
+		"chat_jid": chatJID,
+		"reason":   reason,
+	})
+	return true, nil
+}
+
+// PauseBot pauses the bot in a chat and reports whether it wasn't paused already. Unless replace
+// is set, a pause in place is kept; one whose timeout passed is always replaced.
+func (store *MessageStore) PauseBot(pause *BotPause, replace bool) (bool, error) {
+	var query string
+	switch {
+	case store.isPostgres && replace:
+		query = `INSERT INTO bot_pauses (chat_jid, trigger, keyword, paused_at, resume_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_jid) DO UPDATE SET trigger = EXCLUDED.trigger, keyword = EXCLUDED.keyword, paused_at = EXCLUDED.paused_at, resume_at = EXCLUDED.resume_at`
+	case store.isPostgres:
+		query = `INSERT INTO bot_pauses (chat_jid, trigger, keyword, paused_at, resume_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_jid) DO UPDATE SET trigger = EXCLUDED.trigger, keyword = EXCLUDED.keyword, paused_at = EXCLUDED.paused_at, resume_at = EXCLUDED.resume_at
+		WHERE bot_pauses.resume_at <= EXCLUDED.paused_at`
+	case replace:
+		query = `INSERT INTO bot_pauses (chat_jid, trigger, keyword, paused_at, resume_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET trigger = excluded.trigger, keyword = excluded.keyword, paused_at = excluded.paused_at, resume_at = excluded.resume_at`
+	default:
+		query = `INSERT INTO bot_pauses (chat_jid, trigger, keyword, paused_at, resume_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET trigger = excluded.trigger, keyword = excluded.keyword, paused_at = excluded.paused_at, resume_at = excluded.resume_at
+		WHERE bot_pauses.resume_at <= excluded.paused_at`
+	}
+
+	result, err := store.db.Exec(query, pause.ChatJID, pause.Trigger, pause.Keyword, pause.PausedAt, pause.ResumeAt)
+	if err != nil {
+		return false, err
+	}
+	added, _ := result.RowsAffected()
+	return added > 0, nil
+}
+
+// ResumeBot removes the pause of a chat and reports whether the bot was paused there
+func (store *MessageStore) ResumeBot(chatJID string) (bool, error) {
+	query := "DELETE FROM bot_pauses WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM bot_pauses WHERE chat_jid = $1"
+	}
+	result, err := store.db.Exec(query, chatJID)
+	if err != nil {
+		return false, err
+	}
+	removed, _ := result.RowsAffected()
+	return removed > 0, nil
+}
+
+// scanBotPause reads a row of botPauseColumns
+func scanBotPause(row interface{ Scan(...interface{}) error }) (*BotPause, error) {
+	var pause BotPause
+	var keyword sql.NullString
+	var resumeAt sql.NullTime
+	if err := row.Scan(&pause.ChatJID, &pause.Trigger, &keyword, &pause.PausedAt, &resumeAt); err != nil {
+		return nil, err
+	}
+	pause.Keyword = keyword.String
+	if resumeAt.Valid {
+		pause.ResumeAt = &resumeAt.Time
+	}
+	return &pause, nil
+}
+
+// localize converts the times of a pause to a response's time zone
+func (pause *BotPause) localize(loc *time.Location) {
+	pause.PausedAt = pause.PausedAt.In(loc)
+	if pause.ResumeAt != nil {
+		resumeAt := pause.ResumeAt.In(loc)
+		pause.ResumeAt = &resumeAt
+	}
+}
+
+// GetBotPause returns the pause of a chat, or nil if the bot isn't paused there now
+func (store *MessageStore) GetBotPause(chatJID string) (*BotPause, error) {
+	query := "SELECT " + botPauseColumns + " FROM bot_pauses WHERE chat_jid = ? AND (resume_at IS NULL OR resume_at > ?)"
+	if store.isPostgres {
+		query = "SELECT " + botPauseColumns + " FROM bot_pauses WHERE chat_jid = $1 AND (resume_at IS NULL OR resume_at > $2)"
+	}
+	pause, err := scanBotPause(store.db.QueryRow(query, chatJID, time.Now().UTC()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return pause, err
+}
+
+// LapsedBotPauses returns the chats whose pause timed out by now
+func (store *MessageStore) LapsedBotPauses(now time.Time) ([]string, error) {
+	query := "SELECT chat_jid FROM bot_pauses WHERE resume_at <= ?"
+	if store.isPostgres {
+		query = "SELECT chat_jid FROM bot_pauses WHERE resume_at <= $1"
+	}
+	rows, err := store.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatJIDs []string
+	for rows.Next() {
+		var chatJID string
+		if err := rows.Scan(&chatJID); err != nil {
+			return nil, err
+		}
+		chatJIDs = append(chatJIDs, chatJID)
+	}
+	return chatJIDs, rows.Err()
+}
+
+// ListBotPauses returns the chats where the bot is paused now, longest waiting first
+func (store *MessageStore) ListBotPauses() ([]BotPause, error) {
+	query := "SELECT " + botPauseColumns + " FROM bot_pauses WHERE resume_at IS NULL OR resume_at > ? ORDER BY paused_at"
+	if store.isPostgres {
+		query = "SELECT " + botPauseColumns + " FROM bot_pauses WHERE resume_at IS NULL OR resume_at > $1 ORDER BY paused_at"
+	}
+	rows, err := store.db.Query(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := []BotPause{}
+	for rows.Next() {
+		pause, err := scanBotPause(rows)
+		if err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, *pause)
+	}
+	return pauses, rows.Err()
+}
+
+// registerHandoffRoutes registers /api/v1/handoffs, /api/v1/chats/{jid}/handoff and /api/v1/chats/{jid}/bot
+func registerHandoffRoutes(messageStore *MessageStore) {
+	handleAPI("/handoffs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pauses, err := messageStore.ListBotPauses()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list handoffs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		names, err := messageStore.chatNames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get chat names: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range pauses {
+			pauses[i].Name = names[pauses[i].ChatJID]
+			pauses[i].localize(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pauses)
+	})
+
+	registerChatRoute("handoff", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		switch r.Method {
+		case http.MethodGet:
+			loc, err := requestLocation(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pause, err := messageStore.GetBotPause(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get handoff: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if pause == nil {
+				http.Error(w, "Chat has no handoff", http.StatusNotFound)
+				return
+			}
+			pause.localize(loc)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(pause)
+
+		case http.MethodDelete:
+			// The agent is done and hands the chat back to the bot
+			resumed, err := chatHandoff.Resume(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to resume bot: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if !resumed {
+				http.Error(w, "Chat has no handoff", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	registerChatRoute("bot", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		serveChatBot(w, r, chatJID, messageStore)
+	})
+}
+
+// serveChatBot handles /api/v1/chats/{jid}/bot
+func serveChatBot(w http.ResponseWriter, r *http.Request, chatJID string, messageStore *MessageStore) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pause *BotPause
+	switch r.Method {
+	case http.MethodGet:
+		pause, err = messageStore.GetBotPause(chatJID)
+
+	case http.MethodPut:
+		var req SetBotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Paused == nil {
+			http.Error(w, "paused is required", http.StatusBadRequest)
+			return
+		}
+		if !*req.Paused {
+			if req.ResumeAfter != "" {
+				http.Error(w, "resume_after only applies when pausing", http.StatusBadRequest)
+				return
+			}
+			_, err = chatHandoff.Resume(chatJID)
+			break
+		}
+
+		var resumeAt *time.Time
+		if req.ResumeAfter != "" {
+			duration, err := parseMuteDuration(req.ResumeAfter)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid resume_after: %v", err), http.StatusBadRequest)
+				return
+			}
+			at := time.Now().UTC().Add(duration)
+			resumeAt = &at
+		}
+		pause, err = chatHandoff.Pause(chatJID, resumeAt)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update bot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := BotStatus{ChatJID: chatJID}
+	if pause != nil {
+		pause.localize(loc)
+		status.Paused = true
+		status.Trigger = pause.Trigger
+		status.Keyword = pause.Keyword
+		status.PausedAt = &pause.PausedAt
+		status.ResumeAt = pause.ResumeAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hashChainEnabled links every stored message to the one stored before it in the same chat
+// through a SHA-256 hash chain, so changes to stored messages can be detected
+var hashChainEnabled bool
+
+// hashChainMutex serializes appends, as each link depends on the chat's previous head
+var hashChainMutex sync.Mutex
+
+// ChainLink is a message's place in its chat's hash chain
+type ChainLink struct {
+	Seq  int64
+	Prev string
+	Hash string
+}
+
+// chainedFields are the parts of a message covered by its hash. Columns that legitimately
+// change after a message is stored, such as media download details, are left out.
+type chainedFields struct {
+	ID          string `json:"id"`
+	ChatJID     string `json:"chat_jid"`
+	Sender      string `json:"sender"`
+	Content     string `json:"content"`
+	Timestamp   int64  `json:"timestamp"`
+	IsFromMe    bool   `json:"is_from_me"`
+	MediaType   string `json:"media_type"`
+	Filename    string `json:"filename"`
+	SystemEvent string `json:"system_event"`
+}
+
+// chainHash returns the hex SHA-256 of the previous hash, a newline and the message's fields as JSON
+func chainHash(prev string, fields chainedFields) string {
+	encoded, _ := json.Marshal(fields)
+	sum := sha256.Sum256(append([]byte(prev+"\n"), encoded...))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainedFieldsColumns selects a message's chained fields, in chainedFields order
+const chainedFieldsColumns = "id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, '')"
+
+// scanChainedFields reads the columns of chainedFieldsColumns
+func scanChainedFields(scan func(...interface{}) error, extra ...interface{}) (chainedFields, error) {
+	var fields chainedFields
+	var timestamp time.Time
+	dest := append([]interface{}{&fields.ID, &fields.ChatJID, &fields.Sender, &fields.Content, &timestamp,
+		&fields.IsFromMe, &fields.MediaType, &fields.Filename, &fields.SystemEvent}, extra...)
+	if err := scan(dest...); err != nil {
+		return fields, err
+	}
+	// Whole seconds survive every database's timestamp precision
+	fields.Timestamp = timestamp.Unix()
+	return fields, nil
+}
+
+// GetChainLink returns the chain link of a message, or nil if it isn't chained
+func (store *MessageStore) GetChainLink(id, chatJID string) (*ChainLink, error) {
+	query := "SELECT chain_seq, COALESCE(chain_prev, ''), chain_hash FROM messages WHERE id = ? AND chat_jid = ? AND chain_hash IS NOT NULL"
+	if store.isPostgres {
+		query = "SELECT chain_seq, COALESCE(chain_prev, ''), chain_hash FROM messages WHERE id = $1 AND chat_jid = $2 AND chain_hash IS NOT NULL"
+	}
+	var link ChainLink
+	err := store.db.QueryRow(query, id, chatJID).Scan(&link.Seq, &link.Prev, &link.Hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// setChainLink records a message's chain link
+func (store *MessageStore) setChainLink(id, chatJID string, link *ChainLink) error {
+	query := "UPDATE messages SET chain_seq = ?, chain_prev = ?, chain_hash = ? WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "UPDATE messages SET chain_seq = $1, chain_prev = $2, chain_hash = $3 WHERE id = $4 AND chat_jid = $5"
+	}
+	_, err := store.db.Exec(query, link.Seq, link.Prev, link.Hash, id, chatJID)
+	return err
+}
+
+// GetChainHead returns the last link of a chat's chain, or nil if nothing has been chained.
+// Heads are kept separately, so pruned messages don't reset the chain.
+func (store *MessageStore) GetChainHead(chatJID string) (*ChainLink, error) {
+	query := "SELECT seq, hash FROM chain_heads WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT seq, hash FROM chain_heads WHERE chat_jid = $1"
+	}
+	var head ChainLink
+	err := store.db.QueryRow(query, chatJID).Scan(&head.Seq, &head.Hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// chainMessage appends a newly stored message to its chat's chain. Messages that are already
+// chained keep their link; previous restores a link an SQLite INSERT OR REPLACE dropped.
+func (store *MessageStore) chainMessage(id, chatJID string, previous *ChainLink) error {
+	hashChainMutex.Lock()
+	defer hashChainMutex.Unlock()
+
+	existing, err := store.GetChainLink(id, chatJID)
+	if err != nil || existing != nil {
+		return err
+	}
+	if previous != nil {
+		return store.setChainLink(id, chatJID, previous)
+	}
+
+	query := "SELECT " + chainedFieldsColumns + " FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT " + chainedFieldsColumns + " FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	fields, err := scanChainedFields(store.db.QueryRow(query, id, chatJID).Scan)
+	if err == sql.ErrNoRows {
+		// Nothing was stored, e.g. an empty message
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	head, err := store.GetChainHead(chatJID)
+	if err != nil {
+		return err
+	}
+	link := &ChainLink{Seq: 1}
+	if head != nil {
+		link.Seq, link.Prev = head.Seq+1, head.Hash
+	}
+	link.Hash = chainHash(link.Prev, fields)
+	if err := store.setChainLink(id, chatJID, link); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO chain_heads (chat_jid, seq, hash) VALUES (?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET seq = excluded.seq, hash = excluded.hash`
+	if store.isPostgres {
+		query = `INSERT INTO chain_heads (chat_jid, seq, hash) VALUES ($1, $2, $3)
+		ON CONFLICT (chat_jid) DO UPDATE SET seq = EXCLUDED.seq, hash = EXCLUDED.hash`
+	}
+	_, err = store.db.Exec(query, chatJID, link.Seq, link.Hash)
+	return err
+}
+
+// ChainGap is a range of links whose messages are no longer stored
+type ChainGap struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// ChainMismatch is a chained message that no longer matches its hash or its predecessor
+type ChainMismatch struct {
+	Seq       int64  `json:"seq"`
+	MessageID string `json:"message_id"`
+	Problem   string `json:"problem"`
+}
+
+// ChainVerification is the result of checking a chat's hash chain
+type ChainVerification struct {
+	ChatJID string `json:"chat_jid"`
+	// Valid means no stored message was altered and the chain ends at its recorded head
+	Valid bool `json:"valid"`
+	// Complete means no chained message is missing, e.g. pruned or revoked
+	Complete   bool            `json:"complete"`
+	Messages   int             `json:"messages"`
+	Unchained  int             `json:"unchained"`
+	HeadSeq    int64           `json:"head_seq"`
+	HeadHash   string          `json:"head_hash,omitempty"`
+	Gaps       []ChainGap      `json:"gaps"`
+	Mismatches []ChainMismatch `json:"mismatches"`
+	CheckedAt  time.Time       `json:"checked_at"`
+}
+
+// VerifyChain recomputes every hash of a chat's chain and checks the links between them
+func (store *MessageStore) VerifyChain(chatJID string) (*ChainVerification, error) {
+	result := &ChainVerification{ChatJID: chatJID, Gaps: []ChainGap{}, Mismatches: []ChainMismatch{}}
+
+	head, err := store.GetChainHead(chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if head != nil {
+		result.HeadSeq, result.HeadHash = head.Seq, head.Hash
+	}
+
+	query := "SELECT COUNT(*) FROM messages WHERE chat_jid = ? AND chain_hash IS NULL"
+	if store.isPostgres {
+		query = "SELECT COUNT(*) FROM messages WHERE chat_jid = $1 AND chain_hash IS NULL"
+	}
+	if err := store.db.QueryRow(query, chatJID).Scan(&result.Unchained); err != nil {
+		return nil, err
+	}
+
+	query = "SELECT " + chainedFieldsColumns + ", chain_seq, COALESCE(chain_prev, ''), chain_hash FROM messages WHERE chat_jid = ? AND chain_hash IS NOT NULL ORDER BY chain_seq"
+	if store.isPostgres {
+		query = "SELECT " + chainedFieldsColumns + ", chain_seq, COALESCE(chain_prev, ''), chain_hash FROM messages WHERE chat_jid = $1 AND chain_hash IS NOT NULL ORDER BY chain_seq"
+	}
+	rows, err := store.db.Query(query, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var last ChainLink
+	for rows.Next() {
+		var link ChainLink
+		fields, err := scanChainedFields(rows.Scan, &link.Seq, &link.Prev, &link.Hash)
+		if err != nil {
+			return nil, err
+		}
+		result.Messages++
+
+		if chainHash(link.Prev, fields) != link.Hash {
+			result.Mismatches = append(result.Mismatches, ChainMismatch{Seq: link.Seq, MessageID: fields.ID, Problem: "message does not match its hash"})
+		}
+		switch {
+		case link.Seq == last.Seq:
+			result.Mismatches = append(result.Mismatches, ChainMismatch{Seq: link.Seq, MessageID: fields.ID, Problem: "sequence number is used twice"})
+		case link.Seq == last.Seq+1:
+			if link.Prev != last.Hash {
+				result.Mismatches = append(result.Mismatches, ChainMismatch{Seq: link.Seq, MessageID: fields.ID, Problem: "previous hash does not match the preceding message"})
+			}
+		default:
+			result.Gaps = append(result.Gaps, ChainGap{From: last.Seq + 1, To: link.Seq - 1})
+		}
+		last = link
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The tail may be pruned, but a stored message beyond the head or a different last hash is not expected
+	switch {
+	case last.Seq > result.HeadSeq:
+		result.Mismatches = append(result.Mismatches, ChainMismatch{Seq: last.Seq, Problem: "chain continues past the recorded head"})
+	case last.Seq == result.HeadSeq && last.Hash != result.HeadHash:
+		result.Mismatches = append(result.Mismatches, ChainMismatch{Seq: last.Seq, Problem: "last hash does not match the recorded head"})
+	case last.Seq < result.HeadSeq:
+		result.Gaps = append(result.Gaps, ChainGap{From: last.Seq + 1, To: result.HeadSeq})
+	}
+
+	result.Valid = len(result.Mismatches) == 0
+	result.Complete = len(result.Gaps) == 0
+	result.CheckedAt = time.Now().UTC()
+	return result, nil
+}
+
+// ChainCheck is a hash from an exported transcript to check against the stored chain
+type ChainCheck struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// ChainCheckResult tells whether a hash from a transcript matches the stored message
+type ChainCheckResult struct {
+	Seq       int64  `json:"seq"`
+	Hash      string `json:"hash"`
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status"`
+}
+
+// CheckChainHashes compares hashes from an exported transcript with the stored, recomputed chain.
+// Status is match, mismatch (the transcript or the store was altered) or missing (no longer stored).
+func (store *MessageStore) CheckChainHashes(chatJID string, checks []ChainCheck) ([]ChainCheckResult, error) {
+	query := "SELECT " + chainedFieldsColumns + ", COALESCE(chain_prev, ''), chain_hash FROM messages WHERE chat_jid = ? AND chain_seq = ?"
+	if store.isPostgres {
+		query = "SELECT " + chainedFieldsColumns + ", COALESCE(chain_prev, ''), chain_hash FROM messages WHERE chat_jid = $1 AND chain_seq = $2"
+	}
+
+	results := make([]ChainCheckResult, 0, len(checks))
+	for _, check := range checks {
+		result := ChainCheckResult{Seq: check.Seq, Hash: check.Hash, Status: "missing"}
+		var prev, hash string
+		fields, err := scanChainedFields(store.db.QueryRow(query, chatJID, check.Seq).Scan, &prev, &hash)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			result.MessageID = fields.ID
+			result.Status = "mismatch"
+			if hash == check.Hash && chainHash(prev, fields) == hash {
+				result.Status = "match"
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// registerHashChainRoutes registers /api/v1/chats/{jid}/chain: GET verifies the chat's chain,
+// POST checks hashes from an exported transcript
+func registerHashChainRoutes(messageStore *MessageStore) {
+	registerChatRoute("chain", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		switch r.Method {
+		case http.MethodGet:
+			loc, err := requestLocation(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err := messageStore.VerifyChain(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to verify hash chain: %v", err), http.StatusInternalServerError)
+				return
+			}
+			result.CheckedAt = result.CheckedAt.In(loc)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+
+		case http.MethodPost:
+			var req struct {
+				Hashes []ChainCheck `json:"hashes"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Hashes) == 0 {
+				http.Error(w, "hashes is required", http.StatusBadRequest)
+				return
+			}
+			if len(req.Hashes) > 10000 {
+				http.Error(w, "At most 10000 hashes can be checked at once", http.StatusBadRequest)
+				return
+			}
+			results, err := messageStore.CheckChainHashes(chatJID, req.Hashes)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to check hashes: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
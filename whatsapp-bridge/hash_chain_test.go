@@ -0,0 +1,250 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const chainTestChat = "15550001111@s.whatsapp.net"
+
+// newChainTestStore opens a test store with the hash chain on
+func newChainTestStore(t *testing.T) *MessageStore {
+	t.Helper()
+	previous := hashChainEnabled
+	hashChainEnabled = true
+	t.Cleanup(func() { hashChainEnabled = previous })
+	return newTestMessageStore(t)
+}
+
+// storeChainTestMessages stores m1, m2 and m3 in the test chat, a minute apart
+func storeChainTestMessages(t *testing.T, store *MessageStore) {
+	t.Helper()
+	if err := store.StoreChat(chainTestChat, "Test", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 3, 1, 9, 0, 0, 500, time.UTC)
+	for i, content := range []string{"first", "second", "third"} {
+		id := "m" + string(rune('1'+i))
+		if err := store.StoreMessage(id, chainTestChat, "15550002222", content, start.Add(time.Duration(i)*time.Minute), i == 1,
+			"", "", "", nil, nil, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mustChainLink(t *testing.T, store *MessageStore, id, chatJID string) *ChainLink {
+	t.Helper()
+	link, err := store.GetChainLink(id, chatJID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link == nil {
+		t.Fatalf("message %s is not chained", id)
+	}
+	return link
+}
+
+func TestHashChainLinksMessages(t *testing.T) {
+	store := newChainTestStore(t)
+	storeChainTestMessages(t, store)
+	other := "15550003333@s.whatsapp.net"
+	if err := store.StoreChat(other, "Other", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreMessage("o1", other, "15550003333", "elsewhere", time.Now(), false, "", "", "", nil, nil, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is stored for an empty message, so nothing is chained
+	if err := store.StoreMessage("empty", chainTestChat, "15550002222", "", time.Now(), false, "", "", "", nil, nil, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var prev string
+	for i, id := range []string{"m1", "m2", "m3"} {
+		link := mustChainLink(t, store, id, chainTestChat)
+		if link.Seq != int64(i+1) || link.Prev != prev {
+			t.Errorf("%s: seq %d after %q, want seq %d after %q", id, link.Seq, link.Prev, i+1, prev)
+		}
+		prev = link.Hash
+	}
+	if link := mustChainLink(t, store, "o1", other); link.Seq != 1 || link.Prev != "" {
+		t.Errorf("another chat's first message has seq %d after %q", link.Seq, link.Prev)
+	}
+	head, err := store.GetChainHead(chainTestChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != 3 || head.Hash != prev {
+		t.Errorf("head is %d %s, want 3 %s", head.Seq, head.Hash, prev)
+	}
+
+	// A message stored again, e.g. once its media is downloaded, keeps its place
+	before := mustChainLink(t, store, "m2", chainTestChat)
+	if err := store.StoreMessage("m2", chainTestChat, "15550002222", "second", time.Date(2025, 3, 1, 9, 1, 0, 500, time.UTC), true,
+		"", "", "https://mmg.whatsapp.net/m2", nil, nil, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if after := mustChainLink(t, store, "m2", chainTestChat); *after != *before {
+		t.Errorf("storing m2 again moved it from %+v to %+v", before, after)
+	}
+	if head, _ := store.GetChainHead(chainTestChat); head.Seq != 3 {
+		t.Errorf("storing m2 again moved the head to %d", head.Seq)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   []string
+		valid    bool
+		complete bool
+		problems []string
+		gaps     []ChainGap
+	}{
+		{name: "untouched", valid: true, complete: true},
+		{
+			name:     "media details are not covered",
+			tamper:   []string{"UPDATE messages SET url = 'https://mmg.whatsapp.net/x', file_length = 10 WHERE id = 'm2'"},
+			valid:    true,
+			complete: true,
+		},
+		{
+			name:     "edited content",
+			tamper:   []string{"UPDATE messages SET content = 'edited' WHERE id = 'm2'"},
+			complete: true,
+			problems: []string{"message does not match its hash"},
+		},
+		{
+			name:     "changed sender",
+			tamper:   []string{"UPDATE messages SET is_from_me = 1 WHERE id = 'm1'"},
+			complete: true,
+			problems: []string{"message does not match its hash"},
+		},
+		{
+			name:   "deleted message",
+			tamper: []string{"DELETE FROM messages WHERE id = 'm2'"},
+			valid:  true,
+			gaps:   []ChainGap{{From: 2, To: 2}},
+		},
+		{
+			name:   "pruned tail",
+			tamper: []string{"DELETE FROM messages WHERE id IN ('m2', 'm3')"},
+			valid:  true,
+			gaps:   []ChainGap{{From: 2, To: 3}},
+		},
+		{
+			name:     "renumbered after a deletion",
+			tamper:   []string{"DELETE FROM messages WHERE id = 'm2'", "UPDATE messages SET chain_seq = 2 WHERE id = 'm3'"},
+			problems: []string{"previous hash does not match the preceding message"},
+			gaps:     []ChainGap{{From: 3, To: 3}},
+		},
+		{
+			name:     "duplicated sequence number",
+			tamper:   []string{"UPDATE messages SET chain_seq = 2 WHERE id = 'm3'"},
+			problems: []string{"sequence number is used twice"},
+			gaps:     []ChainGap{{From: 3, To: 3}},
+		},
+		{
+			name:     "rewritten head",
+			tamper:   []string{"UPDATE chain_heads SET hash = 'feed'"},
+			complete: true,
+			problems: []string{"last hash does not match the recorded head"},
+		},
+		{
+			name:     "rolled back head",
+			tamper:   []string{"UPDATE chain_heads SET seq = 2"},
+			complete: true,
+			problems: []string{"chain continues past the recorded head"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newChainTestStore(t)
+			storeChainTestMessages(t, store)
+			for _, statement := range tt.tamper {
+				if _, err := store.db.Exec(statement); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := store.VerifyChain(chainTestChat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != tt.valid || result.Complete != tt.complete {
+				t.Errorf("valid %v, complete %v; want %v, %v", result.Valid, result.Complete, tt.valid, tt.complete)
+			}
+			var problems []string
+			for _, mismatch := range result.Mismatches {
+				problems = append(problems, mismatch.Problem)
+			}
+			if strings.Join(problems, "; ") != strings.Join(tt.problems, "; ") {
+				t.Errorf("problems %q, want %q", problems, tt.problems)
+			}
+			if len(result.Gaps) != len(tt.gaps) {
+				t.Fatalf("gaps %v, want %v", result.Gaps, tt.gaps)
+			}
+			for i := range tt.gaps {
+				if result.Gaps[i] != tt.gaps[i] {
+					t.Errorf("gaps %v, want %v", result.Gaps, tt.gaps)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyChainCountsUnchainedMessages(t *testing.T) {
+	store := newChainTestStore(t)
+	storeChainTestMessages(t, store)
+	hashChainEnabled = false
+	if err := store.StoreMessage("before", chainTestChat, "15550002222", "stored with the chain off", time.Now(), false,
+		"", "", "", nil, nil, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.VerifyChain(chainTestChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || !result.Complete || result.Messages != 3 || result.Unchained != 1 {
+		t.Errorf("got %+v, want 3 valid chained messages and 1 unchained", result)
+	}
+}
+
+func TestCheckChainHashes(t *testing.T) {
+	store := newChainTestStore(t)
+	storeChainTestMessages(t, store)
+	first := mustChainLink(t, store, "m1", chainTestChat)
+	second := mustChainLink(t, store, "m2", chainTestChat)
+	if _, err := store.db.Exec("UPDATE messages SET content = 'edited' WHERE id = 'm2'"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		check  ChainCheck
+		id     string
+		status string
+	}{
+		{"exported hash", ChainCheck{Seq: 1, Hash: first.Hash}, "m1", "match"},
+		{"altered transcript", ChainCheck{Seq: 1, Hash: second.Hash}, "m1", "mismatch"},
+		{"altered store", ChainCheck{Seq: 2, Hash: second.Hash}, "m2", "mismatch"},
+		{"no longer stored", ChainCheck{Seq: 9, Hash: first.Hash}, "", "missing"},
+	}
+	checks := make([]ChainCheck, len(tests))
+	for i, tt := range tests {
+		checks[i] = tt.check
+	}
+	results, err := store.CheckChainHashes(chainTestChat, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := results[i]; got.MessageID != tt.id || got.Status != tt.status || got.Seq != tt.check.Seq {
+				t.Errorf("got %+v, want %s for %s", got, tt.status, tt.id)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// stripImageMetadata removes EXIF, XMP, IPTC and text metadata from an image before it is sent.
+// Pixel data is copied byte for byte, so the image is never re-encoded. Formats we don't know
+// how to clean are returned unchanged.
+func stripImageMetadata(data []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	default:
+		return data, nil
+	}
+}
+
+// stripJPEGMetadata drops APP1 (EXIF/XMP), APP13 (IPTC) and comment segments from a JPEG.
+// The EXIF orientation is preserved in a minimal replacement segment so photos taken in
+// portrait mode are not displayed sideways.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a valid JPEG file (missing SOI marker)")
+	}
+
+	var kept bytes.Buffer
+	var orientation uint16
+	leadingAPP0 := -1
+
+	for i := 2; i < len(data); {
+		if data[i] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+
+		// Skip fill bytes before the marker code
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, fmt.Errorf("truncated JPEG marker at offset %d", i)
+		}
+		marker := data[i+1]
+
+		// Start of scan: everything after this is entropy-coded image data
+		if marker == 0xDA {
+			kept.Write(data[i:])
+			break
+		}
+
+		// Standalone markers carry no length field
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			kept.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment at offset %d", i)
+		}
+		// The length counts its own two bytes, so anything shorter is corrupt
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment length %d at offset %d", length, i)
+		}
+		segmentEnd := i + 2 + length
+		if segmentEnd > len(data) {
+			return nil, fmt.Errorf("JPEG segment at offset %d exceeds file size", i)
+		}
+		segment := data[i:segmentEnd]
+
+		switch marker {
+		case 0xE1:
+			// APP1 holds EXIF (including GPS) and XMP; remember the orientation and drop the rest
+			if len(segment) < 4 {
+				break
+			}
+			if value, ok := readEXIFOrientation(segment[4:]); ok {
+				orientation = value
+			}
+		case 0xED, 0xFE:
+			// APP13 (Photoshop/IPTC) and COM (free-text comment)
+		default:
+			if marker == 0xE0 && kept.Len() == 0 {
+				leadingAPP0 = len(segment)
+			}
+			kept.Write(segment)
+		}
+
+		i = segmentEnd
+	}
+
+	out := make([]byte, 0, kept.Len()+64)
+	out = append(out, 0xFF, 0xD8)
+
+	rest := kept.Bytes()
+	if leadingAPP0 > 0 {
+		// JFIF requires APP0 to directly follow SOI, so the EXIF segment goes after it
+		out = append(out, rest[:leadingAPP0]...)
+		rest = rest[leadingAPP0:]
+	}
+	if orientation > 1 {
+		out = append(out, orientationEXIFSegment(orientation)...)
+	}
+	out = append(out, rest...)
+
+	return out, nil
+}
+
+// readEXIFOrientation extracts the orientation tag from IFD0 of an APP1 EXIF payload
+func readEXIFOrientation(payload []byte) (uint16, bool) {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 0, false
+	}
+	tiff := payload[6:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) {
+		return 0, false
+	}
+
+	entryCount := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for n := 0; n < entryCount; n++ {
+		entry := ifdOffset + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Orientation is tag 0x0112 stored as a single SHORT
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			value := order.Uint16(tiff[entry+8 : entry+10])
+			return value, value >= 1 && value <= 8
+		}
+	}
+
+	return 0, false
+}
+
+// orientationEXIFSegment builds an APP1 segment whose only EXIF entry is the orientation tag
+func orientationEXIFSegment(orientation uint16) []byte {
+	var payload bytes.Buffer
+	payload.WriteString("Exif\x00\x00")
+
+	// Big-endian TIFF header with IFD0 immediately after it
+	payload.WriteString("MM")
+	binary.Write(&payload, binary.BigEndian, uint16(0x002A))
+	binary.Write(&payload, binary.BigEndian, uint32(8))
+
+	// One entry: Orientation (0x0112), SHORT, count 1, then no next IFD
+	binary.Write(&payload, binary.BigEndian, uint16(1))
+	binary.Write(&payload, binary.BigEndian, uint16(0x0112))
+	binary.Write(&payload, binary.BigEndian, uint16(3))
+	binary.Write(&payload, binary.BigEndian, uint32(1))
+	binary.Write(&payload, binary.BigEndian, orientation)
+	binary.Write(&payload, binary.BigEndian, uint16(0))
+	binary.Write(&payload, binary.BigEndian, uint32(0))
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(payload.Len()+2))
+	return append(segment, payload.Bytes()...)
+}
+
+// stripPNGMetadata drops textual, EXIF and timestamp chunks from a PNG
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil, fmt.Errorf("not a valid PNG file (missing signature)")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:len(signature)]...)
+
+	for i := len(signature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("truncated PNG chunk header at offset %d", i)
+		}
+		chunkEnd := i + 12 + int(binary.BigEndian.Uint32(data[i:i+4]))
+		if chunkEnd > len(data) || chunkEnd < i {
+			return nil, fmt.Errorf("PNG chunk at offset %d exceeds file size", i)
+		}
+
+		switch string(data[i+4 : i+8]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+			// Metadata chunk, skip it
+		default:
+			out = append(out, data[i:chunkEnd]...)
+		}
+
+		i = chunkEnd
+	}
+
+	return out, nil
+}
+
+// stripWebPMetadata drops EXIF and XMP chunks from a WebP and clears their flags in VP8X
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a valid WebP file (missing RIFF/WEBP header)")
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk header at offset %d", i)
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		// Chunks are padded to an even number of bytes
+		chunkEnd := i + 8 + size + size%2
+		if chunkEnd > len(data) {
+			chunkEnd = len(data)
+		}
+
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			// Metadata chunk, skip it
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:chunkEnd]...)
+			if len(chunk) > 8 {
+				// Bit 3 flags EXIF and bit 2 flags XMP
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:chunkEnd]...)
+		}
+
+		i = chunkEnd
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
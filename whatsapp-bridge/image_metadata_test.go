@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// jpegSegment builds a JPEG marker segment with its length field
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEG is SOI, JFIF APP0, an EXIF APP1 with orientation 6, a comment and a scan
+func testJPEG() []byte {
+	exif := orientationEXIFSegment(6)[4:]
+	var data []byte
+	data = append(data, 0xFF, 0xD8)
+	data = append(data, jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))...)
+	data = append(data, jpegSegment(0xE1, append(exif, "GPS 51.5N 0.1W"...))...)
+	data = append(data, jpegSegment(0xFE, []byte("taken at home"))...)
+	data = append(data, jpegSegment(0xDB, bytes.Repeat([]byte{1}, 65))...)
+	data = append(data, 0xFF, 0xDA, 0x00, 0x02, 0x12, 0x34, 0x56, 0xFF, 0xD9)
+	return data
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	out, err := stripJPEGMetadata(testJPEG())
+	if err != nil {
+		t.Fatalf("stripJPEGMetadata: %v", err)
+	}
+	for _, leaked := range []string{"GPS", "taken at home"} {
+		if bytes.Contains(out, []byte(leaked)) {
+			t.Errorf("output still contains %q", leaked)
+		}
+	}
+	if !bytes.HasPrefix(out[2:], []byte{0xFF, 0xE0}) {
+		t.Errorf("APP0 does not directly follow SOI")
+	}
+	app0 := 4 + int(binary.BigEndian.Uint16(out[4:6]))
+	if value, ok := readEXIFOrientation(out[app0+4:]); !ok || value != 6 {
+		t.Errorf("orientation after APP0 = %d, %v; want 6", value, ok)
+	}
+	if !bytes.HasSuffix(out, []byte{0xFF, 0xDA, 0x00, 0x02, 0x12, 0x34, 0x56, 0xFF, 0xD9}) {
+		t.Errorf("scan data was not kept byte for byte")
+	}
+}
+
+func TestStripJPEGMetadataRejectsCorruptSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"missing SOI", []byte{0xFF, 0xD9, 0xFF, 0xDA}},
+		{"APP1 length 0", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x00, 0xFF, 0xDA}},
+		{"APP1 length 1", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xDA}},
+		{"APP0 length 0", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x00, 0xFF, 0xDA}},
+		{"segment past end", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10, 0x00}},
+		{"truncated length", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}},
+		{"truncated marker", []byte{0xFF, 0xD8, 0xFF, 0xFF}},
+		{"not a marker", []byte{0xFF, 0xD8, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripJPEGMetadata(tt.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// pngChunk builds a PNG chunk; the CRC isn't checked by the stripper, so it is left zero
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, payload...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func testPNG() []byte {
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = append(data, pngChunk("IHDR", make([]byte, 13))...)
+	data = append(data, pngChunk("tEXt", []byte("Author\x00alice"))...)
+	data = append(data, pngChunk("eXIf", []byte("MM\x00\x2a"))...)
+	data = append(data, pngChunk("tIME", make([]byte, 7))...)
+	data = append(data, pngChunk("IDAT", []byte{1, 2, 3})...)
+	return append(data, pngChunk("IEND", nil)...)
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	out, err := stripPNGMetadata(testPNG())
+	if err != nil {
+		t.Fatalf("stripPNGMetadata: %v", err)
+	}
+	want := []byte("\x89PNG\r\n\x1a\n")
+	want = append(want, pngChunk("IHDR", make([]byte, 13))...)
+	want = append(want, pngChunk("IDAT", []byte{1, 2, 3})...)
+	want = append(want, pngChunk("IEND", nil)...)
+	if !bytes.Equal(out, want) {
+		t.Errorf("stripped PNG = %x, want %x", out, want)
+	}
+}
+
+func TestStripPNGMetadataRejectsCorruptChunks(t *testing.T) {
+	valid := testPNG()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"missing signature", []byte("GIF89a")},
+		{"truncated header", valid[:12]},
+		{"chunk past end", append(append([]byte(nil), valid[:8]...), 0x7F, 0xFF, 0xFF, 0xFF, 'I', 'D', 'A', 'T')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripPNGMetadata(tt.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// webpChunk builds a RIFF chunk, padded to an even size
+func webpChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, kind)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func testWebP() []byte {
+	var body []byte
+	body = append(body, webpChunk("VP8X", []byte{0x0C, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	body = append(body, webpChunk("VP8 ", []byte{9, 8, 7})...)
+	body = append(body, webpChunk("EXIF", []byte("GPS 51.5N"))...)
+	body = append(body, webpChunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)+4))
+	return append(data, body...)
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	out, err := stripWebPMetadata(testWebP())
+	if err != nil {
+		t.Fatalf("stripWebPMetadata: %v", err)
+	}
+	if bytes.Contains(out, []byte("GPS")) || bytes.Contains(out, []byte("xmpmeta")) {
+		t.Errorf("output still contains metadata")
+	}
+	if size := binary.LittleEndian.Uint32(out[4:8]); int(size) != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(out)-8)
+	}
+	if flags := out[20]; flags&0x0C != 0 {
+		t.Errorf("VP8X still flags EXIF or XMP: %08b", flags)
+	}
+	if !bytes.Contains(out, webpChunk("VP8 ", []byte{9, 8, 7})) {
+		t.Errorf("image chunk was not kept")
+	}
+}
+
+func TestStripWebPMetadataRejectsCorruptFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"missing header", []byte("RIFF\x00\x00\x00\x00WAVE")},
+		{"truncated chunk header", append([]byte("RIFF\x08\x00\x00\x00WEBP"), 'V', 'P', '8')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripWebPMetadata(tt.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// TestStripImageMetadataTruncated feeds every prefix of each test image to the strippers, which
+// must return an error or a result but never panic
+func TestStripImageMetadataTruncated(t *testing.T) {
+	images := map[string][]byte{
+		"image/jpeg": testJPEG(),
+		"image/png":  testPNG(),
+		"image/webp": testWebP(),
+	}
+	for mimeType, data := range images {
+		for n := 0; n <= len(data); n++ {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s truncated to %d bytes panicked: %v", mimeType, n, r)
+					}
+				}()
+				stripImageMetadata(data[:n], mimeType)
+			}()
+		}
+	}
+}
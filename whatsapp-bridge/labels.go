@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits on labels so they stay tags like "lead" or "vip" rather than notes
+const (
+	maxChatLabels = 20
+	maxLabelLen   = 50
+)
+
+// ChatLabels is the set of labels of a chat or contact
+type ChatLabels struct {
+	JID    string   `json:"jid"`
+	Labels []string `json:"labels"`
+}
+
+// normalizeLabels lowercases, trims and de-duplicates labels and checks the limits
+func normalizeLabels(labels []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" || len([]rune(label)) > maxLabelLen {
+			return nil, fmt.Errorf("labels must be 1 to %d characters", maxLabelLen)
+		}
+		if !seen[label] {
+			seen[label] = true
+			normalized = append(normalized, label)
+		}
+	}
+	if len(normalized) > maxChatLabels {
+		return nil, fmt.Errorf("at most %d labels are allowed", maxChatLabels)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// GetChatLabels returns the labels of a chat in alphabetical order
+func (store *MessageStore) GetChatLabels(jid string) ([]string, error) {
+	query := "SELECT label FROM chat_labels WHERE chat_jid = ? ORDER BY label"
+	if store.isPostgres {
+		query = "SELECT label FROM chat_labels WHERE chat_jid = $1 ORDER BY label"
+	}
+
+	rows, err := store.db.Query(query, jid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []string{}
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+// SetChatLabels replaces the labels of a chat in one transaction
+func (store *MessageStore) SetChatLabels(jid string, labels []string) error {
+	deleteQuery := "DELETE FROM chat_labels WHERE chat_jid = ?"
+	insertQuery := "INSERT INTO chat_labels (chat_jid, label, added_at) VALUES (?, ?, ?)"
+	if store.isPostgres {
+		deleteQuery = "DELETE FROM chat_labels WHERE chat_jid = $1"
+		insertQuery = "INSERT INTO chat_labels (chat_jid, label, added_at) VALUES ($1, $2, $3)"
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteQuery, jid); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, label := range labels {
+		if _, err := tx.Exec(insertQuery, jid, label, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// registerLabelRoutes registers /api/v1/chats/{jid}/labels and /api/v1/contacts/{jid}/labels
+func registerLabelRoutes(messageStore *MessageStore) {
+	handler := func(w http.ResponseWriter, r *http.Request, jid string) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req struct {
+				Labels []string `json:"labels"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			labels, err := normalizeLabels(req.Labels)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := messageStore.SetChatLabels(jid, labels); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save labels: %v", err), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		labels, err := messageStore.GetChatLabels(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get labels: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ChatLabels{JID: jid, Labels: labels})
+	}
+
+	registerChatRoute("labels", handler)
+	registerContactRoute("labels", handler)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Legal hold audit actions
+const (
+	HoldPlaced      = "placed"
+	HoldReleased    = "released"
+	HoldAccess      = "access"
+	HoldRevokeKept  = "revoke_kept"
+	HoldErasureKept = "erasure_kept"
+)
+
+// heldChatsQuery selects the chats on hold, for excluding them from deletions
+const heldChatsQuery = "SELECT chat_jid FROM legal_holds"
+
+// LegalHold keeps a chat's messages and media from being deleted, for litigation
+type LegalHold struct {
+	ChatJID  string    `json:"chat_jid"`
+	Reason   string    `json:"reason"`
+	PlacedBy string    `json:"placed_by,omitempty"`
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+// HoldAuditEntry records an access to, or a change of, a chat on hold
+type HoldAuditEntry struct {
+	ID      string    `json:"id"`
+	ChatJID string    `json:"chat_jid"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor"`
+	IP      string    `json:"ip,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// LegalHolds manages holds and their audit log. Retention pruning, GDPR erasure and revokes
+// skip chats on hold, and every API request for one is logged.
+type LegalHolds struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// legalHolds is set once the message store is open
+var legalHolds *LegalHolds
+
+// NewLegalHolds creates the legal hold registry backed by the message store
+func NewLegalHolds(messageStore *MessageStore, logger waLog.Logger) *LegalHolds {
+	return &LegalHolds{messageStore: messageStore, logger: logger}
+}
+
+// IsHeld reports whether a chat is on hold. The database is asked every time, so all replicas agree.
+func (h *LegalHolds) IsHeld(chatJID string) bool {
+	if h == nil || chatJID == "" {
+		return false
+	}
+	hold, err := h.messageStore.GetLegalHold(chatJID)
+	if err != nil {
+		// Failing closed keeps held data from being deleted
+		h.logger.Warnf("Failed to check legal hold of %s: %v", chatJID, err)
+		return true
+	}
+	return hold != nil
+}
+
+// Record adds an entry to the audit log of a chat on hold
+func (h *LegalHolds) Record(chatJID, action, actor, ip, detail string) {
+	if h == nil {
+		return
+	}
+	entry := &HoldAuditEntry{
+		ID:      newEventID(),
+		ChatJID: chatJID,
+		Action:  action,
+		Actor:   actor,
+		IP:      ip,
+		Detail:  detail,
+		At:      time.Now().UTC(),
+	}
+	if err := h.messageStore.AddHoldAuditEntry(entry); err != nil {
+		h.logger.Errorf("Failed to record legal hold audit entry for %s: %v", chatJID, err)
+	}
+}
+
+// RecordRequest logs an API request for a chat if the chat is on hold
+func (h *LegalHolds) RecordRequest(r *http.Request, chatJID string) {
+	if h.IsHeld(chatJID) {
+		h.Record(chatJID, HoldAccess, usageSubject(r), clientIP(r), r.Method+" "+r.URL.Path)
+	}
+}
+
+// requestChatJID returns the chat an API request is about: the {jid} of /chats/{jid}/...
+// routes, or the chat_jid query parameter of routes such as /media/
+func requestChatJID(r *http.Request) string {
+	route := apiRoute(r)
+	if strings.HasPrefix(route, "/chats/") {
+		path := strings.TrimPrefix(route, "/chats/")
+		if separator := strings.LastIndex(path, "/"); separator > 0 {
+			return path[:separator]
+		}
+	}
+	return r.URL.Query().Get("chat_jid")
+}
+
+// legalHoldMiddleware audit-logs every API request for a chat on hold
+func legalHoldMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if legalHolds != nil && strings.HasPrefix(r.URL.Path, "/api/") && r.Method != http.MethodOptions {
+			legalHolds.RecordRequest(r, requestChatJID(r))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetLegalHold returns the hold of a chat, or nil if it isn't on hold
+func (store *MessageStore) GetLegalHold(chatJID string) (*LegalHold, error) {
+	query := "SELECT chat_jid, reason, COALESCE(placed_by, ''), placed_at FROM legal_holds WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT chat_jid, reason, COALESCE(placed_by, ''), placed_at FROM legal_holds WHERE chat_jid = $1"
+	}
+	var hold LegalHold
+	err := store.db.QueryRow(query, chatJID).Scan(&hold.ChatJID, &hold.Reason, &hold.PlacedBy, &hold.PlacedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// PlaceLegalHold puts a chat on hold, or updates the reason of an existing hold
+func (store *MessageStore) PlaceLegalHold(hold *LegalHold) error {
+	query := `INSERT INTO legal_holds (chat_jid, reason, placed_by, placed_at) VALUES (?, ?, NULLIF(?, ''), ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET reason = excluded.reason`
+	if store.isPostgres {
+		query = `INSERT INTO legal_holds (chat_jid, reason, placed_by, placed_at) VALUES ($1, $2, NULLIF($3, ''), $4)
+		ON CONFLICT (chat_jid) DO UPDATE SET reason = EXCLUDED.reason`
+	}
+	_, err := store.db.Exec(query, hold.ChatJID, hold.Reason, hold.PlacedBy, hold.PlacedAt)
+	return err
+}
+
+// ReleaseLegalHold takes a chat off hold and reports whether it was on hold
+func (store *MessageStore) ReleaseLegalHold(chatJID string) (bool, error) {
+	query := "DELETE FROM legal_holds WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM legal_holds WHERE chat_jid = $1"
+	}
+	result, err := store.db.Exec(query, chatJID)
+	if err != nil {
+		return false, err
+	}
+	released, _ := result.RowsAffected()
+	return released > 0, nil
+}
+
+// ListLegalHolds returns all chats on hold, most recently placed first
+func (store *MessageStore) ListLegalHolds() ([]LegalHold, error) {
+	rows, err := store.db.Query("SELECT chat_jid, reason, COALESCE(placed_by, ''), placed_at FROM legal_holds ORDER BY placed_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []LegalHold{}
+	for rows.Next() {
+		var hold LegalHold
+		if err := rows.Scan(&hold.ChatJID, &hold.Reason, &hold.PlacedBy, &hold.PlacedAt); err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// AddHoldAuditEntry appends to the legal hold audit log
+func (store *MessageStore) AddHoldAuditEntry(entry *HoldAuditEntry) error {
+	query := "INSERT INTO legal_hold_audit (id, chat_jid, action, actor, ip, detail, at) VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)"
+	if store.isPostgres {
+		query = "INSERT INTO legal_hold_audit (id, chat_jid, action, actor, ip, detail, at) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)"
+	}
+	_, err := store.db.Exec(query, entry.ID, entry.ChatJID, entry.Action, entry.Actor, entry.IP, entry.Detail, entry.At)
+	return err
+}
+
+// ListHoldAuditEntries returns the newest audit entries, of one chat or of all chats
+func (store *MessageStore) ListHoldAuditEntries(chatJID string, limit int) ([]HoldAuditEntry, error) {
+	columns := "SELECT id, chat_jid, action, actor, COALESCE(ip, ''), COALESCE(detail, ''), at FROM legal_hold_audit"
+	var rows *sql.Rows
+	var err error
+	switch {
+	case chatJID != "" && store.isPostgres:
+		rows, err = store.db.Query(columns+" WHERE chat_jid = $1 ORDER BY at DESC LIMIT $2", chatJID, limit)
+	case chatJID != "":
+		rows, err = store.db.Query(columns+" WHERE chat_jid = ? ORDER BY at DESC LIMIT ?", chatJID, limit)
+	case store.isPostgres:
+		rows, err = store.db.Query(columns+" ORDER BY at DESC LIMIT $1", limit)
+	default:
+		rows, err = store.db.Query(columns+" ORDER BY at DESC LIMIT ?", limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HoldAuditEntry{}
+	for rows.Next() {
+		var entry HoldAuditEntry
+		if err := rows.Scan(&entry.ID, &entry.ChatJID, &entry.Action, &entry.Actor, &entry.IP, &entry.Detail, &entry.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// registerLegalHoldRoutes registers /api/v1/legal-holds, /api/v1/legal-holds/audit and /api/v1/chats/{jid}/hold
+func registerLegalHoldRoutes(messageStore *MessageStore) {
+	handleAPI("/legal-holds", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		holds, err := messageStore.ListLegalHolds()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list legal holds: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range holds {
+			holds[i].PlacedAt = holds[i].PlacedAt.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(holds)
+	})
+
+	handleAPI("/legal-holds/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 1000 {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries, err := messageStore.ListHoldAuditEntries(r.URL.Query().Get("chat_jid"), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get legal hold audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range entries {
+			entries[i].At = entries[i].At.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+
+	registerChatRoute("hold", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the current hold below
+
+		case http.MethodPut:
+			var req struct {
+				Reason   string `json:"reason"`
+				PlacedBy string `json:"placed_by"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+				http.Error(w, "reason is required", http.StatusBadRequest)
+				return
+			}
+			hold := &LegalHold{ChatJID: chatJID, Reason: req.Reason, PlacedBy: req.PlacedBy, PlacedAt: time.Now().UTC()}
+			if err := messageStore.PlaceLegalHold(hold); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to place legal hold: %v", err), http.StatusInternalServerError)
+				return
+			}
+			legalHolds.Record(chatJID, HoldPlaced, usageSubject(r), clientIP(r), req.Reason)
+
+		case http.MethodDelete:
+			released, err := messageStore.ReleaseLegalHold(chatJID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to release legal hold: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if !released {
+				http.Error(w, "Chat is not on hold", http.StatusNotFound)
+				return
+			}
+			legalHolds.Record(chatJID, HoldReleased, usageSubject(r), clientIP(r), "")
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hold, err := messageStore.GetLegalHold(chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get legal hold: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if hold == nil {
+			http.Error(w, "Chat is not on hold", http.StatusNotFound)
+			return
+		}
+		hold.PlacedAt = hold.PlacedAt.In(loc)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hold)
+	})
+}
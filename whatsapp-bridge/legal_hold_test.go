@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	heldTestChat  = "15550001111@s.whatsapp.net"
+	heldTestGroup = "120363000000000001@g.us"
+	freeTestGroup = "120363000000000002@g.us"
+)
+
+// newLegalHoldTestStore opens a test store with the legal hold registry on it and a message from
+// 15550001111 in their own chat and in two groups, the first of which is on hold
+func newLegalHoldTestStore(t *testing.T) *MessageStore {
+	t.Helper()
+	store := newTestMessageStore(t)
+	previous := legalHolds
+	legalHolds = NewLegalHolds(store, waLog.Noop)
+	t.Cleanup(func() { legalHolds = previous })
+
+	sent := time.Now().Add(-48 * time.Hour)
+	for _, chatJID := range []string{heldTestChat, heldTestGroup, freeTestGroup} {
+		if err := store.StoreChat(chatJID, "Test", sent); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.StoreMessage("m-"+chatJID, chatJID, "15550001111", "hello", sent, false, "", "", "", nil, nil, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.PlaceLegalHold(&LegalHold{ChatJID: heldTestGroup, Reason: "litigation", PlacedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// storedChats returns the chats that still have messages
+func storedChats(t *testing.T, store *MessageStore) []string {
+	t.Helper()
+	rows, err := store.db.Query("SELECT DISTINCT chat_jid FROM messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var chats []string
+	for rows.Next() {
+		var chatJID string
+		if err := rows.Scan(&chatJID); err != nil {
+			t.Fatal(err)
+		}
+		chats = append(chats, chatJID)
+	}
+	sort.Strings(chats)
+	return chats
+}
+
+// auditActions returns the actions in a chat's audit log, oldest first
+func auditActions(t *testing.T, store *MessageStore, chatJID string) []string {
+	t.Helper()
+	entries, err := store.ListHoldAuditEntries(chatJID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		actions = append(actions, entries[i].Action+" by "+entries[i].Actor)
+	}
+	return actions
+}
+
+func TestLegalHoldKeepsMessagesFromPruning(t *testing.T) {
+	store := newLegalHoldTestStore(t)
+	deleted, err := store.PruneMessages(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("pruned %d messages, want 2", deleted)
+	}
+	if chats := storedChats(t, store); strings.Join(chats, ",") != heldTestGroup {
+		t.Errorf("messages left in %v, want only the chat on hold", chats)
+	}
+}
+
+func TestLegalHoldKeepsMessagesFromErasure(t *testing.T) {
+	tests := []struct {
+		name  string
+		holds []string
+		held  []string
+		kept  []string
+	}{
+		{"group on hold", nil, []string{heldTestGroup}, []string{heldTestGroup}},
+		{"personal chat on hold", []string{heldTestChat}, []string{heldTestChat, heldTestGroup}, []string{heldTestGroup, heldTestChat}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newLegalHoldTestStore(t)
+			for _, chatJID := range tt.holds {
+				if err := store.PlaceLegalHold(&LegalHold{ChatJID: chatJID, Reason: "litigation", PlacedAt: time.Now().UTC()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report := store.EraseContact(heldTestChat, "15550001111")
+			if len(report.Errors) > 0 {
+				t.Fatalf("erasure failed: %v", report.Errors)
+			}
+			if strings.Join(report.Held, ",") != strings.Join(tt.held, ",") {
+				t.Errorf("report lists %v as held, want %v", report.Held, tt.held)
+			}
+			if chats := storedChats(t, store); strings.Join(chats, ",") != strings.Join(tt.kept, ",") {
+				t.Errorf("messages left in %v, want %v", chats, tt.kept)
+			}
+			for _, chatJID := range tt.held {
+				if actions := auditActions(t, store, chatJID); strings.Join(actions, ",") != HoldErasureKept+" by gdpr" {
+					t.Errorf("%s audit log is %v, want the kept erasure", chatJID, actions)
+				}
+			}
+		})
+	}
+}
+
+func TestLegalHoldReleaseIsAudited(t *testing.T) {
+	store := newLegalHoldTestStore(t)
+	registerLegalHoldRoutes(store)
+
+	release := func(chatJID string) int {
+		recorder := httptest.NewRecorder()
+		serveChatRoute(recorder, httptest.NewRequest(http.MethodDelete, "/api/v1/chats/"+chatJID+"/hold", nil))
+		return recorder.Code
+	}
+	if status := release(heldTestGroup); status != http.StatusNoContent {
+		t.Fatalf("release answered %d", status)
+	}
+	if legalHolds.IsHeld(heldTestGroup) {
+		t.Error("chat is still on hold")
+	}
+	if actions := auditActions(t, store, heldTestGroup); strings.Join(actions, ",") != HoldReleased+" by "+anonymousUsageSubject {
+		t.Errorf("audit log is %v, want the release", actions)
+	}
+
+	if status := release(heldTestGroup); status != http.StatusNotFound {
+		t.Errorf("releasing again answered %d, want 404", status)
+	}
+	if actions := auditActions(t, store, heldTestGroup); len(actions) != 1 {
+		t.Errorf("releasing again was audited: %v", actions)
+	}
+}
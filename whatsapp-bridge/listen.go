@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// basePath is the path prefix the bridge is served under behind a reverse proxy, e.g. "/whatsapp",
+// or "" at the root. Routes are registered without it; links and redirects add it with withBasePath.
+var basePath string
+
+// ListenConfig is where the web server listens, and the limits on the connections it accepts
+type ListenConfig struct {
+	// Host is the interface to bind, empty for all
+	Host     string
+	Port     int
+	BasePath string
+
+	// ReadHeaderTimeout bounds how long a client may take to send the request headers,
+	// which stops slowloris clients from holding connections open
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound reading a whole request and writing its response; 0 disables them
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// IdleTimeout closes keep-alive connections that wait this long for the next request
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// MaxConnections caps the open connections; further clients wait to be accepted. 0 is unlimited.
+	MaxConnections int
+	// HTTP2 serves HTTP/2 without TLS to clients that speak it from the start, such as load balancers
+	// configured for end-to-end HTTP/2; browsers keep using HTTP/1.1 unless a TLS proxy upgrades them
+	HTTP2                bool
+	MaxConcurrentStreams int
+}
+
+// NewListenConfigFromEnv reads PORT, BIND_ADDRESS, BASE_PATH and the HTTP_* limits. The arguments
+// come from the -port, -bind and -base-path flags and win when set.
+func NewListenConfigFromEnv(port int, bind, path string) (ListenConfig, error) {
+	config := ListenConfig{
+		Host:                 os.Getenv("BIND_ADDRESS"),
+		Port:                 8080,
+		BasePath:             os.Getenv("BASE_PATH"),
+		ReadHeaderTimeout:    time.Duration(getEnvInt("HTTP_READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second,
+		ReadTimeout:          time.Duration(getEnvInt("HTTP_READ_TIMEOUT_SECONDS", 300)) * time.Second,
+		WriteTimeout:         time.Duration(getEnvInt("HTTP_WRITE_TIMEOUT_SECONDS", 300)) * time.Second,
+		IdleTimeout:          time.Duration(getEnvInt("HTTP_IDLE_TIMEOUT_SECONDS", 120)) * time.Second,
+		MaxHeaderBytes:       getEnvInt("HTTP_MAX_HEADER_KB", 64) << 10,
+		MaxConnections:       getEnvInt("HTTP_MAX_CONNECTIONS", 1000),
+		HTTP2:                getEnvBool("HTTP2", true),
+		MaxConcurrentStreams: getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+	}
+	if value := os.Getenv("PORT"); value != "" {
+		envPort, err := strconv.Atoi(value)
+		if err != nil {
+			return config, fmt.Errorf("PORT must be a number")
+		}
+		config.Port = envPort
+	}
+	if port != 0 {
+		config.Port = port
+	}
+	if bind != "" {
+		config.Host = bind
+	}
+	if path != "" {
+		config.BasePath = path
+	}
+
+	if config.Port < 1 || config.Port > 65535 {
+		return config, fmt.Errorf("port must be between 1 and 65535")
+	}
+	config.BasePath = strings.TrimRight(config.BasePath, "/")
+	if config.BasePath != "" && !strings.HasPrefix(config.BasePath, "/") {
+		return config, fmt.Errorf("base path must start with /")
+	}
+	if strings.ContainsAny(config.BasePath, "?#'\"<> ") {
+		return config, fmt.Errorf("base path %q contains characters not allowed in a path", config.BasePath)
+	}
+
+	if config.ReadHeaderTimeout <= 0 || config.IdleTimeout <= 0 {
+		return config, fmt.Errorf("HTTP_READ_HEADER_TIMEOUT_SECONDS and HTTP_IDLE_TIMEOUT_SECONDS must be positive")
+	}
+	if config.ReadTimeout < 0 || config.WriteTimeout < 0 {
+		return config, fmt.Errorf("HTTP_READ_TIMEOUT_SECONDS and HTTP_WRITE_TIMEOUT_SECONDS must not be negative")
+	}
+	if config.MaxHeaderBytes < 1<<10 {
+		return config, fmt.Errorf("HTTP_MAX_HEADER_KB must be at least 1")
+	}
+	if config.MaxConnections < 0 {
+		return config, fmt.Errorf("HTTP_MAX_CONNECTIONS must not be negative")
+	}
+	if config.MaxConcurrentStreams < 1 {
+		return config, fmt.Errorf("HTTP2_MAX_CONCURRENT_STREAMS must be positive")
+	}
+	return config, nil
+}
+
+// Addr is the address to listen on
+func (c ListenConfig) Addr() string {
+	return net.JoinHostPort(strings.Trim(c.Host, "[]"), strconv.Itoa(c.Port))
+}
+
+// LocalURL is the URL of the bridge from the machine it runs on, including the base path
+func (c ListenConfig) LocalURL() string {
+	host := strings.Trim(c.Host, "[]")
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(c.Port)) + c.BasePath
+}
+
+// withBasePath prefixes a root-relative path such as "/login" with the base path
+func withBasePath(path string) string {
+	return basePath + path
+}
+
+// basePathHandler serves the routes under the base path and redirects the bare prefix to it with a slash
+func basePathHandler(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// NewServer builds the web server with the configured timeouts and protocols
+func (c ListenConfig) NewServer(handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              c.Addr(),
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		Protocols:         new(http.Protocols),
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: c.MaxConcurrentStreams},
+	}
+	server.Protocols.SetHTTP1(true)
+	// Behind TLS, which a reverse proxy terminates in most deployments, HTTP/2 is negotiated as usual
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(c.HTTP2)
+	return server
+}
+
+// ListenAndServe listens on the configured address and serves the handler until the server fails
+func (c ListenConfig) ListenAndServe(handler http.Handler) error {
+	listener, err := net.Listen("tcp", c.Addr())
+	if err != nil {
+		return err
+	}
+	if c.MaxConnections > 0 {
+		listener = &limitListener{Listener: listener, slots: make(chan struct{}, c.MaxConnections)}
+	}
+	return c.NewServer(handler).Serve(listener)
+}
+
+// limitListener stops accepting connections while all slots are taken, leaving new clients in the backlog
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: sync.OnceFunc(func() { <-l.slots })}, nil
+}
+
+// limitConn frees its slot when closed, whether by the server or by a handler that hijacked it
+type limitConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// clearDeadlines lifts the read and write timeouts for a response that streams for longer,
+// such as the event stream or a large media file. An expired read deadline would cancel the request.
+func clearDeadlines(w http.ResponseWriter) {
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+}
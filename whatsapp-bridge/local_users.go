@@ -0,0 +1,720 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dashboardSessionCookie carries the dashboard session, a Supabase access token or a local session
+const dashboardSessionCookie = "sb-access-token"
+
+// Purposes of a local dashboard session
+const (
+	SessionPurposeLogin  = "login"
+	SessionPurposeInvite = "invite"
+)
+
+const (
+	// dashboardSessionTTL matches the lifetime of the session cookie
+	dashboardSessionTTL = time.Hour
+	// minPasswordLength is the shortest password a local account accepts
+	minPasswordLength = 10
+	// defaultAdminEmail is the bootstrap admin's email without DASHBOARD_ADMIN_EMAIL
+	defaultAdminEmail = "admin@localhost"
+	// adminPasswordFile holds the bootstrap admin's generated password in the data directory, so it
+	// never reaches the log or diagnostic bundles
+	adminPasswordFile = "admin-password.txt"
+)
+
+// errDashboardUserExists is returned when creating a user whose email is taken
+var errDashboardUserExists = errors.New("a user with this email already exists")
+
+// DashboardUser is someone who signs in to the dashboard when Supabase isn't configured
+type DashboardUser struct {
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	Admin       bool      `json:"admin"`
+	HasPassword bool      `json:"has_password"`
+	Passkeys    int       `json:"passkeys"`
+	CreatedAt   time.Time `json:"created_at"`
+	// passwordHash is the bcrypt hash of the password; empty for passkey-only users
+	passwordHash string
+}
+
+// LocalAuth signs dashboard users in against the bridge's own user table. It is used when
+// Supabase isn't configured, so the dashboard is never left open.
+type LocalAuth struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	// dummyHash is compared against for unknown emails, so they take as long as wrong passwords
+	dummyHash []byte
+}
+
+// localAuth is set once the message store is open
+var localAuth *LocalAuth
+
+// NewLocalAuthFromEnv creates the bootstrap admin when there is no admin yet. Its email is
+// DASHBOARD_ADMIN_EMAIL and its password DASHBOARD_ADMIN_PASSWORD, or a random one written to
+// adminPasswordFile.
+func NewLocalAuthFromEnv(messageStore *MessageStore, logger waLog.Logger) (*LocalAuth, error) {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte(newEventID()), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	a := &LocalAuth{messageStore: messageStore, logger: logger, dummyHash: dummyHash}
+	if os.Getenv("SUPABASE_URL") != "" && os.Getenv("SUPABASE_ANON_KEY") != "" {
+		return a, nil
+	}
+
+	admins, err := messageStore.CountDashboardAdmins()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count dashboard admins: %v", err)
+	}
+	if admins > 0 {
+		return a, nil
+	}
+
+	email := strings.ToLower(strings.TrimSpace(os.Getenv("DASHBOARD_ADMIN_EMAIL")))
+	if email == "" {
+		email = defaultAdminEmail
+	}
+	password := os.Getenv("DASHBOARD_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		secret := make([]byte, 15)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		password = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DASHBOARD_ADMIN_PASSWORD: %v", err)
+	}
+
+	// A user from before local accounts, e.g. with a passkey, is promoted rather than duplicated
+	user, err := messageStore.EnsureDashboardUser(email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard admin: %v", err)
+	}
+	admin := true
+	if err := messageStore.UpdateDashboardUser(user.ID, &hash, &admin); err != nil {
+		return nil, fmt.Errorf("failed to create dashboard admin: %v", err)
+	}
+	if generated {
+		if err := writeAdminPassword(email, password); err != nil {
+			return nil, fmt.Errorf("failed to store the dashboard admin password: %v", err)
+		}
+		logger.Warnf("Created dashboard admin %s; its password is in %s until you sign in and change it on the Passkeys page",
+			email, dataPath(adminPasswordFile))
+	} else {
+		logger.Infof("Created dashboard admin %s", email)
+	}
+	return a, nil
+}
+
+// writeAdminPassword writes the bootstrap admin's email and password to adminPasswordFile, readable
+// only by the bridge's user
+func writeAdminPassword(email, password string) error {
+	if err := ensureDataDir(); err != nil {
+		return err
+	}
+	path := dataPath(adminPasswordFile)
+	// A file left from an earlier install may be readable by others; start afresh
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(file, "%s\n%s\n", email, password); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeAdminPassword deletes adminPasswordFile once the admin it was written for changes their password
+func removeAdminPassword(email string) {
+	data, err := os.ReadFile(dataPath(adminPasswordFile))
+	if err != nil {
+		return
+	}
+	if written, _, _ := strings.Cut(string(data), "\n"); written == email {
+		os.Remove(dataPath(adminPasswordFile))
+	}
+}
+
+// hashPassword checks a password's length and hashes it with bcrypt
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	// bcrypt ignores everything after 72 bytes, so longer passwords would be silently truncated
+	if len(password) > 72 {
+		return "", errors.New("password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Authenticate checks an email and password, returning the user or nil if they don't match
+func (a *LocalAuth) Authenticate(email, password string) (*DashboardUser, error) {
+	user, err := a.messageStore.GetDashboardUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.passwordHash == "" {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.passwordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// SessionUser returns the user signed in with the request's local session, or nil
+func (a *LocalAuth) SessionUser(r *http.Request) *DashboardUser {
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if cookie, err := r.Cookie(dashboardSessionCookie); err == nil {
+		token = cookie.Value
+	}
+	return a.TokenUser(token)
+}
+
+// TokenUser returns the user a local session token belongs to, or nil
+func (a *LocalAuth) TokenUser(token string) *DashboardUser {
+	if a == nil || token == "" {
+		return nil
+	}
+	user, err := a.messageStore.DashboardSessionUser(token, SessionPurposeLogin)
+	if err != nil {
+		a.logger.Warnf("Failed to look up dashboard session: %v", err)
+		return nil
+	}
+	return user
+}
+
+// StartSession signs a user in and sets the session cookie
+func (a *LocalAuth) StartSession(w http.ResponseWriter, r *http.Request, user *DashboardUser) error {
+	token, _, err := a.messageStore.CreateDashboardSession(user.ID, SessionPurposeLogin, dashboardSessionTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardSessionCookie,
+		Value:    token,
+		Path:     withBasePath("/"),
+		MaxAge:   int(dashboardSessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// dashboardAdminOnly lets user and key management through for a signed-in dashboard admin, or an
+// issued API key with the admin scope. These routes create accounts and keys, so unlike the rest
+// of the API they are never open to requests without a key, whatever API_KEYS_REQUIRED says.
+func dashboardAdminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if user := localAuth.SessionUser(r); user != nil {
+			if !user.Admin {
+				http.Error(w, "Only dashboard admins can manage users and API keys", http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+			return
+		}
+
+		presented := requestAPIKey(r)
+		if presented == "" || apiKeys == nil {
+			http.Error(w, "Sign in as a dashboard admin or send an admin API key", http.StatusUnauthorized)
+			return
+		}
+		key, err := apiKeys.Lookup(presented)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if key == nil {
+			http.Error(w, "Invalid or revoked API key", http.StatusUnauthorized)
+			return
+		}
+		if !key.allows(ScopeAdmin) {
+			http.Error(w, fmt.Sprintf("API key %s lacks the %s scope", key.ID, ScopeAdmin), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// dashboardUserRequest is the body of POST /api/v1/admin/users and PATCH /api/v1/admin/users/{id}
+type dashboardUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Admin    *bool  `json:"admin"`
+}
+
+// registerLocalUserRoutes registers /api/v1/admin/users and /api/v1/admin/users/{id}
+func registerLocalUserRoutes(messageStore *MessageStore) {
+	handleAPI("/admin/users", dashboardAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			users, err := messageStore.ListDashboardUsers()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list users: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(users)
+
+		case http.MethodPost:
+			var req dashboardUserRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			email := strings.ToLower(strings.TrimSpace(req.Email))
+			if !strings.Contains(email, "@") {
+				http.Error(w, "email is required", http.StatusBadRequest)
+				return
+			}
+			// Without a password the user signs in with a passkey, registered through an invite
+			hash := ""
+			if req.Password != "" {
+				var err error
+				if hash, err = hashPassword(req.Password); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			user, err := messageStore.CreateDashboardUser(email, hash, req.Admin != nil && *req.Admin)
+			if errors.Is(err, errDashboardUserExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(user)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	handleAPI("/admin/users/", dashboardAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(apiRoute(r), "/admin/users/")
+		user, err := messageStore.GetDashboardUser(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var req dashboardUserRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			var hash *string
+			if req.Password != "" {
+				value, err := hashPassword(req.Password)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				hash = &value
+			}
+			if req.Admin != nil && !*req.Admin && user.Admin && !otherAdminExists(w, messageStore) {
+				return
+			}
+			if err := messageStore.UpdateDashboardUser(user.ID, hash, req.Admin); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to update user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			// A new password signs the user out everywhere
+			if hash != nil {
+				if err := messageStore.DeleteDashboardSessions(user.ID); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to end sessions: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, err = messageStore.GetDashboardUser(user.ID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(user)
+
+		case http.MethodDelete:
+			if user.Admin && !otherAdminExists(w, messageStore) {
+				return
+			}
+			if err := messageStore.DeleteDashboardUser(user.ID); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to delete user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// otherAdminExists writes 409 when the only admin would lose their access
+func otherAdminExists(w http.ResponseWriter, messageStore *MessageStore) bool {
+	admins, err := messageStore.CountDashboardAdmins()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count admins: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if admins <= 1 {
+		http.Error(w, "The last admin can't be removed or demoted", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// ServeChangePassword handles POST /auth/password, where signed-in users change their own password
+func (q *QRWebServer) ServeChangePassword(w http.ResponseWriter, r *http.Request) {
+	if q.supabaseClient != nil {
+		http.Error(w, "Passwords are managed by Supabase", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := localAuth.SessionUser(r)
+	if user == nil {
+		http.Error(w, "Sign in to change your password", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	// Passkey-only users set their first password without one
+	if user.HasPassword {
+		current, err := localAuth.Authenticate(user.Email, req.CurrentPassword)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if current == nil {
+			http.Error(w, "The current password is wrong", http.StatusForbidden)
+			return
+		}
+	}
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := localAuth.messageStore.UpdateDashboardUser(user.ID, &hash, nil); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to change password: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Other sessions end; this one continues with a fresh session
+	if err := localAuth.messageStore.DeleteDashboardSessions(user.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to end sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := localAuth.StartSession(w, r, user); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to sign in: %v", err), http.StatusInternalServerError)
+		return
+	}
+	removeAdminPassword(user.Email)
+	localAuth.logger.Infof("Dashboard user %s changed their password", user.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newSessionToken returns a random token for a dashboard session or invite
+func newSessionToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// dashboardUserColumns are the columns scanDashboardUser reads, in order
+const dashboardUserColumns = "id, email, password_hash, is_admin, created_at"
+
+// scanDashboardUser reads a dashboard_users row
+func scanDashboardUser(scan func(dest ...interface{}) error) (*DashboardUser, error) {
+	var user DashboardUser
+	var passwordHash sql.NullString
+	if err := scan(&user.ID, &user.Email, &passwordHash, &user.Admin, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	user.passwordHash = passwordHash.String
+	user.HasPassword = user.passwordHash != ""
+	return &user, nil
+}
+
+// getDashboardUserWhere returns the user matching one column, or nil
+func (store *MessageStore) getDashboardUserWhere(column, value string) (*DashboardUser, error) {
+	query := "SELECT " + dashboardUserColumns + " FROM dashboard_users WHERE " + column + " = ?"
+	if store.isPostgres {
+		query = "SELECT " + dashboardUserColumns + " FROM dashboard_users WHERE " + column + " = $1"
+	}
+	user, err := scanDashboardUser(store.db.QueryRow(query, value).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// GetDashboardUser returns a user by ID, or nil if there is none
+func (store *MessageStore) GetDashboardUser(id string) (*DashboardUser, error) {
+	return store.getDashboardUserWhere("id", id)
+}
+
+// GetDashboardUserByEmail returns a user by email, or nil if there is none
+func (store *MessageStore) GetDashboardUserByEmail(email string) (*DashboardUser, error) {
+	return store.getDashboardUserWhere("email", strings.ToLower(strings.TrimSpace(email)))
+}
+
+// CreateDashboardUser adds a user; passwordHash is empty for users who sign in with a passkey
+func (store *MessageStore) CreateDashboardUser(email, passwordHash string, admin bool) (*DashboardUser, error) {
+	existing, err := store.GetDashboardUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errDashboardUserExists
+	}
+
+	user := &DashboardUser{
+		ID:           "usr_" + newEventID(),
+		Email:        strings.ToLower(strings.TrimSpace(email)),
+		Admin:        admin,
+		HasPassword:  passwordHash != "",
+		CreatedAt:    time.Now().UTC(),
+		passwordHash: passwordHash,
+	}
+	query := "INSERT INTO dashboard_users (id, email, password_hash, is_admin, created_at) VALUES (?, ?, ?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO dashboard_users (id, email, password_hash, is_admin, created_at) VALUES ($1, $2, $3, $4, $5)"
+	}
+	var hash interface{}
+	if passwordHash != "" {
+		hash = passwordHash
+	}
+	if _, err := store.db.Exec(query, user.ID, user.Email, hash, user.Admin, user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// EnsureDashboardUser returns the user with an email, creating them without a password if need be
+func (store *MessageStore) EnsureDashboardUser(email string) (*DashboardUser, error) {
+	user, err := store.GetDashboardUserByEmail(email)
+	if err != nil || user != nil {
+		return user, err
+	}
+	user, err = store.CreateDashboardUser(email, "", false)
+	if errors.Is(err, errDashboardUserExists) {
+		// Created by another replica in the meantime
+		return store.GetDashboardUserByEmail(email)
+	}
+	return user, err
+}
+
+// UpdateDashboardUser sets a user's password hash and admin flag; nil leaves a field as it is
+func (store *MessageStore) UpdateDashboardUser(id string, passwordHash *string, admin *bool) error {
+	sets := []string{}
+	args := []interface{}{}
+	if passwordHash != nil {
+		sets = append(sets, "password_hash = ?")
+		args = append(args, *passwordHash)
+	}
+	if admin != nil {
+		sets = append(sets, "is_admin = ?")
+		args = append(args, *admin)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	query := "UPDATE dashboard_users SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+	args = append(args, id)
+	if store.isPostgres {
+		query = numberPlaceholders(query)
+	}
+	_, err := store.db.Exec(query, args...)
+	return err
+}
+
+// numberPlaceholders turns ? placeholders into PostgreSQL's $1, $2, ...
+func numberPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// DeleteDashboardUser removes a user with their passkeys and sessions
+func (store *MessageStore) DeleteDashboardUser(id string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"dashboard_passkeys", "dashboard_sessions"} {
+		query := "DELETE FROM " + table + " WHERE user_id = ?"
+		if store.isPostgres {
+			query = "DELETE FROM " + table + " WHERE user_id = $1"
+		}
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	query := "DELETE FROM dashboard_users WHERE id = ?"
+	if store.isPostgres {
+		query = "DELETE FROM dashboard_users WHERE id = $1"
+	}
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDashboardUsers returns every user with their number of passkeys, by email
+func (store *MessageStore) ListDashboardUsers() ([]DashboardUser, error) {
+	rows, err := store.db.Query(`SELECT u.id, u.email, u.password_hash, u.is_admin, u.created_at,
+		(SELECT COUNT(*) FROM dashboard_passkeys p WHERE p.user_id = u.id)
+		FROM dashboard_users u ORDER BY u.email`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []DashboardUser{}
+	for rows.Next() {
+		var passkeys int
+		user, err := scanDashboardUser(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &passkeys)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		user.Passkeys = passkeys
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// CountDashboardAdmins returns how many users are admins
+func (store *MessageStore) CountDashboardAdmins() (int, error) {
+	var count int
+	err := store.db.QueryRow("SELECT COUNT(*) FROM dashboard_users WHERE is_admin = TRUE").Scan(&count)
+	return count, err
+}
+
+// CreateDashboardSession starts a session for a user and returns its token; only a hash of the
+// token is stored, like API keys. Expired sessions are cleared on the way.
+func (store *MessageStore) CreateDashboardSession(userID, purpose string, ttl time.Duration) (string, time.Time, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(ttl)
+
+	cleanup := "DELETE FROM dashboard_sessions WHERE expires_at < ?"
+	insert := "INSERT INTO dashboard_sessions (token_hash, user_id, purpose, created_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+	if store.isPostgres {
+		cleanup = "DELETE FROM dashboard_sessions WHERE expires_at < $1"
+		insert = "INSERT INTO dashboard_sessions (token_hash, user_id, purpose, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)"
+	}
+	if _, err := store.db.Exec(cleanup, now); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := store.db.Exec(insert, hashAPIKey(token), userID, purpose, now, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// DashboardSessionUser returns the user of an unexpired session, or nil
+func (store *MessageStore) DashboardSessionUser(token, purpose string) (*DashboardUser, error) {
+	query := `SELECT u.id, u.email, u.password_hash, u.is_admin, u.created_at FROM dashboard_sessions s
+		JOIN dashboard_users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.purpose = ? AND s.expires_at > ?`
+	if store.isPostgres {
+		query = `SELECT u.id, u.email, u.password_hash, u.is_admin, u.created_at FROM dashboard_sessions s
+		JOIN dashboard_users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.purpose = $2 AND s.expires_at > $3`
+	}
+	user, err := scanDashboardUser(store.db.QueryRow(query, hashAPIKey(token), purpose, time.Now().UTC()).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// DeleteDashboardSession ends a session or uses up an invite
+func (store *MessageStore) DeleteDashboardSession(token string) error {
+	query := "DELETE FROM dashboard_sessions WHERE token_hash = ?"
+	if store.isPostgres {
+		query = "DELETE FROM dashboard_sessions WHERE token_hash = $1"
+	}
+	_, err := store.db.Exec(query, hashAPIKey(token))
+	return err
+}
+
+// DeleteDashboardSessions signs a user out everywhere
+func (store *MessageStore) DeleteDashboardSessions(userID string) error {
+	query := "DELETE FROM dashboard_sessions WHERE user_id = ? AND purpose = ?"
+	if store.isPostgres {
+		query = "DELETE FROM dashboard_sessions WHERE user_id = $1 AND purpose = $2"
+	}
+	_, err := store.db.Exec(query, userID, SessionPurposeLogin)
+	return err
+}
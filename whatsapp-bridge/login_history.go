@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Outcomes of a dashboard login
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failed"
+)
+
+// DashboardLogin is one attempt to sign in to the dashboard, served by /api/v1/admin/logins
+type DashboardLogin struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Outcome   string `json:"outcome"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Region    string `json:"region,omitempty"`
+	City      string `json:"city,omitempty"`
+	// NewCountry is set on successful logins from a country the user never signed in from before
+	NewCountry bool      `json:"new_country"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// GeoLocation is where an IP address is, as coarse as the database it came from
+type GeoLocation struct {
+	Country string
+	Region  string
+	City    string
+}
+
+// geoRange is a block of addresses, as 16-byte IPs so IPv4 and IPv6 compare alike
+type geoRange struct {
+	start    [16]byte
+	end      [16]byte
+	location int
+}
+
+// GeoIPDatabase locates IP addresses offline, so logins aren't sent to a lookup service
+type GeoIPDatabase struct {
+	ranges    []geoRange
+	locations []GeoLocation
+}
+
+// LoadGeoIPDatabase reads an IP range CSV in the DB-IP Lite layout, plain or gzipped: either
+// start,end,country or start,end,continent,country,region,city,...
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+	defer file.Close()
+
+	var source io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GeoIP database: %v", err)
+		}
+		defer gz.Close()
+		source = gz
+	}
+
+	reader := csv.NewReader(source)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	db := &GeoIPDatabase{}
+	// Databases repeat a few thousand locations across millions of ranges
+	known := map[GeoLocation]int{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid GeoIP database %s: %v", path, err)
+		}
+
+		var location GeoLocation
+		switch {
+		case len(record) == 3:
+			location.Country = record[2]
+		case len(record) >= 6:
+			location = GeoLocation{Country: record[3], Region: record[4], City: record[5]}
+		default:
+			return nil, fmt.Errorf("invalid GeoIP database %s: line %d has %d columns", path, line, len(record))
+		}
+		// ZZ marks reserved and unassigned ranges
+		if location.Country == "" || location.Country == "ZZ" {
+			continue
+		}
+
+		start, end := net.ParseIP(record[0]), net.ParseIP(record[1])
+		if start == nil || end == nil {
+			if line == 1 {
+				// A header row
+				continue
+			}
+			return nil, fmt.Errorf("invalid GeoIP database %s: line %d has no IP range", path, line)
+		}
+
+		index, ok := known[location]
+		if !ok {
+			index = len(db.locations)
+			db.locations = append(db.locations, location)
+			known[location] = index
+		}
+		r := geoRange{location: index}
+		copy(r.start[:], start.To16())
+		copy(r.end[:], end.To16())
+		db.ranges = append(db.ranges, r)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start[:], db.ranges[j].start[:]) < 0
+	})
+	return db, nil
+}
+
+// Lookup returns the location of an IP address, or nil if the database doesn't cover it
+func (db *GeoIPDatabase) Lookup(address string) *GeoLocation {
+	ip := net.ParseIP(address)
+	if db == nil || ip == nil {
+		return nil
+	}
+	var key [16]byte
+	copy(key[:], ip.To16())
+
+	// The last range starting at or before the address is the only one that can hold it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start[:], key[:]) > 0
+	})
+	if i == 0 || bytes.Compare(db.ranges[i-1].end[:], key[:]) < 0 {
+		return nil
+	}
+	location := db.locations[db.ranges[i-1].location]
+	return &location
+}
+
+// LoginMonitor records dashboard logins and alerts when an account signs in from a new country
+type LoginMonitor struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	geo          *GeoIPDatabase
+	alertURL     string
+}
+
+// loginMonitor is set once the message store is open
+var loginMonitor *LoginMonitor
+
+// NewLoginMonitorFromEnv loads the LOGIN_GEOIP_DATABASE, if set; without it logins are
+// recorded by IP only
+func NewLoginMonitorFromEnv(messageStore *MessageStore, logger waLog.Logger) (*LoginMonitor, error) {
+	m := &LoginMonitor{messageStore: messageStore, logger: logger, alertURL: os.Getenv("ALERT_WEBHOOK_URL")}
+	if path := os.Getenv("LOGIN_GEOIP_DATABASE"); path != "" {
+		geo, err := LoadGeoIPDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Locating dashboard logins with %d IP ranges from %s", len(geo.ranges), path)
+		m.geo = geo
+	}
+	return m, nil
+}
+
+// Record stores a login attempt. A successful login from a country the user hasn't signed in
+// from before raises an alert; a user's first login only sets where they usually are.
+func (m *LoginMonitor) Record(r *http.Request, email string, success bool) {
+	if m == nil {
+		return
+	}
+	login := DashboardLogin{
+		ID:        newEventID(),
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		Outcome:   LoginFailed,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Timestamp: time.Now().UTC(),
+	}
+	if success {
+		login.Outcome = LoginSucceeded
+	}
+	if location := m.geo.Lookup(login.IP); location != nil {
+		login.Country, login.Region, login.City = location.Country, location.Region, location.City
+	}
+
+	if success && login.Country != "" {
+		seen, located, err := m.messageStore.LoginCountrySeen(login.Email, login.Country)
+		if err != nil {
+			m.logger.Warnf("Failed to check login countries of %s: %v", login.Email, err)
+		}
+		login.NewCountry = err == nil && located && !seen
+	}
+
+	if err := m.messageStore.SaveDashboardLogin(login); err != nil {
+		m.logger.Warnf("Failed to record dashboard login: %v", err)
+	}
+	if login.NewCountry {
+		m.alert(login)
+	}
+}
+
+// alert reports a login from a new country on every channel
+func (m *LoginMonitor) alert(login DashboardLogin) {
+	where := login.Country
+	if login.City != "" {
+		where = login.City + ", " + login.Country
+	}
+	message := fmt.Sprintf("Dashboard login for %s from a new country: %s (IP %s). Review GET /api/v1/admin/logins if this wasn't them.",
+		login.Email, where, login.IP)
+	m.logger.Warnf("%s", message)
+	publishEvent(EventDashboardLoginNewCountry, "", login.Timestamp, map[string]interface{}{
+		"id":         login.ID,
+		"email":      login.Email,
+		"ip":         login.IP,
+		"user_agent": login.UserAgent,
+		"country":    login.Country,
+		"region":     login.Region,
+		"city":       login.City,
+	})
+	if m.alertURL != "" {
+		go func() {
+			if err := postAlert(m.alertURL, message); err != nil {
+				m.logger.Errorf("Failed to send login alert: %v", err)
+			}
+		}()
+	}
+}
+
+// LoginCountrySeen reports whether a user signed in successfully from a country before, and
+// whether any of their earlier successful logins was located at all
+func (store *MessageStore) LoginCountrySeen(email, country string) (bool, bool, error) {
+	query := `SELECT COALESCE(MAX(CASE WHEN country = ? THEN 1 ELSE 0 END), 0), COUNT(*)
+		FROM dashboard_logins WHERE email = ? AND outcome = ? AND country IS NOT NULL AND country != ''`
+	if store.isPostgres {
+		query = `SELECT COALESCE(MAX(CASE WHEN country = $1 THEN 1 ELSE 0 END), 0), COUNT(*)
+		FROM dashboard_logins WHERE email = $2 AND outcome = $3 AND country IS NOT NULL AND country != ''`
+	}
+	var seen, located int
+	if err := store.db.QueryRow(query, country, email, LoginSucceeded).Scan(&seen, &located); err != nil {
+		return false, false, err
+	}
+	return seen > 0, located > 0, nil
+}
+
+// SaveDashboardLogin stores a login attempt
+func (store *MessageStore) SaveDashboardLogin(login DashboardLogin) error {
+	query := `INSERT INTO dashboard_logins (id, email, outcome, ip, user_agent, country, region, city, new_country, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO dashboard_logins (id, email, outcome, ip, user_agent, country, region, city, new_country, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	}
+	_, err := store.db.Exec(query, login.ID, login.Email, login.Outcome, login.IP, login.UserAgent,
+		login.Country, login.Region, login.City, login.NewCountry, login.Timestamp)
+	return err
+}
+
+// ListDashboardLogins returns the most recent logins, newest first, optionally of one user
+func (store *MessageStore) ListDashboardLogins(email string, limit int) ([]DashboardLogin, error) {
+	query := `SELECT id, email, outcome, ip, user_agent, country, region, city, new_country, timestamp FROM dashboard_logins`
+	args := []interface{}{}
+	if email != "" {
+		query += " WHERE email = ?"
+		args = append(args, strings.ToLower(email))
+	}
+	query += " ORDER BY timestamp DESC LIMIT ?"
+	args = append(args, limit)
+	if store.isPostgres {
+		query = strings.Replace(query, "email = ?", "email = $1", 1)
+		query = strings.Replace(query, "LIMIT ?", "LIMIT $"+strconv.Itoa(len(args)), 1)
+	}
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := []DashboardLogin{}
+	for rows.Next() {
+		var login DashboardLogin
+		var userAgent, country, region, city sql.NullString
+		if err := rows.Scan(&login.ID, &login.Email, &login.Outcome, &login.IP, &userAgent, &country, &region, &city,
+			&login.NewCountry, &login.Timestamp); err != nil {
+			return nil, err
+		}
+		login.UserAgent = userAgent.String
+		login.Country = country.String
+		login.Region = region.String
+		login.City = city.String
+		logins = append(logins, login)
+	}
+	return logins, rows.Err()
+}
+
+// registerLoginHistoryRoutes registers /api/v1/admin/logins
+func registerLoginHistoryRoutes(messageStore *MessageStore) {
+	handleAPI("/admin/logins", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 1000 {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+
+		logins, err := messageStore.ListDashboardLogins(r.URL.Query().Get("email"), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get login history: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range logins {
+			logins[i].Timestamp = logins[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logins)
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// logLevelNames are the levels of LOG_LEVEL, from the most to the least verbose
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// logColors match whatsmeow's stdout logger, which the bridge's logger replaces
+var logColors = map[string]string{
+	"INFO":  "\033[36m",
+	"WARN":  "\033[33m",
+	"ERROR": "\033[31m",
+}
+
+// LogLevelState is returned by /api/v1/admin/loglevel
+type LogLevelState struct {
+	Level string `json:"level"`
+	// DefaultLevel is LOG_LEVEL, which a temporary level falls back to
+	DefaultLevel string `json:"default_level"`
+	// ExpiresAt is when a temporary level falls back to the default
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LogLevelController holds the level every logger of the bridge and whatsmeow writes at,
+// so it can be raised while the bridge runs
+type LogLevelController struct {
+	level      atomic.Int32
+	defaultLvl int32
+	timeout    time.Duration
+	expiresAt  time.Time
+	timer      *time.Timer
+	mutex      sync.Mutex
+}
+
+// logLevel is configured from LOG_LEVEL by initLogLevel
+var logLevel = newLogLevelController(1)
+
+func newLogLevelController(level int32) *LogLevelController {
+	controller := &LogLevelController{defaultLvl: level, timeout: 30 * time.Minute}
+	controller.level.Store(level)
+	return controller
+}
+
+// parseLogLevel returns the position of a level name in logLevelNames
+func parseLogLevel(name string) (int32, error) {
+	for i, level := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), level) {
+			return int32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+}
+
+// initLogLevel reads LOG_LEVEL and LOG_LEVEL_TIMEOUT_MINUTES, and lets SIGUSR1 toggle debug logging
+func initLogLevel() error {
+	level := int32(1)
+	if name := os.Getenv("LOG_LEVEL"); name != "" {
+		var err error
+		if level, err = parseLogLevel(name); err != nil {
+			return err
+		}
+	}
+	minutes := getEnvInt("LOG_LEVEL_TIMEOUT_MINUTES", 30)
+	if minutes < 0 {
+		return fmt.Errorf("LOG_LEVEL_TIMEOUT_MINUTES must not be negative")
+	}
+
+	logLevel.mutex.Lock()
+	logLevel.defaultLvl = level
+	logLevel.timeout = time.Duration(minutes) * time.Minute
+	logLevel.level.Store(level)
+	logLevel.mutex.Unlock()
+
+	watchLogLevelSignal()
+	return nil
+}
+
+// Enabled reports whether messages of a level are written
+func (c *LogLevelController) Enabled(level int32) bool {
+	return level >= c.level.Load()
+}
+
+// Set changes the level. A level other than the default falls back to it after duration,
+// unless duration is 0.
+func (c *LogLevelController) Set(name string, duration time.Duration) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.expiresAt = time.Time{}
+	if level != c.defaultLvl && duration > 0 {
+		c.expiresAt = time.Now().Add(duration)
+		c.timer = time.AfterFunc(duration, c.expire)
+	}
+	c.level.Store(level)
+
+	if c.expiresAt.IsZero() {
+		fmt.Printf("Log level set to %s\n", logLevelNames[level])
+	} else {
+		fmt.Printf("Log level set to %s until %s\n", logLevelNames[level], c.expiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Toggle switches between debug logging, for the default timeout, and the default level
+func (c *LogLevelController) Toggle() {
+	c.mutex.Lock()
+	debug := c.level.Load() == 0 && c.defaultLvl != 0
+	defaultName, timeout := logLevelNames[c.defaultLvl], c.timeout
+	c.mutex.Unlock()
+
+	if debug {
+		c.Set(defaultName, 0)
+	} else {
+		c.Set("DEBUG", timeout)
+	}
+}
+
+// Timeout is how long a raised level lasts when no duration is given
+func (c *LogLevelController) Timeout() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.timeout
+}
+
+// expire falls back to the default level once a temporary level runs out
+func (c *LogLevelController) expire() {
+	c.mutex.Lock()
+	expired := !c.expiresAt.IsZero() && !time.Now().Before(c.expiresAt)
+	defaultName := logLevelNames[c.defaultLvl]
+	c.mutex.Unlock()
+
+	if expired {
+		c.Set(defaultName, 0)
+	}
+}
+
+// State describes the current level
+func (c *LogLevelController) State() LogLevelState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := LogLevelState{
+		Level:        logLevelNames[c.level.Load()],
+		DefaultLevel: logLevelNames[c.defaultLvl],
+	}
+	if !c.expiresAt.IsZero() {
+		expiresAt := c.expiresAt.UTC()
+		state.ExpiresAt = &expiresAt
+	}
+	return state
+}
+
+// leveledLogger writes like whatsmeow's stdout logger, at the level logLevel holds right now
+type leveledLogger struct {
+	module string
+	color  bool
+}
+
+// newLogger returns a logger for a module that follows the runtime log level
+func newLogger(module string) waLog.Logger {
+	return &leveledLogger{module: module, color: true}
+}
+
+func (l *leveledLogger) output(level int32, msg string, args ...interface{}) {
+	if !logLevel.Enabled(level) {
+		return
+	}
+	name := logLevelNames[level]
+	var colorStart, colorReset string
+	if l.color && logColors[name] != "" {
+		colorStart, colorReset = logColors[name], "\033[0m"
+	}
+	fmt.Printf("%s%s [%s %s] %s%s\n", time.Now().Format("15:04:05.000"), colorStart, l.module, name, fmt.Sprintf(msg, args...), colorReset)
+}
+
+func (l *leveledLogger) Debugf(msg string, args ...interface{}) { l.output(0, msg, args...) }
+func (l *leveledLogger) Infof(msg string, args ...interface{})  { l.output(1, msg, args...) }
+func (l *leveledLogger) Warnf(msg string, args ...interface{})  { l.output(2, msg, args...) }
+func (l *leveledLogger) Errorf(msg string, args ...interface{}) { l.output(3, msg, args...) }
+
+func (l *leveledLogger) Sub(module string) waLog.Logger {
+	return &leveledLogger{module: l.module + "/" + module, color: l.color}
+}
+
+// LogLevelRequest is the body of POST /api/v1/admin/loglevel
+type LogLevelRequest struct {
+	Level string `json:"level"`
+	// DurationSeconds is how long the level lasts; 0 keeps it until it's changed again,
+	// and leaving it out uses LOG_LEVEL_TIMEOUT_MINUTES
+	DurationSeconds *int `json:"duration_seconds,omitempty"`
+}
+
+// registerLogLevelRoutes registers /api/v1/admin/loglevel
+func registerLogLevelRoutes() {
+	handleAPI("/admin/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPost:
+			var req LogLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			duration := logLevel.Timeout()
+			if req.DurationSeconds != nil {
+				if *req.DurationSeconds < 0 {
+					http.Error(w, "duration_seconds must not be negative", http.StatusBadRequest)
+					return
+				}
+				duration = time.Duration(*req.DurationSeconds) * time.Second
+			}
+			if err := logLevel.Set(req.Level, duration); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state := logLevel.State()
+		if state.ExpiresAt != nil {
+			expiresAt := state.ExpiresAt.In(loc)
+			state.ExpiresAt = &expiresAt
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+}
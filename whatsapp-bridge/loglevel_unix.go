@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignal toggles debug logging on SIGUSR1, e.g. kill -USR1 <pid>
+func watchLogLevelSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			logLevel.Toggle()
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// watchLogLevelSignal does nothing on Windows, which has no SIGUSR1; use /api/v1/admin/loglevel
+func watchLogLevelSignal() {}
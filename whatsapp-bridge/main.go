@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"net/http"
@@ -37,12 +39,22 @@ type Message struct {
 	IsFromMe  bool
 	MediaType string
 	Filename  string
+	// Reactions maps each emoji to the number of people who reacted with it
+	Reactions map[string]int `json:",omitempty"`
+	// MyReaction is the emoji this account reacted with
+	MyReaction string `json:",omitempty"`
+
+	id string
 }
 
 // Database handler for storing message history
 type MessageStore struct {
 	db *sql.DB
 	isPostgres bool
+	// account is set on the stores of additional linked accounts, which change capture and the cache don't cover
+	account string
+	// fullTextSearch is set once the full-text index of messages is in place
+	fullTextSearch bool
 }
 
 // Initialize message store
@@ -55,53 +67,56 @@ func NewMessageStore(dbAdapter *DatabaseAdapter) (*MessageStore, error) {
 			return nil, fmt.Errorf("failed to get PostgreSQL database connection: %v", err)
 		}
 		
-		return &MessageStore{db: db, isPostgres: true}, nil
+		store := &MessageStore{db: db, isPostgres: true}
+		if err := store.ensureSchema(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to update message schema: %v", err)
+		}
+		
+		return store, nil
 	}
 	
 	// Fallback to SQLite
 	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
-		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	if err := ensureDataDir(); err != nil {
+		return nil, err
 	}
 
-	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
+	return openSQLiteMessageStore("messages.db")
+}
+
+// openPostgresMessageStore opens a message store in a PostgreSQL database
+func openPostgresMessageStore(dsn string) (*MessageStore, error) {
+	db, err := sql.Open(sqlDriverName("postgres"), dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open message database: %v", err)
 	}
 
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS chats (
-			jid TEXT PRIMARY KEY,
-			name TEXT,
-			last_message_time TIMESTAMP
-		);
-		
-		CREATE TABLE IF NOT EXISTS messages (
-			id TEXT,
-			chat_jid TEXT,
-			sender TEXT,
-			content TEXT,
-			timestamp TIMESTAMP,
-			is_from_me BOOLEAN,
-			media_type TEXT,
-			filename TEXT,
-			url TEXT,
-			media_key BLOB,
-			file_sha256 BLOB,
-			file_enc_sha256 BLOB,
-			file_length INTEGER,
-			PRIMARY KEY (id, chat_jid),
-			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
-		);
-	`)
+	store := &MessageStore{db: db, isPostgres: true}
+	if err := store.ensureSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to update message schema: %v", err)
+	}
+
+	return store, nil
+}
+
+// openSQLiteMessageStore opens a message store in a SQLite file, relative to the data directory
+func openSQLiteMessageStore(name string) (*MessageStore, error) {
+	// Recursive triggers let INSERT OR REPLACE remove the replaced message from the full-text index
+	db, err := sql.Open(sqlDriverName("sqlite3"), sqliteDSN(name)+"&_recursive_triggers=on")
 	if err != nil {
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+
+	// ensureSchema creates the tables on a new database
+	store := &MessageStore{db: db, isPostgres: false}
+	if err := store.ensureSchema(); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to create tables: %v", err)
+		return nil, fmt.Errorf("failed to update message schema: %v", err)
 	}
 
-	return &MessageStore{db: db, isPostgres: false}, nil
+	return store, nil
 }
 
 // Close the database connection
@@ -118,7 +133,14 @@ func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time
 		query = "INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)"
 	}
 	
-	_, err := store.db.Exec(query, jid, name, lastMessageTime)
+	// Always store UTC so SQLite's text timestamps sort correctly
+	err := store.captureChat(jid, func() error {
+		_, err := store.db.Exec(query, jid, name, lastMessageTime.UTC())
+		return err
+	})
+	if err == nil && store.account == "" {
+		chatStored(jid, name)
+	}
 	return err
 }
 
@@ -130,25 +152,47 @@ func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, tim
 		return nil
 	}
 
+	// A message stored again keeps the status receipts have moved it to
+	status := MessageStatusReceived
+	if isFromMe {
+		status = MessageStatusSent
+	}
+
 	var query string
 	if store.isPostgres {
 		query = `INSERT INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length, status, status_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $5)
 		ON CONFLICT (id, chat_jid) DO UPDATE SET 
 		sender = $3, content = $4, timestamp = $5, is_from_me = $6, 
 		media_type = $7, filename = $8, url = $9, media_key = $10, 
 		file_sha256 = $11, file_enc_sha256 = $12, file_length = $13`
 	} else {
 		query = `INSERT OR REPLACE INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length, status, status_at) 
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13,
+		COALESCE((SELECT status FROM messages WHERE id = ?1 AND chat_jid = ?2), ?14),
+		COALESCE((SELECT status_at FROM messages WHERE id = ?1 AND chat_jid = ?2), ?5))`
+	}
+
+	// INSERT OR REPLACE drops the chain link of a message stored again, so keep it to restore
+	var previous *ChainLink
+	if hashChainEnabled && !store.isPostgres {
+		previous, _ = store.GetChainLink(id, chatJID)
 	}
 	
-	_, err := store.db.Exec(
-		query,
-		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
-	)
+	err := store.captureMessage(id, chatJID, func() error {
+		_, err := store.db.Exec(
+			query,
+			id, chatJID, sender, content, timestamp.UTC(), isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, status,
+		)
+		return err
+	})
+	if err == nil && hashChainEnabled {
+		if err := store.chainMessage(id, chatJID, previous); err != nil {
+			return fmt.Errorf("failed to add message to hash chain: %v", err)
+		}
+	}
 	return err
 }
 
@@ -156,9 +200,9 @@ func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, tim
 func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
 	var query string
 	if store.isPostgres {
-		query = "SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = $1 ORDER BY timestamp DESC LIMIT $2"
+		query = "SELECT id, sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = $1 ORDER BY timestamp DESC LIMIT $2"
 	} else {
-		query = "SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?"
+		query = "SELECT id, sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?"
 	}
 	
 	rows, err := store.db.Query(query, chatJID, limit)
@@ -171,7 +215,7 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	for rows.Next() {
 		var msg Message
 		var timestamp time.Time
-		err := rows.Scan(&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
+		err := rows.Scan(&msg.id, &msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
 		if err != nil {
 			return nil, err
 		}
@@ -184,6 +228,11 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 
 // Get all chats
 func (store *MessageStore) GetChats() (map[string]time.Time, error) {
+	return cached(cacheKeyChatMap, cacheTTL, store.queryChatTimes)
+}
+
+// Read chat activity times from the database
+func (store *MessageStore) queryChatTimes() (map[string]time.Time, error) {
 	var query string
 	if store.isPostgres {
 		query = "SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC"
@@ -224,8 +273,8 @@ func extractTextContent(msg *waProto.Message) string {
 		return extendedText.GetText()
 	}
 
-	// For now, we're ignoring non-text messages
-	return ""
+	// Payment and order messages are stored as a readable summary
+	return paymentSummary(msg)
 }
 
 // CORS middleware to allow cross-origin requests
@@ -233,8 +282,9 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Allow requests from any origin when running in Cloud Run
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Upload-Offset, X-API-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Deprecation, Link, X-Bridge-Role, X-Bridge-Mode, Retry-After")
 
 		// Handle pre-flight requests
 		if r.Method == "OPTIONS" {
@@ -249,8 +299,19 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 // SendMessageResponse represents the response for the send message API
 type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	// ErrorCode and Retryable classify a failed send, see send_errors.go
+	ErrorCode string `json:"error_code,omitempty"`
+	Retryable *bool  `json:"retryable,omitempty"`
+	// State is the state the message reached with ?wait_for=, or queued for the outbox
+	State    string `json:"state,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	// OutboxID identifies a queued message at /messages/{id}/status
+	OutboxID string `json:"outbox_id,omitempty"`
 }
 
 // SendMessageRequest represents the request body for the send message API
@@ -258,97 +319,192 @@ type SendMessageRequest struct {
 	Recipient string `json:"recipient"`
 	Message   string `json:"message"`
 	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	// Agent is the dashboard user replying, recorded with the message
+	Agent string `json:"agent,omitempty"`
+	// Signature overrides AGENT_SIGNATURE for this message
+	Signature *bool `json:"signature,omitempty"`
+	// TemplateID sends a stored template, filled in with Variables, instead of Message
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	// Queue hands the message to the outbox instead of sending it now; ?queue= and OUTBOX_ENABLED set the default
+	Queue *bool `json:"queue,omitempty"`
+	// QuotedMessageID sends the message as a reply to a stored message of the chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// SendOptions carries optional settings of an outgoing message
+type SendOptions struct {
+	// ClientRef is the caller's own ID for the message, stored and echoed in status events
+	ClientRef string
+	// Agent is the dashboard user who wrote the message
+	Agent string
+	// Signature overrides AGENT_SIGNATURE when set
+	Signature *bool
+	// Payment turns a text message into a payment request with the text as its note
+	Payment *PaymentDetails
+	// Account is the ID of the additional linked account sending the message, empty for the default one
+	Account string
+	// APIKey is the usage subject of the API caller that sent the message, for the audit export
+	APIKey string
+	// QuotedMessageID is the stored message of the chat this one replies to
+	QuotedMessageID string
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string, messageStore *MessageStore) (bool, string) {
+// Function to send a WhatsApp message; returns the WhatsApp message ID on success
+func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string, opts SendOptions, messageStore *MessageStore) (success bool, result string, messageID string, code string) {
+	// Tell status subscribers about failures, with the caller's reference
+	var recipientJID types.JID
+	defer func() {
+		if !success {
+			chatJID := ""
+			if !recipientJID.IsEmpty() {
+				chatJID = recipientJID.String()
+			}
+			publishEvent(EventMessageFailed, chatJID, time.Time{}, withAccount(opts.Account, map[string]interface{}{
+				"recipient":  recipient,
+				"client_ref": opts.ClientRef,
+				"agent":      opts.Agent,
+				"error":      result,
+				"error_code": code,
+				"retryable":  sendErrorRetryable[code],
+			}))
+		}
+	}()
+
 	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
+		return false, "Not connected to WhatsApp", "", SendErrNotConnected
 	}
 
-	// Create JID for recipient
-	var recipientJID types.JID
+	// Read-only and receive-only deployments never send, including auto-replies and flows
+	if readOnlyMode {
+		return false, "The bridge is in read-only mode", "", SendErrSendingDisabled
+	}
+	if receiveOnlyMode {
+		return false, "The bridge is in receive-only mode", "", SendErrSendingDisabled
+	}
+
+	// Nothing goes out while the session may be in someone else's hands
+	if lock := sessionGuard.Current(); lock != nil {
+		return false, fmt.Sprintf("Sending is locked (%s); an admin must acknowledge the lock", lock.Reason), "", SendErrSendingDisabled
+	}
+
+	// New numbers ramp their daily volume up slowly to avoid bans; the warm-up follows the default account
+	if opts.Account == "" {
+		if ok, reason := warmUp.Reserve(); !ok {
+			return false, reason, "", SendErrRateLimited
+		}
+		defer func() {
+			if !success {
+				warmUp.Release()
+			}
+		}()
+	}
+
+	// Create JID for recipient: a JID, a group ID or a phone number
 	var err error
+	recipientJID, err = parseRecipient(recipient)
+	if err != nil {
+		return false, fmt.Sprintf("Error parsing JID: %v", err), "", SendErrInvalidRequest
+	}
+
+	// WhatsApp accepts messages to numbers without an account and never delivers them
+	if !checkRecipientOnWhatsApp(client, messageStore, recipientJID) {
+		return false, fmt.Sprintf("%s is not on WhatsApp", recipientJID.User), "", SendErrRecipientNotOnWhatsApp
+	}
 
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
+	// Groups the account left, or where only admins may write, would reject the message
+	if reason, code := checkGroupRecipient(client, recipientJID, opts.Account); code != "" {
+		return false, reason, "", code
+	}
 
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
+	// A reply carries the quoted message, so it has to be one the bridge stored
+	var quote *waProto.ContextInfo
+	if opts.QuotedMessageID != "" {
+		quote, err = quoteContextInfo(client, messageStore, recipientJID, opts.QuotedMessageID)
 		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
+			return false, fmt.Sprintf("Error getting quoted message: %v", err), "", SendErrServerError
 		}
-	} else {
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: "s.whatsapp.net", // For personal chats
+		if quote == nil {
+			return false, fmt.Sprintf("Quoted message %s not found in %s", opts.QuotedMessageID, recipientJID), "", SendErrInvalidRequest
 		}
 	}
 
+	// Sign the text or caption with the agent's name if configured
+	message = withAgentSignature(message, opts)
+
 	msg := &waProto.Message{}
 	
 	// Variables to track media info for database storage
 	var mediaType, filename, url string
 	var mediaKey, fileSHA256, fileEncSHA256 []byte
 	var fileLength uint64
+	var scanStatus, scanDetail string
 
 	// Check if we have media to send
 	if mediaPath != "" {
-		// Read media file
-		mediaData, err := os.ReadFile(mediaPath)
+		// Open media file
+		mediaFile, err := os.Open(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
+			return false, fmt.Sprintf("Error reading media file: %v", err), "", SendErrInvalidRequest
+		}
+		defer mediaFile.Close()
+
+		if info, err := mediaFile.Stat(); err == nil && info.Size() > maxSendMediaBytes {
+			return false, mediaTooLargeMessage(info.Size(), maxSendMediaBytes), "", SendErrMediaTooLarge
 		}
 
 		// Determine media type and mime type based on file extension
 		fileExt := strings.ToLower(mediaPath[strings.LastIndex(mediaPath, ".")+1:])
-		var mediaType whatsmeow.MediaType
-		var mimeType string
-
-		// Handle different media types
-		switch fileExt {
-		// Image types
-		case "jpg", "jpeg":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/jpeg"
-		case "png":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/png"
-		case "gif":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/gif"
-		case "webp":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/webp"
-
-		// Audio types
-		case "ogg":
-			mediaType = whatsmeow.MediaAudio
-			mimeType = "audio/ogg; codecs=opus"
-
-		// Video types
-		case "mp4":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/mp4"
-		case "avi":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/avi"
-		case "mov":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/quicktime"
-
-		// Document types (for any other file type)
-		default:
-			mediaType = whatsmeow.MediaDocument
-			mimeType = "application/octet-stream"
-		}
-
-		// Upload media to WhatsApp servers
-		resp, err := client.Upload(context.Background(), mediaData, mediaType)
+		mediaType, mimeType := mediaTypeForExtension(fileExt)
+
+		// Images and voice notes are small and need in-memory processing (metadata stripping,
+		// Ogg analysis); videos and documents are streamed so large files never sit in memory
+		var mediaData []byte
+		var resp whatsmeow.UploadResponse
+		if mediaType == whatsmeow.MediaImage || mediaType == whatsmeow.MediaAudio {
+			mediaData, err = io.ReadAll(mediaFile)
+			if err != nil {
+				return false, fmt.Sprintf("Error reading media file: %v", err), "", SendErrInvalidRequest
+			}
+
+			// Strip EXIF/GPS metadata from images unless explicitly disabled
+			if mediaType == whatsmeow.MediaImage && getEnvBool("STRIP_IMAGE_METADATA", true) {
+				mediaData, err = stripImageMetadata(mediaData, mimeType)
+				if err != nil {
+					return false, fmt.Sprintf("Error stripping image metadata: %v", err), "", SendErrInvalidRequest
+				}
+			}
+
+			// Scan outgoing media if a scanner is configured
+			if mediaScanPolicy != nil {
+				var allowed bool
+				scanStatus, scanDetail, allowed = mediaScanPolicy.Check(bytes.NewReader(mediaData), filepath.Base(mediaPath))
+				if !allowed {
+					return false, fmt.Sprintf("Media blocked by scanner (%s): %s", scanStatus, scanDetail), "", SendErrMediaBlocked
+				}
+			}
+
+			// Upload media to WhatsApp servers
+			resp, err = client.Upload(context.Background(), mediaData, mediaType)
+		} else {
+			// Scan outgoing media if a scanner is configured
+			if mediaScanPolicy != nil {
+				var allowed bool
+				scanStatus, scanDetail, allowed = mediaScanPolicy.Check(mediaFile, filepath.Base(mediaPath))
+				if !allowed {
+					return false, fmt.Sprintf("Media blocked by scanner (%s): %s", scanStatus, scanDetail), "", SendErrMediaBlocked
+				}
+				if _, err := mediaFile.Seek(0, io.SeekStart); err != nil {
+					return false, fmt.Sprintf("Error rewinding media file: %v", err), "", SendErrServerError
+				}
+			}
+
+			// Stream media to WhatsApp servers
+			resp, err = uploadMediaStream(client, mediaFile, mediaType)
+		}
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			return false, fmt.Sprintf("Error uploading media: %v", err), "", classifyUploadError(err)
 		}
 
 		fmt.Println("Media uploaded", resp)
@@ -387,23 +543,23 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileEncSHA256: resp.FileEncSHA256,
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
+				ContextInfo:   quote,
 			}
 		case "audio":
-			// Handle ogg audio files
-			var seconds uint32 = 30 // Default fallback
+			// Ogg Opus files are sent as voice notes, other audio as audio files
+			var seconds *uint32
 			var waveform []byte = nil
+			voiceNote := strings.Contains(mimeType, "ogg")
 
 			// Try to analyze the ogg file
-			if strings.Contains(mimeType, "ogg") {
+			if voiceNote {
 				analyzedSeconds, analyzedWaveform, err := analyzeOggOpus(mediaData)
 				if err == nil {
-					seconds = analyzedSeconds
+					seconds = proto.Uint32(analyzedSeconds)
 					waveform = analyzedWaveform
 				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
+					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err), "", SendErrInvalidRequest
 				}
-			} else {
-				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
 			}
 
 			msg.AudioMessage = &waProto.AudioMessage{
@@ -414,9 +570,10 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileEncSHA256: resp.FileEncSHA256,
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
-				Seconds:       proto.Uint32(seconds),
-				PTT:           proto.Bool(true),
+				Seconds:       seconds,
+				PTT:           proto.Bool(voiceNote),
 				Waveform:      waveform,
+				ContextInfo:   quote,
 			}
 		case "video":
 			msg.VideoMessage = &waProto.VideoMessage{
@@ -428,6 +585,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileEncSHA256: resp.FileEncSHA256,
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
+				ContextInfo:   quote,
 			}
 		case "document":
 			msg.DocumentMessage = &waProto.DocumentMessage{
@@ -440,8 +598,16 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileEncSHA256: resp.FileEncSHA256,
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
+				ContextInfo:   quote,
 			}
 		}
+	} else if opts.Payment != nil {
+		msg.RequestPaymentMessage = opts.Payment.message(message, recipientJID)
+		// Store a readable summary, as for incoming payment requests
+		message = paymentSummary(msg)
+	} else if quote != nil {
+		// Plain conversation messages have no context, so replies are extended text
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: proto.String(message), ContextInfo: quote}
 	} else {
 		msg.Conversation = proto.String(message)
 	}
@@ -471,7 +637,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 	}
 
 	if err != nil {
-		return false, fmt.Sprintf("Error sending message after %d retries: %v", maxRetries, err)
+		return false, fmt.Sprintf("Error sending message after %d retries: %v", maxRetries, err), "", classifySendError(err)
 	}
 	
 	// Store the sent message in our database if we have a message store
@@ -479,7 +645,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Get the chat name
 		chatJID := recipientJID.String()
 		// Create a simple logger for this operation
-		logger := waLog.Stdout("SendMessage", "INFO", true)
+		logger := newLogger("SendMessage")
 		name := GetChatName(client, messageStore, recipientJID, chatJID, nil, "", logger)
 		
 		// Store the chat
@@ -507,11 +673,41 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		); err != nil {
 			fmt.Printf("Failed to store sent message: %v\n", err)
 		} else {
-			fmt.Printf("Stored outbound message in database: %s\n", message)
+			fmt.Printf("Stored outbound message in database: %s\n", logRedactor.Body(message))
+
+			// Record the scan verdict alongside the message
+			if scanStatus != "" {
+				if err := messageStore.UpdateScanResult(resp.ID, chatJID, scanStatus, scanDetail); err != nil {
+					fmt.Printf("Failed to store scan result for sent message: %v\n", err)
+				}
+			}
+
+			// Remember the caller's reference, the authoring agent and the API key
+			if opts.ClientRef != "" || opts.Agent != "" || opts.APIKey != "" {
+				if err := messageStore.SetSendDetails(resp.ID, chatJID, opts); err != nil {
+					fmt.Printf("Failed to store client_ref, agent and API key for sent message: %v\n", err)
+				}
+			}
+
+			if opts.QuotedMessageID != "" {
+				if err := messageStore.SetReplyTo(resp.ID, chatJID, opts.QuotedMessageID); err != nil {
+					fmt.Printf("Failed to store quoted message reference for sent message: %v\n", err)
+				}
+			}
 		}
 	}
 
-	return true, fmt.Sprintf("Message sent to %s", recipient)
+	publishEvent(EventMessageSent, recipientJID.String(), resp.Timestamp, withAccount(opts.Account, map[string]interface{}{
+		"id":         resp.ID,
+		"chat_jid":   recipientJID.String(),
+		"client_ref": opts.ClientRef,
+		"agent":      opts.Agent,
+		"media_type": mediaType,
+		"reply_to":   opts.QuotedMessageID,
+	}))
+	publishMessageStatus(opts.Account, recipientJID.String(), resp.ID, MessageStatusSent, "", opts.ClientRef, resp.Timestamp)
+
+	return true, fmt.Sprintf("Message sent to %s", recipient), resp.ID, ""
 }
 
 // Extract media info from a message
@@ -558,6 +754,18 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	sender := msg.Info.Sender.User
 
 	// Get appropriate chat name (pass nil for conversation since we don't have one for regular messages)
+	// A message deleted for everyone is removed with its media
+	if protocol := msg.Message.GetProtocolMessage(); protocol != nil && protocol.GetType() == waProto.ProtocolMessage_REVOKE {
+		handleRevoke(messageStore, chatJID, protocol.GetKey().GetID(), msg.Info.Timestamp, logger)
+		return
+	}
+
+	// Reactions are kept apart from messages and don't count as chat activity
+	if reaction := msg.Message.GetReactionMessage(); reaction != nil {
+		handleReaction(messageStore, chatJID, sender, msg.Info.IsFromMe, reaction, msg.Info.Timestamp, logger)
+		return
+	}
+
 	name := GetChatName(client, messageStore, msg.Info.Chat, chatJID, nil, sender, logger)
 
 	// Update chat in database with the message timestamp (keeps last message time updated)
@@ -597,6 +805,51 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
+		replyTo := quotedMessageID(msg.Message)
+		if replyTo != "" {
+			if err := messageStore.SetReplyTo(msg.Info.ID, chatJID, replyTo); err != nil {
+				logger.Warnf("Failed to store quoted message reference: %v", err)
+			}
+		}
+		publishEvent(EventMessageReceived, chatJID, msg.Info.Timestamp, map[string]interface{}{
+			"id":         msg.Info.ID,
+			"chat_jid":   chatJID,
+			"sender":     sender,
+			"content":    content,
+			"is_from_me": msg.Info.IsFromMe,
+			"media_type": mediaType,
+			"filename":   filename,
+			"reply_to":   replyTo,
+		})
+		publishPaymentEvent(msg, chatJID, sender)
+
+		// Fetch incoming media now if the auto-download policy covers it; the rest is fetched on first access
+		if mediaType != "" && !msg.Info.IsFromMe && !readOnlyMode && autoDownloadPolicy.Allows(mediaType, fileLength, chatJID, sender) {
+			autoDownloadPolicy.Enqueue(msg.Info.ID, chatJID)
+		}
+
+		// Hand messages from contacts to conversation flows and routing rules, unless read-only
+		incoming := IncomingMessage{
+			ID:          msg.Info.ID,
+			ChatJID:     chatJID,
+			Sender:      sender,
+			SenderJID:   msg.Info.Sender.ToNonAD(),
+			SenderPhone: sender,
+			Content:     content,
+			MediaType:   mediaType,
+			Timestamp:   msg.Info.Timestamp,
+			IsGroup:     msg.Info.IsGroup,
+		}
+		if msg.Info.Sender.Server == types.HiddenUserServer && msg.Info.SenderAlt.User != "" {
+			incoming.SenderPhone = msg.Info.SenderAlt.User
+		}
+		if !msg.Info.IsFromMe && !readOnlyMode {
+			go dispatchIncoming(incoming)
+		} else if msg.Info.IsGroup && groupModerator != nil {
+			// Moderation commands typed on the owner's phone
+			go groupModerator.HandleOwnMessage(incoming)
+		}
+
 		// Log message reception
 		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
 		direction := "←"
@@ -606,9 +859,9 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 
 		// Log based on message type
 		if mediaType != "" {
-			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
+			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, logRedactor.Phone(sender), mediaType, filename, logRedactor.Body(content))
 		} else if content != "" {
-			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, logRedactor.Phone(sender), logRedactor.Body(content))
 		}
 	}
 }
@@ -716,7 +969,7 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	var err error
 
 	// First, check if we already have this file
-	chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(chatJID, ":", "_"))
+	chatDir := dataPath(strings.ReplaceAll(chatJID, ":", "_"))
 	localPath := ""
 
 	// Get media info from the database
@@ -768,7 +1021,7 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
-	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
+	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, logRedactor.Phone(chatJID))
 
 	// Extract direct path from URL
 	directPath := extractDirectPathFromURL(url)
@@ -798,18 +1051,51 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		MediaType:     waMediaType,
 	}
 
-	// Download the media using whatsmeow client
-	mediaData, err := client.Download(context.Background(), downloader)
+	// Download the media straight to a temporary file in the chat directory, so large
+	// videos are never held in memory and a partial download never appears under the final name
+	tempFile, err := os.CreateTemp(chatDir, ".download-*")
 	if err != nil {
+		return false, "", "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+	defer tempFile.Close()
+
+	if err := client.DownloadToFile(context.Background(), downloader, tempFile); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
 	}
 
-	// Save the downloaded media to file
-	if err := os.WriteFile(localPath, mediaData, 0644); err != nil {
+	// Scan received media before it is moved into the chat directory
+	if mediaScanPolicy != nil {
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			return false, "", "", "", fmt.Errorf("failed to rewind downloaded media: %v", err)
+		}
+
+		scanStatus, scanDetail, allowed := mediaScanPolicy.Check(tempFile, filename)
+		if err := messageStore.UpdateScanResult(messageID, chatJID, scanStatus, scanDetail); err != nil {
+			fmt.Printf("Failed to store scan result for message %s: %v\n", messageID, err)
+		}
+
+		if !allowed {
+			quarantinePath, err := mediaScanPolicy.Quarantine(tempPath, chatJID, filename)
+			if err != nil {
+				fmt.Printf("Failed to quarantine media for message %s: %v\n", messageID, err)
+			} else if quarantinePath != "" {
+				fmt.Printf("Quarantined media for message %s at %s\n", messageID, quarantinePath)
+			}
+			return false, "", "", "", fmt.Errorf("media blocked by scanner (%s): %s", scanStatus, scanDetail)
+		}
+	}
+
+	// Move the completed download into place
+	if err := tempFile.Close(); err != nil {
+		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
+	}
+	if err := os.Rename(tempPath, localPath); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
 	}
 
-	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
+	fmt.Printf("Successfully downloaded %s media for message %s (%d bytes)\n", mediaType, messageID, fileLength)
 	return true, mediaType, filename, absPath, nil
 }
 
@@ -834,9 +1120,9 @@ func extractDirectPathFromURL(url string) string {
 }
 
 // Start a REST API server to expose the WhatsApp client functionality
-func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAdapter *DatabaseAdapter, port int) {
+func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAdapter *DatabaseAdapter, listen ListenConfig) {
 	// Handler for sending messages
-	http.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/send", leaderOnly(sendUnlocked(meteredSend(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -850,39 +1136,124 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 			return
 		}
 
+		// Optionally block until the message is acknowledged or delivered
+		waitFor, waitTimeout, err := parseDeliveryWait(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Validate request
 		if req.Recipient == "" {
 			http.Error(w, "Recipient is required", http.StatusBadRequest)
 			return
 		}
 
-		if req.Message == "" && req.MediaPath == "" {
+		if req.Message == "" && req.MediaPath == "" && req.TemplateID == "" {
 			http.Error(w, "Message or media path is required", http.StatusBadRequest)
 			return
 		}
 
-		fmt.Println("Received request to send message", req.Message, req.MediaPath)
+		if len(req.ClientRef) > maxClientRefLen {
+			http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Agent) > maxAgentLen {
+			http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+			return
+		}
+
+		opts := SendOptions{ClientRef: req.ClientRef, Agent: req.Agent, Signature: req.Signature, APIKey: usageSubject(r), QuotedMessageID: req.QuotedMessageID}
+
+		// A template replaces the message, filled in from the recipient's attributes, and isn't sent
+		// while its preview has errors
+		if req.TemplateID != "" {
+			if req.Message, err = messageStore.FillTemplate(req.TemplateID, req.Recipient, req.Variables, opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// A queued message is stored and sent by the outbox worker, which retries it until it goes out
+		queue, err := messageOutbox.queueRequested(r, req.Queue)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if queue && waitFor != "" {
+			if req.Queue != nil || r.URL.Query().Get("queue") != "" {
+				http.Error(w, "wait_for doesn't apply to queued messages", http.StatusBadRequest)
+				return
+			}
+			// Waiting for delivery asks for a synchronous send even when the outbox is the default
+			queue = false
+		}
+		// Sends made during maintenance wait in the outbox, which is drained when it ends
+		if maintenance.Status().State != MaintenanceOff {
+			if waitFor != "" {
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "The bridge is in maintenance and can't wait for delivery; try again later, or leave out wait_for to queue the message", http.StatusServiceUnavailable)
+				return
+			}
+			queue = true
+		}
+		if queue {
+			queued, err := messageOutbox.Enqueue(req.Recipient, req.Message, req.MediaPath, opts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			usageMeter.RecordSend(r, req.Recipient, req.MediaPath)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success:   true,
+				Message:   "Message queued",
+				ClientRef: req.ClientRef,
+				Agent:     req.Agent,
+				State:     OutboxQueued,
+				OutboxID:  queued.ID,
+			})
+			return
+		}
+
+		fmt.Printf("Received request to send message to %s: %s %s\n", logRedactor.Phone(req.Recipient), logRedactor.Body(req.Message), req.MediaPath)
 
 		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath, messageStore)
-		fmt.Println("Message sent", success, message)
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
+		success, message, messageID, code := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath, opts, messageStore)
+		// The result message names the recipient, so log the redacted recipient and the error code instead
+		if success {
+			fmt.Printf("Message sent to %s: %s\n", logRedactor.Phone(req.Recipient), messageID)
+			usageMeter.RecordSend(r, req.Recipient, req.MediaPath)
+		} else {
+			fmt.Printf("Failed to send message to %s: %s\n", logRedactor.Phone(req.Recipient), code)
+		}
+		response := SendMessageResponse{
+			Success:   success,
+			Message:   message,
+			MessageID: messageID,
+			ClientRef: req.ClientRef,
+			Agent:     req.Agent,
+		}.withError(code)
 
 		// Set appropriate status code
+		status := http.StatusOK
 		if !success {
-			w.WriteHeader(http.StatusInternalServerError)
+			status = http.StatusInternalServerError
+		} else if waitFor != "" {
+			status = awaitSendState(&response, waitFor, waitTimeout)
 		}
 
 		// Send response
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
-		})
-	})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}))))
 
 	// Handler for downloading media
-	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/download", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -902,6 +1273,12 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 			return
 		}
 
+		if ok, message := usageMeter.Allow(r, UsageMediaDownloadBytes); !ok {
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		legalHolds.RecordRequest(r, req.ChatJID)
+
 		// Download the media
 		success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
 
@@ -923,6 +1300,10 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 			return
 		}
 
+		if info, err := os.Stat(path); err == nil {
+			usageMeter.Record(r, UsageMediaDownloadBytes, info.Size())
+		}
+
 		// Send successful response
 		json.NewEncoder(w).Encode(DownloadMediaResponse{
 			Success:  true,
@@ -930,10 +1311,62 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 			Filename: filename,
 			Path:     path,
 		})
-	})
+	}))
+
+	// Handler for streaming media content directly to the client
+	handleAPI("/media/", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		// Only allow GET requests
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		messageID := strings.TrimPrefix(apiRoute(r), "/media/")
+		chatJID := r.URL.Query().Get("chat_jid")
+		if messageID == "" || chatJID == "" {
+			http.Error(w, "Message ID and chat_jid are required", http.StatusBadRequest)
+			return
+		}
+
+		if ok, message := usageMeter.Allow(r, UsageMediaDownloadBytes); !ok {
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+
+		// Media already in the Supabase Storage bucket is served from there without downloading it
+		if mediaStorage != nil {
+			if object, filename, _ := messageStore.GetStoragePath(messageID, chatJID); object != "" && serveSignedMedia(w, r, chatJID, object, filename) {
+				return
+			}
+		}
+
+		// Download the media to the local store if we don't have it yet
+		success, _, filename, path, err := downloadMedia(client, messageStore, messageID, chatJID)
+		if !success || err != nil {
+			errMsg := "Unknown error"
+			if err != nil {
+				errMsg = err.Error()
+			}
+			http.Error(w, fmt.Sprintf("Failed to download media: %s", errMsg), http.StatusInternalServerError)
+			return
+		}
+
+		if mediaStorage != nil {
+			if object, err := mediaStorage.Store(messageStore, messageID, chatJID, path, filename); err != nil {
+				fmt.Printf("Serving media of message %s from local disk: %v\n", messageID, err)
+			} else if serveSignedMedia(w, r, chatJID, object, filename) {
+				return
+			}
+		}
+
+		// Only the bytes actually streamed count, so range requests aren't charged for the whole file
+		counter := &countingResponseWriter{ResponseWriter: w}
+		serveMediaFile(counter, r, path, filename)
+		usageMeter.Record(r, UsageMediaDownloadBytes, counter.written)
+	}))
 
 	// Handler for database status
-	http.HandleFunc("/api/db/status", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/db/status", func(w http.ResponseWriter, r *http.Request) {
 		// Only allow GET requests
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -980,30 +1413,162 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 		json.NewEncoder(w).Encode(response)
 	})
 
-	// Handler for getting all chats
-	http.HandleFunc("/api/chats", func(w http.ResponseWriter, r *http.Request) {
+	// Handler for getting all chats (legacy map format, replaced by /api/v1/chats)
+	handleLegacyAPI("/api/chats", func(r *http.Request) string {
+		return apiV1Prefix + "/chats"
+	}, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		chats, err := messageStore.GetChats()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to get chats: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		// Render timestamps in the requested timezone
+		for jid, lastMessageTime := range chats {
+			chats[jid] = lastMessageTime.In(loc)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(chats)
 	})
 
-	// Handler for getting messages from a chat
-	http.HandleFunc("/api/messages/", func(w http.ResponseWriter, r *http.Request) {
+	// Handler for per-chat resources (/api/chats/{jid}/...)
+	handleAPI("/chats/", serveChatRoute)
+	registerDraftRoutes(messageStore)
+	registerExportRoutes(client, messageStore)
+	registerAvatarRoutes(client)
+
+	// Handler for per-contact resources (/api/contacts/{jid}/...)
+	handleAPI("/contacts/", serveContactRoute)
+	registerPresenceRoutes(client, messageStore)
+	registerMetadataRoutes(messageStore)
+	registerLabelRoutes(messageStore)
+	registerContactOverviewRoutes(client, messageStore)
+	registerEventLogRoutes(messageStore)
+	registerAnalyticsRoutes(messageStore)
+	registerMessageExportRoutes(messageStore)
+
+	// Handlers for the account's groups (/api/groups) and per-group resources (/api/groups/{jid}/...)
+	registerGroupRoutes(client)
+	handleAPI("/groups/", serveGroupRoute)
+	registerGroupTimelineRoutes(messageStore)
+	registerModerationRoutes()
+
+	// Handlers for chat commands and muted chats
+	registerCommandRoutes(messageStore)
+
+	// Handler for looking up sent messages by the caller's reference
+	registerClientRefRoutes(messageStore)
+
+	// Handler for payment requests
+	registerPaymentRoutes(client, messageStore)
+
+	// Handlers for routing rules and queue assignments
+	registerRoutingRoutes(messageStore)
+	registerClassificationRoutes()
+	registerSLARoutes(messageStore)
+	registerDiagnosticsRoutes(client, messageStore)
+	registerVersionRoutes()
+	registerLogLevelRoutes()
+
+	// Handlers for scheduled messages and campaigns
+	registerSchedulerRoutes(messageStore)
+
+	// Handlers for recurring series of scheduled messages
+	registerRecurringRoutes(messageStore)
+
+	// Handlers for reviewing campaigns that need approval
+	registerCampaignApprovalRoutes(messageStore)
+
+	// Handlers for message templates and their previews
+	registerMessageTemplateRoutes(messageStore)
+
+	// Handlers for contact attributes, which fill template placeholders
+	registerContactAttributeRoutes(messageStore)
+
+	// Handlers for additional linked accounts
+	registerAccountRoutes()
+
+	// Handlers for conversation flows
+	registerFlowRoutes(messageStore)
+
+	// Handlers for chats handed over to agents
+	registerHandoffRoutes(messageStore)
+
+	// Handlers for issuing and revoking API keys
+	registerAPIKeyRoutes(messageStore)
+
+	// Handlers for the live event streams
+	registerEventStreamRoutes()
+	registerWebSocketRoutes(client)
+
+	// Handler for searching stored messages
+	registerSearchRoutes(messageStore)
+	registerSemanticSearchRoutes()
+
+	// Handler for reacting to messages
+	registerReactionRoutes(client, messageStore)
+
+	// Handler for voice notes recorded in the dashboard
+	registerVoiceNoteRoutes(client, messageStore)
+	registerMediaSendRoutes(client, messageStore)
+
+	// Handler for right-to-erasure requests
+	registerGDPRRoutes(messageStore)
+
+	// Handlers for legal holds and their audit log
+	registerLegalHoldRoutes(messageStore)
+
+	// Handler for verifying message hash chains
+	registerHashChainRoutes(messageStore)
+
+	// Handler for the pairing audit log
+	registerPairingRoutes(messageStore)
+	registerLoginHistoryRoutes(messageStore)
+	registerLocalUserRoutes(messageStore)
+
+	// Handlers for session takeover locks
+	registerSessionGuardRoutes()
+	registerMaintenanceRoutes()
+	registerUsageRoutes()
+	registerTenantRoutes(client, messageStore)
+	registerAuditExportRoutes(messageStore)
+	registerMediaGCRoutes(messageStore)
+	registerMetricsRoute(client)
+
+	// Handler for v1 routes with normalized JSON
+	registerV1Routes(messageStore)
+	registerThreadRoutes(messageStore)
+
+	// Handler for getting messages from a chat (legacy format, replaced by /api/v1/chats/{jid}/messages)
+	handleLegacyAPI("/api/messages/", func(r *http.Request) string {
+		if strings.HasSuffix(r.URL.Path, "/thread") || strings.HasSuffix(r.URL.Path, "/status") || strings.HasSuffix(r.URL.Path, "/receipts") {
+			return apiV1Prefix + strings.TrimPrefix(r.URL.Path, "/api")
+		}
+		return apiV1Prefix + "/chats/" + strings.TrimPrefix(r.URL.Path, "/api/messages/") + "/messages"
+	}, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		// /api/messages/{id}/thread, /status and /receipts share this prefix
+		if strings.HasSuffix(r.URL.Path, "/thread") || strings.HasSuffix(r.URL.Path, "/status") || strings.HasSuffix(r.URL.Path, "/receipts") {
+			handleMessageRoute(messageStore)(w, r)
+			return
+		}
+
 		jid := strings.TrimPrefix(r.URL.Path, "/api/messages/")
 		if jid == "" {
 			http.Error(w, "Chat JID is required", http.StatusBadRequest)
@@ -1018,18 +1583,33 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 			}
 		}
 
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		messages, err := messageStore.GetMessages(jid, limit)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to get messages: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if err := messageStore.attachLegacyReactions(jid, messages); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get reactions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Render timestamps in the requested timezone
+		for i := range messages {
+			messages[i].Time = messages[i].Time.In(loc)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(messages)
 	})
 
 	// Handler for health check
-	http.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+	handleAPI("/health", func(w http.ResponseWriter, r *http.Request) {
 		// Only allow GET requests
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1039,11 +1619,22 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 		// Check WhatsApp client connection status
 		isConnected := client.IsConnected()
 		response := map[string]interface{}{
-			"connected": isConnected,
-			"message":   "WhatsApp client is connected.",
+			"connected":    isConnected,
+			"message":      "WhatsApp client is connected.",
+			"role":         replicaRole(),
+			"read_only":    readOnlyMode,
+			"receive_only": receiveOnlyMode,
+			"maintenance":  maintenance.Status(),
+			"storage":      storageMonitor.Status(),
 		}
 
-		if !isConnected {
+		if maintenance.Status().State != MaintenanceOff {
+			response["message"] = "Maintenance; sends are refused and the WhatsApp connection is closed."
+		} else if readOnlyMode && !isConnected {
+			response["message"] = "Read-only mode; serving stored data without a WhatsApp connection."
+		} else if replicaRole() == "follower" {
+			response["message"] = "Standby replica serving read traffic; the leader holds the WhatsApp connection."
+		} else if !isConnected {
 			response["message"] = "WhatsApp client is not connected. Please refresh credentials."
 		}
 
@@ -1056,9 +1647,19 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 
 	// Add wrapper health endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		if client.IsConnected() {
+		if maintenance.Status().State != MaintenanceOff {
+			// Stay in rotation so the container isn't restarted mid-migration, but say why nothing is processed
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Main application is in maintenance."))
+		} else if client.IsConnected() {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("Main application is live."))
+		} else if replicaRole() == "follower" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Standby replica is live."))
+		} else if readOnlyMode {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Read-only application is live."))
 		} else {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			w.Write([]byte("Main application is not live."))
@@ -1066,24 +1667,97 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, dbAda
 	})
 
 	// Start the server
-	serverPort := os.Getenv("PORT")
-	if serverPort == "" {
-		serverPort = strconv.Itoa(port)
-	}
-	
-	serverAddr := fmt.Sprintf(":%s", serverPort)
+	serverAddr := listen.Addr()
 	fmt.Printf("Starting REST API server on %s...\n", serverAddr)
 
 	// Run server in the main goroutine since we're now consolidating everything
-	if err := http.ListenAndServe(serverAddr, corsMiddleware(http.DefaultServeMux)); err != nil {
+	if err := listen.ListenAndServe(forwardedMiddleware(basePathHandler(compressionMiddleware(etagMiddleware(corsMiddleware(apiKeyMiddleware(readOnlyMiddleware(receiveOnlyMiddleware(tenantMiddleware(legalHoldMiddleware(http.DefaultServeMux))))))))))); err != nil {
 		fmt.Printf("REST API server error: %v\n", err)
 	}
 }
 
 func main() {
-	// Set up logger
-	logger := waLog.Stdout("Client", "INFO", true)
+	runSetup := flag.Bool("setup", false, "run the interactive setup wizard, even if a configuration exists")
+	exportSessionPath := flag.String("export-session", "", "write the linked device's credentials, encrypted with SESSION_PASSPHRASE, to a file and exit")
+	importSessionPath := flag.String("import-session", "", "load device credentials written by -export-session and exit")
+	forceImport := flag.Bool("force", false, "let -import-session replace an existing linked device")
+	listenPort := flag.Int("port", 0, "port of the web server and API (default: PORT or 8080)")
+	bindAddress := flag.String("bind", "", "interface address to listen on, e.g. 127.0.0.1 (default: BIND_ADDRESS or all interfaces)")
+	pathPrefix := flag.String("base-path", "", "path prefix when a reverse proxy serves the bridge under a sub-path, e.g. /whatsapp (default: BASE_PATH)")
+	printVersion := flag.Bool("version", false, "print the version of the bridge and exit")
+	createAPIKey := flag.String("create-api-key", "", "issue an API key with this name, print it and exit")
+	apiKeyScopes := flag.String("api-key-scopes", ScopeAdmin, "comma-separated scopes of -create-api-key: read, send, write or admin")
+	revokeAPIKey := flag.String("revoke-api-key", "", "revoke the API key with this key_ ID and exit")
+	listAPIKeys := flag.Bool("list-api-keys", false, "list the issued API keys and exit")
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Println(readBuildInfo())
+		return
+	}
+
+	// Set up logger; its level can be changed while the bridge runs
+	logger := newLogger("Client")
+	if err := initLogLevel(); err != nil {
+		logger.Errorf("Invalid log level configuration: %v", err)
+		return
+	}
+
+	// Moving a session between deployments runs instead of the client
+	if *exportSessionPath != "" || *importSessionPath != "" {
+		if *exportSessionPath != "" && *importSessionPath != "" {
+			logger.Errorf("Use either -export-session or -import-session, not both")
+			os.Exit(2)
+		}
+		if err := runSessionTransfer(logger, *exportSessionPath, *importSessionPath, *forceImport); err != nil {
+			logger.Errorf("Session transfer failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Managing API keys runs instead of the client too
+	if *createAPIKey != "" || *revokeAPIKey != "" || *listAPIKeys {
+		if err := runAPIKeyCommand(logger, *createAPIKey, *apiKeyScopes, *revokeAPIKey, *listAPIKeys); err != nil {
+			logger.Errorf("API key command failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger.Infof("Starting WhatsApp client...")
+	logger.Infof("%s", readBuildInfo())
+
+	if err := startFileLogging(); err != nil {
+		logger.Warnf("File logging disabled: %v", err)
+	}
+
+	// Guide first-time users through configuration and pairing
+	setup := &SetupResult{}
+	if (*runSetup && isInteractive()) || shouldRunSetupWizard() {
+		result, err := runSetupWizard(os.Stdin, os.Stdout)
+		if err != nil {
+			logger.Errorf("Setup failed: %v", err)
+			return
+		}
+		setup = result
+	}
+
+	// Where the web server listens, and the sub-path a reverse proxy serves it under
+	listen, err := NewListenConfigFromEnv(*listenPort, *bindAddress, *pathPrefix)
+	if err != nil {
+		logger.Errorf("Invalid listen configuration: %v", err)
+		return
+	}
+	basePath = listen.BasePath
+
+	// Reverse proxies whose X-Forwarded-* headers are believed
+	trustedProxies, err = NewTrustedProxiesFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid trusted proxy configuration: %v", err)
+		return
+	}
+	compressionEnabled = getEnvBool("HTTP_COMPRESSION", true)
 
 	// Initialize QR web server
 	qrWebServer := NewQRWebServer()
@@ -1092,7 +1766,7 @@ func main() {
 	qrWebServer.RegisterRoutes()
 	
 	// Start the wrapper functionality to monitor health
-	StartWrapper()
+	StartWrapper(listen.LocalURL())
 	
 	// Initialize database adapter for Supabase/PostgreSQL with SQLite fallback
 	dbAdapter := NewDatabaseAdapter(logger)
@@ -1102,21 +1776,105 @@ func main() {
 		return
 	}
 
+	// Configure optional media scanning
+	mediaScanPolicy, err = NewMediaScanPolicyFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid media scanning configuration: %v", err)
+		return
+	}
+
+	// Keep media in a Supabase Storage bucket and serve it through signed URLs
+	mediaStorage, err = NewMediaStorageFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid Supabase Storage configuration: %v", err)
+		return
+	}
+
+	// Match the web UI to the portal it's embedded in
+	uiTheme, err = NewUIThemeFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid UI theme configuration: %v", err)
+		return
+	}
+	pageTemplates, err = NewTemplateRegistryFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid UI templates: %v", err)
+		return
+	}
+
+	// Look for newer releases when asked to
+	updateChecker, err = NewUpdateCheckerFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid update check configuration: %v", err)
+		return
+	}
+
+	// Serve stored data only, for demos and audits
+	readOnlyMode = getEnvBool("READ_ONLY", false)
+	if readOnlyMode {
+		logger.Infof("Read-only mode: sending and changes through the API are disabled")
+	}
+
+	// Archive incoming messages without ever sending
+	receiveOnlyMode = getEnvBool("RECEIVE_ONLY", false)
+	if receiveOnlyMode {
+		logger.Infof("Receive-only mode: all outbound sends are disabled")
+	}
+
+	// Chain stored messages together so changes can be detected
+	hashChainEnabled = getEnvBool("HASH_CHAIN", false)
+	if hashChainEnabled {
+		logger.Infof("Hash chain enabled: stored messages are chained per chat")
+	}
+
+	// Configure the timezone used to render timestamps
+	if err := initTimezone(); err != nil {
+		logger.Errorf("Invalid timezone configuration: %v", err)
+		return
+	}
+
+	// Configure PII redaction for logs and webhooks
+	if err := initRedactors(); err != nil {
+		logger.Errorf("Invalid privacy configuration: %v", err)
+		return
+	}
+
+	// Configure the cache for chat listings, contact names and avatars
+	if err := initCache(); err != nil {
+		logger.Errorf("Invalid cache configuration: %v", err)
+		return
+	}
+
+	// Deliver bridge events to webhooks
+	webhookSink, err := NewWebhookSinkFromEnv(logger)
+	if err != nil {
+		logger.Errorf("Invalid webhook configuration: %v", err)
+		return
+	}
+	if webhookSink != nil {
+		webhookSink.Start()
+	}
+
 	// Log connection info
 	connInfo := dbAdapter.GetConnectionInfo()
 	logger.Infof("Database initialized: %+v", connInfo)
 
-	// Get device store - This contains session information
-	deviceStore, err := container.GetFirstDevice(context.Background())
+	// Initialize message store
+	messageStore, err := NewMessageStore(dbAdapter)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No device exists, create one
-			deviceStore = container.NewDevice()
-			logger.Infof("Created new device")
-		} else {
-			logger.Errorf("Failed to get device: %v", err)
-			return
-		}
+		logger.Errorf("Failed to initialize message store: %v", err)
+		return
+	}
+	defer messageStore.Close()
+
+	// Get device store - This contains session information. Devices of additional accounts are skipped.
+	deviceStore, err := defaultDevice(container, messageStore)
+	if err != nil {
+		logger.Errorf("Failed to get device: %v", err)
+		return
+	}
+	if deviceStore.ID == nil {
+		logger.Infof("Created new device")
 	}
 
 	// Create client instance
@@ -1126,16 +1884,259 @@ func main() {
 		return
 	}
 
-	// Initialize message store
-	messageStore, err := NewMessageStore(dbAdapter)
+	// Reach WhatsApp through a proxy where it is blocked
+	if err := configureProxy(client, logger); err != nil {
+		logger.Errorf("Invalid proxy configuration: %v", err)
+		return
+	}
+
+	// Additional WhatsApp numbers linked to this bridge, each with its own device and message history
+	sessionManager, err = NewSessionManager(container, messageStore, dbAdapter.dbURL, logger)
 	if err != nil {
-		logger.Errorf("Failed to initialize message store: %v", err)
+		logger.Errorf("Invalid account configuration: %v", err)
+		return
+	}
+
+	// Keep recent events for the activity feed
+	eventLog, err := NewEventLogFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid event log configuration: %v", err)
+		return
+	}
+	if eventLog != nil {
+		eventLog.Start()
+	}
+
+	// Send row changes of messages and chats to the CDC sink
+	cdcConsumer, err := NewCDCConsumerFromEnv(logger)
+	if err != nil {
+		logger.Errorf("Invalid CDC configuration: %v", err)
+		return
+	}
+	changeCapture.AddConsumer(cdcConsumer)
+
+	// Mirror messages into Elasticsearch or OpenSearch
+	searchIndex, err = NewSearchIndexFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid search index configuration: %v", err)
+		return
+	}
+	if searchIndex != nil {
+		searchConsumer, err := searchIndex.Consumer()
+		if err != nil {
+			logger.Errorf("Invalid search index configuration: %v", err)
+			return
+		}
+		changeCapture.AddConsumer(searchConsumer)
+		searchIndex.Start()
+	}
+
+	// Embed message text for semantic search
+	semanticSearch, err = NewSemanticSearchFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid semantic search configuration: %v", err)
+		return
+	}
+	if semanticSearch != nil {
+		changeCapture.AddConsumer(semanticSearch.Consumer())
+		semanticSearch.Start()
+	}
+
+	// Score incoming messages for the sentiment reports
+	sentimentScoring, err := NewSentimentFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid sentiment configuration: %v", err)
+		return
+	}
+	if sentimentScoring != nil {
+		changeCapture.AddConsumer(sentimentScoring.Consumer())
+	}
+	changeCapture.Start()
+
+	// Refuse sends and close the connection while an admin has the bridge in maintenance
+	maintenance = NewMaintenance(client, logger)
+
+	// Keep a history of pairing attempts for security review
+	pairingAudit = NewPairingAudit(messageStore, logger)
+
+	// Sign dashboard users in with local accounts when Supabase isn't configured
+	localAuth, err = NewLocalAuthFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Failed to set up dashboard users: %v", err)
+		return
+	}
+	passkeyAuth = NewPasskeyAuthFromEnv(messageStore, logger)
+
+	// Keep contacts' last-seen times and lasting presence subscriptions across restarts
+	presenceTracker.Attach(messageStore, logger)
+
+	// Locate dashboard logins and alert on logins from new countries
+	loginMonitor, err = NewLoginMonitorFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid login monitoring configuration: %v", err)
+		return
+	}
+
+	// Keep chats under litigation hold from being deleted, and log access to them
+	legalHolds = NewLegalHolds(messageStore, logger)
+
+	// Lock sending when the session may have been taken over
+	sessionGuard, err = NewSessionGuard(messageStore, logger)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	// Count messages and media per API key and enforce quotas
+	usageMeter, err = NewUsageMeterFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid usage quota configuration: %v", err)
+		return
+	}
+	messageCosts, err = LoadMessageCostsFromEnv(logger)
+	if err != nil {
+		logger.Errorf("Invalid message cost configuration: %v", err)
+		return
+	}
+
+	// API keys issued by the bridge, with their scopes
+	apiKeys = NewAPIKeyRegistry(messageStore, logger)
+	apiKeysRequired = getEnvBool("API_KEYS_REQUIRED", true)
+	if !apiKeysRequired {
+		logger.Warnf("API_KEYS_REQUIRED is off: the REST API accepts requests without a key")
+	} else if keys, err := messageStore.ListAPIKeys(); err == nil && !hasActiveAPIKey(keys) {
+		logger.Warnf("No API keys are issued yet, so the REST API refuses every request; create the first with -create-api-key NAME -api-key-scopes admin")
+	}
+
+	// Tenants sharing the bridge, so their keys can be suspended
+	tenantRegistry, err = NewTenantRegistry(messageStore, logger)
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	// Post each tenant's usage to the billing webhook after every period
+	billingReporter, err := NewBillingReporterFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid billing configuration: %v", err)
+		return
+	}
+	if billingReporter != nil {
+		billingReporter.Start()
+	}
+
+	// Upload each day's outbound messages to S3 for compliance retention
+	auditExporter, err := NewAuditExporterFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid audit export configuration: %v", err)
+		return
+	}
+	if auditExporter != nil {
+		auditExporter.Start()
+	}
+
+	// Download selected incoming media as it arrives instead of on first access
+	autoDownloadPolicy, err = NewAutoDownloadPolicyFromEnv(logger)
+	if err != nil {
+		logger.Errorf("Invalid media auto-download configuration: %v", err)
+		return
+	}
+	if autoDownloadPolicy != nil {
+		autoDownloadPolicy.Start(client, messageStore)
+	}
+
+	// Limit the daily sends of a newly linked number while it warms up
+	warmUp, err = NewWarmUpFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid warm-up configuration: %v", err)
+		return
+	}
+
+	// Measure databases, media and free disk space, and alert before the disk fills up
+	storageMonitor, err = NewStorageMonitorFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid storage threshold configuration: %v", err)
+		return
+	}
+	storageMonitor.Start()
+
+	// Prune old messages and orphaned media
+	if err := startMediaGC(messageStore, logger); err != nil {
+		logger.Errorf("Invalid retention configuration: %v", err)
+		return
+	}
+
+	// Label chats from their incoming messages, before routing so rules can match the labels
+	chatClassifier, err = NewClassifierFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid classification configuration: %v", err)
+		return
+	}
+
+	// Route incoming messages to webhooks, auto-replies and queues
+	messageRouter, err = NewRouterFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid routing rules: %v", err)
+		return
+	}
+
+	// Track first response and resolution times of assigned chats against their SLA targets
+	slaTracker, err = NewSLATrackerFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid SLA configuration: %v", err)
+		return
+	}
+	if slaTracker != nil {
+		slaTracker.Start()
+	}
+
+	// Send scheduled messages, campaigns and recurring series once they're due; the leader starts it below
+	messageScheduler, err = NewSchedulerFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid scheduler configuration: %v", err)
+		return
+	}
+
+	// Queue /send requests that ask for it and retry them until they go out; the leader starts it below
+	messageOutbox, err = NewOutboxFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid outbox configuration: %v", err)
+		return
+	}
+
+	// Load conversation flows for simple bots
+	flowEngine, err = NewFlowEngineFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid conversation flows: %v", err)
+		return
+	}
+
+	// Hand chats to agents when contacts ask for one, pausing the bot there until an agent resumes it
+	chatHandoff, err = NewHandoffFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid handoff configuration: %v", err)
+		return
+	}
+	chatHandoff.Start()
+
+	// Let allowlisted admins control the bridge with chat commands; receive-only can't answer them
+	if !receiveOnlyMode {
+		commandProcessor, err = NewCommandProcessorFromEnv(client, messageStore, logger)
+		if err != nil {
+			logger.Errorf("Invalid chat command configuration: %v", err)
+			return
+		}
+	}
+
+	// Moderate groups where the account is admin
+	groupModerator, err = NewModeratorFromEnv(client, messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid moderation config: %v", err)
 		return
 	}
-	defer messageStore.Close()
 
 	// Setup event handling for messages and history sync
-	client.AddEventHandler(func(evt interface{}) {
+	handleEvent := func(evt interface{}) {
 		switch v := evt.(type) {
 		case *events.Message:
 			// Process regular messages
@@ -1145,20 +2146,144 @@ func main() {
 			// Process history sync events
 			handleHistorySync(client, messageStore, v, logger)
 
+		case *events.GroupInfo:
+			// Participant, subject and description changes
+			handleGroupInfo(messageStore, v, logger)
+			if groupModerator != nil {
+				go groupModerator.HandleGroupInfo(v)
+			}
+
+		case *events.JoinedGroup:
+			// The account was added to a group
+			invalidateCache(cacheKeyJoinedGroups)
+
+		case *events.Picture:
+			// Profile picture and group icon changes
+			handlePicture(messageStore, v, logger)
+
+		case *events.PushName:
+			// Contact display name changes
+			handlePushName(messageStore, v, logger)
+
+		case *events.Presence:
+			// Online status of contacts we subscribed to
+			handlePresence(v)
+
+		case *events.Receipt:
+			// Wake sends waiting for delivery, tell subscribers and update the stored status
+			handleDeliveryReceipt(v, "")
+			recordReceiptStatus(messageStore, v, logger)
+
 		case *events.Connected:
 			logger.Infof("Connected to WhatsApp")
+			publishEvent(EventConnectionConnected, "", time.Time{}, map[string]interface{}{})
+			go presenceTracker.Reset(client, logger)
+			go warmUp.Begin()
+			messageOutbox.Wake()
+
+		case *events.Disconnected:
+			publishEvent(EventConnectionDisconnected, "", time.Time{}, map[string]interface{}{})
+
+		case *events.PairSuccess:
+			pairingAudit.Finish(PairingSucceeded, v.ID.String(), v.Platform, "")
+
+		case *events.PairError:
+			pairingAudit.Finish(PairingFailed, v.ID.String(), v.Platform, v.Error.Error())
+
+		case *events.StreamReplaced:
+			// Another client connected with our session; reconnecting would fight over it
+			sessionGuard.Lock(LockStreamReplaced, "another client connected with this session")
+			go func() {
+				sessionGuard.AwaitAcknowledgement()
+				if client.Store.ID != nil && !client.IsConnected() {
+					if err := client.Connect(); err != nil {
+						logger.Errorf("Failed to reconnect after acknowledgement: %v", err)
+					}
+				}
+			}()
 
 		case *events.LoggedOut:
 			logger.Warnf("Device logged out, please scan QR code to log in again")
+			publishEvent(EventConnectionLoggedOut, "", time.Time{}, map[string]interface{}{"reason": v.Reason.String()})
+			sessionGuard.Lock(LockLoggedOut, fmt.Sprintf("the bridge was unlinked (%s); restart it after acknowledging to pair again", v.Reason))
 		}
-	})
+	}
+	client.AddEventHandler(handleEvent)
+
+	// Pairing with a code instead of the QR code
+	registerPairPhoneRoutes(client, qrWebServer, logger)
+
+	// Register resumable upload routes for large media
+	uploadManager := NewUploadManager()
+	uploadManager.RegisterRoutes(client, messageStore)
+	uploadManager.StartCleanup()
+
+	// Elect a leader when several replicas share the database; followers serve reads until they take over
+	leaderElector, err = NewLeaderElectorFromEnv(messageStore, logger)
+	if err != nil {
+		logger.Errorf("Invalid HA configuration: %v", err)
+		return
+	}
+	if leaderElector != nil {
+		go startRESTServer(client, messageStore, dbAdapter, listen)
+		if err := leaderElector.AwaitLeadership(context.Background()); err != nil {
+			logger.Errorf("Failed to become leader: %v", err)
+			return
+		}
+		// Exit when leadership is lost so two replicas never hold the socket; the orchestrator restarts us as a follower
+		go leaderElector.MonitorLeadership(context.Background(), func() {
+			client.Disconnect()
+			logger.Errorf("Exiting so another replica can take over")
+			os.Exit(1)
+		})
+	}
+
+	restStarted := leaderElector != nil
+
+	// Connect the additional accounts; only the leader holds their sockets too
+	if !readOnlyMode {
+		sessionManager.Start()
+	}
+
+	// Only the leader sends scheduled and queued messages, and receive-only deployments never do
+	if !readOnlyMode && !receiveOnlyMode {
+		messageScheduler.Start()
+		messageOutbox.Start()
+	}
+
+	// Read-only deployments never pair a device, and can't take a lock acknowledgement,
+	// so without a usable session they only serve what is stored
+	if readOnlyMode && (client.Store.ID == nil || sessionGuard.Current() != nil) {
+		logger.Warnf("Read-only mode without a usable session; serving stored data without connecting to WhatsApp")
+		if restStarted {
+			select {}
+		}
+		startRESTServer(client, messageStore, dbAdapter, listen)
+		return
+	}
+
+	// After a possible takeover, wait for an admin before reconnecting or pairing again.
+	// The API has to be up to take the acknowledgement.
+	if lock := sessionGuard.Current(); lock != nil {
+		if !restStarted {
+			go startRESTServer(client, messageStore, dbAdapter, listen)
+			restStarted = true
+		}
+		logger.Warnf("Session locked since %s (%s); waiting for POST /api/v1/session/lock/acknowledge", lock.LockedAt.Format(time.RFC3339), lock.Reason)
+		sessionGuard.AwaitAcknowledgement()
+	}
 
 	// Create channel to track connection success
 	connected := make(chan bool, 1)
 
 	// Connect to WhatsApp
 	if client.Store.ID == nil {
-		// No ID stored, this is a new client, need to pair with phone
+		// No ID stored, this is a new client, need to pair with phone.
+		// The dashboard shows the QR code and takes pairing code requests, so it has to be up.
+		if !restStarted {
+			go startRESTServer(client, messageStore, dbAdapter, listen)
+			restStarted = true
+		}
 		qrChan, _ := client.GetQRChannel(context.Background())
 		err = client.Connect()
 		if err != nil {
@@ -1167,11 +2292,29 @@ func main() {
 		}
 
 		// Handle QR code for pairing with phone
-		fmt.Printf("\n🌐 QR Code available at: http://localhost:8080\n")
+		pairingCodeShown := false
+		if setup.PairPhone != "" {
+			pairingAudit.Begin("phone_code")
+		} else {
+			pairingAudit.Begin("qr")
+		}
+		fmt.Printf("\n🌐 QR Code available at: %s/\n", listen.LocalURL())
 		fmt.Println("Open the URL in your browser to scan the QR code with WhatsApp")
 		
 		for evt := range qrChan {
-			if evt.Event == "code" {
+			if evt.Event == "code" && setup.PairPhone != "" {
+				// Pair with a link code instead; WhatsApp accepts it once the first QR code is issued
+				if !pairingCodeShown {
+					code, err := requestPairingCode(client, setup.PairPhone)
+					if err != nil {
+						logger.Errorf("Failed to request pairing code: %v", err)
+						return
+					}
+					fmt.Printf("\n📱 Pairing code: %s\n", code)
+					fmt.Println("On your phone open WhatsApp > Linked devices > Link a device > Link with phone number instead, and enter the code")
+					pairingCodeShown = true
+				}
+			} else if evt.Event == "code" {
 				// Update web server with new QR code
 				qrWebServer.UpdateQRCode(evt.Code)
 				fmt.Println("\n📱 QR Code updated - refresh your browser to see the new code")
@@ -1183,6 +2326,9 @@ func main() {
 				qrWebServer.SetConnected()
 				connected <- true
 				break
+			} else if evt.Event != "code" {
+				// Timeouts and pairing errors end the attempt
+				pairingAudit.FinishQR(evt)
 			}
 		}
 
@@ -1192,6 +2338,7 @@ func main() {
 			fmt.Println("\nSuccessfully connected and authenticated!")
 		case <-time.After(3 * time.Minute):
 			logger.Errorf("Timeout waiting for QR code scan")
+			pairingAudit.Finish(PairingTimeout, "", "", "QR code was not scanned in time")
 			return
 		}
 	} else {
@@ -1216,14 +2363,21 @@ func main() {
 
 	fmt.Println("\n✓ Connected to WhatsApp! Type 'help' for commands.")
 
+	// Inject faults to soak-test recovery (chaos builds only)
+	startChaos(client, messageStore, logger)
+
+	// In HA mode, or after waiting for a lock acknowledgement, the REST API server is already running
+	if restStarted {
+		select {}
+	}
+
 	// Start REST API server - this will now run in the main goroutine
-	startRESTServer(client, messageStore, dbAdapter, 8080)
+	startRESTServer(client, messageStore, dbAdapter, listen)
 }
 
 // GetChatName determines the appropriate name for a chat based on JID and other info
 func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types.JID, chatJID string, conversation interface{}, sender string, logger waLog.Logger) string {
 	// First, check if chat already exists in database with a name
-	var existingName string
 	var query string
 	
 	if messageStore.isPostgres {
@@ -1232,10 +2386,14 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 		query = "SELECT name FROM chats WHERE jid = ?"
 	}
 	
-	err := messageStore.db.QueryRow(query, chatJID).Scan(&existingName)
+	existingName, err := cached(cacheKeyChatName+chatJID, contactCacheTTL, func() (string, error) {
+		var name string
+		err := messageStore.db.QueryRow(query, chatJID).Scan(&name)
+		return name, err
+	})
 	if err == nil && existingName != "" {
 		// Chat exists with a name, use that
-		logger.Infof("Using existing chat name for %s: %s", chatJID, existingName)
+		logger.Infof("Using existing chat name for %s: %s", logRedactor.Phone(chatJID), logRedactor.Name(existingName))
 		return existingName
 	}
 
@@ -1288,10 +2446,10 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 			}
 		}
 
-		logger.Infof("Using group name: %s", name)
+		logger.Infof("Using group name: %s", logRedactor.Name(name))
 	} else {
 		// This is an individual contact
-		logger.Infof("Getting name for contact: %s", chatJID)
+		logger.Infof("Getting name for contact: %s", logRedactor.Phone(chatJID))
 
 		// Just use contact info (full name)
 		contact, err := client.Store.Contacts.GetContact(context.Background(), jid)
@@ -1305,7 +2463,7 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 			name = jid.User
 		}
 
-		logger.Infof("Using contact name: %s", name)
+		logger.Infof("Using contact name: %s", logRedactor.Name(name))
 	}
 
 	return name
@@ -1327,7 +2485,7 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 		// Try to parse the JID
 		jid, err := types.ParseJID(chatJID)
 		if err != nil {
-			logger.Warnf("Failed to parse JID %s: %v", chatJID, err)
+			logger.Warnf("Failed to parse JID %s: %v", logRedactor.Phone(chatJID), err)
 			continue
 		}
 
@@ -1378,8 +2536,13 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength = extractMediaInfo(msg.Message.Message)
 				}
 
+				// Store the reactions the message received
+				for _, reaction := range msg.Message.GetReactions() {
+					storeHistoryReaction(client, messageStore, chatJID, jid, msg.Message.GetKey().GetID(), reaction, logger)
+				}
+
 				// Log the message content for debugging
-				logger.Infof("Message content: %v, Media Type: %v", content, mediaType)
+				logger.Infof("Message content: %v, Media Type: %v", logRedactor.Body(content), mediaType)
 
 				// Skip messages with no content and no media
 				if content == "" && mediaType == "" {
@@ -1437,13 +2600,18 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
 					syncedCount++
+					if replyTo := quotedMessageID(msg.Message.Message); replyTo != "" {
+						if err := messageStore.SetReplyTo(msgID, chatJID, replyTo); err != nil {
+							logger.Warnf("Failed to store quoted message reference: %v", err)
+						}
+					}
 					// Log successful message storage
 					if mediaType != "" {
 						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, mediaType, filename, content)
+							timestamp.Format("2006-01-02 15:04:05"), logRedactor.Phone(sender), logRedactor.Phone(chatJID), mediaType, filename, logRedactor.Body(content))
 					} else {
 						logger.Infof("Stored message: [%s] %s -> %s: %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, content)
+							timestamp.Format("2006-01-02 15:04:05"), logRedactor.Phone(sender), logRedactor.Phone(chatJID), logRedactor.Body(content))
 					}
 				}
 			}
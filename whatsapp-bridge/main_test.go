@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// newTestMessageStore opens a SQLite message store in a temporary data directory
+func newTestMessageStore(t *testing.T) *MessageStore {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	previousDir := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = previousDir })
+
+	adapter := NewDatabaseAdapter(waLog.Noop)
+	if _, err := adapter.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewMessageStore(adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Maintenance states
+const (
+	MaintenanceOff    = "off"
+	MaintenanceActive = "active"
+)
+
+// MaintenanceStatus is the response of /api/v1/admin/maintenance
+type MaintenanceStatus struct {
+	State  string     `json:"state"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// Maintenance pauses sends and event processing, e.g. while the database is migrated.
+// Nothing is held in the bridge's memory: /send queues into the outbox, other sends are refused
+// with 503, and the WhatsApp connections of every account are closed so the server keeps
+// incoming messages until maintenance ends and they are reopened.
+type Maintenance struct {
+	client *whatsmeow.Client
+	logger waLog.Logger
+	state  string
+	reason string
+	since  time.Time
+	// reconnect is set when Start closed the connection, and accounts lists the additional
+	// accounts it disconnected, so Resume only reopens what it closed
+	reconnect bool
+	accounts  []*Account
+	mutex     sync.Mutex
+}
+
+// maintenance is the process-wide maintenance switch
+var maintenance *Maintenance
+
+// NewMaintenance creates the maintenance switch for a client, off
+func NewMaintenance(client *whatsmeow.Client, logger waLog.Logger) *Maintenance {
+	return &Maintenance{client: client, logger: logger, state: MaintenanceOff}
+}
+
+// Status returns the current state
+func (m *Maintenance) Status() MaintenanceStatus {
+	if m == nil {
+		return MaintenanceStatus{State: MaintenanceOff}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := MaintenanceStatus{State: m.state, Reason: m.reason}
+	if m.state != MaintenanceOff {
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// Start pauses sends and closes the WhatsApp connections; starting again only updates the reason
+func (m *Maintenance) Start(reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == MaintenanceActive {
+		m.reason = reason
+		return
+	}
+	m.state = MaintenanceActive
+	m.reason = reason
+	m.since = time.Now().UTC()
+	// An expected disconnect, so whatsmeow doesn't reconnect on its own
+	if m.client.IsConnected() {
+		m.client.Disconnect()
+		m.reconnect = true
+	}
+	m.accounts = sessionManager.Disconnect()
+	m.logger.Warnf("Maintenance started: %s", reason)
+	publishEvent(EventMaintenanceStarted, "", m.since, map[string]interface{}{"reason": reason})
+}
+
+// Resume ends maintenance and reopens the connections, after which WhatsApp delivers what
+// arrived in the meantime and the outbox sends what was queued
+func (m *Maintenance) Resume() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state != MaintenanceActive {
+		return
+	}
+	m.state = MaintenanceOff
+	m.reason = ""
+	if m.reconnect && m.client.Store.ID != nil && !m.client.IsConnected() {
+		go func() {
+			if err := m.client.Connect(); err != nil {
+				m.logger.Errorf("Failed to reconnect after maintenance: %v", err)
+			}
+		}()
+	}
+	m.reconnect = false
+	sessionManager.Reconnect(m.accounts)
+	m.accounts = nil
+	// Also drained when the connection comes back, but it may never have been closed
+	messageOutbox.Wake()
+	m.logger.Infof("Maintenance ended")
+	publishEvent(EventMaintenanceEnded, "", time.Time{}, map[string]interface{}{})
+}
+
+// refuseDuringMaintenance wraps the send handlers the outbox can't queue for, so requests made
+// during maintenance get 503 with Retry-After; callers retry them, so nothing is lost if the
+// bridge restarts in the meantime
+func refuseDuringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenance.Status().State != MaintenanceOff {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "The bridge is in maintenance; try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// MaintenanceRequest is the body of POST /api/v1/admin/maintenance
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// registerMaintenanceRoutes registers /api/v1/admin/maintenance
+func registerMaintenanceRoutes() {
+	handleAPI("/admin/maintenance", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPost:
+			var req MaintenanceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if len(req.Reason) > 500 {
+				http.Error(w, "reason must be at most 500 characters", http.StatusBadRequest)
+				return
+			}
+			if req.Enabled {
+				maintenance.Start(req.Reason)
+			} else {
+				maintenance.Resume()
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := maintenance.Status()
+		if status.Since != nil {
+			since := status.Since.In(loc)
+			status.Since = &since
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}))
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// staleDownloadAge is how old an unfinished download must be before the orphan scan removes it
+const staleDownloadAge = 24 * time.Hour
+
+// OrphanFile is a media file no stored message refers to
+type OrphanFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MediaGCReport is the result of an orphan scan
+type MediaGCReport struct {
+	Scanned int          `json:"scanned"`
+	Orphans []OrphanFile `json:"orphans"`
+	Bytes   int64        `json:"bytes"`
+	Removed int          `json:"removed"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+// mediaFilePath returns where the media of a message is stored once downloaded
+func mediaFilePath(chatJID, filename string) string {
+	return filepath.Join(dataPath(strings.ReplaceAll(chatJID, ":", "_")), filepath.Base(filename))
+}
+
+// removeMediaFile deletes a downloaded media file, ignoring media that was never downloaded
+func removeMediaFile(chatJID, filename string) error {
+	if filename == "" {
+		return nil
+	}
+	if err := os.Remove(mediaFilePath(chatJID, filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteMessage removes a message with its reactions, receipts and downloaded media
+func (store *MessageStore) DeleteMessage(id, chatJID string) (bool, error) {
+	query := "SELECT COALESCE(filename, ''), COALESCE(storage_path, '') FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT COALESCE(filename, ''), COALESCE(storage_path, '') FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	var filename, object string
+	if err := store.db.QueryRow(query, id, chatJID).Scan(&filename, &object); err != nil {
+		// Revokes of messages that were never stored are common
+		return false, nil
+	}
+
+	queries := []string{
+		"DELETE FROM messages WHERE id = ? AND chat_jid = ?",
+		"DELETE FROM message_reactions WHERE message_id = ? AND chat_jid = ?",
+		"DELETE FROM message_receipts WHERE message_id = ? AND chat_jid = ?",
+	}
+	if store.isPostgres {
+		queries = []string{
+			"DELETE FROM messages WHERE id = $1 AND chat_jid = $2",
+			"DELETE FROM message_reactions WHERE message_id = $1 AND chat_jid = $2",
+			"DELETE FROM message_receipts WHERE message_id = $1 AND chat_jid = $2",
+		}
+	}
+	if err := store.captureMessage(id, chatJID, func() error {
+		for _, query := range queries {
+			if _, err := store.db.Exec(query, id, chatJID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if object != "" {
+		if err := mediaStorage.Remove(object); err != nil {
+			return true, fmt.Errorf("failed to delete media from storage: %v", err)
+		}
+	}
+	return true, removeMediaFile(chatJID, filename)
+}
+
+// PruneMessages deletes messages older than a cutoff with their reactions, receipts and downloaded media,
+// and returns the number of messages deleted. Chats on legal hold are kept.
+func (store *MessageStore) PruneMessages(before time.Time) (int64, error) {
+	query := "SELECT chat_jid, filename, COALESCE(storage_path, '') FROM messages WHERE timestamp < ? AND COALESCE(filename, '') <> '' AND chat_jid NOT IN (" + heldChatsQuery + ")"
+	if store.isPostgres {
+		query = "SELECT chat_jid, filename, COALESCE(storage_path, '') FROM messages WHERE timestamp < $1 AND COALESCE(filename, '') <> '' AND chat_jid NOT IN (" + heldChatsQuery + ")"
+	}
+	rows, err := store.db.Query(query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var media [][2]string
+	var objects []string
+	for rows.Next() {
+		var chatJID, filename, object string
+		if err := rows.Scan(&chatJID, &filename, &object); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		media = append(media, [2]string{chatJID, filename})
+		if object != "" {
+			objects = append(objects, object)
+		}
+	}
+	rows.Close()
+
+	condition := "timestamp < ? AND chat_jid NOT IN (" + heldChatsQuery + ")"
+	if store.isPostgres {
+		condition = "timestamp < $1 AND chat_jid NOT IN (" + heldChatsQuery + ")"
+	}
+	pruned := store.messagesToDelete(condition, before.UTC())
+	result, err := store.db.Exec("DELETE FROM messages WHERE "+condition, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	emitMessageDeletes(pruned, false)
+
+	// Reactions whose message is gone
+	if _, err := store.db.Exec(`DELETE FROM message_reactions WHERE NOT EXISTS
+		(SELECT 1 FROM messages WHERE messages.id = message_reactions.message_id AND messages.chat_jid = message_reactions.chat_jid)`); err != nil {
+		return deleted, err
+	}
+	if _, err := store.db.Exec(`DELETE FROM message_receipts WHERE NOT EXISTS
+		(SELECT 1 FROM messages WHERE messages.id = message_receipts.message_id AND messages.chat_jid = message_receipts.chat_jid)`); err != nil {
+		return deleted, err
+	}
+
+	if err := mediaStorage.Remove(objects...); err != nil {
+		return deleted, fmt.Errorf("failed to delete media from storage: %v", err)
+	}
+	for _, file := range media {
+		if err := removeMediaFile(file[0], file[1]); err != nil {
+			return deleted, fmt.Errorf("failed to delete media file %s: %v", filepath.Base(file[1]), err)
+		}
+	}
+	return deleted, nil
+}
+
+// referencedMedia returns the paths of media files stored messages refer to
+func (store *MessageStore) referencedMedia() (map[string]bool, error) {
+	rows, err := store.db.Query("SELECT chat_jid, filename FROM messages WHERE COALESCE(filename, '') <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := map[string]bool{}
+	for rows.Next() {
+		var chatJID, filename string
+		if err := rows.Scan(&chatJID, &filename); err != nil {
+			return nil, err
+		}
+		paths[mediaFilePath(chatJID, filename)] = true
+	}
+	return paths, rows.Err()
+}
+
+// ScanOrphanMedia lists files in the chat media directories that no stored message refers to,
+// and removes them if asked. Other data directory contents, such as uploads, are left alone.
+func (store *MessageStore) ScanOrphanMedia(remove bool) (*MediaGCReport, error) {
+	referenced, err := store.referencedMedia()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MediaGCReport{Orphans: []OrphanFile{}}
+	dirs, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		// Chat media directories are named after the chat JID
+		if !dir.IsDir() || !strings.Contains(dir.Name(), "@") {
+			continue
+		}
+		chatDir := dataPath(dir.Name())
+		entries, err := os.ReadDir(chatDir)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to read %s: %v", dir.Name(), err))
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			report.Scanned++
+
+			path := filepath.Join(chatDir, entry.Name())
+			if referenced[path] {
+				continue
+			}
+			// Downloads in progress are written to dot files first
+			if strings.HasPrefix(entry.Name(), ".") && time.Since(info.ModTime()) < staleDownloadAge {
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, OrphanFile{Path: filepath.Join(dir.Name(), entry.Name()), Bytes: info.Size()})
+			report.Bytes += info.Size()
+			if remove {
+				if err := os.Remove(path); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("failed to remove %s: %v", entry.Name(), err))
+					continue
+				}
+				report.Removed++
+			}
+		}
+		if remove {
+			// Only succeeds once the directory is empty
+			os.Remove(chatDir)
+		}
+	}
+	return report, nil
+}
+
+// handleRevoke deletes a message its sender deleted for everyone, with its media.
+// Messages in chats on legal hold are kept and the revoke is logged instead.
+func handleRevoke(messageStore *MessageStore, chatJID, messageID string, timestamp time.Time, logger waLog.Logger) {
+	if messageID == "" || readOnlyMode {
+		return
+	}
+	if legalHolds.IsHeld(chatJID) {
+		legalHolds.Record(chatJID, HoldRevokeKept, "whatsapp", "", messageID)
+		publishEvent(EventMessageRevoked, chatJID, timestamp, map[string]interface{}{
+			"chat_jid":   chatJID,
+			"message_id": messageID,
+			"legal_hold": true,
+		})
+		return
+	}
+	deleted, err := messageStore.DeleteMessage(messageID, chatJID)
+	if err != nil {
+		logger.Warnf("Failed to delete revoked message %s: %v", messageID, err)
+		return
+	}
+	if deleted {
+		publishEvent(EventMessageRevoked, chatJID, timestamp, map[string]interface{}{
+			"chat_jid":   chatJID,
+			"message_id": messageID,
+		})
+	}
+}
+
+// startMediaGC prunes messages past MESSAGE_RETENTION_DAYS every hour and removes orphaned
+// media every MEDIA_GC_INTERVAL_HOURS; both are off by default
+func startMediaGC(messageStore *MessageStore, logger waLog.Logger) error {
+	retentionDays := getEnvInt("MESSAGE_RETENTION_DAYS", 0)
+	gcHours := getEnvInt("MEDIA_GC_INTERVAL_HOURS", 0)
+	if retentionDays < 0 || gcHours < 0 {
+		return fmt.Errorf("MESSAGE_RETENTION_DAYS and MEDIA_GC_INTERVAL_HOURS must not be negative")
+	}
+	if retentionDays == 0 && gcHours == 0 {
+		return nil
+	}
+
+	go func() {
+		var lastScan time.Time
+		for {
+			// The database is shared between replicas, so only the leader prunes
+			if !readOnlyMode && (leaderElector == nil || leaderElector.IsLeader()) {
+				if retentionDays > 0 {
+					cutoff := time.Now().AddDate(0, 0, -retentionDays)
+					if deleted, err := messageStore.PruneMessages(cutoff); err != nil {
+						logger.Warnf("Failed to prune old messages: %v", err)
+					} else if deleted > 0 {
+						logger.Infof("Pruned %d messages older than %d days", deleted, retentionDays)
+					}
+				}
+				if gcHours > 0 && time.Since(lastScan) >= time.Duration(gcHours)*time.Hour {
+					lastScan = time.Now()
+					if report, err := messageStore.ScanOrphanMedia(true); err != nil {
+						logger.Warnf("Failed to scan for orphaned media: %v", err)
+					} else if len(report.Orphans) > 0 {
+						logger.Infof("Removed %d of %d orphaned media files (%d bytes)", report.Removed, len(report.Orphans), report.Bytes)
+					}
+				}
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+	return nil
+}
+
+// registerMediaGCRoutes registers /api/v1/admin/media/gc: GET reports orphaned media, POST removes it
+func registerMediaGCRoutes(messageStore *MessageStore) {
+	handleAPI("/admin/media/gc", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		var remove bool
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			remove = true
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, err := messageStore.ScanOrphanMedia(remove)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to scan media: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}))
+}
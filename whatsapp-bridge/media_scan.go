@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scan statuses stored on the message record
+const (
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	ScanStatusError    = "error"
+)
+
+// Actions taken when a scanner flags a file
+const (
+	ScanActionQuarantine = "quarantine"
+	ScanActionReject     = "reject"
+	ScanActionAllow      = "allow"
+)
+
+// ScanResult is the verdict returned by a media scanner
+type ScanResult struct {
+	Clean     bool   `json:"clean"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// MediaScanner inspects media content before it is sent or stored
+type MediaScanner interface {
+	Name() string
+	Scan(r io.Reader, filename string) (ScanResult, error)
+}
+
+// ClamAVScanner scans media through a clamd socket using the INSTREAM command
+type ClamAVScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamAVScanner creates a scanner for a clamd address ("/path/to/clamd.sock" or "host:port")
+func NewClamAVScanner(address string) *ClamAVScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+
+	return &ClamAVScanner{
+		network: network,
+		address: address,
+		timeout: 30 * time.Second,
+	}
+}
+
+// Name implements the MediaScanner interface
+func (s *ClamAVScanner) Name() string {
+	return "clamav"
+}
+
+// Scan implements the MediaScanner interface
+func (s *ClamAVScanner) Scan(r io.Reader, filename string) (ScanResult, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, fmt.Errorf("failed to start clamd stream: %v", err)
+	}
+
+	// Stream the data as length-prefixed chunks, terminated by a zero-length chunk
+	chunk := make([]byte, 32*1024)
+	var size [4]byte
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return ScanResult{}, fmt.Errorf("failed to stream data to clamd: %v", err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return ScanResult{}, fmt.Errorf("failed to stream data to clamd: %v", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, fmt.Errorf("failed to read media for scanning: %v", readErr)
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return ScanResult{}, fmt.Errorf("failed to finish clamd stream: %v", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND"
+	verdict := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	verdict = strings.TrimPrefix(verdict, "stream: ")
+	switch {
+	case verdict == "OK":
+		return ScanResult{Clean: true}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return ScanResult{Clean: false, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd error: %s", verdict)
+	}
+}
+
+// HTTPScanner posts media to an external scanning service.
+// The service receives the raw bytes and must reply with {"clean": bool, "signature": "..."}.
+type HTTPScanner struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPScanner creates a scanner that posts media to the given URL
+func NewHTTPScanner(url string) *HTTPScanner {
+	return &HTTPScanner{
+		url:    url,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Name implements the MediaScanner interface
+func (s *HTTPScanner) Name() string {
+	return "http"
+}
+
+// Scan implements the MediaScanner interface
+func (s *HTTPScanner) Scan(r io.Reader, filename string) (ScanResult, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, r)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to create scan request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", filename)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("scan request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ScanResult{}, fmt.Errorf("scanner returned status %d", resp.StatusCode)
+	}
+
+	var result ScanResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ScanResult{}, fmt.Errorf("invalid scanner response: %v", err)
+	}
+
+	return result, nil
+}
+
+// MediaScanPolicy decides what happens to media based on the scanner verdict
+type MediaScanPolicy struct {
+	scanner       MediaScanner
+	onInfected    string
+	failOpen      bool
+	quarantineDir string
+}
+
+// mediaScanPolicy is the process-wide scanning policy, nil when scanning is disabled
+var mediaScanPolicy *MediaScanPolicy
+
+// NewMediaScanPolicyFromEnv builds the scanning policy from environment variables.
+// It returns nil when MEDIA_SCANNER is not set.
+func NewMediaScanPolicyFromEnv() (*MediaScanPolicy, error) {
+	var scanner MediaScanner
+
+	switch strings.ToLower(os.Getenv("MEDIA_SCANNER")) {
+	case "":
+		return nil, nil
+	case "clamav":
+		address := os.Getenv("CLAMAV_ADDRESS")
+		if address == "" {
+			address = "127.0.0.1:3310"
+		}
+		scanner = NewClamAVScanner(address)
+	case "http":
+		url := os.Getenv("MEDIA_SCANNER_URL")
+		if url == "" {
+			return nil, fmt.Errorf("MEDIA_SCANNER_URL is required for the http scanner")
+		}
+		scanner = NewHTTPScanner(url)
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_SCANNER %q", os.Getenv("MEDIA_SCANNER"))
+	}
+
+	onInfected := strings.ToLower(os.Getenv("MEDIA_SCAN_ON_INFECTED"))
+	switch onInfected {
+	case "":
+		onInfected = ScanActionQuarantine
+	case ScanActionQuarantine, ScanActionReject, ScanActionAllow:
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_SCAN_ON_INFECTED %q", onInfected)
+	}
+
+	return &MediaScanPolicy{
+		scanner:       scanner,
+		onInfected:    onInfected,
+		failOpen:      getEnvBool("MEDIA_SCAN_FAIL_OPEN", false),
+		quarantineDir: dataPath("quarantine"),
+	}, nil
+}
+
+// Check scans the data and reports the status to store, a detail string, and whether the media may be used
+func (p *MediaScanPolicy) Check(r io.Reader, filename string) (status string, detail string, allowed bool) {
+	result, err := p.scanner.Scan(r, filename)
+	if err != nil {
+		fmt.Printf("Media scan of %s with %s failed: %v\n", filename, p.scanner.Name(), err)
+		return ScanStatusError, err.Error(), p.failOpen
+	}
+
+	if result.Clean {
+		return ScanStatusClean, "", true
+	}
+
+	fmt.Printf("Media scan flagged %s: %s\n", filename, result.Signature)
+	return ScanStatusInfected, result.Signature, p.onInfected == ScanActionAllow
+}
+
+// Quarantine moves a flagged file into the quarantine directory instead of the chat directory
+func (p *MediaScanPolicy) Quarantine(srcPath, chatJID, filename string) (string, error) {
+	if p.onInfected != ScanActionQuarantine {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(p.quarantineDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %v", err)
+	}
+
+	name := fmt.Sprintf("%s_%s", strings.ReplaceAll(chatJID, ":", "_"), filename)
+	path := filepath.Join(p.quarantineDir, name)
+	if err := os.Rename(srcPath, path); err != nil {
+		return "", fmt.Errorf("failed to move file to quarantine: %v", err)
+	}
+
+	return path, nil
+}
+
+// UpdateScanResult stores the scan verdict on a message record
+func (store *MessageStore) UpdateScanResult(id, chatJID, status, detail string) error {
+	var query string
+	if store.isPostgres {
+		query = "UPDATE messages SET scan_status = $1, scan_detail = $2 WHERE id = $3 AND chat_jid = $4"
+	} else {
+		query = "UPDATE messages SET scan_status = ?, scan_detail = ? WHERE id = ? AND chat_jid = ?"
+	}
+
+	_, err := store.db.Exec(query, status, detail, id, chatJID)
+	return err
+}
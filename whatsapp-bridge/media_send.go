@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// maxBase64MediaBytes bounds files sent base64-encoded in JSON, which is decoded in memory;
+// larger files go as multipart uploads, which are streamed to disk
+const maxBase64MediaBytes = 100 << 20
+
+// sendMediaTooLarge answers multipart uploads beyond maxSendMediaBytes
+var sendMediaTooLarge = fmt.Sprintf("Media must be at most %d MB, the most WhatsApp accepts", maxSendMediaBytes>>20)
+
+// sniffedExtensions maps content types recognised from a file's first bytes to the extension it is sent with
+var sniffedExtensions = map[string]string{
+	"image/jpeg":      "jpg",
+	"image/png":       "png",
+	"image/gif":       "gif",
+	"image/webp":      "webp",
+	"video/mp4":       "mp4",
+	"video/avi":       "avi",
+	"application/ogg": "ogg",
+	"audio/mpeg":      "mp3",
+	"application/pdf": "pdf",
+}
+
+// SendMediaRequest is the JSON body of /api/v1/send/media, with the file base64-encoded
+type SendMediaRequest struct {
+	Recipient string `json:"recipient"`
+	// Message is the caption
+	Message string `json:"message"`
+	// Media is the file as base64, or as a data: URL
+	Media     string `json:"media"`
+	Filename  string `json:"filename,omitempty"`
+	MimeType  string `json:"mime_type,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Signature *bool  `json:"signature,omitempty"`
+	// QuotedMessageID sends the media as a reply to a stored message of the chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// mediaTypeForExtension returns how a file with an extension is sent: as an image, a voice note (Ogg Opus),
+// other audio or a video, and with which MIME type. Anything else is sent as a document.
+func mediaTypeForExtension(ext string) (whatsmeow.MediaType, string) {
+	switch ext = strings.ToLower(ext); ext {
+	case "jpg", "jpeg":
+		return whatsmeow.MediaImage, "image/jpeg"
+	case "png", "gif", "webp":
+		return whatsmeow.MediaImage, "image/" + ext
+	case "ogg":
+		return whatsmeow.MediaAudio, "audio/ogg; codecs=opus"
+	case "mp3":
+		return whatsmeow.MediaAudio, "audio/mpeg"
+	case "m4a":
+		return whatsmeow.MediaAudio, "audio/mp4"
+	case "aac", "amr":
+		return whatsmeow.MediaAudio, "audio/" + ext
+	case "mp4":
+		return whatsmeow.MediaVideo, "video/mp4"
+	case "avi":
+		return whatsmeow.MediaVideo, "video/avi"
+	case "mov":
+		return whatsmeow.MediaVideo, "video/quicktime"
+	}
+	if mimeType := mime.TypeByExtension("." + ext); ext != "" && mimeType != "" {
+		return whatsmeow.MediaDocument, mimeType
+	}
+	return whatsmeow.MediaDocument, "application/octet-stream"
+}
+
+// sendMediaFilename picks the name a file is sent under. The content type sniffed from its first bytes
+// wins over the extension of the given name, then the declared MIME type fills in a missing extension.
+// Files without a name are named like received media, e.g. image_20250730_131536.jpg.
+func sendMediaFilename(name, declared string, head []byte) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	declared, _, _ = mime.ParseMediaType(declared)
+	if canonical, ok := sniffedExtensions[sniffed]; ok {
+		// jpeg and jpg, say, are the same; only a different type is corrected
+		_, have := mediaTypeForExtension(ext)
+		if _, want := mediaTypeForExtension(canonical); have != want {
+			ext = canonical
+		}
+	} else if ext == "" {
+		// The system MIME table lists extensions alphabetically, which would make plain text .asc
+		if canonical, ok := sniffedExtensions[declared]; ok {
+			ext = canonical
+		} else if declared == "text/plain" || (declared == "" && sniffed == "text/plain") {
+			ext = "txt"
+		} else if extensions, _ := mime.ExtensionsByType(declared); len(extensions) > 0 && declared != "application/octet-stream" {
+			ext = strings.TrimPrefix(extensions[0], ".")
+		} else {
+			ext = "bin"
+		}
+	}
+
+	if base == "" {
+		kind := "document"
+		switch mediaType, _ := mediaTypeForExtension(ext); mediaType {
+		case whatsmeow.MediaImage:
+			kind = "image"
+		case whatsmeow.MediaVideo:
+			kind = "video"
+		case whatsmeow.MediaAudio:
+			kind = "audio"
+		}
+		base = kind + "_" + time.Now().Format("20060102_150405")
+	}
+	return base + "." + ext
+}
+
+// saveSendMedia writes an uploaded file into dir under the name it is sent with, and returns its path
+func saveSendMedia(dir, name, declared string, data io.Reader) (string, error) {
+	upload, err := os.CreateTemp(dir, "upload-")
+	if err != nil {
+		return "", err
+	}
+	size, err := io.Copy(upload, data)
+	if closeErr := upload.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if size == 0 {
+		return "", fmt.Errorf("the file is empty")
+	}
+
+	file, err := os.Open(upload.Name())
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	file.Close()
+
+	mediaPath := filepath.Join(dir, sendMediaFilename(name, declared, head[:n]))
+	if err := os.Rename(upload.Name(), mediaPath); err != nil {
+		return "", err
+	}
+	return mediaPath, nil
+}
+
+// decodeBase64Media decodes base64 or a data: URL, returning the MIME type the URL declares
+func decodeBase64Media(value string) ([]byte, string, error) {
+	declared := ""
+	if strings.HasPrefix(value, "data:") {
+		header, payload, found := strings.Cut(value, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return nil, "", fmt.Errorf("media data URLs must be base64-encoded")
+		}
+		declared = strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+		value = payload
+	}
+	value = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, value)
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(value); err != nil {
+			return nil, "", fmt.Errorf("media is not valid base64")
+		}
+	}
+	return data, declared, nil
+}
+
+// readSendMediaRequest reads a multipart upload with a file part and form fields, or a JSON
+// SendMediaRequest, saves the file into dir and returns the request and the file's path
+func readSendMediaRequest(w http.ResponseWriter, r *http.Request, dir string) (SendMediaRequest, string, int, string) {
+	var req SendMediaRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBase64MediaBytes/3*4+1<<20)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				return req, "", http.StatusRequestEntityTooLarge, fmt.Sprintf("Base64 media must be at most %d MB; upload larger files as multipart/form-data", maxBase64MediaBytes>>20)
+			}
+			return req, "", http.StatusBadRequest, "Invalid request format; send JSON with base64 media, or multipart/form-data"
+		}
+		if req.Media == "" {
+			return req, "", http.StatusBadRequest, "Media is required"
+		}
+		data, declared, err := decodeBase64Media(req.Media)
+		if err != nil {
+			return req, "", http.StatusBadRequest, err.Error()
+		}
+		if len(data) > maxBase64MediaBytes {
+			return req, "", http.StatusRequestEntityTooLarge, fmt.Sprintf("Base64 media must be at most %d MB; upload larger files as multipart/form-data", maxBase64MediaBytes>>20)
+		}
+		if req.MimeType != "" {
+			declared = req.MimeType
+		}
+		mediaPath, err := saveSendMedia(dir, req.Filename, declared, bytes.NewReader(data))
+		if err != nil {
+			return req, "", http.StatusBadRequest, fmt.Sprintf("Failed to store media: %v", err)
+		}
+		return req, mediaPath, 0, ""
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSendMediaBytes+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return req, "", http.StatusBadRequest, fmt.Sprintf("Invalid multipart request: %v", err)
+	}
+	mediaPath := ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				return req, "", http.StatusRequestEntityTooLarge, sendMediaTooLarge
+			}
+			return req, "", http.StatusBadRequest, fmt.Sprintf("Invalid multipart request: %v", err)
+		}
+
+		if part.FormName() == "file" {
+			if mediaPath != "" {
+				return req, "", http.StatusBadRequest, "Send one file per message"
+			}
+			if mediaPath, err = saveSendMedia(dir, part.FileName(), part.Header.Get("Content-Type"), part); err != nil {
+				if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+					return req, "", http.StatusRequestEntityTooLarge, sendMediaTooLarge
+				}
+				return req, "", http.StatusBadRequest, fmt.Sprintf("Failed to store media: %v", err)
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 64<<10))
+		if err != nil {
+			return req, "", http.StatusBadRequest, fmt.Sprintf("Invalid multipart request: %v", err)
+		}
+		switch part.FormName() {
+		case "recipient":
+			req.Recipient = string(value)
+		case "message":
+			req.Message = string(value)
+		case "client_ref":
+			req.ClientRef = string(value)
+		case "agent":
+			req.Agent = string(value)
+		case "quoted_message_id":
+			req.QuotedMessageID = string(value)
+		case "signature":
+			signature, err := strconv.ParseBool(string(value))
+			if err != nil {
+				return req, "", http.StatusBadRequest, "signature must be true or false"
+			}
+			req.Signature = &signature
+		}
+	}
+	if mediaPath == "" {
+		return req, "", http.StatusBadRequest, "A file part is required"
+	}
+	return req, mediaPath, 0, ""
+}
+
+// registerMediaSendRoutes registers /api/v1/send/media, which sends an uploaded image, video, audio or document
+func registerMediaSendRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	handleAPI("/send/media", leaderOnly(refuseDuringMaintenance(sendUnlocked(meteredSend(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		waitFor, waitTimeout, err := parseDeliveryWait(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The file name is what the message is stored under, like media sent with media_path
+		dir, err := os.MkdirTemp("", "send-media-")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to store media: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		req, mediaPath, status, problem := readSendMediaRequest(w, r, dir)
+		if problem != "" {
+			http.Error(w, problem, status)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.ClientRef) > maxClientRefLen {
+			http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+			return
+		}
+		if len(req.Agent) > maxAgentLen {
+			http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+			return
+		}
+
+		opts := SendOptions{ClientRef: req.ClientRef, Agent: req.Agent, Signature: req.Signature, APIKey: usageSubject(r), QuotedMessageID: req.QuotedMessageID}
+		success, message, messageID, code := sendWhatsAppMessage(client, req.Recipient, req.Message, mediaPath, opts, messageStore)
+		if success {
+			usageMeter.RecordSend(r, req.Recipient, mediaPath)
+		}
+		response := SendMessageResponse{
+			Success:   success,
+			Message:   message,
+			MessageID: messageID,
+			ClientRef: req.ClientRef,
+			Agent:     req.Agent,
+		}.withError(code)
+
+		status = http.StatusOK
+		if !success {
+			status = http.StatusInternalServerError
+		} else if waitFor != "" {
+			status = awaitSendState(&response, waitFor, waitTimeout)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	})))))
+}
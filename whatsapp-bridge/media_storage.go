@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaStorage keeps a copy of downloaded media in a Supabase Storage bucket, so /media/
+// can hand out signed URLs and large transfers never go through the bridge
+type MediaStorage struct {
+	baseURL   string
+	key       string
+	bucket    string
+	expiresIn int
+	client    *http.Client
+}
+
+// storageRemoveBatch is the most objects the Storage API deletes in one request
+const storageRemoveBatch = 1000
+
+// mediaStorage is nil unless SUPABASE_STORAGE_BUCKET is set
+var mediaStorage *MediaStorage
+
+// NewMediaStorageFromEnv reads the SUPABASE_STORAGE_* settings; it returns nil when no bucket is configured
+func NewMediaStorageFromEnv() (*MediaStorage, error) {
+	bucket := os.Getenv("SUPABASE_STORAGE_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	baseURL := strings.TrimSuffix(os.Getenv("SUPABASE_URL"), "/")
+	key := os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
+	if baseURL == "" || key == "" {
+		return nil, fmt.Errorf("SUPABASE_STORAGE_BUCKET needs SUPABASE_URL and SUPABASE_SERVICE_ROLE_KEY")
+	}
+	expiresIn := getEnvInt("SUPABASE_SIGNED_URL_SECONDS", 300)
+	if expiresIn < 1 {
+		return nil, fmt.Errorf("SUPABASE_SIGNED_URL_SECONDS must be positive")
+	}
+	return &MediaStorage{
+		baseURL:   baseURL,
+		key:       key,
+		bucket:    bucket,
+		expiresIn: expiresIn,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// mediaObjectPath returns the bucket path of a message's media, laid out like the data directory
+func mediaObjectPath(chatJID, filename string) string {
+	return strings.ReplaceAll(chatJID, ":", "_") + "/" + filepath.Base(filename)
+}
+
+// objectURL returns a Storage API URL for an object path
+func (s *MediaStorage) objectURL(prefix, object string) string {
+	segments := strings.Split(object, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("%s/storage/v1/object/%s%s/%s", s.baseURL, prefix, url.PathEscape(s.bucket), strings.Join(segments, "/"))
+}
+
+// do sends a Storage API request and decodes a JSON response into out, if given
+func (s *MediaStorage) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+s.key)
+	req.Header.Set("apikey", s.key)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("storage returned status %d: %s", resp.StatusCode, apiErr.Message)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// Upload streams a local file to the bucket, replacing any object at the same path
+func (s *MediaStorage) Upload(localPath, object string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.objectURL("", object), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	contentType := mime.TypeByExtension(filepath.Ext(object))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("x-upsert", "true")
+	return s.do(req, nil)
+}
+
+// SignedURL returns a URL that serves an object without credentials until it expires
+func (s *MediaStorage) SignedURL(object string) (string, time.Time, error) {
+	body, _ := json.Marshal(map[string]int{"expiresIn": s.expiresIn})
+	req, err := http.NewRequest(http.MethodPost, s.objectURL("sign/", object), bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	expiresAt := time.Now().Add(time.Duration(s.expiresIn) * time.Second)
+	var signed struct {
+		SignedURL string `json:"signedURL"`
+	}
+	if err := s.do(req, &signed); err != nil {
+		return "", time.Time{}, err
+	}
+	if signed.SignedURL == "" {
+		return "", time.Time{}, fmt.Errorf("storage returned no signed URL")
+	}
+	// The signed URL is relative to the Storage API
+	return s.baseURL + "/storage/v1" + signed.SignedURL, expiresAt, nil
+}
+
+// Remove deletes objects from the bucket; objects that don't exist are ignored
+func (s *MediaStorage) Remove(objects ...string) error {
+	if s == nil {
+		return nil
+	}
+	for start := 0; start < len(objects); start += storageRemoveBatch {
+		end := min(start+storageRemoveBatch, len(objects))
+		body, _ := json.Marshal(map[string][]string{"prefixes": objects[start:end]})
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/storage/v1/object/%s", s.baseURL, url.PathEscape(s.bucket)), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := s.do(req, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store uploads a downloaded media file to the bucket and records its object path
+func (s *MediaStorage) Store(messageStore *MessageStore, messageID, chatJID, localPath, filename string) (string, error) {
+	object := mediaObjectPath(chatJID, filename)
+	if err := s.Upload(localPath, object); err != nil {
+		return "", fmt.Errorf("failed to upload media to storage: %v", err)
+	}
+	if err := messageStore.SetStoragePath(messageID, chatJID, object); err != nil {
+		return "", fmt.Errorf("failed to record uploaded media: %v", err)
+	}
+	return object, nil
+}
+
+// GetStoragePath returns the bucket path a message's media was uploaded to, if any, and its filename
+func (store *MessageStore) GetStoragePath(id, chatJID string) (string, string, error) {
+	query := "SELECT COALESCE(storage_path, ''), COALESCE(filename, '') FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT COALESCE(storage_path, ''), COALESCE(filename, '') FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	var object, filename string
+	err := store.db.QueryRow(query, id, chatJID).Scan(&object, &filename)
+	return object, filename, err
+}
+
+// SetStoragePath records where a message's media was uploaded
+func (store *MessageStore) SetStoragePath(id, chatJID, object string) error {
+	query := "UPDATE messages SET storage_path = ? WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "UPDATE messages SET storage_path = $1 WHERE id = $2 AND chat_jid = $3"
+	}
+	_, err := store.db.Exec(query, object, id, chatJID)
+	return err
+}
+
+// serveSignedMedia answers /media/ with a redirect to a signed URL of an object in the bucket,
+// or with the URL as JSON for ?redirect=false. It reports false if no URL could be signed,
+// so the caller can stream the media from local disk instead.
+func serveSignedMedia(w http.ResponseWriter, r *http.Request, chatJID, object, filename string) bool {
+	signedURL, expiresAt, err := mediaStorage.SignedURL(object)
+	if err != nil {
+		fmt.Printf("Failed to sign media URL for %s, serving it from local disk: %v\n", object, err)
+		return false
+	}
+
+	// The transfer happens between the client and storage, so the whole file counts
+	if info, err := os.Stat(mediaFilePath(chatJID, filename)); err == nil {
+		usageMeter.Record(r, UsageMediaDownloadBytes, info.Size())
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("redirect") == "false" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"url":        signedURL,
+			"filename":   filename,
+			"expires_at": expiresAt.UTC(),
+		})
+		return true
+	}
+	http.Redirect(w, r, signedURL, http.StatusFound)
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// uploadMediaStream encrypts and uploads media from a reader without loading it into memory.
+// whatsmeow needs a seekable scratch file for the encrypted copy, so one is created in the temp directory.
+func uploadMediaStream(client *whatsmeow.Client, r io.Reader, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	tempFile, err := os.CreateTemp("", "whatsapp-upload-*")
+	if err != nil {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("failed to create temporary upload file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	return client.UploadReader(context.Background(), r, tempFile, mediaType)
+}
+
+// serveMediaFile streams a downloaded media file to the HTTP client, with support for range requests
+func serveMediaFile(w http.ResponseWriter, r *http.Request, path, filename string) {
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Media file not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Failed to read media file", http.StatusInternalServerError)
+		return
+	}
+
+	// Let ServeContent sniff the content type if the extension is unknown
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	// Videos and documents can take longer to reach slow clients than the write timeout allows
+	clearDeadlines(w)
+
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Chat types messages are priced by
+const (
+	CostChatDirect     = "direct"
+	CostChatGroup      = "group"
+	CostChatBroadcast  = "broadcast"
+	CostChatNewsletter = "newsletter"
+)
+
+// costChatTypes lists the chat types a price can be set for
+var costChatTypes = []string{CostChatDirect, CostChatGroup, CostChatBroadcast, CostChatNewsletter}
+
+// costMicros is the unit costs are stored in: millionths of the currency, so sums don't drift
+const costMicros = 1000000
+
+// callingCodePattern matches the country calling codes prices are keyed by
+var callingCodePattern = regexp.MustCompile(`^[1-9][0-9]{0,2}$`)
+
+// MessageCosts is the MESSAGE_COSTS_FILE: what a message would cost with a paid provider.
+// A direct chat is priced by the calling code of the number, then by chat type, then the default.
+type MessageCosts struct {
+	Currency  string             `json:"currency"`
+	Default   float64            `json:"default"`
+	ChatTypes map[string]float64 `json:"chat_types"`
+	Countries map[string]float64 `json:"countries"`
+}
+
+// UsageCost is the simulated cost of the messages a key sent in a month
+type UsageCost struct {
+	Currency  string     `json:"currency"`
+	Total     float64    `json:"total"`
+	Breakdown []CostLine `json:"breakdown"`
+}
+
+// CostLine is the cost of the messages sent to one chat type and country
+type CostLine struct {
+	ChatType string  `json:"chat_type"`
+	Country  string  `json:"country,omitempty"`
+	Messages int64   `json:"messages"`
+	Cost     float64 `json:"cost"`
+}
+
+// messageCosts is nil unless MESSAGE_COSTS_FILE is set
+var messageCosts *MessageCosts
+
+// LoadMessageCostsFromEnv reads MESSAGE_COSTS_FILE, returning nil when it isn't set
+func LoadMessageCostsFromEnv(logger waLog.Logger) (*MessageCosts, error) {
+	path := os.Getenv("MESSAGE_COSTS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message costs: %v", err)
+	}
+	costs := &MessageCosts{}
+	if err := json.Unmarshal(data, costs); err != nil {
+		return nil, fmt.Errorf("invalid message costs in %s: %v", path, err)
+	}
+	if costs.Currency == "" {
+		costs.Currency = "USD"
+	}
+	if costs.Default < 0 {
+		return nil, fmt.Errorf("default message cost must not be negative")
+	}
+	for chatType, price := range costs.ChatTypes {
+		if !containsString(costChatTypes, chatType) {
+			return nil, fmt.Errorf("unknown chat type %q in %s (expected %s)", chatType, path, strings.Join(costChatTypes, ", "))
+		}
+		if price < 0 {
+			return nil, fmt.Errorf("cost for %s chats must not be negative", chatType)
+		}
+	}
+	for code, price := range costs.Countries {
+		if !callingCodePattern.MatchString(code) {
+			return nil, fmt.Errorf("country %q in %s must be a calling code such as 44", code, path)
+		}
+		if price < 0 {
+			return nil, fmt.Errorf("cost for country %s must not be negative", code)
+		}
+	}
+	logger.Infof("Simulating message costs in %s for %d countries", costs.Currency, len(costs.Countries))
+	return costs, nil
+}
+
+// costChatType returns the chat type of a recipient
+func costChatType(jid types.JID) string {
+	switch jid.Server {
+	case types.GroupServer:
+		return CostChatGroup
+	case types.BroadcastServer:
+		return CostChatBroadcast
+	case types.NewsletterServer:
+		return CostChatNewsletter
+	}
+	return CostChatDirect
+}
+
+// callingCode returns the country calling code of a phone number that has a price
+func (c *MessageCosts) callingCode(phone string) string {
+	// Calling codes are prefix-free, so the first match is the only one
+	for length := 1; length <= 3 && length <= len(phone); length++ {
+		if _, ok := c.Countries[phone[:length]]; ok {
+			return phone[:length]
+		}
+	}
+	return ""
+}
+
+// Price returns the chat type, the priced country (empty if none) and the cost in micros of a
+// message to a recipient
+func (c *MessageCosts) Price(jid types.JID) (string, string, int64) {
+	chatType := costChatType(jid)
+	price := c.Default
+	country := ""
+	if chatType == CostChatDirect && jid.Server == types.DefaultUserServer {
+		country = c.callingCode(jid.User)
+	}
+	if country != "" {
+		price = c.Countries[country]
+	} else if typePrice, ok := c.ChatTypes[chatType]; ok {
+		price = typePrice
+	}
+	return chatType, country, int64(math.Round(price * costMicros))
+}
+
+// RecordCost adds the simulated cost of a message to the caller's usage for the current month
+func (m *UsageMeter) RecordCost(r *http.Request, recipient string) {
+	if m == nil || messageCosts == nil {
+		return
+	}
+	jid, err := parseRecipient(recipient)
+	if err != nil {
+		return
+	}
+	chatType, country, cost := messageCosts.Price(jid)
+	if err := m.messageStore.AddMessageCost(usageSubject(r), usagePeriod(time.Now()), chatType, country, cost); err != nil {
+		m.logger.Warnf("Failed to record message cost: %v", err)
+	}
+}
+
+// AddMessageCost counts a message and its cost in micros
+func (store *MessageStore) AddMessageCost(subject, period, chatType, country string, cost int64) error {
+	query := `INSERT INTO message_costs (subject, period, chat_type, country, messages, cost_micros) VALUES (?, ?, ?, ?, 1, ?)
+		ON CONFLICT (subject, period, chat_type, country) DO UPDATE SET messages = message_costs.messages + 1,
+		cost_micros = message_costs.cost_micros + excluded.cost_micros`
+	if store.isPostgres {
+		query = `INSERT INTO message_costs (subject, period, chat_type, country, messages, cost_micros) VALUES ($1, $2, $3, $4, 1, $5)
+		ON CONFLICT (subject, period, chat_type, country) DO UPDATE SET messages = message_costs.messages + 1,
+		cost_micros = message_costs.cost_micros + EXCLUDED.cost_micros`
+	}
+	_, err := store.db.Exec(query, subject, period, chatType, country, cost)
+	return err
+}
+
+// GetMessageCosts returns the simulated costs of a subject for a month, by chat type and country
+func (store *MessageStore) GetMessageCosts(subject, period, currency string) (*UsageCost, error) {
+	query := "SELECT chat_type, country, messages, cost_micros FROM message_costs WHERE subject = ? AND period = ?"
+	if store.isPostgres {
+		query = "SELECT chat_type, country, messages, cost_micros FROM message_costs WHERE subject = $1 AND period = $2"
+	}
+	rows, err := store.db.Query(query, subject, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cost := &UsageCost{Currency: currency, Breakdown: []CostLine{}}
+	var total int64
+	for rows.Next() {
+		var line CostLine
+		var micros int64
+		if err := rows.Scan(&line.ChatType, &line.Country, &line.Messages, &micros); err != nil {
+			return nil, err
+		}
+		line.Cost = float64(micros) / costMicros
+		total += micros
+		cost.Breakdown = append(cost.Breakdown, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	cost.Total = float64(total) / costMicros
+
+	// Most expensive first, which is what a budget review looks at
+	sort.Slice(cost.Breakdown, func(i, j int) bool {
+		a, b := cost.Breakdown[i], cost.Breakdown[j]
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		if a.ChatType != b.ChatType {
+			return a.ChatType < b.ChatType
+		}
+		return a.Country < b.Country
+	})
+	return cost, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exportBatchSize is how many messages are read per query while streaming an export.
+// Short queries keep the database free for incoming messages while a slow client downloads.
+const exportBatchSize = 500
+
+// ExportedMessage is one line of a JSON Lines export
+type ExportedMessage struct {
+	ID          string    `json:"id"`
+	ChatJID     string    `json:"chat_jid"`
+	Sender      string    `json:"sender"`
+	Content     string    `json:"content"`
+	Timestamp   time.Time `json:"timestamp"`
+	IsFromMe    bool      `json:"is_from_me"`
+	MediaType   string    `json:"media_type,omitempty"`
+	Filename    string    `json:"filename,omitempty"`
+	Agent       string    `json:"agent,omitempty"`
+	ReplyTo     string    `json:"reply_to,omitempty"`
+	SystemEvent string    `json:"system_event,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	// Reactions maps each emoji to the number of people who reacted with it
+	Reactions  map[string]int `json:"reactions,omitempty"`
+	MyReaction string         `json:"my_reaction,omitempty"`
+}
+
+// exportedMessageColumns are read by queryExportedMessages, in order
+const exportedMessageColumns = "id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, agent, reply_to, system_event, status"
+
+// exportCursor is the position after the last exported message, in (timestamp, chat_jid, id) order
+type exportCursor struct {
+	timestamp time.Time
+	chatJID   string
+	id        string
+}
+
+// ExportMessagesBatch returns up to limit messages after the cursor, oldest first, sent before until
+// when it is set. A nil cursor starts at since.
+func (store *MessageStore) ExportMessagesBatch(cursor *exportCursor, since, until time.Time, limit int) ([]ExportedMessage, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	if cursor != nil {
+		// Row value comparisons aren't available in older SQLite, so spell out the tie-breaks
+		ts := cursor.timestamp
+		conditions = append(conditions, "(timestamp > "+arg(ts)+
+			" OR (timestamp = "+arg(ts)+" AND (chat_jid > "+arg(cursor.chatJID)+
+			" OR (chat_jid = "+arg(cursor.chatJID)+" AND id > "+arg(cursor.id)+"))))")
+	} else if !since.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(since.UTC()))
+	}
+	if !until.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(until.UTC()))
+	}
+
+	query := "SELECT " + exportedMessageColumns + " FROM messages"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp, chat_jid, id LIMIT " + arg(limit)
+	messages, err := store.queryExportedMessages(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return messages, store.attachExportedReactions(messages)
+}
+
+// queryExportedMessages reads ExportedMessages selected with exportedMessageColumns
+func (store *MessageStore) queryExportedMessages(query string, args ...interface{}) ([]ExportedMessage, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ExportedMessage
+	for rows.Next() {
+		var msg ExportedMessage
+		var sender, content, mediaType, filename, agent, replyTo, systemEvent, status sql.NullString
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &sender, &content, &msg.Timestamp, &msg.IsFromMe, &mediaType, &filename, &agent, &replyTo, &systemEvent, &status); err != nil {
+			return nil, err
+		}
+		msg.Sender = sender.String
+		msg.Content = content.String
+		msg.MediaType = mediaType.String
+		msg.Filename = filename.String
+		msg.Agent = agent.String
+		msg.ReplyTo = replyTo.String
+		msg.SystemEvent = systemEvent.String
+		msg.Status = status.String
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// registerMessageExportRoutes registers /api/v1/export/all?format=jsonl&since=...&until=...
+func registerMessageExportRoutes(messageStore *MessageStore) {
+	handleAPI("/export/all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "jsonl"
+		}
+		if format != "jsonl" {
+			http.Error(w, fmt.Sprintf("Unsupported export format: %s", format), http.StatusBadRequest)
+			return
+		}
+
+		var since, until time.Time
+		var err error
+		if value := r.URL.Query().Get("since"); value != "" {
+			if since, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid since parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("until"); value != "" {
+			if until, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid until parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Read the first batch before answering, so a database failure is still a proper error
+		batch, err := messageStore.ExportMessagesBatch(nil, since, until, exportBatchSize)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to export messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		filename := "messages-" + time.Now().UTC().Format("20060102-150405") + ".jsonl"
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no")
+		// A full export can take longer than the write timeout on slow links
+		clearDeadlines(w)
+		w.WriteHeader(http.StatusOK)
+
+		// Each batch is written and flushed before the next is read, so a slow reader
+		// holds back the queries instead of the bridge buffering the export in memory
+		controller := http.NewResponseController(w)
+		buffered := bufio.NewWriterSize(w, 64<<10)
+		encoder := json.NewEncoder(buffered)
+		exported := 0
+		for len(batch) > 0 {
+			for _, msg := range batch {
+				if err := encoder.Encode(msg); err != nil {
+					return
+				}
+			}
+			exported += len(batch)
+			if err := buffered.Flush(); err != nil {
+				return
+			}
+			controller.Flush()
+			if len(batch) < exportBatchSize || r.Context().Err() != nil {
+				break
+			}
+
+			last := batch[len(batch)-1]
+			batch, err = messageStore.ExportMessagesBatch(&exportCursor{timestamp: last.Timestamp, chatJID: last.ChatJID, id: last.ID}, since, until, exportBatchSize)
+			if err != nil {
+				// The status is already sent; the client sees a stream that ends early
+				fmt.Printf("Message export stopped after %d messages: %v\n", exported, err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Statuses of stored messages. Inbound messages stay received; outbound ones move forward as receipts arrive.
+const (
+	MessageStatusReceived  = "received"
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+	MessageStatusPlayed    = "played"
+)
+
+// messageStatusPredecessors lists the statuses a receipt may move a message from, so a late
+// delivery receipt never turns a read message back into a delivered one
+var messageStatusPredecessors = map[string][]string{
+	MessageStatusDelivered: {MessageStatusSent},
+	MessageStatusRead:      {MessageStatusSent, MessageStatusDelivered},
+	MessageStatusPlayed:    {MessageStatusSent, MessageStatusDelivered, MessageStatusRead},
+}
+
+// receiptStatuses maps the receipts that change a message's status to the status they give
+var receiptStatuses = map[types.ReceiptType]string{
+	types.ReceiptTypeDelivered: MessageStatusDelivered,
+	types.ReceiptTypeRead:      MessageStatusRead,
+	types.ReceiptTypePlayed:    MessageStatusPlayed,
+}
+
+// AdvanceMessageStatus moves an outbound message to a status, unless it is already past it, and
+// reports whether it moved
+func (store *MessageStore) AdvanceMessageStatus(id, chatJID, status string, at time.Time) (bool, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	query := "UPDATE messages SET status = " + arg(status) + ", status_at = " + arg(at.UTC()) +
+		" WHERE id = " + arg(id) + " AND chat_jid = " + arg(chatJID) + " AND is_from_me AND status IN ("
+	for i, previous := range messageStatusPredecessors[status] {
+		if i > 0 {
+			query += ", "
+		}
+		query += arg(previous)
+	}
+	query += ")"
+
+	var advanced int64
+	err := store.captureMessage(id, chatJID, func() error {
+		result, err := store.db.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		advanced, _ = result.RowsAffected()
+		return nil
+	})
+	return advanced > 0, err
+}
+
+// recordReceiptStatus stores the receipt for each message it covers and moves the messages to the
+// status it gives, publishing the change. Receipts from our own devices don't count.
+func recordReceiptStatus(store *MessageStore, evt *events.Receipt, logger waLog.Logger) {
+	status, ok := receiptStatuses[evt.Type]
+	if !ok || evt.IsFromMe || store == nil {
+		return
+	}
+	chatJID := evt.Chat.String()
+	recipient := evt.Sender.ToNonAD().String()
+	for _, id := range evt.MessageIDs {
+		if err := store.AddMessageReceipt(id, chatJID, recipient, status, evt.Timestamp); err != nil {
+			logger.Warnf("Failed to store receipt for message %s: %v", id, err)
+		}
+		advanced, err := store.AdvanceMessageStatus(id, chatJID, status, evt.Timestamp)
+		if err != nil {
+			logger.Warnf("Failed to update status of message %s: %v", id, err)
+			continue
+		}
+		if !advanced {
+			continue
+		}
+		clientRef := ""
+		if matches, err := store.findMessage(id, chatJID); err == nil && len(matches) == 1 {
+			clientRef = matches[0].ClientRef
+		}
+		publishMessageStatus(store.account, chatJID, id, status, recipient, clientRef, evt.Timestamp)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTemplateBodyLen is the longest text message WhatsApp accepts, and so the longest template body
+const maxTemplateBodyLen = 65536
+
+// defaultTemplateMaxLength is TEMPLATE_MAX_LENGTH when it's unset, in characters
+const defaultTemplateMaxLength = 4096
+
+// maxTemplateSamples bounds the sample variables stored with a template
+const maxTemplateSamples = 100
+
+// maxTemplateVariants bounds the language variants of a template
+const maxTemplateVariants = 50
+
+// Severities of template lint issues; errors stop a template from being sent
+const (
+	TemplateIssueError   = "error"
+	TemplateIssueWarning = "warning"
+)
+
+// messageTemplateColumns are the columns scanMessageTemplate reads, in order
+const messageTemplateColumns = `id, name, body, COALESCE(samples, ''), COALESCE(language, ''), COALESCE(variants, ''), created_at, updated_at`
+
+// MessageTemplate is reusable message text with {{name}} placeholders, filled in when it's sent
+type MessageTemplate struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Body string `json:"body"`
+	// Language is the language of Body, e.g. en
+	Language string `json:"language,omitempty"`
+	// Variants are the body in other languages by language tag; a recipient gets the one of their
+	// language attribute, and Body when there is none
+	Variants map[string]string `json:"variants,omitempty"`
+	// Samples are example values of the placeholders, used by previews
+	Samples   map[string]string `json:"samples,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// TemplatePreviewRequest is the optional body of POST /api/v1/templates/{id}/preview
+type TemplatePreviewRequest struct {
+	// Recipient previews the template for a contact, filling placeholders from their attributes and
+	// picking the variant of their language; a language variable picks another
+	Recipient string `json:"recipient,omitempty"`
+	// Variables fill placeholders, over the template's samples and the recipient's attributes
+	Variables map[string]string `json:"variables,omitempty"`
+	// Agent and Signature render the agent signature as a send would
+	Agent     string `json:"agent,omitempty"`
+	Signature *bool  `json:"signature,omitempty"`
+}
+
+// TemplateIssue is something a template check found
+type TemplateIssue struct {
+	Severity    string `json:"severity"`
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Placeholder string `json:"placeholder,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// TemplatePreview is a template rendered with a set of variables, and what's wrong with the result
+type TemplatePreview struct {
+	TemplateID string `json:"template_id"`
+	// Language is the language of the body that was rendered, empty for a default body without one
+	Language string `json:"language,omitempty"`
+	// Text is exactly what would be sent, agent signature included
+	Text string `json:"text"`
+	// Length counts the characters of Text, against MaxLength
+	Length    int `json:"length"`
+	MaxLength int `json:"max_length"`
+	// Placeholders are the variables the template uses; Undefined those without a value and
+	// Unused the values no placeholder uses
+	Placeholders []string        `json:"placeholders"`
+	Undefined    []string        `json:"undefined"`
+	Unused       []string        `json:"unused"`
+	Issues       []TemplateIssue `json:"issues"`
+	// OK is false when any issue is an error
+	OK bool `json:"ok"`
+}
+
+// validate checks a template from an API request, and normalizes its language tags like contact attributes
+func (template *MessageTemplate) validate() error {
+	if template.Name == "" || len(template.Name) > 200 {
+		return fmt.Errorf("name is required and must be at most 200 characters")
+	}
+	if strings.TrimSpace(template.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	if utf8.RuneCountInString(template.Body) > maxTemplateBodyLen {
+		return fmt.Errorf("body must be at most %d characters", maxTemplateBodyLen)
+	}
+	if len(template.Samples) > maxTemplateSamples {
+		return fmt.Errorf("a template can have at most %d samples", maxTemplateSamples)
+	}
+
+	template.Language = strings.ReplaceAll(strings.TrimSpace(template.Language), "_", "-")
+	if template.Language != "" && !contactLanguagePattern.MatchString(template.Language) {
+		return fmt.Errorf("invalid language %q; use a language tag such as en or pt-BR", template.Language)
+	}
+	if len(template.Variants) > maxTemplateVariants {
+		return fmt.Errorf("a template can have at most %d variants", maxTemplateVariants)
+	}
+	variants := make(map[string]string, len(template.Variants))
+	for language, body := range template.Variants {
+		tag := strings.ReplaceAll(strings.TrimSpace(language), "_", "-")
+		if !contactLanguagePattern.MatchString(tag) {
+			return fmt.Errorf("invalid variant language %q; use a language tag such as en or pt-BR", language)
+		}
+		if strings.EqualFold(tag, template.Language) {
+			return fmt.Errorf("variant %s is the template's own language; put its text in body", tag)
+		}
+		for other := range variants {
+			if strings.EqualFold(other, tag) {
+				return fmt.Errorf("variant %s is given twice", tag)
+			}
+		}
+		if strings.TrimSpace(body) == "" {
+			return fmt.Errorf("variant %s has no body", tag)
+		}
+		if utf8.RuneCountInString(body) > maxTemplateBodyLen {
+			return fmt.Errorf("variant %s must be at most %d characters", tag, maxTemplateBodyLen)
+		}
+		variants[tag] = body
+	}
+	template.Variants = variants
+	return nil
+}
+
+// variant picks the body for a recipient's language and returns it with its language: the variant
+// of the exact tag, then of the primary language (pt for pt-BR), then one of the same primary
+// language (pt-PT for pt-BR), and otherwise Body. Tags compare case-insensitively.
+func (template *MessageTemplate) variant(language string) (string, string) {
+	language = strings.ReplaceAll(strings.TrimSpace(language), "_", "-")
+	if language == "" || strings.EqualFold(language, template.Language) {
+		return template.Body, template.Language
+	}
+	primary, _, _ := strings.Cut(language, "-")
+
+	// Sorted, so the sibling picked is the same on every send
+	tags := make([]string, 0, len(template.Variants))
+	for tag := range template.Variants {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, candidate := range []func(tag string) bool{
+		func(tag string) bool { return strings.EqualFold(tag, language) },
+		func(tag string) bool { return strings.EqualFold(tag, primary) },
+		func(tag string) bool { base, _, _ := strings.Cut(tag, "-"); return strings.EqualFold(base, primary) },
+	} {
+		for _, tag := range tags {
+			if candidate(tag) {
+				return template.Variants[tag], tag
+			}
+		}
+	}
+	return template.Body, template.Language
+}
+
+// templateMaxLength returns TEMPLATE_MAX_LENGTH, the longest a rendered template may be
+func templateMaxLength() int {
+	length := getEnvInt("TEMPLATE_MAX_LENGTH", defaultTemplateMaxLength)
+	if length <= 0 || length > maxTemplateBodyLen {
+		return maxTemplateBodyLen
+	}
+	return length
+}
+
+// renderTemplate fills the placeholders of a template body and checks the result against the
+// length and URL policies. It returns the text without the agent signature, which sending adds,
+// and a preview whose Text has it.
+func renderTemplate(body string, variables map[string]string, opts SendOptions) (string, TemplatePreview) {
+	preview := TemplatePreview{
+		MaxLength:    templateMaxLength(),
+		Placeholders: []string{},
+		Undefined:    []string{},
+		Unused:       []string{},
+		Issues:       []TemplateIssue{},
+	}
+	issue := func(severity, code, message string) *TemplateIssue {
+		preview.Issues = append(preview.Issues, TemplateIssue{Severity: severity, Code: code, Message: message})
+		return &preview.Issues[len(preview.Issues)-1]
+	}
+
+	used := map[string]bool{}
+	for _, match := range flowVariablePattern.FindAllStringSubmatch(body, -1) {
+		name := match[1]
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		preview.Placeholders = append(preview.Placeholders, name)
+		if _, ok := variables[name]; !ok {
+			preview.Undefined = append(preview.Undefined, name)
+			issue(TemplateIssueError, "undefined_placeholder", fmt.Sprintf("{{%s}} has no value", name)).Placeholder = name
+		}
+	}
+	for name := range variables {
+		if !used[name] {
+			preview.Unused = append(preview.Unused, name)
+		}
+	}
+	sort.Strings(preview.Unused)
+
+	// Braces left after the placeholders are taken out are most likely a typo, e.g. {{first name}}
+	rest := flowVariablePattern.ReplaceAllString(body, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		issue(TemplateIssueWarning, "malformed_placeholder", "The template has {{ or }} that isn't a placeholder; names are letters, digits and underscores")
+	}
+
+	// Undefined placeholders stay as they are, so the preview shows where they are
+	text := flowVariablePattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		if value, ok := variables[flowVariablePattern.FindStringSubmatch(placeholder)[1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+	preview.Text = withAgentSignature(text, opts)
+	preview.Length = utf8.RuneCountInString(preview.Text)
+
+	if strings.TrimSpace(text) == "" {
+		issue(TemplateIssueError, "empty", "The rendered message is empty")
+	}
+	if preview.Length > preview.MaxLength {
+		issue(TemplateIssueError, "too_long", fmt.Sprintf("The message is %d characters, over the limit of %d", preview.Length, preview.MaxLength))
+	}
+
+	allowed := splitEnvList("TEMPLATE_URL_DOMAINS")
+	for _, match := range linkPattern.FindAllStringSubmatch(text, -1) {
+		link, host := match[0], strings.ToLower(match[1])
+		lower := strings.ToLower(link)
+		// Anything with a dot is a domain to the pattern, so only real links are checked
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "www.") {
+			continue
+		}
+		if len(allowed) > 0 && !domainMatches(host, allowed) {
+			issue(TemplateIssueError, "url_not_allowed", fmt.Sprintf("%s is not one of the domains in TEMPLATE_URL_DOMAINS", host)).URL = link
+		} else if strings.HasPrefix(lower, "http://") {
+			issue(TemplateIssueWarning, "insecure_url", "The link doesn't use https").URL = link
+		}
+	}
+
+	preview.OK = true
+	for _, found := range preview.Issues {
+		if found.Severity == TemplateIssueError {
+			preview.OK = false
+		}
+	}
+	return text, preview
+}
+
+// templateError sums up the errors of a preview, for a send that was refused
+func (preview TemplatePreview) templateError() error {
+	var messages []string
+	for _, found := range preview.Issues {
+		if found.Severity == TemplateIssueError {
+			messages = append(messages, found.Message)
+		}
+	}
+	name := preview.TemplateID
+	if preview.Language != "" {
+		name += " (" + preview.Language + ")"
+	}
+	return fmt.Errorf("template %s can't be sent: %s", name, strings.Join(messages, "; "))
+}
+
+// render fills the variant of the language variable in with variables
+func (template *MessageTemplate) render(variables map[string]string, opts SendOptions) (string, TemplatePreview) {
+	body, language := template.variant(variables["language"])
+	text, preview := renderTemplate(body, variables, opts)
+	preview.TemplateID, preview.Language = template.ID, language
+	return text, preview
+}
+
+// fill renders a template with variables for sending, in the variant of their language; a template
+// with lint errors is refused
+func (template *MessageTemplate) fill(variables map[string]string, opts SendOptions) (string, error) {
+	text, preview := template.render(variables, opts)
+	if !preview.OK {
+		return "", preview.templateError()
+	}
+	return text, nil
+}
+
+// FillTemplate renders a stored template for a recipient, with the variables over their contact
+// attributes, in the variant of their language. Samples are left out.
+func (store *MessageStore) FillTemplate(id, recipient string, variables map[string]string, opts SendOptions) (string, error) {
+	template, err := store.GetMessageTemplate(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get template: %v", err)
+	}
+	if template == nil {
+		return "", fmt.Errorf("template %s not found", id)
+	}
+	if variables, err = store.TemplateVariables(recipient, variables); err != nil {
+		return "", err
+	}
+	return template.fill(variables, opts)
+}
+
+// scanMessageTemplate reads a row of messageTemplateColumns
+func scanMessageTemplate(row interface{ Scan(...interface{}) error }) (*MessageTemplate, error) {
+	var template MessageTemplate
+	var samples, variants string
+	if err := row.Scan(&template.ID, &template.Name, &template.Body, &samples, &template.Language, &variants,
+		&template.CreatedAt, &template.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if samples != "" {
+		if err := json.Unmarshal([]byte(samples), &template.Samples); err != nil {
+			return nil, fmt.Errorf("invalid samples of template %s: %v", template.ID, err)
+		}
+	}
+	if variants != "" {
+		if err := json.Unmarshal([]byte(variants), &template.Variants); err != nil {
+			return nil, fmt.Errorf("invalid variants of template %s: %v", template.ID, err)
+		}
+	}
+	return &template, nil
+}
+
+// SaveMessageTemplate inserts or replaces a template
+func (store *MessageStore) SaveMessageTemplate(template *MessageTemplate) error {
+	var samples, variants interface{}
+	if len(template.Samples) > 0 {
+		encoded, _ := json.Marshal(template.Samples)
+		samples = string(encoded)
+	}
+	if len(template.Variants) > 0 {
+		encoded, _ := json.Marshal(template.Variants)
+		variants = string(encoded)
+	}
+
+	query := `INSERT INTO message_templates (id, name, body, samples, language, variants, created_at, updated_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, body = excluded.body, samples = excluded.samples,
+		language = excluded.language, variants = excluded.variants, updated_at = excluded.updated_at`
+	if store.isPostgres {
+		query = `INSERT INTO message_templates (id, name, body, samples, language, variants, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET name = $2, body = $3, samples = $4, language = NULLIF($5, ''), variants = $6, updated_at = $8`
+	}
+	if _, err := store.db.Exec(query, template.ID, template.Name, template.Body, samples, template.Language, variants,
+		template.CreatedAt, template.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save template: %v", err)
+	}
+	return nil
+}
+
+// GetMessageTemplate returns a template, or nil if there is none with the ID
+func (store *MessageStore) GetMessageTemplate(id string) (*MessageTemplate, error) {
+	query := "SELECT " + messageTemplateColumns + " FROM message_templates WHERE id = ?"
+	if store.isPostgres {
+		query = "SELECT " + messageTemplateColumns + " FROM message_templates WHERE id = $1"
+	}
+	template, err := scanMessageTemplate(store.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return template, err
+}
+
+// ListMessageTemplates returns all templates ordered by name
+func (store *MessageStore) ListMessageTemplates() ([]MessageTemplate, error) {
+	rows, err := store.db.Query("SELECT " + messageTemplateColumns + " FROM message_templates ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []MessageTemplate{}
+	for rows.Next() {
+		template, err := scanMessageTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, rows.Err()
+}
+
+// DeleteMessageTemplate removes a template and reports whether it existed
+func (store *MessageStore) DeleteMessageTemplate(id string) (bool, error) {
+	query := "DELETE FROM message_templates WHERE id = ?"
+	if store.isPostgres {
+		query = "DELETE FROM message_templates WHERE id = $1"
+	}
+	result, err := store.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete template: %v", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// registerMessageTemplateRoutes registers /api/v1/templates and /api/v1/templates/{id}, plus /preview
+func registerMessageTemplateRoutes(messageStore *MessageStore) {
+	handleAPI("/templates", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			templates, err := messageStore.ListMessageTemplates()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list templates: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(templates)
+
+		case http.MethodPost:
+			var template MessageTemplate
+			if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if err := template.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			template.ID = newEventID()
+			template.CreatedAt = time.Now().UTC()
+			template.UpdatedAt = template.CreatedAt
+			if err := messageStore.SaveMessageTemplate(&template); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(template)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	handleAPI("/templates/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/templates/"), "/")
+
+		template, err := messageStore.GetMessageTemplate(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get template: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if template == nil {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+
+		case action == "" && r.Method == http.MethodPut:
+			var update MessageTemplate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			template.Name, template.Body, template.Samples = update.Name, update.Body, update.Samples
+			template.Language, template.Variants = update.Language, update.Variants
+			if err := template.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			template.UpdatedAt = time.Now().UTC()
+			if err := messageStore.SaveMessageTemplate(template); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case action == "" && r.Method == http.MethodDelete:
+			if _, err := messageStore.DeleteMessageTemplate(id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		case action == "preview" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+			var req TemplatePreviewRequest
+			if r.Method == http.MethodPost && r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "Invalid request format", http.StatusBadRequest)
+					return
+				}
+			}
+			if len(req.Agent) > maxAgentLen {
+				http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+				return
+			}
+			variables := map[string]string{}
+			for name, value := range template.Samples {
+				variables[name] = value
+			}
+			if req.Recipient != "" {
+				if _, err := contactJID(req.Recipient); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			contact, err := messageStore.TemplateVariables(req.Recipient, req.Variables)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for name, value := range contact {
+				variables[name] = value
+			}
+			_, preview := template.render(variables, SendOptions{Agent: req.Agent, Signature: req.Signature})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(preview)
+			return
+
+		case action != "" && action != "preview":
+			http.NotFound(w, r)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(template)
+	})
+}
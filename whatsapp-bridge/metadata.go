@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Limits on metadata so the tables stay a place for IDs and short notes
+const (
+	maxMetadataKeys     = 100
+	maxMetadataKeyLen   = 100
+	maxMetadataValueLen = 1000
+)
+
+// ChatMetadata is free-text notes and key/value metadata attached to a chat or contact,
+// e.g. the ID of the matching CRM record. A contact shares its JID with their personal chat.
+type ChatMetadata struct {
+	JID       string            `json:"jid"`
+	Notes     string            `json:"notes"`
+	Metadata  map[string]string `json:"metadata"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// UpdateMetadataRequest represents the request body for PATCH; a null metadata value removes the key
+type UpdateMetadataRequest struct {
+	Notes    *string            `json:"notes"`
+	Metadata map[string]*string `json:"metadata"`
+}
+
+// validateMetadata checks metadata keys and values against the limits
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("at most %d metadata keys are allowed", maxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLen {
+			return fmt.Errorf("metadata keys must be 1 to %d characters", maxMetadataKeyLen)
+		}
+		if len(value) > maxMetadataValueLen {
+			return fmt.Errorf("metadata value of %q is longer than %d characters", key, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+// GetChatMetadata returns the notes and metadata of a chat; both are empty if none were saved
+func (store *MessageStore) GetChatMetadata(jid string) (*ChatMetadata, error) {
+	var notesQuery, metadataQuery string
+	if store.isPostgres {
+		notesQuery = "SELECT notes, updated_at FROM chat_notes WHERE chat_jid = $1"
+		metadataQuery = "SELECT key, value, updated_at FROM chat_metadata WHERE chat_jid = $1"
+	} else {
+		notesQuery = "SELECT notes, updated_at FROM chat_notes WHERE chat_jid = ?"
+		metadataQuery = "SELECT key, value, updated_at FROM chat_metadata WHERE chat_jid = ?"
+	}
+
+	result := &ChatMetadata{JID: jid, Metadata: map[string]string{}}
+
+	var notesUpdated time.Time
+	err := store.db.QueryRow(notesQuery, jid).Scan(&result.Notes, &notesUpdated)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		result.UpdatedAt = &notesUpdated
+	}
+
+	rows, err := store.db.Query(metadataQuery, jid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		var updatedAt time.Time
+		if err := rows.Scan(&key, &value, &updatedAt); err != nil {
+			return nil, err
+		}
+		result.Metadata[key] = value
+		// The latest change of notes or any key
+		if result.UpdatedAt == nil || updatedAt.After(*result.UpdatedAt) {
+			result.UpdatedAt = &updatedAt
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// UpdateChatMetadata applies notes and metadata changes in one transaction.
+// With replace set, keys not in the update are removed.
+func (store *MessageStore) UpdateChatMetadata(jid string, update UpdateMetadataRequest, replace bool) error {
+	var notesQuery, deleteNotesQuery, setQuery, deleteKeyQuery, clearQuery string
+	if store.isPostgres {
+		notesQuery = "INSERT INTO chat_notes (chat_jid, notes, updated_at) VALUES ($1, $2, $3) ON CONFLICT (chat_jid) DO UPDATE SET notes = $2, updated_at = $3"
+		deleteNotesQuery = "DELETE FROM chat_notes WHERE chat_jid = $1"
+		setQuery = "INSERT INTO chat_metadata (chat_jid, key, value, updated_at) VALUES ($1, $2, $3, $4) ON CONFLICT (chat_jid, key) DO UPDATE SET value = $3, updated_at = $4"
+		deleteKeyQuery = "DELETE FROM chat_metadata WHERE chat_jid = $1 AND key = $2"
+		clearQuery = "DELETE FROM chat_metadata WHERE chat_jid = $1"
+	} else {
+		notesQuery = "INSERT OR REPLACE INTO chat_notes (chat_jid, notes, updated_at) VALUES (?, ?, ?)"
+		deleteNotesQuery = "DELETE FROM chat_notes WHERE chat_jid = ?"
+		setQuery = "INSERT OR REPLACE INTO chat_metadata (chat_jid, key, value, updated_at) VALUES (?, ?, ?, ?)"
+		deleteKeyQuery = "DELETE FROM chat_metadata WHERE chat_jid = ? AND key = ?"
+		clearQuery = "DELETE FROM chat_metadata WHERE chat_jid = ?"
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// Empty notes are stored as no notes
+	if update.Notes != nil || replace {
+		if update.Notes == nil || *update.Notes == "" {
+			_, err = tx.Exec(deleteNotesQuery, jid)
+		} else {
+			_, err = tx.Exec(notesQuery, jid, *update.Notes, now)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save notes: %v", err)
+		}
+	}
+
+	if replace {
+		if _, err := tx.Exec(clearQuery, jid); err != nil {
+			return fmt.Errorf("failed to clear metadata: %v", err)
+		}
+	}
+
+	for key, value := range update.Metadata {
+		if value == nil {
+			_, err = tx.Exec(deleteKeyQuery, jid, key)
+		} else {
+			_, err = tx.Exec(setQuery, jid, key, *value, now)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save metadata %q: %v", key, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteChatMetadata removes all notes and metadata of a chat
+func (store *MessageStore) DeleteChatMetadata(jid string) error {
+	return store.UpdateChatMetadata(jid, UpdateMetadataRequest{}, true)
+}
+
+// FindChatsByMetadata returns the chats where a metadata key has the given value
+func (store *MessageStore) FindChatsByMetadata(key, value string) ([]string, error) {
+	var query string
+	if store.isPostgres {
+		query = "SELECT chat_jid FROM chat_metadata WHERE key = $1 AND value = $2 ORDER BY chat_jid"
+	} else {
+		query = "SELECT chat_jid FROM chat_metadata WHERE key = ? AND value = ? ORDER BY chat_jid"
+	}
+
+	rows, err := store.db.Query(query, key, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jids := []string{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// registerMetadataRoutes registers /api/v1/chats/{jid}/metadata, /api/v1/contacts/{jid}/metadata
+// and the reverse lookup /api/v1/metadata?key=&value=
+func registerMetadataRoutes(messageStore *MessageStore) {
+	handler := func(w http.ResponseWriter, r *http.Request, jid string) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the current state below
+
+		case http.MethodPut:
+			// Replace notes and metadata
+			var req ChatMetadata
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if err := validateMetadata(req.Metadata); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			update := UpdateMetadataRequest{Notes: &req.Notes, Metadata: make(map[string]*string, len(req.Metadata))}
+			for key, value := range req.Metadata {
+				value := value
+				update.Metadata[key] = &value
+			}
+			if err := messageStore.UpdateChatMetadata(jid, update, true); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save metadata: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPatch:
+			// Merge keys into the existing metadata
+			var req UpdateMetadataRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			merged, err := messageStore.GetChatMetadata(jid)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get metadata: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for key, value := range req.Metadata {
+				if value == nil {
+					delete(merged.Metadata, key)
+				} else {
+					merged.Metadata[key] = *value
+				}
+			}
+			if err := validateMetadata(merged.Metadata); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := messageStore.UpdateChatMetadata(jid, req, false); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save metadata: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodDelete:
+			if err := messageStore.DeleteChatMetadata(jid); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to delete metadata: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Every successful read or write answers with the current state
+		metadata, err := messageStore.GetChatMetadata(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get metadata: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if metadata.UpdatedAt != nil {
+			updatedAt := metadata.UpdatedAt.In(loc)
+			metadata.UpdatedAt = &updatedAt
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metadata)
+	}
+
+	registerChatRoute("metadata", handler)
+	registerContactRoute("metadata", handler)
+
+	handleAPI("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		key, value := r.URL.Query().Get("key"), r.URL.Query().Get("value")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+
+		jids, err := messageStore.FindChatsByMetadata(key, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to search metadata: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"jids": jids})
+	})
+}
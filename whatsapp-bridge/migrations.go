@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// schemaMigration is a numbered change to the message store, applied once and recorded in
+// bridge_migrations. Unlike bridgeTables and messageColumns, migrations may rewrite existing rows.
+type schemaMigration struct {
+	version    int
+	name       string
+	statements []string
+	// postgres replaces statements where the SQLite SQL isn't portable
+	postgres []string
+}
+
+// migrationLockKey is the PostgreSQL advisory lock replicas take while migrating, so only one applies each migration
+const migrationLockKey = 0x77616d6967
+
+// schemaMigrations are applied in order on startup. Append new ones; never edit or reorder applied ones.
+var schemaMigrations = []schemaMigration{
+	{
+		version: 1,
+		name:    "message status",
+		statements: []string{
+			"ALTER TABLE messages ADD COLUMN status TEXT",
+			"ALTER TABLE messages ADD COLUMN status_at TIMESTAMP",
+			// Messages stored before statuses were tracked keep the state they were stored in
+			`UPDATE messages SET status = CASE WHEN is_from_me THEN 'sent' ELSE 'received' END
+			WHERE system_event IS NULL`,
+			"CREATE INDEX IF NOT EXISTS idx_messages_status ON messages (chat_jid, status)",
+		},
+	},
+	{
+		version: 2,
+		name:    "recurring scheduled messages",
+		statements: []string{
+			"ALTER TABLE scheduled_messages ADD COLUMN series_id TEXT",
+			"CREATE INDEX IF NOT EXISTS idx_scheduled_messages_series ON scheduled_messages (series_id)",
+		},
+	},
+	{
+		version: 3,
+		name:    "campaign approval",
+		statements: []string{
+			"ALTER TABLE campaigns ADD COLUMN approval TEXT",
+			"ALTER TABLE campaigns ADD COLUMN requested_by TEXT",
+			"ALTER TABLE campaigns ADD COLUMN reviewed_by TEXT",
+			"ALTER TABLE campaigns ADD COLUMN reviewed_at TIMESTAMP",
+			"ALTER TABLE campaigns ADD COLUMN review_note TEXT",
+		},
+	},
+	{
+		version: 4,
+		name:    "campaign templates",
+		statements: []string{
+			"ALTER TABLE campaigns ADD COLUMN template_id TEXT",
+		},
+	},
+	{
+		version: 5,
+		name:    "template language variants",
+		statements: []string{
+			"ALTER TABLE message_templates ADD COLUMN language TEXT",
+			"ALTER TABLE message_templates ADD COLUMN variants TEXT",
+		},
+	},
+	{
+		version: 6,
+		name:    "bot pause timeouts",
+		statements: []string{
+			"ALTER TABLE bot_pauses ADD COLUMN resume_at TIMESTAMP",
+		},
+	},
+	{
+		version: 7,
+		name:    "outbound message API keys",
+		statements: []string{
+			"ALTER TABLE messages ADD COLUMN api_key TEXT",
+			"ALTER TABLE outbox ADD COLUMN api_key TEXT",
+		},
+	},
+	{
+		version: 8,
+		name:    "queued replies",
+		statements: []string{
+			"ALTER TABLE outbox ADD COLUMN quoted_message_id TEXT",
+		},
+	},
+	{
+		version: 9,
+		name:    "local dashboard accounts",
+		statements: []string{
+			"ALTER TABLE dashboard_users ADD COLUMN password_hash TEXT",
+			"ALTER TABLE dashboard_users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE",
+		},
+	},
+}
+
+// latestSchemaVersion is the version of the message store this build migrates to
+func latestSchemaVersion() int {
+	if len(schemaMigrations) == 0 {
+		return 0
+	}
+	return schemaMigrations[len(schemaMigrations)-1].version
+}
+
+// migrate applies the migrations the database hasn't seen yet, each in its own transaction
+func (store *MessageStore) migrate() error {
+	if _, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS bridge_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create bridge_migrations table: %v", err)
+	}
+
+	var current int
+	if err := store.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM bridge_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for _, migration := range schemaMigrations {
+		if migration.version <= current {
+			continue
+		}
+		if err := store.applyMigration(migration); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.version, migration.name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records it in one transaction, so a failure leaves neither behind
+func (store *MessageStore) applyMigration(migration schemaMigration) error {
+	statements := migration.statements
+	if store.isPostgres && migration.postgres != nil {
+		statements = migration.postgres
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if store.isPostgres {
+		// Another replica may have applied the migration while we waited for the lock
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+			return err
+		}
+		var applied bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bridge_migrations WHERE version = $1)", migration.version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	query := "INSERT INTO bridge_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO bridge_migrations (version, name, applied_at) VALUES ($1, $2, $3)"
+	}
+	if _, err := tx.Exec(query, migration.version, migration.name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
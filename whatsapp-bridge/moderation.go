@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// moderationFileName is the default moderation config inside DATA_DIR
+const moderationFileName = "moderation.json"
+
+// groupInfoTTL bounds how long admin lists are reused; group changes drop them sooner
+const groupInfoTTL = 5 * time.Minute
+
+// Actions taken on messages with banned links
+const (
+	LinkActionRemove = "remove" // delete the message and remove the sender from the group
+	LinkActionDelete = "delete" // delete the message only
+	LinkActionWarn   = "warn"   // answer with a warning
+)
+
+// Moderation actions, reported in group.moderation events and the group timeline
+const (
+	ModerationLinkRemoved = "link_removed"
+	ModerationLinkDeleted = "link_deleted"
+	ModerationLinkWarned  = "link_warned"
+	ModerationFloodWarned = "flood_warned"
+	ModerationWelcomed    = "welcomed"
+	ModerationCommand     = "command"
+)
+
+// linkPattern finds web addresses with or without a scheme; group 1 is the host
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})(?:[/:?#]\S*)?`)
+
+// FloodPolicy warns members who send too many messages in a short time
+type FloodPolicy struct {
+	MaxMessages   int    `json:"max_messages"`
+	WindowSeconds int    `json:"window_seconds"`
+	Warning       string `json:"warning,omitempty"`
+}
+
+// ModerationConfig configures the group moderation bot. It only acts in groups where the
+// account is an admin, and never on messages of other admins.
+type ModerationConfig struct {
+	// Groups limits moderation to these group JIDs; by default every group is moderated
+	Groups []string `json:"groups,omitempty"`
+
+	// BannedLinks are domains whose links, including subdomains, are not allowed. "*" bans
+	// every http(s) or www. link except those to AllowedLinks.
+	BannedLinks  []string `json:"banned_links,omitempty"`
+	AllowedLinks []string `json:"allowed_links,omitempty"`
+	LinkAction   string   `json:"link_action,omitempty"`
+	LinkWarning  string   `json:"link_warning,omitempty"`
+
+	Flood *FloodPolicy `json:"flood,omitempty"`
+
+	// Welcome is sent when members join; {{participants}} and {{group}} are replaced
+	Welcome string `json:"welcome,omitempty"`
+
+	// Commands lets group admins run !kick, !promote and !demote
+	Commands      bool   `json:"commands,omitempty"`
+	CommandPrefix string `json:"command_prefix,omitempty"`
+}
+
+// cachedGroupInfo is the participant list of a group as last fetched
+type cachedGroupInfo struct {
+	info      *types.GroupInfo
+	fetchedAt time.Time
+}
+
+// Moderator enforces the moderation config in groups
+type Moderator struct {
+	config       ModerationConfig
+	groups       map[string]bool
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+
+	mutex      sync.Mutex
+	groupInfo  map[string]cachedGroupInfo
+	recent     map[string][]time.Time
+	lastWarned map[string]time.Time
+}
+
+// groupModerator is set when a moderation config exists
+var groupModerator *Moderator
+
+// NewModeratorFromEnv loads the config from MODERATION_FILE or DATA_DIR/moderation.json.
+// It returns nil when there is no config file.
+func NewModeratorFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*Moderator, error) {
+	path := os.Getenv("MODERATION_FILE")
+	if path == "" {
+		path = dataPath(moderationFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation config: %v", err)
+	}
+
+	var config ModerationConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid moderation config in %s: %v", path, err)
+	}
+
+	switch config.LinkAction {
+	case "":
+		config.LinkAction = LinkActionRemove
+	case LinkActionRemove, LinkActionDelete, LinkActionWarn:
+	default:
+		return nil, fmt.Errorf("invalid link_action %q (expected remove, delete or warn)", config.LinkAction)
+	}
+	if config.LinkWarning == "" {
+		config.LinkWarning = "@{{sender}} links to {{domain}} are not allowed in this group."
+	}
+	if config.Flood != nil {
+		if config.Flood.MaxMessages < 2 || config.Flood.WindowSeconds < 1 {
+			return nil, fmt.Errorf("flood needs max_messages of at least 2 and window_seconds of at least 1")
+		}
+		if config.Flood.Warning == "" {
+			config.Flood.Warning = "@{{sender}} please slow down, you are sending too many messages."
+		}
+	}
+	if config.CommandPrefix == "" {
+		config.CommandPrefix = "!"
+	}
+	for i, domain := range config.BannedLinks {
+		config.BannedLinks[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+	for i, domain := range config.AllowedLinks {
+		config.AllowedLinks[i] = strings.ToLower(strings.TrimSpace(domain))
+	}
+
+	moderator := &Moderator{
+		config:       config,
+		groups:       make(map[string]bool),
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+		groupInfo:    make(map[string]cachedGroupInfo),
+		recent:       make(map[string][]time.Time),
+		lastWarned:   make(map[string]time.Time),
+	}
+	for _, group := range config.Groups {
+		if !strings.HasSuffix(group, "@"+types.GroupServer) {
+			return nil, fmt.Errorf("invalid group %q in moderation config", group)
+		}
+		moderator.groups[group] = true
+	}
+
+	logger.Infof("Loaded group moderation config from %s", path)
+	return moderator, nil
+}
+
+// active reports whether a group is moderated and the bridge may act in it
+func (m *Moderator) active(chatJID string) bool {
+	if readOnlyMode || receiveOnlyMode || sessionGuard.Current() != nil {
+		return false
+	}
+	return len(m.groups) == 0 || m.groups[chatJID]
+}
+
+// roles fetches whether the account and a member are admins of a group
+func (m *Moderator) roles(group types.JID, member string) (selfAdmin, memberAdmin bool, err error) {
+	m.mutex.Lock()
+	cached, ok := m.groupInfo[group.String()]
+	m.mutex.Unlock()
+
+	if !ok || time.Since(cached.fetchedAt) > groupInfoTTL {
+		info, err := m.client.GetGroupInfo(group)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to get group info: %v", err)
+		}
+		cached = cachedGroupInfo{info: info, fetchedAt: time.Now()}
+		m.mutex.Lock()
+		m.groupInfo[group.String()] = cached
+		m.mutex.Unlock()
+	}
+
+	// Groups may list members by phone number or by LID
+	for _, participant := range cached.info.Participants {
+		admin := participant.IsAdmin || participant.IsSuperAdmin
+		for _, user := range []string{participant.JID.User, participant.LID.User} {
+			if user == "" {
+				continue
+			}
+			if user == member {
+				memberAdmin = admin
+			}
+			if m.isSelf(user) {
+				selfAdmin = admin
+			}
+		}
+	}
+	return selfAdmin, memberAdmin, nil
+}
+
+// isSelf reports whether a phone number or LID user is the account's own
+func (m *Moderator) isSelf(user string) bool {
+	return isOwnUser(m.client, user)
+}
+
+// HandleIncoming moderates a group message. It reports whether the message was deleted,
+// in which case it is not routed any further.
+func (m *Moderator) HandleIncoming(msg IncomingMessage) bool {
+	if !msg.IsGroup || !m.active(msg.ChatJID) {
+		return false
+	}
+	if len(m.config.BannedLinks) == 0 && m.config.Flood == nil && !m.config.Commands {
+		return false
+	}
+	group, err := types.ParseJID(msg.ChatJID)
+	if err != nil {
+		return false
+	}
+
+	selfAdmin, senderAdmin, err := m.roles(group, msg.Sender)
+	if err != nil {
+		m.logger.Warnf("Moderation of %s skipped: %v", msg.ChatJID, err)
+		return false
+	}
+	if !selfAdmin {
+		return false
+	}
+
+	if senderAdmin {
+		if m.config.Commands && strings.HasPrefix(msg.Content, m.config.CommandPrefix) {
+			m.runCommand(group, msg)
+		}
+		return false
+	}
+
+	if domain := m.bannedLink(msg.Content); domain != "" {
+		return m.enforceLinkBan(group, msg, domain)
+	}
+	if m.config.Flood != nil && m.flooding(msg) {
+		m.reply(msg.ChatJID, fillModerationTemplate(m.config.Flood.Warning, map[string]string{"sender": msg.Sender}))
+		m.record(msg, ModerationFloodWarned, "", []types.JID{msg.SenderJID})
+	}
+	return false
+}
+
+// HandleOwnMessage runs commands the account owner sends to a group from their phone
+func (m *Moderator) HandleOwnMessage(msg IncomingMessage) {
+	if !m.config.Commands || !msg.IsGroup || !strings.HasPrefix(msg.Content, m.config.CommandPrefix) || !m.active(msg.ChatJID) {
+		return
+	}
+	group, err := types.ParseJID(msg.ChatJID)
+	if err != nil {
+		return
+	}
+	if selfAdmin, _, err := m.roles(group, msg.Sender); err != nil || !selfAdmin {
+		return
+	}
+	m.runCommand(group, msg)
+}
+
+// bannedLink returns the first banned domain linked in a message
+func (m *Moderator) bannedLink(content string) string {
+	if len(m.config.BannedLinks) == 0 {
+		return ""
+	}
+	for _, match := range linkPattern.FindAllStringSubmatch(content, -1) {
+		host := strings.ToLower(match[1])
+		if domainMatches(host, m.config.AllowedLinks) {
+			continue
+		}
+		for _, banned := range m.config.BannedLinks {
+			// Anything with a dot is a domain to the pattern, so "*" only counts real links
+			if banned == "*" {
+				lower := strings.ToLower(match[0])
+				if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "www.") {
+					return host
+				}
+			} else if domainMatches(host, []string{banned}) {
+				return banned
+			}
+		}
+	}
+	return ""
+}
+
+// domainMatches reports whether a host is one of the domains or a subdomain of one
+func domainMatches(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceLinkBan applies the link action to a message; it reports whether the message was deleted
+func (m *Moderator) enforceLinkBan(group types.JID, msg IncomingMessage, domain string) bool {
+	if m.config.LinkAction == LinkActionWarn {
+		m.reply(msg.ChatJID, fillModerationTemplate(m.config.LinkWarning, map[string]string{"sender": msg.Sender, "domain": domain}))
+		m.record(msg, ModerationLinkWarned, domain, []types.JID{msg.SenderJID})
+		return false
+	}
+
+	if _, err := m.client.SendMessage(context.Background(), group, m.client.BuildRevoke(group, msg.SenderJID, msg.ID)); err != nil {
+		m.logger.Warnf("Failed to delete message with banned link in %s: %v", msg.ChatJID, err)
+		return false
+	}
+	handleRevoke(m.messageStore, msg.ChatJID, msg.ID, time.Now(), m.logger)
+
+	sender := []types.JID{msg.SenderJID}
+	if m.config.LinkAction == LinkActionDelete {
+		m.record(msg, ModerationLinkDeleted, domain, sender)
+		return true
+	}
+	if _, err := m.client.UpdateGroupParticipants(group, sender, whatsmeow.ParticipantChangeRemove); err != nil {
+		m.logger.Warnf("Failed to remove %s from %s: %v", logRedactor.Phone(msg.Sender), msg.ChatJID, err)
+		m.record(msg, ModerationLinkDeleted, domain, sender)
+		return true
+	}
+	m.record(msg, ModerationLinkRemoved, domain, sender)
+	return true
+}
+
+// flooding counts a message and reports whether its sender should be warned. Message times
+// are used, so a backlog delivered at once after a reconnect isn't taken for a flood.
+func (m *Moderator) flooding(msg IncomingMessage) bool {
+	window := time.Duration(m.config.Flood.WindowSeconds) * time.Second
+	key := msg.ChatJID + "|" + msg.Sender
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	recent := m.recent[key][:0]
+	for _, at := range m.recent[key] {
+		if msg.Timestamp.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, msg.Timestamp)
+	m.recent[key] = recent
+
+	if len(recent) < m.config.Flood.MaxMessages {
+		return false
+	}
+	// One warning per window is enough
+	if last, ok := m.lastWarned[key]; ok && msg.Timestamp.Sub(last) < window {
+		return false
+	}
+	m.lastWarned[key] = msg.Timestamp
+	return true
+}
+
+// runCommand runs an admin command such as "!kick 1234567890"
+func (m *Moderator) runCommand(group types.JID, msg IncomingMessage) {
+	fields := strings.Fields(strings.TrimPrefix(msg.Content, m.config.CommandPrefix))
+	if len(fields) == 0 {
+		return
+	}
+	command := strings.ToLower(fields[0])
+
+	var action whatsmeow.ParticipantChange
+	var done string
+	switch command {
+	case "kick", "remove":
+		action, done = whatsmeow.ParticipantChangeRemove, "Removed"
+	case "promote":
+		action, done = whatsmeow.ParticipantChangePromote, "Promoted"
+	case "demote":
+		action, done = whatsmeow.ParticipantChangeDemote, "Demoted"
+	case "help":
+		p := m.config.CommandPrefix
+		m.reply(msg.ChatJID, fmt.Sprintf("Admin commands: %skick, %spromote and %sdemote, followed by phone numbers or @mentions.", p, p, p))
+		return
+	default:
+		return
+	}
+
+	// Mentions arrive in the text as @ and the phone number
+	var targets []types.JID
+	var users []string
+	for _, arg := range fields[1:] {
+		user := strings.TrimLeft(arg, "@+")
+		if user == "" || strings.Trim(user, "0123456789") != "" {
+			continue
+		}
+		targets = append(targets, types.NewJID(user, types.DefaultUserServer))
+		users = append(users, user)
+	}
+	if len(targets) == 0 {
+		m.reply(msg.ChatJID, fmt.Sprintf("Usage: %s%s <phone number or @mention>", m.config.CommandPrefix, command))
+		return
+	}
+
+	if _, err := m.client.UpdateGroupParticipants(group, targets, action); err != nil {
+		m.logger.Warnf("Moderation command %s in %s failed: %v", command, msg.ChatJID, err)
+		m.reply(msg.ChatJID, fmt.Sprintf("Could not %s %s: %v", command, strings.Join(users, ", "), err))
+		return
+	}
+	m.reply(msg.ChatJID, fmt.Sprintf("%s %s.", done, strings.Join(users, ", ")))
+	m.record(msg, ModerationCommand, command, targets)
+}
+
+// HandleGroupInfo welcomes new members and forgets cached admin lists after changes
+func (m *Moderator) HandleGroupInfo(evt *events.GroupInfo) {
+	chatJID := evt.JID.String()
+	m.mutex.Lock()
+	delete(m.groupInfo, chatJID)
+	m.mutex.Unlock()
+
+	if m.config.Welcome == "" || len(evt.Join) == 0 || !m.active(chatJID) {
+		return
+	}
+	// No welcome when the account itself was added
+	for _, jid := range evt.Join {
+		if m.isSelf(jid.User) {
+			return
+		}
+	}
+	if selfAdmin, _, err := m.roles(evt.JID, ""); err != nil || !selfAdmin {
+		return
+	}
+
+	users := make([]string, len(evt.Join))
+	for i, jid := range evt.Join {
+		users[i] = "@" + jid.User
+	}
+	name := ""
+	m.mutex.Lock()
+	if cached, ok := m.groupInfo[chatJID]; ok {
+		name = cached.info.Name
+	}
+	m.mutex.Unlock()
+
+	m.reply(chatJID, fillModerationTemplate(m.config.Welcome, map[string]string{"participants": strings.Join(users, ", "), "group": name}))
+	publishEvent(EventGroupModeration, chatJID, evt.Timestamp, map[string]interface{}{
+		"action":       ModerationWelcomed,
+		"participants": jidStrings(evt.Join),
+	})
+}
+
+// reply sends a moderation message to a group
+func (m *Moderator) reply(chatJID, text string) {
+	noSignature := false
+	success, result, _, _ := sendWhatsAppMessage(m.client, chatJID, text, "", SendOptions{Agent: "moderation", Signature: &noSignature}, m.messageStore)
+	if !success {
+		m.logger.Warnf("Moderation message to %s failed: %s", chatJID, result)
+	}
+}
+
+// record stores a moderation action prompted by a message in the group's history and publishes it
+func (m *Moderator) record(msg IncomingMessage, action, reason string, participants []types.JID) {
+	// The bridge acts on its own, except for commands
+	self, actor := "", msg.Sender
+	if m.client.Store.ID != nil {
+		self = m.client.Store.ID.ToNonAD().String()
+		if action != ModerationCommand {
+			actor = m.client.Store.ID.User
+		}
+	}
+
+	var content string
+	switch action {
+	case ModerationLinkRemoved:
+		content = fmt.Sprintf("Moderation removed %s for a link to %s", msg.Sender, reason)
+	case ModerationLinkDeleted:
+		content = fmt.Sprintf("Moderation deleted a message from %s with a link to %s", msg.Sender, reason)
+	case ModerationLinkWarned:
+		content = fmt.Sprintf("Moderation warned %s about a link to %s", msg.Sender, reason)
+	case ModerationFloodWarned:
+		content = fmt.Sprintf("Moderation warned %s for flooding", msg.Sender)
+	case ModerationCommand:
+		content = fmt.Sprintf("%s ran %s", msg.Sender, msg.Content)
+	}
+
+	recordSystemEvent(m.messageStore, m.logger, msg.ChatJID, actor, EventGroupModeration, content, time.Now(), map[string]interface{}{
+		"author":       self,
+		"action":       action,
+		"participants": jidStrings(participants),
+		"message_id":   msg.ID,
+		"reason":       reason,
+	})
+}
+
+// fillModerationTemplate replaces placeholders such as {{sender}} in a moderation message
+func fillModerationTemplate(template string, values map[string]string) string {
+	return flowVariablePattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := flowVariablePattern.FindStringSubmatch(placeholder)[1]
+		return values[name]
+	})
+}
+
+// registerModerationRoutes registers /api/v1/moderation
+func registerModerationRoutes() {
+	handleAPI("/moderation", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response := map[string]interface{}{"enabled": groupModerator != nil}
+		if groupModerator != nil {
+			response["config"] = groupModerator.config
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
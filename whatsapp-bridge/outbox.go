@@ -0,0 +1,492 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Statuses of an outbox message
+const (
+	OutboxQueued  = "queued"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// outboxColumns are the columns scanOutboxMessage reads, in order
+const outboxColumns = `id, recipient, chat_jid, COALESCE(message, ''), COALESCE(media_path, ''), COALESCE(client_ref, ''),
+	COALESCE(agent, ''), status, attempts, next_attempt_at, COALESCE(message_id, ''), COALESCE(error, ''),
+	COALESCE(error_code, ''), created_at, sent_at, COALESCE(api_key, ''), COALESCE(quoted_message_id, '')`
+
+// OutboxMessage is a message /send queued for the outbox worker. Message already carries the
+// agent signature, so it goes out as queued even if AGENT_SIGNATURE changes in between.
+type OutboxMessage struct {
+	ID        string `json:"id"`
+	Recipient string `json:"recipient"`
+	ChatJID   string `json:"chat_jid"`
+	Message   string `json:"message,omitempty"`
+	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	APIKey    string `json:"api_key,omitempty"`
+	// QuotedMessageID is the stored message the queued message replies to
+	QuotedMessageID string     `json:"quoted_message_id,omitempty"`
+	Status          string     `json:"status"`
+	Attempts        int        `json:"attempts"`
+	NextAttemptAt   time.Time  `json:"next_attempt_at"`
+	MessageID       string     `json:"message_id,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	ErrorCode       string     `json:"error_code,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	SentAt          *time.Time `json:"sent_at,omitempty"`
+}
+
+// MessageStatus is the state of a sent or queued message, served by /messages/{id}/status.
+// Delivery is the receipt status (sent, delivered, read, played) once the message went out.
+type MessageStatus struct {
+	ID            string     `json:"id"`
+	MessageID     string     `json:"message_id,omitempty"`
+	ChatJID       string     `json:"chat_jid,omitempty"`
+	ClientRef     string     `json:"client_ref,omitempty"`
+	Status        string     `json:"status"`
+	Delivery      string     `json:"delivery,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	ErrorCode     string     `json:"error_code,omitempty"`
+	QueuedAt      *time.Time `json:"queued_at,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// Outbox sends the messages queued by /send while this replica leads, retrying failed sends with
+// exponential backoff
+type Outbox struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+	// enabled queues every /send that doesn't ask otherwise
+	enabled     bool
+	interval    time.Duration
+	batch       int
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	wake        chan struct{}
+}
+
+// messageOutbox holds the messages /send queued; the leader drains it
+var messageOutbox *Outbox
+
+// NewOutboxFromEnv reads the OUTBOX_* settings. OUTBOX_ENABLED is off by default because a queued
+// send answers 202 with an outbox ID instead of the WhatsApp message ID callers of /send read.
+func NewOutboxFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*Outbox, error) {
+	outbox := &Outbox{
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+		enabled:      getEnvBool("OUTBOX_ENABLED", false),
+		interval:     time.Duration(getEnvInt("OUTBOX_POLL_SECONDS", 5)) * time.Second,
+		batch:        getEnvInt("OUTBOX_BATCH_SIZE", 50),
+		maxAttempts:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 8),
+		retryBase:    time.Duration(getEnvInt("OUTBOX_RETRY_BASE_SECONDS", 10)) * time.Second,
+		retryMax:     time.Duration(getEnvInt("OUTBOX_RETRY_MAX_SECONDS", 3600)) * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+	switch {
+	case outbox.interval < time.Second:
+		return nil, fmt.Errorf("OUTBOX_POLL_SECONDS must be positive")
+	case outbox.batch < 1:
+		return nil, fmt.Errorf("OUTBOX_BATCH_SIZE must be positive")
+	case outbox.maxAttempts < 1:
+		return nil, fmt.Errorf("OUTBOX_MAX_ATTEMPTS must be positive")
+	case outbox.retryBase < time.Second:
+		return nil, fmt.Errorf("OUTBOX_RETRY_BASE_SECONDS must be positive")
+	case outbox.retryMax < outbox.retryBase:
+		return nil, fmt.Errorf("OUTBOX_RETRY_MAX_SECONDS must be at least OUTBOX_RETRY_BASE_SECONDS")
+	}
+	return outbox, nil
+}
+
+// Start gives up on messages a previous run was sending, then drains the outbox every interval
+// and whenever a message is queued or the connection comes back
+func (o *Outbox) Start() {
+	// A message that was being sent when the bridge stopped may have gone out; sending it again could duplicate it
+	if interrupted, err := o.messageStore.FailInterruptedOutboxMessages(); err != nil {
+		o.logger.Warnf("Failed to clean up interrupted outbox messages: %v", err)
+	} else if interrupted > 0 {
+		o.logger.Warnf("Marked %d outbox messages interrupted by a restart as failed", interrupted)
+	}
+
+	go func() {
+		for {
+			o.drain()
+			select {
+			case <-o.wake:
+			case <-time.After(o.interval):
+			}
+		}
+	}()
+}
+
+// Wake drains the outbox now instead of at the next poll
+func (o *Outbox) Wake() {
+	if o == nil {
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// paused reports why queued messages have to wait, or "" when they can go out
+func (o *Outbox) paused() string {
+	switch {
+	case maintenance.Status().State != MaintenanceOff:
+		return "maintenance"
+	case sessionGuard.Current() != nil:
+		return "session lock"
+	case !o.client.IsConnected():
+		return "not connected"
+	}
+	return ""
+}
+
+// drain sends the queued messages that are due, oldest first, while the connection holds
+func (o *Outbox) drain() {
+	if reason := o.paused(); reason != "" {
+		o.logger.Debugf("Outbox waits (%s)", reason)
+		return
+	}
+
+	due, err := o.messageStore.DueOutboxMessages(time.Now().UTC(), o.batch)
+	if err != nil {
+		o.logger.Warnf("Failed to list due outbox messages: %v", err)
+		return
+	}
+	for i := range due {
+		if i > 0 {
+			time.Sleep(scheduledSendGap)
+		}
+		if o.paused() != "" {
+			return
+		}
+		o.send(&due[i])
+	}
+}
+
+// retryDelay is the wait after a failed attempt: the base delay, doubled after every attempt, up to the maximum
+func (o *Outbox) retryDelay(attempts int) time.Duration {
+	delay := o.retryBase
+	for i := 1; i < attempts && delay < o.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > o.retryMax {
+		delay = o.retryMax
+	}
+	return delay
+}
+
+// send sends one outbox message and records the outcome; retryable failures try again after a backoff
+func (o *Outbox) send(queued *OutboxMessage) {
+	claimed, err := o.messageStore.ClaimOutboxMessage(queued.ID)
+	if err != nil || !claimed {
+		if err != nil {
+			o.logger.Warnf("Failed to claim outbox message %s: %v", queued.ID, err)
+		}
+		return
+	}
+
+	// The signature was added when the message was queued
+	noSignature := false
+	opts := SendOptions{ClientRef: queued.ClientRef, Agent: queued.Agent, Signature: &noSignature, APIKey: queued.APIKey,
+		QuotedMessageID: queued.QuotedMessageID}
+	success, result, messageID, code := sendWhatsAppMessage(o.client, queued.Recipient, queued.Message, queued.MediaPath, opts, o.messageStore)
+	attempts := queued.Attempts + 1
+	now := time.Now().UTC()
+
+	switch {
+	case success:
+		err = o.messageStore.FinishOutboxMessage(queued.ID, OutboxSent, attempts, messageID, "", "", &now, queued.NextAttemptAt)
+	case sendErrorRetryable[code] && attempts < o.maxAttempts:
+		retryAt := now.Add(o.retryDelay(attempts))
+		o.logger.Infof("Outbox message %s to %s failed (%s), retrying at %s", queued.ID, logRedactor.Phone(queued.Recipient), result, retryAt.Format(time.RFC3339))
+		err = o.messageStore.FinishOutboxMessage(queued.ID, OutboxQueued, attempts, "", result, code, nil, retryAt)
+	default:
+		o.logger.Warnf("Outbox message %s to %s failed: %s", queued.ID, logRedactor.Phone(queued.Recipient), result)
+		err = o.messageStore.FinishOutboxMessage(queued.ID, OutboxFailed, attempts, "", result, code, nil, queued.NextAttemptAt)
+	}
+	if err != nil {
+		o.logger.Errorf("Failed to record outcome of outbox message %s: %v", queued.ID, err)
+	}
+}
+
+// queueRequested reports whether a /send request goes through the outbox: its queue field, then
+// ?queue=, then OUTBOX_ENABLED
+func (o *Outbox) queueRequested(r *http.Request, queue *bool) (bool, error) {
+	if queue != nil {
+		return *queue, nil
+	}
+	if value := r.URL.Query().Get("queue"); value != "" {
+		switch value {
+		case "true", "1":
+			return true, nil
+		case "false", "0":
+			return false, nil
+		}
+		return false, fmt.Errorf("queue must be true or false")
+	}
+	return o != nil && o.enabled, nil
+}
+
+// Enqueue validates the recipient and stores a message for the worker
+func (o *Outbox) Enqueue(recipient, message, mediaPath string, opts SendOptions) (*OutboxMessage, error) {
+	jid, err := parseRecipient(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %v", recipient, err)
+	}
+
+	now := time.Now().UTC()
+	queued := &OutboxMessage{
+		ID:              newEventID(),
+		Recipient:       recipient,
+		ChatJID:         jid.String(),
+		Message:         withAgentSignature(message, opts),
+		MediaPath:       mediaPath,
+		ClientRef:       opts.ClientRef,
+		Agent:           opts.Agent,
+		APIKey:          opts.APIKey,
+		QuotedMessageID: opts.QuotedMessageID,
+		Status:          OutboxQueued,
+		NextAttemptAt:   now,
+		CreatedAt:       now,
+	}
+	if err := o.messageStore.AddOutboxMessage(queued); err != nil {
+		return nil, err
+	}
+	o.Wake()
+	return queued, nil
+}
+
+// scanOutboxMessage reads a row of outboxColumns
+func scanOutboxMessage(row interface{ Scan(...interface{}) error }) (*OutboxMessage, error) {
+	var queued OutboxMessage
+	var sentAt sql.NullTime
+	if err := row.Scan(&queued.ID, &queued.Recipient, &queued.ChatJID, &queued.Message, &queued.MediaPath, &queued.ClientRef,
+		&queued.Agent, &queued.Status, &queued.Attempts, &queued.NextAttemptAt, &queued.MessageID, &queued.Error,
+		&queued.ErrorCode, &queued.CreatedAt, &sentAt, &queued.APIKey, &queued.QuotedMessageID); err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		queued.SentAt = &sentAt.Time
+	}
+	return &queued, nil
+}
+
+// AddOutboxMessage stores a queued message
+func (store *MessageStore) AddOutboxMessage(queued *OutboxMessage) error {
+	query := `INSERT INTO outbox (id, recipient, chat_jid, message, media_path, client_ref, agent, api_key, quoted_message_id, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, 0, ?, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO outbox (id, recipient, chat_jid, message, media_path, client_ref, agent, api_key, quoted_message_id, status, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10, 0, $11, $12)`
+	}
+	_, err := store.db.Exec(query, queued.ID, queued.Recipient, queued.ChatJID, queued.Message, queued.MediaPath, queued.ClientRef,
+		queued.Agent, queued.APIKey, queued.QuotedMessageID, queued.Status, queued.NextAttemptAt, queued.CreatedAt)
+	return err
+}
+
+// GetOutboxMessage returns an outbox message by its outbox ID or, once sent, its WhatsApp message ID; nil when there is none
+func (store *MessageStore) GetOutboxMessage(id string) (*OutboxMessage, error) {
+	query := "SELECT " + outboxColumns + " FROM outbox WHERE id = ? OR message_id = ? ORDER BY created_at DESC LIMIT 1"
+	if store.isPostgres {
+		query = "SELECT " + outboxColumns + " FROM outbox WHERE id = $1 OR message_id = $2 ORDER BY created_at DESC LIMIT 1"
+	}
+	queued, err := scanOutboxMessage(store.db.QueryRow(query, id, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return queued, err
+}
+
+// DueOutboxMessages returns queued messages whose next attempt is due, oldest first
+func (store *MessageStore) DueOutboxMessages(now time.Time, limit int) ([]OutboxMessage, error) {
+	query := "SELECT " + outboxColumns + " FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY created_at ASC LIMIT ?"
+	if store.isPostgres {
+		query = "SELECT " + outboxColumns + " FROM outbox WHERE status = $1 AND next_attempt_at <= $2 ORDER BY created_at ASC LIMIT $3"
+	}
+	rows, err := store.db.Query(query, OutboxQueued, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []OutboxMessage{}
+	for rows.Next() {
+		queued, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, *queued)
+	}
+	return due, rows.Err()
+}
+
+// ClaimOutboxMessage marks a queued message as sending, reporting false when it's no longer queued
+func (store *MessageStore) ClaimOutboxMessage(id string) (bool, error) {
+	query := "UPDATE outbox SET status = ? WHERE id = ? AND status = ?"
+	if store.isPostgres {
+		query = "UPDATE outbox SET status = $1 WHERE id = $2 AND status = $3"
+	}
+	result, err := store.db.Exec(query, OutboxSending, id, OutboxQueued)
+	if err != nil {
+		return false, err
+	}
+	claimed, _ := result.RowsAffected()
+	return claimed > 0, nil
+}
+
+// FinishOutboxMessage records the outcome of an attempt; a queued status with a later nextAttemptAt retries it
+func (store *MessageStore) FinishOutboxMessage(id, status string, attempts int, messageID, errorMessage, errorCode string, sentAt *time.Time, nextAttemptAt time.Time) error {
+	query := `UPDATE outbox SET status = ?, attempts = ?, message_id = NULLIF(?, ''), error = NULLIF(?, ''),
+		error_code = NULLIF(?, ''), sent_at = ?, next_attempt_at = ? WHERE id = ?`
+	if store.isPostgres {
+		query = `UPDATE outbox SET status = $1, attempts = $2, message_id = NULLIF($3, ''), error = NULLIF($4, ''),
+		error_code = NULLIF($5, ''), sent_at = $6, next_attempt_at = $7 WHERE id = $8`
+	}
+	_, err := store.db.Exec(query, status, attempts, messageID, errorMessage, errorCode, sentAt, nextAttemptAt, id)
+	return err
+}
+
+// FailInterruptedOutboxMessages marks messages left sending by a previous run as failed
+func (store *MessageStore) FailInterruptedOutboxMessages() (int64, error) {
+	query := "UPDATE outbox SET status = ?, error = ? WHERE status = ?"
+	if store.isPostgres {
+		query = "UPDATE outbox SET status = $1, error = $2 WHERE status = $3"
+	}
+	result, err := store.db.Exec(query, OutboxFailed, "Interrupted while sending; the message may have been delivered", OutboxSending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetMessageStatus resolves an outbox ID or a WhatsApp message ID to the state of the message; nil when
+// neither is known. A message sent directly by /send reports sent with its delivery status.
+func (store *MessageStore) GetMessageStatus(id, chatJID string) (*MessageStatus, error) {
+	queued, err := store.GetOutboxMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if queued != nil && (chatJID == "" || queued.ChatJID == chatJID) {
+		status := &MessageStatus{
+			ID:        queued.ID,
+			MessageID: queued.MessageID,
+			ChatJID:   queued.ChatJID,
+			ClientRef: queued.ClientRef,
+			Status:    queued.Status,
+			Attempts:  queued.Attempts,
+			Error:     queued.Error,
+			ErrorCode: queued.ErrorCode,
+			QueuedAt:  &queued.CreatedAt,
+			SentAt:    queued.SentAt,
+		}
+		if queued.Status == OutboxQueued {
+			status.NextAttemptAt = &queued.NextAttemptAt
+		}
+		if queued.MessageID != "" {
+			if matches, err := store.findMessage(queued.MessageID, queued.ChatJID); err == nil && len(matches) == 1 {
+				status.Delivery = matches[0].Status
+			}
+		}
+		return status, nil
+	}
+
+	matches, err := store.findMessage(id, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	if len(matches) > 1 {
+		return nil, errAmbiguousMessage
+	}
+	sent := matches[0]
+	if !sent.IsFromMe {
+		return nil, nil
+	}
+	return &MessageStatus{
+		ID:        sent.ID,
+		MessageID: sent.ID,
+		ChatJID:   sent.ChatJID,
+		ClientRef: sent.ClientRef,
+		Status:    OutboxSent,
+		Delivery:  sent.Status,
+		Attempts:  1,
+		SentAt:    &sent.Timestamp,
+	}, nil
+}
+
+// errAmbiguousMessage is returned for a message ID stored in several chats
+var errAmbiguousMessage = errors.New("message ID exists in several chats; pass chat_jid")
+
+// localize converts the times of a message status to a response's time zone
+func (status *MessageStatus) localize(loc *time.Location) {
+	for _, at := range []**time.Time{&status.NextAttemptAt, &status.QueuedAt, &status.SentAt} {
+		if *at != nil {
+			local := (*at).In(loc)
+			*at = &local
+		}
+	}
+}
+
+// handleMessageStatus serves /api/v1/messages/{id}/status
+func handleMessageStatus(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		route := strings.TrimPrefix(apiRoute(r), "/messages/")
+		id := strings.TrimSuffix(route, "/status")
+		if id == route || id == "" || strings.Contains(id, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status, err := messageStore.GetMessageStatus(id, r.URL.Query().Get("chat_jid"))
+		if err == errAmbiguousMessage {
+			http.Error(w, "Message ID exists in several chats; pass chat_jid", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message status: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if status == nil {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		legalHolds.RecordRequest(r, status.ChatJID)
+		status.localize(loc)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// pairingClientName is the device a pairing code links; WhatsApp only accepts common "Browser (OS)" names
+const pairingClientName = "Chrome (Linux)"
+
+// PairPhoneRequest is the body of POST /api/v1/pair/phone and /api/v1/accounts/{id}/pair-phone
+type PairPhoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// PairPhoneResponse carries the code to enter on the phone, under Linked Devices > Link a Device >
+// Link with phone number instead
+type PairPhoneResponse struct {
+	Code  string `json:"code"`
+	Phone string `json:"phone"`
+}
+
+// validatePairPhone returns the digits of an international phone number, as PairPhone expects
+func validatePairPhone(phone string) (string, error) {
+	digits := normalizePairPhone(phone)
+	if len(digits) < 7 || len(digits) > 15 || strings.HasPrefix(digits, "0") {
+		return "", fmt.Errorf("phone must be an international number with its country code, e.g. +44 7700 900123")
+	}
+	return digits, nil
+}
+
+// requestPairingCode asks WhatsApp for an 8-character code that links the client to phone. It only
+// works while the client shows QR codes, since the code is sent over the same login connection,
+// and the code expires with the last of them.
+func requestPairingCode(client *whatsmeow.Client, phone string) (string, error) {
+	return client.PairPhone(context.Background(), phone, true, whatsmeow.PairClientChrome, pairingClientName)
+}
+
+// servePairingCode answers a pairing code request for a client; qrShown reports whether it is
+// showing QR codes. It returns the phone number when a code was sent.
+func servePairingCode(w http.ResponseWriter, r *http.Request, client *whatsmeow.Client, qrShown bool) (string, bool) {
+	var req PairPhoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return "", false
+	}
+	phone, err := validatePairPhone(req.Phone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if client.Store.ID != nil {
+		http.Error(w, "Already linked to WhatsApp", http.StatusConflict)
+		return "", false
+	}
+	if !qrShown {
+		http.Error(w, "Not pairing right now; try again once a QR code is shown", http.StatusConflict)
+		return "", false
+	}
+
+	code, err := requestPairingCode(client, phone)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to request pairing code: %v", err), http.StatusBadGateway)
+		return "", false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(PairPhoneResponse{Code: code, Phone: phone})
+	return phone, true
+}
+
+// registerPairPhoneRoutes registers /api/v1/pair/phone, which links the default account with a
+// pairing code instead of the QR code the dashboard shows
+func registerPairPhoneRoutes(client *whatsmeow.Client, qrWebServer *QRWebServer, logger waLog.Logger) {
+	handleAPI("/pair/phone", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		qrCode, connected := qrWebServer.GetQRCode()
+		if phone, ok := servePairingCode(w, r, client, qrCode != "" && !connected); ok {
+			pairingAudit.RecordPhoneCode(r)
+			logger.Infof("Pairing code requested for %s", logRedactor.Phone(phone))
+		}
+	}))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Outcomes of a pairing attempt
+const (
+	PairingPending   = "pending"
+	PairingSucceeded = "success"
+	PairingFailed    = "failed"
+	PairingTimeout   = "timeout"
+	// PairingAbandoned marks attempts cut short by a restart
+	PairingAbandoned = "abandoned"
+)
+
+// maxPairingViewers caps the viewers kept per attempt, so a polling dashboard can't grow a row forever
+const maxPairingViewers = 50
+
+// PairingViewer is a dashboard session that was shown the QR code
+type PairingViewer struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// PairingAttempt records one attempt to link the account, from the first QR code to its outcome
+type PairingAttempt struct {
+	ID         string          `json:"id"`
+	Method     string          `json:"method"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Error      string          `json:"error,omitempty"`
+	DeviceJID  string          `json:"device_jid,omitempty"`
+	Platform   string          `json:"platform,omitempty"`
+	Viewers    []PairingViewer `json:"viewers"`
+}
+
+// PairingAudit keeps the history of pairing attempts for security review
+type PairingAudit struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	current      *PairingAttempt
+	mutex        sync.Mutex
+}
+
+// pairingAudit records pairing attempts once the message store is open
+var pairingAudit *PairingAudit
+
+// NewPairingAudit creates an audit log backed by the message store
+func NewPairingAudit(messageStore *MessageStore, logger waLog.Logger) *PairingAudit {
+	return &PairingAudit{messageStore: messageStore, logger: logger}
+}
+
+// Begin starts recording a pairing attempt. Method is "qr" or "phone_code".
+func (a *PairingAudit) Begin(method string) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Attempts left open by a previous run never finished
+	if err := a.messageStore.AbandonPendingPairings(); err != nil {
+		a.logger.Warnf("Failed to close old pairing attempts: %v", err)
+	}
+
+	a.current = &PairingAttempt{
+		ID:        newEventID(),
+		Method:    method,
+		StartedAt: time.Now().UTC(),
+		Outcome:   PairingPending,
+		Viewers:   []PairingViewer{},
+	}
+	if err := a.messageStore.SavePairingAttempt(a.current, true); err != nil {
+		a.logger.Warnf("Failed to record pairing attempt: %v", err)
+	}
+}
+
+// RecordViewer notes the dashboard session that was served the QR code
+func (a *PairingAudit) RecordViewer(r *http.Request) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.current == nil || !a.addViewer(r) {
+		return
+	}
+	if err := a.messageStore.SavePairingAttempt(a.current, false); err != nil {
+		a.logger.Warnf("Failed to record QR code viewer: %v", err)
+	}
+}
+
+// RecordPhoneCode notes that the current attempt went on with a pairing code, requested by the
+// session of r, which counts as a viewer of the code
+func (a *PairingAudit) RecordPhoneCode(r *http.Request) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.current == nil {
+		return
+	}
+	a.current.Method = "phone_code"
+	a.addViewer(r)
+	if err := a.messageStore.SavePairingAttempt(a.current, false); err != nil {
+		a.logger.Warnf("Failed to record pairing code request: %v", err)
+	}
+}
+
+// addViewer adds the session of r to the viewers of the current attempt, reporting whether it is new
+func (a *PairingAudit) addViewer(r *http.Request) bool {
+	if len(a.current.Viewers) >= maxPairingViewers {
+		return false
+	}
+
+	// The page refreshes the QR image, so each session is only recorded once
+	viewer := PairingViewer{IP: clientIP(r), UserAgent: r.UserAgent(), SeenAt: time.Now().UTC()}
+	for _, seen := range a.current.Viewers {
+		if seen.IP == viewer.IP && seen.UserAgent == viewer.UserAgent {
+			return false
+		}
+	}
+	a.current.Viewers = append(a.current.Viewers, viewer)
+	return true
+}
+
+// Finish records the outcome of the current attempt; later calls for the same attempt are ignored
+func (a *PairingAudit) Finish(outcome, deviceJID, platform, errorMessage string) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.current == nil {
+		return
+	}
+	finishedAt := time.Now().UTC()
+	a.current.FinishedAt = &finishedAt
+	a.current.Outcome = outcome
+	a.current.DeviceJID = deviceJID
+	a.current.Platform = platform
+	a.current.Error = errorMessage
+	if err := a.messageStore.SavePairingAttempt(a.current, false); err != nil {
+		a.logger.Warnf("Failed to record pairing outcome: %v", err)
+	}
+	a.current = nil
+}
+
+// FinishQR records a QR channel timeout or error as the outcome of the current attempt
+func (a *PairingAudit) FinishQR(evt whatsmeow.QRChannelItem) {
+	switch {
+	case evt.Event == whatsmeow.QRChannelTimeout.Event:
+		a.Finish(PairingTimeout, "", "", "QR code was not scanned in time")
+	case evt.Error != nil:
+		a.Finish(PairingFailed, "", "", evt.Error.Error())
+	default:
+		a.Finish(PairingFailed, "", "", evt.Event)
+	}
+}
+
+// clientIP returns the address of the client; forwardedMiddleware has already replaced the peer
+// address with the nearest untrusted X-Forwarded-For hop when the peer is a trusted proxy
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SavePairingAttempt inserts a new pairing attempt or updates an existing one
+func (store *MessageStore) SavePairingAttempt(attempt *PairingAttempt, insert bool) error {
+	viewers, err := json.Marshal(attempt.Viewers)
+	if err != nil {
+		return err
+	}
+
+	var finishedAt interface{}
+	if attempt.FinishedAt != nil {
+		finishedAt = *attempt.FinishedAt
+	}
+
+	var query string
+	var args []interface{}
+	if insert {
+		query = `INSERT INTO pairing_attempts (id, method, started_at, outcome, viewers) VALUES (?, ?, ?, ?, ?)`
+		if store.isPostgres {
+			query = `INSERT INTO pairing_attempts (id, method, started_at, outcome, viewers) VALUES ($1, $2, $3, $4, $5)`
+		}
+		args = []interface{}{attempt.ID, attempt.Method, attempt.StartedAt, attempt.Outcome, string(viewers)}
+	} else {
+		query = `UPDATE pairing_attempts SET method = ?, finished_at = ?, outcome = ?, error = ?, device_jid = ?, platform = ?, viewers = ? WHERE id = ?`
+		if store.isPostgres {
+			query = `UPDATE pairing_attempts SET method = $1, finished_at = $2, outcome = $3, error = $4, device_jid = $5, platform = $6, viewers = $7 WHERE id = $8`
+		}
+		args = []interface{}{attempt.Method, finishedAt, attempt.Outcome, attempt.Error, attempt.DeviceJID, attempt.Platform, string(viewers), attempt.ID}
+	}
+
+	if _, err := store.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to save pairing attempt: %v", err)
+	}
+	return nil
+}
+
+// AbandonPendingPairings closes attempts that were still open when the bridge stopped
+func (store *MessageStore) AbandonPendingPairings() error {
+	query := "UPDATE pairing_attempts SET outcome = ?, finished_at = ? WHERE outcome = ?"
+	if store.isPostgres {
+		query = "UPDATE pairing_attempts SET outcome = $1, finished_at = $2 WHERE outcome = $3"
+	}
+	_, err := store.db.Exec(query, PairingAbandoned, time.Now().UTC(), PairingPending)
+	return err
+}
+
+// ListPairingAttempts returns the most recent pairing attempts, newest first
+func (store *MessageStore) ListPairingAttempts(limit int) ([]PairingAttempt, error) {
+	query := `SELECT id, method, started_at, finished_at, outcome, error, device_jid, platform, viewers
+		FROM pairing_attempts ORDER BY started_at DESC LIMIT ?`
+	if store.isPostgres {
+		query = `SELECT id, method, started_at, finished_at, outcome, error, device_jid, platform, viewers
+		FROM pairing_attempts ORDER BY started_at DESC LIMIT $1`
+	}
+
+	rows, err := store.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []PairingAttempt{}
+	for rows.Next() {
+		var attempt PairingAttempt
+		var finishedAt sql.NullTime
+		var errorMessage, deviceJID, platform, viewers sql.NullString
+		if err := rows.Scan(&attempt.ID, &attempt.Method, &attempt.StartedAt, &finishedAt, &attempt.Outcome,
+			&errorMessage, &deviceJID, &platform, &viewers); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			attempt.FinishedAt = &finishedAt.Time
+		}
+		attempt.Error = errorMessage.String
+		attempt.DeviceJID = deviceJID.String
+		attempt.Platform = platform.String
+		attempt.Viewers = []PairingViewer{}
+		if viewers.String != "" {
+			if err := json.Unmarshal([]byte(viewers.String), &attempt.Viewers); err != nil {
+				return nil, fmt.Errorf("invalid viewers of pairing attempt %s: %v", attempt.ID, err)
+			}
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// registerPairingRoutes registers /api/v1/pairing/history
+func registerPairingRoutes(messageStore *MessageStore) {
+	handleAPI("/pairing/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 1000 {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+
+		attempts, err := messageStore.ListPairingAttempts(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get pairing history: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range attempts {
+			attempts[i].StartedAt = attempts[i].StartedAt.In(loc)
+			if attempts[i].FinishedAt != nil {
+				finishedAt := attempts[i].FinishedAt.In(loc)
+				attempts[i].FinishedAt = &finishedAt
+			}
+			for j := range attempts[i].Viewers {
+				attempts[i].Viewers[j].SeenAt = attempts[i].Viewers[j].SeenAt.In(loc)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attempts)
+	})
+}
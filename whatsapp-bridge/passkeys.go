@@ -0,0 +1,661 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	// passkeyInviteTTL is how long a teammate has to register their first passkey
+	passkeyInviteTTL = 24 * time.Hour
+	// passkeyChallengeTTL is how long a registration or sign-in may take in the browser
+	passkeyChallengeTTL = 5 * time.Minute
+)
+
+// Passkey is a WebAuthn credential a dashboard user signs in with
+type Passkey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserID     string     `json:"-"`
+	PublicKey  []byte     `json:"-"`
+	SignCount  uint32     `json:"-"`
+}
+
+// passkeyChallenge is a registration or sign-in the browser is working on
+type passkeyChallenge struct {
+	challenge []byte
+	ceremony  string
+	userID    string
+	invite    string
+	expires   time.Time
+}
+
+// PasskeyAuth registers passkeys and signs local dashboard users in with them, as an
+// alternative to their password
+type PasskeyAuth struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	rpID         string
+	origins      []string
+	challenges   map[string]passkeyChallenge
+	mutex        sync.Mutex
+}
+
+// passkeyAuth is set once the message store is open
+var passkeyAuth *PasskeyAuth
+
+// NewPasskeyAuthFromEnv reads WEBAUTHN_RP_ID and WEBAUTHN_ORIGINS; without them passkeys are
+// bound to the host the dashboard is opened on
+func NewPasskeyAuthFromEnv(messageStore *MessageStore, logger waLog.Logger) *PasskeyAuth {
+	p := &PasskeyAuth{
+		messageStore: messageStore,
+		logger:       logger,
+		rpID:         os.Getenv("WEBAUTHN_RP_ID"),
+		challenges:   make(map[string]passkeyChallenge),
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			p.origins = append(p.origins, origin)
+		}
+	}
+	return p
+}
+
+// relyingParty returns the domain passkeys are bound to and the origins allowed to use them
+func (p *PasskeyAuth) relyingParty(r *http.Request) (string, []string) {
+	rpID := p.rpID
+	if rpID == "" {
+		rpID = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			rpID = host
+		}
+	}
+	origins := p.origins
+	if len(origins) == 0 {
+		origins = []string{strings.TrimSuffix(externalURL(r, ""), basePath)}
+	}
+	return rpID, origins
+}
+
+// begin stores a new challenge and returns its ID
+func (p *PasskeyAuth) begin(ceremony, userID, invite string) (string, []byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", nil, err
+	}
+	id := newEventID()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	for key, pending := range p.challenges {
+		if now.After(pending.expires) {
+			delete(p.challenges, key)
+		}
+	}
+	p.challenges[id] = passkeyChallenge{
+		challenge: challenge,
+		ceremony:  ceremony,
+		userID:    userID,
+		invite:    invite,
+		expires:   now.Add(passkeyChallengeTTL),
+	}
+	return id, challenge, nil
+}
+
+// finish takes a challenge back; each can be answered once
+func (p *PasskeyAuth) finish(id, ceremony string) (passkeyChallenge, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pending, ok := p.challenges[id]
+	delete(p.challenges, id)
+	if !ok || pending.ceremony != ceremony || time.Now().After(pending.expires) {
+		return passkeyChallenge{}, false
+	}
+	return pending, true
+}
+
+// passkeyRegistration is the body of POST /auth/passkeys/register/finish
+type passkeyRegistration struct {
+	ChallengeID       string `json:"challenge_id"`
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AttestationObject string `json:"attestation_object"`
+}
+
+// passkeyAssertion is the body of POST /auth/passkeys/login/finish
+type passkeyAssertion struct {
+	ChallengeID       string `json:"challenge_id"`
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"user_handle"`
+}
+
+// decodeBase64URL accepts the unpadded base64url WebAuthn uses, and padded for good measure
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// ServePasskeys handles /auth/passkeys and everything under it. Passkeys are for local dashboard
+// users only; with Supabase, sign-in is Supabase's.
+func (q *QRWebServer) ServePasskeys(w http.ResponseWriter, r *http.Request) {
+	if q.supabaseClient != nil {
+		http.Error(w, "Passkeys are only available when Supabase isn't configured", http.StatusNotFound)
+		return
+	}
+	if passkeyAuth == nil || localAuth == nil {
+		http.Error(w, "The bridge is starting", http.StatusServiceUnavailable)
+		return
+	}
+
+	route := strings.Trim(strings.TrimPrefix(r.URL.Path, "/auth/passkeys"), "/")
+	switch {
+	case route == "login/begin" && r.Method == http.MethodPost:
+		passkeyAuth.beginLogin(w, r)
+	case route == "login/finish" && r.Method == http.MethodPost:
+		passkeyAuth.finishLogin(w, r)
+	case route == "register/begin" && r.Method == http.MethodPost:
+		passkeyAuth.beginRegistration(w, r)
+	case route == "register/finish" && r.Method == http.MethodPost:
+		passkeyAuth.finishRegistration(w, r)
+	case route == "invites" && r.Method == http.MethodPost:
+		passkeyAuth.createInvite(w, r)
+	case route == "" && r.Method == http.MethodGet:
+		passkeyAuth.listPasskeys(w, r)
+	case route != "" && !strings.Contains(route, "/") && r.Method == http.MethodDelete:
+		passkeyAuth.deletePasskey(w, r, route)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// signedIn returns the session's user, or writes 401
+func (p *PasskeyAuth) signedIn(w http.ResponseWriter, r *http.Request) *DashboardUser {
+	user := localAuth.SessionUser(r)
+	if user == nil {
+		http.Error(w, "Sign in to manage passkeys", http.StatusUnauthorized)
+	}
+	return user
+}
+
+// writePasskeyJSON writes a passkey endpoint's response
+func writePasskeyJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(value)
+}
+
+// pubKeyCredParams are the algorithms offered to authenticators, in order of preference
+var pubKeyCredParams = []map[string]interface{}{
+	{"type": "public-key", "alg": coseES256},
+	{"type": "public-key", "alg": coseEdDSA},
+	{"type": "public-key", "alg": coseRS256},
+}
+
+// beginRegistration returns the options for navigator.credentials.create. A signed-in user
+// registers a passkey for themselves; a teammate registers their first with an invite.
+func (p *PasskeyAuth) beginRegistration(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Invite string `json:"invite"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var user *DashboardUser
+	if req.Invite != "" {
+		invited, err := p.messageStore.DashboardSessionUser(req.Invite, SessionPurposeInvite)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check invite: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if invited == nil {
+			http.Error(w, "The invite is invalid or has expired", http.StatusUnauthorized)
+			return
+		}
+		user = invited
+	} else if user = p.signedIn(w, r); user == nil {
+		return
+	}
+
+	existing, err := p.messageStore.ListPasskeys(user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list passkeys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	exclude := []map[string]string{}
+	for _, passkey := range existing {
+		exclude = append(exclude, map[string]string{"type": "public-key", "id": passkey.ID})
+	}
+
+	id, challenge, err := p.begin("webauthn.create", user.ID, req.Invite)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rpID, _ := p.relyingParty(r)
+	writePasskeyJSON(w, map[string]interface{}{
+		"challenge_id": id,
+		"public_key": map[string]interface{}{
+			"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+			"rp":        map[string]string{"id": rpID, "name": "WhatsApp Bridge"},
+			"user": map[string]string{
+				"id":          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+				"name":        user.Email,
+				"displayName": user.Email,
+			},
+			"pubKeyCredParams":   pubKeyCredParams,
+			"excludeCredentials": exclude,
+			"authenticatorSelection": map[string]string{
+				"residentKey":      "required",
+				"userVerification": "preferred",
+			},
+			"attestation": "none",
+			"timeout":     int(passkeyChallengeTTL / time.Millisecond),
+		},
+	})
+}
+
+// finishRegistration checks the new credential and stores it
+func (p *PasskeyAuth) finishRegistration(w http.ResponseWriter, r *http.Request) {
+	var req passkeyRegistration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	pending, ok := p.finish(req.ChallengeID, "webauthn.create")
+	if !ok {
+		http.Error(w, "The registration has expired; start again", http.StatusBadRequest)
+		return
+	}
+
+	clientDataJSON, err := decodeBase64URL(req.ClientDataJSON)
+	if err != nil {
+		http.Error(w, "client_data_json is not base64url", http.StatusBadRequest)
+		return
+	}
+	attestationObject, err := decodeBase64URL(req.AttestationObject)
+	if err != nil {
+		http.Error(w, "attestation_object is not base64url", http.StatusBadRequest)
+		return
+	}
+
+	rpID, origins := p.relyingParty(r)
+	if err := verifyClientData(clientDataJSON, "webauthn.create", pending.challenge, origins); err != nil {
+		http.Error(w, fmt.Sprintf("Passkey rejected: %v", err), http.StatusBadRequest)
+		return
+	}
+	auth, err := parseAttestation(attestationObject)
+	if err == nil {
+		err = verifyAuthenticatorData(auth, rpID)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Passkey rejected: %v", err), http.StatusBadRequest)
+		return
+	}
+	credentialID := base64.RawURLEncoding.EncodeToString(auth.credentialID)
+	if req.ID != "" && strings.TrimRight(req.ID, "=") != credentialID {
+		http.Error(w, "Passkey rejected: credential ID does not match", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := Passkey{
+		ID:        credentialID,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		UserID:    pending.userID,
+		PublicKey: auth.publicKey,
+		SignCount: auth.signCount,
+	}
+	if err := p.messageStore.SavePasskey(passkey); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store passkey: %v", err), http.StatusInternalServerError)
+		return
+	}
+	user, err := p.messageStore.GetDashboardUser(pending.userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load the passkey's user", http.StatusInternalServerError)
+		return
+	}
+	p.logger.Infof("Registered passkey %q for dashboard user %s", passkey.Name, user.Email)
+
+	// An invite is good for one passkey, and signs the teammate in
+	if pending.invite != "" {
+		if err := p.messageStore.DeleteDashboardSession(pending.invite); err != nil {
+			p.logger.Warnf("Failed to remove used passkey invite: %v", err)
+		}
+		if err := localAuth.StartSession(w, r, user); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to sign in: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	writePasskeyJSON(w, passkey)
+}
+
+// beginLogin returns the options for navigator.credentials.get. No credentials are listed, so
+// the browser offers every passkey it holds for the bridge and the user picks their account.
+func (p *PasskeyAuth) beginLogin(w http.ResponseWriter, r *http.Request) {
+	id, challenge, err := p.begin("webauthn.get", "", "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rpID, _ := p.relyingParty(r)
+	writePasskeyJSON(w, map[string]interface{}{
+		"challenge_id": id,
+		"public_key": map[string]interface{}{
+			"challenge":        base64.RawURLEncoding.EncodeToString(challenge),
+			"rpId":             rpID,
+			"userVerification": "preferred",
+			"timeout":          int(passkeyChallengeTTL / time.Millisecond),
+		},
+	})
+}
+
+// finishLogin checks the passkey's signature and starts a session
+func (p *PasskeyAuth) finishLogin(w http.ResponseWriter, r *http.Request) {
+	var req passkeyAssertion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	pending, ok := p.finish(req.ChallengeID, "webauthn.get")
+	if !ok {
+		http.Error(w, "The sign-in has expired; start again", http.StatusBadRequest)
+		return
+	}
+
+	passkey, err := p.messageStore.GetPasskey(strings.TrimRight(req.ID, "="))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to look up passkey: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if passkey == nil {
+		http.Error(w, "This passkey isn't registered with the bridge", http.StatusUnauthorized)
+		return
+	}
+	user, err := p.messageStore.GetDashboardUser(passkey.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load the passkey's user", http.StatusInternalServerError)
+		return
+	}
+
+	reject := func(reason error) {
+		loginMonitor.Record(r, user.Email, false)
+		p.logger.Warnf("Rejected passkey sign-in for %s: %v", user.Email, reason)
+		http.Error(w, "Passkey sign-in failed", http.StatusUnauthorized)
+	}
+
+	clientDataJSON, err := decodeBase64URL(req.ClientDataJSON)
+	if err != nil {
+		reject(fmt.Errorf("client data is not base64url"))
+		return
+	}
+	authData, err := decodeBase64URL(req.AuthenticatorData)
+	if err != nil {
+		reject(fmt.Errorf("authenticator data is not base64url"))
+		return
+	}
+	signature, err := decodeBase64URL(req.Signature)
+	if err != nil {
+		reject(fmt.Errorf("signature is not base64url"))
+		return
+	}
+	if req.UserHandle != "" {
+		if handle, err := decodeBase64URL(req.UserHandle); err != nil || string(handle) != passkey.UserID {
+			reject(fmt.Errorf("user handle does not match"))
+			return
+		}
+	}
+
+	rpID, origins := p.relyingParty(r)
+	if err := verifyClientData(clientDataJSON, "webauthn.get", pending.challenge, origins); err != nil {
+		reject(err)
+		return
+	}
+	auth, err := parseAuthenticatorData(authData)
+	if err == nil {
+		err = verifyAuthenticatorData(auth, rpID)
+	}
+	if err != nil {
+		reject(err)
+		return
+	}
+	// The authenticator signs its data followed by the hash of the client data
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(passkey.PublicKey, signed, signature); err != nil {
+		reject(err)
+		return
+	}
+	// A counter that doesn't move forward means the passkey was copied
+	if auth.signCount != 0 || passkey.SignCount != 0 {
+		if auth.signCount <= passkey.SignCount {
+			reject(fmt.Errorf("signature counter went from %d to %d", passkey.SignCount, auth.signCount))
+			return
+		}
+	}
+
+	if err := p.messageStore.RecordPasskeyUse(passkey.ID, auth.signCount, time.Now().UTC()); err != nil {
+		p.logger.Warnf("Failed to update passkey %s: %v", passkey.ID, err)
+	}
+	if err := localAuth.StartSession(w, r, user); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to sign in: %v", err), http.StatusInternalServerError)
+		return
+	}
+	loginMonitor.Record(r, user.Email, true)
+	writePasskeyJSON(w, map[string]string{"email": user.Email, "redirect": withBasePath("/")})
+}
+
+// listPasskeys returns the signed-in user's passkeys
+func (p *PasskeyAuth) listPasskeys(w http.ResponseWriter, r *http.Request) {
+	user := p.signedIn(w, r)
+	if user == nil {
+		return
+	}
+	passkeys, err := p.messageStore.ListPasskeys(user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list passkeys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writePasskeyJSON(w, map[string]interface{}{
+		"email":        user.Email,
+		"admin":        user.Admin,
+		"has_password": user.HasPassword,
+		"passkeys":     passkeys,
+	})
+}
+
+// deletePasskey removes one of the signed-in user's passkeys
+func (p *PasskeyAuth) deletePasskey(w http.ResponseWriter, r *http.Request, id string) {
+	user := p.signedIn(w, r)
+	if user == nil {
+		return
+	}
+	deleted, err := p.messageStore.DeletePasskey(id, user.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove passkey: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	p.logger.Infof("Removed passkey %s of dashboard user %s", id, user.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createInvite lets an admin add a teammate, who registers a passkey with the link
+func (p *PasskeyAuth) createInvite(w http.ResponseWriter, r *http.Request) {
+	user := p.signedIn(w, r)
+	if user == nil {
+		return
+	}
+	if !user.Admin {
+		http.Error(w, "Only dashboard admins can invite teammates", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !strings.Contains(email, "@") {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	invited, err := p.messageStore.EnsureDashboardUser(email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	token, expires, err := p.messageStore.CreateDashboardSession(invited.ID, SessionPurposeInvite, passkeyInviteTTL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create invite: %v", err), http.StatusInternalServerError)
+		return
+	}
+	p.logger.Infof("%s invited %s to register a passkey", user.Email, email)
+	writePasskeyJSON(w, map[string]interface{}{
+		"email":      email,
+		"url":        externalURL(r, "/passkeys?invite="+token),
+		"expires_at": expires,
+	})
+}
+
+// ServePasskeysPage serves the passkey management page; with ?invite= it is open to the
+// invited teammate, who has no session yet
+func (q *QRWebServer) ServePasskeysPage(w http.ResponseWriter, r *http.Request) {
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		pageTemplates.Render(w, "passkeys", nil)
+	}
+	if r.URL.Query().Get("invite") != "" {
+		serve(w, r)
+		return
+	}
+	q.authMiddleware(serve)(w, r)
+}
+
+// scanPasskey reads a dashboard_passkeys row
+func scanPasskey(scan func(dest ...interface{}) error) (*Passkey, error) {
+	var passkey Passkey
+	var publicKey string
+	var signCount int64
+	var lastUsed sql.NullTime
+	if err := scan(&passkey.ID, &passkey.UserID, &passkey.Name, &publicKey, &signCount, &passkey.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of passkey %s: %v", passkey.ID, err)
+	}
+	passkey.PublicKey = key
+	passkey.SignCount = uint32(signCount)
+	if lastUsed.Valid {
+		passkey.LastUsedAt = &lastUsed.Time
+	}
+	return &passkey, nil
+}
+
+// passkeyColumns are the columns scanPasskey reads, in order
+const passkeyColumns = "id, user_id, name, public_key, sign_count, created_at, last_used_at"
+
+// ListPasskeys returns a user's passkeys, oldest first
+func (store *MessageStore) ListPasskeys(userID string) ([]Passkey, error) {
+	query := "SELECT " + passkeyColumns + " FROM dashboard_passkeys WHERE user_id = ? ORDER BY created_at"
+	if store.isPostgres {
+		query = "SELECT " + passkeyColumns + " FROM dashboard_passkeys WHERE user_id = $1 ORDER BY created_at"
+	}
+	rows, err := store.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, *passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+// GetPasskey returns a passkey by credential ID, or nil if it isn't registered
+func (store *MessageStore) GetPasskey(id string) (*Passkey, error) {
+	query := "SELECT " + passkeyColumns + " FROM dashboard_passkeys WHERE id = ?"
+	if store.isPostgres {
+		query = "SELECT " + passkeyColumns + " FROM dashboard_passkeys WHERE id = $1"
+	}
+	passkey, err := scanPasskey(store.db.QueryRow(query, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return passkey, err
+}
+
+// SavePasskey stores a newly registered passkey
+func (store *MessageStore) SavePasskey(passkey Passkey) error {
+	query := `INSERT INTO dashboard_passkeys (id, user_id, name, public_key, sign_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO dashboard_passkeys (id, user_id, name, public_key, sign_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	}
+	_, err := store.db.Exec(query, passkey.ID, passkey.UserID, passkey.Name,
+		base64.StdEncoding.EncodeToString(passkey.PublicKey), int64(passkey.SignCount), passkey.CreatedAt)
+	return err
+}
+
+// RecordPasskeyUse stores a passkey's signature counter after a sign-in
+func (store *MessageStore) RecordPasskeyUse(id string, signCount uint32, at time.Time) error {
+	query := "UPDATE dashboard_passkeys SET sign_count = ?, last_used_at = ? WHERE id = ?"
+	if store.isPostgres {
+		query = "UPDATE dashboard_passkeys SET sign_count = $1, last_used_at = $2 WHERE id = $3"
+	}
+	_, err := store.db.Exec(query, int64(signCount), at, id)
+	return err
+}
+
+// DeletePasskey removes one of a user's passkeys and reports whether it existed
+func (store *MessageStore) DeletePasskey(id, userID string) (bool, error) {
+	query := "DELETE FROM dashboard_passkeys WHERE id = ? AND user_id = ?"
+	if store.isPostgres {
+		query = "DELETE FROM dashboard_passkeys WHERE id = $1 AND user_id = $2"
+	}
+	result, err := store.db.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Payment requests expire after a week unless the caller says otherwise, like in the app
+const (
+	defaultPaymentExpiryHours = 7 * 24
+	maxPaymentExpiryHours     = 30 * 24
+)
+
+// currencyPattern matches ISO 4217 currency codes
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// PaymentDetails are the amount and expiry of an outgoing payment request
+type PaymentDetails struct {
+	// Amount1000 is the amount in thousandths of the currency unit, as WhatsApp encodes it
+	Amount1000 uint64
+	Currency   string
+	Expiry     time.Time
+}
+
+// PaymentRequest represents the request body for the payment request API
+type PaymentRequest struct {
+	Recipient      string  `json:"recipient"`
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	Note           string  `json:"note,omitempty"`
+	ExpiresInHours int     `json:"expires_in_hours,omitempty"`
+	ClientRef      string  `json:"client_ref,omitempty"`
+	Agent          string  `json:"agent,omitempty"`
+}
+
+// message builds the WhatsApp payment request, with the note as its text
+func (p *PaymentDetails) message(note string, requestFrom types.JID) *waProto.RequestPaymentMessage {
+	return &waProto.RequestPaymentMessage{
+		NoteMessage:         &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String(note)}},
+		CurrencyCodeIso4217: proto.String(p.Currency),
+		Amount1000:          proto.Uint64(p.Amount1000),
+		RequestFrom:         proto.String(requestFrom.String()),
+		ExpiryTimestamp:     proto.Int64(p.Expiry.Unix()),
+		Amount: &waProto.Money{
+			Value:        proto.Int64(int64(p.Amount1000)),
+			Offset:       proto.Uint32(1000),
+			CurrencyCode: proto.String(p.Currency),
+		},
+	}
+}
+
+// formatAmount renders an amount in thousandths as e.g. "12.50 INR"
+func formatAmount(amount1000 int64, currency string) string {
+	return fmt.Sprintf("%.2f %s", float64(amount1000)/1000, currency)
+}
+
+// requestAmount returns the amount in thousandths and the currency of a payment request.
+// Newer clients send a Money value with its own offset; older ones only amount1000.
+func requestAmount(req *waProto.RequestPaymentMessage) (int64, string) {
+	if money := req.GetAmount(); money != nil && money.GetOffset() > 0 {
+		return money.GetValue() * 1000 / int64(money.GetOffset()), money.GetCurrencyCode()
+	}
+	return int64(req.GetAmount1000()), req.GetCurrencyCodeIso4217()
+}
+
+// paymentSummary describes a payment or order message for the chat history, or returns ""
+func paymentSummary(msg *waProto.Message) string {
+	if req := msg.GetRequestPaymentMessage(); req != nil {
+		amount, currency := requestAmount(req)
+		summary := "Payment request: " + formatAmount(amount, currency)
+		if note := extractTextContent(req.GetNoteMessage()); note != "" {
+			summary += " - " + note
+		}
+		return summary
+	}
+	if payment := msg.GetSendPaymentMessage(); payment != nil {
+		summary := "Payment sent"
+		if note := extractTextContent(payment.GetNoteMessage()); note != "" {
+			summary += " - " + note
+		}
+		return summary
+	}
+	if msg.GetDeclinePaymentRequestMessage() != nil {
+		return "Payment request declined"
+	}
+	if msg.GetCancelPaymentRequestMessage() != nil {
+		return "Payment request cancelled"
+	}
+	if order := msg.GetOrderMessage(); order != nil {
+		summary := fmt.Sprintf("Order %s: %d items", order.GetOrderID(), order.GetItemCount())
+		if title := order.GetOrderTitle(); title != "" {
+			summary = fmt.Sprintf("Order %s: %s (%d items)", order.GetOrderID(), title, order.GetItemCount())
+		}
+		if total := order.GetTotalAmount1000(); total > 0 {
+			summary += ", " + formatAmount(total, order.GetTotalCurrencyCode())
+		}
+		return summary
+	}
+	return ""
+}
+
+// publishPaymentEvent publishes payment requests, payments and orders in a message as structured events
+func publishPaymentEvent(msg *events.Message, chatJID, sender string) {
+	data := map[string]interface{}{
+		"id":         msg.Info.ID,
+		"chat_jid":   chatJID,
+		"sender":     sender,
+		"is_from_me": msg.Info.IsFromMe,
+	}
+
+	var eventType string
+	switch {
+	case msg.Message.GetRequestPaymentMessage() != nil:
+		req := msg.Message.GetRequestPaymentMessage()
+		amount, currency := requestAmount(req)
+		eventType = EventPaymentRequested
+		data["amount"] = float64(amount) / 1000
+		data["currency"] = currency
+		data["note"] = extractTextContent(req.GetNoteMessage())
+		data["request_from"] = req.GetRequestFrom()
+		if expiry := req.GetExpiryTimestamp(); expiry > 0 {
+			data["expires_at"] = time.Unix(expiry, 0).UTC()
+		}
+
+	case msg.Message.GetSendPaymentMessage() != nil:
+		payment := msg.Message.GetSendPaymentMessage()
+		eventType = EventPaymentCompleted
+		data["request_id"] = payment.GetRequestMessageKey().GetID()
+		data["note"] = extractTextContent(payment.GetNoteMessage())
+
+	case msg.Message.GetDeclinePaymentRequestMessage() != nil:
+		eventType = EventPaymentDeclined
+		data["request_id"] = msg.Message.GetDeclinePaymentRequestMessage().GetKey().GetID()
+
+	case msg.Message.GetCancelPaymentRequestMessage() != nil:
+		eventType = EventPaymentCancelled
+		data["request_id"] = msg.Message.GetCancelPaymentRequestMessage().GetKey().GetID()
+
+	case msg.Message.GetOrderMessage() != nil:
+		order := msg.Message.GetOrderMessage()
+		eventType = EventOrderReceived
+		data["order_id"] = order.GetOrderID()
+		data["title"] = order.GetOrderTitle()
+		data["item_count"] = order.GetItemCount()
+		data["status"] = strings.ToLower(order.GetStatus().String())
+		data["amount"] = float64(order.GetTotalAmount1000()) / 1000
+		data["currency"] = order.GetTotalCurrencyCode()
+		data["note"] = order.GetMessage()
+		data["seller"] = order.GetSellerJID()
+
+	default:
+		return
+	}
+
+	publishEvent(eventType, chatJID, msg.Info.Timestamp, data)
+}
+
+// registerPaymentRoutes registers /api/v1/payments/request
+func registerPaymentRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	handleAPI("/payments/request", leaderOnly(refuseDuringMaintenance(sendUnlocked(meteredSend(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// WhatsApp payments only exist in some countries, so the account owner opts in
+		if !getEnvBool("PAYMENTS_ENABLED", false) {
+			http.Error(w, "Payment requests are disabled; set PAYMENTS_ENABLED=true if the account supports WhatsApp payments", http.StatusForbidden)
+			return
+		}
+
+		var req PaymentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// Validate request
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(req.Recipient, "@"+types.GroupServer) {
+			http.Error(w, "Payments can only be requested from individual contacts", http.StatusBadRequest)
+			return
+		}
+		req.Currency = strings.ToUpper(req.Currency)
+		if !currencyPattern.MatchString(req.Currency) {
+			http.Error(w, "currency must be an ISO 4217 code such as INR or BRL", http.StatusBadRequest)
+			return
+		}
+		amount1000 := math.Round(req.Amount * 1000)
+		if amount1000 <= 0 || amount1000 > math.MaxInt64 {
+			http.Error(w, "amount must be positive", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInHours == 0 {
+			req.ExpiresInHours = defaultPaymentExpiryHours
+		}
+		if req.ExpiresInHours < 0 || req.ExpiresInHours > maxPaymentExpiryHours {
+			http.Error(w, fmt.Sprintf("expires_in_hours must be between 1 and %d", maxPaymentExpiryHours), http.StatusBadRequest)
+			return
+		}
+		if len(req.ClientRef) > maxClientRefLen {
+			http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+			return
+		}
+		if len(req.Agent) > maxAgentLen {
+			http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+			return
+		}
+
+		opts := SendOptions{
+			ClientRef: req.ClientRef,
+			Agent:     req.Agent,
+			APIKey:    usageSubject(r),
+			Payment: &PaymentDetails{
+				Amount1000: uint64(amount1000),
+				Currency:   req.Currency,
+				Expiry:     time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour),
+			},
+		}
+		success, message, messageID, code := sendWhatsAppMessage(client, req.Recipient, req.Note, "", opts, messageStore)
+		if success {
+			usageMeter.RecordSend(r, req.Recipient, "")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   success,
+			Message:   message,
+			MessageID: messageID,
+			ClientRef: req.ClientRef,
+			Agent:     req.Agent,
+		}.withError(code))
+	})))))
+}
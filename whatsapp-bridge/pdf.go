@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size and layout in PDF points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+// helveticaWidths holds the Helvetica glyph widths (per 1000 units) for ASCII 32-126
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// winAnsiSpecials maps the non-Latin-1 characters available in WinAnsiEncoding
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfImage is a JPEG embedded as an image XObject
+type pdfImage struct {
+	data   []byte
+	width  int
+	height int
+}
+
+// PDFDocument is a minimal PDF writer for text and JPEG images using the standard
+// Helvetica fonts. Characters outside WinAnsiEncoding are rendered as "?".
+type PDFDocument struct {
+	pages  []*bytes.Buffer
+	images []pdfImage
+	y      float64
+}
+
+// NewPDFDocument creates a document with one empty page
+func NewPDFDocument() *PDFDocument {
+	doc := &PDFDocument{}
+	doc.AddPage()
+	return doc
+}
+
+// AddPage starts a new page and moves the cursor to the top margin
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// ensureSpace starts a new page if fewer than height points remain on the current one
+func (d *PDFDocument) ensureSpace(height float64) {
+	if d.y-height < pdfMargin {
+		d.AddPage()
+	}
+}
+
+// TextWidth returns the rendered width of a string in points
+func (d *PDFDocument) TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// WriteLine writes a single line of text at the cursor and advances it
+func (d *PDFDocument) WriteLine(text string, size float64, bold bool, gray float64) {
+	leading := size * 1.3
+	d.ensureSpace(leading)
+	d.y -= size
+
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.pages[len(d.pages)-1], "BT %.3f g /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		gray, font, size, pdfMargin, d.y, pdfEscape(text))
+
+	d.y -= leading - size
+}
+
+// WriteParagraph writes word-wrapped text across the printable width
+func (d *PDFDocument) WriteParagraph(text string, size float64) {
+	maxWidth := pdfPageWidth - 2*pdfMargin
+	for _, line := range d.wrap(text, size, maxWidth) {
+		d.WriteLine(line, size, false, 0)
+	}
+}
+
+// wrap breaks text into lines that fit maxWidth, splitting overlong words
+func (d *PDFDocument) wrap(text string, size, maxWidth float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		current := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if current != "" {
+				candidate = current + " " + word
+			}
+			if d.TextWidth(candidate, size) <= maxWidth {
+				current = candidate
+				continue
+			}
+			if current != "" {
+				lines = append(lines, current)
+			}
+
+			// Break words that are wider than a whole line (URLs, long numbers)
+			current = ""
+			for _, r := range word {
+				if d.TextWidth(current+string(r), size) > maxWidth {
+					lines = append(lines, current)
+					current = ""
+				}
+				current += string(r)
+			}
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// AddJPEG places a JPEG image at the cursor, scaled to the given display width
+func (d *PDFDocument) AddJPEG(data []byte, width, height int, displayWidth float64) {
+	displayHeight := displayWidth * float64(height) / float64(width)
+	d.ensureSpace(displayHeight + 4)
+	d.y -= displayHeight
+
+	d.images = append(d.images, pdfImage{data: data, width: width, height: height})
+	fmt.Fprintf(d.pages[len(d.pages)-1], "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n",
+		displayWidth, displayHeight, pdfMargin, d.y, len(d.images))
+
+	d.y -= 4
+}
+
+// Space moves the cursor down
+func (d *PDFDocument) Space(height float64) {
+	d.y -= height
+}
+
+// WriteTo serializes the document, adding "Page X of Y" footers
+func (d *PDFDocument) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	// Object numbers: 1 catalog, 2 page tree, 3-4 fonts, then images, then page/content pairs
+	firstImage := 5
+	firstPage := firstImage + len(d.images)
+
+	startObject := func() {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	startObject()
+	out.WriteString("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	startObject()
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	fmt.Fprintf(&out, "<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(d.pages))
+
+	startObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\nendobj\n")
+	startObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>\nendobj\n")
+
+	var xobjects strings.Builder
+	for i, img := range d.images {
+		startObject()
+		fmt.Fprintf(&out, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+			img.width, img.height, len(img.data))
+		out.Write(img.data)
+		out.WriteString("\nendstream\nendobj\n")
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i+1, firstImage+i)
+	}
+
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		fmt.Fprintf(page, "BT 0.5 g /F1 8 Tf %.2f %.2f Td (%s) Tj ET\n",
+			pdfPageWidth-pdfMargin-d.TextWidth(footer, 8), pdfMargin/2, footer)
+
+		startObject()
+		fmt.Fprintf(&out, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s>> >> /Contents %d 0 R >>\nendobj\n",
+			pdfPageWidth, pdfPageHeight, xobjects.String(), firstPage+2*i+1)
+
+		startObject()
+		fmt.Fprintf(&out, "<< /Length %d >>\nstream\n", page.Len())
+		out.Write(page.Bytes())
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	xrefOffset := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+
+	n, err := w.Write(out.Bytes())
+	return int64(n), err
+}
+
+// pdfEscape converts text to WinAnsiEncoding and escapes it for a PDF string literal
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiSpecials[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiSpecials[r])
+		case r == '\t':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Presence statuses reported by the API
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+	PresenceUnknown = "unknown"
+)
+
+// presenceWait is how long a first lookup waits for WhatsApp to report the contact's presence
+const presenceWait = 3 * time.Second
+
+// Chat states sent by /api/v1/chats/{jid}/typing
+const (
+	ChatStateComposing = "composing"
+	ChatStateRecording = "recording"
+	ChatStatePaused    = "paused"
+)
+
+// APIPresence is the online status of a contact; LastSeen is nil when the contact hides it
+type APIPresence struct {
+	JID       string     `json:"jid"`
+	Status    string     `json:"status"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Subscribed is set while the bridge receives the contact's presence updates
+	Subscribed bool `json:"subscribed"`
+}
+
+// OwnPresence is the account's own online status, set by POST /api/v1/presence
+type OwnPresence struct {
+	Status string `json:"status"`
+}
+
+// ChatStateRequest is the body of POST /api/v1/chats/{jid}/typing
+type ChatStateRequest struct {
+	State string `json:"state"`
+}
+
+// PresenceTracker subscribes to contacts' presence on request and remembers the last update.
+// WhatsApp forgets subscriptions when the connection drops, so they are renewed on reconnect;
+// subscriptions made through /api/v1/presence/{jid}/subscribe are stored and outlive restarts.
+type PresenceTracker struct {
+	presences  map[string]APIPresence
+	subscribed map[string]bool
+	waiting    map[string][]chan struct{}
+	available  bool
+	// chosen is the status last set through the API; empty means the bridge goes online only
+	// when a subscription needs it
+	chosen       string
+	messageStore *MessageStore
+	logger       waLog.Logger
+	mutex        sync.Mutex
+}
+
+// presenceTracker is the process-wide presence state
+var presenceTracker = &PresenceTracker{
+	presences:  make(map[string]APIPresence),
+	subscribed: make(map[string]bool),
+	waiting:    make(map[string][]chan struct{}),
+}
+
+// Attach stores presence updates and subscriptions in the message store
+func (t *PresenceTracker) Attach(messageStore *MessageStore, logger waLog.Logger) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.messageStore = messageStore
+	t.logger = logger
+}
+
+// Get returns the last known presence of a contact, from before a restart if need be
+func (t *PresenceTracker) Get(jid types.JID) APIPresence {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := jid.String()
+	if presence, ok := t.presences[key]; ok {
+		presence.Subscribed = t.subscribed[key]
+		return presence
+	}
+	if t.messageStore != nil {
+		presence, err := t.messageStore.GetPresence(key)
+		if err != nil {
+			t.logger.Warnf("Failed to load presence of %s: %v", key, err)
+		} else if presence != nil {
+			presence.Subscribed = t.subscribed[key]
+			return *presence
+		}
+	}
+	return APIPresence{JID: key, Status: PresenceUnknown, Subscribed: t.subscribed[key]}
+}
+
+// Own returns the account's online status as far as the bridge set it
+func (t *PresenceTracker) Own() OwnPresence {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.available {
+		return OwnPresence{Status: PresenceOnline}
+	}
+	return OwnPresence{Status: PresenceOffline}
+}
+
+// SetOwn marks the account online or offline. The choice holds across reconnects, and while
+// offline the bridge no longer goes online for subscriptions, so their updates may stop.
+func (t *PresenceTracker) SetOwn(client *whatsmeow.Client, status string) error {
+	presence := types.PresenceAvailable
+	if status == PresenceOffline {
+		presence = types.PresenceUnavailable
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := client.SendPresence(presence); err != nil {
+		return err
+	}
+	t.available = status == PresenceOnline
+	t.chosen = status
+	return nil
+}
+
+// Subscribe asks WhatsApp for a contact's presence updates and waits briefly for the first one
+func (t *PresenceTracker) Subscribe(client *whatsmeow.Client, jid types.JID) (APIPresence, error) {
+	key := jid.String()
+
+	t.mutex.Lock()
+	if t.subscribed[key] {
+		t.mutex.Unlock()
+		return t.Get(jid), nil
+	}
+
+	// The servers only send presence to clients that are online themselves
+	if !t.available && t.chosen != PresenceOffline {
+		if err := client.SendPresence(types.PresenceAvailable); err != nil {
+			t.mutex.Unlock()
+			return APIPresence{}, fmt.Errorf("failed to go online: %v", err)
+		}
+		t.available = true
+	}
+
+	if err := client.SubscribePresence(jid); err != nil {
+		t.mutex.Unlock()
+		return APIPresence{}, err
+	}
+	t.subscribed[key] = true
+
+	updated := make(chan struct{})
+	t.waiting[key] = append(t.waiting[key], updated)
+	t.mutex.Unlock()
+
+	select {
+	case <-updated:
+	case <-time.After(presenceWait):
+	}
+	return t.Get(jid), nil
+}
+
+// Unsubscribe stops renewing a stored subscription. WhatsApp has no way to cancel one, so
+// updates keep arriving until the connection drops.
+func (t *PresenceTracker) Unsubscribe(jid types.JID) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.messageStore != nil {
+		if err := t.messageStore.SetPresenceSubscribed(jid.String(), false); err != nil {
+			return err
+		}
+	}
+	delete(t.subscribed, jid.String())
+	return nil
+}
+
+// Forget drops the remembered presence and subscription of an erased contact
+func (t *PresenceTracker) Forget(jid string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.presences, jid)
+	delete(t.subscribed, jid)
+}
+
+// Reset forgets subscriptions after a reconnect and renews them, along with the stored ones
+func (t *PresenceTracker) Reset(client *whatsmeow.Client, logger waLog.Logger) {
+	t.mutex.Lock()
+	jids := make([]string, 0, len(t.subscribed))
+	for jid := range t.subscribed {
+		jids = append(jids, jid)
+	}
+	if t.messageStore != nil {
+		stored, err := t.messageStore.ListPresenceSubscriptions()
+		if err != nil {
+			logger.Warnf("Failed to load presence subscriptions: %v", err)
+		}
+		for _, jid := range stored {
+			if !t.subscribed[jid] {
+				jids = append(jids, jid)
+			}
+		}
+	}
+	chosen := t.chosen
+	t.available = false
+	t.mutex.Unlock()
+
+	if len(jids) == 0 && chosen != PresenceOnline {
+		return
+	}
+
+	if chosen != PresenceOffline {
+		if err := client.SendPresence(types.PresenceAvailable); err != nil {
+			logger.Warnf("Failed to go online for presence updates: %v", err)
+			t.mutex.Lock()
+			t.subscribed = make(map[string]bool)
+			t.mutex.Unlock()
+			return
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.available = chosen != PresenceOffline
+	for _, key := range jids {
+		jid, err := types.ParseJID(key)
+		if err == nil {
+			err = client.SubscribePresence(jid)
+		}
+		if err != nil {
+			logger.Warnf("Failed to renew presence subscription for %s: %v", key, err)
+			delete(t.subscribed, key)
+			continue
+		}
+		t.subscribed[key] = true
+	}
+}
+
+// handlePresence records a presence update and publishes it on the event bus
+func handlePresence(evt *events.Presence) {
+	jid := evt.From.ToNonAD()
+	now := time.Now().UTC()
+	presence := APIPresence{JID: jid.String(), Status: PresenceOnline, UpdatedAt: &now}
+	if evt.Unavailable {
+		presence.Status = PresenceOffline
+		if !evt.LastSeen.IsZero() {
+			lastSeen := evt.LastSeen.UTC()
+			presence.LastSeen = &lastSeen
+		}
+	}
+
+	presenceTracker.mutex.Lock()
+	previous, known := presenceTracker.presences[presence.JID]
+	presenceTracker.presences[presence.JID] = presence
+	for _, updated := range presenceTracker.waiting[presence.JID] {
+		close(updated)
+	}
+	delete(presenceTracker.waiting, presence.JID)
+	messageStore, logger := presenceTracker.messageStore, presenceTracker.logger
+	presenceTracker.mutex.Unlock()
+
+	// Stored so last-seen times survive a restart
+	if messageStore != nil {
+		if err := messageStore.SavePresence(presence); err != nil {
+			logger.Warnf("Failed to store presence of %s: %v", presence.JID, err)
+		}
+	}
+
+	// The servers repeat the current status on subscribe; only changes are events
+	if known && previous.Status == presence.Status {
+		return
+	}
+
+	data := map[string]interface{}{
+		"jid":    presence.JID,
+		"status": presence.Status,
+	}
+	if presence.LastSeen != nil {
+		data["last_seen"] = *presence.LastSeen
+	}
+	publishEvent(EventContactPresenceChanged, presence.JID, now, data)
+}
+
+// presenceIn converts a presence's times to the caller's timezone
+func presenceIn(presence APIPresence, loc *time.Location) APIPresence {
+	if presence.LastSeen != nil {
+		lastSeen := presence.LastSeen.In(loc)
+		presence.LastSeen = &lastSeen
+	}
+	if presence.UpdatedAt != nil {
+		updatedAt := presence.UpdatedAt.In(loc)
+		presence.UpdatedAt = &updatedAt
+	}
+	return presence
+}
+
+// GetPresence returns the stored presence of a contact, or nil if none was ever received
+func (store *MessageStore) GetPresence(jid string) (*APIPresence, error) {
+	query := "SELECT status, last_seen, updated_at FROM contact_presence WHERE jid = ?"
+	if store.isPostgres {
+		query = "SELECT status, last_seen, updated_at FROM contact_presence WHERE jid = $1"
+	}
+	var lastSeen, updatedAt sql.NullTime
+	presence := APIPresence{JID: jid}
+	err := store.db.QueryRow(query, jid).Scan(&presence.Status, &lastSeen, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		presence.LastSeen = &lastSeen.Time
+	}
+	if updatedAt.Valid {
+		presence.UpdatedAt = &updatedAt.Time
+	}
+	return &presence, nil
+}
+
+// SavePresence stores the latest presence update of a contact
+func (store *MessageStore) SavePresence(presence APIPresence) error {
+	query := `INSERT INTO contact_presence (jid, status, last_seen, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET status = excluded.status, last_seen = excluded.last_seen, updated_at = excluded.updated_at`
+	if store.isPostgres {
+		query = `INSERT INTO contact_presence (jid, status, last_seen, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jid) DO UPDATE SET status = EXCLUDED.status, last_seen = EXCLUDED.last_seen, updated_at = EXCLUDED.updated_at`
+	}
+	_, err := store.db.Exec(query, presence.JID, presence.Status, presence.LastSeen, presence.UpdatedAt)
+	return err
+}
+
+// SetPresenceSubscribed stores whether a contact's presence is renewed on every connect
+func (store *MessageStore) SetPresenceSubscribed(jid string, subscribed bool) error {
+	query := `INSERT INTO contact_presence (jid, status, subscribed) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET subscribed = excluded.subscribed`
+	if store.isPostgres {
+		query = `INSERT INTO contact_presence (jid, status, subscribed) VALUES ($1, $2, $3)
+		ON CONFLICT (jid) DO UPDATE SET subscribed = EXCLUDED.subscribed`
+	}
+	_, err := store.db.Exec(query, jid, PresenceUnknown, subscribed)
+	return err
+}
+
+// ListPresenceSubscriptions returns the contacts whose presence is renewed on every connect
+func (store *MessageStore) ListPresenceSubscriptions() ([]string, error) {
+	rows, err := store.db.Query("SELECT jid FROM contact_presence WHERE subscribed = TRUE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jids := []string{}
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// parseContactJID accepts the JID of a single WhatsApp user
+func parseContactJID(value string) (types.JID, bool) {
+	jid, err := types.ParseJID(value)
+	return jid, err == nil && jid.Server == types.DefaultUserServer
+}
+
+// subscribePresence subscribes to a contact and writes the first update in the caller's timezone
+func subscribePresence(w http.ResponseWriter, r *http.Request, client *whatsmeow.Client, jid types.JID, loc *time.Location) {
+	if !client.IsConnected() {
+		http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+		return
+	}
+
+	presence, err := presenceTracker.Subscribe(client, jid)
+	if errors.Is(err, whatsmeow.ErrNotConnected) {
+		http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to subscribe to presence: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presenceIn(presence, loc))
+}
+
+// registerPresenceRoutes registers /api/v1/contacts/{jid}/presence, /api/v1/presence,
+// /api/v1/presence/{jid}[/subscribe] and /api/v1/chats/{jid}/typing
+func registerPresenceRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	registerContactRoute("presence", func(w http.ResponseWriter, r *http.Request, contactJID string) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		jid, ok := parseContactJID(contactJID)
+		if !ok {
+			http.Error(w, "Invalid contact JID", http.StatusBadRequest)
+			return
+		}
+		subscribePresence(w, r, client, jid, loc)
+	})
+
+	// The account's own online status
+	handleAPI("/presence", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(presenceTracker.Own())
+
+		case http.MethodPost:
+			var req OwnPresence
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.Status != PresenceOnline && req.Status != PresenceOffline {
+				http.Error(w, "status must be online or offline", http.StatusBadRequest)
+				return
+			}
+			if !client.IsConnected() {
+				http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+				return
+			}
+			if err := presenceTracker.SetOwn(client, req.Status); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to set presence: %v", err), http.StatusBadGateway)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(presenceTracker.Own())
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// Stored presence and last-seen time of a contact, and lasting subscriptions
+	handleAPI("/presence/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(apiRoute(r), "/presence/")
+		contactJID, resource, _ := strings.Cut(path, "/")
+		jid, ok := parseContactJID(contactJID)
+		if !ok {
+			http.Error(w, "Invalid contact JID", http.StatusBadRequest)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case resource == "" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(presenceIn(presenceTracker.Get(jid), loc))
+
+		case resource == "subscribe" && r.Method == http.MethodPost:
+			leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+				if err := messageStore.SetPresenceSubscribed(jid.String(), true); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to store subscription: %v", err), http.StatusInternalServerError)
+					return
+				}
+				subscribePresence(w, r, client, jid, loc)
+			})(w, r)
+
+		case resource == "subscribe" && r.Method == http.MethodDelete:
+			if err := presenceTracker.Unsubscribe(jid); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to remove subscription: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case resource == "" || resource == "subscribe":
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// Typing and recording indicators go out on the socket, which only the leader holds
+	registerChatRoute("typing", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+			serveChatState(w, r, client, chatJID)
+		})(w, r)
+	})
+}
+
+// serveChatState handles POST /api/v1/chats/{jid}/typing
+func serveChatState(w http.ResponseWriter, r *http.Request, client *whatsmeow.Client, chatJID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if receiveOnlyMode {
+		w.Header().Set("X-Bridge-Mode", "receive-only")
+		http.Error(w, "The bridge is in receive-only mode; sending is disabled", http.StatusForbidden)
+		return
+	}
+
+	var req ChatStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	state, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+	switch req.State {
+	case ChatStateComposing:
+	case ChatStateRecording:
+		media = types.ChatPresenceMediaAudio
+	case ChatStatePaused:
+		state = types.ChatPresencePaused
+	default:
+		http.Error(w, "state must be composing, recording or paused", http.StatusBadRequest)
+		return
+	}
+
+	jid, err := types.ParseJID(chatJID)
+	if err != nil || (jid.Server != types.DefaultUserServer && jid.Server != types.GroupServer) {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	if !client.IsConnected() {
+		http.Error(w, "Not connected to WhatsApp", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := client.SendChatPresence(jid, state, media); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send chat state: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"chat":    jid.String(),
+		"state":   req.State,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Phone number redaction modes
+const (
+	RedactPhonesNone     = "none"
+	RedactPhonesHash     = "hash"
+	RedactPhonesTruncate = "truncate"
+)
+
+// Redactor hides personal data before it leaves the bridge through an output sink
+// (logs, webhooks). Each sink has its own Redactor so operators can, for example,
+// keep full payloads for a trusted webhook while scrubbing logs shipped to a vendor.
+type Redactor struct {
+	phones string
+	bodies bool
+	salt   string
+}
+
+// Redactors for each output sink, configured at startup
+var (
+	logRedactor     = &Redactor{phones: RedactPhonesNone}
+	webhookRedactor = &Redactor{phones: RedactPhonesNone}
+	cdcRedactor     = &Redactor{phones: RedactPhonesNone}
+)
+
+// NewRedactorFromEnv builds the redactor for a sink from {SINK}_REDACT_PHONES and
+// {SINK}_REDACT_BODIES. PRIVACY_MODE=true changes the defaults to hashed phone
+// numbers and redacted bodies for every sink.
+func NewRedactorFromEnv(sink string) (*Redactor, error) {
+	privacyMode := getEnvBool("PRIVACY_MODE", false)
+
+	defaultPhones := RedactPhonesNone
+	if privacyMode {
+		defaultPhones = RedactPhonesHash
+	}
+
+	prefix := strings.ToUpper(sink)
+	phones := strings.ToLower(os.Getenv(prefix + "_REDACT_PHONES"))
+	if phones == "" {
+		phones = defaultPhones
+	}
+	switch phones {
+	case RedactPhonesNone, RedactPhonesHash, RedactPhonesTruncate:
+	default:
+		return nil, fmt.Errorf("invalid %s_REDACT_PHONES: %s (expected none, hash or truncate)", prefix, phones)
+	}
+
+	return &Redactor{
+		phones: phones,
+		bodies: getEnvBool(prefix+"_REDACT_BODIES", privacyMode),
+		salt:   os.Getenv("PRIVACY_HASH_SALT"),
+	}, nil
+}
+
+// initRedactors configures the redactors for all sinks
+func initRedactors() error {
+	var err error
+	if logRedactor, err = NewRedactorFromEnv("log"); err != nil {
+		return err
+	}
+	if webhookRedactor, err = NewRedactorFromEnv("webhook"); err != nil {
+		return err
+	}
+	if cdcRedactor, err = NewRedactorFromEnv("cdc"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Phone redacts a phone number or the user part of a JID, keeping the server and device
+func (r *Redactor) Phone(value string) string {
+	if r == nil || r.phones == RedactPhonesNone || value == "" {
+		return value
+	}
+
+	user, rest := value, ""
+	if i := strings.IndexAny(value, ":@"); i >= 0 {
+		user, rest = value[:i], value[i:]
+	}
+
+	// Group IDs and other non-phone identifiers are left alone
+	if strings.HasSuffix(rest, "@g.us") || strings.HasSuffix(rest, "@broadcast") || strings.HasSuffix(rest, "@newsletter") {
+		return value
+	}
+
+	digits := strings.TrimPrefix(user, "+")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return value
+	}
+
+	switch r.phones {
+	case RedactPhonesTruncate:
+		if len(digits) <= 4 {
+			return "****" + rest
+		}
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:] + rest
+	default:
+		// Salted so hashes can't be reversed by hashing every possible phone number
+		sum := sha256.Sum256([]byte(r.salt + digits))
+		return "#" + hex.EncodeToString(sum[:])[:12] + rest
+	}
+}
+
+// Body redacts message content, keeping only its length
+func (r *Redactor) Body(value string) string {
+	if r == nil || !r.bodies || value == "" {
+		return value
+	}
+	return fmt.Sprintf("[redacted %d chars]", len([]rune(value)))
+}
+
+// Name redacts a contact or chat display name along with message bodies
+func (r *Redactor) Name(value string) string {
+	if r == nil || !r.bodies || value == "" {
+		return value
+	}
+	return "[redacted name]"
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Parts of the WhatsApp connection that WHATSAPP_PROXY_SCOPE can route through the proxy
+const (
+	ProxyScopeAll       = "all"
+	ProxyScopeWebsocket = "websocket"
+	ProxyScopeMedia     = "media"
+)
+
+// configureProxy routes the WhatsApp websocket and media transfers through WHATSAPP_PROXY,
+// for networks where WhatsApp can't be reached directly. Other outgoing requests, such as
+// webhooks, use the standard HTTPS_PROXY variables instead.
+func configureProxy(client *whatsmeow.Client, logger waLog.Logger) error {
+	address := os.Getenv("WHATSAPP_PROXY")
+	if address == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("WHATSAPP_PROXY must be a URL such as socks5://host:1080 or http://host:3128")
+	}
+	switch parsed.Scheme {
+	case "socks5", "http", "https":
+	default:
+		return fmt.Errorf("WHATSAPP_PROXY scheme must be socks5, http or https, not %q", parsed.Scheme)
+	}
+
+	scope := os.Getenv("WHATSAPP_PROXY_SCOPE")
+	if scope == "" {
+		scope = ProxyScopeAll
+	}
+	var opts whatsmeow.SetProxyOptions
+	switch scope {
+	case ProxyScopeAll:
+	case ProxyScopeWebsocket:
+		opts.NoMedia = true
+	case ProxyScopeMedia:
+		// whatsmeow dials SOCKS media connections through the websocket's proxy
+		if parsed.Scheme == "socks5" {
+			return fmt.Errorf("a socks5 WHATSAPP_PROXY can't be limited to media; use an http proxy or WHATSAPP_PROXY_SCOPE=all")
+		}
+		opts.NoWebsocket = true
+	default:
+		return fmt.Errorf("WHATSAPP_PROXY_SCOPE must be %s, %s or %s", ProxyScopeAll, ProxyScopeWebsocket, ProxyScopeMedia)
+	}
+
+	if err := client.SetProxyAddress(address, opts); err != nil {
+		return fmt.Errorf("failed to set WhatsApp proxy: %v", err)
+	}
+	logger.Infof("Connecting to WhatsApp through proxy %s (%s)", parsed.Redacted(), scope)
+	return nil
+}
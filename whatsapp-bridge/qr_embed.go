@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// embedSettings reads QR_EMBED_TOKENS and QR_EMBED_ORIGINS. Tokens are case-sensitive, so
+// they aren't read with splitEnvList.
+func embedSettings() (tokens, origins []string) {
+	for _, token := range strings.Split(os.Getenv("QR_EMBED_TOKENS"), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	for _, origin := range splitEnvList("QR_EMBED_ORIGINS") {
+		origins = append(origins, strings.TrimSuffix(origin, "/"))
+	}
+	return tokens, origins
+}
+
+// embedAuthorized checks the ?token= of an embed request: one of QR_EMBED_TOKENS, or a
+// login session token, since third-party cookies don't reach an iframe. Without Supabase the
+// session token is a local dashboard user's.
+func (q *QRWebServer) embedAuthorized(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	for _, allowed := range q.embedTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return true
+		}
+	}
+	if token == "" {
+		token = q.getSessionFromRequest(r)
+	}
+	if q.supabaseClient == nil {
+		return localAuth.TokenUser(token) != nil
+	}
+	return q.validateSession(token)
+}
+
+// embedAuthMiddleware wraps the widget's data endpoints, answering 401 rather than
+// redirecting to a login page the iframe couldn't show
+func (q *QRWebServer) embedAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !q.embedAuthorized(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ServeQREmbed serves the pairing widget for iframes. The page holds no data itself; it
+// polls /qr/embed/status and reports changes to the parent window with postMessage.
+func (q *QRWebServer) ServeQREmbed(w http.ResponseWriter, r *http.Request) {
+	// Messages go to the embedding origin only, once QR_EMBED_ORIGINS names the allowed ones
+	targetOrigin := strings.TrimSuffix(strings.ToLower(r.URL.Query().Get("origin")), "/")
+	if len(q.embedOrigins) > 0 {
+		if targetOrigin == "" {
+			targetOrigin = q.embedOrigins[0]
+		} else if !containsString(q.embedOrigins, targetOrigin) {
+			http.Error(w, "Origin not allowed", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", "frame-ancestors "+strings.Join(q.embedOrigins, " "))
+	} else if targetOrigin == "" {
+		targetOrigin = "*"
+	}
+
+	// The token is in the URL, so don't hand it to the QR image or anything else as a referrer
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	pageTemplates.Render(w, "qr_embed", struct{ TargetOrigin string }{targetOrigin})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// reactionLookupBatch keeps IN lists under SQLite's bound parameter limit
+const reactionLookupBatch = 500
+
+// maxReactionRunes allows emoji built from several code points, such as flags and skin tones
+const maxReactionRunes = 10
+
+// ReactRequest represents the request body for reacting to a message
+type ReactRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	// Emoji replaces the account's reaction; empty removes it
+	Emoji string `json:"emoji"`
+}
+
+// MessageReactions summarizes the reactions to one message
+type MessageReactions struct {
+	// Counts maps each emoji to the number of people who reacted with it
+	Counts map[string]int
+	// Mine is the emoji this account reacted with, if any
+	Mine string
+}
+
+// StoreReaction records a reaction to a message; an empty emoji removes the sender's reaction
+func (store *MessageStore) StoreReaction(chatJID, messageID, sender, emoji string, isFromMe bool, timestamp time.Time) error {
+	if emoji == "" {
+		query := "DELETE FROM message_reactions WHERE chat_jid = ? AND message_id = ? AND sender = ?"
+		if store.isPostgres {
+			query = "DELETE FROM message_reactions WHERE chat_jid = $1 AND message_id = $2 AND sender = $3"
+		}
+		_, err := store.db.Exec(query, chatJID, messageID, sender)
+		return err
+	}
+
+	// A sender has one reaction per message; reacting again replaces it unless it is older
+	query := `INSERT INTO message_reactions (chat_jid, message_id, sender, emoji, is_from_me, timestamp) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_jid, message_id, sender) DO UPDATE SET emoji = excluded.emoji, is_from_me = excluded.is_from_me, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= message_reactions.timestamp`
+	if store.isPostgres {
+		query = `INSERT INTO message_reactions (chat_jid, message_id, sender, emoji, is_from_me, timestamp) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (chat_jid, message_id, sender) DO UPDATE SET emoji = EXCLUDED.emoji, is_from_me = EXCLUDED.is_from_me, timestamp = EXCLUDED.timestamp
+		WHERE EXCLUDED.timestamp >= message_reactions.timestamp`
+	}
+	_, err := store.db.Exec(query, chatJID, messageID, sender, emoji, isFromMe, timestamp.UTC())
+	return err
+}
+
+// GetReactions returns the reactions to the given messages of a chat, keyed by message ID.
+// Messages without reactions are left out.
+func (store *MessageStore) GetReactions(chatJID string, messageIDs []string) (map[string]*MessageReactions, error) {
+	reactions := map[string]*MessageReactions{}
+	for start := 0; start < len(messageIDs); start += reactionLookupBatch {
+		end := min(start+reactionLookupBatch, len(messageIDs))
+		batch := messageIDs[start:end]
+
+		placeholders := make([]string, len(batch))
+		args := []interface{}{chatJID}
+		for i, id := range batch {
+			placeholders[i] = "?"
+			if store.isPostgres {
+				placeholders[i] = fmt.Sprintf("$%d", i+2)
+			}
+			args = append(args, id)
+		}
+		query := "SELECT message_id, emoji, is_from_me FROM message_reactions WHERE chat_jid = ? AND message_id IN (%s)"
+		if store.isPostgres {
+			query = "SELECT message_id, emoji, is_from_me FROM message_reactions WHERE chat_jid = $1 AND message_id IN (%s)"
+		}
+
+		rows, err := store.db.Query(fmt.Sprintf(query, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var messageID, emoji string
+			var isFromMe bool
+			if err := rows.Scan(&messageID, &emoji, &isFromMe); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			summary := reactions[messageID]
+			if summary == nil {
+				summary = &MessageReactions{Counts: map[string]int{}}
+				reactions[messageID] = summary
+			}
+			summary.Counts[emoji]++
+			if isFromMe {
+				summary.Mine = emoji
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reactions, nil
+}
+
+// attachReactions fills in the reactions of listed v1 messages
+func (store *MessageStore) attachReactions(chatJID string, messages []APIMessage) error {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	reactions, err := store.GetReactions(chatJID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if summary := reactions[messages[i].ID]; summary != nil {
+			messages[i].Reactions = summary.Counts
+			messages[i].MyReaction = summary.Mine
+		}
+	}
+	return nil
+}
+
+// attachExportedReactions fills in the reactions of a batch of exported messages, which spans chats
+func (store *MessageStore) attachExportedReactions(messages []ExportedMessage) error {
+	ids := map[string][]string{}
+	for _, msg := range messages {
+		ids[msg.ChatJID] = append(ids[msg.ChatJID], msg.ID)
+	}
+	for chatJID, chatIDs := range ids {
+		reactions, err := store.GetReactions(chatJID, chatIDs)
+		if err != nil {
+			return err
+		}
+		for i := range messages {
+			if messages[i].ChatJID != chatJID {
+				continue
+			}
+			if summary := reactions[messages[i].ID]; summary != nil {
+				messages[i].Reactions = summary.Counts
+				messages[i].MyReaction = summary.Mine
+			}
+		}
+	}
+	return nil
+}
+
+// attachLegacyReactions fills in the reactions of messages listed by the legacy /api/messages route
+func (store *MessageStore) attachLegacyReactions(chatJID string, messages []Message) error {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.id
+	}
+	reactions, err := store.GetReactions(chatJID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if summary := reactions[messages[i].id]; summary != nil {
+			messages[i].Reactions = summary.Counts
+			messages[i].MyReaction = summary.Mine
+		}
+	}
+	return nil
+}
+
+// handleReaction stores a reaction received from WhatsApp and publishes it
+func handleReaction(messageStore *MessageStore, chatJID, sender string, isFromMe bool, reaction *waProto.ReactionMessage, timestamp time.Time, logger waLog.Logger) {
+	messageID := reaction.GetKey().GetID()
+	if messageID == "" {
+		return
+	}
+	emoji := reaction.GetText()
+	if err := messageStore.StoreReaction(chatJID, messageID, sender, emoji, isFromMe, timestamp); err != nil {
+		logger.Warnf("Failed to store reaction: %v", err)
+		return
+	}
+	data := map[string]interface{}{
+		"chat_jid":   chatJID,
+		"message_id": messageID,
+		"sender":     sender,
+		"emoji":      emoji,
+		"is_from_me": isFromMe,
+	}
+	// Describe the reacted message when it is stored, so consumers needn't look it up
+	if matches, err := messageStore.findMessage(messageID, chatJID); err != nil {
+		logger.Warnf("Failed to get reacted message: %v", err)
+	} else if len(matches) == 1 {
+		reacted := matches[0]
+		data["message_sender"] = reacted.Sender
+		data["message_is_from_me"] = reacted.IsFromMe
+		data["message_content"] = reacted.Content
+		data["message_media_type"] = reacted.MediaType
+		data["message_timestamp"] = reacted.Timestamp.UTC()
+	}
+	publishEvent(EventMessageReaction, chatJID, timestamp, data)
+}
+
+// storeHistoryReaction stores a reaction attached to a message from a history sync
+func storeHistoryReaction(client *whatsmeow.Client, messageStore *MessageStore, chatJID string, chat types.JID, messageID string, reaction *waProto.Reaction, logger waLog.Logger) {
+	if messageID == "" || reaction.GetText() == "" {
+		return
+	}
+	key := reaction.GetKey()
+	sender := chat.User
+	if key.GetFromMe() {
+		sender = client.Store.ID.User
+	} else if participant, err := types.ParseJID(key.GetParticipant()); err == nil && participant.User != "" {
+		sender = participant.User
+	}
+	timestamp := time.UnixMilli(reaction.GetSenderTimestampMS())
+	if err := messageStore.StoreReaction(chatJID, messageID, sender, reaction.GetText(), key.GetFromMe(), timestamp); err != nil {
+		logger.Warnf("Failed to store history reaction: %v", err)
+	}
+}
+
+// GetMessageSender returns the stored sender of a message, and whether this account sent it
+func (store *MessageStore) GetMessageSender(chatJID, messageID string) (string, bool, error) {
+	query := "SELECT COALESCE(sender, ''), is_from_me FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT COALESCE(sender, ''), is_from_me FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	var sender string
+	var isFromMe bool
+	err := store.db.QueryRow(query, messageID, chatJID).Scan(&sender, &isFromMe)
+	return sender, isFromMe, err
+}
+
+// registerReactionRoutes registers /api/v1/react
+func registerReactionRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	logger := newLogger("Reactions")
+	handleAPI("/react", leaderOnly(refuseDuringMaintenance(sendUnlocked(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ReactRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if len([]rune(req.Emoji)) > maxReactionRunes {
+			http.Error(w, "emoji must be a single emoji", http.StatusBadRequest)
+			return
+		}
+		chat, err := types.ParseJID(req.ChatJID)
+		if err != nil {
+			http.Error(w, "Invalid chat_jid", http.StatusBadRequest)
+			return
+		}
+
+		// The reaction names the message's sender, so the message has to be known
+		sender, isFromMe, err := messageStore.GetMessageSender(req.ChatJID, req.MessageID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		senderJID := storedSenderJID(client, chat, sender, isFromMe)
+
+		reaction := client.BuildReaction(chat, senderJID, req.MessageID, req.Emoji)
+		resp, err := client.SendMessage(context.Background(), chat, reaction)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Error sending reaction: %v", err),
+			}.withError(classifySendError(err)))
+			return
+		}
+
+		// WhatsApp doesn't echo our own reactions back, so store this one like an incoming one
+		handleReaction(messageStore, req.ChatJID, client.Store.ID.User, true, reaction.GetReactionMessage(), resp.Timestamp, logger)
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   "Reaction sent",
+			MessageID: resp.ID,
+		})
+	}))))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// readOnlyMode serves stored data while refusing sends and changes, for demos, audits and analysts
+var readOnlyMode bool
+
+// readOnlyRoutes are API routes that take a POST but don't change anything the bridge holds
+var readOnlyRoutes = map[string]bool{
+	"/download": true,
+}
+
+// readOnlyMiddleware rejects API requests that would send or change data while in read-only mode
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyMode && strings.HasPrefix(r.URL.Path, "/api/") && !readOnlyAllowed(r) {
+			w.Header().Set("X-Bridge-Mode", "read-only")
+			http.Error(w, "The bridge is in read-only mode; sending and changing data are disabled", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlyAllowed reports whether a request only reads data
+func readOnlyAllowed(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return readOnlyRoutes[apiRoute(r)]
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MessageReceipt is a delivery, read or played receipt for an outbound message. In groups every
+// member sends their own.
+type MessageReceipt struct {
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageReceipts is the response of /api/v1/messages/{id}/receipts
+type MessageReceipts struct {
+	MessageID string           `json:"message_id"`
+	ChatJID   string           `json:"chat_jid"`
+	Status    string           `json:"status"`
+	StatusAt  *time.Time       `json:"status_at,omitempty"`
+	Receipts  []MessageReceipt `json:"receipts"`
+}
+
+// AddMessageReceipt stores a recipient's receipt for one of our stored messages; a repeated receipt is kept once
+func (store *MessageStore) AddMessageReceipt(id, chatJID, recipient, status string, at time.Time) error {
+	exists := "SELECT COUNT(*) FROM messages WHERE id = ? AND chat_jid = ? AND is_from_me"
+	insert := `INSERT INTO message_receipts (message_id, chat_jid, recipient, status, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, recipient, status) DO NOTHING`
+	if store.isPostgres {
+		exists = "SELECT COUNT(*) FROM messages WHERE id = $1 AND chat_jid = $2 AND is_from_me"
+		insert = `INSERT INTO message_receipts (message_id, chat_jid, recipient, status, timestamp) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, chat_jid, recipient, status) DO NOTHING`
+	}
+
+	// Receipts also arrive for messages sent from the phone before the bridge stored them
+	var count int
+	if err := store.db.QueryRow(exists, id, chatJID).Scan(&count); err != nil || count == 0 {
+		return err
+	}
+	_, err := store.db.Exec(insert, id, chatJID, recipient, status, at.UTC())
+	return err
+}
+
+// GetMessageReceipts returns the status of a message and its receipts, oldest first; nil when it isn't stored
+func (store *MessageStore) GetMessageReceipts(id, chatJID string) (*MessageReceipts, error) {
+	query := "SELECT COALESCE(status, ''), status_at FROM messages WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT COALESCE(status, ''), status_at FROM messages WHERE id = $1 AND chat_jid = $2"
+	}
+	receipts := &MessageReceipts{MessageID: id, ChatJID: chatJID, Receipts: []MessageReceipt{}}
+	var statusAt sql.NullTime
+	err := store.db.QueryRow(query, id, chatJID).Scan(&receipts.Status, &statusAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if statusAt.Valid {
+		receipts.StatusAt = &statusAt.Time
+	}
+
+	query = "SELECT recipient, status, timestamp FROM message_receipts WHERE message_id = ? AND chat_jid = ? ORDER BY timestamp, recipient"
+	if store.isPostgres {
+		query = "SELECT recipient, status, timestamp FROM message_receipts WHERE message_id = $1 AND chat_jid = $2 ORDER BY timestamp, recipient"
+	}
+	rows, err := store.db.Query(query, id, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receipt MessageReceipt
+		if err := rows.Scan(&receipt.Recipient, &receipt.Status, &receipt.Timestamp); err != nil {
+			return nil, err
+		}
+		receipts.Receipts = append(receipts.Receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// publishMessageStatus tells subscribers an outbound message moved to a status
+func publishMessageStatus(account, chatJID, id, status, recipient, clientRef string, at time.Time) {
+	publishEvent(EventMessageStatus, chatJID, at, withAccount(account, map[string]interface{}{
+		"id":         id,
+		"chat_jid":   chatJID,
+		"status":     status,
+		"recipient":  recipient,
+		"client_ref": clientRef,
+	}))
+}
+
+// handleMessageReceipts serves /api/v1/messages/{id}/receipts
+func handleMessageReceipts(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		route := strings.TrimPrefix(apiRoute(r), "/messages/")
+		id := strings.TrimSuffix(route, "/receipts")
+		if id == route || id == "" || strings.Contains(id, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		matches, err := messageStore.findMessage(id, r.URL.Query().Get("chat_jid"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(matches) == 0 {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if len(matches) > 1 {
+			http.Error(w, "Message ID exists in several chats; pass chat_jid", http.StatusBadRequest)
+			return
+		}
+
+		receipts, err := messageStore.GetMessageReceipts(id, matches[0].ChatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get receipts: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if receipts == nil {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		legalHolds.RecordRequest(r, receipts.ChatJID)
+		if receipts.StatusAt != nil {
+			statusAt := receipts.StatusAt.In(loc)
+			receipts.StatusAt = &statusAt
+		}
+		for i := range receipts.Receipts {
+			receipts.Receipts[i].Timestamp = receipts.Receipts[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(receipts)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// receiveOnlyMode stores incoming messages and emits events but never sends, for compliance archives
+// where an accidental reply must be impossible
+var receiveOnlyMode bool
+
+// outboundRoutes are API routes that send messages. Uploads are included, as finishing one sends it.
+var outboundRoutes = map[string]bool{
+	"/send":             true,
+	"/payments/request": true,
+	"/uploads":          true,
+	"/react":            true,
+	"/send/voice":       true,
+	"/scheduled":        true,
+	"/campaigns":        true,
+	"/recurring":        true,
+}
+
+// receiveOnlyMiddleware rejects API requests that would send a message while in receive-only mode.
+// sendWhatsAppMessage refuses as well, so auto-replies and flows can't send either.
+func receiveOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if receiveOnlyMode && r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/") && outboundRoutes[apiRoute(r)] {
+			w.Header().Set("X-Bridge-Mode", "receive-only")
+			http.Error(w, "The bridge is in receive-only mode; sending is disabled", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// recipientTimezoneKey is the chat metadata key holding a contact's IANA timezone, e.g. Europe/Berlin
+const recipientTimezoneKey = "timezone"
+
+// Where the timezone of a recipient came from
+const (
+	TimezoneFromMetadata    = "metadata"
+	TimezoneFromCountryCode = "country_code"
+	TimezoneFromDefault     = "default"
+	TimezoneFromRequest     = "request"
+)
+
+// countryTimezones maps calling codes to the timezone most of the country's numbers live in.
+// Countries spanning several zones (e.g. +1, +7, +55, +61) get the most populous one; store a
+// timezone in the contact's metadata where that's wrong.
+var countryTimezones = map[string]string{
+	"1":   "America/New_York",
+	"7":   "Europe/Moscow",
+	"20":  "Africa/Cairo",
+	"27":  "Africa/Johannesburg",
+	"30":  "Europe/Athens",
+	"31":  "Europe/Amsterdam",
+	"32":  "Europe/Brussels",
+	"33":  "Europe/Paris",
+	"34":  "Europe/Madrid",
+	"36":  "Europe/Budapest",
+	"39":  "Europe/Rome",
+	"40":  "Europe/Bucharest",
+	"41":  "Europe/Zurich",
+	"43":  "Europe/Vienna",
+	"44":  "Europe/London",
+	"45":  "Europe/Copenhagen",
+	"46":  "Europe/Stockholm",
+	"47":  "Europe/Oslo",
+	"48":  "Europe/Warsaw",
+	"49":  "Europe/Berlin",
+	"51":  "America/Lima",
+	"52":  "America/Mexico_City",
+	"53":  "America/Havana",
+	"54":  "America/Argentina/Buenos_Aires",
+	"55":  "America/Sao_Paulo",
+	"56":  "America/Santiago",
+	"57":  "America/Bogota",
+	"58":  "America/Caracas",
+	"60":  "Asia/Kuala_Lumpur",
+	"61":  "Australia/Sydney",
+	"62":  "Asia/Jakarta",
+	"63":  "Asia/Manila",
+	"64":  "Pacific/Auckland",
+	"65":  "Asia/Singapore",
+	"66":  "Asia/Bangkok",
+	"81":  "Asia/Tokyo",
+	"82":  "Asia/Seoul",
+	"84":  "Asia/Ho_Chi_Minh",
+	"86":  "Asia/Shanghai",
+	"90":  "Europe/Istanbul",
+	"91":  "Asia/Kolkata",
+	"92":  "Asia/Karachi",
+	"93":  "Asia/Kabul",
+	"94":  "Asia/Colombo",
+	"95":  "Asia/Yangon",
+	"98":  "Asia/Tehran",
+	"211": "Africa/Juba",
+	"212": "Africa/Casablanca",
+	"213": "Africa/Algiers",
+	"216": "Africa/Tunis",
+	"218": "Africa/Tripoli",
+	"220": "Africa/Banjul",
+	"221": "Africa/Dakar",
+	"225": "Africa/Abidjan",
+	"233": "Africa/Accra",
+	"234": "Africa/Lagos",
+	"237": "Africa/Douala",
+	"243": "Africa/Kinshasa",
+	"244": "Africa/Luanda",
+	"249": "Africa/Khartoum",
+	"250": "Africa/Kigali",
+	"251": "Africa/Addis_Ababa",
+	"254": "Africa/Nairobi",
+	"255": "Africa/Dar_es_Salaam",
+	"256": "Africa/Kampala",
+	"260": "Africa/Lusaka",
+	"263": "Africa/Harare",
+	"351": "Europe/Lisbon",
+	"352": "Europe/Luxembourg",
+	"353": "Europe/Dublin",
+	"354": "Atlantic/Reykjavik",
+	"356": "Europe/Malta",
+	"357": "Asia/Nicosia",
+	"358": "Europe/Helsinki",
+	"359": "Europe/Sofia",
+	"370": "Europe/Vilnius",
+	"371": "Europe/Riga",
+	"372": "Europe/Tallinn",
+	"380": "Europe/Kyiv",
+	"381": "Europe/Belgrade",
+	"385": "Europe/Zagreb",
+	"386": "Europe/Ljubljana",
+	"420": "Europe/Prague",
+	"421": "Europe/Bratislava",
+	"502": "America/Guatemala",
+	"503": "America/El_Salvador",
+	"504": "America/Tegucigalpa",
+	"505": "America/Managua",
+	"506": "America/Costa_Rica",
+	"507": "America/Panama",
+	"591": "America/La_Paz",
+	"593": "America/Guayaquil",
+	"595": "America/Asuncion",
+	"598": "America/Montevideo",
+	"852": "Asia/Hong_Kong",
+	"855": "Asia/Phnom_Penh",
+	"880": "Asia/Dhaka",
+	"886": "Asia/Taipei",
+	"960": "Indian/Maldives",
+	"961": "Asia/Beirut",
+	"962": "Asia/Amman",
+	"963": "Asia/Damascus",
+	"964": "Asia/Baghdad",
+	"965": "Asia/Kuwait",
+	"966": "Asia/Riyadh",
+	"967": "Asia/Aden",
+	"968": "Asia/Muscat",
+	"970": "Asia/Gaza",
+	"971": "Asia/Dubai",
+	"972": "Asia/Jerusalem",
+	"973": "Asia/Bahrain",
+	"974": "Asia/Qatar",
+	"977": "Asia/Kathmandu",
+	"992": "Asia/Dushanbe",
+	"994": "Asia/Baku",
+	"995": "Asia/Tbilisi",
+	"998": "Asia/Tashkent",
+}
+
+// countryCodeTimezone infers a timezone from the calling code of a phone number
+func countryCodeTimezone(phone string) string {
+	phone = strings.TrimPrefix(phone, "+")
+	// Calling codes are prefix-free, so the first match is the only one
+	for length := 1; length <= 3 && length <= len(phone); length++ {
+		if zone, ok := countryTimezones[phone[:length]]; ok {
+			return zone
+		}
+	}
+	return ""
+}
+
+// recipientLocation returns the timezone of a recipient: the one stored in its chat metadata,
+// otherwise the one of its country code, otherwise TIMEZONE. It also says which it used.
+func recipientLocation(store *MessageStore, recipient types.JID) (*time.Location, string) {
+	if metadata, err := store.GetChatMetadata(recipient.String()); err == nil {
+		if name := metadata.Metadata[recipientTimezoneKey]; name != "" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc, TimezoneFromMetadata
+			}
+		}
+	}
+	if recipient.Server == types.DefaultUserServer {
+		if name := countryCodeTimezone(recipient.User); name != "" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc, TimezoneFromCountryCode
+			}
+		}
+	}
+	return displayLocation, TimezoneFromDefault
+}
+
+// localSendTime returns when the clock (HH:MM) shows in a timezone: on date (YYYY-MM-DD) when it's
+// given, otherwise the next time it does. A date on which the time has already passed there gives
+// now, so recipients for whom it has passed get the message right away instead of never.
+func localSendTime(loc *time.Location, clock, date string, now time.Time) (time.Time, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("local_time must be HH:MM")
+	}
+
+	local := now.In(loc)
+	year, month, day := local.Date()
+	if date != "" {
+		onDate, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("date must be YYYY-MM-DD")
+		}
+		at := time.Date(onDate.Year(), onDate.Month(), onDate.Day(), parsed.Hour(), parsed.Minute(), 0, 0, loc)
+		if at.Before(now) {
+			return now, nil
+		}
+		return at, nil
+	}
+
+	at := time.Date(year, month, day, parsed.Hour(), parsed.Minute(), 0, 0, loc)
+	if at.Before(now) {
+		at = time.Date(year, month, day+1, parsed.Hour(), parsed.Minute(), 0, 0, loc)
+	}
+	return at, nil
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Statuses of a recurring series
+const (
+	SeriesActive   = "active"
+	SeriesPaused   = "paused"
+	SeriesEnded    = "ended"
+	SeriesCanceled = "canceled"
+)
+
+// What happens to an occurrence that falls on a skipped day
+const (
+	SkipOccurrence = "skip"
+	SkipToNextDay  = "next_day"
+)
+
+// maxSkipDates bounds the holidays a series can list
+const maxSkipDates = 1000
+
+// maxSkipShiftDays is how far next_day moves an occurrence before giving up on it
+const maxSkipShiftDays = 31
+
+// seriesPreviewCount is how many upcoming occurrences a series response lists
+const seriesPreviewCount = 5
+
+// seriesColumns are the columns scanSeries reads, in order
+const seriesColumns = `id, recipient, chat_jid, COALESCE(message, ''), COALESCE(media_path, ''), COALESCE(client_ref, ''),
+	COALESCE(agent, ''), cron, timezone, timezone_source, COALESCE(skip_dates, ''), COALESCE(skip_weekdays, ''), on_skip,
+	COALESCE(end_date, ''), status, next_send_at, occurrences, created_at, updated_at`
+
+// RecurringSeries sends a message to a recipient every time its cron expression matches on the clock
+// of Timezone. Each occurrence becomes a scheduled message with the series' ID once it's due.
+type RecurringSeries struct {
+	ID             string `json:"id"`
+	Recipient      string `json:"recipient"`
+	ChatJID        string `json:"chat_jid"`
+	Message        string `json:"message,omitempty"`
+	MediaPath      string `json:"media_path,omitempty"`
+	ClientRef      string `json:"client_ref,omitempty"`
+	Agent          string `json:"agent,omitempty"`
+	Cron           string `json:"cron"`
+	Timezone       string `json:"timezone"`
+	TimezoneSource string `json:"timezone_source"`
+	// SkipDates are days, YYYY-MM-DD in Timezone, without occurrences, such as holidays
+	SkipDates []string `json:"skip_dates,omitempty"`
+	// SkipWeekdays are days of the week without occurrences, e.g. sat and sun
+	SkipWeekdays []string `json:"skip_weekdays,omitempty"`
+	// OnSkip is skip to drop an occurrence on a skipped day, or next_day to send it on the next day that isn't
+	OnSkip string `json:"on_skip"`
+	// EndDate is the last day, YYYY-MM-DD in Timezone, that can have an occurrence
+	EndDate    string     `json:"end_date,omitempty"`
+	Status     string     `json:"status"`
+	NextSendAt *time.Time `json:"next_send_at,omitempty"`
+	// Occurrences counts the messages the series has queued so far
+	Occurrences int       `json:"occurrences"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Upcoming lists the next occurrences of an active series, to check its rules against
+	Upcoming []time.Time `json:"upcoming,omitempty"`
+}
+
+// RecurringRequest is the body of POST /api/v1/recurring
+type RecurringRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message"`
+	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	Cron      string `json:"cron"`
+	// Timezone is the IANA timezone the cron expression runs in; the recipient's when it's left out
+	Timezone     string   `json:"timezone,omitempty"`
+	SkipDates    []string `json:"skip_dates,omitempty"`
+	SkipWeekdays []string `json:"skip_weekdays,omitempty"`
+	OnSkip       string   `json:"on_skip,omitempty"`
+	EndDate      string   `json:"end_date,omitempty"`
+}
+
+// RecurringUpdate is the body of PATCH /api/v1/recurring/{id}. Fields left out keep their value;
+// an empty timezone returns to the recipient's and an empty end_date removes it.
+type RecurringUpdate struct {
+	Message      *string   `json:"message"`
+	MediaPath    *string   `json:"media_path"`
+	ClientRef    *string   `json:"client_ref"`
+	Agent        *string   `json:"agent"`
+	Cron         *string   `json:"cron"`
+	Timezone     *string   `json:"timezone"`
+	SkipDates    *[]string `json:"skip_dates"`
+	SkipWeekdays *[]string `json:"skip_weekdays"`
+	OnSkip       *string   `json:"on_skip"`
+	EndDate      *string   `json:"end_date"`
+}
+
+// validate checks a series and normalizes its rules
+func (series *RecurringSeries) validate() error {
+	if err := validateScheduledContent(series.Message, series.MediaPath, series.ClientRef, series.Agent); err != nil {
+		return err
+	}
+	if _, err := parseCron(series.Cron); err != nil {
+		return fmt.Errorf("invalid cron: %v", err)
+	}
+	if _, err := time.LoadLocation(series.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", series.Timezone)
+	}
+	if len(series.SkipDates) > maxSkipDates {
+		return fmt.Errorf("skip_dates can list at most %d dates", maxSkipDates)
+	}
+	for _, date := range series.SkipDates {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("skip_dates must be YYYY-MM-DD dates")
+		}
+	}
+	for i, day := range series.SkipWeekdays {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if _, ok := cronWeekdays[day]; !ok {
+			return fmt.Errorf("skip_weekdays must be sun, mon, tue, wed, thu, fri or sat")
+		}
+		series.SkipWeekdays[i] = day
+	}
+	switch series.OnSkip {
+	case "":
+		series.OnSkip = SkipOccurrence
+	case SkipOccurrence, SkipToNextDay:
+	default:
+		return fmt.Errorf("on_skip must be %s or %s", SkipOccurrence, SkipToNextDay)
+	}
+	if series.EndDate != "" {
+		if _, err := time.Parse("2006-01-02", series.EndDate); err != nil {
+			return fmt.Errorf("end_date must be YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+// nextAfter returns the first occurrence after a time once the skip rules and end date are applied,
+// or the zero time when there are no more
+func (series *RecurringSeries) nextAfter(after time.Time) time.Time {
+	schedule, err := parseCron(series.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(series.Timezone)
+	if err != nil {
+		return time.Time{}
+	}
+
+	skipDates := make(map[string]bool, len(series.SkipDates))
+	for _, date := range series.SkipDates {
+		skipDates[date] = true
+	}
+	skipWeekdays := make(map[time.Weekday]bool, len(series.SkipWeekdays))
+	for _, day := range series.SkipWeekdays {
+		skipWeekdays[time.Weekday(cronWeekdays[day])] = true
+	}
+	skipped := func(t time.Time) bool {
+		return skipDates[t.Format("2006-01-02")] || skipWeekdays[t.Weekday()]
+	}
+	// Rules that skip every occurrence end the series too
+	horizon := after.Add(cronHorizon)
+	ended := func(t time.Time) bool {
+		return (series.EndDate != "" && t.Format("2006-01-02") > series.EndDate) || t.After(horizon)
+	}
+
+	// An occurrence moved by next_day can come after one that was skipped before the time
+	from := after
+	if series.OnSkip == SkipToNextDay {
+		from = after.AddDate(0, 0, -maxSkipShiftDays)
+	}
+	for t := schedule.Next(from, loc); !t.IsZero() && !ended(t); t = schedule.Next(t, loc) {
+		if !skipped(t) {
+			if t.After(after) {
+				return t.UTC()
+			}
+			continue
+		}
+		if series.OnSkip != SkipToNextDay {
+			continue
+		}
+
+		shifted := t
+		for days := 0; days < maxSkipShiftDays && skipped(shifted); days++ {
+			year, month, day := shifted.Date()
+			shifted = time.Date(year, month, day+1, t.Hour(), t.Minute(), 0, 0, loc)
+		}
+		if skipped(shifted) || ended(shifted) || !shifted.After(after) {
+			continue
+		}
+		// When the next regular occurrence comes on or before that day, it goes instead, so a daily
+		// series skipping weekends sends one message on Monday rather than three
+		if regular := schedule.Next(t, loc); !regular.IsZero() && regular.Format("2006-01-02") <= shifted.Format("2006-01-02") {
+			continue
+		}
+		return shifted.UTC()
+	}
+	return time.Time{}
+}
+
+// reschedule sets the next occurrence after now, ending the series when there is none
+func (series *RecurringSeries) reschedule(now time.Time) {
+	if next := series.nextAfter(now); next.IsZero() {
+		series.Status = SeriesEnded
+		series.NextSendAt = nil
+	} else {
+		series.Status = SeriesActive
+		series.NextSendAt = &next
+	}
+}
+
+// preview lists the next occurrences of an active series in Upcoming
+func (series *RecurringSeries) preview(now time.Time) {
+	series.Upcoming = nil
+	if series.Status != SeriesActive {
+		return
+	}
+	at := now
+	for len(series.Upcoming) < seriesPreviewCount {
+		at = series.nextAfter(at)
+		if at.IsZero() {
+			return
+		}
+		series.Upcoming = append(series.Upcoming, at)
+	}
+}
+
+// localize converts the times of a series to a response's time zone
+func (series *RecurringSeries) localize(loc *time.Location) {
+	series.CreatedAt = series.CreatedAt.In(loc)
+	series.UpdatedAt = series.UpdatedAt.In(loc)
+	if series.NextSendAt != nil {
+		next := series.NextSendAt.In(loc)
+		series.NextSendAt = &next
+	}
+	for i := range series.Upcoming {
+		series.Upcoming[i] = series.Upcoming[i].In(loc)
+	}
+}
+
+// occurrence returns the scheduled message for the series' next occurrence
+func (series *RecurringSeries) occurrence(now time.Time) *ScheduledMessage {
+	return &ScheduledMessage{
+		ID:             newEventID(),
+		SeriesID:       series.ID,
+		Recipient:      series.Recipient,
+		ChatJID:        series.ChatJID,
+		Message:        series.Message,
+		MediaPath:      series.MediaPath,
+		ClientRef:      series.ClientRef,
+		Agent:          series.Agent,
+		SendAt:         *series.NextSendAt,
+		Timezone:       series.Timezone,
+		TimezoneSource: series.TimezoneSource,
+		Status:         ScheduledPending,
+		CreatedAt:      now,
+	}
+}
+
+// queueRecurring turns the due occurrences of recurring series into scheduled messages. Occurrences
+// missed while the bridge was down or disconnected go out once, not once for each.
+func (s *Scheduler) queueRecurring(now time.Time) {
+	due, err := s.messageStore.DueSeries(now, s.batch)
+	if err != nil {
+		s.logger.Warnf("Failed to list due recurring series: %v", err)
+		return
+	}
+	for i := range due {
+		series := &due[i]
+		occurrence := series.occurrence(now)
+		series.reschedule(now)
+		series.UpdatedAt = now
+		if err := s.messageStore.QueueSeriesOccurrence(series, occurrence); err != nil {
+			s.logger.Warnf("Failed to queue occurrence of recurring series %s: %v", series.ID, err)
+		}
+	}
+}
+
+// scanSeries reads a row of seriesColumns
+func scanSeries(row interface{ Scan(...interface{}) error }) (*RecurringSeries, error) {
+	var series RecurringSeries
+	var skipDates, skipWeekdays string
+	var nextSendAt sql.NullTime
+	if err := row.Scan(&series.ID, &series.Recipient, &series.ChatJID, &series.Message, &series.MediaPath, &series.ClientRef,
+		&series.Agent, &series.Cron, &series.Timezone, &series.TimezoneSource, &skipDates, &skipWeekdays, &series.OnSkip,
+		&series.EndDate, &series.Status, &nextSendAt, &series.Occurrences, &series.CreatedAt, &series.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if skipDates != "" {
+		if err := json.Unmarshal([]byte(skipDates), &series.SkipDates); err != nil {
+			return nil, fmt.Errorf("invalid skip dates of series %s: %v", series.ID, err)
+		}
+	}
+	if skipWeekdays != "" {
+		if err := json.Unmarshal([]byte(skipWeekdays), &series.SkipWeekdays); err != nil {
+			return nil, fmt.Errorf("invalid skip weekdays of series %s: %v", series.ID, err)
+		}
+	}
+	if nextSendAt.Valid {
+		series.NextSendAt = &nextSendAt.Time
+	}
+	return &series, nil
+}
+
+// encodeSkipRules returns the skip lists of a series as stored, NULL when empty
+func (series *RecurringSeries) encodeSkipRules() (interface{}, interface{}) {
+	var skipDates, skipWeekdays interface{}
+	if len(series.SkipDates) > 0 {
+		encoded, _ := json.Marshal(series.SkipDates)
+		skipDates = string(encoded)
+	}
+	if len(series.SkipWeekdays) > 0 {
+		encoded, _ := json.Marshal(series.SkipWeekdays)
+		skipWeekdays = string(encoded)
+	}
+	return skipDates, skipWeekdays
+}
+
+// AddSeries stores a new recurring series
+func (store *MessageStore) AddSeries(series *RecurringSeries) error {
+	query := `INSERT INTO scheduled_series (id, recipient, chat_jid, message, media_path, client_ref, agent, cron, timezone,
+		timezone_source, skip_dates, skip_weekdays, on_skip, end_date, status, next_send_at, occurrences, created_at, updated_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, 0, ?, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO scheduled_series (id, recipient, chat_jid, message, media_path, client_ref, agent, cron, timezone,
+		timezone_source, skip_dates, skip_weekdays, on_skip, end_date, status, next_send_at, occurrences, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11, $12, $13, NULLIF($14, ''), $15, $16, 0, $17, $18)`
+	}
+	skipDates, skipWeekdays := series.encodeSkipRules()
+	_, err := store.db.Exec(query, series.ID, series.Recipient, series.ChatJID, series.Message, series.MediaPath, series.ClientRef,
+		series.Agent, series.Cron, series.Timezone, series.TimezoneSource, skipDates, skipWeekdays, series.OnSkip, series.EndDate,
+		series.Status, series.NextSendAt, series.CreatedAt, series.UpdatedAt)
+	return err
+}
+
+// UpdateSeries saves the content, rules and status of a series
+func (store *MessageStore) UpdateSeries(series *RecurringSeries) error {
+	query := `UPDATE scheduled_series SET message = NULLIF(?, ''), media_path = NULLIF(?, ''), client_ref = NULLIF(?, ''),
+		agent = NULLIF(?, ''), cron = ?, timezone = ?, timezone_source = ?, skip_dates = ?, skip_weekdays = ?, on_skip = ?,
+		end_date = NULLIF(?, ''), status = ?, next_send_at = ?, updated_at = ? WHERE id = ?`
+	if store.isPostgres {
+		query = `UPDATE scheduled_series SET message = NULLIF($1, ''), media_path = NULLIF($2, ''), client_ref = NULLIF($3, ''),
+		agent = NULLIF($4, ''), cron = $5, timezone = $6, timezone_source = $7, skip_dates = $8, skip_weekdays = $9, on_skip = $10,
+		end_date = NULLIF($11, ''), status = $12, next_send_at = $13, updated_at = $14 WHERE id = $15`
+	}
+	skipDates, skipWeekdays := series.encodeSkipRules()
+	_, err := store.db.Exec(query, series.Message, series.MediaPath, series.ClientRef, series.Agent, series.Cron, series.Timezone,
+		series.TimezoneSource, skipDates, skipWeekdays, series.OnSkip, series.EndDate, series.Status, series.NextSendAt,
+		series.UpdatedAt, series.ID)
+	return err
+}
+
+// GetSeries returns a recurring series, or nil if there is none with the ID
+func (store *MessageStore) GetSeries(id string) (*RecurringSeries, error) {
+	query := "SELECT " + seriesColumns + " FROM scheduled_series WHERE id = ?"
+	if store.isPostgres {
+		query = "SELECT " + seriesColumns + " FROM scheduled_series WHERE id = $1"
+	}
+	series, err := scanSeries(store.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return series, err
+}
+
+// ListSeries returns the recurring series, newest first, optionally of one status
+func (store *MessageStore) ListSeries(status string) ([]RecurringSeries, error) {
+	if status == "" {
+		return store.querySeries("SELECT " + seriesColumns + " FROM scheduled_series ORDER BY created_at DESC")
+	}
+	query := "SELECT " + seriesColumns + " FROM scheduled_series WHERE status = ? ORDER BY created_at DESC"
+	if store.isPostgres {
+		query = "SELECT " + seriesColumns + " FROM scheduled_series WHERE status = $1 ORDER BY created_at DESC"
+	}
+	return store.querySeries(query, status)
+}
+
+// DueSeries returns the active series whose next occurrence has come, oldest first
+func (store *MessageStore) DueSeries(now time.Time, limit int) ([]RecurringSeries, error) {
+	query := "SELECT " + seriesColumns + " FROM scheduled_series WHERE status = ? AND next_send_at <= ? ORDER BY next_send_at ASC LIMIT ?"
+	if store.isPostgres {
+		query = "SELECT " + seriesColumns + " FROM scheduled_series WHERE status = $1 AND next_send_at <= $2 ORDER BY next_send_at ASC LIMIT $3"
+	}
+	return store.querySeries(query, SeriesActive, now, limit)
+}
+
+func (store *MessageStore) querySeries(query string, args ...interface{}) ([]RecurringSeries, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []RecurringSeries{}
+	for rows.Next() {
+		series, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *series)
+	}
+	return list, rows.Err()
+}
+
+// QueueSeriesOccurrence stores an occurrence of a series along with its next one, unless the series
+// was paused or canceled in the meantime
+func (store *MessageStore) QueueSeriesOccurrence(series *RecurringSeries, occurrence *ScheduledMessage) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE scheduled_series SET status = ?, next_send_at = ?, occurrences = occurrences + 1, updated_at = ? WHERE id = ? AND status = ?"
+	if store.isPostgres {
+		query = "UPDATE scheduled_series SET status = $1, next_send_at = $2, occurrences = occurrences + 1, updated_at = $3 WHERE id = $4 AND status = $5"
+	}
+	result, err := tx.Exec(query, series.Status, series.NextSendAt, series.UpdatedAt, series.ID, SeriesActive)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(store.insertScheduledQuery(), occurrence.ID, occurrence.CampaignID, occurrence.SeriesID, occurrence.Recipient,
+		occurrence.ChatJID, occurrence.Message, occurrence.MediaPath, occurrence.ClientRef, occurrence.Agent, occurrence.SendAt,
+		occurrence.Timezone, occurrence.TimezoneSource, occurrence.Status, occurrence.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// registerRecurringRoutes registers /api/v1/recurring and /api/v1/recurring/{id}, plus /pause and /resume
+func registerRecurringRoutes(messageStore *MessageStore) {
+	handleAPI("/recurring", func(w http.ResponseWriter, r *http.Request) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			list, err := messageStore.ListSeries(r.URL.Query().Get("status"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list recurring series: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for i := range list {
+				list[i].localize(loc)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+
+		case http.MethodPost:
+			var req RecurringRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.Recipient == "" {
+				http.Error(w, "Recipient is required", http.StatusBadRequest)
+				return
+			}
+			jid, err := parseRecipient(req.Recipient)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid recipient %q: %v", req.Recipient, err), http.StatusBadRequest)
+				return
+			}
+
+			now := time.Now().UTC()
+			series := &RecurringSeries{
+				ID:             newEventID(),
+				Recipient:      req.Recipient,
+				ChatJID:        jid.String(),
+				Message:        req.Message,
+				MediaPath:      req.MediaPath,
+				ClientRef:      req.ClientRef,
+				Agent:          req.Agent,
+				Cron:           strings.TrimSpace(req.Cron),
+				Timezone:       req.Timezone,
+				TimezoneSource: TimezoneFromRequest,
+				SkipDates:      req.SkipDates,
+				SkipWeekdays:   req.SkipWeekdays,
+				OnSkip:         req.OnSkip,
+				EndDate:        req.EndDate,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+			if series.Timezone == "" {
+				recipientLoc, source := recipientLocation(messageStore, jid)
+				series.Timezone, series.TimezoneSource = recipientLoc.String(), source
+			}
+			if err := series.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			series.reschedule(now)
+			if series.Status == SeriesEnded {
+				http.Error(w, "The series has no occurrences; check cron, the skip rules and end_date", http.StatusBadRequest)
+				return
+			}
+
+			if err := messageStore.AddSeries(series); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to create recurring series: %v", err), http.StatusInternalServerError)
+				return
+			}
+			series.preview(now)
+			series.localize(loc)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(series)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	handleAPI("/recurring/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/recurring/"), "/")
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		series, err := messageStore.GetSeries(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get recurring series: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if series == nil {
+			http.Error(w, "Recurring series not found", http.StatusNotFound)
+			return
+		}
+
+		now := time.Now().UTC()
+		// Pausing or canceling drops the occurrence waiting to go out, if any
+		dropQueued := false
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+
+		case action == "" && r.Method == http.MethodPatch:
+			if series.Status == SeriesCanceled {
+				http.Error(w, "A canceled series can't be edited", http.StatusConflict)
+				return
+			}
+			var update RecurringUpdate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if update.Message != nil {
+				series.Message = *update.Message
+			}
+			if update.MediaPath != nil {
+				series.MediaPath = *update.MediaPath
+			}
+			if update.ClientRef != nil {
+				series.ClientRef = *update.ClientRef
+			}
+			if update.Agent != nil {
+				series.Agent = *update.Agent
+			}
+			if update.OnSkip != nil {
+				series.OnSkip = *update.OnSkip
+			}
+			if update.EndDate != nil {
+				series.EndDate = *update.EndDate
+			}
+			if update.Cron != nil {
+				series.Cron = strings.TrimSpace(*update.Cron)
+			}
+			if update.Timezone != nil {
+				series.Timezone, series.TimezoneSource = *update.Timezone, TimezoneFromRequest
+				if series.Timezone == "" {
+					jid, _ := parseRecipient(series.Recipient)
+					recipientLoc, source := recipientLocation(messageStore, jid)
+					series.Timezone, series.TimezoneSource = recipientLoc.String(), source
+				}
+			}
+			if update.SkipDates != nil {
+				series.SkipDates = *update.SkipDates
+			}
+			if update.SkipWeekdays != nil {
+				series.SkipWeekdays = *update.SkipWeekdays
+			}
+			if err := series.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// A paused series works out its next occurrence when it's resumed
+			if series.Status != SeriesPaused {
+				series.reschedule(now)
+			}
+
+		case action == "" && r.Method == http.MethodDelete:
+			if series.Status == SeriesEnded {
+				http.Error(w, "The series has already ended", http.StatusConflict)
+				return
+			}
+			series.Status = SeriesCanceled
+			series.NextSendAt = nil
+			dropQueued = true
+
+		case action == "pause" && r.Method == http.MethodPost:
+			if series.Status != SeriesActive && series.Status != SeriesPaused {
+				http.Error(w, fmt.Sprintf("Only active series can be paused; this one is %s", series.Status), http.StatusConflict)
+				return
+			}
+			series.Status = SeriesPaused
+			series.NextSendAt = nil
+			dropQueued = true
+
+		case action == "resume" && r.Method == http.MethodPost:
+			if series.Status != SeriesActive && series.Status != SeriesPaused {
+				http.Error(w, fmt.Sprintf("Only paused series can be resumed; this one is %s", series.Status), http.StatusConflict)
+				return
+			}
+			// Occurrences that fell in the pause are skipped
+			if series.Status == SeriesPaused {
+				series.reschedule(now)
+			}
+
+		case action != "" && action != "pause" && action != "resume":
+			http.NotFound(w, r)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			series.UpdatedAt = now
+			if err := messageStore.UpdateSeries(series); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save recurring series: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if dropQueued {
+				if _, err := messageStore.cancelScheduledWhere("series_id", series.ID); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to cancel queued occurrence: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		series.preview(now)
+		series.localize(loc)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(series)
+	})
+}
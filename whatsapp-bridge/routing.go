@@ -0,0 +1,584 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// routingRulesFileName is the default rules file inside DATA_DIR
+const routingRulesFileName = "routing_rules.json"
+
+// IncomingMessage is a stored message from a contact, as seen by routing and flows
+type IncomingMessage struct {
+	ID      string
+	ChatJID string
+	Sender  string
+	// SenderJID is the full JID of the sender, needed to act on group members
+	SenderJID types.JID
+	// SenderPhone is the sender's phone number, also when the message is addressed by LID
+	SenderPhone string
+	Content     string
+	MediaType   string
+	Timestamp   time.Time
+	IsGroup     bool
+}
+
+// dispatchIncoming labels a message's chat, then passes the message to the chat's conversation
+// flow, or to the routing rules when no flow takes it. Admin commands, muted chats, group
+// messages deleted by moderation, chats handed to an agent and messages asking for one go no
+// further.
+func dispatchIncoming(msg IncomingMessage) {
+	if commandProcessor.Handle(msg) {
+		return
+	}
+	if commandProcessor.Muted(msg.ChatJID) {
+		return
+	}
+	if groupModerator != nil && groupModerator.HandleIncoming(msg) {
+		return
+	}
+	if chatHandoff.Paused(msg.ChatJID) || chatHandoff.HandleIncoming(msg) {
+		return
+	}
+	if chatClassifier != nil {
+		chatClassifier.Classify(msg)
+	}
+	// Flows exist to answer, so receive-only deployments leave them out
+	if flowEngine != nil && !receiveOnlyMode && flowEngine.HandleIncoming(msg) {
+		return
+	}
+	if messageRouter != nil {
+		messageRouter.Route(msg)
+	}
+}
+
+// RoutingRule matches incoming messages and sends them to a webhook, answers them or assigns
+// the chat to a queue. A rule without keywords or regex matches every message; one with labels
+// only matches chats that have one of them.
+type RoutingRule struct {
+	Name string `json:"name"`
+
+	// Matching
+	Keywords     []string `json:"keywords,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	FirstMessage bool     `json:"first_message,omitempty"`
+	Groups       bool     `json:"groups,omitempty"`
+	Labels       []string `json:"labels,omitempty"`
+
+	// Actions
+	Webhook string `json:"webhook,omitempty"`
+	Reply   string `json:"reply,omitempty"`
+	Assign  string `json:"assign,omitempty"`
+
+	// Continue evaluates later rules after this one matched
+	Continue bool `json:"continue,omitempty"`
+
+	// Fallback marks a catch-all rule: matching it counts towards HANDOFF_FALLBACK_LIMIT
+	Fallback bool `json:"fallback,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// matches reports whether a rule applies to a message
+func (rule *RoutingRule) matches(msg IncomingMessage, firstMessage bool, chatLabels []string) bool {
+	if msg.IsGroup && !rule.Groups {
+		return false
+	}
+	if rule.FirstMessage && !firstMessage {
+		return false
+	}
+	if len(rule.Labels) > 0 && !hasAnyLabel(chatLabels, rule.Labels) {
+		return false
+	}
+	return rule.pattern == nil || rule.pattern.MatchString(msg.Content)
+}
+
+// hasAnyLabel reports whether a chat's labels include one of wanted
+func hasAnyLabel(labels, wanted []string) bool {
+	for _, label := range labels {
+		for _, want := range wanted {
+			if label == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Router applies routing rules to incoming messages
+type Router struct {
+	rules         []*RoutingRule
+	sinks         map[string]*WebhookSink
+	client        *whatsmeow.Client
+	messageStore  *MessageStore
+	logger        waLog.Logger
+	replyCooldown time.Duration
+	lastReplies   map[string]time.Time
+	mutex         sync.Mutex
+}
+
+// messageRouter is set when routing rules are configured
+var messageRouter *Router
+
+// NewRouterFromEnv loads the rules from ROUTING_RULES_FILE or DATA_DIR/routing_rules.json.
+// It returns nil when there is no rules file.
+func NewRouterFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*Router, error) {
+	path := os.Getenv("ROUTING_RULES_FILE")
+	if path == "" {
+		path = dataPath(routingRulesFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules: %v", err)
+	}
+
+	var rules []*RoutingRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid routing rules in %s: %v", path, err)
+	}
+
+	router := &Router{
+		rules:         rules,
+		sinks:         make(map[string]*WebhookSink),
+		client:        client,
+		messageStore:  messageStore,
+		logger:        logger,
+		replyCooldown: time.Duration(getEnvInt("ROUTING_REPLY_COOLDOWN_MINUTES", 60)) * time.Minute,
+		lastReplies:   make(map[string]time.Time),
+	}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Webhook == "" && rule.Reply == "" && rule.Assign == "" {
+			return nil, fmt.Errorf("routing rule %q has no webhook, reply or assign action", rule.Name)
+		}
+		if len(rule.Labels) > 0 {
+			if rule.Labels, err = normalizeLabels(rule.Labels); err != nil {
+				return nil, fmt.Errorf("invalid labels in routing rule %q: %v", rule.Name, err)
+			}
+		}
+
+		// Keywords match whole words, case-insensitively
+		var alternatives []string
+		for _, keyword := range rule.Keywords {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				alternatives = append(alternatives, `\b`+regexp.QuoteMeta(keyword)+`\b`)
+			}
+		}
+		if rule.Regex != "" {
+			alternatives = append(alternatives, "(?:"+rule.Regex+")")
+		}
+		if len(alternatives) > 0 {
+			rule.pattern, err = regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex in routing rule %q: %v", rule.Name, err)
+			}
+		}
+
+		if rule.Webhook != "" {
+			if !strings.HasPrefix(rule.Webhook, "http://") && !strings.HasPrefix(rule.Webhook, "https://") {
+				return nil, fmt.Errorf("invalid webhook in routing rule %q", rule.Name)
+			}
+			if router.sinks[rule.Webhook] == nil {
+				sink := newWebhookSink([]string{rule.Webhook}, logger)
+				sink.run()
+				router.sinks[rule.Webhook] = sink
+			}
+		}
+	}
+
+	logger.Infof("Loaded %d routing rules from %s", len(rules), path)
+	return router, nil
+}
+
+// Route runs the actions of the rules matching a message; by default only the first match applies
+func (r *Router) Route(msg IncomingMessage) {
+	firstMessage := false
+	for _, rule := range r.rules {
+		if rule.FirstMessage {
+			firstMessage = r.messageStore.isFirstIncoming(msg.ChatJID)
+			break
+		}
+	}
+	var chatLabels []string
+	for _, rule := range r.rules {
+		if len(rule.Labels) > 0 {
+			var err error
+			if chatLabels, err = r.messageStore.GetChatLabels(msg.ChatJID); err != nil {
+				r.logger.Warnf("Failed to get labels of %s for routing: %v", msg.ChatJID, err)
+			}
+			break
+		}
+	}
+
+	for _, rule := range r.rules {
+		if !rule.matches(msg, firstMessage, chatLabels) {
+			continue
+		}
+		// A chat that keeps ending up at the fallback goes to an agent instead
+		if rule.Fallback && chatHandoff.Fallback(msg.ChatJID) {
+			if err := chatHandoff.Trigger(msg.ChatJID, HandoffFallback, ""); err != nil {
+				r.logger.Warnf("Handoff of %s failed: %v", logRedactor.Phone(msg.ChatJID), err)
+			}
+			return
+		}
+		if !rule.Fallback {
+			chatHandoff.Understood(msg.ChatJID)
+		}
+		r.apply(rule, msg)
+		if !rule.Continue {
+			return
+		}
+	}
+}
+
+// apply runs the actions of a matched rule
+func (r *Router) apply(rule *RoutingRule, msg IncomingMessage) {
+	if rule.Webhook != "" {
+		r.sinks[rule.Webhook].Enqueue(BridgeEvent{
+			ID:        newEventID(),
+			Type:      EventMessageReceived,
+			Timestamp: msg.Timestamp.UTC(),
+			ChatJID:   msg.ChatJID,
+			Data: map[string]interface{}{
+				"id":         msg.ID,
+				"chat_jid":   msg.ChatJID,
+				"sender":     msg.Sender,
+				"content":    msg.Content,
+				"media_type": msg.MediaType,
+				"rule":       rule.Name,
+			},
+		})
+	}
+
+	if rule.Assign != "" {
+		assigned, err := r.messageStore.AssignChat(msg.ChatJID, rule.Assign, rule.Name, false)
+		if err != nil {
+			r.logger.Warnf("Failed to assign chat to %s: %v", rule.Assign, err)
+		} else if assigned {
+			publishEvent(EventChatAssigned, msg.ChatJID, time.Time{}, map[string]interface{}{
+				"chat_jid": msg.ChatJID,
+				"queue":    rule.Assign,
+				"rule":     rule.Name,
+			})
+		}
+	}
+
+	if rule.Reply != "" && !receiveOnlyMode && r.shouldReply(rule, msg.ChatJID) {
+		noSignature := false
+		success, result, _, _ := sendWhatsAppMessage(r.client, msg.ChatJID, rule.Reply, "", SendOptions{Agent: "auto-reply", Signature: &noSignature}, r.messageStore)
+		if !success {
+			r.logger.Warnf("Auto-reply of routing rule %q failed: %s", rule.Name, result)
+		}
+	}
+}
+
+// shouldReply limits auto-replies to one per chat and rule within the cooldown
+func (r *Router) shouldReply(rule *RoutingRule, chatJID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := rule.Name + "|" + chatJID
+	if last, ok := r.lastReplies[key]; ok && time.Since(last) < r.replyCooldown {
+		return false
+	}
+	r.lastReplies[key] = time.Now()
+	return true
+}
+
+// isFirstIncoming reports whether a chat has exactly one message from the contact, the one just stored
+func (store *MessageStore) isFirstIncoming(chatJID string) bool {
+	query := "SELECT COUNT(*) FROM messages WHERE chat_jid = ? AND NOT is_from_me"
+	if store.isPostgres {
+		query = "SELECT COUNT(*) FROM messages WHERE chat_jid = $1 AND NOT is_from_me"
+	}
+	var count int
+	if err := store.db.QueryRow(query, chatJID).Scan(&count); err != nil {
+		return false
+	}
+	return count == 1
+}
+
+// Assignment states for ListAssignments
+const (
+	AssignmentsOpen     = "open"
+	AssignmentsResolved = "resolved"
+)
+
+// ChatAssignment is the queue a chat was routed to
+type ChatAssignment struct {
+	ChatJID         string     `json:"chat_jid"`
+	Name            string     `json:"name,omitempty"`
+	Queue           string     `json:"queue"`
+	Rule            string     `json:"rule"`
+	AssignedAt      time.Time  `json:"assigned_at"`
+	FirstResponseAt *time.Time `json:"first_response_at,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	SLA             *SLAStatus `json:"sla,omitempty"`
+
+	firstResponseAlerted bool
+	resolutionAlerted    bool
+}
+
+// assignmentFields are the columns scanned by scanAssignment
+const assignmentFields = "chat_jid, queue, rule, assigned_at, first_response_at, resolved_at, first_response_breached_at, resolution_breached_at"
+
+// assignmentReset starts the SLA clocks of a reassigned chat again
+const assignmentReset = "first_response_at = NULL, resolved_at = NULL, first_response_breached_at = NULL, resolution_breached_at = NULL"
+
+// scanAssignment reads a row of assignmentFields
+func scanAssignment(row interface{ Scan(...interface{}) error }) (*ChatAssignment, error) {
+	var assignment ChatAssignment
+	var firstResponseAt, resolvedAt, firstResponseBreachedAt, resolutionBreachedAt sql.NullTime
+	if err := row.Scan(&assignment.ChatJID, &assignment.Queue, &assignment.Rule, &assignment.AssignedAt,
+		&firstResponseAt, &resolvedAt, &firstResponseBreachedAt, &resolutionBreachedAt); err != nil {
+		return nil, err
+	}
+	if firstResponseAt.Valid {
+		assignment.FirstResponseAt = &firstResponseAt.Time
+	}
+	if resolvedAt.Valid {
+		assignment.ResolvedAt = &resolvedAt.Time
+	}
+	assignment.firstResponseAlerted = firstResponseBreachedAt.Valid
+	assignment.resolutionAlerted = resolutionBreachedAt.Valid
+	return &assignment, nil
+}
+
+// localize adds the SLA status and converts the times of an assignment to a response's time zone
+func (assignment *ChatAssignment) localize(loc *time.Location) {
+	assignment.SLA = slaTracker.Status(assignment, time.Now())
+	assignment.AssignedAt = assignment.AssignedAt.In(loc)
+	for _, at := range []*time.Time{assignment.FirstResponseAt, assignment.ResolvedAt} {
+		if at != nil {
+			*at = at.In(loc)
+		}
+	}
+	if assignment.SLA != nil {
+		for _, at := range []*time.Time{assignment.SLA.FirstResponseDue, assignment.SLA.ResolutionDue} {
+			if at != nil {
+				*at = at.In(loc)
+			}
+		}
+	}
+}
+
+// AssignChat assigns a chat to a queue. Unless replace is set, open assignments are kept and only a
+// resolved one is reopened; the result reports whether the assignment changed.
+func (store *MessageStore) AssignChat(chatJID, queue, rule string, replace bool) (bool, error) {
+	var query string
+	switch {
+	case store.isPostgres && replace:
+		query = "INSERT INTO chat_assignments (chat_jid, queue, rule, assigned_at) VALUES ($1, $2, $3, $4) ON CONFLICT (chat_jid) DO UPDATE SET queue = $2, rule = $3, assigned_at = $4, " + assignmentReset
+	case store.isPostgres:
+		query = "INSERT INTO chat_assignments (chat_jid, queue, rule, assigned_at) VALUES ($1, $2, $3, $4) ON CONFLICT (chat_jid) DO UPDATE SET queue = $2, rule = $3, assigned_at = $4, " + assignmentReset +
+			" WHERE chat_assignments.resolved_at IS NOT NULL"
+	case replace:
+		query = "INSERT OR REPLACE INTO chat_assignments (chat_jid, queue, rule, assigned_at) VALUES (?, ?, ?, ?)"
+	default:
+		query = "INSERT INTO chat_assignments (chat_jid, queue, rule, assigned_at) VALUES (?, ?, ?, ?) ON CONFLICT (chat_jid) DO UPDATE SET queue = excluded.queue, rule = excluded.rule, assigned_at = excluded.assigned_at, " + assignmentReset +
+			" WHERE chat_assignments.resolved_at IS NOT NULL"
+	}
+
+	result, err := store.db.Exec(query, chatJID, queue, rule, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// GetAssignment returns the queue assignment of a chat, or nil if it has none
+func (store *MessageStore) GetAssignment(chatJID string) (*ChatAssignment, error) {
+	query := "SELECT " + assignmentFields + " FROM chat_assignments WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "SELECT " + assignmentFields + " FROM chat_assignments WHERE chat_jid = $1"
+	}
+
+	assignment, err := scanAssignment(store.db.QueryRow(query, chatJID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return assignment, err
+}
+
+// ListAssignments returns the chats assigned to a queue, or all assignments, oldest first.
+// state limits them to AssignmentsOpen or AssignmentsResolved.
+func (store *MessageStore) ListAssignments(queue, state string) ([]ChatAssignment, error) {
+	var conditions []string
+	var args []interface{}
+	if queue != "" {
+		args = append(args, queue)
+		if store.isPostgres {
+			conditions = append(conditions, "queue = $1")
+		} else {
+			conditions = append(conditions, "queue = ?")
+		}
+	}
+	switch state {
+	case AssignmentsOpen:
+		conditions = append(conditions, "resolved_at IS NULL")
+	case AssignmentsResolved:
+		conditions = append(conditions, "resolved_at IS NOT NULL")
+	}
+	query := "SELECT " + assignmentFields + " FROM chat_assignments"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY assigned_at"
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []ChatAssignment{}
+	for rows.Next() {
+		assignment, err := scanAssignment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, *assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// Unassign removes the queue assignment of a chat
+func (store *MessageStore) Unassign(chatJID string) error {
+	query := "DELETE FROM chat_assignments WHERE chat_jid = ?"
+	if store.isPostgres {
+		query = "DELETE FROM chat_assignments WHERE chat_jid = $1"
+	}
+	_, err := store.db.Exec(query, chatJID)
+	return err
+}
+
+// registerRoutingRoutes registers /api/v1/routing/rules, /api/v1/assignments and /api/v1/chats/{jid}/assignment
+func registerRoutingRoutes(messageStore *MessageStore) {
+	handleAPI("/routing/rules", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rules := []*RoutingRule{}
+		if messageRouter != nil {
+			rules = messageRouter.rules
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	})
+
+	handleAPI("/assignments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		state := r.URL.Query().Get("state")
+		if state != "" && state != AssignmentsOpen && state != AssignmentsResolved {
+			http.Error(w, "state must be open or resolved", http.StatusBadRequest)
+			return
+		}
+
+		assignments, err := messageStore.ListAssignments(r.URL.Query().Get("queue"), state)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list assignments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		names, err := messageStore.chatNames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get chat names: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range assignments {
+			assignments[i].Name = names[assignments[i].ChatJID]
+			assignments[i].localize(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignments)
+	})
+
+	registerChatRoute("assignment", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the current assignment below
+
+		case http.MethodPut:
+			// Manual (re)assignment, e.g. when an agent hands a chat over
+			var req struct {
+				Queue string `json:"queue"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Queue == "" {
+				http.Error(w, "queue is required", http.StatusBadRequest)
+				return
+			}
+			if _, err := messageStore.AssignChat(chatJID, req.Queue, "manual", true); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to assign chat: %v", err), http.StatusInternalServerError)
+				return
+			}
+			publishEvent(EventChatAssigned, chatJID, time.Time{}, map[string]interface{}{
+				"chat_jid": chatJID,
+				"queue":    req.Queue,
+				"rule":     "manual",
+			})
+
+		case http.MethodDelete:
+			if err := messageStore.Unassign(chatJID); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to unassign chat: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		assignment, err := messageStore.GetAssignment(chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get assignment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if assignment == nil {
+			http.Error(w, "Chat is not assigned", http.StatusNotFound)
+			return
+		}
+		assignment.localize(loc)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	})
+}
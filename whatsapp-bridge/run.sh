@@ -1,4 +1,4 @@
 #!/bin/bash
 
 echo "Starting WhatsApp Bridge..."
-go run main.go database.go qr_web.go wrapper.go 
\ No newline at end of file
+go run .
\ No newline at end of file
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds a single upload
+const s3Timeout = 2 * time.Minute
+
+// S3Bucket uploads objects to an S3 bucket, or an S3-compatible store such as MinIO, with
+// Signature Version 4. Only PutObject is needed, so this avoids pulling in the AWS SDK.
+type S3Bucket struct {
+	bucket       string
+	region       string
+	endpoint     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	httpClient   *http.Client
+}
+
+// NewS3BucketFromEnv returns the bucket with credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN. Without an endpoint, the AWS endpoint of the region is used.
+func NewS3BucketFromEnv(bucket, region, endpoint string) (*S3Bucket, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint != "" && !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	s3 := &S3Bucket{
+		bucket:       bucket,
+		region:       region,
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		httpClient:   &http.Client{Timeout: s3Timeout},
+	}
+	if s3.accessKey == "" || s3.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required to upload to S3")
+	}
+	return s3, nil
+}
+
+// objectURL addresses AWS buckets by virtual host and custom endpoints by path, which is what
+// S3-compatible stores support
+func (s3 *S3Bucket) objectURL(key string) *url.URL {
+	escaped := s3Escape("/" + key)
+	if s3.endpoint == "" {
+		u, _ := url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s3.bucket, s3.region, escaped))
+		return u
+	}
+	u, _ := url.Parse(s3.endpoint + "/" + s3.bucket + escaped)
+	return u
+}
+
+// PutObject uploads an object. Headers such as the object lock settings are signed with it.
+func (s3 *S3Bucket) PutObject(key, contentType string, body []byte, headers map[string]string) error {
+	target := s3.objectURL(key)
+	req, err := http.NewRequest(http.MethodPut, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	md5Sum := md5.Sum(body)
+	req.Header.Set("Content-Type", contentType)
+	// Object lock uploads must carry a checksum
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s3.sign(req, body, time.Now().UTC())
+
+	resp, err := s3.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 authorization of a request
+func (s3 *S3Bucket) sign(req *http.Request, body []byte, now time.Time) {
+	payloadSum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payloadSum[:])
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s3.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s3.sessionToken)
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + s3.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s3.secretKey), day)
+	key = hmacSHA256(key, s3.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape percent-encodes a path the way Signature Version 4 expects: every byte but unreserved
+// characters and slashes
+func s3Escape(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', strings.IndexByte("-_.~/", b) >= 0:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
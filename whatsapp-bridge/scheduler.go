@@ -0,0 +1,944 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Statuses of a scheduled message
+const (
+	ScheduledPending  = "pending"
+	ScheduledSending  = "sending"
+	ScheduledSent     = "sent"
+	ScheduledFailed   = "failed"
+	ScheduledCanceled = "canceled"
+	// ScheduledAwaitingApproval holds the messages of a campaign until an admin approves it
+	ScheduledAwaitingApproval = "awaiting_approval"
+)
+
+// scheduledMaxAttempts is how often a message whose send failed with a retryable error is tried
+const scheduledMaxAttempts = 5
+
+// scheduledSendGap spaces out the messages that are due at once, so a campaign doesn't burst
+const scheduledSendGap = time.Second
+
+// maxCampaignRecipients bounds the rows a single campaign request creates
+const maxCampaignRecipients = 10000
+
+// scheduledColumns are the columns scanScheduledMessage reads, in order
+const scheduledColumns = `id, COALESCE(campaign_id, ''), COALESCE(series_id, ''), recipient, chat_jid, COALESCE(message, ''), COALESCE(media_path, ''),
+	COALESCE(client_ref, ''), COALESCE(agent, ''), send_at, timezone, timezone_source, status, attempts,
+	COALESCE(message_id, ''), COALESCE(error, ''), COALESCE(error_code, ''), created_at, sent_at`
+
+// ScheduledMessage is a message the scheduler sends at SendAt. Times are stored in UTC; Timezone is
+// the recipient's, which a local_time was resolved in.
+type ScheduledMessage struct {
+	ID             string     `json:"id"`
+	CampaignID     string     `json:"campaign_id,omitempty"`
+	SeriesID       string     `json:"series_id,omitempty"`
+	Recipient      string     `json:"recipient"`
+	ChatJID        string     `json:"chat_jid"`
+	Message        string     `json:"message,omitempty"`
+	MediaPath      string     `json:"media_path,omitempty"`
+	ClientRef      string     `json:"client_ref,omitempty"`
+	Agent          string     `json:"agent,omitempty"`
+	SendAt         time.Time  `json:"send_at"`
+	Timezone       string     `json:"timezone"`
+	TimezoneSource string     `json:"timezone_source"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	MessageID      string     `json:"message_id,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	ErrorCode      string     `json:"error_code,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+}
+
+// campaignColumns are the columns listCampaigns reads
+const campaignColumns = `id, name, created_at, COALESCE(approval, ''), COALESCE(requested_by, ''), COALESCE(reviewed_by, ''),
+	reviewed_at, COALESCE(review_note, ''), COALESCE(template_id, '')`
+
+// Campaign is a message scheduled for many recipients at once
+type Campaign struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Counts is the number of its messages in each status
+	Counts      map[string]int `json:"counts"`
+	FirstSendAt *time.Time     `json:"first_send_at,omitempty"`
+	LastSendAt  *time.Time     `json:"last_send_at,omitempty"`
+	// Approval is pending, approved, rejected or withdrawn for a campaign that needs an admin's approval
+	Approval    string     `json:"approval,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+	// TemplateID is the template the messages were filled in from, if any
+	TemplateID string `json:"template_id,omitempty"`
+}
+
+// ScheduleRequest is the body of POST /api/v1/scheduled. Either SendAt, an RFC 3339 time, or
+// LocalTime, HH:MM in the recipient's timezone, is required.
+type ScheduleRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message"`
+	MediaPath string `json:"media_path,omitempty"`
+	ClientRef string `json:"client_ref,omitempty"`
+	Agent     string `json:"agent,omitempty"`
+	SendAt    string `json:"send_at,omitempty"`
+	LocalTime string `json:"local_time,omitempty"`
+	// Date is the day, YYYY-MM-DD in the recipient's timezone, a LocalTime is on; without it
+	// the message goes the next time the recipient's clock shows LocalTime
+	Date string `json:"date,omitempty"`
+}
+
+// CampaignRequest is the body of POST /api/v1/campaigns
+type CampaignRequest struct {
+	Name       string   `json:"name"`
+	Recipients []string `json:"recipients"`
+	Message    string   `json:"message"`
+	MediaPath  string   `json:"media_path,omitempty"`
+	Agent      string   `json:"agent,omitempty"`
+	SendAt     string   `json:"send_at,omitempty"`
+	LocalTime  string   `json:"local_time,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	// SpreadMinutes spaces out the recipients due at the same moment evenly over that many minutes
+	SpreadMinutes int `json:"spread_minutes,omitempty"`
+	// RequireApproval holds the campaign for an admin's approval even without CAMPAIGN_APPROVAL
+	RequireApproval bool `json:"require_approval,omitempty"`
+	// TemplateID sends a stored template instead of Message, filled in for each recipient from their
+	// contact attributes and Variables
+	TemplateID string            `json:"template_id,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+}
+
+// Scheduler sends scheduled messages once they're due. Only the leader runs it.
+type Scheduler struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+	interval     time.Duration
+	batch        int
+}
+
+// messageScheduler sends the scheduled messages once this replica leads
+var messageScheduler *Scheduler
+
+// NewSchedulerFromEnv reads SCHEDULER_POLL_SECONDS and SCHEDULER_BATCH_SIZE
+func NewSchedulerFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*Scheduler, error) {
+	scheduler := &Scheduler{
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+		interval:     time.Duration(getEnvInt("SCHEDULER_POLL_SECONDS", 30)) * time.Second,
+		batch:        getEnvInt("SCHEDULER_BATCH_SIZE", 50),
+	}
+	if scheduler.interval < time.Second {
+		return nil, fmt.Errorf("SCHEDULER_POLL_SECONDS must be positive")
+	}
+	if scheduler.batch < 1 {
+		return nil, fmt.Errorf("SCHEDULER_BATCH_SIZE must be positive")
+	}
+	return scheduler, nil
+}
+
+// Start gives up on messages a previous run was sending, then sends due messages every interval
+func (s *Scheduler) Start() {
+	// A message that was being sent when the bridge stopped may have gone out; sending it again could duplicate it
+	if interrupted, err := s.messageStore.FailInterruptedScheduledMessages(); err != nil {
+		s.logger.Warnf("Failed to clean up interrupted scheduled messages: %v", err)
+	} else if interrupted > 0 {
+		s.logger.Warnf("Marked %d scheduled messages interrupted by a restart as failed", interrupted)
+	}
+
+	go func() {
+		for {
+			s.sendDue()
+			time.Sleep(s.interval)
+		}
+	}()
+}
+
+// paused reports why due messages have to wait, or "" when they can go out
+func (s *Scheduler) paused() string {
+	switch {
+	case maintenance.Status().State != MaintenanceOff:
+		return "maintenance"
+	case sessionGuard.Current() != nil:
+		return "session lock"
+	case !s.client.IsConnected():
+		return "not connected"
+	}
+	return ""
+}
+
+// sendDue queues the due occurrences of recurring series, then sends the messages whose time has come, oldest first
+func (s *Scheduler) sendDue() {
+	if reason := s.paused(); reason != "" {
+		s.logger.Debugf("Scheduled messages wait (%s)", reason)
+		return
+	}
+
+	s.queueRecurring(time.Now().UTC())
+
+	due, err := s.messageStore.DueScheduledMessages(time.Now().UTC(), s.batch)
+	if err != nil {
+		s.logger.Warnf("Failed to list due scheduled messages: %v", err)
+		return
+	}
+	for i := range due {
+		if i > 0 {
+			time.Sleep(scheduledSendGap)
+		}
+		if s.paused() != "" {
+			return
+		}
+		s.send(&due[i])
+	}
+}
+
+// send sends one scheduled message and records the outcome; retryable failures try again later
+func (s *Scheduler) send(scheduled *ScheduledMessage) {
+	// Claiming the message first keeps a canceled one from going out
+	claimed, err := s.messageStore.ClaimScheduledMessage(scheduled.ID)
+	if err != nil || !claimed {
+		if err != nil {
+			s.logger.Warnf("Failed to claim scheduled message %s: %v", scheduled.ID, err)
+		}
+		return
+	}
+
+	opts := SendOptions{ClientRef: scheduled.ClientRef, Agent: scheduled.Agent}
+	success, result, messageID, code := sendWhatsAppMessage(s.client, scheduled.Recipient, scheduled.Message, scheduled.MediaPath, opts, s.messageStore)
+	attempts := scheduled.Attempts + 1
+	now := time.Now().UTC()
+
+	switch {
+	case success:
+		err = s.messageStore.FinishScheduledMessage(scheduled.ID, ScheduledSent, attempts, messageID, "", "", &now, scheduled.SendAt)
+	case sendErrorRetryable[code] && attempts < scheduledMaxAttempts:
+		// Back off a little longer after every attempt
+		retryAt := now.Add(time.Duration(attempts) * 5 * time.Minute)
+		s.logger.Infof("Scheduled message %s to %s failed (%s), retrying at %s", scheduled.ID, logRedactor.Phone(scheduled.Recipient), result, retryAt.Format(time.RFC3339))
+		err = s.messageStore.FinishScheduledMessage(scheduled.ID, ScheduledPending, attempts, "", result, code, nil, retryAt)
+	default:
+		s.logger.Warnf("Scheduled message %s to %s failed: %s", scheduled.ID, logRedactor.Phone(scheduled.Recipient), result)
+		err = s.messageStore.FinishScheduledMessage(scheduled.ID, ScheduledFailed, attempts, "", result, code, nil, scheduled.SendAt)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to record outcome of scheduled message %s: %v", scheduled.ID, err)
+	}
+}
+
+// scheduleTime resolves when a message to a recipient goes out: at sendAt, or at localTime on date
+// in the recipient's timezone. It returns the time with the timezone used and where it came from.
+func scheduleTime(messageStore *MessageStore, recipient, sendAt, localTime, date string, now time.Time) (time.Time, string, string, error) {
+	jid, err := parseRecipient(recipient)
+	if err != nil {
+		return time.Time{}, "", "", fmt.Errorf("invalid recipient %q: %v", recipient, err)
+	}
+
+	switch {
+	case sendAt != "" && localTime != "":
+		return time.Time{}, "", "", fmt.Errorf("send_at and local_time are mutually exclusive")
+	case sendAt != "":
+		if date != "" {
+			return time.Time{}, "", "", fmt.Errorf("date only applies to local_time")
+		}
+		at, err := time.Parse(time.RFC3339, sendAt)
+		if err != nil {
+			return time.Time{}, "", "", fmt.Errorf("send_at must be an RFC 3339 time")
+		}
+		return at.UTC(), "UTC", "", nil
+	case localTime != "":
+		loc, source := recipientLocation(messageStore, jid)
+		at, err := localSendTime(loc, localTime, date, now)
+		if err != nil {
+			return time.Time{}, "", "", err
+		}
+		return at.UTC(), loc.String(), source, nil
+	}
+	return time.Time{}, "", "", fmt.Errorf("send_at or local_time is required")
+}
+
+// spreadSendTimes spaces out messages due at the same moment evenly over a window, in place
+func spreadSendTimes(messages []ScheduledMessage, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	slots := make(map[time.Time][]int)
+	for i := range messages {
+		slots[messages[i].SendAt] = append(slots[messages[i].SendAt], i)
+	}
+	for _, indexes := range slots {
+		step := window / time.Duration(len(indexes))
+		for n, i := range indexes {
+			messages[i].SendAt = messages[i].SendAt.Add(time.Duration(n) * step)
+		}
+	}
+}
+
+// scanScheduledMessage reads a row of scheduledColumns
+func scanScheduledMessage(row interface{ Scan(...interface{}) error }) (*ScheduledMessage, error) {
+	var scheduled ScheduledMessage
+	var sentAt sql.NullTime
+	if err := row.Scan(&scheduled.ID, &scheduled.CampaignID, &scheduled.SeriesID, &scheduled.Recipient, &scheduled.ChatJID, &scheduled.Message,
+		&scheduled.MediaPath, &scheduled.ClientRef, &scheduled.Agent, &scheduled.SendAt, &scheduled.Timezone,
+		&scheduled.TimezoneSource, &scheduled.Status, &scheduled.Attempts, &scheduled.MessageID, &scheduled.Error,
+		&scheduled.ErrorCode, &scheduled.CreatedAt, &sentAt); err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		scheduled.SentAt = &sentAt.Time
+	}
+	return &scheduled, nil
+}
+
+// localize converts the times of a scheduled message to a response's time zone
+func (scheduled *ScheduledMessage) localize(loc *time.Location) {
+	scheduled.SendAt = scheduled.SendAt.In(loc)
+	scheduled.CreatedAt = scheduled.CreatedAt.In(loc)
+	if scheduled.SentAt != nil {
+		sentAt := scheduled.SentAt.In(loc)
+		scheduled.SentAt = &sentAt
+	}
+}
+
+// insertScheduledQuery adds a scheduled message
+func (store *MessageStore) insertScheduledQuery() string {
+	query := `INSERT INTO scheduled_messages (id, campaign_id, series_id, recipient, chat_jid, message, media_path, client_ref, agent,
+		send_at, timezone, timezone_source, status, attempts, created_at)
+		VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, 0, ?)`
+	if store.isPostgres {
+		query = `INSERT INTO scheduled_messages (id, campaign_id, series_id, recipient, chat_jid, message, media_path, client_ref, agent,
+		send_at, timezone, timezone_source, status, attempts, created_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, $13, 0, $14)`
+	}
+	return query
+}
+
+// AddScheduledMessage stores a message to send later
+func (store *MessageStore) AddScheduledMessage(scheduled *ScheduledMessage) error {
+	_, err := store.db.Exec(store.insertScheduledQuery(), scheduled.ID, scheduled.CampaignID, scheduled.SeriesID, scheduled.Recipient, scheduled.ChatJID,
+		scheduled.Message, scheduled.MediaPath, scheduled.ClientRef, scheduled.Agent, scheduled.SendAt, scheduled.Timezone,
+		scheduled.TimezoneSource, scheduled.Status, scheduled.CreatedAt)
+	return err
+}
+
+// AddCampaign stores a campaign and its messages together
+func (store *MessageStore) AddCampaign(campaign *Campaign, messages []ScheduledMessage) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	campaignQuery := `INSERT INTO campaigns (id, name, created_at, approval, requested_by, template_id)
+		VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))`
+	if store.isPostgres {
+		campaignQuery = `INSERT INTO campaigns (id, name, created_at, approval, requested_by, template_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))`
+	}
+	if _, err := tx.Exec(campaignQuery, campaign.ID, campaign.Name, campaign.CreatedAt, campaign.Approval, campaign.RequestedBy,
+		campaign.TemplateID); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(store.insertScheduledQuery())
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, scheduled := range messages {
+		if _, err := insert.Exec(scheduled.ID, scheduled.CampaignID, scheduled.SeriesID, scheduled.Recipient, scheduled.ChatJID, scheduled.Message,
+			scheduled.MediaPath, scheduled.ClientRef, scheduled.Agent, scheduled.SendAt, scheduled.Timezone,
+			scheduled.TimezoneSource, scheduled.Status, scheduled.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetScheduledMessage returns a scheduled message, or nil if there is none with the ID
+func (store *MessageStore) GetScheduledMessage(id string) (*ScheduledMessage, error) {
+	query := "SELECT " + scheduledColumns + " FROM scheduled_messages WHERE id = ?"
+	if store.isPostgres {
+		query = "SELECT " + scheduledColumns + " FROM scheduled_messages WHERE id = $1"
+	}
+	scheduled, err := scanScheduledMessage(store.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return scheduled, err
+}
+
+// ListScheduledMessages returns scheduled messages by send time, optionally of one status, campaign or series
+func (store *MessageStore) ListScheduledMessages(status, campaignID, seriesID string, limit int) ([]ScheduledMessage, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	var conditions []string
+	if status != "" {
+		conditions = append(conditions, "status = "+arg(status))
+	}
+	if campaignID != "" {
+		conditions = append(conditions, "campaign_id = "+arg(campaignID))
+	}
+	if seriesID != "" {
+		conditions = append(conditions, "series_id = "+arg(seriesID))
+	}
+	query := "SELECT " + scheduledColumns + " FROM scheduled_messages"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY send_at ASC LIMIT " + arg(limit)
+
+	return store.queryScheduledMessages(query, args...)
+}
+
+// DueScheduledMessages returns pending messages whose send time has come, oldest first
+func (store *MessageStore) DueScheduledMessages(now time.Time, limit int) ([]ScheduledMessage, error) {
+	query := "SELECT " + scheduledColumns + " FROM scheduled_messages WHERE status = ? AND send_at <= ? ORDER BY send_at ASC LIMIT ?"
+	if store.isPostgres {
+		query = "SELECT " + scheduledColumns + " FROM scheduled_messages WHERE status = $1 AND send_at <= $2 ORDER BY send_at ASC LIMIT $3"
+	}
+	return store.queryScheduledMessages(query, ScheduledPending, now, limit)
+}
+
+func (store *MessageStore) queryScheduledMessages(query string, args ...interface{}) ([]ScheduledMessage, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ScheduledMessage{}
+	for rows.Next() {
+		scheduled, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *scheduled)
+	}
+	return messages, rows.Err()
+}
+
+// ClaimScheduledMessage moves a pending message to sending, reporting whether it was still pending
+func (store *MessageStore) ClaimScheduledMessage(id string) (bool, error) {
+	query := "UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?"
+	if store.isPostgres {
+		query = "UPDATE scheduled_messages SET status = $1 WHERE id = $2 AND status = $3"
+	}
+	result, err := store.db.Exec(query, ScheduledSending, id, ScheduledPending)
+	if err != nil {
+		return false, err
+	}
+	claimed, _ := result.RowsAffected()
+	return claimed > 0, nil
+}
+
+// FinishScheduledMessage records the outcome of a send; a pending status with a later sendAt retries it
+func (store *MessageStore) FinishScheduledMessage(id, status string, attempts int, messageID, errorMessage, errorCode string, sentAt *time.Time, sendAt time.Time) error {
+	query := `UPDATE scheduled_messages SET status = ?, attempts = ?, message_id = NULLIF(?, ''), error = NULLIF(?, ''),
+		error_code = NULLIF(?, ''), sent_at = ?, send_at = ? WHERE id = ?`
+	if store.isPostgres {
+		query = `UPDATE scheduled_messages SET status = $1, attempts = $2, message_id = NULLIF($3, ''), error = NULLIF($4, ''),
+		error_code = NULLIF($5, ''), sent_at = $6, send_at = $7 WHERE id = $8`
+	}
+	_, err := store.db.Exec(query, status, attempts, messageID, errorMessage, errorCode, sentAt, sendAt, id)
+	return err
+}
+
+// FailInterruptedScheduledMessages marks messages left sending by a previous run as failed
+func (store *MessageStore) FailInterruptedScheduledMessages() (int64, error) {
+	query := "UPDATE scheduled_messages SET status = ?, error = ? WHERE status = ?"
+	if store.isPostgres {
+		query = "UPDATE scheduled_messages SET status = $1, error = $2 WHERE status = $3"
+	}
+	result, err := store.db.Exec(query, ScheduledFailed, "Interrupted while sending; the message may have been delivered", ScheduledSending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CancelScheduledMessages cancels the pending messages with an ID or of a campaign, returning how many
+func (store *MessageStore) CancelScheduledMessages(id, campaignID string) (int64, error) {
+	if campaignID != "" {
+		return store.cancelScheduledWhere("campaign_id", campaignID)
+	}
+	return store.cancelScheduledWhere("id", id)
+}
+
+// cancelScheduledWhere cancels the pending messages whose column has a value, including those awaiting approval
+func (store *MessageStore) cancelScheduledWhere(column, value string) (int64, error) {
+	query := "UPDATE scheduled_messages SET status = ? WHERE " + column + " = ? AND status IN (?, ?)"
+	if store.isPostgres {
+		query = "UPDATE scheduled_messages SET status = $1 WHERE " + column + " = $2 AND status IN ($3, $4)"
+	}
+	result, err := store.db.Exec(query, ScheduledCanceled, value, ScheduledPending, ScheduledAwaitingApproval)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetCampaign returns a campaign with the counts of its messages, or nil if there is none with the ID
+func (store *MessageStore) GetCampaign(id string) (*Campaign, error) {
+	campaigns, err := store.listCampaigns(id)
+	if err != nil || len(campaigns) == 0 {
+		return nil, err
+	}
+	return &campaigns[0], nil
+}
+
+// ListCampaigns returns all campaigns, newest first
+func (store *MessageStore) ListCampaigns() ([]Campaign, error) {
+	return store.listCampaigns("")
+}
+
+func (store *MessageStore) listCampaigns(id string) ([]Campaign, error) {
+	query := "SELECT " + campaignColumns + " FROM campaigns ORDER BY created_at DESC"
+	countQuery := `SELECT campaign_id, status, COUNT(*), MIN(send_at), MAX(send_at) FROM scheduled_messages
+		WHERE campaign_id IS NOT NULL GROUP BY campaign_id, status`
+	var args []interface{}
+	if id != "" {
+		args = append(args, id)
+		query = "SELECT " + campaignColumns + " FROM campaigns WHERE id = ?"
+		countQuery = "SELECT campaign_id, status, COUNT(*), MIN(send_at), MAX(send_at) FROM scheduled_messages WHERE campaign_id = ? GROUP BY campaign_id, status"
+		if store.isPostgres {
+			query = strings.Replace(query, "?", "$1", 1)
+			countQuery = strings.Replace(countQuery, "?", "$1", 1)
+		}
+	}
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	campaigns := []Campaign{}
+	index := make(map[string]int)
+	for rows.Next() {
+		campaign := Campaign{Counts: make(map[string]int)}
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&campaign.ID, &campaign.Name, &campaign.CreatedAt, &campaign.Approval, &campaign.RequestedBy,
+			&campaign.ReviewedBy, &reviewedAt, &campaign.ReviewNote, &campaign.TemplateID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if reviewedAt.Valid {
+			campaign.ReviewedAt = &reviewedAt.Time
+		}
+		index[campaign.ID] = len(campaigns)
+		campaigns = append(campaigns, campaign)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = store.db.Query(countQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var campaignID, status string
+		var count int
+		var first, last aggregateTime
+		if err := rows.Scan(&campaignID, &status, &count, &first, &last); err != nil {
+			return nil, err
+		}
+		i, ok := index[campaignID]
+		if !ok {
+			continue
+		}
+		campaign := &campaigns[i]
+		campaign.Counts[status] = count
+		if first.Valid && (campaign.FirstSendAt == nil || first.Time.Before(*campaign.FirstSendAt)) {
+			at := first.Time
+			campaign.FirstSendAt = &at
+		}
+		if last.Valid && (campaign.LastSendAt == nil || last.Time.After(*campaign.LastSendAt)) {
+			at := last.Time
+			campaign.LastSendAt = &at
+		}
+	}
+	return campaigns, rows.Err()
+}
+
+// aggregateTime scans MIN and MAX of a timestamp, which SQLite returns as text
+type aggregateTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *aggregateTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Valid = false
+	case time.Time:
+		t.Time, t.Valid = v, true
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	default:
+		return fmt.Errorf("unsupported time value %T", value)
+	}
+	return nil
+}
+
+func (t *aggregateTime) parse(value string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02T15:04:05.999999999-07:00", "2006-01-02 15:04:05", time.RFC3339Nano} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			t.Time, t.Valid = parsed.UTC(), true
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time %q", value)
+}
+
+// localize converts the times of a campaign to a response's time zone
+func (campaign *Campaign) localize(loc *time.Location) {
+	campaign.CreatedAt = campaign.CreatedAt.In(loc)
+	for _, at := range []*time.Time{campaign.FirstSendAt, campaign.LastSendAt, campaign.ReviewedAt} {
+		if at != nil {
+			*at = at.In(loc)
+		}
+	}
+}
+
+// validateScheduledContent checks the parts of a scheduled message shared by single messages and campaigns
+func validateScheduledContent(message, mediaPath, clientRef, agent string) error {
+	switch {
+	case message == "" && mediaPath == "":
+		return fmt.Errorf("Message or media path is required")
+	case len(clientRef) > maxClientRefLen:
+		return fmt.Errorf("client_ref must be at most %d characters", maxClientRefLen)
+	case len(agent) > maxAgentLen:
+		return fmt.Errorf("agent must be at most %d characters", maxAgentLen)
+	}
+	return nil
+}
+
+// registerSchedulerRoutes registers /api/v1/scheduled, /api/v1/scheduled/{id}, /api/v1/campaigns
+// and /api/v1/campaigns/{id}
+func registerSchedulerRoutes(messageStore *MessageStore) {
+	handleAPI("/scheduled", func(w http.ResponseWriter, r *http.Request) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			limit := 100
+			if value := query.Get("limit"); value != "" {
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 1 || limit > 1000 {
+					http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+					return
+				}
+			}
+			messages, err := messageStore.ListScheduledMessages(query.Get("status"), query.Get("campaign_id"), query.Get("series_id"), limit)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list scheduled messages: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for i := range messages {
+				messages[i].localize(loc)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(messages)
+
+		case http.MethodPost:
+			var req ScheduleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if req.Recipient == "" {
+				http.Error(w, "Recipient is required", http.StatusBadRequest)
+				return
+			}
+			if err := validateScheduledContent(req.Message, req.MediaPath, req.ClientRef, req.Agent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			now := time.Now().UTC()
+			sendAt, timezone, source, err := scheduleTime(messageStore, req.Recipient, req.SendAt, req.LocalTime, req.Date, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			jid, _ := parseRecipient(req.Recipient)
+
+			scheduled := &ScheduledMessage{
+				ID:             newEventID(),
+				Recipient:      req.Recipient,
+				ChatJID:        jid.String(),
+				Message:        req.Message,
+				MediaPath:      req.MediaPath,
+				ClientRef:      req.ClientRef,
+				Agent:          req.Agent,
+				SendAt:         sendAt,
+				Timezone:       timezone,
+				TimezoneSource: source,
+				Status:         ScheduledPending,
+				CreatedAt:      now,
+			}
+			if err := messageStore.AddScheduledMessage(scheduled); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to schedule message: %v", err), http.StatusInternalServerError)
+				return
+			}
+			scheduled.localize(loc)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(scheduled)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	handleAPI("/scheduled/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(apiRoute(r), "/scheduled/")
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the message below
+
+		case http.MethodDelete:
+			if _, err := messageStore.CancelScheduledMessages(id, ""); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to cancel scheduled message: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		scheduled, err := messageStore.GetScheduledMessage(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get scheduled message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if scheduled == nil {
+			http.Error(w, "Scheduled message not found", http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete && scheduled.Status != ScheduledCanceled {
+			http.Error(w, fmt.Sprintf("Only pending messages can be canceled; this one is %s", scheduled.Status), http.StatusConflict)
+			return
+		}
+		scheduled.localize(loc)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduled)
+	})
+
+	handleAPI("/campaigns", func(w http.ResponseWriter, r *http.Request) {
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			campaigns, err := messageStore.ListCampaigns()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list campaigns: %v", err), http.StatusInternalServerError)
+				return
+			}
+			// ?approval=pending is the queue of campaigns waiting for an admin
+			approval := r.URL.Query().Get("approval")
+			filtered := []Campaign{}
+			for i := range campaigns {
+				if approval != "" && campaigns[i].Approval != approval {
+					continue
+				}
+				campaigns[i].localize(loc)
+				filtered = append(filtered, campaigns[i])
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(filtered)
+
+		case http.MethodPost:
+			var req CampaignRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(req.Name) == "" {
+				http.Error(w, "name is required", http.StatusBadRequest)
+				return
+			}
+			if len(req.Recipients) == 0 || len(req.Recipients) > maxCampaignRecipients {
+				http.Error(w, fmt.Sprintf("recipients must list between 1 and %d recipients", maxCampaignRecipients), http.StatusBadRequest)
+				return
+			}
+			var template *MessageTemplate
+			if req.TemplateID != "" {
+				if template, err = messageStore.GetMessageTemplate(req.TemplateID); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to get template: %v", err), http.StatusInternalServerError)
+					return
+				}
+				if template == nil {
+					http.Error(w, fmt.Sprintf("template %s not found", req.TemplateID), http.StatusBadRequest)
+					return
+				}
+				req.Message = template.Body
+			}
+			if err := validateScheduledContent(req.Message, req.MediaPath, "", req.Agent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.SpreadMinutes < 0 || req.SpreadMinutes > 24*60 {
+				http.Error(w, "spread_minutes must be between 0 and 1440", http.StatusBadRequest)
+				return
+			}
+
+			now := time.Now().UTC()
+			campaign := &Campaign{ID: newEventID(), Name: strings.TrimSpace(req.Name), CreatedAt: now, TemplateID: req.TemplateID}
+			// A campaign needing approval holds its messages until an admin approves it
+			status := ScheduledPending
+			if req.RequireApproval || getEnvBool("CAMPAIGN_APPROVAL", false) {
+				status = ScheduledAwaitingApproval
+				campaign.Approval = ApprovalPending
+				campaign.RequestedBy = req.Agent
+				if campaign.RequestedBy == "" {
+					campaign.RequestedBy = usageSubject(r)
+				}
+			}
+			messages := make([]ScheduledMessage, 0, len(req.Recipients))
+			seen := make(map[string]bool)
+			for _, recipient := range req.Recipients {
+				recipient = strings.TrimSpace(recipient)
+				sendAt, timezone, source, err := scheduleTime(messageStore, recipient, req.SendAt, req.LocalTime, req.Date, now)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				jid, _ := parseRecipient(recipient)
+				// A recipient listed twice gets the message once
+				if seen[jid.String()] {
+					continue
+				}
+				seen[jid.String()] = true
+				message := req.Message
+				if template != nil {
+					variables, err := messageStore.TemplateVariables(recipient, req.Variables)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					if message, err = template.fill(variables, SendOptions{Agent: req.Agent}); err != nil {
+						http.Error(w, fmt.Sprintf("recipient %s: %v", recipient, err), http.StatusBadRequest)
+						return
+					}
+				}
+				messages = append(messages, ScheduledMessage{
+					ID:             newEventID(),
+					CampaignID:     campaign.ID,
+					Recipient:      recipient,
+					ChatJID:        jid.String(),
+					Message:        message,
+					MediaPath:      req.MediaPath,
+					Agent:          req.Agent,
+					SendAt:         sendAt,
+					Timezone:       timezone,
+					TimezoneSource: source,
+					Status:         status,
+					CreatedAt:      now,
+				})
+			}
+			sort.SliceStable(messages, func(i, j int) bool { return messages[i].SendAt.Before(messages[j].SendAt) })
+			spreadSendTimes(messages, time.Duration(req.SpreadMinutes)*time.Minute)
+
+			if err := messageStore.AddCampaign(campaign, messages); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to create campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			created, err := messageStore.GetCampaign(campaign.ID)
+			if err != nil || created == nil {
+				http.Error(w, fmt.Sprintf("Failed to get campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if created.Approval == ApprovalPending {
+				publishCampaignApproval(EventCampaignApprovalRequested, created)
+			}
+			created.localize(loc)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	handleAPI("/campaigns/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(apiRoute(r), "/campaigns/")
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Answered with the campaign below
+
+		case http.MethodDelete:
+			// Canceling a campaign still waiting for approval withdraws it from review
+			if _, err := messageStore.ReviewCampaign(id, ApprovalWithdrawn, usageSubject(r), "", time.Now().UTC()); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to cancel campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if _, err := messageStore.CancelScheduledMessages("", id); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to cancel campaign: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		campaign, err := messageStore.GetCampaign(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get campaign: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if campaign == nil {
+			http.Error(w, "Campaign not found", http.StatusNotFound)
+			return
+		}
+		campaign.localize(loc)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(campaign)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchMessageTypes are the values of the type filter: text for messages without media, or a media type
+var searchMessageTypes = map[string]bool{"text": true, "image": true, "video": true, "audio": true, "document": true}
+
+// maxSearchOffset bounds paging, as Elasticsearch refuses to page past 10000 results by default
+const maxSearchOffset = 10000
+
+// SearchFilter narrows a message search. Zero fields don't filter.
+type SearchFilter struct {
+	ChatJID string
+	// Sender is the phone number of the sender
+	Sender string
+	Since  time.Time
+	Until  time.Time
+	Type   string
+	// Offset skips that many results, to page through them
+	Offset int
+}
+
+// SearchMessages returns the messages matching query, newest first. With the full-text index
+// messages match when they contain every word of the query, or words starting with them;
+// without it, or for a query without words, they match when their text or file name contains it.
+func (store *MessageStore) SearchMessages(query string, filter SearchFilter, limit int) ([]APIMessage, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		if store.isPostgres {
+			return fmt.Sprintf("$%d", len(args))
+		}
+		return "?"
+	}
+
+	if terms := fullTextTerms(query); store.fullTextSearch && len(terms) > 0 {
+		conditions = append(conditions, store.fullTextCondition(terms, arg))
+	} else {
+		// Escape LIKE wildcards so a search for "50%" means just that
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(query)) + "%"
+		conditions = append(conditions, fmt.Sprintf(`(LOWER(content) LIKE %s ESCAPE '\' OR LOWER(filename) LIKE %s ESCAPE '\')`, arg(pattern), arg(pattern)))
+	}
+	conditions = append(conditions, "system_event IS NULL")
+	if filter.ChatJID != "" {
+		conditions = append(conditions, "chat_jid = "+arg(filter.ChatJID))
+	}
+	if filter.Sender != "" {
+		conditions = append(conditions, "sender = "+arg(filter.Sender))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(filter.Since.UTC()))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(filter.Until.UTC()))
+	}
+	switch filter.Type {
+	case "":
+	case "text":
+		conditions = append(conditions, "COALESCE(media_type, '') = ''")
+	default:
+		conditions = append(conditions, "media_type = "+arg(filter.Type))
+	}
+
+	rows, err := store.db.Query(fmt.Sprintf("SELECT id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '') FROM messages WHERE %s ORDER BY timestamp DESC, id LIMIT %s OFFSET %s",
+		strings.Join(conditions, " AND "), arg(limit), arg(filter.Offset)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []APIMessage{}
+	for rows.Next() {
+		var msg APIMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.ClientRef, &msg.Agent, &msg.ReplyTo, &msg.Status); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// searchMessages answers a search from the search index with SEARCH_BACKEND=elasticsearch, and from
+// the database otherwise or while the index is unavailable. Matches are read back from the database,
+// so results are the same shape either way.
+func searchMessages(messageStore *MessageStore, query string, filter SearchFilter, limit int) ([]APIMessage, error) {
+	if searchIndex != nil && searchIndex.serveSearch {
+		keys, err := searchIndex.Search(query, filter, limit)
+		if err == nil {
+			return messageStore.GetMessagesByKey(keys)
+		}
+		fmt.Printf("Search index unavailable, searching the database: %v\n", err)
+	}
+	return messageStore.SearchMessages(query, filter, limit)
+}
+
+// registerSearchRoutes registers /api/v1/search?q=...&chat_jid=...&sender=...&since=...&until=...&type=...&limit=...&offset=...
+func registerSearchRoutes(messageStore *MessageStore) {
+	handleAPI("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if len([]rune(query)) < 2 {
+			http.Error(w, "q must be at least 2 characters", http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 500 {
+				http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+				return
+			}
+		}
+
+		filter := SearchFilter{ChatJID: r.URL.Query().Get("chat_jid"), Type: r.URL.Query().Get("type")}
+		// A sender JID searches by its phone number, the way senders are stored
+		if sender := r.URL.Query().Get("sender"); sender != "" {
+			filter.Sender, _, _ = strings.Cut(sender, "@")
+			filter.Sender, _, _ = strings.Cut(filter.Sender, ":")
+		}
+		if value := r.URL.Query().Get("since"); value != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid since parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("until"); value != "" {
+			if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid until parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if filter.Type != "" && !searchMessageTypes[filter.Type] {
+			http.Error(w, "type must be text, image, video, audio or document", http.StatusBadRequest)
+			return
+		}
+		if value := r.URL.Query().Get("offset"); value != "" {
+			filter.Offset, err = strconv.Atoi(value)
+			if err != nil || filter.Offset < 0 {
+				http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			if filter.Offset+limit > maxSearchOffset {
+				http.Error(w, fmt.Sprintf("offset plus limit must be at most %d", maxSearchOffset), http.StatusBadRequest)
+				return
+			}
+		}
+
+		messages, err := searchMessages(messageStore, query, filter, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to search messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// The middleware only sees chat_jid, so log the held chats a wider search reached
+		seen := map[string]bool{}
+		for i := range messages {
+			messages[i].Timestamp = messages[i].Timestamp.In(loc)
+			if filter.ChatJID == "" && !seen[messages[i].ChatJID] {
+				seen[messages[i].ChatJID] = true
+				legalHolds.RecordRequest(r, messages[i].ChatJID)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	})
+}
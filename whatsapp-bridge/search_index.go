@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// defaultSearchIndexMapping creates the index when ELASTICSEARCH_MAPPING_FILE isn't set
+const defaultSearchIndexMapping = `{
+  "mappings": {
+    "properties": {
+      "id": {"type": "keyword"},
+      "chat_jid": {"type": "keyword"},
+      "sender": {"type": "keyword"},
+      "content": {"type": "text"},
+      "timestamp": {"type": "date"},
+      "is_from_me": {"type": "boolean"},
+      "media_type": {"type": "keyword"},
+      "filename": {"type": "text"},
+      "agent": {"type": "keyword"},
+      "reply_to": {"type": "keyword"},
+      "system_event": {"type": "keyword"}
+    }
+  }
+}`
+
+// SearchIndex mirrors stored messages into an Elasticsearch or OpenSearch index
+type SearchIndex struct {
+	url      string
+	index    string
+	username string
+	password string
+	apiKey   string
+	mapping  []byte
+	// serveSearch answers /api/v1/search from the index instead of the database
+	serveSearch  bool
+	messageStore *MessageStore
+	client       *http.Client
+	logger       waLog.Logger
+
+	mutex sync.Mutex
+	ready bool
+}
+
+// searchIndex is set at startup when ELASTICSEARCH_URL is configured
+var searchIndex *SearchIndex
+
+// NewSearchIndexFromEnv reads the ELASTICSEARCH_* settings and SEARCH_BACKEND, and returns nil
+// when ELASTICSEARCH_URL is not set
+func NewSearchIndexFromEnv(messageStore *MessageStore, logger waLog.Logger) (*SearchIndex, error) {
+	backend := strings.ToLower(os.Getenv("SEARCH_BACKEND"))
+	if backend != "" && backend != "sql" && backend != "elasticsearch" {
+		return nil, fmt.Errorf("invalid SEARCH_BACKEND: %s (expected sql or elasticsearch)", backend)
+	}
+
+	endpoint := strings.TrimRight(os.Getenv("ELASTICSEARCH_URL"), "/")
+	if endpoint == "" {
+		if backend == "elasticsearch" {
+			return nil, fmt.Errorf("SEARCH_BACKEND=elasticsearch requires ELASTICSEARCH_URL")
+		}
+		return nil, nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("ELASTICSEARCH_URL must be an http or https URL")
+	}
+
+	index := &SearchIndex{
+		url:          endpoint,
+		index:        os.Getenv("ELASTICSEARCH_INDEX"),
+		username:     os.Getenv("ELASTICSEARCH_USERNAME"),
+		password:     os.Getenv("ELASTICSEARCH_PASSWORD"),
+		apiKey:       os.Getenv("ELASTICSEARCH_API_KEY"),
+		mapping:      []byte(defaultSearchIndexMapping),
+		serveSearch:  backend == "elasticsearch",
+		messageStore: messageStore,
+		client:       &http.Client{Timeout: time.Duration(getEnvInt("ELASTICSEARCH_TIMEOUT_SECONDS", 30)) * time.Second},
+		logger:       logger,
+	}
+	if index.index == "" {
+		index.index = "whatsapp-messages"
+	}
+	if path := os.Getenv("ELASTICSEARCH_MAPPING_FILE"); path != "" {
+		mapping, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ELASTICSEARCH_MAPPING_FILE: %v", err)
+		}
+		if !json.Valid(mapping) {
+			return nil, fmt.Errorf("ELASTICSEARCH_MAPPING_FILE is not valid JSON")
+		}
+		index.mapping = mapping
+	}
+	return index, nil
+}
+
+// Consumer receives message changes for the change capture, unredacted so they stay searchable
+func (s *SearchIndex) Consumer() (*changeConsumer, error) {
+	consumer := &changeConsumer{
+		name:      "Search index",
+		sink:      s,
+		tables:    map[string]bool{cdcTableMessages: true},
+		batchSize: getEnvInt("ELASTICSEARCH_BATCH_SIZE", 500),
+		queue:     make(chan ChangeEvent, getEnvInt("ELASTICSEARCH_QUEUE_SIZE", 10000)),
+		logger:    s.logger,
+	}
+	if consumer.batchSize < 1 {
+		return nil, fmt.Errorf("ELASTICSEARCH_BATCH_SIZE must be positive")
+	}
+	return consumer, nil
+}
+
+// Start creates the index, or checks it exists, in the background; changes retry until it does
+func (s *SearchIndex) Start() {
+	go func() {
+		if err := s.ensureIndex(); err != nil {
+			s.logger.Warnf("Search index %s is not available yet: %v", s.index, err)
+		}
+	}()
+}
+
+// request sends a request to the cluster with the configured credentials
+func (s *SearchIndex) request(method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(req)
+}
+
+// responseError turns an unsuccessful response into an error with the start of its body
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+	return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// ensureIndex creates the index with the mapping if it doesn't exist, and then fills it
+// with the messages stored so far in the background
+func (s *SearchIndex) ensureIndex() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ready {
+		return nil
+	}
+
+	resp, err := s.request(http.MethodHead, "/"+url.PathEscape(s.index), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		s.ready = true
+		return nil
+	case resp.StatusCode != http.StatusNotFound:
+		return fmt.Errorf("status %d checking index %s", resp.StatusCode, s.index)
+	}
+
+	resp, err = s.request(http.MethodPut, "/"+url.PathEscape(s.index), "application/json", s.mapping)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Another replica may have created it in the meantime, and will fill it
+	if resp.StatusCode == http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		if strings.Contains(string(body), "resource_already_exists_exception") {
+			s.ready = true
+			return nil
+		}
+		return fmt.Errorf("failed to create index %s: status 400: %s", s.index, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to create index %s: %v", s.index, responseError(resp))
+	}
+
+	s.ready = true
+	s.logger.Infof("Created search index %s, indexing stored messages", s.index)
+	go s.backfill()
+	return nil
+}
+
+// backfill indexes every stored message, in the batches of the full export
+func (s *SearchIndex) backfill() {
+	var cursor *exportCursor
+	indexed := 0
+	for {
+		batch, err := s.messageStore.ExportMessagesBatch(cursor, time.Time{}, time.Time{}, exportBatchSize)
+		if err != nil {
+			s.logger.Errorf("Search index backfill stopped after %d messages: %v", indexed, err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		events := make([]ChangeEvent, len(batch))
+		for i := range batch {
+			events[i] = ChangeEvent{Table: cdcTableMessages, Op: ChangeInsert, After: &batch[i]}
+		}
+		for attempt := 0; ; attempt++ {
+			if err = s.bulk(events); err == nil {
+				break
+			}
+			if attempt == cdcAttempts-1 {
+				s.logger.Errorf("Search index backfill stopped after %d messages: %v", indexed, err)
+				return
+			}
+			time.Sleep(time.Duration(1<<(attempt+1)) * time.Second)
+		}
+		indexed += len(batch)
+
+		last := batch[len(batch)-1]
+		cursor = &exportCursor{timestamp: last.Timestamp, chatJID: last.ChatJID, id: last.ID}
+	}
+	s.logger.Infof("Indexed %d stored messages into %s", indexed, s.index)
+}
+
+// searchDocumentID identifies a message in the index; message IDs are only unique within a chat
+func searchDocumentID(chatJID, id string) string {
+	return chatJID + "/" + id
+}
+
+// Send indexes a batch of message changes with the bulk API
+func (s *SearchIndex) Send(events []ChangeEvent) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+	return s.bulk(events)
+}
+
+// bulk writes inserts and updates as index actions and deletes as delete actions. Rejections that
+// a retry could fix fail the batch, which is safe to repeat; others are logged and skipped.
+func (s *SearchIndex) bulk(events []ChangeEvent) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, evt := range events {
+		target := map[string]string{"_index": s.index, "_id": searchDocumentID(evt.Key["chat_jid"], evt.Key["id"])}
+		if evt.Op == ChangeDelete {
+			encoder.Encode(map[string]interface{}{"delete": target})
+			continue
+		}
+		msg := evt.After.(*ExportedMessage)
+		target["_id"] = searchDocumentID(msg.ChatJID, msg.ID)
+		encoder.Encode(map[string]interface{}{"index": target})
+		encoder.Encode(msg)
+	}
+
+	resp, err := s.request(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			switch {
+			case outcome.Status < 300 || (action == "delete" && outcome.Status == http.StatusNotFound):
+			case outcome.Status == http.StatusTooManyRequests || outcome.Status >= 500:
+				return fmt.Errorf("%s of %s was rejected with status %d", action, outcome.ID, outcome.Status)
+			default:
+				s.logger.Warnf("Search index refused %s of %s: %s", action, outcome.ID, outcome.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// messageKey identifies a stored message
+type messageKey struct {
+	ChatJID string `json:"chat_jid"`
+	ID      string `json:"id"`
+}
+
+// Search returns the keys of the messages whose text or file name matches the query, newest first
+func (s *SearchIndex) Search(query string, filter SearchFilter, limit int) ([]messageKey, error) {
+	filters := []interface{}{}
+	if filter.ChatJID != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"chat_jid": filter.ChatJID}})
+	}
+	if filter.Sender != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"sender": filter.Sender}})
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		period := map[string]interface{}{}
+		if !filter.Since.IsZero() {
+			period["gte"] = filter.Since.UTC().Format(time.RFC3339)
+		}
+		if !filter.Until.IsZero() {
+			period["lt"] = filter.Until.UTC().Format(time.RFC3339)
+		}
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"timestamp": period}})
+	}
+	switch filter.Type {
+	case "":
+	case "text":
+		// Text messages have no media type, or an empty one
+		filters = append(filters, map[string]interface{}{"bool": map[string]interface{}{"should": []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"media_type": ""}},
+			map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "media_type"}}}},
+		}}})
+	default:
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"media_type": filter.Type}})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"from":    filter.Offset,
+		"size":    limit,
+		"sort":    []interface{}{map[string]interface{}{"timestamp": "desc"}},
+		"_source": []string{"id", "chat_jid"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": []interface{}{map[string]interface{}{
+					"multi_match": map[string]interface{}{"query": query, "fields": []string{"content", "filename"}, "operator": "and"},
+				}},
+				"filter":   filters,
+				"must_not": []interface{}{map[string]interface{}{"exists": map[string]interface{}{"field": "system_event"}}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.request(http.MethodPost, "/"+url.PathEscape(s.index)+"/_search", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source messageKey `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to read search response: %v", err)
+	}
+	keys := make([]messageKey, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		keys[i] = hit.Source
+	}
+	return keys, nil
+}
+
+// GetMessagesByKey returns the stored messages with the given keys, in the order of the keys.
+// Keys of messages that are no longer stored are skipped.
+func (store *MessageStore) GetMessagesByKey(keys []messageKey) ([]APIMessage, error) {
+	if len(keys) == 0 {
+		return []APIMessage{}, nil
+	}
+	var conditions []string
+	var args []interface{}
+	for _, key := range keys {
+		if store.isPostgres {
+			conditions = append(conditions, fmt.Sprintf("(chat_jid = $%d AND id = $%d)", len(args)+1, len(args)+2))
+		} else {
+			conditions = append(conditions, "(chat_jid = ? AND id = ?)")
+		}
+		args = append(args, key.ChatJID, key.ID)
+	}
+	found, err := store.queryMessages("SELECT "+threadMessageColumns+" FROM messages WHERE "+strings.Join(conditions, " OR "), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[messageKey]APIMessage, len(found))
+	for _, msg := range found {
+		byKey[messageKey{ChatJID: msg.ChatJID, ID: msg.ID}] = msg
+	}
+	messages := []APIMessage{}
+	for _, key := range keys {
+		if msg, ok := byKey[key]; ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
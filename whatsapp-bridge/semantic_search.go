@@ -0,0 +1,880 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// maxEmbeddingRunes bounds the text embedded per message, well inside the input limit of the models
+const maxEmbeddingRunes = 4000
+
+// vssCandidates is how many nearest neighbours sqlite-vss returns before the chat and time filters apply
+const vssCandidates = 1000
+
+// embeddingProvider turns texts into vectors, one per text and in the same order
+type embeddingProvider interface {
+	Embed(texts []string) ([][]float32, error)
+}
+
+// openAIEmbeddings calls the embeddings API of OpenAI or a compatible server
+type openAIEmbeddings struct {
+	url        string
+	apiKey     string
+	model      string
+	dimensions int
+	client     *http.Client
+}
+
+func (p *openAIEmbeddings) Embed(texts []string) ([][]float32, error) {
+	request := map[string]interface{}{"model": p.model, "input": texts}
+	if p.dimensions > 0 {
+		request["dimensions"] = p.dimensions
+	}
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := postProviderRequest(p.client, p.url+"/embeddings", p.apiKey, request, &result); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding response has an unexpected index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("embedding response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// ollamaEmbeddings calls the embed API of an Ollama server
+type ollamaEmbeddings struct {
+	url    string
+	model  string
+	client *http.Client
+}
+
+func (p *ollamaEmbeddings) Embed(texts []string) ([][]float32, error) {
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := postProviderRequest(p.client, p.url+"/api/embed", "", map[string]interface{}{"model": p.model, "input": texts}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding response has %d vectors for %d inputs", len(result.Embeddings), len(texts))
+	}
+	return result.Embeddings, nil
+}
+
+// postProviderRequest posts a JSON request to a provider and decodes its answer
+func postProviderRequest(client *http.Client, target, apiKey string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to read provider response: %v", err)
+	}
+	return nil
+}
+
+// SemanticFilter narrows a semantic search to a chat and a time range; zero values don't filter
+type SemanticFilter struct {
+	ChatJID string
+	Since   time.Time
+	Until   time.Time
+}
+
+// scoredKey is a message found by a semantic search with its cosine similarity to the query
+type scoredKey struct {
+	messageKey
+	score float64
+}
+
+// SemanticSearchResult is a message with how close it is in meaning to the query, from -1 to 1
+type SemanticSearchResult struct {
+	APIMessage
+	Score float64 `json:"score"`
+}
+
+// SemanticSearch embeds the text of stored messages and finds the messages closest in meaning to a query.
+// Vectors are kept with pgvector on PostgreSQL, and in embeddings.db on SQLite, indexed by sqlite-vss
+// when its extensions are available and compared one by one otherwise.
+type SemanticSearch struct {
+	provider embeddingProvider
+	// model identifies the vectors of the configured model, so vectors of another model are never compared
+	model        string
+	db           *sql.DB
+	isPostgres   bool
+	vss          bool
+	backfill     bool
+	batchSize    int
+	queueSize    int
+	messageStore *MessageStore
+	logger       waLog.Logger
+
+	mutex     sync.Mutex
+	vssTables map[int]bool
+}
+
+// semanticSearch is set at startup when EMBEDDINGS_PROVIDER is configured
+var semanticSearch *SemanticSearch
+
+// NewSemanticSearchFromEnv reads the EMBEDDINGS_* settings, and returns nil when EMBEDDINGS_PROVIDER is not set
+func NewSemanticSearchFromEnv(messageStore *MessageStore, logger waLog.Logger) (*SemanticSearch, error) {
+	client := &http.Client{Timeout: time.Duration(getEnvInt("EMBEDDINGS_TIMEOUT_SECONDS", 30)) * time.Second}
+	endpoint := strings.TrimRight(os.Getenv("EMBEDDINGS_URL"), "/")
+	model := os.Getenv("EMBEDDINGS_MODEL")
+	dimensions := getEnvInt("EMBEDDINGS_DIMENSIONS", 0)
+
+	var provider embeddingProvider
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("EMBEDDINGS_PROVIDER"))); kind {
+	case "":
+		return nil, nil
+	case "openai":
+		if endpoint == "" {
+			endpoint = "https://api.openai.com/v1"
+		}
+		if model == "" {
+			model = "text-embedding-3-small"
+		}
+		apiKey := os.Getenv("EMBEDDINGS_API_KEY")
+		if apiKey == "" && strings.HasPrefix(endpoint, "https://api.openai.com/") {
+			return nil, fmt.Errorf("EMBEDDINGS_PROVIDER=openai requires EMBEDDINGS_API_KEY")
+		}
+		provider = &openAIEmbeddings{url: endpoint, apiKey: apiKey, model: model, dimensions: dimensions, client: client}
+	case "ollama":
+		if endpoint == "" {
+			endpoint = "http://localhost:11434"
+		}
+		if model == "" {
+			model = "nomic-embed-text"
+		}
+		provider = &ollamaEmbeddings{url: endpoint, model: model, client: client}
+	default:
+		return nil, fmt.Errorf("invalid EMBEDDINGS_PROVIDER: %s (expected openai or ollama)", kind)
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("EMBEDDINGS_URL must be an http or https URL")
+	}
+	if dimensions < 0 {
+		return nil, fmt.Errorf("EMBEDDINGS_DIMENSIONS must not be negative")
+	}
+
+	search := &SemanticSearch{
+		provider:     provider,
+		model:        model,
+		backfill:     getEnvBool("EMBEDDINGS_BACKFILL", true),
+		batchSize:    getEnvInt("EMBEDDINGS_BATCH_SIZE", 64),
+		queueSize:    getEnvInt("EMBEDDINGS_QUEUE_SIZE", 10000),
+		messageStore: messageStore,
+		logger:       logger,
+		vssTables:    map[int]bool{},
+	}
+	if dimensions > 0 {
+		search.model += "@" + strconv.Itoa(dimensions)
+	}
+	if search.batchSize < 1 {
+		return nil, fmt.Errorf("EMBEDDINGS_BATCH_SIZE must be positive")
+	}
+	if err := search.openStore(); err != nil {
+		return nil, err
+	}
+	return search, nil
+}
+
+// openStore creates the vector table, in the PostgreSQL database of the messages or in embeddings.db
+func (s *SemanticSearch) openStore() error {
+	if s.messageStore.isPostgres {
+		s.db = s.messageStore.db
+		s.isPostgres = true
+		if _, err := s.db.Exec("CREATE EXTENSION IF NOT EXISTS vector"); err != nil {
+			return fmt.Errorf("failed to enable pgvector, install it or create the vector extension as a superuser: %v", err)
+		}
+		// The column has no fixed dimension, so the model can change without a migration
+		if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS message_embeddings (
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			model TEXT NOT NULL,
+			timestamp TIMESTAMP,
+			embedding vector NOT NULL,
+			PRIMARY KEY (chat_jid, message_id)
+		)`); err != nil {
+			return fmt.Errorf("failed to create message_embeddings table: %v", err)
+		}
+		if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_message_embeddings_model ON message_embeddings (model, timestamp)"); err != nil {
+			return fmt.Errorf("failed to create index: %v", err)
+		}
+		return nil
+	}
+
+	// Vectors live apart from messages.db, so the extensions are only loaded where they are needed
+	// and deleting embeddings.db drops them all
+	driver := sqlDriverName("sqlite3")
+	if dir := os.Getenv("SQLITE_VSS_PATH"); dir != "" {
+		driver = "sqlite3_vss"
+		sql.Register(driver, &sqlite3.SQLiteDriver{Extensions: []string{filepath.Join(dir, "vector0"), filepath.Join(dir, "vss0")}})
+		s.vss = true
+	}
+	if err := ensureDataDir(); err != nil {
+		return err
+	}
+	db, err := sql.Open(driver, sqliteDSN("embeddings.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open embedding database: %v", err)
+	}
+	// Keeps SQLite from failing with "database is locked" while the backfill and live changes write
+	db.SetMaxOpenConns(1)
+	s.db = db
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS message_embeddings (
+		seq INTEGER PRIMARY KEY,
+		chat_jid TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		model TEXT NOT NULL,
+		timestamp TIMESTAMP,
+		embedding BLOB NOT NULL,
+		UNIQUE (chat_jid, message_id)
+	)`); err != nil {
+		db.Close()
+		if s.vss {
+			return fmt.Errorf("failed to create message_embeddings table, check SQLITE_VSS_PATH holds the vector0 and vss0 extensions: %v", err)
+		}
+		return fmt.Errorf("failed to create message_embeddings table: %v", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_message_embeddings_model ON message_embeddings (model, timestamp)"); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create index: %v", err)
+	}
+
+	if s.vss {
+		// One vss0 table per dimension, as each holds vectors of a fixed size
+		rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'message_embeddings_vss_%'")
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("failed to list vector indexes: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				db.Close()
+				return err
+			}
+			if dimensions, err := strconv.Atoi(strings.TrimPrefix(name, "message_embeddings_vss_")); err == nil {
+				s.vssTables[dimensions] = true
+			}
+		}
+		return rows.Err()
+	}
+	return nil
+}
+
+// Consumer receives message changes for the change capture, unredacted so their meaning is kept
+func (s *SemanticSearch) Consumer() *changeConsumer {
+	return &changeConsumer{
+		name:      "Embeddings",
+		sink:      s,
+		tables:    map[string]bool{cdcTableMessages: true},
+		batchSize: s.batchSize,
+		queue:     make(chan ChangeEvent, s.queueSize),
+		logger:    s.logger,
+	}
+}
+
+// Start embeds the stored messages that have no vector yet in the background, with EMBEDDINGS_BACKFILL
+func (s *SemanticSearch) Start() {
+	if s.backfill {
+		go s.embedStored()
+	}
+}
+
+// embeddingText is the text of a message that is embedded, or "" when it has none worth searching
+func embeddingText(msg *ExportedMessage) string {
+	if msg.SystemEvent != "" {
+		return ""
+	}
+	text := strings.TrimSpace(msg.Content)
+	if runes := []rune(text); len(runes) > maxEmbeddingRunes {
+		text = string(runes[:maxEmbeddingRunes])
+	}
+	return text
+}
+
+// embedStored walks the stored messages in the batches of the full export and embeds those without
+// a vector of the current model. A message deleted meanwhile may keep a vector; searches skip it.
+func (s *SemanticSearch) embedStored() {
+	var cursor *exportCursor
+	embedded := 0
+	for {
+		batch, err := s.messageStore.ExportMessagesBatch(cursor, time.Time{}, time.Time{}, exportBatchSize)
+		if err != nil {
+			s.logger.Errorf("Embedding backfill stopped after %d messages: %v", embedded, err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		pending, err := s.withoutVector(batch)
+		if err != nil {
+			s.logger.Errorf("Embedding backfill stopped after %d messages: %v", embedded, err)
+			return
+		}
+		for start := 0; start < len(pending); start += s.batchSize {
+			chunk := pending[start:min(start+s.batchSize, len(pending))]
+			for attempt := 0; ; attempt++ {
+				if err = s.embed(chunk); err == nil {
+					break
+				}
+				if attempt == cdcAttempts-1 {
+					s.logger.Errorf("Embedding backfill stopped after %d messages: %v", embedded, err)
+					return
+				}
+				time.Sleep(time.Duration(1<<(attempt+1)) * time.Second)
+			}
+			embedded += len(chunk)
+		}
+
+		last := batch[len(batch)-1]
+		cursor = &exportCursor{timestamp: last.Timestamp, chatJID: last.ChatJID, id: last.ID}
+	}
+	if embedded > 0 {
+		s.logger.Infof("Embedded %d stored messages for semantic search", embedded)
+	}
+}
+
+// withoutVector returns the messages with text that have no vector of the current model
+func (s *SemanticSearch) withoutVector(messages []ExportedMessage) ([]*ExportedMessage, error) {
+	var candidates []*ExportedMessage
+	var conditions []string
+	args := []interface{}{s.model}
+	for i := range messages {
+		if embeddingText(&messages[i]) == "" {
+			continue
+		}
+		candidates = append(candidates, &messages[i])
+		if s.isPostgres {
+			conditions = append(conditions, fmt.Sprintf("(chat_jid = $%d AND message_id = $%d)", len(args)+1, len(args)+2))
+		} else {
+			conditions = append(conditions, "(chat_jid = ? AND message_id = ?)")
+		}
+		args = append(args, messages[i].ChatJID, messages[i].ID)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	placeholder := "?"
+	if s.isPostgres {
+		placeholder = "$1"
+	}
+	rows, err := s.db.Query("SELECT chat_jid, message_id FROM message_embeddings WHERE model = "+placeholder+" AND ("+strings.Join(conditions, " OR ")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[messageKey]bool{}
+	for rows.Next() {
+		var key messageKey
+		if err := rows.Scan(&key.ChatJID, &key.ID); err != nil {
+			return nil, err
+		}
+		done[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []*ExportedMessage
+	for _, msg := range candidates {
+		if !done[messageKey{ChatJID: msg.ChatJID, ID: msg.ID}] {
+			pending = append(pending, msg)
+		}
+	}
+	return pending, nil
+}
+
+// Send updates the vectors of a batch of message changes. Only the net change of each message counts,
+// and messages whose text and time stayed the same aren't embedded again.
+func (s *SemanticSearch) Send(events []ChangeEvent) error {
+	type netChange struct {
+		before rowImage
+		after  rowImage
+	}
+	changes := map[messageKey]*netChange{}
+	var order []messageKey
+	for _, evt := range events {
+		key := messageKey{ChatJID: evt.Key["chat_jid"], ID: evt.Key["id"]}
+		change, seen := changes[key]
+		if !seen {
+			change = &netChange{before: evt.Before}
+			changes[key] = change
+			order = append(order, key)
+		}
+		change.after = evt.After
+	}
+
+	var removed []messageKey
+	var pending []*ExportedMessage
+	for _, key := range order {
+		change := changes[key]
+		after, _ := change.after.(*ExportedMessage)
+		if after == nil || embeddingText(after) == "" {
+			removed = append(removed, key)
+			continue
+		}
+		if before, _ := change.before.(*ExportedMessage); before != nil &&
+			embeddingText(before) == embeddingText(after) && before.Timestamp.Equal(after.Timestamp) {
+			continue
+		}
+		pending = append(pending, after)
+	}
+
+	if err := s.embed(pending); err != nil {
+		return err
+	}
+	return s.remove(removed)
+}
+
+// embed computes and stores the vectors of messages
+func (s *SemanticSearch) embed(messages []*ExportedMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	texts := make([]string, len(messages))
+	for i, msg := range messages {
+		texts[i] = embeddingText(msg)
+	}
+	vectors, err := s.provider.Embed(texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed messages: %v", err)
+	}
+	return s.store(messages, vectors)
+}
+
+// store writes vectors, replacing those the messages had before
+func (s *SemanticSearch) store(messages []*ExportedMessage, vectors [][]float32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seqs := make([]int64, len(messages))
+	for i, msg := range messages {
+		if s.isPostgres {
+			_, err = tx.Exec(`INSERT INTO message_embeddings (chat_jid, message_id, model, timestamp, embedding) VALUES ($1, $2, $3, $4, $5::vector)
+				ON CONFLICT (chat_jid, message_id) DO UPDATE SET model = EXCLUDED.model, timestamp = EXCLUDED.timestamp, embedding = EXCLUDED.embedding`,
+				msg.ChatJID, msg.ID, s.model, msg.Timestamp.UTC(), vectorLiteral(vectors[i]))
+		} else {
+			// An upsert keeps seq, the rowid the vss0 index refers to
+			err = tx.QueryRow(`INSERT INTO message_embeddings (chat_jid, message_id, model, timestamp, embedding) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (chat_jid, message_id) DO UPDATE SET model = excluded.model, timestamp = excluded.timestamp, embedding = excluded.embedding
+				RETURNING seq`,
+				msg.ChatJID, msg.ID, s.model, msg.Timestamp.UTC(), encodeVector(vectors[i])).Scan(&seqs[i])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store embedding: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if !s.vss {
+		return nil
+	}
+
+	// vss0 tables can't update rows, and apply their writes on commit, so replace in two steps
+	if err := s.removeFromVSS(seqs); err != nil {
+		return err
+	}
+	// The tables are created first, as the transaction holds the only connection
+	tables := make([]string, len(vectors))
+	for i, vector := range vectors {
+		if tables[i], err = s.vssTable(len(vector)); err != nil {
+			return err
+		}
+	}
+	tx, err = s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, seq := range seqs {
+		if _, err := tx.Exec("INSERT INTO "+tables[i]+" (rowid, embedding) VALUES (?, json(?))", seq, vectorLiteral(vectors[i])); err != nil {
+			return fmt.Errorf("failed to index embedding: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// remove deletes the vectors of messages
+func (s *SemanticSearch) remove(keys []messageKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	var seqs []int64
+	if s.vss {
+		for _, key := range keys {
+			var seq int64
+			err := s.db.QueryRow("SELECT seq FROM message_embeddings WHERE chat_jid = ? AND message_id = ?", key.ChatJID, key.ID).Scan(&seq)
+			if err == nil {
+				seqs = append(seqs, seq)
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if err := s.removeFromVSS(seqs); err != nil {
+			return err
+		}
+	}
+
+	query := "DELETE FROM message_embeddings WHERE chat_jid = ? AND message_id = ?"
+	if s.isPostgres {
+		query = "DELETE FROM message_embeddings WHERE chat_jid = $1 AND message_id = $2"
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, key := range keys {
+		if _, err := tx.Exec(query, key.ChatJID, key.ID); err != nil {
+			return fmt.Errorf("failed to delete embedding: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// removeFromVSS drops rows from every vss0 table, as the dimension of their old vector isn't known
+func (s *SemanticSearch) removeFromVSS(seqs []int64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	var tables []string
+	for dimensions := range s.vssTables {
+		tables = append(tables, vssTableName(dimensions))
+	}
+	s.mutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range tables {
+		for _, seq := range seqs {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE rowid = ?", seq); err != nil {
+				return fmt.Errorf("failed to remove embedding from index: %v", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// vssTableName is the vss0 table holding vectors of a dimension
+func vssTableName(dimensions int) string {
+	return "message_embeddings_vss_" + strconv.Itoa(dimensions)
+}
+
+// vssTable returns the vss0 table for vectors of a dimension, creating it on first use
+func (s *SemanticSearch) vssTable(dimensions int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	table := vssTableName(dimensions)
+	if s.vssTables[dimensions] {
+		return table, nil
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING vss0(embedding(%d))", table, dimensions)); err != nil {
+		return "", fmt.Errorf("failed to create vector index: %v", err)
+	}
+	s.vssTables[dimensions] = true
+	return table, nil
+}
+
+// Search returns up to limit messages closest in meaning to the query, best match first
+func (s *SemanticSearch) Search(query string, filter SemanticFilter, limit int) ([]SemanticSearchResult, error) {
+	vectors, err := s.provider.Embed([]string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed the query: %v", err)
+	}
+	vector := vectors[0]
+
+	var keys []scoredKey
+	switch {
+	case s.isPostgres:
+		keys, err = s.searchPostgres(vector, filter, limit)
+	case s.vss:
+		keys, err = s.searchVSS(vector, filter, limit)
+	default:
+		keys, err = s.searchExact(vector, filter, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messageKeys := make([]messageKey, len(keys))
+	scores := make(map[messageKey]float64, len(keys))
+	for i, key := range keys {
+		messageKeys[i] = key.messageKey
+		scores[key.messageKey] = key.score
+	}
+	messages, err := s.messageStore.GetMessagesByKey(messageKeys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SemanticSearchResult, len(messages))
+	for i, msg := range messages {
+		results[i] = SemanticSearchResult{APIMessage: msg, Score: scores[messageKey{ChatJID: msg.ChatJID, ID: msg.ID}]}
+	}
+	return results, nil
+}
+
+// filterConditions turns a filter into SQL conditions on message_embeddings, after the model
+func (s *SemanticSearch) filterConditions(filter SemanticFilter, column string, args *[]interface{}) string {
+	arg := func(value interface{}) string {
+		*args = append(*args, value)
+		if s.isPostgres {
+			return fmt.Sprintf("$%d", len(*args))
+		}
+		return "?"
+	}
+	conditions := []string{column + "model = " + arg(s.model)}
+	if filter.ChatJID != "" {
+		conditions = append(conditions, column+"chat_jid = "+arg(filter.ChatJID))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, column+"timestamp >= "+arg(filter.Since.UTC()))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, column+"timestamp < "+arg(filter.Until.UTC()))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// searchPostgres lets pgvector order the vectors by cosine distance
+func (s *SemanticSearch) searchPostgres(vector []float32, filter SemanticFilter, limit int) ([]scoredKey, error) {
+	args := []interface{}{vectorLiteral(vector)}
+	where := s.filterConditions(filter, "", &args)
+	args = append(args, limit)
+	rows, err := s.db.Query(fmt.Sprintf("SELECT chat_jid, message_id, 1 - (embedding <=> $1::vector) FROM message_embeddings WHERE %s ORDER BY embedding <=> $1::vector LIMIT $%d",
+		where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []scoredKey
+	for rows.Next() {
+		var key scoredKey
+		if err := rows.Scan(&key.ChatJID, &key.ID, &key.score); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// searchVSS takes the nearest neighbours from the vss0 index and applies the filter to them,
+// so a narrow filter may leave fewer than limit matches
+func (s *SemanticSearch) searchVSS(vector []float32, filter SemanticFilter, limit int) ([]scoredKey, error) {
+	s.mutex.Lock()
+	indexed := s.vssTables[len(vector)]
+	s.mutex.Unlock()
+	if !indexed {
+		return nil, nil
+	}
+
+	args := []interface{}{vectorLiteral(vector), vssCandidates}
+	where := s.filterConditions(filter, "e.", &args)
+	return s.scoreRows(vector, limit, fmt.Sprintf(`SELECT e.chat_jid, e.message_id, e.embedding FROM
+		(SELECT rowid FROM %s WHERE vss_search(embedding, vss_search_params(json(?), ?))) v
+		JOIN message_embeddings e ON e.seq = v.rowid WHERE %s`, vssTableName(len(vector)), where), args...)
+}
+
+// searchExact compares the query with every vector that matches the filter
+func (s *SemanticSearch) searchExact(vector []float32, filter SemanticFilter, limit int) ([]scoredKey, error) {
+	var args []interface{}
+	where := s.filterConditions(filter, "", &args)
+	return s.scoreRows(vector, limit, "SELECT chat_jid, message_id, embedding FROM message_embeddings WHERE "+where, args...)
+}
+
+// scoreRows reads chat_jid, message_id and embedding rows and keeps the limit closest to the vector
+func (s *SemanticSearch) scoreRows(vector []float32, limit int, query string, args ...interface{}) ([]scoredKey, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []scoredKey
+	for rows.Next() {
+		var key scoredKey
+		var encoded []byte
+		if err := rows.Scan(&key.ChatJID, &key.ID, &encoded); err != nil {
+			return nil, err
+		}
+		key.score = cosineSimilarity(vector, decodeVector(encoded))
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].score > keys[j].score })
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// vectorLiteral formats a vector as pgvector and sqlite-vss read it, like a JSON array
+func vectorLiteral(vector []float32) string {
+	parts := make([]string, len(vector))
+	for i, value := range vector {
+		parts[i] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// encodeVector stores a vector as little-endian float32 values
+func encodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+func decodeVector(encoded []byte) []float32 {
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector
+}
+
+// cosineSimilarity is 1 for vectors pointing the same way, 0 for unrelated ones and -1 for opposite ones
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return -1
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// registerSemanticSearchRoutes registers /api/v1/search/semantic?q=...&chat_jid=...&since=...&until=...&limit=...
+func registerSemanticSearchRoutes() {
+	handleAPI("/search/semantic", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if semanticSearch == nil {
+			http.Error(w, "Semantic search is not enabled; set EMBEDDINGS_PROVIDER", http.StatusNotFound)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if len([]rune(query)) < 2 {
+			http.Error(w, "q must be at least 2 characters", http.StatusBadRequest)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 20
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > 100 {
+				http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+		}
+		filter := SemanticFilter{ChatJID: r.URL.Query().Get("chat_jid")}
+		if value := r.URL.Query().Get("since"); value != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid since parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("until"); value != "" {
+			if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "Invalid until parameter, expected RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+
+		results, err := semanticSearch.Search(query, filter, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to search messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// The middleware only sees chat_jid, so log the held chats a wider search reached
+		seen := map[string]bool{}
+		for i := range results {
+			results[i].Timestamp = results[i].Timestamp.In(loc)
+			if filter.ChatJID == "" && !seen[results[i].ChatJID] {
+				seen[results[i].ChatJID] = true
+				legalHolds.RecordRequest(r, results[i].ChatJID)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+}
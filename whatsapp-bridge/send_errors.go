@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Send failure codes returned by the send API and in message.failed events. They are stable,
+// so clients can branch on them instead of parsing messages.
+const (
+	SendErrRecipientNotOnWhatsApp = "recipient_not_on_whatsapp"
+	SendErrRateLimited            = "rate_limited"
+	SendErrMediaTooLarge          = "media_too_large"
+	SendErrNotConnected           = "not_connected"
+	SendErrServerError            = "server_error"
+	SendErrInvalidRequest         = "invalid_request"
+	SendErrMediaBlocked           = "media_blocked"
+	SendErrSendingDisabled        = "sending_disabled"
+	SendErrNotGroupMember         = "not_group_member"
+	SendErrGroupAdminsOnly        = "group_admins_only"
+)
+
+// maxSendMediaBytes is the largest file WhatsApp accepts
+const maxSendMediaBytes = 2 << 30
+
+// sendErrorRetryable tells whether sending the same message again later can succeed
+var sendErrorRetryable = map[string]bool{
+	SendErrRecipientNotOnWhatsApp: false,
+	SendErrRateLimited:            true,
+	SendErrMediaTooLarge:          false,
+	SendErrNotConnected:           true,
+	SendErrServerError:            true,
+	SendErrInvalidRequest:         false,
+	SendErrMediaBlocked:           false,
+	SendErrSendingDisabled:        false,
+	SendErrNotGroupMember:         false,
+	SendErrGroupAdminsOnly:        false,
+}
+
+// classifySendError maps an error from whatsmeow's SendMessage to a send failure code
+func classifySendError(err error) string {
+	var disconnected *whatsmeow.DisconnectedError
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotConnected), errors.Is(err, whatsmeow.ErrNotLoggedIn), errors.As(err, &disconnected):
+		return SendErrNotConnected
+	case errors.Is(err, whatsmeow.ErrIQRateOverLimit), errors.Is(err, whatsmeow.ErrIQResourceLimit):
+		return SendErrRateLimited
+	case errors.Is(err, whatsmeow.ErrUnknownServer), errors.Is(err, whatsmeow.ErrRecipientADJID), errors.Is(err, whatsmeow.ErrBroadcastListUnsupported):
+		return SendErrInvalidRequest
+	case errors.Is(err, whatsmeow.ErrServerReturnedError):
+		// The server's error code follows the wrapped error
+		if strings.HasSuffix(err.Error(), " 429") {
+			return SendErrRateLimited
+		}
+	}
+	return SendErrServerError
+}
+
+// classifyUploadError maps an error from a media upload to a send failure code
+func classifyUploadError(err error) string {
+	if strings.Contains(err.Error(), "status code 413") {
+		return SendErrMediaTooLarge
+	}
+	return classifySendError(err)
+}
+
+// checkRecipientOnWhatsApp looks up a phone number the bridge has never chatted with, so messages
+// to numbers without WhatsApp fail instead of being silently dropped. Lookup errors let the send go ahead.
+func checkRecipientOnWhatsApp(client *whatsmeow.Client, messageStore *MessageStore, recipient types.JID) bool {
+	if recipient.Server != types.DefaultUserServer || messageStore == nil || messageStore.HasChat(recipient.String()) {
+		return true
+	}
+	results, err := client.IsOnWhatsApp([]string{"+" + recipient.User})
+	if err != nil || len(results) == 0 {
+		return true
+	}
+	return results[0].IsIn
+}
+
+// HasChat reports whether a chat is stored
+func (store *MessageStore) HasChat(jid string) bool {
+	query := "SELECT 1 FROM chats WHERE jid = ?"
+	if store.isPostgres {
+		query = "SELECT 1 FROM chats WHERE jid = $1"
+	}
+	var found int
+	return store.db.QueryRow(query, jid).Scan(&found) == nil
+}
+
+// withError fills in the error code and retryability of a failed send
+func (resp SendMessageResponse) withError(code string) SendMessageResponse {
+	if code != "" {
+		retryable := sendErrorRetryable[code]
+		resp.ErrorCode, resp.Retryable = code, &retryable
+	}
+	return resp
+}
+
+// mediaTooLargeMessage explains a rejected attachment
+func mediaTooLargeMessage(size, limit int64) string {
+	return fmt.Sprintf("Media file is %d MB, more than the %d MB WhatsApp accepts", size>>20, limit>>20)
+}
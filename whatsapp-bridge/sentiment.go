@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Scores at or beyond these bounds count as positive or negative messages in reports
+const (
+	positiveSentiment = 0.25
+	negativeSentiment = -0.25
+)
+
+// recentSentimentMessages is how many of a chat's latest scored messages make up its recent average
+const recentSentimentMessages = 5
+
+// sentimentScorer rates texts from -1 (very negative) to 1 (very positive)
+type sentimentScorer interface {
+	Score(texts []string) ([]float64, error)
+}
+
+// lexiconSentiment scores text with a word list, in the manner of VADER: weights from -4 to 4,
+// flipped by a preceding negation, raised by intensifiers and exclamation marks, and normalized
+type lexiconSentiment struct {
+	words map[string]float64
+}
+
+// sentimentLexicon is the built-in English word list
+var sentimentLexicon = map[string]float64{
+	"good": 1.9, "great": 3.1, "excellent": 3.2, "amazing": 2.8, "awesome": 3.1, "perfect": 2.7,
+	"fantastic": 2.6, "wonderful": 2.7, "brilliant": 2.8, "love": 3.2, "loved": 2.9, "like": 1.5,
+	"nice": 1.8, "happy": 2.7, "glad": 2.0, "pleased": 1.9, "satisfied": 1.8, "thanks": 1.9,
+	"thank": 1.5, "thx": 1.5, "appreciate": 1.7, "appreciated": 2.3, "helpful": 1.8, "quick": 1.0,
+	"fast": 1.0, "easy": 1.9, "works": 1.0, "working": 0.8, "fixed": 1.2, "solved": 1.6,
+	"resolved": 1.6, "recommend": 1.5, "best": 3.2, "cool": 1.3, "fine": 0.8, "ok": 0.9,
+	"okay": 0.9, "yes": 0.8, "welcome": 2.0, "friendly": 2.2, "kind": 2.4, "lovely": 2.8,
+	"super": 2.9, "superb": 3.1, "delighted": 2.9, "impressed": 2.3, "smooth": 1.2, "reliable": 1.6,
+
+	"bad": -2.5, "terrible": -2.9, "awful": -2.7, "horrible": -2.5, "worst": -3.1, "poor": -2.1,
+	"hate": -2.7, "hated": -3.2, "angry": -2.3, "annoyed": -1.6, "annoying": -2.2, "upset": -1.6,
+	"frustrated": -2.4, "frustrating": -1.9, "disappointed": -2.3, "disappointing": -2.2, "sad": -2.1,
+	"unhappy": -1.8, "useless": -1.8, "broken": -1.6, "wrong": -2.1, "problem": -1.7, "problems": -1.7,
+	"issue": -1.0, "issues": -1.0, "error": -1.4, "fail": -2.5, "failed": -2.3, "failing": -2.3,
+	"slow": -1.0, "late": -0.8, "delay": -1.3, "delayed": -0.9, "never": -0.5, "refund": -1.0,
+	"cancel": -1.0, "complaint": -1.5, "complain": -1.5, "rude": -2.0, "ridiculous": -2.1,
+	"unacceptable": -2.0, "scam": -2.6, "ripoff": -2.4, "waste": -1.8, "wasted": -2.2, "worse": -2.1,
+	"stupid": -2.4, "sucks": -1.5, "crap": -1.6, "ugh": -1.8, "damn": -1.7, "wtf": -2.8,
+	"still": -0.3, "again": -0.3, "nobody": -0.8, "ignored": -1.3, "missing": -1.2, "lost": -1.3,
+	"confused": -1.3, "confusing": -1.4, "worried": -1.2, "scared": -1.9, "sorry": -0.3, "no": -1.2,
+
+	"😀": 2.3, "😃": 2.3, "😄": 2.3, "😁": 2.0, "😊": 2.2, "🙂": 1.2, "😍": 2.7, "🥰": 2.7,
+	"❤": 2.5, "👍": 1.9, "🙏": 1.5, "🎉": 2.3, "👏": 2.0, "😂": 1.2, "✅": 1.0,
+	"😡": -2.8, "😠": -2.4, "🤬": -3.0, "😞": -2.0, "😢": -1.9, "😭": -1.7, "🙁": -1.5, "☹": -1.8,
+	"😤": -1.8, "👎": -1.9, "💩": -1.7, "😒": -1.5, "😩": -1.9, "😫": -1.9,
+}
+
+// sentimentNegations flip the weight of the next few words
+var sentimentNegations = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "nobody": true, "neither": true, "nor": true,
+	"cannot": true, "without": true, "hardly": true, "dont": true, "doesnt": true, "didnt": true,
+	"isnt": true, "wasnt": true, "arent": true, "werent": true, "cant": true, "couldnt": true,
+	"wont": true, "wouldnt": true, "shouldnt": true, "havent": true, "hasnt": true, "aint": true,
+}
+
+// sentimentIntensifiers scale the weight of the next word
+var sentimentIntensifiers = map[string]float64{
+	"very": 1.3, "really": 1.3, "so": 1.3, "extremely": 1.5, "totally": 1.3, "absolutely": 1.5,
+	"completely": 1.4, "too": 1.2, "incredibly": 1.5, "quite": 1.1,
+	"slightly": 0.7, "somewhat": 0.8, "barely": 0.6,
+}
+
+// newLexiconSentiment starts from the built-in word list, overridden by the optional JSON file
+// of word weights at path
+func newLexiconSentiment(path string) (*lexiconSentiment, error) {
+	words := make(map[string]float64, len(sentimentLexicon))
+	for word, weight := range sentimentLexicon {
+		words[word] = weight
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SENTIMENT_LEXICON_FILE: %v", err)
+		}
+		var extra map[string]float64
+		if err := json.Unmarshal(data, &extra); err != nil {
+			return nil, fmt.Errorf("SENTIMENT_LEXICON_FILE must be a JSON object of word weights: %v", err)
+		}
+		for word, weight := range extra {
+			words[strings.ToLower(word)] = weight
+		}
+	}
+	return &lexiconSentiment{words: words}, nil
+}
+
+func (l *lexiconSentiment) Score(texts []string) ([]float64, error) {
+	scores := make([]float64, len(texts))
+	for i, text := range texts {
+		scores[i] = l.score(text)
+	}
+	return scores, nil
+}
+
+func (l *lexiconSentiment) score(text string) float64 {
+	var total float64
+	negated := 0
+	boost := 1.0
+	for _, token := range sentimentTokens(text) {
+		// "don't" and "dont" are the same negation
+		word := strings.ReplaceAll(token, "'", "")
+		if weight, ok := l.words[word]; ok && !sentimentNegations[word] {
+			weight *= boost
+			if negated > 0 {
+				weight *= -0.75
+			}
+			total += weight
+		}
+		switch {
+		case sentimentNegations[word] || strings.HasSuffix(token, "n't"):
+			negated = 3
+			boost = 1
+		case sentimentIntensifiers[word] > 0:
+			boost = sentimentIntensifiers[word]
+		default:
+			boost = 1
+			if negated > 0 {
+				negated--
+			}
+		}
+	}
+
+	if exclamations := strings.Count(text, "!"); exclamations > 0 && total != 0 {
+		total *= 1 + 0.1*math.Min(float64(exclamations), 4)
+	}
+	return total / math.Sqrt(total*total+15)
+}
+
+// sentimentTokens splits text into lowercase words and single emoji
+func sentimentTokens(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || ((r == '\'' || r == '’') && word.Len() > 0):
+			if r == '’' {
+				r = '\''
+			}
+			word.WriteRune(r)
+		case unicode.Is(unicode.So, r):
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// httpSentiment asks a service to score texts. It posts {"texts": [...]} and expects
+// {"scores": [...]} back, one score from -1 to 1 per text.
+type httpSentiment struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (p *httpSentiment) Score(texts []string) ([]float64, error) {
+	var result struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := postProviderRequest(p.client, p.url, p.apiKey, map[string]interface{}{"texts": texts}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Scores) != len(texts) {
+		return nil, fmt.Errorf("sentiment service returned %d scores for %d texts", len(result.Scores), len(texts))
+	}
+	for i, score := range result.Scores {
+		result.Scores[i] = math.Max(-1, math.Min(1, score))
+	}
+	return result.Scores, nil
+}
+
+// Sentiment scores incoming messages as they are stored, for the sentiment reports
+type Sentiment struct {
+	scorer       sentimentScorer
+	batchSize    int
+	queueSize    int
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// NewSentimentFromEnv returns nil when SENTIMENT_PROVIDER is unset
+func NewSentimentFromEnv(messageStore *MessageStore, logger waLog.Logger) (*Sentiment, error) {
+	var scorer sentimentScorer
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("SENTIMENT_PROVIDER"))); kind {
+	case "":
+		return nil, nil
+	case "lexicon":
+		lexicon, err := newLexiconSentiment(os.Getenv("SENTIMENT_LEXICON_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		scorer = lexicon
+	case "http":
+		endpoint := os.Getenv("SENTIMENT_URL")
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return nil, fmt.Errorf("SENTIMENT_PROVIDER=http requires an http or https SENTIMENT_URL")
+		}
+		scorer = &httpSentiment{
+			url:    endpoint,
+			apiKey: os.Getenv("SENTIMENT_API_KEY"),
+			client: &http.Client{Timeout: time.Duration(getEnvInt("SENTIMENT_TIMEOUT_SECONDS", 30)) * time.Second},
+		}
+	default:
+		return nil, fmt.Errorf("invalid SENTIMENT_PROVIDER: %s (expected lexicon or http)", kind)
+	}
+
+	s := &Sentiment{
+		scorer:       scorer,
+		batchSize:    getEnvInt("SENTIMENT_BATCH_SIZE", 32),
+		queueSize:    getEnvInt("SENTIMENT_QUEUE_SIZE", 10000),
+		messageStore: messageStore,
+		logger:       logger,
+	}
+	if s.batchSize < 1 {
+		return nil, fmt.Errorf("SENTIMENT_BATCH_SIZE must be positive")
+	}
+	return s, nil
+}
+
+// Consumer receives message changes from change capture, so scoring never holds up storing
+func (s *Sentiment) Consumer() *changeConsumer {
+	return &changeConsumer{
+		name:      "Sentiment",
+		sink:      s,
+		tables:    map[string]bool{cdcTableMessages: true},
+		batchSize: s.batchSize,
+		queue:     make(chan ChangeEvent, s.queueSize),
+		logger:    s.logger,
+	}
+}
+
+// sentimentText is the text scored for a message, empty for sent messages and system events
+func sentimentText(msg *ExportedMessage) string {
+	if msg.IsFromMe || msg.SystemEvent != "" {
+		return ""
+	}
+	return strings.TrimSpace(msg.Content)
+}
+
+// Send scores new and edited incoming messages. An edit that empties a message clears its score.
+func (s *Sentiment) Send(events []ChangeEvent) error {
+	var pending []*ExportedMessage
+	var cleared []*ExportedMessage
+	for _, evt := range events {
+		after, _ := evt.After.(*ExportedMessage)
+		if after == nil {
+			continue
+		}
+		before, _ := evt.Before.(*ExportedMessage)
+		if before != nil && sentimentText(before) == sentimentText(after) {
+			continue
+		}
+		if sentimentText(after) == "" {
+			if before != nil {
+				cleared = append(cleared, after)
+			}
+			continue
+		}
+		pending = append(pending, after)
+	}
+
+	var scores []float64
+	if len(pending) > 0 {
+		texts := make([]string, len(pending))
+		for i, msg := range pending {
+			texts[i] = sentimentText(msg)
+		}
+		var err error
+		if scores, err = s.scorer.Score(texts); err != nil {
+			return fmt.Errorf("failed to score messages: %v", err)
+		}
+	}
+
+	query := "UPDATE messages SET sentiment = ? WHERE chat_jid = ? AND id = ?"
+	if s.messageStore.isPostgres {
+		query = "UPDATE messages SET sentiment = $1 WHERE chat_jid = $2 AND id = $3"
+	}
+	for i, msg := range pending {
+		if _, err := s.messageStore.db.Exec(query, scores[i], msg.ChatJID, msg.ID); err != nil {
+			return fmt.Errorf("failed to store sentiment: %v", err)
+		}
+	}
+	for _, msg := range cleared {
+		if _, err := s.messageStore.db.Exec(query, nil, msg.ChatJID, msg.ID); err != nil {
+			return fmt.Errorf("failed to clear sentiment: %v", err)
+		}
+	}
+	return nil
+}
+
+// DailySentiment summarizes the scored incoming messages of one day
+type DailySentiment struct {
+	Date     string   `json:"date"`
+	Scored   int      `json:"scored"`
+	Average  *float64 `json:"average"`
+	Positive int      `json:"positive"`
+	Neutral  int      `json:"neutral"`
+	Negative int      `json:"negative"`
+}
+
+// ChatSentiment summarizes the scored incoming messages of one chat over a period
+type ChatSentiment struct {
+	ChatJID       string    `json:"chat_jid"`
+	Name          string    `json:"name,omitempty"`
+	Scored        int       `json:"scored"`
+	Average       float64   `json:"average"`
+	RecentAverage float64   `json:"recent_average"`
+	Trend         float64   `json:"trend"`
+	Negative      int       `json:"negative"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// scoredMessages reads the scored incoming messages of a report, oldest first per chat
+func (store *MessageStore) scoredMessages(f AnalyticsFilter, read func(chatJID string, at time.Time, score float64)) error {
+	where, args := store.analyticsConditions(f)
+	rows, err := store.db.Query("SELECT chat_jid, timestamp, sentiment FROM messages WHERE "+where+
+		" AND NOT is_from_me AND sentiment IS NOT NULL ORDER BY chat_jid, timestamp", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chatJID string
+		var at time.Time
+		var score float64
+		if err := rows.Scan(&chatJID, &at, &score); err != nil {
+			return err
+		}
+		read(chatJID, at, score)
+	}
+	return rows.Err()
+}
+
+// DailySentimentScores averages the sentiment of incoming messages per day
+func (store *MessageStore) DailySentimentScores(f AnalyticsFilter) ([]DailySentiment, error) {
+	result := []DailySentiment{}
+	days := map[string]*DailySentiment{}
+	for _, date := range f.days() {
+		result = append(result, DailySentiment{Date: date})
+	}
+	for i := range result {
+		days[result[i].Date] = &result[i]
+	}
+	totals := map[string]float64{}
+
+	err := store.scoredMessages(f, func(chatJID string, at time.Time, score float64) {
+		date := at.In(f.Location).Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			return
+		}
+		day.Scored++
+		totals[date] += score
+		switch {
+		case score >= positiveSentiment:
+			day.Positive++
+		case score <= negativeSentiment:
+			day.Negative++
+		default:
+			day.Neutral++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if result[i].Scored > 0 {
+			average := totals[result[i].Date] / float64(result[i].Scored)
+			result[i].Average = &average
+		}
+	}
+	return result, nil
+}
+
+// ChatSentimentScores summarizes sentiment per chat, the chats whose latest messages are most
+// negative first. Trend is the recent average minus the average of the whole period.
+func (store *MessageStore) ChatSentimentScores(f AnalyticsFilter) ([]ChatSentiment, error) {
+	result := []ChatSentiment{}
+	var recent []float64
+	var total float64
+	finish := func() {
+		if len(result) == 0 {
+			return
+		}
+		chat := &result[len(result)-1]
+		chat.Average = total / float64(chat.Scored)
+		var sum float64
+		for _, score := range recent {
+			sum += score
+		}
+		chat.RecentAverage = sum / float64(len(recent))
+		chat.Trend = chat.RecentAverage - chat.Average
+	}
+
+	err := store.scoredMessages(f, func(chatJID string, at time.Time, score float64) {
+		if len(result) == 0 || result[len(result)-1].ChatJID != chatJID {
+			finish()
+			result = append(result, ChatSentiment{ChatJID: chatJID})
+			recent, total = nil, 0
+		}
+		chat := &result[len(result)-1]
+		chat.Scored++
+		chat.LastMessageAt = at
+		total += score
+		if score <= negativeSentiment {
+			chat.Negative++
+		}
+		recent = append(recent, score)
+		if len(recent) > recentSentimentMessages {
+			recent = recent[1:]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	finish()
+
+	if len(result) > 0 {
+		names, err := store.chatNames()
+		if err != nil {
+			return nil, err
+		}
+		for i := range result {
+			result[i].Name = names[result[i].ChatJID]
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].RecentAverage < result[j].RecentAverage })
+	return result, nil
+}
+
+// chatNames maps chat JIDs to their names
+func (store *MessageStore) chatNames() (map[string]string, error) {
+	rows, err := store.db.Query("SELECT jid, COALESCE(name, '') FROM chats")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]string{}
+	for rows.Next() {
+		var jid, name string
+		if err := rows.Scan(&jid, &name); err != nil {
+			return nil, err
+		}
+		names[jid] = name
+	}
+	return names, rows.Err()
+}
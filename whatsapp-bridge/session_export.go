@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Session export files are versioned so later releases can still read older exports
+const (
+	sessionExportFormat     = "whatsapp-bridge-session"
+	sessionExportVersion    = 1
+	sessionExportKDF        = "pbkdf2-sha256"
+	sessionExportIterations = 600000
+	minSessionPassphraseLen = 12
+)
+
+// sessionTables are the whatsmeow tables holding the device credentials and Signal sessions.
+// The device comes first because the others reference it.
+var sessionTables = []string{
+	"whatsmeow_device",
+	"whatsmeow_identity_keys",
+	"whatsmeow_pre_keys",
+	"whatsmeow_sessions",
+	"whatsmeow_sender_keys",
+	"whatsmeow_app_state_sync_keys",
+	"whatsmeow_app_state_version",
+	"whatsmeow_app_state_mutation_macs",
+	"whatsmeow_contacts",
+	"whatsmeow_chat_settings",
+	"whatsmeow_message_secrets",
+	"whatsmeow_privacy_tokens",
+	"whatsmeow_lid_map",
+	"whatsmeow_event_buffer",
+}
+
+// sessionDeviceColumns names the column tying each table's rows to a device. The LID map is
+// shared by every device in a store, so it is copied whole.
+var sessionDeviceColumns = map[string]string{
+	"whatsmeow_device":                  "jid",
+	"whatsmeow_identity_keys":           "our_jid",
+	"whatsmeow_pre_keys":                "jid",
+	"whatsmeow_sessions":                "our_jid",
+	"whatsmeow_sender_keys":             "our_jid",
+	"whatsmeow_app_state_sync_keys":     "jid",
+	"whatsmeow_app_state_version":       "jid",
+	"whatsmeow_app_state_mutation_macs": "jid",
+	"whatsmeow_contacts":                "our_jid",
+	"whatsmeow_chat_settings":           "our_jid",
+	"whatsmeow_message_secrets":         "our_jid",
+	"whatsmeow_privacy_tokens":          "our_jid",
+	"whatsmeow_event_buffer":            "our_jid",
+}
+
+// SessionExportFile is the encrypted file written by -export-session
+type SessionExportFile struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// sessionSnapshot is the decrypted content of an export
+type sessionSnapshot struct {
+	ExportedAt    time.Time      `json:"exported_at"`
+	SchemaVersion int            `json:"schema_version"`
+	DeviceJID     string         `json:"device_jid"`
+	Tables        []sessionTable `json:"tables"`
+}
+
+// sessionTable holds the rows of one whatsmeow table
+type sessionTable struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// sessionPassphrase reads the passphrase from SESSION_PASSPHRASE, or asks for it on a terminal
+func sessionPassphrase() (string, error) {
+	passphrase := os.Getenv("SESSION_PASSPHRASE")
+	if passphrase == "" && isInteractive() {
+		fmt.Print("Session passphrase: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("set SESSION_PASSPHRASE or run the command on a terminal to enter one")
+	}
+	if len(passphrase) < minSessionPassphraseLen {
+		return "", fmt.Errorf("passphrase must be at least %d characters", minSessionPassphraseLen)
+	}
+	return passphrase, nil
+}
+
+// runSessionTransfer runs the -export-session or -import-session command
+func runSessionTransfer(logger waLog.Logger, exportPath, importPath string, force bool) error {
+	passphrase, err := sessionPassphrase()
+	if err != nil {
+		return err
+	}
+
+	// Initializing the adapter creates the whatsmeow tables an import needs
+	dbAdapter := NewDatabaseAdapter(logger)
+	if _, err := dbAdapter.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+	isPostgres := dbAdapter.dbURL != ""
+
+	db, err := openDeviceDB(dbAdapter.dbURL)
+	if err != nil {
+		return fmt.Errorf("failed to open device store: %v", err)
+	}
+	defer db.Close()
+
+	if exportPath != "" {
+		snapshot, err := exportSession(db, isPostgres, "")
+		if err != nil {
+			return err
+		}
+		if err := writeSessionExport(exportPath, snapshot, passphrase); err != nil {
+			return err
+		}
+		logger.Infof("Exported session of %s to %s", snapshot.DeviceJID, exportPath)
+		logger.Warnf("Stop this deployment before starting the one you import into; both can't use the session at once")
+		return nil
+	}
+
+	snapshot, err := readSessionExport(importPath, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := importSession(db, isPostgres, snapshot, force); err != nil {
+		return err
+	}
+	logger.Infof("Imported session of %s exported at %s", snapshot.DeviceJID, snapshot.ExportedAt.Format(time.RFC3339))
+	return nil
+}
+
+// openDeviceDB opens the default account's whatsmeow store directly: the PostgreSQL database at
+// dbURL, or whatsmeow.db in the data directory
+func openDeviceDB(dbURL string) (*sql.DB, error) {
+	if dbURL != "" {
+		return sql.Open(sqlDriverName("postgres"), dbURL)
+	}
+	return sql.Open(sqlDriverName("sqlite3"), sqliteDSN("whatsmeow.db"))
+}
+
+// sessionSchemaVersion returns the whatsmeow schema version of the device store
+func sessionSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("SELECT version FROM whatsmeow_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read whatsmeow schema version: %v", err)
+	}
+	return version, nil
+}
+
+// exportSession reads the whatsmeow rows of a device: the given one, or the only one in the store
+func exportSession(db *sql.DB, isPostgres bool, deviceJID string) (*sessionSnapshot, error) {
+	version, err := sessionSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &sessionSnapshot{ExportedAt: time.Now().UTC(), SchemaVersion: version, DeviceJID: deviceJID, Tables: []sessionTable{}}
+
+	if deviceJID == "" {
+		var devices int
+		if err := db.QueryRow("SELECT COUNT(*) FROM whatsmeow_device").Scan(&devices); err != nil {
+			return nil, fmt.Errorf("failed to read device: %v", err)
+		}
+		if devices != 1 {
+			return nil, fmt.Errorf("expected one linked device, found %d; pair the bridge before exporting, or start it once "+
+				"so additional accounts move their devices to their own stores", devices)
+		}
+		if err := db.QueryRow("SELECT jid FROM whatsmeow_device").Scan(&snapshot.DeviceJID); err != nil {
+			return nil, fmt.Errorf("failed to read device: %v", err)
+		}
+	}
+
+	for _, name := range sessionTables {
+		exists, err := tableExists(db, isPostgres, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %v", name, err)
+		}
+		if !exists {
+			continue
+		}
+		table, err := exportSessionTable(db, isPostgres, name, snapshot.DeviceJID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", name, err)
+		}
+		snapshot.Tables = append(snapshot.Tables, *table)
+	}
+	return snapshot, nil
+}
+
+// exportSessionTable reads a table's rows of a device, with binary and time values tagged so they survive JSON
+func exportSessionTable(db *sql.DB, isPostgres bool, name, deviceJID string) (*sessionTable, error) {
+	query, args := "SELECT * FROM "+name, []interface{}{}
+	if column := sessionDeviceColumns[name]; column != "" {
+		query += " WHERE " + column + " = " + devicePlaceholder(isPostgres)
+		args = append(args, deviceJID)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	table := &sessionTable{Name: name, Columns: columns, Rows: [][]interface{}{}}
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			switch v := value.(type) {
+			case []byte:
+				values[i] = map[string]string{"bytes": base64.StdEncoding.EncodeToString(v)}
+			case time.Time:
+				values[i] = map[string]string{"time": v.UTC().Format(time.RFC3339Nano)}
+			}
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
+
+// sessionValue turns a value decoded from an export back into a database value
+func sessionValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		if encoded, ok := v["bytes"].(string); ok {
+			return base64.StdEncoding.DecodeString(encoded)
+		}
+		if encoded, ok := v["time"].(string); ok {
+			return time.Parse(time.RFC3339Nano, encoded)
+		}
+		return nil, fmt.Errorf("unknown value %v", v)
+	}
+	return value, nil
+}
+
+// importSession writes an exported session into the device store in one transaction
+func importSession(db *sql.DB, isPostgres bool, snapshot *sessionSnapshot, force bool) error {
+	version, err := sessionSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version != snapshot.SchemaVersion {
+		return fmt.Errorf("export has whatsmeow schema version %d but this deployment has %d; run the same bridge version on both", snapshot.SchemaVersion, version)
+	}
+
+	var devices int
+	if err := db.QueryRow("SELECT COUNT(*) FROM whatsmeow_device").Scan(&devices); err != nil {
+		return fmt.Errorf("failed to read device: %v", err)
+	}
+	if devices > 0 && !force {
+		return fmt.Errorf("this deployment already has a linked device; pass -force to replace it")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Clear the existing session, children before the device they reference
+	for i := len(sessionTables) - 1; i >= 0; i-- {
+		exists, err := tableExists(db, isPostgres, sessionTables[i])
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %v", sessionTables[i], err)
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM " + sessionTables[i]); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", sessionTables[i], err)
+		}
+	}
+
+	known := make(map[string]bool, len(sessionTables))
+	for _, name := range sessionTables {
+		known[name] = true
+	}
+	for _, table := range snapshot.Tables {
+		// Table and column names are interpolated, so only whatsmeow's own are accepted
+		if !known[table.Name] {
+			return fmt.Errorf("export contains unexpected table %s", table.Name)
+		}
+		placeholders := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			if !validIdentifier(column) {
+				return fmt.Errorf("export contains invalid column %q in %s", column, table.Name)
+			}
+			placeholders[i] = "?"
+			if isPostgres {
+				placeholders[i] = fmt.Sprintf("$%d", i+1)
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.Name, strings.Join(table.Columns, ", "), strings.Join(placeholders, ", "))
+
+		for _, row := range table.Rows {
+			if len(row) != len(table.Columns) {
+				return fmt.Errorf("export has a malformed row in %s", table.Name)
+			}
+			args := make([]interface{}, len(row))
+			for i, value := range row {
+				if args[i], err = sessionValue(value); err != nil {
+					return fmt.Errorf("invalid value for %s.%s: %v", table.Name, table.Columns[i], err)
+				}
+			}
+			if _, err := tx.Exec(query, args...); err != nil {
+				return fmt.Errorf("failed to import %s: %v", table.Name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %v", err)
+	}
+	return nil
+}
+
+// deleteSessionDevice removes a device's rows from a whatsmeow store, leaving the shared LID map
+func deleteSessionDevice(db *sql.DB, isPostgres bool, deviceJID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Children before the device they reference
+	for i := len(sessionTables) - 1; i >= 0; i-- {
+		column := sessionDeviceColumns[sessionTables[i]]
+		if column == "" {
+			continue
+		}
+		exists, err := tableExists(db, isPostgres, sessionTables[i])
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %v", sessionTables[i], err)
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM "+sessionTables[i]+" WHERE "+column+" = "+devicePlaceholder(isPostgres), deviceJID); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", sessionTables[i], err)
+		}
+	}
+	return tx.Commit()
+}
+
+// devicePlaceholder is the placeholder of the device JID in the queries above
+func devicePlaceholder(isPostgres bool) string {
+	if isPostgres {
+		return "$1"
+	}
+	return "?"
+}
+
+// validIdentifier reports whether a column name is safe to put in a query
+func validIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionKey derives the encryption key from the passphrase
+func sessionKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+}
+
+// writeSessionExport encrypts a snapshot with AES-256-GCM and writes it readable only by the owner
+func writeSessionExport(path string, snapshot *sessionSnapshot, passphrase string) error {
+	plaintext, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+
+	file := SessionExportFile{
+		Format:     sessionExportFormat,
+		Version:    sessionExportVersion,
+		KDF:        sessionExportKDF,
+		Iterations: sessionExportIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+	gcm, err := sessionCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, []byte(sessionExportFormat))
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode export: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// readSessionExport decrypts an export written by writeSessionExport
+func readSessionExport(path, passphrase string) (*sessionSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var file SessionExportFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Format != sessionExportFormat {
+		return nil, fmt.Errorf("%s is not a session export", path)
+	}
+	if file.Version != sessionExportVersion || file.KDF != sessionExportKDF {
+		return nil, fmt.Errorf("unsupported session export version %d (%s)", file.Version, file.KDF)
+	}
+	if file.Iterations < 100000 {
+		return nil, fmt.Errorf("session export uses too few key derivation iterations")
+	}
+
+	gcm, err := sessionCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("session export has an invalid nonce")
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, []byte(sessionExportFormat))
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted export")
+	}
+
+	return decodeSessionSnapshot(plaintext)
+}
+
+// decodeSessionSnapshot decodes a snapshot into the values importSession expects
+func decodeSessionSnapshot(data []byte) (*sessionSnapshot, error) {
+	// Numbers are kept exact, since key IDs and timestamps don't fit a float64
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var snapshot sessionSnapshot
+	if err := decoder.Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// sessionCipher returns the AES-256-GCM cipher for a passphrase
+func sessionCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := sessionKey(passphrase, salt, iterations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
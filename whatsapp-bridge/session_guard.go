@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Reasons the session gets locked
+const (
+	// LockStreamReplaced means another client connected with the bridge's session
+	LockStreamReplaced = "stream_replaced"
+	// LockLoggedOut means the phone unlinked the bridge
+	LockLoggedOut = "logged_out"
+)
+
+// SessionLock records a possible takeover of the WhatsApp session. Sending stays blocked
+// and the bridge won't reconnect or pair again until an admin acknowledges it.
+type SessionLock struct {
+	ID             string     `json:"id"`
+	Reason         string     `json:"reason"`
+	Detail         string     `json:"detail,omitempty"`
+	LockedAt       time.Time  `json:"locked_at"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+// SessionGuard locks sending when the session may have been taken over
+type SessionGuard struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	alertURL     string
+	current      *SessionLock
+	acknowledged chan struct{}
+	mutex        sync.Mutex
+}
+
+// sessionGuard is set once the message store is open
+var sessionGuard *SessionGuard
+
+// NewSessionGuard restores a lock left unacknowledged by a previous run
+func NewSessionGuard(messageStore *MessageStore, logger waLog.Logger) (*SessionGuard, error) {
+	current, err := messageStore.GetSessionLock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session lock: %v", err)
+	}
+	return &SessionGuard{
+		messageStore: messageStore,
+		logger:       logger,
+		alertURL:     os.Getenv("ALERT_WEBHOOK_URL"),
+		current:      current,
+		acknowledged: make(chan struct{}),
+	}, nil
+}
+
+// Current returns the active lock, or nil when sending is allowed
+func (g *SessionGuard) Current() *SessionLock {
+	if g == nil {
+		return nil
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.current
+}
+
+// Lock blocks sending and raises an alert on every channel; an existing lock is kept
+func (g *SessionGuard) Lock(reason, detail string) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.current != nil {
+		return
+	}
+	lock := &SessionLock{ID: newEventID(), Reason: reason, Detail: detail, LockedAt: time.Now().UTC()}
+	if err := g.messageStore.SaveSessionLock(lock); err != nil {
+		// Still lock in memory; a restart without the row only loses the lock, not the alert
+		g.logger.Errorf("Failed to persist session lock: %v", err)
+	}
+	g.current = lock
+	g.acknowledged = make(chan struct{})
+
+	message := fmt.Sprintf("WhatsApp session locked (%s): %s. Sending is blocked until an admin acknowledges with POST /api/v1/session/lock/acknowledge.", reason, detail)
+	g.logger.Errorf("%s", message)
+	publishEvent(EventSessionLocked, "", lock.LockedAt, map[string]interface{}{
+		"id":     lock.ID,
+		"reason": reason,
+		"detail": detail,
+	})
+	if g.alertURL != "" {
+		go g.alert(message)
+	}
+}
+
+// Acknowledge lifts the lock on behalf of an admin
+func (g *SessionGuard) Acknowledge(by string) (*SessionLock, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.current == nil {
+		return nil, nil
+	}
+	lock := *g.current
+	now := time.Now().UTC()
+	lock.AcknowledgedBy = by
+	lock.AcknowledgedAt = &now
+	if err := g.messageStore.AcknowledgeSessionLock(lock.ID, by, now); err != nil {
+		return nil, err
+	}
+	g.current = nil
+	close(g.acknowledged)
+
+	g.logger.Infof("Session lock %s acknowledged by %s", lock.ID, by)
+	publishEvent(EventSessionUnlocked, "", now, map[string]interface{}{
+		"id":              lock.ID,
+		"reason":          lock.Reason,
+		"acknowledged_by": by,
+	})
+	return &lock, nil
+}
+
+// AwaitAcknowledgement blocks while the session is locked
+func (g *SessionGuard) AwaitAcknowledgement() {
+	g.mutex.Lock()
+	locked, acknowledged := g.current != nil, g.acknowledged
+	g.mutex.Unlock()
+	if locked {
+		<-acknowledged
+	}
+}
+
+// alert posts the lock to ALERT_WEBHOOK_URL
+func (g *SessionGuard) alert(message string) {
+	if err := postAlert(g.alertURL, message); err != nil {
+		g.logger.Errorf("Failed to send session lock alert: %v", err)
+	}
+}
+
+// postAlert posts a message to an alert webhook in the {"text": ...} shape chat tools accept
+func postAlert(url, message string) error {
+	body, _ := json.Marshal(map[string]string{"text": message})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendUnlocked wraps send handlers so they answer 423 Locked while the session is locked
+func sendUnlocked(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if lock := sessionGuard.Current(); lock != nil {
+			http.Error(w, fmt.Sprintf("Sending is locked since %s (%s); an admin must acknowledge the lock", lock.LockedAt.Format(time.RFC3339), lock.Reason), http.StatusLocked)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// GetSessionLock returns the unacknowledged session lock, or nil
+func (store *MessageStore) GetSessionLock() (*SessionLock, error) {
+	query := `SELECT id, reason, detail, locked_at FROM session_locks
+		WHERE acknowledged_at IS NULL ORDER BY locked_at DESC LIMIT 1`
+
+	var lock SessionLock
+	var detail sql.NullString
+	err := store.db.QueryRow(query).Scan(&lock.ID, &lock.Reason, &detail, &lock.LockedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock.Detail = detail.String
+	return &lock, nil
+}
+
+// SaveSessionLock records a new session lock
+func (store *MessageStore) SaveSessionLock(lock *SessionLock) error {
+	query := "INSERT INTO session_locks (id, reason, detail, locked_at) VALUES (?, ?, ?, ?)"
+	if store.isPostgres {
+		query = "INSERT INTO session_locks (id, reason, detail, locked_at) VALUES ($1, $2, $3, $4)"
+	}
+	_, err := store.db.Exec(query, lock.ID, lock.Reason, lock.Detail, lock.LockedAt)
+	return err
+}
+
+// AcknowledgeSessionLock marks a session lock as acknowledged
+func (store *MessageStore) AcknowledgeSessionLock(id, by string, at time.Time) error {
+	query := "UPDATE session_locks SET acknowledged_by = ?, acknowledged_at = ? WHERE id = ?"
+	if store.isPostgres {
+		query = "UPDATE session_locks SET acknowledged_by = $1, acknowledged_at = $2 WHERE id = $3"
+	}
+	if _, err := store.db.Exec(query, by, at, id); err != nil {
+		return fmt.Errorf("failed to acknowledge session lock: %v", err)
+	}
+	return nil
+}
+
+// SessionStatus is the response of GET /api/v1/session/lock
+type SessionStatus struct {
+	Locked bool         `json:"locked"`
+	Lock   *SessionLock `json:"lock,omitempty"`
+}
+
+// registerSessionGuardRoutes registers /api/v1/session/lock and its acknowledgement
+func registerSessionGuardRoutes() {
+	handleAPI("/session/lock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status := SessionStatus{}
+		if lock := sessionGuard.Current(); lock != nil {
+			shown := *lock
+			shown.LockedAt = shown.LockedAt.In(loc)
+			status = SessionStatus{Locked: true, Lock: &shown}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	// Only the leader holds the lock in memory and waits for the acknowledgement
+	handleAPI("/session/lock/acknowledge", leaderOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			AcknowledgedBy string `json:"acknowledged_by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AcknowledgedBy == "" {
+			http.Error(w, "acknowledged_by is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.AcknowledgedBy) > maxAgentLen {
+			http.Error(w, fmt.Sprintf("acknowledged_by must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+			return
+		}
+
+		lock, err := sessionGuard.Acknowledge(req.AcknowledgedBy)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to acknowledge: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if lock == nil {
+			http.Error(w, "Session is not locked", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lock)
+	}))
+}
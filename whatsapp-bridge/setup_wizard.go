@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// SetupResult holds the wizard answers that affect the current run rather than the config file
+type SetupResult struct {
+	// PairPhone is the phone number to pair with a link code instead of a QR code
+	PairPhone string
+}
+
+// isInteractive reports whether stdin is a terminal. A character device isn't enough:
+// /dev/null is one too, and is what Docker and systemd give a service as stdin.
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// shouldRunSetupWizard reports whether this looks like a first run on an interactive terminal:
+// no command-line flags, no .env or config file, no database URL and no local device session
+func shouldRunSetupWizard() bool {
+	if flag.NFlag() > 0 || !isInteractive() {
+		return false
+	}
+	for _, path := range []string{".env", dataPath(configFileName)} {
+		if _, err := os.Stat(path); err == nil {
+			return false
+		}
+	}
+	if os.Getenv("DATABASE_URL") != "" {
+		return false
+	}
+	if _, err := os.Stat(dataPath("whatsmeow.db")); err == nil {
+		return false
+	}
+	return true
+}
+
+// wizard asks questions on a terminal
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+	err error
+}
+
+// ask prompts for a value, returning def when the answer is empty
+func (w *wizard) ask(prompt, def string) string {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+
+	line, err := w.in.ReadString('\n')
+	if err != nil {
+		// Remember that input ended so loops waiting for a valid answer can give up
+		w.err = err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+// choose prompts for one of the numbered options and returns its index
+func (w *wizard) choose(prompt string, options []string) int {
+	fmt.Fprintln(w.out, prompt)
+	for i, option := range options {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, option)
+	}
+	for {
+		answer := w.ask("Choose", "1")
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+		if w.err != nil {
+			return 0
+		}
+		fmt.Fprintf(w.out, "Please enter a number between 1 and %d\n", len(options))
+	}
+}
+
+// runSetupWizard walks through first-run configuration, writes it to the config file
+// and applies it to the current process so startup continues with the new settings
+func runSetupWizard(in io.Reader, out io.Writer) (*SetupResult, error) {
+	w := &wizard{in: bufio.NewReader(in), out: out}
+	values := map[string]string{}
+	result := &SetupResult{}
+
+	fmt.Fprintln(out, "\nWelcome to the WhatsApp bridge! Let's set up its configuration.")
+	fmt.Fprintln(out, "Press Enter to accept the default shown in brackets.")
+
+	// Database
+	fmt.Fprintln(out)
+	if w.choose("Where should messages and the WhatsApp session be stored?", []string{
+		"SQLite files in " + dataDir + " (simplest, single instance)",
+		"PostgreSQL / Supabase",
+	}) == 1 {
+		for {
+			dbURL := w.ask("PostgreSQL connection URL", "")
+			if w.err != nil {
+				return nil, fmt.Errorf("setup cancelled: %v", w.err)
+			}
+			if dbURL == "" {
+				continue
+			}
+			if err := testPostgresURL(dbURL); err != nil {
+				fmt.Fprintf(out, "Could not connect: %v\n", err)
+				if w.ask("Use it anyway? (y/N)", "n") != "y" {
+					continue
+				}
+			}
+			values["DATABASE_URL"] = dbURL
+			break
+		}
+	}
+
+	// Web port
+	fmt.Fprintln(out)
+	for {
+		port := w.ask("Port for the web dashboard and REST API", "8080")
+		if n, err := strconv.Atoi(port); err == nil && n > 0 && n < 65536 {
+			values["PORT"] = port
+			break
+		}
+		if w.err != nil {
+			return nil, fmt.Errorf("setup cancelled: %v", w.err)
+		}
+		fmt.Fprintln(out, "Please enter a port between 1 and 65535")
+	}
+
+	// Dashboard authentication
+	fmt.Fprintln(out)
+	if w.choose("How should the web dashboard be protected?", []string{
+		"Local user accounts, managed in the dashboard",
+		"Supabase authentication",
+	}) == 1 {
+		values["SUPABASE_URL"] = w.ask("Supabase project URL", "")
+		values["SUPABASE_ANON_KEY"] = w.ask("Supabase anon key", "")
+	} else {
+		// The password isn't written to the config file; the first start generates and logs one
+		values["DASHBOARD_ADMIN_EMAIL"] = w.ask("Email of the first dashboard admin", defaultAdminEmail)
+		fmt.Fprintln(out, "The admin's password is printed in the log on the first start. Change it on the Passkeys page.")
+	}
+
+	// Pairing
+	fmt.Fprintln(out)
+	if w.choose("How do you want to link your WhatsApp account?", []string{
+		"Scan a QR code (terminal and web dashboard)",
+		"Enter a pairing code on your phone",
+	}) == 1 {
+		for result.PairPhone == "" {
+			result.PairPhone = normalizePairPhone(w.ask("Phone number with country code (e.g. +44 7700 900123)", ""))
+			if result.PairPhone == "" && w.err != nil {
+				return nil, fmt.Errorf("setup cancelled: %v", w.err)
+			}
+		}
+	}
+
+	// Never save answers that were defaulted because input ended, e.g. stdin closed mid-way
+	if w.err != nil {
+		return nil, fmt.Errorf("setup cancelled: %v", w.err)
+	}
+
+	configPath := dataPath(configFileName)
+	if err := ensureDataDir(); err != nil {
+		return nil, err
+	}
+	if err := writeEnvFile(configPath, values); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		os.Setenv(key, value)
+	}
+
+	fmt.Fprintf(out, "\nSaved configuration to %s. Edit it or delete it to run this wizard again.\n\n", configPath)
+	return result, nil
+}
+
+// testPostgresURL checks that a PostgreSQL URL is reachable
+func testPostgresURL(dbURL string) error {
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Ping()
+}
+
+// normalizePairPhone keeps only the digits of a phone number, as PairPhone expects
+func normalizePairPhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	return digits.String()
+}
+
+// writeEnvFile sets keys in a .env file, keeping its other lines and comments
+func writeEnvFile(path string, values map[string]string) error {
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	written := map[string]bool{}
+	for i, line := range lines {
+		key, _, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if value, ok := values[key]; ok {
+			lines[i] = key + "=" + strconv.Quote(value)
+			written[key] = true
+		}
+	}
+
+	// Append new keys in a stable order
+	for _, key := range []string{"DATABASE_URL", "PORT", "SUPABASE_URL", "SUPABASE_ANON_KEY"} {
+		if value, ok := values[key]; ok && !written[key] {
+			lines = append(lines, key+"="+strconv.Quote(value))
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
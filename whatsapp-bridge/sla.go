@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// slaTargetsFileName is the default per-queue SLA targets file inside DATA_DIR
+const slaTargetsFileName = "sla_targets.json"
+
+// SLA kinds, as reported in breach alerts and sla.breached events
+const (
+	SLAFirstResponse = "first_response"
+	SLAResolution    = "resolution"
+)
+
+// slaAutomatedAgents send messages on the bridge's behalf; they don't count as a first response
+var slaAutomatedAgents = []string{"auto-reply", "commands", "flow", "moderation", "broadcast"}
+
+// SLATarget is how long the chats of a queue may wait for a first response and for a resolution.
+// Zero means the queue has no such target.
+type SLATarget struct {
+	Queue                string `json:"queue"`
+	FirstResponseMinutes int    `json:"first_response_minutes,omitempty"`
+	ResolutionMinutes    int    `json:"resolution_minutes,omitempty"`
+}
+
+// SLAStatus is where an assignment stands against the targets of its queue
+type SLAStatus struct {
+	FirstResponseDue      *time.Time `json:"first_response_due,omitempty"`
+	FirstResponseBreached bool       `json:"first_response_breached"`
+	ResolutionDue         *time.Time `json:"resolution_due,omitempty"`
+	ResolutionBreached    bool       `json:"resolution_breached"`
+}
+
+// SLATracker records first responses to assigned chats and alerts when a target is missed
+type SLATracker struct {
+	messageStore  *MessageStore
+	logger        waLog.Logger
+	defaultTarget SLATarget
+	targets       map[string]SLATarget
+	interval      time.Duration
+	alertURL      string
+}
+
+// slaTracker is set when SLA targets are configured
+var slaTracker *SLATracker
+
+// NewSLATrackerFromEnv reads the default targets from SLA_FIRST_RESPONSE_MINUTES and SLA_RESOLUTION_MINUTES,
+// and per-queue targets from SLA_TARGETS_FILE or DATA_DIR/sla_targets.json. It returns nil without targets.
+func NewSLATrackerFromEnv(messageStore *MessageStore, logger waLog.Logger) (*SLATracker, error) {
+	tracker := &SLATracker{
+		messageStore: messageStore,
+		logger:       logger,
+		defaultTarget: SLATarget{
+			FirstResponseMinutes: getEnvInt("SLA_FIRST_RESPONSE_MINUTES", 0),
+			ResolutionMinutes:    getEnvInt("SLA_RESOLUTION_MINUTES", 0),
+		},
+		targets:  make(map[string]SLATarget),
+		interval: time.Duration(getEnvInt("SLA_CHECK_INTERVAL_SECONDS", 60)) * time.Second,
+		alertURL: os.Getenv("ALERT_WEBHOOK_URL"),
+	}
+	if tracker.defaultTarget.FirstResponseMinutes < 0 || tracker.defaultTarget.ResolutionMinutes < 0 {
+		return nil, fmt.Errorf("SLA_FIRST_RESPONSE_MINUTES and SLA_RESOLUTION_MINUTES must not be negative")
+	}
+	if tracker.interval < time.Second {
+		return nil, fmt.Errorf("SLA_CHECK_INTERVAL_SECONDS must be positive")
+	}
+
+	path := os.Getenv("SLA_TARGETS_FILE")
+	if path == "" {
+		path = dataPath(slaTargetsFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = ""
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SLA targets: %v", err)
+		}
+		var targets []SLATarget
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return nil, fmt.Errorf("invalid SLA targets in %s: %v", path, err)
+		}
+		for i, target := range targets {
+			if target.Queue == "" {
+				return nil, fmt.Errorf("SLA target %d has no queue", i+1)
+			}
+			if target.FirstResponseMinutes < 0 || target.ResolutionMinutes < 0 {
+				return nil, fmt.Errorf("SLA target for queue %q must not be negative", target.Queue)
+			}
+			tracker.targets[target.Queue] = target
+		}
+		logger.Infof("Loaded SLA targets of %d queues from %s", len(targets), path)
+	}
+
+	if len(tracker.targets) == 0 && tracker.defaultTarget.FirstResponseMinutes == 0 && tracker.defaultTarget.ResolutionMinutes == 0 {
+		return nil, nil
+	}
+	return tracker, nil
+}
+
+// Start checks the open assignments now and then every interval
+func (t *SLATracker) Start() {
+	go func() {
+		for {
+			t.check()
+			time.Sleep(t.interval)
+		}
+	}()
+}
+
+// target returns the targets of a queue; a queue listed in the targets file doesn't use the defaults
+func (t *SLATracker) target(queue string) SLATarget {
+	if target, ok := t.targets[queue]; ok {
+		return target
+	}
+	target := t.defaultTarget
+	target.Queue = queue
+	return target
+}
+
+// Status computes the due times of an assignment and whether they were missed, or nil when
+// its queue has no targets
+func (t *SLATracker) Status(assignment *ChatAssignment, now time.Time) *SLAStatus {
+	if t == nil {
+		return nil
+	}
+	target := t.target(assignment.Queue)
+	if target.FirstResponseMinutes == 0 && target.ResolutionMinutes == 0 {
+		return nil
+	}
+
+	status := &SLAStatus{}
+	if target.FirstResponseMinutes > 0 {
+		due := assignment.AssignedAt.Add(time.Duration(target.FirstResponseMinutes) * time.Minute)
+		status.FirstResponseDue = &due
+		status.FirstResponseBreached = missed(assignment.FirstResponseAt, due, now)
+	}
+	if target.ResolutionMinutes > 0 {
+		due := assignment.AssignedAt.Add(time.Duration(target.ResolutionMinutes) * time.Minute)
+		status.ResolutionDue = &due
+		status.ResolutionBreached = missed(assignment.ResolvedAt, due, now)
+	}
+	return status
+}
+
+// missed reports whether something that happened at done, or hasn't happened yet, missed its due time
+func missed(done *time.Time, due, now time.Time) bool {
+	if done != nil {
+		return done.After(due)
+	}
+	return now.After(due)
+}
+
+// check records the first responses to open assignments and alerts about newly missed targets
+func (t *SLATracker) check() {
+	assignments, err := t.messageStore.ListAssignments("", AssignmentsOpen)
+	if err != nil {
+		t.logger.Warnf("Failed to list assignments for SLA check: %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range assignments {
+		assignment := &assignments[i]
+		if assignment.FirstResponseAt == nil {
+			at, err := t.messageStore.firstResponse(assignment.ChatJID, assignment.AssignedAt)
+			if err != nil {
+				t.logger.Warnf("Failed to find first response in %s: %v", assignment.ChatJID, err)
+				continue
+			}
+			if at != nil {
+				if err := t.messageStore.setAssignmentTime(assignment.ChatJID, "first_response_at", *at); err != nil {
+					t.logger.Warnf("Failed to store first response of %s: %v", assignment.ChatJID, err)
+					continue
+				}
+				assignment.FirstResponseAt = at
+			}
+		}
+
+		status := t.Status(assignment, now)
+		if status == nil {
+			continue
+		}
+		if status.FirstResponseBreached && !assignment.firstResponseAlerted {
+			t.breach(assignment, SLAFirstResponse, *status.FirstResponseDue, "first_response_breached_at")
+		}
+		if status.ResolutionBreached && !assignment.resolutionAlerted {
+			t.breach(assignment, SLAResolution, *status.ResolutionDue, "resolution_breached_at")
+		}
+	}
+}
+
+// breach records that a target was missed, so it is only reported once, publishes an sla.breached
+// event and sends an alert
+func (t *SLATracker) breach(assignment *ChatAssignment, kind string, due time.Time, column string) {
+	if err := t.messageStore.setAssignmentTime(assignment.ChatJID, column, time.Now().UTC()); err != nil {
+		t.logger.Warnf("Failed to record SLA breach of %s: %v", assignment.ChatJID, err)
+		return
+	}
+
+	message := fmt.Sprintf("SLA breached: %s in queue %s missed its %s deadline of %s (assigned %s)",
+		t.messageStore.getChatDisplayName(assignment.ChatJID), assignment.Queue, strings.ReplaceAll(kind, "_", " "),
+		due.Format(time.RFC3339), assignment.AssignedAt.Format(time.RFC3339))
+	t.logger.Warnf("%s", message)
+
+	publishEvent(EventSLABreached, assignment.ChatJID, time.Time{}, map[string]interface{}{
+		"chat_jid":    assignment.ChatJID,
+		"queue":       assignment.Queue,
+		"sla":         kind,
+		"assigned_at": assignment.AssignedAt,
+		"due_at":      due,
+	})
+	if t.alertURL != "" {
+		go func() {
+			if err := postAlert(t.alertURL, message); err != nil {
+				t.logger.Errorf("Failed to send SLA alert: %v", err)
+			}
+		}()
+	}
+}
+
+// firstResponse returns the time of the first message sent in a chat since it was assigned, not
+// counting automatic answers, or nil if nobody has answered yet
+func (store *MessageStore) firstResponse(chatJID string, since time.Time) (*time.Time, error) {
+	placeholders := make([]string, len(slaAutomatedAgents))
+	args := []interface{}{chatJID, true, since.UTC()}
+	for i, agent := range slaAutomatedAgents {
+		placeholders[i] = "?"
+		if store.isPostgres {
+			placeholders[i] = fmt.Sprintf("$%d", len(args)+1)
+		}
+		args = append(args, agent)
+	}
+	query := "SELECT timestamp FROM messages WHERE chat_jid = ? AND is_from_me = ? AND timestamp >= ? AND system_event IS NULL"
+	if store.isPostgres {
+		query = "SELECT timestamp FROM messages WHERE chat_jid = $1 AND is_from_me = $2 AND timestamp >= $3 AND system_event IS NULL"
+	}
+	query += " AND (agent IS NULL OR agent NOT IN (" + strings.Join(placeholders, ", ") + ")) ORDER BY timestamp LIMIT 1"
+
+	var at time.Time
+	err := store.db.QueryRow(query, args...).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	at = at.UTC()
+	return &at, nil
+}
+
+// setAssignmentTime sets one of the SLA timestamps of an assignment
+func (store *MessageStore) setAssignmentTime(chatJID, column string, at time.Time) error {
+	query := fmt.Sprintf("UPDATE chat_assignments SET %s = ? WHERE chat_jid = ?", column)
+	if store.isPostgres {
+		query = fmt.Sprintf("UPDATE chat_assignments SET %s = $1 WHERE chat_jid = $2", column)
+	}
+	_, err := store.db.Exec(query, at, chatJID)
+	return err
+}
+
+// ResolveChat marks the assignment of a chat resolved, which stops its resolution clock. It returns
+// false when the chat isn't assigned or was already resolved.
+func (store *MessageStore) ResolveChat(chatJID string) (bool, error) {
+	query := "UPDATE chat_assignments SET resolved_at = ? WHERE chat_jid = ? AND resolved_at IS NULL"
+	if store.isPostgres {
+		query = "UPDATE chat_assignments SET resolved_at = $1 WHERE chat_jid = $2 AND resolved_at IS NULL"
+	}
+	result, err := store.db.Exec(query, time.Now().UTC(), chatJID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// registerSLARoutes registers /api/v1/sla/targets and /api/v1/chats/{jid}/resolve
+func registerSLARoutes(messageStore *MessageStore) {
+	handleAPI("/sla/targets", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response := struct {
+			Default *SLATarget  `json:"default,omitempty"`
+			Queues  []SLATarget `json:"queues"`
+		}{Queues: []SLATarget{}}
+		if slaTracker != nil {
+			if slaTracker.defaultTarget.FirstResponseMinutes > 0 || slaTracker.defaultTarget.ResolutionMinutes > 0 {
+				response.Default = &slaTracker.defaultTarget
+			}
+			for _, target := range slaTracker.targets {
+				response.Queues = append(response.Queues, target)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	registerChatRoute("resolve", func(w http.ResponseWriter, r *http.Request, chatJID string) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resolved, err := messageStore.ResolveChat(chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to resolve chat: %v", err), http.StatusInternalServerError)
+			return
+		}
+		assignment, err := messageStore.GetAssignment(chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get assignment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if assignment == nil {
+			http.Error(w, "Chat is not assigned", http.StatusNotFound)
+			return
+		}
+		if resolved {
+			publishEvent(EventChatResolved, chatJID, time.Time{}, map[string]interface{}{
+				"chat_jid": chatJID,
+				"queue":    assignment.Queue,
+			})
+		}
+		assignment.localize(loc)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Storage health levels, from best to worst
+const (
+	StorageOK       = "ok"
+	StorageWarning  = "warning"
+	StorageCritical = "critical"
+)
+
+// storageLevels maps health levels to the value of the Prometheus gauge
+var storageLevels = map[string]int{StorageOK: 0, StorageWarning: 1, StorageCritical: 2}
+
+// StorageStatus is the storage section of /api/v1/health
+type StorageStatus struct {
+	Status          string    `json:"status"`
+	Problems        []string  `json:"problems,omitempty"`
+	DatabaseBytes   int64     `json:"database_bytes"`
+	MediaBytes      int64     `json:"media_bytes"`
+	MediaFiles      int       `json:"media_files"`
+	DiskFreeBytes   uint64    `json:"disk_free_bytes,omitempty"`
+	DiskTotalBytes  uint64    `json:"disk_total_bytes,omitempty"`
+	DiskFreePercent float64   `json:"disk_free_percent,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+// StorageMonitor measures the databases, media and free disk space in the background and
+// alerts when a threshold is crossed, before the bridge fills the disk
+type StorageMonitor struct {
+	messageStore        *MessageStore
+	logger              waLog.Logger
+	interval            time.Duration
+	warnFreePercent     float64
+	criticalFreePercent float64
+	maxDatabaseBytes    int64
+	maxMediaBytes       int64
+	alertURL            string
+	status              *StorageStatus
+	mutex               sync.Mutex
+}
+
+// storageMonitor is set once the message store is open
+var storageMonitor *StorageMonitor
+
+// NewStorageMonitorFromEnv reads the STORAGE_* thresholds
+func NewStorageMonitorFromEnv(messageStore *MessageStore, logger waLog.Logger) (*StorageMonitor, error) {
+	monitor := &StorageMonitor{
+		messageStore:        messageStore,
+		logger:              logger,
+		interval:            time.Duration(getEnvInt("STORAGE_CHECK_INTERVAL_SECONDS", 60)) * time.Second,
+		warnFreePercent:     float64(getEnvInt("STORAGE_WARN_FREE_PERCENT", 15)),
+		criticalFreePercent: float64(getEnvInt("STORAGE_CRITICAL_FREE_PERCENT", 5)),
+		maxDatabaseBytes:    int64(getEnvInt("STORAGE_MAX_DATABASE_MB", 0)) << 20,
+		maxMediaBytes:       int64(getEnvInt("STORAGE_MAX_MEDIA_MB", 0)) << 20,
+		alertURL:            os.Getenv("ALERT_WEBHOOK_URL"),
+	}
+	if monitor.interval < time.Second {
+		return nil, fmt.Errorf("STORAGE_CHECK_INTERVAL_SECONDS must be positive")
+	}
+	if monitor.criticalFreePercent < 0 || monitor.warnFreePercent < monitor.criticalFreePercent || monitor.warnFreePercent > 100 {
+		return nil, fmt.Errorf("STORAGE_CRITICAL_FREE_PERCENT must be between 0 and STORAGE_WARN_FREE_PERCENT, which must be at most 100")
+	}
+	if monitor.maxDatabaseBytes < 0 || monitor.maxMediaBytes < 0 {
+		return nil, fmt.Errorf("STORAGE_MAX_DATABASE_MB and STORAGE_MAX_MEDIA_MB must not be negative")
+	}
+	return monitor, nil
+}
+
+// Start measures storage now and then every interval
+func (m *StorageMonitor) Start() {
+	m.check()
+	go func() {
+		for {
+			time.Sleep(m.interval)
+			m.check()
+		}
+	}()
+}
+
+// Status returns the last measurement, or nil before the first one
+func (m *StorageMonitor) Status() *StorageStatus {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.status
+}
+
+// check measures storage, stores the result and alerts when the level changes
+func (m *StorageMonitor) check() {
+	status := &StorageStatus{Status: StorageOK, CheckedAt: time.Now().UTC()}
+
+	databaseBytes, err := m.messageStore.databaseSize()
+	if err != nil {
+		m.logger.Warnf("Failed to measure database size: %v", err)
+	}
+	status.DatabaseBytes = databaseBytes
+	status.MediaBytes, status.MediaFiles = mediaSize()
+
+	if free, total, err := diskSpace(dataDir); err == nil && total > 0 {
+		status.DiskFreeBytes = free
+		status.DiskTotalBytes = total
+		status.DiskFreePercent = float64(int(float64(free)/float64(total)*1000)) / 10
+
+		if status.DiskFreePercent < m.criticalFreePercent {
+			status.raise(StorageCritical, "only %.1f%% disk space left", status.DiskFreePercent)
+		} else if status.DiskFreePercent < m.warnFreePercent {
+			status.raise(StorageWarning, "only %.1f%% disk space left", status.DiskFreePercent)
+		}
+	}
+	if m.maxDatabaseBytes > 0 && status.DatabaseBytes > m.maxDatabaseBytes {
+		status.raise(StorageWarning, "database is larger than %d MB", m.maxDatabaseBytes>>20)
+	}
+	if m.maxMediaBytes > 0 && status.MediaBytes > m.maxMediaBytes {
+		status.raise(StorageWarning, "media is larger than %d MB", m.maxMediaBytes>>20)
+	}
+
+	m.mutex.Lock()
+	previous := StorageOK
+	if m.status != nil {
+		previous = m.status.Status
+	}
+	m.status = status
+	m.mutex.Unlock()
+
+	if status.Status == previous {
+		return
+	}
+	var message string
+	if status.Status == StorageOK {
+		message = "WhatsApp bridge storage is back to normal"
+		m.logger.Infof("%s", message)
+	} else {
+		message = fmt.Sprintf("WhatsApp bridge storage %s: %s", status.Status, strings.Join(status.Problems, "; "))
+		m.logger.Warnf("%s", message)
+	}
+	publishEvent(EventStorageStatusChanged, "", status.CheckedAt, map[string]interface{}{
+		"status":   status.Status,
+		"previous": previous,
+		"problems": status.Problems,
+	})
+	if m.alertURL != "" {
+		go func() {
+			if err := postAlert(m.alertURL, message); err != nil {
+				m.logger.Errorf("Failed to send storage alert: %v", err)
+			}
+		}()
+	}
+}
+
+// raise records a problem and lowers the status to at least the given level
+func (s *StorageStatus) raise(level, format string, args ...interface{}) {
+	s.Problems = append(s.Problems, fmt.Sprintf(format, args...))
+	if storageLevels[level] > storageLevels[s.Status] {
+		s.Status = level
+	}
+}
+
+// databaseSize returns the size of the bridge and session databases
+func (store *MessageStore) databaseSize() (int64, error) {
+	if store.isPostgres {
+		var size int64
+		err := store.db.QueryRow("SELECT pg_database_size(current_database())").Scan(&size)
+		return size, err
+	}
+
+	// SQLite databases with their journal and WAL files
+	files, err := filepath.Glob(dataPath("*.db*"))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// mediaSize returns the size and number of files in the chat media, upload and quarantine directories
+func mediaSize() (int64, int) {
+	var size int64
+	var files int
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return 0, 0
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !(strings.Contains(name, "@") || name == "uploads" || name == "quarantine") {
+			continue
+		}
+		filepath.Walk(dataPath(name), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				size += info.Size()
+				files++
+			}
+			return nil
+		})
+	}
+	return size, files
+}
+
+// registerMetricsRoute serves storage and connection gauges at /metrics in the Prometheus text format
+func registerMetricsRoute(client *whatsmeow.Client) {
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var b strings.Builder
+		gauge := func(name, help string, value interface{}) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+		}
+
+		connected := 0
+		if client.IsConnected() {
+			connected = 1
+		}
+		gauge("whatsapp_bridge_connected", "Whether the WhatsApp connection is up.", connected)
+
+		if status := storageMonitor.Status(); status != nil {
+			gauge("whatsapp_bridge_database_size_bytes", "Size of the bridge and session databases.", status.DatabaseBytes)
+			gauge("whatsapp_bridge_media_size_bytes", "Size of stored media, uploads and quarantine.", status.MediaBytes)
+			gauge("whatsapp_bridge_media_files", "Number of stored media, upload and quarantine files.", status.MediaFiles)
+			if status.DiskTotalBytes > 0 {
+				gauge("whatsapp_bridge_disk_free_bytes", "Free space on the data directory's filesystem.", status.DiskFreeBytes)
+				gauge("whatsapp_bridge_disk_total_bytes", "Size of the data directory's filesystem.", status.DiskTotalBytes)
+			}
+			gauge("whatsapp_bridge_storage_level", "Storage health: 0 ok, 1 warning, 2 critical.", storageLevels[status.Status])
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
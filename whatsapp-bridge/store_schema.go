@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+)
+
+// messageColumns lists columns added to the messages table after the initial schema.
+// They are created on startup for both SQLite and PostgreSQL so existing deployments upgrade in place.
+var messageColumns = []struct {
+	name       string
+	definition string
+}{
+	{"scan_status", "TEXT"},
+	{"scan_detail", "TEXT"},
+	{"system_event", "TEXT"},
+	{"system_data", "TEXT"},
+	{"client_ref", "TEXT"},
+	{"agent", "TEXT"},
+	{"reply_to", "TEXT"},
+	{"storage_path", "TEXT"},
+	{"chain_seq", "INTEGER"},
+	{"chain_prev", "TEXT"},
+	{"chain_hash", "TEXT"},
+	{"sentiment", "REAL"},
+}
+
+// assignmentColumns lists columns added to the chat_assignments table for SLA tracking
+var assignmentColumns = []struct {
+	name       string
+	definition string
+}{
+	{"first_response_at", "TIMESTAMP"},
+	{"resolved_at", "TIMESTAMP"},
+	{"first_response_breached_at", "TIMESTAMP"},
+	{"resolution_breached_at", "TIMESTAMP"},
+}
+
+// messageIndexes are created after messageColumns, as they may cover added columns
+var messageIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_messages_client_ref ON messages (client_ref)",
+	"CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (chat_jid, reply_to)",
+	"CREATE INDEX IF NOT EXISTS idx_messages_chain ON messages (chat_jid, chain_seq)",
+}
+
+// bridgeTables lists the tables of the message store, created on startup if missing.
+// The postgres statement is only needed where the SQLite DDL isn't portable.
+var bridgeTables = []struct {
+	name     string
+	sqlite   string
+	postgres string
+}{
+	{
+		name: "chats",
+		sqlite: `CREATE TABLE IF NOT EXISTS chats (
+			jid TEXT PRIMARY KEY,
+			name TEXT,
+			last_message_time TIMESTAMP
+		)`,
+	},
+	{
+		name: "messages",
+		sqlite: `CREATE TABLE IF NOT EXISTS messages (
+			id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			content TEXT,
+			timestamp TIMESTAMP,
+			is_from_me BOOLEAN,
+			media_type TEXT,
+			filename TEXT,
+			url TEXT,
+			media_key BLOB,
+			file_sha256 BLOB,
+			file_enc_sha256 BLOB,
+			file_length INTEGER,
+			PRIMARY KEY (id, chat_jid),
+			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+		)`,
+		postgres: `CREATE TABLE IF NOT EXISTS messages (
+			id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			content TEXT,
+			timestamp TIMESTAMP,
+			is_from_me BOOLEAN,
+			media_type TEXT,
+			filename TEXT,
+			url TEXT,
+			media_key BYTEA,
+			file_sha256 BYTEA,
+			file_enc_sha256 BYTEA,
+			file_length INTEGER,
+			PRIMARY KEY (id, chat_jid),
+			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+		)`,
+	},
+	{
+		name: "drafts",
+		sqlite: `CREATE TABLE IF NOT EXISTS drafts (
+			chat_jid TEXT PRIMARY KEY,
+			content TEXT NOT NULL,
+			updated_by TEXT,
+			updated_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "chat_notes",
+		sqlite: `CREATE TABLE IF NOT EXISTS chat_notes (
+			chat_jid TEXT PRIMARY KEY,
+			notes TEXT NOT NULL,
+			updated_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "chat_metadata",
+		sqlite: `CREATE TABLE IF NOT EXISTS chat_metadata (
+			chat_jid TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (chat_jid, key)
+		)`,
+	},
+	{
+		name: "chat_assignments",
+		sqlite: `CREATE TABLE IF NOT EXISTS chat_assignments (
+			chat_jid TEXT PRIMARY KEY,
+			queue TEXT NOT NULL,
+			rule TEXT,
+			assigned_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "flow_sessions",
+		sqlite: `CREATE TABLE IF NOT EXISTS flow_sessions (
+			chat_jid TEXT PRIMARY KEY,
+			flow_id TEXT NOT NULL,
+			step TEXT NOT NULL,
+			variables TEXT,
+			started_at TIMESTAMP,
+			updated_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "pairing_attempts",
+		sqlite: `CREATE TABLE IF NOT EXISTS pairing_attempts (
+			id TEXT PRIMARY KEY,
+			method TEXT NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP,
+			outcome TEXT NOT NULL,
+			error TEXT,
+			device_jid TEXT,
+			platform TEXT,
+			viewers TEXT
+		)`,
+	},
+	{
+		name: "session_locks",
+		sqlite: `CREATE TABLE IF NOT EXISTS session_locks (
+			id TEXT PRIMARY KEY,
+			reason TEXT NOT NULL,
+			detail TEXT,
+			locked_at TIMESTAMP NOT NULL,
+			acknowledged_by TEXT,
+			acknowledged_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "api_usage",
+		sqlite: `CREATE TABLE IF NOT EXISTS api_usage (
+			subject TEXT NOT NULL,
+			period TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			amount BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (subject, period, metric)
+		)`,
+	},
+	{
+		name: "message_costs",
+		sqlite: `CREATE TABLE IF NOT EXISTS message_costs (
+			subject TEXT NOT NULL,
+			period TEXT NOT NULL,
+			chat_type TEXT NOT NULL,
+			country TEXT NOT NULL DEFAULT '',
+			messages BIGINT NOT NULL DEFAULT 0,
+			cost_micros BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (subject, period, chat_type, country)
+		)`,
+	},
+	{
+		name: "audit_exports",
+		sqlite: `CREATE TABLE IF NOT EXISTS audit_exports (
+			day TEXT PRIMARY KEY,
+			object TEXT NOT NULL,
+			records INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			manifest_sha256 TEXT NOT NULL,
+			exported_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "message_reactions",
+		sqlite: `CREATE TABLE IF NOT EXISTS message_reactions (
+			chat_jid TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			is_from_me BOOLEAN NOT NULL DEFAULT FALSE,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (chat_jid, message_id, sender)
+		)`,
+	},
+	{
+		name: "api_usage_daily",
+		sqlite: `CREATE TABLE IF NOT EXISTS api_usage_daily (
+			subject TEXT NOT NULL,
+			day TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			amount BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (subject, day, metric)
+		)`,
+	},
+	{
+		name: "billing_reports",
+		sqlite: `CREATE TABLE IF NOT EXISTS billing_reports (
+			tenant_id TEXT NOT NULL,
+			period TEXT NOT NULL,
+			sent_at TIMESTAMP,
+			PRIMARY KEY (tenant_id, period)
+		)`,
+	},
+	{
+		name: "tenants",
+		sqlite: `CREATE TABLE IF NOT EXISTS tenants (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			key_ids TEXT,
+			users TEXT,
+			suspended BOOLEAN NOT NULL DEFAULT FALSE,
+			suspended_reason TEXT,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "accounts",
+		sqlite: `CREATE TABLE IF NOT EXISTS accounts (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			device_jid TEXT,
+			created_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "warmup_accounts",
+		sqlite: `CREATE TABLE IF NOT EXISTS warmup_accounts (
+			account TEXT PRIMARY KEY,
+			started_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "warmup_sends",
+		sqlite: `CREATE TABLE IF NOT EXISTS warmup_sends (
+			account TEXT NOT NULL,
+			day TEXT NOT NULL,
+			sent INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (account, day)
+		)`,
+	},
+	{
+		name: "legal_holds",
+		sqlite: `CREATE TABLE IF NOT EXISTS legal_holds (
+			chat_jid TEXT PRIMARY KEY,
+			reason TEXT NOT NULL,
+			placed_by TEXT,
+			placed_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "legal_hold_audit",
+		sqlite: `CREATE TABLE IF NOT EXISTS legal_hold_audit (
+			id TEXT PRIMARY KEY,
+			chat_jid TEXT NOT NULL,
+			action TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			ip TEXT,
+			detail TEXT,
+			at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name:   "legal_hold_audit lookup index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_legal_hold_audit_chat ON legal_hold_audit (chat_jid, at)`,
+	},
+	{
+		name: "chain_heads",
+		sqlite: `CREATE TABLE IF NOT EXISTS chain_heads (
+			chat_jid TEXT PRIMARY KEY,
+			seq INTEGER NOT NULL,
+			hash TEXT NOT NULL
+		)`,
+	},
+	{
+		name: "chat_mutes",
+		sqlite: `CREATE TABLE IF NOT EXISTS chat_mutes (
+			chat_jid TEXT PRIMARY KEY,
+			muted_by TEXT NOT NULL,
+			muted_at TIMESTAMP NOT NULL,
+			muted_until TIMESTAMP
+		)`,
+	},
+	{
+		name: "chat_labels",
+		sqlite: `CREATE TABLE IF NOT EXISTS chat_labels (
+			chat_jid TEXT NOT NULL,
+			label TEXT NOT NULL,
+			added_at TIMESTAMP,
+			PRIMARY KEY (chat_jid, label)
+		)`,
+	},
+	{
+		name:   "chat_labels lookup index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_chat_labels_label ON chat_labels (label)`,
+	},
+	{
+		name: "bridge_events",
+		sqlite: `CREATE TABLE IF NOT EXISTS bridge_events (
+			id TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			chat_jid TEXT,
+			timestamp TIMESTAMP NOT NULL,
+			data TEXT
+		)`,
+	},
+	{
+		name:   "bridge_events timestamp index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_bridge_events_timestamp ON bridge_events (timestamp)`,
+	},
+	{
+		name:   "chat_metadata lookup index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_chat_metadata_key_value ON chat_metadata (key, value)`,
+	},
+	{
+		name: "campaigns",
+		sqlite: `CREATE TABLE IF NOT EXISTS campaigns (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "scheduled_messages",
+		sqlite: `CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id TEXT PRIMARY KEY,
+			campaign_id TEXT,
+			recipient TEXT NOT NULL,
+			chat_jid TEXT NOT NULL,
+			message TEXT,
+			media_path TEXT,
+			client_ref TEXT,
+			agent TEXT,
+			send_at TIMESTAMP NOT NULL,
+			timezone TEXT NOT NULL,
+			timezone_source TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			message_id TEXT,
+			error TEXT,
+			error_code TEXT,
+			created_at TIMESTAMP NOT NULL,
+			sent_at TIMESTAMP
+		)`,
+	},
+	{
+		name:   "scheduled_messages due index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (status, send_at)`,
+	},
+	{
+		name:   "scheduled_messages campaign index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_scheduled_messages_campaign ON scheduled_messages (campaign_id)`,
+	},
+	{
+		name: "scheduled_series",
+		sqlite: `CREATE TABLE IF NOT EXISTS scheduled_series (
+			id TEXT PRIMARY KEY,
+			recipient TEXT NOT NULL,
+			chat_jid TEXT NOT NULL,
+			message TEXT,
+			media_path TEXT,
+			client_ref TEXT,
+			agent TEXT,
+			cron TEXT NOT NULL,
+			timezone TEXT NOT NULL,
+			timezone_source TEXT NOT NULL,
+			skip_dates TEXT,
+			skip_weekdays TEXT,
+			on_skip TEXT NOT NULL,
+			end_date TEXT,
+			status TEXT NOT NULL,
+			next_send_at TIMESTAMP,
+			occurrences INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name:   "scheduled_series due index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_scheduled_series_due ON scheduled_series (status, next_send_at)`,
+	},
+	{
+		name: "contact_attributes",
+		sqlite: `CREATE TABLE IF NOT EXISTS contact_attributes (
+			chat_jid TEXT PRIMARY KEY,
+			name TEXT,
+			language TEXT,
+			fields TEXT,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "message_templates",
+		sqlite: `CREATE TABLE IF NOT EXISTS message_templates (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			body TEXT NOT NULL,
+			samples TEXT,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "outbox",
+		sqlite: `CREATE TABLE IF NOT EXISTS outbox (
+			id TEXT PRIMARY KEY,
+			recipient TEXT NOT NULL,
+			chat_jid TEXT NOT NULL,
+			message TEXT,
+			media_path TEXT,
+			client_ref TEXT,
+			agent TEXT,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			message_id TEXT,
+			error TEXT,
+			error_code TEXT,
+			created_at TIMESTAMP NOT NULL,
+			sent_at TIMESTAMP
+		)`,
+	},
+	{
+		name:   "outbox due index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (status, next_attempt_at)`,
+	},
+	{
+		name:   "outbox message index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_outbox_message ON outbox (message_id)`,
+	},
+	{
+		name: "message_receipts",
+		sqlite: `CREATE TABLE IF NOT EXISTS message_receipts (
+			message_id TEXT NOT NULL,
+			chat_jid TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			status TEXT NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			PRIMARY KEY (message_id, chat_jid, recipient, status)
+		)`,
+	},
+	{
+		name: "bot_pauses",
+		sqlite: `CREATE TABLE IF NOT EXISTS bot_pauses (
+			chat_jid TEXT PRIMARY KEY,
+			trigger TEXT NOT NULL,
+			keyword TEXT,
+			paused_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "api_keys",
+		sqlite: `CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)`,
+	},
+	{
+		name: "dashboard_logins",
+		sqlite: `CREATE TABLE IF NOT EXISTS dashboard_logins (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			ip TEXT NOT NULL,
+			user_agent TEXT,
+			country TEXT,
+			region TEXT,
+			city TEXT,
+			new_country BOOLEAN NOT NULL DEFAULT FALSE,
+			timestamp TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name:   "dashboard_logins lookup index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_dashboard_logins_email ON dashboard_logins (email, country)`,
+	},
+	{
+		name: "dashboard_users",
+		sqlite: `CREATE TABLE IF NOT EXISTS dashboard_users (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "dashboard_passkeys",
+		sqlite: `CREATE TABLE IF NOT EXISTS dashboard_passkeys (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP
+		)`,
+	},
+	{
+		name:   "dashboard_passkeys lookup index",
+		sqlite: `CREATE INDEX IF NOT EXISTS idx_dashboard_passkeys_user ON dashboard_passkeys (user_id)`,
+	},
+	{
+		name: "dashboard_sessions",
+		sqlite: `CREATE TABLE IF NOT EXISTS dashboard_sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			purpose TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		name: "contact_presence",
+		sqlite: `CREATE TABLE IF NOT EXISTS contact_presence (
+			jid TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			last_seen TIMESTAMP,
+			updated_at TIMESTAMP,
+			subscribed BOOLEAN NOT NULL DEFAULT FALSE
+		)`,
+	},
+}
+
+// ensureSchema applies additive schema changes and pending migrations to the message store, and
+// sets up its full-text index
+func (store *MessageStore) ensureSchema() error {
+	for _, table := range bridgeTables {
+		ddl := table.sqlite
+		if store.isPostgres && table.postgres != "" {
+			ddl = table.postgres
+		}
+		if _, err := store.db.Exec(ddl); err != nil {
+			return fmt.Errorf("failed to create %s table: %v", table.name, err)
+		}
+	}
+
+	for _, column := range messageColumns {
+		if err := store.ensureColumn("messages", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range assignmentColumns {
+		if err := store.ensureColumn("chat_assignments", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range messageIndexes {
+		if _, err := store.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create index: %v", err)
+		}
+	}
+
+	if err := store.migrate(); err != nil {
+		return err
+	}
+	return store.ensureFullTextSearch()
+}
+
+// ensureColumn adds a column to a table if it doesn't exist yet
+func (store *MessageStore) ensureColumn(table, column, definition string) error {
+	var columnExists bool
+	var err error
+
+	if store.isPostgres {
+		// Only this store's schema counts: an additional account's schema has the same tables
+		err = store.db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1
+				FROM information_schema.columns
+				WHERE table_schema = current_schema()
+				AND table_name = $1
+				AND column_name = $2
+			)
+		`, table, column).Scan(&columnExists)
+	} else {
+		var count int
+		err = store.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+		columnExists = count > 0
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to check if %s.%s column exists: %v", table, column, err)
+	}
+
+	if columnExists {
+		return nil
+	}
+
+	_, err = store.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %v", table, column, err)
+	}
+
+	return nil
+}
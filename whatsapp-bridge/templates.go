@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// embeddedTemplates are the pages of the web UI. Each templates/*.html file is a page, and
+// templates/partials/*.html holds the pieces every page shares, such as the theme.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// PageData is what a page template is executed with
+type PageData struct {
+	Theme    *UITheme
+	BasePath string
+	// Page holds the values of one page, e.g. the origin the QR embed reports to
+	Page interface{}
+}
+
+// templateFuncs are available to every template
+var templateFuncs = template.FuncMap{
+	// path prefixes a bridge path with BASE_PATH, e.g. {{path "/login"}}
+	"path": withBasePath,
+}
+
+// TemplateRegistry holds the parsed pages of the web UI, each with its own copy of the partials
+type TemplateRegistry struct {
+	pages map[string]*template.Template
+}
+
+// pageTemplates renders the web UI; set at startup by NewTemplateRegistryFromEnv
+var pageTemplates *TemplateRegistry
+
+// NewTemplateRegistryFromEnv parses the embedded templates. Files in UI_TEMPLATES_DIR replace
+// the embedded files of the same name, and new pages or partials there are added.
+func NewTemplateRegistryFromEnv() (*TemplateRegistry, error) {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	dir := os.Getenv("UI_TEMPLATES_DIR")
+	if dir == "" {
+		return NewTemplateRegistry(embedded)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("UI_TEMPLATES_DIR %s is not a directory", dir)
+	}
+	return NewTemplateRegistry(overlayFS{upper: os.DirFS(dir), lower: embedded})
+}
+
+// NewTemplateRegistry parses the pages in fsys. The partials are parsed before each page,
+// so a page can replace a shared piece with a {{define}} of its own.
+func NewTemplateRegistry(fsys fs.FS) (*TemplateRegistry, error) {
+	pages, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	partials, err := fs.Glob(fsys, "partials/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &TemplateRegistry{pages: make(map[string]*template.Template)}
+	for _, page := range pages {
+		tmpl := template.New(page).Funcs(templateFuncs)
+		if len(partials) > 0 {
+			if tmpl, err = tmpl.ParseFS(fsys, partials...); err != nil {
+				return nil, fmt.Errorf("failed to parse template partials: %v", err)
+			}
+		}
+		if tmpl, err = tmpl.ParseFS(fsys, page); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %v", page, err)
+		}
+		registry.pages[strings.TrimSuffix(page, ".html")] = tmpl
+	}
+	return registry, nil
+}
+
+// Render executes a page with the current theme and writes it. The page is rendered into
+// a buffer first, so a template error is a clean 500 rather than half a page.
+func (r *TemplateRegistry) Render(w http.ResponseWriter, name string, page interface{}) {
+	tmpl := r.pages[name]
+	if tmpl == nil {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, PageData{Theme: uiTheme, BasePath: basePath, Page: page}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render page: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// overlayFS serves files from upper where they exist and from lower otherwise, so a
+// directory of custom templates only needs the files it changes
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if file, err := o.upper.Open(name); err == nil {
+		return file, nil
+	}
+	return o.lower.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	if lowerErr != nil && upperErr != nil {
+		return nil, lowerErr
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	for _, entry := range lower {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		entries[entry.Name()] = entry
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
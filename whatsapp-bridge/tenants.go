@@ -0,0 +1,432 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+var (
+	// tenantIDPattern keeps tenant IDs usable in URLs
+	tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+	// usageKeyPattern matches the key IDs reported by /api/v1/usage
+	usageKeyPattern = regexp.MustCompile(`^key_[0-9a-f]{16}$`)
+)
+
+// maxTenantMembers caps the keys and users of a tenant
+const maxTenantMembers = 100
+
+// Tenant is a customer sharing the bridge, identified by its API keys.
+// KeyIDs are the key_ IDs shown by /api/v1/usage, so keys are never stored.
+type Tenant struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	KeyIDs          []string  `json:"key_ids"`
+	Users           []string  `json:"users"`
+	Suspended       bool      `json:"suspended"`
+	SuspendedReason string    `json:"suspended_reason,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// TenantStatus is a tenant with its connection status and usage this month
+type TenantStatus struct {
+	Tenant
+	Connected bool             `json:"connected"`
+	Period    string           `json:"period"`
+	Usage     map[string]int64 `json:"usage"`
+}
+
+// TenantRegistry keeps tenants in memory so every API request can be checked for suspension
+type TenantRegistry struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	byKey        map[string]*Tenant
+	mutex        sync.RWMutex
+}
+
+// tenantRegistry is set once the message store is open
+var tenantRegistry *TenantRegistry
+
+// NewTenantRegistry loads the tenants from the message store
+func NewTenantRegistry(messageStore *MessageStore, logger waLog.Logger) (*TenantRegistry, error) {
+	registry := &TenantRegistry{messageStore: messageStore, logger: logger}
+	if err := registry.reload(); err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %v", err)
+	}
+	return registry, nil
+}
+
+// reload rebuilds the key index after a change
+func (t *TenantRegistry) reload() error {
+	tenants, err := t.messageStore.ListTenants()
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*Tenant)
+	for i := range tenants {
+		for _, keyID := range tenants[i].KeyIDs {
+			byKey[keyID] = &tenants[i]
+		}
+	}
+
+	t.mutex.Lock()
+	t.byKey = byKey
+	t.mutex.Unlock()
+	return nil
+}
+
+// ForRequest returns the tenant of the request's API key, or nil
+func (t *TenantRegistry) ForRequest(r *http.Request) *Tenant {
+	if t == nil {
+		return nil
+	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.byKey[usageSubject(r)]
+}
+
+// Save validates and stores a tenant. Each key belongs to at most one tenant.
+func (t *TenantRegistry) Save(tenant *Tenant) error {
+	t.mutex.RLock()
+	for _, keyID := range tenant.KeyIDs {
+		if owner, ok := t.byKey[keyID]; ok && owner.ID != tenant.ID {
+			t.mutex.RUnlock()
+			return fmt.Errorf("key %s already belongs to tenant %s", keyID, owner.ID)
+		}
+	}
+	t.mutex.RUnlock()
+
+	if err := t.messageStore.SaveTenant(tenant); err != nil {
+		return err
+	}
+	return t.reload()
+}
+
+// Delete removes a tenant; its keys go back to being unassigned
+func (t *TenantRegistry) Delete(id string) (bool, error) {
+	deleted, err := t.messageStore.DeleteTenant(id)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return true, t.reload()
+}
+
+// tenantMiddleware refuses API requests from keys of suspended tenants. The admin
+// API stays open so a suspension can always be lifted.
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(apiRoute(r), "/admin/") {
+			if tenant := tenantRegistry.ForRequest(r); tenant != nil && tenant.Suspended {
+				http.Error(w, fmt.Sprintf("Tenant %s is suspended", tenant.ID), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate checks a tenant from an API request
+func (tenant *Tenant) validate() error {
+	if !tenantIDPattern.MatchString(tenant.ID) {
+		return fmt.Errorf("id must be 1-63 lowercase letters, digits or dashes")
+	}
+	if tenant.Name == "" || len(tenant.Name) > 200 {
+		return fmt.Errorf("name is required and must be at most 200 characters")
+	}
+	if len(tenant.KeyIDs) > maxTenantMembers || len(tenant.Users) > maxTenantMembers {
+		return fmt.Errorf("a tenant can have at most %d keys and %d users", maxTenantMembers, maxTenantMembers)
+	}
+	for _, keyID := range tenant.KeyIDs {
+		if !usageKeyPattern.MatchString(keyID) {
+			return fmt.Errorf("invalid key ID %q; use the key_ IDs reported by /api/v1/usage", keyID)
+		}
+	}
+	for _, user := range tenant.Users {
+		if !strings.Contains(user, "@") || len(user) > 254 {
+			return fmt.Errorf("invalid user %q; users are dashboard email addresses", user)
+		}
+	}
+	if len(tenant.SuspendedReason) > 500 {
+		return fmt.Errorf("suspended_reason must be at most 500 characters")
+	}
+	return nil
+}
+
+// SaveTenant inserts or replaces a tenant
+func (store *MessageStore) SaveTenant(tenant *Tenant) error {
+	keyIDs, err := json.Marshal(tenant.KeyIDs)
+	if err != nil {
+		return err
+	}
+	users, err := json.Marshal(tenant.Users)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO tenants (id, name, key_ids, users, suspended, suspended_reason, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, key_ids = excluded.key_ids, users = excluded.users,
+		suspended = excluded.suspended, suspended_reason = excluded.suspended_reason, updated_at = excluded.updated_at`
+	if store.isPostgres {
+		query = `INSERT INTO tenants (id, name, key_ids, users, suspended, suspended_reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET name = $2, key_ids = $3, users = $4, suspended = $5, suspended_reason = $6, updated_at = $8`
+	}
+	if _, err := store.db.Exec(query, tenant.ID, tenant.Name, string(keyIDs), string(users), tenant.Suspended,
+		tenant.SuspendedReason, tenant.CreatedAt, tenant.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save tenant: %v", err)
+	}
+	return nil
+}
+
+// DeleteTenant removes a tenant and reports whether it existed
+func (store *MessageStore) DeleteTenant(id string) (bool, error) {
+	query := "DELETE FROM tenants WHERE id = ?"
+	if store.isPostgres {
+		query = "DELETE FROM tenants WHERE id = $1"
+	}
+	result, err := store.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete tenant: %v", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// ListTenants returns all tenants ordered by ID
+func (store *MessageStore) ListTenants() ([]Tenant, error) {
+	rows, err := store.db.Query(`SELECT id, name, key_ids, users, suspended, suspended_reason, created_at, updated_at
+		FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		var tenant Tenant
+		var keyIDs, users, reason sql.NullString
+		if err := rows.Scan(&tenant.ID, &tenant.Name, &keyIDs, &users, &tenant.Suspended, &reason,
+			&tenant.CreatedAt, &tenant.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tenant.SuspendedReason = reason.String
+		tenant.KeyIDs, tenant.Users = []string{}, []string{}
+		if keyIDs.String != "" {
+			if err := json.Unmarshal([]byte(keyIDs.String), &tenant.KeyIDs); err != nil {
+				return nil, fmt.Errorf("invalid keys of tenant %s: %v", tenant.ID, err)
+			}
+		}
+		if users.String != "" {
+			if err := json.Unmarshal([]byte(users.String), &tenant.Users); err != nil {
+				return nil, fmt.Errorf("invalid users of tenant %s: %v", tenant.ID, err)
+			}
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// GetTenant returns a tenant, or nil if there is none with the ID
+func (store *MessageStore) GetTenant(id string) (*Tenant, error) {
+	tenants, err := store.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tenants {
+		if tenants[i].ID == id {
+			return &tenants[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// tenantStatus adds the connection status and this month's usage, summed over the tenant's keys
+func tenantStatus(client *whatsmeow.Client, messageStore *MessageStore, tenant Tenant, loc *time.Location) (TenantStatus, error) {
+	period := usagePeriod(time.Now())
+	status := TenantStatus{Tenant: tenant, Connected: client.IsConnected(), Period: period, Usage: map[string]int64{}}
+	for _, metric := range usageMetrics {
+		status.Usage[metric] = 0
+	}
+	for _, keyID := range tenant.KeyIDs {
+		usage, err := messageStore.GetUsage(keyID, period)
+		if err != nil {
+			return status, err
+		}
+		for metric, amount := range usage {
+			status.Usage[metric] += amount
+		}
+	}
+	status.CreatedAt = status.CreatedAt.In(loc)
+	status.UpdatedAt = status.UpdatedAt.In(loc)
+	return status, nil
+}
+
+// registerTenantRoutes registers /api/v1/admin/tenants
+func registerTenantRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	handleAPI("/admin/tenants", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			loc, err := requestLocation(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tenants, err := messageStore.ListTenants()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list tenants: %v", err), http.StatusInternalServerError)
+				return
+			}
+			statuses := []TenantStatus{}
+			for _, tenant := range tenants {
+				status, err := tenantStatus(client, messageStore, tenant, loc)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Failed to get tenant usage: %v", err), http.StatusInternalServerError)
+					return
+				}
+				statuses = append(statuses, status)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(statuses)
+
+		case http.MethodPost:
+			var tenant Tenant
+			if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if tenant.KeyIDs == nil {
+				tenant.KeyIDs = []string{}
+			}
+			if tenant.Users == nil {
+				tenant.Users = []string{}
+			}
+			if err := tenant.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			existing, err := messageStore.GetTenant(tenant.ID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to check tenant: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if existing != nil {
+				http.Error(w, "A tenant with this id already exists", http.StatusConflict)
+				return
+			}
+			tenant.CreatedAt = time.Now().UTC()
+			tenant.UpdatedAt = tenant.CreatedAt
+			if err := tenantRegistry.Save(&tenant); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(tenant)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// /admin/tenants/{id}, plus /suspend and /resume
+	handleAPI("/admin/tenants/", func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(apiRoute(r), "/admin/tenants/"), "/")
+
+		tenant, err := messageStore.GetTenant(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if tenant == nil {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+
+		case action == "" && r.Method == http.MethodPut:
+			var update Tenant
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			// Suspension has its own endpoints, so a PUT can't lift it by accident
+			tenant.Name = update.Name
+			tenant.KeyIDs, tenant.Users = update.KeyIDs, update.Users
+			if tenant.KeyIDs == nil {
+				tenant.KeyIDs = []string{}
+			}
+			if tenant.Users == nil {
+				tenant.Users = []string{}
+			}
+
+		case action == "" && r.Method == http.MethodDelete:
+			if _, err := tenantRegistry.Delete(id); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		case action == "suspend" && r.Method == http.MethodPost:
+			var req struct {
+				Reason string `json:"reason"`
+			}
+			// The reason is optional, so an empty body is fine
+			json.NewDecoder(r.Body).Decode(&req)
+			tenant.Suspended = true
+			tenant.SuspendedReason = req.Reason
+
+		case action == "resume" && r.Method == http.MethodPost:
+			tenant.Suspended = false
+			tenant.SuspendedReason = ""
+
+		case action != "" && action != "suspend" && action != "resume":
+			http.NotFound(w, r)
+			return
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			if err := tenant.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tenant.UpdatedAt = time.Now().UTC()
+			if err := tenantRegistry.Save(tenant); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if action != "" {
+				tenantRegistry.logger.Infof("Tenant %s: %s", tenant.ID, action)
+			}
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, err := tenantStatus(client, messageStore, *tenant, loc)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get tenant usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxThreadMessages caps the size of a thread returned by /messages/{id}/thread
+const maxThreadMessages = 500
+
+// threadMessageColumns selects the columns of an APIMessage
+const threadMessageColumns = "id, chat_jid, COALESCE(sender, ''), COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(system_event, ''), COALESCE(client_ref, ''), COALESCE(agent, ''), COALESCE(reply_to, ''), COALESCE(status, '')"
+
+// MessageThread is the response of /api/v1/messages/{id}/thread
+type MessageThread struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	// RootID is the earliest stored message of the quoted-reply chain
+	RootID string `json:"root_id"`
+	// Messages holds the root and every reply below it, oldest first
+	Messages []APIMessage `json:"messages"`
+	// Truncated is set when the thread had more than maxThreadMessages messages
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// quotedMessageID returns the ID of the message a reply quotes, if any
+func quotedMessageID(msg *waProto.Message) string {
+	if msg == nil {
+		return ""
+	}
+	var contextInfo *waProto.ContextInfo
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		contextInfo = msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		contextInfo = msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		contextInfo = msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		contextInfo = msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		contextInfo = msg.GetDocumentMessage().GetContextInfo()
+	}
+	return contextInfo.GetStanzaID()
+}
+
+// storedSenderJID returns the JID of the sender of a stored message, which only keeps the user part
+func storedSenderJID(client *whatsmeow.Client, chat types.JID, sender string, isFromMe bool) types.JID {
+	if isFromMe {
+		return client.Store.ID.ToNonAD()
+	}
+	if chat.Server == types.GroupServer {
+		return types.NewJID(sender, types.DefaultUserServer)
+	}
+	return chat
+}
+
+// quoteContextInfo returns the context that makes an outgoing message a reply to a stored message
+// of the chat, or nil if the chat has no such message. WhatsApp shows the quoted text above the
+// reply from the copy in the context, so the stored text or caption goes along.
+func quoteContextInfo(client *whatsmeow.Client, messageStore *MessageStore, chat types.JID, quotedID string) (*waProto.ContextInfo, error) {
+	if messageStore == nil {
+		return nil, nil
+	}
+	matches, err := messageStore.findMessage(quotedID, chat.String())
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	quoted := matches[0]
+	return &waProto.ContextInfo{
+		StanzaID:      proto.String(quoted.ID),
+		Participant:   proto.String(storedSenderJID(client, chat, quoted.Sender, quoted.IsFromMe).String()),
+		QuotedMessage: &waProto.Message{Conversation: proto.String(quoted.Content)},
+	}, nil
+}
+
+// SetReplyTo records the message a stored message quotes
+func (store *MessageStore) SetReplyTo(id, chatJID, replyTo string) error {
+	query := "UPDATE messages SET reply_to = ? WHERE id = ? AND chat_jid = ?"
+	if store.isPostgres {
+		query = "UPDATE messages SET reply_to = $1 WHERE id = $2 AND chat_jid = $3"
+	}
+	return store.captureMessage(id, chatJID, func() error {
+		_, err := store.db.Exec(query, replyTo, id, chatJID)
+		return err
+	})
+}
+
+// queryMessages reads APIMessages selected with threadMessageColumns
+func (store *MessageStore) queryMessages(query string, args ...interface{}) ([]APIMessage, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []APIMessage{}
+	for rows.Next() {
+		var msg APIMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.SystemEvent, &msg.ClientRef, &msg.Agent, &msg.ReplyTo, &msg.Status); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// findMessage returns the messages with an ID, in one chat if chatJID is set.
+// IDs are only unique per chat, so more than one can match.
+func (store *MessageStore) findMessage(id, chatJID string) ([]APIMessage, error) {
+	if chatJID != "" {
+		query := "SELECT " + threadMessageColumns + " FROM messages WHERE id = ? AND chat_jid = ?"
+		if store.isPostgres {
+			query = "SELECT " + threadMessageColumns + " FROM messages WHERE id = $1 AND chat_jid = $2"
+		}
+		return store.queryMessages(query, id, chatJID)
+	}
+	query := "SELECT " + threadMessageColumns + " FROM messages WHERE id = ?"
+	if store.isPostgres {
+		query = "SELECT " + threadMessageColumns + " FROM messages WHERE id = $1"
+	}
+	return store.queryMessages(query, id)
+}
+
+// repliesTo returns the messages of a chat quoting any of the given IDs
+func (store *MessageStore) repliesTo(chatJID string, ids []string) ([]APIMessage, error) {
+	placeholders := make([]string, len(ids))
+	args := []interface{}{chatJID}
+	for i, id := range ids {
+		placeholders[i] = "?"
+		if store.isPostgres {
+			placeholders[i] = fmt.Sprintf("$%d", i+2)
+		}
+		args = append(args, id)
+	}
+	query := "SELECT " + threadMessageColumns + " FROM messages WHERE chat_jid = ? AND reply_to IN (%s)"
+	if store.isPostgres {
+		query = "SELECT " + threadMessageColumns + " FROM messages WHERE chat_jid = $1 AND reply_to IN (%s)"
+	}
+	return store.queryMessages(fmt.Sprintf(query, strings.Join(placeholders, ", ")), args...)
+}
+
+// GetThread walks quoted replies up from a message to the root of its chain, then collects
+// every reply below the root. Quoted messages that were never stored end the walk upwards.
+func (store *MessageStore) GetThread(message APIMessage) (*MessageThread, error) {
+	thread := &MessageThread{ChatJID: message.ChatJID, MessageID: message.ID}
+	seen := map[string]bool{message.ID: true}
+
+	root := message
+	for root.ReplyTo != "" && !seen[root.ReplyTo] && len(seen) < maxThreadMessages {
+		parents, err := store.findMessage(root.ReplyTo, root.ChatJID)
+		if err != nil {
+			return nil, err
+		}
+		if len(parents) == 0 {
+			break
+		}
+		root = parents[0]
+		seen[root.ID] = true
+	}
+	thread.RootID = root.ID
+
+	// Breadth-first over replies, one query per level
+	thread.Messages = []APIMessage{root}
+	seen = map[string]bool{root.ID: true}
+	frontier := []string{root.ID}
+	for len(frontier) > 0 && !thread.Truncated {
+		var next []string
+		for start := 0; start < len(frontier); start += reactionLookupBatch {
+			end := min(start+reactionLookupBatch, len(frontier))
+			replies, err := store.repliesTo(root.ChatJID, frontier[start:end])
+			if err != nil {
+				return nil, err
+			}
+			for _, reply := range replies {
+				if seen[reply.ID] {
+					continue
+				}
+				if len(thread.Messages) >= maxThreadMessages {
+					thread.Truncated = true
+					break
+				}
+				seen[reply.ID] = true
+				thread.Messages = append(thread.Messages, reply)
+				next = append(next, reply.ID)
+			}
+		}
+		frontier = next
+	}
+
+	sort.SliceStable(thread.Messages, func(i, j int) bool {
+		return thread.Messages[i].Timestamp.Before(thread.Messages[j].Timestamp)
+	})
+	return thread, nil
+}
+
+// handleMessageThread serves /api/v1/messages/{id}/thread
+func handleMessageThread(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		route := strings.TrimPrefix(apiRoute(r), "/messages/")
+		id := strings.TrimSuffix(route, "/thread")
+		if id == route || id == "" || strings.Contains(id, "/") {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		matches, err := messageStore.findMessage(id, r.URL.Query().Get("chat_jid"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(matches) == 0 {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if len(matches) > 1 {
+			http.Error(w, "Message ID exists in several chats; pass chat_jid", http.StatusBadRequest)
+			return
+		}
+
+		thread, err := messageStore.GetThread(matches[0])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get thread: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := messageStore.attachReactions(thread.ChatJID, thread.Messages); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get reactions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range thread.Messages {
+			thread.Messages[i].Timestamp = thread.Messages[i].Timestamp.In(loc)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(thread)
+	}
+}
+
+// handleMessageRoute serves /api/v1/messages/{id}/thread, /status and /receipts
+func handleMessageRoute(messageStore *MessageStore) http.HandlerFunc {
+	thread, status, receipts := handleMessageThread(messageStore), handleMessageStatus(messageStore), handleMessageReceipts(messageStore)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/status"):
+			status(w, r)
+		case strings.HasSuffix(r.URL.Path, "/receipts"):
+			receipts(w, r)
+		default:
+			thread(w, r)
+		}
+	}
+}
+
+// registerThreadRoutes registers /api/v1/messages/{id}/thread, /status and /receipts. The unversioned
+// /api/messages/ prefix belongs to the legacy chat listing, which hands those requests here.
+func registerThreadRoutes(messageStore *MessageStore) {
+	http.HandleFunc(apiV1Prefix+"/messages/", handleMessageRoute(messageStore))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// displayLocation is the default timezone for timestamps in API responses and exports.
+// Timestamps are always stored in UTC; this only affects how they are rendered.
+var displayLocation = time.UTC
+
+// initTimezone loads the display timezone from the TIMEZONE environment variable
+func initTimezone() error {
+	name := os.Getenv("TIMEZONE")
+	if name == "" {
+		return nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid TIMEZONE %q: %v", name, err)
+	}
+	displayLocation = loc
+	return nil
+}
+
+// requestLocation returns the timezone requested with ?tz=, falling back to the configured default
+func requestLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		return displayLocation, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz parameter %q", name)
+	}
+	return loc, nil
+}
+
+// formatTimestamp renders a timestamp as ISO-8601 in the given timezone
+func formatTimestamp(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(time.RFC3339)
+}
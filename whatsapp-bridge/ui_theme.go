@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hexColorPattern matches the #rgb and #rrggbb colors UI_BRAND_COLOR accepts
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// UITheme is the look of the web UI, so it can match the portal it's embedded in
+type UITheme struct {
+	// BrandColor is used for buttons, links and the page background
+	BrandColor string
+	// BrandColorDark is the hover and gradient color, derived from BrandColor unless set
+	BrandColorDark string
+	// LogoURL replaces the emoji logo when set
+	LogoURL string
+	// Mode is the default of viewers who haven't picked one: light, dark or auto
+	Mode string
+}
+
+// uiTheme is the WhatsApp look until NewUIThemeFromEnv says otherwise
+var uiTheme = &UITheme{BrandColor: "#25D366", BrandColorDark: "#128C7E", Mode: "auto"}
+
+// NewUIThemeFromEnv reads the UI_* settings on top of the default theme
+func NewUIThemeFromEnv() (*UITheme, error) {
+	theme := *uiTheme
+
+	if color := strings.TrimSpace(os.Getenv("UI_BRAND_COLOR")); color != "" {
+		if !hexColorPattern.MatchString(color) {
+			return nil, fmt.Errorf("UI_BRAND_COLOR must be a hex color like #25D366, got %q", color)
+		}
+		theme.BrandColor = color
+		theme.BrandColorDark = darkenHexColor(color, 0.7)
+	}
+	if color := strings.TrimSpace(os.Getenv("UI_BRAND_COLOR_DARK")); color != "" {
+		if !hexColorPattern.MatchString(color) {
+			return nil, fmt.Errorf("UI_BRAND_COLOR_DARK must be a hex color like #128C7E, got %q", color)
+		}
+		theme.BrandColorDark = color
+	}
+
+	// Only web and same-origin URLs, so the setting can't smuggle in javascript: links
+	if logo := strings.TrimSpace(os.Getenv("UI_LOGO_URL")); logo != "" {
+		if !strings.HasPrefix(logo, "https://") && !strings.HasPrefix(logo, "http://") && !strings.HasPrefix(logo, "/") {
+			return nil, fmt.Errorf("UI_LOGO_URL must be an http(s) URL or an absolute path, got %q", logo)
+		}
+		theme.LogoURL = logo
+	}
+
+	if mode := strings.ToLower(strings.TrimSpace(os.Getenv("UI_THEME"))); mode != "" {
+		switch mode {
+		case "light", "dark", "auto":
+			theme.Mode = mode
+		default:
+			return nil, fmt.Errorf("UI_THEME must be light, dark or auto, got %q", mode)
+		}
+	}
+	return &theme, nil
+}
+
+// darkenHexColor scales each channel of a #rgb or #rrggbb color by factor
+func darkenHexColor(color string, factor float64) string {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	value, _ := strconv.ParseUint(hex, 16, 32)
+	r := float64(value>>16&0xff) * factor
+	g := float64(value>>8&0xff) * factor
+	b := float64(value&0xff) * factor
+	return fmt.Sprintf("#%02x%02x%02x", int(r), int(g), int(b))
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Usage metrics counted per API key and calendar month (UTC)
+const (
+	UsageMessagesSent       = "messages_sent"
+	UsageMediaUploadBytes   = "media_upload_bytes"
+	UsageMediaDownloadBytes = "media_download_bytes"
+)
+
+// usageMetrics lists the metrics in the order they are reported
+var usageMetrics = []string{UsageMessagesSent, UsageMediaUploadBytes, UsageMediaDownloadBytes}
+
+// anonymousUsageSubject counts requests made without an X-API-Key header
+const anonymousUsageSubject = "anonymous"
+
+// usagePeriodPattern matches the YYYY-MM periods usage is kept for
+var usagePeriodPattern = regexp.MustCompile(`^\d{4}-(0[1-9]|1[0-2])$`)
+
+// UsageQuota holds monthly limits; zero or missing means unlimited
+type UsageQuota map[string]int64
+
+// UsageQuotas is the USAGE_QUOTAS_FILE: a default quota and overrides per key ID
+type UsageQuotas struct {
+	Default UsageQuota            `json:"default"`
+	Keys    map[string]UsageQuota `json:"keys"`
+}
+
+// UsageReport is the usage of one key in one month
+type UsageReport struct {
+	Subject string           `json:"subject"`
+	Period  string           `json:"period"`
+	Usage   map[string]int64 `json:"usage"`
+	Quota   UsageQuota       `json:"quota,omitempty"`
+	// Cost is what the messages would have cost a paid provider, with MESSAGE_COSTS_FILE
+	Cost *UsageCost `json:"cost,omitempty"`
+	// WarmUp is the account-wide daily limit while a new number warms up
+	WarmUp *WarmUpStatus `json:"warm_up,omitempty"`
+}
+
+// UsageMeter counts messages and media bytes per API key and enforces quotas
+type UsageMeter struct {
+	messageStore *MessageStore
+	logger       waLog.Logger
+	quotas       UsageQuotas
+}
+
+// usageMeter is set once the message store is open
+var usageMeter *UsageMeter
+
+// NewUsageMeterFromEnv creates the meter, with quotas from USAGE_QUOTAS_FILE if set
+func NewUsageMeterFromEnv(messageStore *MessageStore, logger waLog.Logger) (*UsageMeter, error) {
+	meter := &UsageMeter{messageStore: messageStore, logger: logger}
+
+	path := os.Getenv("USAGE_QUOTAS_FILE")
+	if path == "" {
+		return meter, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage quotas: %v", err)
+	}
+	if err := json.Unmarshal(data, &meter.quotas); err != nil {
+		return nil, fmt.Errorf("invalid usage quotas in %s: %v", path, err)
+	}
+	quotas := append([]UsageQuota{meter.quotas.Default}, mapValues(meter.quotas.Keys)...)
+	for _, quota := range quotas {
+		for metric, limit := range quota {
+			if !isUsageMetric(metric) {
+				return nil, fmt.Errorf("unknown usage metric %q in %s", metric, path)
+			}
+			if limit < 0 {
+				return nil, fmt.Errorf("quota for %s must not be negative", metric)
+			}
+		}
+	}
+	logger.Infof("Loaded usage quotas for %d API keys", len(meter.quotas.Keys))
+	return meter, nil
+}
+
+// mapValues returns the quotas of a key map
+func mapValues(keys map[string]UsageQuota) []UsageQuota {
+	values := make([]UsageQuota, 0, len(keys))
+	for _, quota := range keys {
+		values = append(values, quota)
+	}
+	return values
+}
+
+// isUsageMetric reports whether a name is one of the counted metrics
+func isUsageMetric(name string) bool {
+	for _, metric := range usageMetrics {
+		if metric == name {
+			return true
+		}
+	}
+	return false
+}
+
+// usageSubject identifies the caller by a hash of its X-API-Key, so keys never reach the database.
+// For keys issued by the bridge this is the key's ID.
+func usageSubject(r *http.Request) string {
+	key := requestAPIKey(r)
+	if key == "" {
+		return anonymousUsageSubject
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key_" + hex.EncodeToString(sum[:8])
+}
+
+// usagePeriod returns the month a usage count belongs to
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// quotaFor returns the quota of a subject
+func (m *UsageMeter) quotaFor(subject string) UsageQuota {
+	if quota, ok := m.quotas.Keys[subject]; ok {
+		return quota
+	}
+	return m.quotas.Default
+}
+
+// Allow checks the caller's quota before a request that adds to the given metrics.
+// It returns a message for the 429 response when a quota is used up.
+func (m *UsageMeter) Allow(r *http.Request, metrics ...string) (bool, string) {
+	if m == nil {
+		return true, ""
+	}
+	subject := usageSubject(r)
+	quota := m.quotaFor(subject)
+
+	var usage map[string]int64
+	for _, metric := range metrics {
+		limit := quota[metric]
+		if limit == 0 {
+			continue
+		}
+		if usage == nil {
+			var err error
+			if usage, err = m.messageStore.GetUsage(subject, usagePeriod(time.Now())); err != nil {
+				// Don't block traffic because the counters can't be read
+				m.logger.Warnf("Failed to check usage quota: %v", err)
+				return true, ""
+			}
+		}
+		if usage[metric] >= limit {
+			return false, fmt.Sprintf("Monthly quota of %d %s used up", limit, metric)
+		}
+	}
+	return true, ""
+}
+
+// Record adds to the caller's usage for the current month, and for the day for billing summaries
+func (m *UsageMeter) Record(r *http.Request, metric string, amount int64) {
+	if m == nil || amount <= 0 {
+		return
+	}
+	subject, now := usageSubject(r), time.Now().UTC()
+	if err := m.messageStore.AddUsage(subject, usagePeriod(now), metric, amount); err != nil {
+		m.logger.Warnf("Failed to record %s usage: %v", metric, err)
+	}
+	if err := m.messageStore.AddDailyUsage(subject, now.Format("2006-01-02"), metric, amount); err != nil {
+		m.logger.Warnf("Failed to record daily %s usage: %v", metric, err)
+	}
+}
+
+// RecordSend counts a sent message, its simulated cost and the size of its media, if any
+func (m *UsageMeter) RecordSend(r *http.Request, recipient, mediaPath string) {
+	m.Record(r, UsageMessagesSent, 1)
+	m.RecordCost(r, recipient)
+	if mediaPath == "" {
+		return
+	}
+	if info, err := os.Stat(mediaPath); err == nil {
+		m.Record(r, UsageMediaUploadBytes, info.Size())
+	}
+}
+
+// meteredSend rejects sends with 429 once the caller's message or upload quota, or the
+// account's daily warm-up limit, is used up
+func meteredSend(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, message := usageMeter.Allow(r, UsageMessagesSent, UsageMediaUploadBytes); !ok {
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		if limitedByWarmUp(w) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// countingResponseWriter counts the body bytes written to the client
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (c *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.written += int64(n)
+	return n, err
+}
+
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// AddUsage adds an amount to a usage counter
+func (store *MessageStore) AddUsage(subject, period, metric string, amount int64) error {
+	query := `INSERT INTO api_usage (subject, period, metric, amount) VALUES (?, ?, ?, ?)
+		ON CONFLICT (subject, period, metric) DO UPDATE SET amount = api_usage.amount + excluded.amount`
+	if store.isPostgres {
+		query = `INSERT INTO api_usage (subject, period, metric, amount) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (subject, period, metric) DO UPDATE SET amount = api_usage.amount + EXCLUDED.amount`
+	}
+	_, err := store.db.Exec(query, subject, period, metric, amount)
+	return err
+}
+
+// GetUsage returns the counters of a subject for a month
+func (store *MessageStore) GetUsage(subject, period string) (map[string]int64, error) {
+	query := "SELECT metric, amount FROM api_usage WHERE subject = ? AND period = ?"
+	if store.isPostgres {
+		query = "SELECT metric, amount FROM api_usage WHERE subject = $1 AND period = $2"
+	}
+	rows, err := store.db.Query(query, subject, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64, len(usageMetrics))
+	for _, metric := range usageMetrics {
+		usage[metric] = 0
+	}
+	for rows.Next() {
+		var metric string
+		var amount int64
+		if err := rows.Scan(&metric, &amount); err != nil {
+			return nil, err
+		}
+		usage[metric] = amount
+	}
+	return usage, rows.Err()
+}
+
+// ListUsageSubjects returns the subjects with usage in a month
+func (store *MessageStore) ListUsageSubjects(period string) ([]string, error) {
+	query := "SELECT DISTINCT subject FROM api_usage WHERE period = ?"
+	if store.isPostgres {
+		query = "SELECT DISTINCT subject FROM api_usage WHERE period = $1"
+	}
+	rows, err := store.db.Query(query, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subjects := []string{}
+	for rows.Next() {
+		var subject string
+		if err := rows.Scan(&subject); err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects, rows.Err()
+}
+
+// report returns the usage and quota of a subject
+func (m *UsageMeter) report(subject, period string) (UsageReport, error) {
+	usage, err := m.messageStore.GetUsage(subject, period)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	report := UsageReport{Subject: subject, Period: period, Usage: usage, Quota: m.quotaFor(subject)}
+	if messageCosts != nil {
+		if report.Cost, err = m.messageStore.GetMessageCosts(subject, period, messageCosts.Currency); err != nil {
+			return UsageReport{}, err
+		}
+	}
+	return report, nil
+}
+
+// registerUsageRoutes registers /api/v1/usage
+func registerUsageRoutes() {
+	handleAPI("/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = usagePeriod(time.Now())
+		}
+		if !usagePeriodPattern.MatchString(period) {
+			http.Error(w, "period must be a month such as 2025-01", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		// ?all=true lists every key, e.g. for billing resellers' customers
+		if r.URL.Query().Get("all") == "true" {
+			subjects, err := usageMeter.messageStore.ListUsageSubjects(period)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get usage: %v", err), http.StatusInternalServerError)
+				return
+			}
+			reports := []UsageReport{}
+			for _, subject := range subjects {
+				report, err := usageMeter.report(subject, period)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Failed to get usage: %v", err), http.StatusInternalServerError)
+					return
+				}
+				reports = append(reports, report)
+			}
+			json.NewEncoder(w).Encode(reports)
+			return
+		}
+
+		report, err := usageMeter.report(usageSubject(r), period)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if report.WarmUp, err = warmUp.Status(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get warm-up status: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version and commit describe the release the binary was built from, e.g.
+// go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD)".
+// When they're not set, the module version and the VCS stamp of the build are used.
+var (
+	version = ""
+	commit  = ""
+)
+
+// defaultUpdateCheckURL is the latest release of the upstream repository
+const defaultUpdateCheckURL = "https://api.github.com/repos/alexechoi/whatsapp-bridge/releases/latest"
+
+// updateCheckRetry is how long a failed update check is kept before trying again
+const updateCheckRetry = 10 * time.Minute
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version          string `json:"version"`
+	Commit           string `json:"commit,omitempty"`
+	CommitTime       string `json:"commit_time,omitempty"`
+	Modified         bool   `json:"modified"`
+	GoVersion        string `json:"go_version"`
+	WhatsmeowVersion string `json:"whatsmeow_version,omitempty"`
+	OS               string `json:"os"`
+	Arch             string `json:"arch"`
+}
+
+// readBuildInfo collects the version of the binary and its WhatsApp library
+func readBuildInfo() BuildInfo {
+	build := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if build.Version == "" && info.Main.Version != "(devel)" {
+			build.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if build.Commit == "" {
+					build.Commit = setting.Value
+				}
+			case "vcs.time":
+				build.CommitTime = setting.Value
+			case "vcs.modified":
+				build.Modified = setting.Value == "true"
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "go.mau.fi/whatsmeow" {
+				build.WhatsmeowVersion = dep.Version
+				if dep.Replace != nil {
+					build.WhatsmeowVersion = dep.Replace.Version
+				}
+			}
+		}
+	}
+	if build.Version == "" {
+		build.Version = "dev"
+	}
+	return build
+}
+
+// String is the one-line version printed by -version and at startup
+func (b BuildInfo) String() string {
+	description := "WhatsApp Bridge " + b.Version
+	if b.Commit != "" {
+		short := b.Commit
+		if len(short) > 12 {
+			short = short[:12]
+		}
+		description += " (" + short
+		if b.Modified {
+			description += ", modified"
+		}
+		description += ")"
+	}
+	return description + ", whatsmeow " + b.WhatsmeowVersion + ", " + b.GoVersion
+}
+
+// UpdateStatus is the result of the last check for a newer release
+type UpdateStatus struct {
+	LatestVersion   string    `json:"latest_version,omitempty"`
+	UpdateAvailable bool      `json:"update_available"`
+	ReleaseURL      string    `json:"release_url,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// UpdateChecker looks up the latest release, at most once per interval
+type UpdateChecker struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	last     *UpdateStatus
+	mutex    sync.Mutex
+}
+
+// updateChecker is nil unless UPDATE_CHECK is enabled
+var updateChecker *UpdateChecker
+
+// NewUpdateCheckerFromEnv returns nil when UPDATE_CHECK is off, so nothing leaves the host by default
+func NewUpdateCheckerFromEnv() (*UpdateChecker, error) {
+	if !getEnvBool("UPDATE_CHECK", false) {
+		return nil, nil
+	}
+
+	checkURL := os.Getenv("UPDATE_CHECK_URL")
+	if checkURL == "" {
+		checkURL = defaultUpdateCheckURL
+	}
+	if parsed, err := url.Parse(checkURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("UPDATE_CHECK_URL %q is not an http(s) URL", checkURL)
+	}
+	hours := getEnvInt("UPDATE_CHECK_INTERVAL_HOURS", 24)
+	if hours < 1 {
+		return nil, fmt.Errorf("UPDATE_CHECK_INTERVAL_HOURS must be at least 1")
+	}
+
+	return &UpdateChecker{
+		url:      checkURL,
+		interval: time.Duration(hours) * time.Hour,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Status returns the cached result, checking again once it's older than the interval
+func (c *UpdateChecker) Status(current string) UpdateStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.last != nil {
+		maxAge := c.interval
+		if c.last.Error != "" {
+			maxAge = updateCheckRetry
+		}
+		if time.Since(c.last.CheckedAt) < maxAge {
+			return *c.last
+		}
+	}
+
+	status := UpdateStatus{CheckedAt: time.Now().UTC()}
+	if latest, releaseURL, err := c.fetchLatest(); err != nil {
+		status.Error = err.Error()
+	} else {
+		status.LatestVersion = latest
+		status.ReleaseURL = releaseURL
+		status.UpdateAvailable = compareVersions(latest, current) > 0
+	}
+	c.last = &status
+	return status
+}
+
+// fetchLatest reads the tag and page of the latest release, in the format of GitHub's releases API
+func (c *UpdateChecker) fetchLatest() (string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "whatsapp-bridge/"+readBuildInfo().Version)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("update check failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("update check failed with status %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", "", fmt.Errorf("failed to parse release: %v", err)
+	}
+	if release.TagName == "" {
+		return "", "", fmt.Errorf("release has no tag_name")
+	}
+	return release.TagName, release.HTMLURL, nil
+}
+
+// parseVersion reads a vMAJOR.MINOR.PATCH version, ignoring any pre-release or build suffix
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// compareVersions returns 1 when a is newer than b, -1 when older, and 0 when they're equal
+// or either isn't a release version (e.g. "dev"), so untagged builds never claim an update
+func compareVersions(a, b string) int {
+	av, okA := parseVersion(a)
+	bv, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0
+	}
+	for i := range av {
+		if av[i] != bv[i] {
+			if av[i] > bv[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// VersionResponse is returned by /api/v1/version
+type VersionResponse struct {
+	BuildInfo
+	StartedAt time.Time `json:"started_at"`
+	// SchemaVersion is the last migration of the message store, applied on startup
+	SchemaVersion int           `json:"schema_version"`
+	Update        *UpdateStatus `json:"update,omitempty"`
+}
+
+// registerVersionRoutes registers /api/v1/version
+func registerVersionRoutes() {
+	handleAPI("/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response := VersionResponse{BuildInfo: readBuildInfo(), StartedAt: processStart.UTC(), SchemaVersion: latestSchemaVersion()}
+		if updateChecker != nil {
+			status := updateChecker.Status(response.Version)
+			response.Update = &status
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
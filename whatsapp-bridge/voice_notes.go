@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// maxVoiceNoteBytes is far above what an hour of Opus speech takes
+const maxVoiceNoteBytes = 16 << 20
+
+// Matroska element IDs needed to pull Opus packets out of a WebM recording
+const (
+	ebmlSegment      = 0x18538067
+	ebmlCluster      = 0x1F43B675
+	ebmlTracks       = 0x1654AE6B
+	ebmlTrackEntry   = 0xAE
+	ebmlTrackNumber  = 0xD7
+	ebmlCodecID      = 0x86
+	ebmlCodecPrivate = 0x63A2
+	ebmlCodecDelay   = 0x56AA
+	ebmlAudio        = 0xE1
+	ebmlChannels     = 0x9F
+	ebmlBlockGroup   = 0xA0
+	ebmlBlock        = 0xA1
+	ebmlSimpleBlock  = 0xA3
+)
+
+// ebmlUnknownSize marks a master element written before its length was known, as live recorders do
+const ebmlUnknownSize = -1
+
+// readEBMLVint reads a variable-length integer; keepMarker keeps the length bit, as element IDs do
+func readEBMLVint(data []byte, keepMarker bool) (value int64, length int, err error) {
+	if len(data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	length = 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+		if length > 8 {
+			return 0, 0, fmt.Errorf("invalid EBML variable-length integer")
+		}
+	}
+	if len(data) < length {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	first := data[0]
+	if !keepMarker {
+		first &= 0xFF >> length
+	}
+	value = int64(first)
+	allOnes := first == 0xFF>>length
+	for _, b := range data[1:length] {
+		value = value<<8 | int64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return ebmlUnknownSize, length, nil
+	}
+	return value, length, nil
+}
+
+// webmOpus is the Opus track of a WebM recording
+type webmOpus struct {
+	head       []byte
+	channels   byte
+	codecDelay uint64
+	track      int64
+	packets    [][]byte
+}
+
+// parseWebMOpus collects the Opus packets of the first Opus track. Master elements are entered
+// rather than skipped, so the unknown sizes browsers write for Segment and Cluster don't matter.
+func parseWebMOpus(data []byte) (*webmOpus, error) {
+	opus := &webmOpus{channels: 1, track: -1}
+	var entryTrack int64
+	var entryCodec string
+	var entryHead []byte
+	var entryDelay uint64
+	var entryChannels byte = 1
+
+	// A track entry ends where the next element outside it starts; settle it then
+	finishEntry := func() {
+		if entryCodec == "A_OPUS" && opus.track < 0 {
+			opus.track, opus.head, opus.codecDelay, opus.channels = entryTrack, entryHead, entryDelay, entryChannels
+		}
+		entryCodec = ""
+	}
+
+elements:
+	for pos := 0; pos < len(data); {
+		id, idLength, err := readEBMLVint(data[pos:], true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WebM element at byte %d: %v", pos, err)
+		}
+		size, sizeLength, err := readEBMLVint(data[pos+idLength:], false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WebM element size at byte %d: %v", pos, err)
+		}
+		pos += idLength + sizeLength
+
+		switch id {
+		case ebmlSegment, ebmlCluster, ebmlTracks, ebmlBlockGroup, ebmlAudio:
+			continue
+		case ebmlTrackEntry:
+			finishEntry()
+			continue
+		}
+		if size == ebmlUnknownSize || pos+int(size) > len(data) {
+			// A recording cut off mid-element still has every packet before it
+			if id == ebmlSimpleBlock || id == ebmlBlock {
+				break elements
+			}
+			return nil, fmt.Errorf("truncated WebM element %x", id)
+		}
+		payload := data[pos : pos+int(size)]
+		pos += int(size)
+
+		switch id {
+		case ebmlTrackNumber:
+			entryTrack = int64(readEBMLUint(payload))
+		case ebmlCodecID:
+			entryCodec = string(payload)
+		case ebmlCodecPrivate:
+			entryHead = payload
+		case ebmlCodecDelay:
+			entryDelay = readEBMLUint(payload)
+		case ebmlChannels:
+			entryChannels = byte(readEBMLUint(payload))
+		case ebmlSimpleBlock, ebmlBlock:
+			finishEntry()
+			track, trackLength, err := readEBMLVint(payload, false)
+			if err != nil || len(payload) < trackLength+3 {
+				return nil, fmt.Errorf("invalid WebM block")
+			}
+			if track != opus.track {
+				continue
+			}
+			// Recorders write one packet per block; laced blocks would need splitting
+			if payload[trackLength+2]&0x06 != 0 {
+				return nil, fmt.Errorf("laced WebM blocks are not supported")
+			}
+			if packet := payload[trackLength+3:]; len(packet) > 0 {
+				opus.packets = append(opus.packets, packet)
+			}
+		}
+	}
+	finishEntry()
+
+	if opus.track < 0 {
+		return nil, fmt.Errorf("no Opus audio track in the recording")
+	}
+	if len(opus.packets) == 0 {
+		return nil, fmt.Errorf("the recording is empty")
+	}
+	return opus, nil
+}
+
+// readEBMLUint reads a big-endian unsigned integer element
+func readEBMLUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// opusPacketSamples returns the duration of an Opus packet in 48 kHz samples, from its TOC byte (RFC 6716 3.1)
+func opusPacketSamples(packet []byte) uint64 {
+	toc := packet[0]
+	config := toc >> 3
+	var frameSamples uint64
+	switch {
+	case config < 12:
+		frameSamples = []uint64{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		frameSamples = []uint64{480, 960}[config%2]
+	default:
+		frameSamples = []uint64{120, 240, 480, 960}[config%4]
+	}
+
+	frames := uint64(1)
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) > 1 {
+			frames = uint64(packet[1] & 0x3F)
+		}
+	}
+	return frameSamples * frames
+}
+
+// oggCRCTable is the CRC-32 of Ogg pages: polynomial 0x04C11DB7, unreflected, no final XOR
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggWriter writes Ogg pages of a single logical stream
+type oggWriter struct {
+	out      bytes.Buffer
+	serial   uint32
+	sequence uint32
+}
+
+// writePage writes packets that fit in one page (at most 255 lacing values)
+func (w *oggWriter) writePage(packets [][]byte, granule uint64, headerType byte) {
+	var lacing []byte
+	var body []byte
+	for _, packet := range packets {
+		for remaining := len(packet); ; remaining -= 255 {
+			if remaining < 255 {
+				lacing = append(lacing, byte(remaining))
+				break
+			}
+			lacing = append(lacing, 255)
+		}
+		body = append(body, packet...)
+	}
+
+	page := make([]byte, 27, 27+len(lacing)+len(body))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.sequence)
+	page[26] = byte(len(lacing))
+	page = append(append(page, lacing...), body...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+
+	w.out.Write(page)
+	w.sequence++
+}
+
+// oggLacingValues is how many lacing values a packet takes
+func oggLacingValues(packet []byte) int {
+	return len(packet)/255 + 1
+}
+
+// remuxWebMToOgg repackages the Opus audio of a WebM recording as Ogg Opus, which is what
+// WhatsApp plays as a voice note. Chrome and Edge can only record WebM; nothing is re-encoded.
+func remuxWebMToOgg(data []byte) ([]byte, error) {
+	opus, err := parseWebMOpus(data)
+	if err != nil {
+		return nil, err
+	}
+
+	head := opus.head
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		// Build the identification header (RFC 7845 5.1) when the track doesn't carry one
+		preSkip := opus.codecDelay * 48000 / uint64(time.Second)
+		head = make([]byte, 19)
+		copy(head, "OpusHead")
+		head[8] = 1
+		head[9] = opus.channels
+		binary.LittleEndian.PutUint16(head[10:], uint16(preSkip))
+		binary.LittleEndian.PutUint32(head[12:], 48000)
+	}
+	vendor := "whatsapp-bridge"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+
+	w := &oggWriter{serial: uint32(time.Now().UnixNano())}
+	w.writePage([][]byte{head}, 0, 0x02)
+	w.writePage([][]byte{tags}, 0, 0)
+
+	var granule uint64
+	var page [][]byte
+	lacing := 0
+	for i, packet := range opus.packets {
+		if lacing+oggLacingValues(packet) > 255 {
+			w.writePage(page, granule, 0)
+			page, lacing = nil, 0
+		}
+		page = append(page, packet)
+		lacing += oggLacingValues(packet)
+		granule += opusPacketSamples(packet)
+		if i == len(opus.packets)-1 {
+			w.writePage(page, granule, 0x04)
+		}
+	}
+	return w.out.Bytes(), nil
+}
+
+// registerVoiceNoteRoutes registers /api/v1/send/voice, which sends a recording as a voice note
+func registerVoiceNoteRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	handleAPI("/send/voice", leaderOnly(refuseDuringMaintenance(sendUnlocked(meteredSend(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recipient := r.URL.Query().Get("recipient")
+		if recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		opts := SendOptions{ClientRef: r.URL.Query().Get("client_ref"), Agent: r.URL.Query().Get("agent"), APIKey: usageSubject(r),
+			QuotedMessageID: r.URL.Query().Get("quoted_message_id")}
+		if len(opts.ClientRef) > maxClientRefLen {
+			http.Error(w, fmt.Sprintf("client_ref must be at most %d characters", maxClientRefLen), http.StatusBadRequest)
+			return
+		}
+		if len(opts.Agent) > maxAgentLen {
+			http.Error(w, fmt.Sprintf("agent must be at most %d characters", maxAgentLen), http.StatusBadRequest)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxVoiceNoteBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Voice notes must be at most %d MB", maxVoiceNoteBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Ogg Opus is sent as is; WebM recordings are repackaged first
+		contentType := strings.ToLower(r.Header.Get("Content-Type"))
+		switch {
+		case bytes.HasPrefix(data, []byte("OggS")):
+		case strings.HasPrefix(contentType, "audio/webm") || bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+			if data, err = remuxWebMToOgg(data); err != nil {
+				http.Error(w, fmt.Sprintf("Unsupported recording: %v", err), http.StatusUnsupportedMediaType)
+				return
+			}
+		default:
+			http.Error(w, "Voice notes must be Ogg Opus or WebM Opus audio", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// The file name is what the message is stored under, like incoming voice notes
+		dir, err := os.MkdirTemp("", "voice-note-")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to store voice note: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+		mediaPath := filepath.Join(dir, "voice_"+time.Now().Format("20060102_150405")+".ogg")
+		if err := os.WriteFile(mediaPath, data, 0600); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to store voice note: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		success, message, messageID, code := sendWhatsAppMessage(client, recipient, "", mediaPath, opts, messageStore)
+		if success {
+			usageMeter.RecordSend(r, recipient, mediaPath)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   success,
+			Message:   message,
+			MessageID: messageID,
+			ClientRef: opts.ClientRef,
+			Agent:     opts.Agent,
+		}.withError(code))
+	})))))
+}
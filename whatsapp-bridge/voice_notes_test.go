@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// ebmlElement encodes an element with an 8-byte size, which every reader must accept
+func ebmlElement(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(ebmlID(id), size...), body...)
+}
+
+// ebmlUnknown starts a master element of unknown size, as browsers write Segment and Cluster
+func ebmlUnknown(id uint32, payload ...[]byte) []byte {
+	return append(append(ebmlID(id), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF), bytes.Join(payload, nil)...)
+}
+
+func ebmlID(id uint32) []byte {
+	encoded := binary.BigEndian.AppendUint32(nil, id)
+	for len(encoded) > 1 && encoded[0] == 0 {
+		encoded = encoded[1:]
+	}
+	return encoded
+}
+
+// simpleBlock is a SimpleBlock of one packet on a track below 127
+func simpleBlock(track byte, flags byte, packet []byte) []byte {
+	return ebmlElement(ebmlSimpleBlock, []byte{0x80 | track, 0, 0, flags}, packet)
+}
+
+func trackEntry(track byte, codec string, extra ...[]byte) []byte {
+	return ebmlElement(ebmlTrackEntry, append([][]byte{
+		ebmlElement(ebmlTrackNumber, []byte{track}),
+		ebmlElement(ebmlCodecID, []byte(codec)),
+	}, extra...)...)
+}
+
+// webmRecording is a recording laid out the way Chrome's MediaRecorder writes it
+func webmRecording(tracks []byte, blocks ...[]byte) []byte {
+	header := ebmlElement(0x1A45DFA3, ebmlElement(0x4282, []byte("webm")))
+	cluster := ebmlUnknown(ebmlCluster, append([][]byte{ebmlElement(0xE7, []byte{0})}, blocks...)...)
+	return append(header, ebmlUnknown(ebmlSegment, ebmlElement(ebmlTracks, tracks), cluster)...)
+}
+
+// stereoOpusTrack has no CodecPrivate, so the remuxer builds OpusHead from the codec delay
+var stereoOpusTrack = trackEntry(1, "A_OPUS",
+	ebmlElement(ebmlCodecDelay, binary.BigEndian.AppendUint32(nil, 6500000)),
+	ebmlElement(ebmlAudio, ebmlElement(ebmlChannels, []byte{2})))
+
+func TestReadEBMLVint(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		keepMarker bool
+		value      int64
+		length     int
+		err        bool
+	}{
+		{"one byte size", []byte{0x81}, false, 1, 1, false},
+		{"one byte id", []byte{0xA3}, true, 0xA3, 1, false},
+		{"two byte size", []byte{0x40, 0x02}, false, 2, 2, false},
+		{"four byte id", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x00}, true, 0x1A45DFA3, 4, false},
+		{"eight byte size", []byte{0x01, 0, 0, 0, 0, 0, 0x01, 0x00}, false, 256, 8, false},
+		{"unknown one byte size", []byte{0xFF}, false, ebmlUnknownSize, 1, false},
+		{"unknown eight byte size", []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, ebmlUnknownSize, 8, false},
+		{"all ones id is not unknown", []byte{0xFF}, true, 0xFF, 1, false},
+		{"no length bit", []byte{0x00, 0x01}, false, 0, 0, true},
+		{"truncated", []byte{0x40}, false, 0, 0, true},
+		{"empty", nil, false, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, length, err := readEBMLVint(tt.data, tt.keepMarker)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if value != tt.value || length != tt.length {
+				t.Errorf("got %d (%d bytes), want %d (%d bytes)", value, length, tt.value, tt.length)
+			}
+		})
+	}
+}
+
+func TestParseWebMOpus(t *testing.T) {
+	first, second := []byte{0xF8, 1, 2}, []byte{0xF8, 3, 4}
+	tests := []struct {
+		name     string
+		data     []byte
+		packets  [][]byte
+		channels byte
+		delay    uint64
+		err      string
+	}{
+		{
+			name:     "unknown size segment and cluster",
+			data:     webmRecording(stereoOpusTrack, simpleBlock(1, 0x80, first), simpleBlock(1, 0x80, second)),
+			packets:  [][]byte{first, second},
+			channels: 2,
+			delay:    6500000,
+		},
+		{
+			name: "blocks of other tracks are skipped",
+			data: webmRecording(append(trackEntry(1, "V_VP8"), trackEntry(2, "A_OPUS")...),
+				simpleBlock(1, 0x80, []byte{0x9D, 0x01, 0x2A}), simpleBlock(2, 0x80, first)),
+			packets:  [][]byte{first},
+			channels: 1,
+		},
+		{
+			name: "block group",
+			data: webmRecording(trackEntry(1, "A_OPUS"),
+				ebmlElement(ebmlBlockGroup, ebmlElement(ebmlBlock, []byte{0x81, 0, 0, 0}, first))),
+			packets:  [][]byte{first},
+			channels: 1,
+		},
+		{
+			name: "cut off mid block",
+			data: func() []byte {
+				data := webmRecording(stereoOpusTrack, simpleBlock(1, 0x80, first), simpleBlock(1, 0x80, second))
+				return data[:len(data)-1]
+			}(),
+			packets:  [][]byte{first},
+			channels: 2,
+			delay:    6500000,
+		},
+		{
+			name: "laced block",
+			data: webmRecording(trackEntry(1, "A_OPUS"), simpleBlock(1, 0x82, first)),
+			err:  "laced",
+		},
+		{
+			name: "no opus track",
+			data: webmRecording(trackEntry(1, "A_VORBIS"), simpleBlock(1, 0x80, first)),
+			err:  "no Opus audio track",
+		},
+		{
+			name: "no packets",
+			data: webmRecording(trackEntry(1, "A_OPUS")),
+			err:  "empty",
+		},
+		{
+			name: "cut off in the tracks",
+			data: func() []byte {
+				data := ebmlUnknown(ebmlSegment, ebmlElement(ebmlTracks, trackEntry(1, "A_OPUS")))
+				return data[:len(data)-3]
+			}(),
+			err: "truncated",
+		},
+		{
+			name: "not webm",
+			data: []byte{0x00, 0x00, 0x00, 0x20, 'f', 't', 'y', 'p'},
+			err:  "invalid WebM element",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opus, err := parseWebMOpus(tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(opus.packets) != len(tt.packets) {
+				t.Fatalf("got %d packets, want %d", len(opus.packets), len(tt.packets))
+			}
+			for i := range tt.packets {
+				if !bytes.Equal(opus.packets[i], tt.packets[i]) {
+					t.Errorf("packet %d = %x, want %x", i, opus.packets[i], tt.packets[i])
+				}
+			}
+			if opus.channels != tt.channels || opus.codecDelay != tt.delay {
+				t.Errorf("channels %d, codec delay %d; want %d, %d", opus.channels, opus.codecDelay, tt.channels, tt.delay)
+			}
+		})
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		samples uint64
+	}{
+		{"silk 10 ms", []byte{0 << 3}, 480},
+		{"silk 20 ms", []byte{1 << 3}, 960},
+		{"silk 40 ms", []byte{2 << 3}, 1920},
+		{"silk 60 ms", []byte{11 << 3}, 2880},
+		{"hybrid 10 ms", []byte{12 << 3}, 480},
+		{"hybrid 20 ms", []byte{15 << 3}, 960},
+		{"celt 2.5 ms", []byte{16 << 3}, 120},
+		{"celt 5 ms", []byte{17 << 3}, 240},
+		{"celt 20 ms", []byte{31 << 3}, 960},
+		{"two equal frames", []byte{31<<3 | 1}, 1920},
+		{"two frames", []byte{31<<3 | 2}, 1920},
+		{"counted frames", []byte{16<<3 | 3, 0x83}, 360},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if samples := opusPacketSamples(tt.packet); samples != tt.samples {
+				t.Errorf("got %d samples, want %d", samples, tt.samples)
+			}
+		})
+	}
+}
+
+// oggPage is a page read back from the remuxer's output
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	packets    [][]byte
+}
+
+// readOggPages splits an Ogg stream into pages and checks each page's CRC bit by bit
+func readOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+	var pages []oggPage
+	var partial []byte
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("page %d: no Ogg page header", len(pages))
+		}
+		segments := int(data[26])
+		lacing := data[27 : 27+segments]
+		size := 27 + segments
+		for _, l := range lacing {
+			size += int(l)
+		}
+		page := append([]byte(nil), data[:size]...)
+		want := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		var crc uint32
+		for _, b := range page {
+			crc ^= uint32(b) << 24
+			for bit := 0; bit < 8; bit++ {
+				if crc&0x80000000 != 0 {
+					crc = crc<<1 ^ 0x04C11DB7
+				} else {
+					crc <<= 1
+				}
+			}
+		}
+		if crc != want {
+			t.Fatalf("page %d: CRC %08x, want %08x", len(pages), want, crc)
+		}
+
+		p := oggPage{
+			headerType: data[5],
+			granule:    binary.LittleEndian.Uint64(data[6:]),
+			serial:     binary.LittleEndian.Uint32(data[14:]),
+			sequence:   binary.LittleEndian.Uint32(data[18:]),
+		}
+		body := data[27+segments : size]
+		for _, l := range lacing {
+			partial = append(partial, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				p.packets = append(p.packets, partial)
+				partial = nil
+			}
+		}
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	if partial != nil {
+		t.Fatal("the last packet is unterminated")
+	}
+	return pages
+}
+
+func TestRemuxWebMToOgg(t *testing.T) {
+	packetsOf := func(n, size int) [][]byte {
+		packets := make([][]byte, n)
+		for i := range packets {
+			packets[i] = append([]byte{31 << 3}, bytes.Repeat([]byte{byte(i)}, size-1)...)
+		}
+		return packets
+	}
+	privateHead := append([]byte("OpusHead"), 1, 1, 0x38, 0x01, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	tests := []struct {
+		name     string
+		track    []byte
+		packets  [][]byte
+		head     []byte
+		channels byte
+		preSkip  uint16
+		pages    int
+	}{
+		{"browser recording", stereoOpusTrack, packetsOf(3, 40), nil, 2, 312, 3},
+		{"codec private", trackEntry(1, "A_OPUS", ebmlElement(ebmlCodecPrivate, privateHead)), packetsOf(2, 40), privateHead, 1, 312, 3},
+		{"packets spanning lacing values", stereoOpusTrack, append(packetsOf(1, 255), packetsOf(1, 600)...), nil, 2, 312, 3},
+		{"more packets than one page holds", stereoOpusTrack, packetsOf(300, 20), nil, 2, 312, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var blocks [][]byte
+			var samples uint64
+			for _, packet := range tt.packets {
+				blocks = append(blocks, simpleBlock(1, 0x80, packet))
+				samples += opusPacketSamples(packet)
+			}
+			out, err := remuxWebMToOgg(webmRecording(tt.track, blocks...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pages := readOggPages(t, out)
+			if len(pages) != tt.pages {
+				t.Fatalf("got %d pages, want %d", len(pages), tt.pages)
+			}
+			for i, page := range pages {
+				if page.sequence != uint32(i) || page.serial != pages[0].serial {
+					t.Errorf("page %d: sequence %d, serial %x", i, page.sequence, page.serial)
+				}
+				want := byte(0)
+				switch i {
+				case 0:
+					want = 0x02
+				case len(pages) - 1:
+					want = 0x04
+				}
+				if page.headerType != want {
+					t.Errorf("page %d: header type %x, want %x", i, page.headerType, want)
+				}
+			}
+
+			head := pages[0].packets[0]
+			if tt.head != nil && !bytes.Equal(head, tt.head) {
+				t.Errorf("OpusHead = %x, want the track's %x", head, tt.head)
+			}
+			if string(head[:8]) != "OpusHead" || head[9] != tt.channels ||
+				binary.LittleEndian.Uint16(head[10:]) != tt.preSkip || binary.LittleEndian.Uint32(head[12:]) != 48000 {
+				t.Errorf("OpusHead = %x, want %d channels and a pre-skip of %d", head, tt.channels, tt.preSkip)
+			}
+			if tags := pages[1].packets[0]; !bytes.HasPrefix(tags, []byte("OpusTags")) {
+				t.Errorf("second page starts %q, want OpusTags", tags)
+			}
+
+			var audio [][]byte
+			for _, page := range pages[2:] {
+				audio = append(audio, page.packets...)
+			}
+			if len(audio) != len(tt.packets) {
+				t.Fatalf("got %d audio packets, want %d", len(audio), len(tt.packets))
+			}
+			for i := range audio {
+				if !bytes.Equal(audio[i], tt.packets[i]) {
+					t.Errorf("audio packet %d changed", i)
+				}
+			}
+			if granule := pages[len(pages)-1].granule; granule != samples {
+				t.Errorf("final granule %d, want %d samples", granule, samples)
+			}
+		})
+	}
+}
+
+func TestRemuxWebMToOggRejectsEmptyRecording(t *testing.T) {
+	if _, err := remuxWebMToOgg(webmRecording(stereoOpusTrack)); err == nil {
+		t.Error("remuxed a recording with no audio")
+	}
+	if _, err := remuxWebMToOgg(nil); err == nil {
+		t.Error("remuxed an empty upload")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// standardWarmUpProfile ramps a new number up to 500 messages a day over four weeks
+const standardWarmUpProfile = "3:20,7:50,14:100,21:250,28:500"
+
+// WarmUpStage limits daily sends until the end of a day of the warm-up
+type WarmUpStage struct {
+	UntilDay   int
+	DailyLimit int
+}
+
+// WarmUpStatus is the warm-up section of /api/v1/usage
+type WarmUpStatus struct {
+	Account    string    `json:"account"`
+	StartedAt  time.Time `json:"started_at"`
+	Day        int       `json:"day"`
+	DailyLimit int       `json:"daily_limit"`
+	SentToday  int       `json:"sent_today"`
+	Remaining  int       `json:"remaining"`
+	EndsAt     time.Time `json:"ends_at"`
+}
+
+// WarmUp caps the daily send volume of a newly linked number and raises the cap in stages,
+// as a sudden burst from a new number is a common reason for bans
+type WarmUp struct {
+	stages       []WarmUpStage
+	startedAt    time.Time
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+	mutex        sync.Mutex
+}
+
+// warmUp is nil unless WARMUP_PROFILE is set
+var warmUp *WarmUp
+
+// NewWarmUpFromEnv reads WARMUP_PROFILE and WARMUP_STARTED_AT; it returns nil when warm-up is off
+func NewWarmUpFromEnv(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) (*WarmUp, error) {
+	profile := strings.TrimSpace(os.Getenv("WARMUP_PROFILE"))
+	switch profile {
+	case "", "off":
+		return nil, nil
+	case "standard":
+		profile = standardWarmUpProfile
+	}
+
+	stages, err := parseWarmUpProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WARMUP_PROFILE: %v", err)
+	}
+	w := &WarmUp{stages: stages, client: client, messageStore: messageStore, logger: logger}
+
+	// Numbers that were already in use before the bridge can start part-way
+	if value := os.Getenv("WARMUP_STARTED_AT"); value != "" {
+		if w.startedAt, err = time.Parse("2006-01-02", value); err != nil {
+			return nil, fmt.Errorf("WARMUP_STARTED_AT must be a date such as 2025-01-02")
+		}
+	}
+	return w, nil
+}
+
+// parseWarmUpProfile parses stages written as until_day:daily_limit, e.g. "3:20,7:50"
+func parseWarmUpProfile(profile string) ([]WarmUpStage, error) {
+	var stages []WarmUpStage
+	for _, entry := range strings.Split(profile, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("stage %q must be until_day:daily_limit", entry)
+		}
+		day, err := strconv.Atoi(parts[0])
+		if err != nil || day < 1 {
+			return nil, fmt.Errorf("stage %q has an invalid day", entry)
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("stage %q has an invalid limit", entry)
+		}
+		if len(stages) > 0 && day <= stages[len(stages)-1].UntilDay {
+			return nil, fmt.Errorf("stage days must increase")
+		}
+		stages = append(stages, WarmUpStage{UntilDay: day, DailyLimit: limit})
+	}
+	return stages, nil
+}
+
+// warmUpDay returns the UTC date a time falls on
+func warmUpDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// account returns the linked number, or "" before pairing
+func (w *WarmUp) account() string {
+	if w.client.Store.ID == nil {
+		return ""
+	}
+	return w.client.Store.ID.User
+}
+
+// Begin starts the warm-up clock of the linked number, unless it has already started
+func (w *WarmUp) Begin() {
+	if w == nil {
+		return
+	}
+	if account := w.account(); account != "" {
+		if _, err := w.messageStore.WarmUpStart(account, time.Now()); err != nil {
+			w.logger.Warnf("Failed to record warm-up start: %v", err)
+		}
+	}
+}
+
+// status returns the warm-up state of the linked number, or nil once its warm-up is over
+func (w *WarmUp) status(now time.Time) (*WarmUpStatus, error) {
+	account := w.account()
+	if account == "" {
+		return nil, nil
+	}
+	startedAt := w.startedAt
+	if startedAt.IsZero() {
+		var err error
+		if startedAt, err = w.messageStore.WarmUpStart(account, now); err != nil {
+			return nil, err
+		}
+	}
+
+	start, today := warmUpDay(startedAt), warmUpDay(now)
+	day := int(today.Sub(start).Hours()/24) + 1
+	status := &WarmUpStatus{
+		Account:   account,
+		StartedAt: startedAt.UTC(),
+		Day:       day,
+		EndsAt:    start.AddDate(0, 0, w.stages[len(w.stages)-1].UntilDay),
+	}
+	for _, stage := range w.stages {
+		if day <= stage.UntilDay {
+			status.DailyLimit = stage.DailyLimit
+			break
+		}
+	}
+	if status.DailyLimit == 0 {
+		return nil, nil
+	}
+
+	sent, err := w.messageStore.GetWarmUpSends(account, today.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	status.SentToday = sent
+	status.Remaining = max(status.DailyLimit-sent, 0)
+	return status, nil
+}
+
+// limitMessage explains a rejected send
+func (s *WarmUpStatus) limitMessage() string {
+	return fmt.Sprintf("Daily warm-up limit of %d messages reached (day %d of the warm-up)", s.DailyLimit, s.Day)
+}
+
+// Status returns the warm-up state for the usage API, or nil when warm-up is off or over
+func (w *WarmUp) Status() (*WarmUpStatus, error) {
+	if w == nil {
+		return nil, nil
+	}
+	return w.status(time.Now())
+}
+
+// Allow checks the daily limit before a send request, returning a message for the 429 response
+func (w *WarmUp) Allow() (bool, string) {
+	status, err := w.Status()
+	if err != nil {
+		// Don't block traffic because the counters can't be read
+		w.logger.Warnf("Failed to check warm-up limit: %v", err)
+		return true, ""
+	}
+	if status != nil && status.Remaining == 0 {
+		return false, status.limitMessage()
+	}
+	return true, ""
+}
+
+// Reserve counts a message against today's limit before it is sent. Every send path goes
+// through it, so auto-replies and flows are limited as well as API calls.
+func (w *WarmUp) Reserve() (bool, string) {
+	if w == nil {
+		return true, ""
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	status, err := w.status(now)
+	if err != nil {
+		w.logger.Warnf("Failed to check warm-up limit: %v", err)
+		return true, ""
+	}
+	if status == nil {
+		return true, ""
+	}
+	if status.Remaining == 0 {
+		return false, status.limitMessage()
+	}
+	if err := w.messageStore.AddWarmUpSends(status.Account, warmUpDay(now).Format("2006-01-02"), 1); err != nil {
+		w.logger.Warnf("Failed to count warm-up send: %v", err)
+	}
+	return true, ""
+}
+
+// Release returns a reserved message that failed to send
+func (w *WarmUp) Release() {
+	if w == nil {
+		return
+	}
+	if account := w.account(); account != "" {
+		if err := w.messageStore.AddWarmUpSends(account, warmUpDay(time.Now()).Format("2006-01-02"), -1); err != nil {
+			w.logger.Warnf("Failed to release warm-up send: %v", err)
+		}
+	}
+}
+
+// warmUpRetryAfter returns the seconds until the daily limits reset at midnight UTC
+func warmUpRetryAfter() string {
+	now := time.Now()
+	return strconv.Itoa(int(warmUpDay(now).AddDate(0, 0, 1).Sub(now).Seconds()) + 1)
+}
+
+// limitedByWarmUp answers 429 once today's warm-up limit is used up
+func limitedByWarmUp(w http.ResponseWriter) bool {
+	if warmUp == nil {
+		return false
+	}
+	ok, message := warmUp.Allow()
+	if !ok {
+		w.Header().Set("Retry-After", warmUpRetryAfter())
+		http.Error(w, message, http.StatusTooManyRequests)
+	}
+	return !ok
+}
+
+// WarmUpStart returns when the warm-up of an account started, recording the given time if it hasn't
+func (store *MessageStore) WarmUpStart(account string, now time.Time) (time.Time, error) {
+	insert := "INSERT INTO warmup_accounts (account, started_at) VALUES (?, ?) ON CONFLICT (account) DO NOTHING"
+	query := "SELECT started_at FROM warmup_accounts WHERE account = ?"
+	if store.isPostgres {
+		insert = "INSERT INTO warmup_accounts (account, started_at) VALUES ($1, $2) ON CONFLICT (account) DO NOTHING"
+		query = "SELECT started_at FROM warmup_accounts WHERE account = $1"
+	}
+	if _, err := store.db.Exec(insert, account, now.UTC()); err != nil {
+		return time.Time{}, err
+	}
+	var startedAt time.Time
+	err := store.db.QueryRow(query, account).Scan(&startedAt)
+	return startedAt, err
+}
+
+// AddWarmUpSends adds to the number of messages an account sent on a day
+func (store *MessageStore) AddWarmUpSends(account, day string, amount int) error {
+	query := `INSERT INTO warmup_sends (account, day, sent) VALUES (?, ?, ?)
+		ON CONFLICT (account, day) DO UPDATE SET sent = warmup_sends.sent + excluded.sent`
+	if store.isPostgres {
+		query = `INSERT INTO warmup_sends (account, day, sent) VALUES ($1, $2, $3)
+		ON CONFLICT (account, day) DO UPDATE SET sent = warmup_sends.sent + EXCLUDED.sent`
+	}
+	_, err := store.db.Exec(query, account, day, amount)
+	return err
+}
+
+// GetWarmUpSends returns the number of messages an account sent on a day
+func (store *MessageStore) GetWarmUpSends(account, day string) (int, error) {
+	query := "SELECT sent FROM warmup_sends WHERE account = ? AND day = ?"
+	if store.isPostgres {
+		query = "SELECT sent FROM warmup_sends WHERE account = $1 AND day = $2"
+	}
+	var sent int
+	err := store.db.QueryRow(query, account, day).Scan(&sent)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return sent, err
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms the bridge accepts for passkeys, in order of preference
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttested     = 0x40
+)
+
+// maxCBORDepth bounds nesting, so a crafted attestation can't exhaust the stack
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("truncated CBOR")
+
+// decodeCBOR decodes one definite-length CBOR item, as authenticators encode them, and returns
+// it with the number of bytes it took. Integers decode to int64 and maps to
+// map[interface{}]interface{}, keyed by int64 or string.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, int, error) {
+	if depth > maxCBORDepth {
+		return nil, 0, errors.New("CBOR nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, 0, errCBORTruncated
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+
+	// Major type 7 holds simple values, where the additional info is the value itself
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		}
+		return nil, 0, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+
+	var arg uint64
+	n := 1
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < 1+size {
+			return nil, 0, errCBORTruncated
+		}
+		for _, b := range data[1 : 1+size] {
+			arg = arg<<8 | uint64(b)
+		}
+		n += size
+	default:
+		return nil, 0, errors.New("indefinite-length CBOR is not supported")
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("CBOR integer out of range")
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("CBOR integer out of range")
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, errCBORTruncated
+		}
+		value := data[n : n+int(arg)]
+		if major == 3 {
+			return string(value), n + int(arg), nil
+		}
+		return append([]byte(nil), value...), n + int(arg), nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, 0, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, size, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += size
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, 0, errCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, size, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += size
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("unsupported CBOR map key")
+			}
+			value, size, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items[key] = value
+			n += size
+		}
+		return items, n, nil
+	default:
+		// A tag; its meaning doesn't matter here, only the item it wraps
+		item, size, err := decodeCBORItem(data[n:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return item, n + size, nil
+	}
+}
+
+// authenticatorData is the part of a WebAuthn response the authenticator signs
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	// publicKey is the COSE key of a new credential, only present on registration
+	publicKey []byte
+}
+
+// parseAuthenticatorData splits authenticator data into its fields
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+	auth := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if auth.flags&authFlagAttested == 0 {
+		return auth, nil
+	}
+
+	// Attested credential data: AAGUID, credential ID length, credential ID, COSE key
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("credential ID is truncated")
+	}
+	auth.credentialID = rest[:idLength]
+	_, keyLength, err := decodeCBOR(rest[idLength:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	auth.publicKey = rest[idLength : idLength+keyLength]
+	return auth, nil
+}
+
+// parseCOSEKey reads a COSE public key into its algorithm and a crypto public key
+func parseCOSEKey(data []byte) (int64, crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, errors.New("COSE key is not a map")
+	}
+	alg, _ := key[int64(3)].(int64)
+	param := func(label int64) []byte {
+		value, _ := key[label].([]byte)
+		return value
+	}
+
+	switch alg {
+	case coseES256:
+		x, y := param(-2), param(-3)
+		if crv, _ := key[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("ES256 key is not on P-256")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return 0, nil, errors.New("ES256 key is not on P-256")
+		}
+		return alg, public, nil
+	case coseEdDSA:
+		x := param(-2)
+		if crv, _ := key[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("EdDSA key is not Ed25519")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case coseRS256:
+		n, e := param(-1), param(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, errors.New("RS256 key is invalid or shorter than 2048 bits")
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported COSE algorithm %d", alg)
+}
+
+// verifyCOSESignature checks a signature made with a passkey's private key
+func verifyCOSESignature(coseKey, signed, signature []byte) error {
+	alg, public, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch alg {
+	case coseES256:
+		valid = ecdsa.VerifyASN1(public.(*ecdsa.PublicKey), digest[:], signature)
+	case coseEdDSA:
+		valid = ed25519.Verify(public.(ed25519.PublicKey), signed, signature)
+	case coseRS256:
+		valid = rsa.VerifyPKCS1v15(public.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// clientData is what the browser says it asked the authenticator to sign
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData checks that a response answers our challenge, from one of our origins
+func verifyClientData(raw []byte, ceremony string, challenge []byte, origins []string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("invalid client data: %v", err)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("client data is for %q, not %q", data.Type, ceremony)
+	}
+	received, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || !bytes.Equal(received, challenge) {
+		return errors.New("challenge does not match")
+	}
+	for _, origin := range origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", data.Origin)
+}
+
+// verifyAuthenticatorData checks the relying party and that the user was present
+func verifyAuthenticatorData(auth *authenticatorData, rpID string) error {
+	hash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(auth.rpIDHash, hash[:]) {
+		return fmt.Errorf("credential is not for %s", rpID)
+	}
+	if auth.flags&authFlagUserPresent == 0 {
+		return errors.New("user was not present")
+	}
+	return nil
+}
+
+// parseAttestation reads the authenticator data out of a registration's attestation object.
+// Passkeys are registered with attestation "none", so the attestation statement isn't checked.
+func parseAttestation(attestationObject []byte) (*authenticatorData, error) {
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	raw, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+	auth, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if auth.credentialID == nil {
+		return nil, errors.New("attestation has no credential")
+	}
+	if _, _, err := parseCOSEKey(auth.publicKey); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cborHead encodes a major type and argument in the shortest form, as authenticators do
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xFF:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xFFFF:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xFFFFFFFF:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+func cborInt(v int64) []byte {
+	if v < 0 {
+		return cborHead(1, uint64(-1-v))
+	}
+	return cborHead(0, uint64(v))
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, uint64(len(b))), b...) }
+
+func cborText(s string) []byte { return append(cborHead(3, uint64(len(s))), s...) }
+
+// cborMap encodes alternating keys and values
+func cborMap(pairs ...[]byte) []byte {
+	return append(cborHead(5, uint64(len(pairs)/2)), bytes.Join(pairs, nil)...)
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// The examples are from RFC 8949 appendix A
+	tests := []struct {
+		name  string
+		hex   string
+		value interface{}
+		size  int
+		err   string
+	}{
+		{"small uint", "17", int64(23), 1, ""},
+		{"one byte uint", "1818", int64(24), 2, ""},
+		{"two byte uint", "1903e8", int64(1000), 3, ""},
+		{"eight byte uint", "1b000000e8d4a51000", int64(1000000000000), 9, ""},
+		{"negative", "20", int64(-1), 1, ""},
+		{"one byte negative", "3863", int64(-100), 2, ""},
+		{"uint out of range", "1bffffffffffffffff", nil, 0, "out of range"},
+		{"negative out of range", "3bffffffffffffffff", nil, 0, "out of range"},
+		{"bytes", "4401020304", []byte{1, 2, 3, 4}, 5, ""},
+		{"text", "6449455446", "IETF", 5, ""},
+		{"array", "83010203", []interface{}{int64(1), int64(2), int64(3)}, 4, ""},
+		{"int map", "a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}, 5, ""},
+		{"text map", "a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}, 9, ""},
+		{"tag", "c11a514b67b0", int64(1363896240), 6, ""},
+		{"false", "f4", false, 1, ""},
+		{"true", "f5", true, 1, ""},
+		{"null", "f6", nil, 1, ""},
+		{"trailing bytes", "0001", int64(0), 1, ""},
+		{"float", "f93c00", nil, 0, "unsupported CBOR simple value"},
+		{"indefinite array", "9f01ff", nil, 0, "indefinite-length"},
+		{"array map key", "a18001", nil, 0, "map key"},
+		{"truncated argument", "1903", nil, 0, "truncated"},
+		{"truncated bytes", "4401", nil, 0, "truncated"},
+		{"truncated array", "8201", nil, 0, "truncated"},
+		{"truncated map", "a101", nil, 0, "truncated"},
+		{"huge array", "9b00000000ffffffff", nil, 0, "truncated"},
+		{"huge bytes", "5bffffffffffffffff", nil, 0, "truncated"},
+		{"empty", "", nil, 0, "truncated"},
+		{"nested to the limit", strings.Repeat("81", maxCBORDepth) + "00", nested(maxCBORDepth), maxCBORDepth + 1, ""},
+		{"nested too deeply", strings.Repeat("81", maxCBORDepth+1) + "00", nil, 0, "too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, size, err := decodeCBOR(mustHex(t, tt.hex))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, tt.value) || size != tt.size {
+				t.Errorf("got %#v (%d bytes), want %#v (%d bytes)", value, size, tt.value, tt.size)
+			}
+		})
+	}
+}
+
+// nested is zero wrapped in depth one-item arrays
+func nested(depth int) interface{} {
+	var value interface{} = int64(0)
+	for i := 0; i < depth; i++ {
+		value = []interface{}{value}
+	}
+	return value
+}
+
+// passkey is a credential key pair with its COSE public key
+type passkey struct {
+	cose []byte
+	sign func(signed []byte) []byte
+}
+
+func es256Passkey(t *testing.T) passkey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xy := point.Bytes()[1:]
+	return passkey{
+		cose: cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(coseES256),
+			cborInt(-1), cborInt(1), cborInt(-2), cborBytes(xy[:32]), cborInt(-3), cborBytes(xy[32:])),
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		},
+	}
+}
+
+func ed25519Passkey(t *testing.T) passkey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return passkey{
+		cose: cborMap(cborInt(1), cborInt(1), cborInt(3), cborInt(coseEdDSA), cborInt(-1), cborInt(6), cborInt(-2), cborBytes(public)),
+		sign: func(signed []byte) []byte { return ed25519.Sign(private, signed) },
+	}
+}
+
+func rs256Passkey(t *testing.T, bits int) passkey {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return passkey{
+		cose: cborMap(cborInt(1), cborInt(3), cborInt(3), cborInt(coseRS256),
+			cborInt(-1), cborBytes(key.N.Bytes()), cborInt(-2), cborBytes(rsaExponent(key.E))),
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		},
+	}
+}
+
+// rsaExponent encodes an exponent in three bytes, as authenticators send 65537
+func rsaExponent(e int) []byte {
+	return []byte{byte(e >> 16), byte(e >> 8), byte(e)}
+}
+
+func TestVerifyCOSESignature(t *testing.T) {
+	signed := []byte("authenticator data and client data hash")
+	keys := map[string]passkey{
+		"ES256": es256Passkey(t),
+		"EdDSA": ed25519Passkey(t),
+		"RS256": rs256Passkey(t, 2048),
+	}
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			signature := key.sign(signed)
+			if err := verifyCOSESignature(key.cose, signed, signature); err != nil {
+				t.Errorf("valid signature rejected: %v", err)
+			}
+			if err := verifyCOSESignature(key.cose, append(signed, '!'), signature); err == nil {
+				t.Error("signature accepted for other data")
+			}
+			other := keys["EdDSA"]
+			if name == "EdDSA" {
+				other = keys["ES256"]
+			}
+			if err := verifyCOSESignature(other.cose, signed, signature); err == nil {
+				t.Error("signature accepted with another key")
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejects(t *testing.T) {
+	ed := ed25519Passkey(t)
+	decoded, _, _ := decodeCBOR(es256Passkey(t).cose)
+	es := decoded.(map[interface{}]interface{})
+	x, y := es[int64(-2)].([]byte), es[int64(-3)].([]byte)
+	offCurve := append([]byte(nil), y...)
+	offCurve[31] ^= 1
+
+	tests := []struct {
+		name string
+		key  []byte
+		err  string
+	}{
+		{"not a map", cborBytes(ed.cose), "not a map"},
+		{"unsupported algorithm", cborMap(cborInt(1), cborInt(2), cborInt(3), cborInt(-35)), "unsupported COSE algorithm -35"},
+		{"no algorithm", cborMap(cborInt(1), cborInt(2)), "unsupported COSE algorithm 0"},
+		{"ES256 on P-384", cborMap(cborInt(3), cborInt(coseES256), cborInt(-1), cborInt(2), cborInt(-2), cborBytes(x), cborInt(-3), cborBytes(y)), "P-256"},
+		{"ES256 off the curve", cborMap(cborInt(3), cborInt(coseES256), cborInt(-1), cborInt(1), cborInt(-2), cborBytes(x), cborInt(-3), cborBytes(offCurve)), "P-256"},
+		{"ES256 short coordinate", cborMap(cborInt(3), cborInt(coseES256), cborInt(-1), cborInt(1), cborInt(-2), cborBytes(x[1:]), cborInt(-3), cborBytes(y)), "P-256"},
+		{"EdDSA on Ed448", cborMap(cborInt(3), cborInt(coseEdDSA), cborInt(-1), cborInt(7), cborInt(-2), cborBytes(make([]byte, 32))), "Ed25519"},
+		{"RS256 1024 bits", rs256Passkey(t, 1024).cose, "2048 bits"},
+		{"RS256 no exponent", cborMap(cborInt(3), cborInt(coseRS256), cborInt(-1), cborBytes(make([]byte, 256))), "2048 bits"},
+		{"truncated", ed.cose[:len(ed.cose)-1], "truncated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseCOSEKey(tt.key); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// authData builds authenticator data; a credential is attested when id is not nil
+func authData(rpID string, flags byte, id, cose []byte) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := binary.BigEndian.AppendUint32(append(hash[:], flags), 7)
+	if id != nil {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(id)))
+		data = append(append(data, id...), cose...)
+	}
+	return data
+}
+
+func TestParseAttestation(t *testing.T) {
+	key := ed25519Passkey(t)
+	id := []byte("credential-id")
+	attestation := func(auth []byte) []byte {
+		return cborMap(cborText("fmt"), cborText("none"), cborText("attStmt"), cborMap(), cborText("authData"), cborBytes(auth))
+	}
+	// Extensions follow the key when the ED flag is set; they aren't part of it
+	extensions := cborMap(cborText("credProtect"), cborInt(2))
+
+	tests := []struct {
+		name   string
+		object []byte
+		err    string
+	}{
+		{"none attestation", attestation(authData("example.com", 0x45, id, key.cose)), ""},
+		{"with extensions", attestation(authData("example.com", 0xC5, id, append(append([]byte(nil), key.cose...), extensions...))), ""},
+		{"no credential", attestation(authData("example.com", 0x05, nil, nil)), "no credential"},
+		{"credential ID cut off", attestation(authData("example.com", 0x45, id, nil)[:37+18+4]), "credential ID is truncated"},
+		{"attested data cut off", attestation(authData("example.com", 0x45, id, key.cose)[:37+10]), "too short"},
+		{"key cut off", attestation(authData("example.com", 0x45, id, key.cose[:len(key.cose)-4])), "invalid credential public key"},
+		{"unsupported key", attestation(authData("example.com", 0x45, id, cborMap(cborInt(3), cborInt(-35)))), "unsupported COSE algorithm"},
+		{"short authenticator data", attestation(make([]byte, 36)), "too short"},
+		{"no authenticator data", cborMap(cborText("fmt"), cborText("none")), "no authenticator data"},
+		{"not a map", cborBytes(authData("example.com", 0x45, id, key.cose)), "not a map"},
+		{"not CBOR", []byte{0xff}, "invalid attestation object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := parseAttestation(tt.object)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(auth.credentialID, id) || !bytes.Equal(auth.publicKey, key.cose) || auth.signCount != 7 {
+				t.Errorf("credential %q, key %x, sign count %d", auth.credentialID, auth.publicKey, auth.signCount)
+			}
+			if err := verifyAuthenticatorData(auth, "example.com"); err != nil {
+				t.Errorf("authenticator data rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAuthenticatorData(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"present", authData("example.com", authFlagUserPresent, nil, nil), ""},
+		{"present and verified", authData("example.com", authFlagUserPresent|authFlagUserVerified, nil, nil), ""},
+		{"other relying party", authData("example.org", authFlagUserPresent, nil, nil), "not for example.com"},
+		{"user not present", authData("example.com", authFlagUserVerified, nil, nil), "not present"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := parseAuthenticatorData(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifyAuthenticatorData(auth, "example.com")
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyClientData(t *testing.T) {
+	challenge := []byte("0123456789abcdef")
+	encoded := base64.RawURLEncoding.EncodeToString(challenge)
+	origins := []string{"https://bridge.example.com", "http://localhost:8080"}
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{"login", `{"type":"webauthn.get","challenge":"` + encoded + `","origin":"https://bridge.example.com"}`, ""},
+		{"second origin", `{"type":"webauthn.get","challenge":"` + encoded + `","origin":"http://localhost:8080","crossOrigin":false}`, ""},
+		{"registration response", `{"type":"webauthn.create","challenge":"` + encoded + `","origin":"https://bridge.example.com"}`, "not \"webauthn.get\""},
+		{"other challenge", `{"type":"webauthn.get","challenge":"` + base64.RawURLEncoding.EncodeToString([]byte("other")) + `","origin":"https://bridge.example.com"}`, "challenge does not match"},
+		{"padded challenge", `{"type":"webauthn.get","challenge":"` + base64.URLEncoding.EncodeToString(challenge) + `","origin":"https://bridge.example.com"}`, "challenge does not match"},
+		{"other origin", `{"type":"webauthn.get","challenge":"` + encoded + `","origin":"https://evil.example.com"}`, "not allowed"},
+		{"not JSON", `webauthn.get`, "invalid client data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyClientData([]byte(tt.raw), "webauthn.get", challenge, origins)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// webhookAttempts is how often a delivery is tried before the event is dropped
+const webhookAttempts = 4
+
+// WebhookSink posts bridge events to the URLs in WEBHOOK_URL
+type WebhookSink struct {
+	urls   []string
+	secret string
+	events map[string]bool
+	queue  chan BridgeEvent
+	client *http.Client
+	logger waLog.Logger
+}
+
+// NewWebhookSinkFromEnv returns nil when WEBHOOK_URL is not set
+func NewWebhookSinkFromEnv(logger waLog.Logger) (*WebhookSink, error) {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("WEBHOOK_URL"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				return nil, fmt.Errorf("invalid WEBHOOK_URL %q", url)
+			}
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	sink := newWebhookSink(urls, logger)
+
+	// Only deliver the listed event types, or all of them
+	if filter := os.Getenv("WEBHOOK_EVENTS"); filter != "" {
+		sink.events = make(map[string]bool)
+		for _, eventType := range strings.Split(filter, ",") {
+			sink.events[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	return sink, nil
+}
+
+// newWebhookSink creates a sink for the given URLs, signed with WEBHOOK_SECRET
+func newWebhookSink(urls []string, logger waLog.Logger) *WebhookSink {
+	return &WebhookSink{
+		urls:   urls,
+		secret: os.Getenv("WEBHOOK_SECRET"),
+		queue:  make(chan BridgeEvent, getEnvInt("WEBHOOK_QUEUE_SIZE", 1000)),
+		client: &http.Client{Timeout: time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second},
+		logger: logger,
+	}
+}
+
+// Start subscribes to the event bus and delivers events in the background, in order
+func (s *WebhookSink) Start() {
+	eventBus.Subscribe(func(evt BridgeEvent) {
+		if s.events != nil && !s.events[evt.Type] {
+			return
+		}
+		s.Enqueue(evt)
+	})
+	s.run()
+}
+
+// Enqueue queues an event for delivery, dropping it if the queue is full
+func (s *WebhookSink) Enqueue(evt BridgeEvent) {
+	select {
+	case s.queue <- evt:
+	default:
+		s.logger.Warnf("Webhook queue full, dropping %s event", evt.Type)
+	}
+}
+
+// run delivers queued events in the background, in order
+func (s *WebhookSink) run() {
+	go func() {
+		for evt := range s.queue {
+			s.deliver(evt)
+		}
+	}()
+}
+
+// deliver posts an event to every URL, retrying with backoff
+func (s *WebhookSink) deliver(evt BridgeEvent) {
+	body, err := json.Marshal(redactEvent(evt, webhookRedactor))
+	if err != nil {
+		s.logger.Errorf("Failed to encode %s webhook: %v", evt.Type, err)
+		return
+	}
+
+	for _, url := range s.urls {
+		var lastErr error
+		for attempt := 0; attempt < webhookAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<attempt) * time.Second)
+			}
+			if lastErr = s.post(url, evt.Type, body); lastErr == nil {
+				break
+			}
+		}
+		if lastErr != nil {
+			s.logger.Warnf("Giving up on %s webhook to %s: %v", evt.Type, url, lastErr)
+		}
+	}
+}
+
+// post sends one webhook request, signed with WEBHOOK_SECRET when set
+func (s *WebhookSink) post(url, eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bridge-Event", eventType)
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-Bridge-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
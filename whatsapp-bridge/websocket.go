@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow"
+)
+
+// EventConnectionState is the first frame of every WebSocket, describing the connection as it is now.
+// It isn't published on the event bus.
+const EventConnectionState = "connection.state"
+
+// webSocketEvents are streamed by /api/v1/ws when no ?types= is given: what a live inbox needs
+var webSocketEvents = []string{
+	EventMessageReceived, EventMessageSent, EventMessageDelivered, EventMessageRead, EventMessageStatus, EventChatHandoff,
+	EventConnectionConnected, EventConnectionDisconnected, EventConnectionLoggedOut,
+}
+
+// webSocketPongWait is how long a client may take to answer a ping before the socket is closed
+const webSocketPongWait = 2 * eventStreamKeepAlive
+
+// webSocketWriteWait bounds a single frame write, so a stalled client can't block its stream
+const webSocketWriteWait = 10 * time.Second
+
+// webSocketUpgrader leaves CheckOrigin unset, so browsers may only open sockets from pages served by the
+// bridge's own host (as forwarded by a trusted proxy). Cookies ride along on WebSocket handshakes, so
+// accepting any origin would let other sites stream events with the dashboard's key.
+var webSocketUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// registerWebSocketRoutes registers /api/v1/ws, which streams bridge events as JSON text frames.
+// ?types=a,b picks the event types, and ?types=* streams all of them.
+func registerWebSocketRoutes(client *whatsmeow.Client) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !websocket.IsWebSocketUpgrade(r) {
+			http.Error(w, "Expected a WebSocket upgrade", http.StatusUpgradeRequired)
+			return
+		}
+
+		filter := make(map[string]bool)
+		switch types := r.URL.Query().Get("types"); types {
+		case "":
+			for _, eventType := range webSocketEvents {
+				filter[eventType] = true
+			}
+		case "*":
+			filter = nil
+		default:
+			for _, eventType := range strings.Split(types, ",") {
+				filter[strings.TrimSpace(eventType)] = true
+			}
+		}
+
+		// The socket stays open for as long as the client listens
+		clearDeadlines(w)
+		conn, err := webSocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already answered with an error
+			return
+		}
+		defer conn.Close()
+
+		// Slow clients lose events rather than holding up the publisher
+		stream := make(chan BridgeEvent, 100)
+		unsubscribe := eventBus.Subscribe(func(evt BridgeEvent) {
+			if filter != nil && !filter[evt.Type] {
+				return
+			}
+			select {
+			case stream <- evt:
+			default:
+			}
+		})
+		defer unsubscribe()
+
+		// Clients don't send anything, but reading handles pongs and notices when they go away
+		closed := make(chan struct{})
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(webSocketPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(webSocketPongWait))
+		})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		state := BridgeEvent{
+			ID:        newEventID(),
+			Type:      EventConnectionState,
+			Timestamp: time.Now().UTC(),
+			Data: map[string]interface{}{
+				"connected":    client.IsConnected(),
+				"logged_in":    client.Store.ID != nil,
+				"read_only":    readOnlyMode,
+				"receive_only": receiveOnlyMode,
+			},
+		}
+		conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait))
+		if err := conn.WriteJSON(state); err != nil {
+			return
+		}
+
+		ping := time.NewTicker(eventStreamKeepAlive)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteWait)); err != nil {
+					return
+				}
+			case evt := <-stream:
+				conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait))
+				if err := conn.WriteJSON(evt); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	// Only under /api/, where the API key and tenant middleware apply
+	http.HandleFunc(apiV1Prefix+"/ws", handler)
+}
@@ -8,14 +8,14 @@ import (
 var isMainAppLive bool
 
 // StartWrapper starts the wrapper health check service
-func StartWrapper() {
+func StartWrapper(localURL string) {
 	// Start monitoring the main application's health
-	go monitorMainAppHealth()
+	go monitorMainAppHealth(localURL)
 }
 
-func monitorMainAppHealth() {
+func monitorMainAppHealth(localURL string) {
 	for {
-		resp, err := http.Get("http://localhost:8080/api/health")
+		resp, err := http.Get(localURL + "/api/v1/health")
 		if err != nil || resp.StatusCode != http.StatusOK {
 			isMainAppLive = false
 		} else {